@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The unversioned /api paths predate /api/v1 and serve the same handlers.
+// They announce their retirement with Deprecation (RFC 9745) and Sunset
+// (RFC 8594) headers.
+var (
+	legacyAPIDeprecated = time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	legacyAPISunset     = time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type apiVersionKey struct{}
+
+// apiVersionFrom returns the API version of the route group serving the
+// request, or "" outside the versioned API.
+func apiVersionFrom(ctx context.Context) string {
+	v, _ := ctx.Value(apiVersionKey{}).(string)
+	return v
+}
+
+// routeGroup registers routes under a shared path prefix and API version,
+// applying the same middleware to each. Adding an API version means creating
+// a group, not repeating prefixes.
+type routeGroup struct {
+	routes     *documentedMux
+	prefix     string
+	version    string
+	middleware []Middleware
+	successor  string
+	timeout    *time.Duration
+}
+
+// Group starts a route group under prefix whose routes are wrapped in
+// middleware, chained with the first outermost.
+func (d *documentedMux) Group(prefix, version string, middleware ...Middleware) *routeGroup {
+	return &routeGroup{routes: d, prefix: prefix, version: version, middleware: middleware}
+}
+
+// Deprecate marks every route in the group as deprecated in favor of the
+// same path under successor: responses carry Deprecation, Sunset, and a
+// successor-version Link, each request logs a warning, and the spec flags
+// the operations.
+func (g *routeGroup) Deprecate(successor string) *routeGroup {
+	g.successor = successor
+	return g
+}
+
+// Timeout overrides the request timeout for routes registered on the group
+// from now on; zero disables it.
+func (g *routeGroup) Timeout(timeout time.Duration) *routeGroup {
+	g.timeout = &timeout
+	return g
+}
+
+// Route registers h for pattern relative to the group prefix; "" is the
+// prefix itself and "POST /items" becomes "POST <prefix>/items". Requests are
+// instrumented under the full path.
+func (g *routeGroup) Route(pattern string, h http.HandlerFunc, ops ...Operation) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	full := g.prefix + path
+	if method != "" {
+		full = method + " " + full
+	}
+	_, label := splitPattern(full)
+	if g.timeout != nil {
+		g.routes.Timeout(label, *g.timeout)
+	}
+
+	h = withAPIVersion(Chain(h, g.middleware...).ServeHTTP, g.version)
+	if g.successor != "" {
+		h = deprecated(h, g.successor+path)
+		for i := range ops {
+			ops[i].Deprecated = true
+		}
+	}
+	g.routes.Route(full, instrument(label, h), ops...)
+}
+
+// withAPIVersion tags the request context with version.
+func withAPIVersion(next http.HandlerFunc, version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	}
+}
+
+// deprecated adds the legacy API headers pointing clients at successor and
+// logs each use so remaining callers can be found before the sunset.
+func deprecated(next http.HandlerFunc, successor string) http.HandlerFunc {
+	deprecation := "@" + strconv.FormatInt(legacyAPIDeprecated.Unix(), 10)
+	sunset := legacyAPISunset.Format(http.TimeFormat)
+	link := "<" + successor + `>; rel="successor-version"`
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation)
+		w.Header().Set("Sunset", sunset)
+		w.Header().Add("Link", link)
+		loggerFrom(r.Context()).Warn("deprecated API path called",
+			slog.String("method", r.Method),
+			slog.String("successor", successor),
+			slog.String("user_agent", r.UserAgent()),
+		)
+		next(w, r)
+	}
+}
+
+// Envelope is the /api/v2 response shape: the payload under data and
+// request metadata alongside it.
+type Envelope struct {
+	Data any          `json:"data"`
+	Meta EnvelopeMeta `json:"meta"`
+}
+
+type EnvelopeMeta struct {
+	APIVersion string    `json:"api_version"`
+	Timestamp  time.Time `json:"timestamp"`
+	RequestID  string    `json:"request_id"`
+}
+
+// envelope wraps data for a v2 response.
+func (s *Server) envelope(r *http.Request, data any) Envelope {
+	return Envelope{
+		Data: data,
+		Meta: EnvelopeMeta{
+			APIVersion: apiVersionFrom(r.Context()),
+			Timestamp:  s.clock.Now(),
+			RequestID:  requestIDFrom(r.Context()),
+		},
+	}
+}
+
+// apiV2Handler is the /api/v2 variant of apiHandler: the same test message
+// and JSON echo, wrapped in an Envelope.
+func (s *Server) apiV2Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeResponse(w, r, http.StatusOK, s.envelope(r, map[string]string{"message": "API endpoint is working"}))
+	case http.MethodPost:
+		var data map[string]any
+		if err := decodeJSON(r, &data); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		writeResponse(w, r, http.StatusOK, s.envelope(r, map[string]any{"received": data}))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
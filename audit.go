@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// auditBuffer is how many entries can wait for the audit writer before new
+// ones are dropped.
+const auditBuffer = 1024
+
+// auditMaxChanges caps the item snapshots kept on one entry, for imports
+// and other bulk writes.
+const auditMaxChanges = 10
+
+// AuditEntry records one mutating API request: who made it, for which
+// tenant, what it was, how it ended, and the items it changed as they were
+// afterwards. Failed is set for 4xx and 5xx answers.
+type AuditEntry struct {
+	ID             int64         `json:"id"`
+	Time           time.Time     `json:"time"`
+	RequestID      string        `json:"request_id,omitempty"`
+	Principal      string        `json:"principal,omitempty"`
+	Tenant         string        `json:"tenant,omitempty"`
+	Method         string        `json:"method"`
+	Path           string        `json:"path"`
+	Query          string        `json:"query,omitempty"`
+	Status         int           `json:"status"`
+	Failed         bool          `json:"failed"`
+	Changes        []AuditChange `json:"changes,omitempty"`
+	ChangesOmitted int           `json:"changes_omitted,omitempty"`
+}
+
+// AuditChange is an item change made by the request, as its webhook event
+// describes it.
+type AuditChange struct {
+	Type string `json:"type"`
+	Item any    `json:"item"`
+}
+
+// AuditList is the response of GET /api/v1/audit. Dropped counts entries
+// lost since startup because the writer fell behind.
+type AuditList struct {
+	Entries []AuditEntry `json:"entries"`
+	Dropped int64        `json:"dropped"`
+}
+
+type auditKey struct{}
+
+// auditChanges collects the item changes of one request.
+type auditChanges struct {
+	mu      sync.Mutex
+	changes []AuditChange
+	omitted int
+}
+
+// recordAuditChange notes an item change on ctx's audit entry, if it has
+// one.
+func recordAuditChange(ctx context.Context, eventType string, item any) {
+	c, ok := ctx.Value(auditKey{}).(*auditChanges)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.changes) == auditMaxChanges {
+		c.omitted++
+		return
+	}
+	c.changes = append(c.changes, AuditChange{Type: eventType, Item: item})
+}
+
+// auditLog keeps the last size entries in memory and appends every one to
+// file, when set. Requests only queue their entry; one background writer
+// does the rest, so a slow disk never holds a request up. Entries that
+// don't fit in the queue are dropped and counted in
+// audit_entries_dropped_total.
+type auditLog struct {
+	now    func() time.Time
+	file   *rotatingFile
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	closed  bool
+	queue   chan AuditEntry
+	done    chan struct{}
+	dropped atomic.Int64
+
+	// entries is a ring guarded by entriesMu; next is where the next entry
+	// goes once it is full.
+	entriesMu sync.Mutex
+	size      int
+	entries   []AuditEntry
+	next      int
+	seq       int64
+}
+
+// newAuditLog starts the writer. The file, if any, is opened for appending
+// and never rotated.
+func newAuditLog(size int, path string, now func() time.Time, logger *slog.Logger) (*auditLog, error) {
+	a := &auditLog{
+		now:     now,
+		logger:  logger,
+		queue:   make(chan AuditEntry, auditBuffer),
+		done:    make(chan struct{}),
+		size:    size,
+		entries: make([]AuditEntry, 0, min(size, auditBuffer)),
+	}
+	if path != "" {
+		f, err := openRotatingFile(path, logRotation{}, func(msg string, err error) {
+			logger.Warn(strings.Replace(msg, "log file", "audit file", 1), slog.String("file", path), slog.Any("error", err))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("open AUDIT_FILE: %w", err)
+		}
+		a.file = f
+	}
+	go a.run()
+	return a, nil
+}
+
+// Middleware records POST, PUT, PATCH, and DELETE requests once they are
+// answered, including those refused by the middleware after it, such as
+// authentication. A panic is recorded as the 500 it becomes.
+func (a *auditLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		entry := AuditEntry{
+			Time:      a.now().UTC(),
+			RequestID: requestIDFrom(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Query:     r.URL.RawQuery,
+		}
+		changes := &auditChanges{}
+		rec := newStatusRecorder(w)
+		defer func() {
+			entry.Status = rec.status
+			p := recover()
+			if p != nil {
+				entry.Status = http.StatusInternalServerError
+			}
+			if info, ok := r.Context().Value(logInfoKey{}).(*requestLogInfo); ok {
+				entry.Principal = info.Subject
+				if entry.Principal == "" {
+					entry.Principal = info.APIKey
+				}
+				entry.Tenant = info.Tenant
+			}
+			entry.Failed = entry.Status >= 400
+			changes.mu.Lock()
+			entry.Changes, entry.ChangesOmitted = changes.changes, changes.omitted
+			changes.mu.Unlock()
+			a.record(r.Context(), entry)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, changes)))
+	})
+}
+
+// record queues entry without waiting for the writer.
+func (a *auditLog) record(ctx context.Context, entry AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.queue <- entry:
+	default:
+		a.dropped.Add(1)
+		auditEntriesDropped.Inc()
+		loggerFrom(ctx).Warn("audit buffer full, dropping entry", slog.String("method", entry.Method), slog.String("path", entry.Path))
+	}
+}
+
+// run numbers queued entries, keeps them, and appends them to the file
+// until the queue is closed.
+func (a *auditLog) run() {
+	defer close(a.done)
+	for entry := range a.queue {
+		a.entriesMu.Lock()
+		a.seq++
+		entry.ID = a.seq
+		if len(a.entries) < a.size {
+			a.entries = append(a.entries, entry)
+		} else {
+			a.entries[a.next] = entry
+			a.next = (a.next + 1) % a.size
+		}
+		a.entriesMu.Unlock()
+		if a.file != nil {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				a.logger.Error("cannot encode audit entry", slog.Int64("id", entry.ID), slog.Any("error", err))
+				continue
+			}
+			a.file.Write(append(line, '\n'))
+		}
+	}
+}
+
+// list returns the kept entries that keep accepts, newest first, at most
+// limit of them.
+func (a *auditLog) list(keep func(AuditEntry) bool, limit int) []AuditEntry {
+	a.entriesMu.Lock()
+	defer a.entriesMu.Unlock()
+	out := []AuditEntry{}
+	n := len(a.entries)
+	for i := 0; i < n && len(out) < limit; i++ {
+		entry := a.entries[(a.next+n-1-i)%n]
+		if keep(entry) {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// Shutdown stops accepting entries and writes those already queued. When
+// ctx ends first, the rest are dropped and ctx's error is returned.
+func (a *auditLog) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	var err error
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	return err
+}
+
+// auditHandler handles GET /api/v1/audit, newest first, filtered by
+// ?principal=, ?tenant=, ?path= prefix, ?since= and ?until= (RFC 3339),
+// and ?failed=, and capped by ?limit=.
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	invalid := func(detail string) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", detail)
+	}
+	limit := min(100, s.cfg.AuditSize)
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > s.cfg.AuditSize {
+			invalid(fmt.Sprintf("limit must be an integer between 1 and %d", s.cfg.AuditSize))
+			return
+		}
+		limit = n
+	}
+	var since, until time.Time
+	for name, t := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := values.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				invalid(name + " must be an RFC 3339 time")
+				return
+			}
+			*t = parsed
+		}
+	}
+	var failed *bool
+	if v := values.Get("failed"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			invalid("failed must be true or false")
+			return
+		}
+		failed = &b
+	}
+	principal, tenant, path := values.Get("principal"), values.Get("tenant"), values.Get("path")
+	entries := s.audit.list(func(e AuditEntry) bool {
+		return (principal == "" || e.Principal == principal) &&
+			(tenant == "" || e.Tenant == tenant) &&
+			strings.HasPrefix(e.Path, path) &&
+			(since.IsZero() || !e.Time.Before(since)) &&
+			(until.IsZero() || e.Time.Before(until)) &&
+			(failed == nil || e.Failed == *failed)
+	}, limit)
+	writeResponse(w, r, http.StatusOK, AuditList{Entries: entries, Dropped: s.audit.dropped.Load()})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	var logs bytes.Buffer
+	keys, err := parseAPIKeys([]string{"ci:s3cret", "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, h := newLoggedTestServer(t, slog.New(slog.NewJSONHandler(&logs, nil)), func(cfg *Config) { cfg.APIKeys = keys })
+
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		value      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"missing", "/api/v1/items", "", "", http.StatusUnauthorized, codeUnauthorized},
+		{"wrong", "/api/v1/items", apiKeyHeader, "guess", http.StatusForbidden, codeForbidden},
+		{"wrong bearer", "/api/v1/items", "Authorization", "Bearer guess", http.StatusForbidden, codeForbidden},
+		{"valid", "/api/v1/items", apiKeyHeader, "s3cret", http.StatusOK, ""},
+		{"valid bearer", "/api/items", "Authorization", "Bearer s3cret", http.StatusOK, ""},
+		{"unnamed", "/api/v1/items", apiKeyHeader, "other", http.StatusOK, ""},
+		{"root is open", "/", "", "", http.StatusOK, ""},
+		{"healthz is open", "/healthz", "", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(t, "GET", tt.path, "")
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			rec := serve(h, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("GET %s = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode {
+				t.Errorf("body = %s, want code %s", rec.Body, tt.wantCode)
+			}
+		})
+	}
+
+	var named int
+	for _, line := range logLines(t, &logs, "request") {
+		if line["api_key"] == "ci" {
+			named++
+		}
+		if line["api_key"] == "s3cret" {
+			t.Errorf("access log carries the secret: %v", line)
+		}
+	}
+	if named != 2 {
+		t.Errorf("%d access log lines name key ci, want 2", named)
+	}
+	if bytes.Contains(logs.Bytes(), []byte("s3cret")) {
+		t.Error("the key's value reached the logs")
+	}
+}
+
+func TestMatchAPIKey(t *testing.T) {
+	keys, _ := parseAPIKeys([]string{"a:one", "b:two"})
+	if key, ok := matchAPIKey(keys, "two"); !ok || key.Name != "b" {
+		t.Errorf("matchAPIKey(two) = %q, %v, want b", key.Name, ok)
+	}
+	for _, presented := range []string{"", "on", "one ", "twotwo"} {
+		if _, ok := matchAPIKey(keys, presented); ok {
+			t.Errorf("matchAPIKey(%q) matched", presented)
+		}
+	}
+	if _, err := parseAPIKeys([]string{"name:"}); err == nil {
+		t.Error("parseAPIKeys accepted an empty key")
+	}
+}
+
+func TestAdminRoutesNeedCredentials(t *testing.T) {
+	routes := []struct{ method, path string }{
+		{"POST", "/admin/fault"},
+		{"POST", "/admin/maintenance"},
+		{"POST", "/admin/readonly"},
+		{"POST", "/stats/reset"},
+		{"GET", "/api/v1/audit"},
+		{"GET", "/debug/requests"},
+		{"POST", "/debug/requests/1/replay"},
+	}
+	enable := func(cfg *Config) {
+		cfg.DebugEndpoints = true
+		cfg.EnableRecorder = true
+	}
+
+	_, open := newTestServer(t, enable)
+	for _, rt := range routes {
+		if rec := serve(open, newRequest(t, rt.method, rt.path, "")); rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s with debug endpoints and no auth = %d, want 404", rt.method, rt.path, rec.Code)
+		}
+	}
+
+	keys, err := parseAPIKeys([]string{"ops:s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secured := newTestServer(t, enable, func(cfg *Config) { cfg.APIKeys = keys })
+	for _, rt := range routes {
+		if rec := serve(secured, newRequest(t, rt.method, rt.path, "")); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a key = %d, want 401", rt.method, rt.path, rec.Code)
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.EnableRecorder = true
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "ENABLE_RECORDER") {
+		t.Errorf("Validate with ENABLE_RECORDER and no auth = %v, want an ENABLE_RECORDER error", err)
+	}
+	cfg.APIKeys = keys
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate with ENABLE_RECORDER and API keys = %v", err)
+	}
+}
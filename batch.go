@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BatchRequest is the body of POST /api/v1/batch.
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+
+	maxOperations int
+}
+
+// BatchOperation is one API call inside a batch. Path may carry a query
+// string.
+type BatchOperation struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchResult is the outcome of the operation at the same index.
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// BatchResponse lists a result per operation. RolledBack is set when an
+// atomic batch failed and none of its changes were kept.
+type BatchResponse struct {
+	Results    []BatchResult `json:"results"`
+	Atomic     bool          `json:"atomic"`
+	RolledBack bool          `json:"rolled_back,omitempty"`
+}
+
+// batchMethods are the methods an operation may use.
+var batchMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// Validate requires between one and maxOperations operations, each with a
+// supported method and a path under /api/.
+func (req *BatchRequest) Validate() []FieldError {
+	var problems []FieldError
+	switch {
+	case len(req.Operations) == 0:
+		problems = append(problems, FieldError{Field: "operations", Constraint: "required", Message: "must contain at least one operation"})
+	case len(req.Operations) > req.maxOperations:
+		problems = append(problems, FieldError{
+			Field:      "operations",
+			Constraint: "max_items",
+			Message:    fmt.Sprintf("must have at most %d items", req.maxOperations),
+			Value:      len(req.Operations),
+		})
+	}
+	for i, op := range req.Operations {
+		op.Method = strings.ToUpper(op.Method)
+		if !slices.Contains(batchMethods, op.Method) {
+			problems = append(problems, FieldError{
+				Field:      fmt.Sprintf("operations[%d].method", i),
+				Constraint: "enum",
+				Message:    "must be one of " + strings.Join(batchMethods, ", "),
+				Value:      op.Method,
+			})
+		}
+		if !strings.HasPrefix(op.Path, "/api/") {
+			problems = append(problems, FieldError{
+				Field:      fmt.Sprintf("operations[%d].path", i),
+				Constraint: "pattern",
+				Message:    "must be a path under /api/",
+				Value:      op.Path,
+			})
+		}
+	}
+	return problems
+}
+
+type batchKey struct{}
+
+// batchContext marks a request as an operation of a batch. store is the
+// transaction of an atomic batch, or nil to use the server's store; pending
+// collects the batch's webhook events until it commits.
+type batchContext struct {
+	store   Store
+	pending *[]WebhookEvent
+}
+
+// inBatch reports whether ctx belongs to a batch operation.
+func inBatch(ctx context.Context) bool {
+	_, ok := ctx.Value(batchKey{}).(batchContext)
+	return ok
+}
+
+// storeFor returns the store a handler should use: the transaction of the
+// atomic batch r belongs to, or s.store.
+func (s *Server) storeFor(r *http.Request) Store {
+	if bc, ok := r.Context().Value(batchKey{}).(batchContext); ok && bc.store != nil {
+		return bc.store
+	}
+	return s.store
+}
+
+// errBatchFailed aborts an atomic batch so its changes are rolled back.
+var errBatchFailed = errors.New("batch operation failed")
+
+// batchHandler handles POST /api/v1/batch. Operations run in order through
+// the router, so each is authenticated, validated, and counted exactly as a
+// standalone request would be. Failures are reported per operation unless
+// ?atomic=true, where the first failure stops the batch and undoes it.
+func (s *Server) batchHandler(w http.ResponseWriter, r *http.Request) {
+	if inBatch(r.Context()) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Nested batch", "batch operations cannot call the batch endpoint")
+		return
+	}
+	atomic := false
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", "atomic must be true or false")
+			return
+		}
+	}
+	req := BatchRequest{maxOperations: s.cfg.BatchMaxOperations}
+	if !decodeValid(w, r, &req) {
+		return
+	}
+
+	if !atomic {
+		results, _ := s.runBatch(r, req.Operations, batchContext{}, false)
+		writeResponse(w, r, http.StatusOK, BatchResponse{Results: results})
+		return
+	}
+	if s.atomic == nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Atomic batches not supported", "atomic=true needs the in-memory or file store")
+		return
+	}
+	var results []BatchResult
+	var pending []WebhookEvent
+	err := s.atomic.Atomically(r.Context(), func(tx Store) error {
+		var failed bool
+		pending = nil
+		results, failed = s.runBatch(r, req.Operations, batchContext{store: instrumentedStore{tx}, pending: &pending}, true)
+		if failed {
+			return errBatchFailed
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errBatchFailed):
+		writeResponse(w, r, http.StatusConflict, BatchResponse{Results: results, Atomic: true, RolledBack: true})
+	case err != nil:
+		// The rollback itself failed to persist.
+		writeStoreError(w, r, err)
+	default:
+		for _, event := range pending {
+			s.publishChange(r.Context(), event.Type, event.Item)
+		}
+		writeResponse(w, r, http.StatusOK, BatchResponse{Results: results, Atomic: true})
+	}
+}
+
+// runBatch executes ops in order within bc. With
+// stopOnFailure, operations after the first 4xx or 5xx are not run and
+// report 424 Failed Dependency.
+func (s *Server) runBatch(r *http.Request, ops []BatchOperation, bc batchContext, stopOnFailure bool) ([]BatchResult, bool) {
+	results := make([]BatchResult, len(ops))
+	failedAt := -1
+	for i, op := range ops {
+		if err := r.Context().Err(); err != nil {
+			// The client is gone or the deadline passed; nobody will read
+			// the rest, and an atomic batch rolls back.
+			return results[:i], true
+		}
+		if failedAt >= 0 {
+			body, _ := json.Marshal(ErrorResponse{
+				Error:     localizeError(r, codeBatchAborted, "Not run"),
+				Code:      codeBatchAborted,
+				Detail:    fmt.Sprintf("operation %d failed", failedAt),
+				RequestID: requestIDFrom(r.Context()),
+			})
+			results[i] = BatchResult{Status: http.StatusFailedDependency, Body: body}
+			continue
+		}
+		results[i] = s.runOperation(r, op, bc)
+		if stopOnFailure && results[i].Status >= 400 {
+			failedAt = i
+		}
+	}
+	return results, failedAt >= 0
+}
+
+// runOperation dispatches one operation as a sub-request of r, carrying over
+// its credentials and client address.
+func (s *Server) runOperation(r *http.Request, op BatchOperation, bc batchContext) BatchResult {
+	var body io.Reader = http.NoBody
+	hasBody := len(op.Body) > 0 && string(op.Body) != "null"
+	if hasBody {
+		body = bytes.NewReader(op.Body)
+	}
+	// A fresh log info keeps sub-requests from overwriting the batch's own
+	// route and credentials in the access log.
+	ctx := context.WithValue(r.Context(), batchKey{}, bc)
+	ctx = context.WithValue(ctx, logInfoKey{}, &requestLogInfo{})
+	sub, err := http.NewRequestWithContext(ctx, strings.ToUpper(op.Method), op.Path, body)
+	if err != nil {
+		return errorResult(r, http.StatusBadRequest, codeInvalidParameter, "Invalid operation", err.Error())
+	}
+	sub.RemoteAddr = r.RemoteAddr
+	sub.Host = r.Host
+	sub.TLS = r.TLS
+	for _, name := range []string{"Authorization", apiKeyHeader, requestIDHeader} {
+		if v := r.Header.Get(name); v != "" {
+			sub.Header.Set(name, v)
+		}
+	}
+	sub.Header.Set("Accept", contentTypeJSON)
+	if hasBody {
+		sub.Header.Set("Content-Type", contentTypeJSON)
+	}
+
+	rec := &batchRecorder{header: make(http.Header)}
+	s.api.ServeHTTP(rec, sub)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	result := BatchResult{Status: rec.status, Body: json.RawMessage("null")}
+	if b := bytes.TrimSpace(rec.body.Bytes()); len(b) > 0 {
+		if json.Valid(b) {
+			result.Body = b
+		} else {
+			result.Body, _ = json.Marshal(string(b))
+		}
+	}
+	return result
+}
+
+func errorResult(r *http.Request, status int, code, msg, detail string) BatchResult {
+	body, _ := json.Marshal(ErrorResponse{Error: localizeError(r, code, msg), Code: code, Detail: detail, RequestID: requestIDFrom(r.Context())})
+	return BatchResult{Status: status, Body: body}
+}
+
+// batchRecorder captures a sub-request's response.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *batchRecorder) Header() http.Header { return rec.header }
+
+func (rec *batchRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *batchRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
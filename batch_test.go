@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func postBatch(t *testing.T, h http.Handler, query, body string) (int, BatchResponse) {
+	t.Helper()
+	rec := serve(h, newRequest(t, "POST", "/api/v1/batch"+query, body))
+	var resp BatchResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+func itemCount(t *testing.T, h http.Handler) int {
+	t.Helper()
+	var list ItemList
+	json.Unmarshal(serve(h, newRequest(t, "GET", "/api/v1/items", "")).Body.Bytes(), &list)
+	return list.Total
+}
+
+func TestBatchMixedResults(t *testing.T) {
+	_, h := newTestServer(t)
+	status, resp := postBatch(t, h, "", `{"operations": [
+		{"method": "POST", "path": "/api/v1/items", "body": {"name": "one"}},
+		{"method": "GET", "path": "/api/v1/items/missing"},
+		{"method": "POST", "path": "/api/v1/items", "body": {"name": ""}},
+		{"method": "post", "path": "/api/v1/items", "body": {"name": "two"}}
+	]}`)
+	if status != http.StatusOK || len(resp.Results) != 4 {
+		t.Fatalf("batch = %d with %d results, want 200 with 4", status, len(resp.Results))
+	}
+	want := []int{http.StatusCreated, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusCreated}
+	for i, result := range resp.Results {
+		if result.Status != want[i] {
+			t.Errorf("result %d = %d %s, want %d", i, result.Status, result.Body, want[i])
+		}
+	}
+	var created Item
+	if err := json.Unmarshal(resp.Results[0].Body, &created); err != nil || created.Name != "one" {
+		t.Errorf("result 0 body = %s, want the created item", resp.Results[0].Body)
+	}
+	if n := itemCount(t, h); n != 2 {
+		t.Errorf("%d items after the batch, want 2", n)
+	}
+}
+
+func TestBatchAtomic(t *testing.T) {
+	_, h := newTestServer(t)
+	existing := createItem(t, h, `{"name": "keep"}`)
+
+	status, resp := postBatch(t, h, "?atomic=true", `{"operations": [
+		{"method": "POST", "path": "/api/v1/items", "body": {"name": "rolled back"}},
+		{"method": "DELETE", "path": "/api/v1/items/`+existing.ID+`"},
+		{"method": "GET", "path": "/api/v1/items/missing"},
+		{"method": "POST", "path": "/api/v1/items", "body": {"name": "never run"}}
+	]}`)
+	if status != http.StatusConflict || !resp.RolledBack {
+		t.Fatalf("failing atomic batch = %d rolled_back=%v, want 409 rolled back", status, resp.RolledBack)
+	}
+	want := []int{http.StatusCreated, http.StatusNoContent, http.StatusNotFound, http.StatusFailedDependency}
+	for i, result := range resp.Results {
+		if result.Status != want[i] {
+			t.Errorf("result %d = %d, want %d", i, result.Status, want[i])
+		}
+	}
+	if rec := serve(h, newRequest(t, "GET", "/api/v1/items/"+existing.ID, "")); rec.Code != http.StatusOK || itemCount(t, h) != 1 {
+		t.Errorf("after rollback GET existing = %d with %d items, want it kept and nothing added", rec.Code, itemCount(t, h))
+	}
+
+	status, resp = postBatch(t, h, "?atomic=true", `{"operations": [
+		{"method": "POST", "path": "/api/v1/items", "body": {"name": "a"}},
+		{"method": "POST", "path": "/api/v1/items", "body": {"name": "b"}}
+	]}`)
+	if status != http.StatusOK || !resp.Atomic || resp.RolledBack || itemCount(t, h) != 3 {
+		t.Errorf("successful atomic batch = %d %+v with %d items, want 200 and 3 items", status, resp, itemCount(t, h))
+	}
+}
+
+func TestBatchRejects(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.BatchMaxOperations = 2 })
+	tests := []struct {
+		name, body string
+		wantStatus int
+	}{
+		{"empty", `{"operations": []}`, http.StatusUnprocessableEntity},
+		{"too many", `{"operations": [` + strings.Repeat(`{"method": "GET", "path": "/api/v1/items"},`, 2) + `{"method": "GET", "path": "/api/v1/items"}]}`, http.StatusUnprocessableEntity},
+		{"outside /api", `{"operations": [{"method": "GET", "path": "/debug/env"}]}`, http.StatusUnprocessableEntity},
+		{"bad method", `{"operations": [{"method": "TRACE", "path": "/api/v1/items"}]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if status, _ := postBatch(t, h, "", tt.body); status != tt.wantStatus {
+			t.Errorf("%s: batch = %d, want %d", tt.name, status, tt.wantStatus)
+		}
+	}
+
+	status, resp := postBatch(t, h, "", `{"operations": [{"method": "POST", "path": "/api/v1/batch", "body": {"operations": [{"method": "GET", "path": "/api/v1/items"}]}}]}`)
+	if status != http.StatusOK || len(resp.Results) != 1 || resp.Results[0].Status != http.StatusBadRequest {
+		t.Errorf("nested batch = %d %+v, want its operation rejected with 400", status, resp)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// breakerState is a circuit breaker's position. The values are exported as
+// the circuit_breaker_state gauge.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (st breakerState) String() string {
+	switch st {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitOpenError is returned instead of calling a dependency whose breaker
+// is open.
+type circuitOpenError struct {
+	dependency string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open", e.dependency)
+}
+
+// circuitBreaker stops calling a dependency after threshold consecutive
+// failures. Once openFor has passed it lets a single probe through
+// (half-open): success closes the circuit, failure reopens it. A nil breaker
+// allows everything.
+type circuitBreaker struct {
+	name      string
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// generation changes on every transition so results of calls admitted
+	// under an earlier state are ignored.
+	generation uint64
+}
+
+// newCircuitBreaker returns a breaker for the named dependency, or nil when
+// threshold is zero.
+func newCircuitBreaker(name string, threshold int, openFor time.Duration, now func() time.Time, logger *slog.Logger) *circuitBreaker {
+	if threshold < 1 {
+		return nil
+	}
+	circuitBreakerState.WithLabelValues(name).Set(float64(breakerClosed))
+	return &circuitBreaker{name: name, threshold: threshold, openFor: openFor, now: now, logger: logger}
+}
+
+// Allow admits a call or returns a *circuitOpenError. An admitted caller must
+// report the outcome through done exactly once.
+func (b *circuitBreaker) Allow() (done func(failed bool), err error) {
+	if b == nil {
+		return func(bool) {}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if wait := b.openFor - b.now().Sub(b.openedAt); wait > 0 {
+			return nil, &circuitOpenError{dependency: b.name, retryAfter: wait}
+		}
+		b.setLocked(breakerHalfOpen)
+	}
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			return nil, &circuitOpenError{dependency: b.name, retryAfter: time.Second}
+		}
+		b.probing = true
+	}
+	generation := b.generation
+	return func(failed bool) { b.record(generation, failed) }, nil
+}
+
+func (b *circuitBreaker) record(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case breakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.setLocked(breakerOpen)
+		}
+	case breakerHalfOpen:
+		if failed {
+			b.setLocked(breakerOpen)
+		} else {
+			b.setLocked(breakerClosed)
+		}
+	}
+}
+
+// setLocked moves to state, resetting the per-state bookkeeping. The caller
+// must hold b.mu.
+func (b *circuitBreaker) setLocked(state breakerState) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.probing = false
+	if state == breakerOpen {
+		b.openedAt = b.now()
+	}
+	circuitBreakerState.WithLabelValues(b.name).Set(float64(state))
+
+	level := slog.LevelInfo
+	if state == breakerOpen {
+		level = slog.LevelWarn
+	}
+	b.logger.Log(context.Background(), level, "circuit breaker state changed",
+		slog.String("dependency", b.name),
+		slog.String("from", from.String()),
+		slog.String("to", state.String()),
+	)
+}
+
+// State reports the current position. An open breaker whose openFor has
+// elapsed still reads "open" until the next call probes it.
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// writeCircuitOpen answers 503 with a Retry-After for when the breaker will
+// next admit a probe.
+func writeCircuitOpen(w http.ResponseWriter, r *http.Request, err *circuitOpenError) {
+	writeRetryableError(w, r, http.StatusServiceUnavailable, err.retryAfter, codeCircuitOpen, "Dependency unavailable", err.Error())
+}
+
+// breakerTransport guards an outbound RoundTripper. Transport errors and
+// 502, 503, and 504 responses count as failures; cancellation by the caller
+// does not.
+type breakerTransport struct {
+	base    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		done(req.Context().Err() == nil)
+	default:
+		done(resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable ||
+			resp.StatusCode == http.StatusGatewayTimeout)
+	}
+	return resp, err
+}
+
+// breakerStore guards a Store backed by an external service. Missing items,
+// rejected patches, version conflicts, and cancelled requests are the
+// caller's doing and are not counted as failures.
+type breakerStore struct {
+	Store
+	breaker *circuitBreaker
+}
+
+func storeFailed(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil &&
+		!errors.Is(err, ErrNotFound) && !errors.Is(err, errInvalidPatch) && !errors.As(err, new(*versionConflictError))
+}
+
+func (s breakerStore) Create(ctx context.Context, item Item) (Item, error) {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return Item{}, err
+	}
+	created, err := s.Store.Create(ctx, item)
+	done(storeFailed(ctx, err))
+	return created, err
+}
+
+func (s breakerStore) Get(ctx context.Context, id string) (Item, error) {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return Item{}, err
+	}
+	item, err := s.Store.Get(ctx, id)
+	done(storeFailed(ctx, err))
+	return item, err
+}
+
+func (s breakerStore) List(ctx context.Context, q itemQuery) ([]Item, int, error) {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return nil, 0, err
+	}
+	items, total, err := s.Store.List(ctx, q)
+	done(storeFailed(ctx, err))
+	return items, total, err
+}
+
+func (s breakerStore) ListStream(ctx context.Context, q itemQuery) iter.Seq2[Item, error] {
+	return func(yield func(Item, error) bool) {
+		done, err := s.breaker.Allow()
+		if err != nil {
+			yield(Item{}, err)
+			return
+		}
+		for item, itemErr := range s.Store.ListStream(ctx, q) {
+			if err = itemErr; err != nil {
+				yield(Item{}, err)
+				break
+			}
+			if !yield(item, nil) {
+				break
+			}
+		}
+		done(storeFailed(ctx, err))
+	}
+}
+
+func (s breakerStore) Update(ctx context.Context, id string, item Item) (Item, error) {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return Item{}, err
+	}
+	updated, err := s.Store.Update(ctx, id, item)
+	done(storeFailed(ctx, err))
+	return updated, err
+}
+
+func (s breakerStore) Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return Item{}, err
+	}
+	patched, err := s.Store.Patch(ctx, id, fn)
+	done(storeFailed(ctx, err))
+	return patched, err
+}
+
+func (s breakerStore) Delete(ctx context.Context, id string) error {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return err
+	}
+	err = s.Store.Delete(ctx, id)
+	done(storeFailed(ctx, err))
+	return err
+}
+
+func (s breakerStore) Restore(ctx context.Context, id string) (Item, bool, error) {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return Item{}, false, err
+	}
+	item, restored, err := s.Store.Restore(ctx, id)
+	done(storeFailed(ctx, err))
+	return item, restored, err
+}
+
+func (s breakerStore) Purge(ctx context.Context, id string) error {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return err
+	}
+	err = s.Store.Purge(ctx, id)
+	done(storeFailed(ctx, err))
+	return err
+}
+
+func (s breakerStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	done, err := s.breaker.Allow()
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.Store.PurgeDeleted(ctx, cutoff)
+	done(storeFailed(ctx, err))
+	return n, err
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreakerTripsAndRecovers(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int64
+	failing.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	b := newCircuitBreaker("test-upstream", 3, 10*time.Second, clock.now, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	client := &http.Client{Transport: breakerTransport{base: http.DefaultTransport, breaker: b}}
+	call := func() error {
+		resp, err := client.Get(upstream.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	gauge := func() float64 { return testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-upstream")) }
+
+	for range 3 {
+		if err := call(); err != nil {
+			t.Fatalf("call while closed = %v, want the upstream's 503", err)
+		}
+	}
+	if b.State() != breakerOpen || gauge() != float64(breakerOpen) {
+		t.Fatalf("after 3 failures state = %s, gauge %v, want open", b.State(), gauge())
+	}
+	var open *circuitOpenError
+	if err := call(); !errors.As(err, &open) || open.retryAfter != 10*time.Second {
+		t.Fatalf("call while open = %v, want a circuitOpenError retrying after 10s", err)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("upstream hit %d times, want the open circuit to fail fast", hits.Load())
+	}
+
+	// A failed probe reopens the circuit.
+	clock.advance(10 * time.Second)
+	call()
+	if b.State() != breakerOpen || hits.Load() != 4 {
+		t.Fatalf("after a failed probe state = %s with %d hits, want open after one probe", b.State(), hits.Load())
+	}
+
+	// A successful probe closes it.
+	failing.Store(false)
+	clock.advance(10 * time.Second)
+	if err := call(); err != nil || b.State() != breakerClosed || gauge() != float64(breakerClosed) {
+		t.Fatalf("after a good probe err = %v, state = %s, want closed", err, b.State())
+	}
+	if err := call(); err != nil {
+		t.Errorf("call after recovery = %v", err)
+	}
+}
+
+func TestCircuitBreakerAdmitsOneProbe(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	b := newCircuitBreaker("probe", 1, time.Second, clock.now, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	done, _ := b.Allow()
+	done(true)
+	clock.advance(time.Second)
+
+	var admitted atomic.Int64
+	var probe func(bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if done, err := b.Allow(); err == nil {
+				admitted.Add(1)
+				mu.Lock()
+				probe = done
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted.Load() != 1 || b.State() != breakerHalfOpen {
+		t.Fatalf("%d calls admitted while half-open (state %s), want exactly 1", admitted.Load(), b.State())
+	}
+	probe(false)
+	if b.State() != breakerClosed {
+		t.Errorf("state after a good probe = %s, want closed", b.State())
+	}
+}
+
+// Outcomes of calls admitted before a transition must not move the new state.
+func TestCircuitBreakerIgnoresStaleResults(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	b := newCircuitBreaker("stale", 2, time.Second, clock.now, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	slow, _ := b.Allow()
+	for range 2 {
+		done, _ := b.Allow()
+		done(true)
+	}
+	clock.advance(time.Second)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow(false)
+	if b.State() != breakerHalfOpen {
+		t.Errorf("stale success moved the breaker to %s", b.State())
+	}
+	probe(false)
+	if b.State() != breakerClosed {
+		t.Errorf("state after the probe = %s, want closed", b.State())
+	}
+}
+
+// downStore is an external store that can be switched off.
+type downStore struct {
+	*memoryStore
+	down atomic.Bool
+}
+
+func (s *downStore) Name() string { return "database" }
+
+func (s *downStore) Check(context.Context) error {
+	if s.down.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (s *downStore) Get(ctx context.Context, id string) (Item, error) {
+	if s.down.Load() {
+		return Item{}, errors.New("connection refused")
+	}
+	return s.memoryStore.Get(ctx, id)
+}
+
+func TestCircuitOpenFailsFast(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CircuitBreakerThreshold = 2
+	cfg.CircuitBreakerOpenDuration = 30 * time.Second
+	cfg.WarmupTimeout = 0
+	store := &downStore{memoryStore: newMemoryStore()}
+	srv, h := startTestServer(t, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+	srv.warmUp(context.Background())
+
+	store.down.Store(true)
+	for range 2 {
+		if rec := serve(h, newRequest(t, "GET", "/api/v1/items/x", "")); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("GET with the store down = %d, want 500 while closed", rec.Code)
+		}
+	}
+	rec := serve(h, newRequest(t, "GET", "/api/v1/items/x", ""))
+	var body ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" || body.Code != codeCircuitOpen {
+		t.Errorf("GET with the circuit open = %d, Retry-After %q, %s; want a circuit_open 503 retrying in 30s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+
+	store.down.Store(false)
+	var health HealthResponse
+	json.Unmarshal(serve(h, newRequest(t, "GET", "/readyz", "")).Body.Bytes(), &health)
+	if health.Circuits["database"] != "open" {
+		t.Errorf("/readyz circuits = %v, want database open", health.Circuits)
+	}
+}
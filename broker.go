@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Broker message headers, besides X-Request-ID. The event ID is the same one
+// webhooks get in X-Webhook-Delivery.
+const (
+	brokerEventHeader   = "X-Event-Type"
+	brokerEventIDHeader = "X-Event-ID"
+)
+
+// brokerBatch is the most messages the publisher hands the broker at once.
+const brokerBatch = 100
+
+// Publisher sends item change events to a message broker. Publish must not
+// block the request that made the change.
+type Publisher interface {
+	Publish(ctx context.Context, event WebhookEvent)
+	Shutdown(ctx context.Context) error
+}
+
+// noopPublisher is the Publisher without BROKER_URL.
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(context.Context, WebhookEvent) {}
+
+func (noopPublisher) Shutdown(context.Context) error { return nil }
+
+// brokerMessage is one encoded event. Key orders a Kafka partition by item.
+type brokerMessage struct {
+	key     string
+	body    []byte
+	headers map[string]string
+}
+
+// brokerConn is a connection to one kind of broker. It is also the readiness
+// check for it.
+type brokerConn interface {
+	Checker
+	Send(ctx context.Context, subject string, msgs []brokerMessage) error
+	Close() error
+}
+
+// openBrokerConn connects to a nats:// or kafka:// URL. Neither blocks on
+// the broker being up: NATS keeps retrying in the background and Kafka
+// dials on the first write.
+func openBrokerConn(rawURL string) (brokerConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "nats":
+		nc, err := nats.Connect(rawURL,
+			nats.Name("my-go-app"),
+			nats.RetryOnFailedConnect(true),
+			nats.MaxReconnects(-1),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return natsConn{nc}, nil
+	case "kafka":
+		return newKafkaConn(strings.Split(u.Host, ",")), nil
+	}
+	return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+}
+
+// brokerPublisher queues events and publishes them from one background
+// worker, so a slow or unreachable broker costs requests nothing. Events
+// that don't fit in the buffer, or that the broker refuses, are dropped and
+// counted in broker_events_dropped_total.
+type brokerPublisher struct {
+	conn    brokerConn
+	subject string
+	timeout time.Duration
+	logger  *slog.Logger
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan brokerMessage
+	done   chan struct{}
+	// ctx is cancelled when a shutdown runs out of time.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newBrokerPublisher(conn brokerConn, subject string, buffer int, timeout time.Duration, logger *slog.Logger) *brokerPublisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &brokerPublisher{
+		conn:    conn,
+		subject: subject,
+		timeout: timeout,
+		logger:  logger,
+		queue:   make(chan brokerMessage, buffer),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go p.run()
+	return p
+}
+
+// Name and Check make the broker connection a readiness check.
+func (p *brokerPublisher) Name() string { return p.conn.Name() }
+
+func (p *brokerPublisher) Check(ctx context.Context) error { return p.conn.Check(ctx) }
+
+// Publish queues event without waiting for the broker. The request ID of
+// ctx goes with it as X-Request-ID.
+func (p *brokerPublisher) Publish(ctx context.Context, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		p.logger.Error("cannot encode broker event", slog.String("event_type", event.Type), slog.Any("error", err))
+		return
+	}
+	msg := brokerMessage{
+		key:  eventItemID(event.Item),
+		body: body,
+		headers: map[string]string{
+			brokerEventHeader:   event.Type,
+			brokerEventIDHeader: event.ID,
+		},
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		msg.headers[requestIDHeader] = id
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.queue <- msg:
+	default:
+		brokerEventsDropped.WithLabelValues("buffer_full").Inc()
+		loggerFrom(ctx).Warn("broker buffer full, dropping event", slog.String("event_type", event.Type), slog.String("event_id", event.ID))
+	}
+}
+
+// run publishes queued messages until the queue is closed, taking whatever
+// has piled up, up to brokerBatch, in one send.
+func (p *brokerPublisher) run() {
+	defer close(p.done)
+	batch := make([]brokerMessage, 0, brokerBatch)
+	for msg := range p.queue {
+		batch = append(batch[:0], msg)
+	more:
+		for len(batch) < brokerBatch {
+			select {
+			case msg, ok := <-p.queue:
+				if !ok {
+					break more
+				}
+				batch = append(batch, msg)
+			default:
+				break more
+			}
+		}
+		p.send(batch)
+	}
+}
+
+func (p *brokerPublisher) send(batch []brokerMessage) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+	defer cancel()
+	if err := p.conn.Send(ctx, p.subject, batch); err != nil {
+		brokerEventsDropped.WithLabelValues("publish_failed").Add(float64(len(batch)))
+		p.logger.Warn("broker publish failed, dropping events",
+			slog.String("broker", p.conn.Name()),
+			slog.Int("events", len(batch)),
+			slog.Any("error", err),
+		)
+		return
+	}
+	brokerEventsPublished.Add(float64(len(batch)))
+}
+
+// Shutdown stops accepting events, publishes those already queued, and
+// closes the connection. When ctx ends first, the rest are dropped and
+// ctx's error is returned.
+func (p *brokerPublisher) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	var err error
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.cancel()
+		<-p.done
+		err = ctx.Err()
+	}
+	p.cancel()
+	if cerr := p.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// eventItemID is the ID of the item an event is about.
+func eventItemID(item any) string {
+	switch item := item.(type) {
+	case Item:
+		return item.ID
+	case *Item:
+		return item.ID
+	case deletedItem:
+		return item.ID
+	}
+	return ""
+}
+
+// natsConn publishes to a NATS subject.
+type natsConn struct {
+	nc *nats.Conn
+}
+
+func (c natsConn) Name() string { return "nats" }
+
+func (c natsConn) Check(context.Context) error {
+	if status := c.nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("connection %s", strings.ToLower(status.String()))
+	}
+	return nil
+}
+
+// Send publishes msgs and waits for the server to have received them.
+func (c natsConn) Send(ctx context.Context, subject string, msgs []brokerMessage) error {
+	for _, msg := range msgs {
+		m := nats.NewMsg(subject)
+		m.Data = msg.body
+		for k, v := range msg.headers {
+			m.Header.Set(k, v)
+		}
+		if err := c.nc.PublishMsg(m); err != nil {
+			return err
+		}
+	}
+	return c.nc.FlushWithContext(ctx)
+}
+
+// Close flushes anything still buffered and disconnects. Without a
+// connection there is nothing to flush to.
+func (c natsConn) Close() error {
+	if c.nc.Status() != nats.CONNECTED {
+		c.nc.Close()
+		return nil
+	}
+	return c.nc.Drain()
+}
+
+// kafkaConn produces to a Kafka topic.
+type kafkaConn struct {
+	w      *kafka.Writer
+	client *kafka.Client
+}
+
+func newKafkaConn(brokers []string) kafkaConn {
+	addr := kafka.TCP(brokers...)
+	return kafkaConn{
+		w: &kafka.Writer{
+			Addr: addr,
+			// Events for one item keep their order on one partition.
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireOne,
+			// The publisher already batches; don't wait to fill up more.
+			BatchSize:              brokerBatch,
+			BatchTimeout:           time.Millisecond,
+			AllowAutoTopicCreation: true,
+		},
+		client: &kafka.Client{Addr: addr},
+	}
+}
+
+func (c kafkaConn) Name() string { return "kafka" }
+
+// Check asks a broker for cluster metadata.
+func (c kafkaConn) Check(ctx context.Context) error {
+	_, err := c.client.Metadata(ctx, &kafka.MetadataRequest{})
+	return err
+}
+
+func (c kafkaConn) Send(ctx context.Context, topic string, msgs []brokerMessage) error {
+	records := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		records[i] = kafka.Message{Topic: topic, Key: []byte(msg.key), Value: msg.body}
+		for k, v := range msg.headers {
+			records[i].Headers = append(records[i].Headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+	}
+	return c.w.WriteMessages(ctx, records...)
+}
+
+func (c kafkaConn) Close() error {
+	return c.w.Close()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// defaultCacheControl is the Cache-Control sent on GET and HEAD responses,
+// keyed by route path as it appears in /openapi.json. CACHE_CONTROL
+// overrides individual entries.
+func defaultCacheControl() map[string]string {
+	return map[string]string{
+		"/":                         "public, max-age=60",
+		"/version":                  "public, max-age=60",
+		"/openapi.json":             "public, max-age=300",
+		"/docs":                     "public, max-age=300",
+		"/health":                   "no-store",
+		"/healthz":                  "no-store",
+		"/readyz":                   "no-store",
+		"/metrics":                  "no-store",
+		"/stats":                    "no-store",
+		"/time":                     "no-store",
+		"/ip":                       "no-store",
+		"/events":                   "no-store",
+		"/ui":                       "no-store",
+		"/debug/config":             "no-store",
+		"/debug/flags":              "no-store",
+		"/debug/tasks":              "no-store",
+		"/jobs":                     "no-store",
+		"/jobs/{id}":                "no-store",
+		"/webhooks":                 "no-store",
+		"/webhooks/{id}/deliveries": "no-store",
+		"/api/uuid":                 "no-store",
+		"/api/v1/uuid":              "no-store",
+		"/api/random":               "no-store",
+		"/api/v1/random":            "no-store",
+		"/api/items":                "private, no-cache",
+		"/api/items/{id}":           "private, no-cache",
+		"/api/v1/items":             "private, no-cache",
+		"/api/v1/items/{id}":        "private, no-cache",
+	}
+}
+
+// parseCacheControl reads CACHE_CONTROL entries of the form
+// "path=directives", separated by semicolons because directives themselves
+// contain commas. An empty value removes the default for that path.
+func parseCacheControl(v string, into map[string]string) error {
+	for _, entry := range strings.Split(v, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, value, ok := strings.Cut(entry, "=")
+		path = strings.TrimSpace(path)
+		if !ok || !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("entry %q must be path=directives", entry)
+		}
+		if value = strings.TrimSpace(value); value == "" {
+			delete(into, path)
+			continue
+		}
+		into[path] = value
+	}
+	return nil
+}
+
+// bodyETag is a weak validator for an encoded response body. It is weak
+// because gzip changes the bytes on the wire but not the representation.
+func bodyETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// generationETag builds a list validator from a store generation without
+// encoding the page: the tenant, query, negotiated encoding, and
+// indentation together identify which representation of that generation
+// was sent. Generations are counted per tenant, so without the tenant two
+// tenants' listings could share an ETag.
+func generationETag(generation string, r *http.Request) string {
+	contentType, _ := negotiate(r.Header.Get("Accept"))
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%t", tenantFrom(r.Context()), r.URL.RawQuery, contentType, wantsPretty(r))
+	return fmt.Sprintf(`W/"%s-%x"`, generation, h.Sum64())
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers a conditional GET or HEAD with 304 when the client's
+// If-None-Match covers etag. The ETag and Vary headers are kept, with Vary
+// always naming Accept since it selects the encoding, and no body is
+// written, so the access log records zero bytes.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	h := w.Header()
+	if !slices.Contains(h.Values("Vary"), "Accept") {
+		h.Add("Vary", "Accept")
+	}
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
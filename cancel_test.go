@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe for a server goroutine to log into
+// while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) snapshot() *bytes.Buffer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.NewBuffer(bytes.Clone(b.buf.Bytes()))
+}
+
+// stallingStore blocks List until its context ends and reports when that
+// happened.
+type stallingStore struct {
+	*memoryStore
+	entered   chan struct{}
+	cancelled chan error
+}
+
+func (s *stallingStore) List(ctx context.Context, q itemQuery) ([]Item, int, error) {
+	close(s.entered)
+	select {
+	case <-ctx.Done():
+		s.cancelled <- ctx.Err()
+		return nil, 0, ctx.Err()
+	case <-time.After(10 * time.Second):
+		s.cancelled <- nil
+		return s.memoryStore.List(ctx, q)
+	}
+}
+
+func TestClientDisconnectCancelsStoreCall(t *testing.T) {
+	var logs lockedBuffer
+	store := &stallingStore{memoryStore: newMemoryStore(), entered: make(chan struct{}), cancelled: make(chan error, 1)}
+	_, h := startTestServer(t, defaultConfig(), slog.New(slog.NewJSONHandler(&logs, nil)), store)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/v1/items", nil)
+	go func() {
+		<-store.entered
+		cancel()
+	}()
+	if _, err := ts.Client().Do(req); err == nil {
+		t.Fatal("request succeeded despite the client giving up")
+	}
+
+	select {
+	case err := <-store.cancelled:
+		if err == nil {
+			t.Fatal("store call ran to completion after the client left")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("store call not cancelled within 2s of the client leaving")
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		lines := logLines(t, logs.snapshot(), "request")
+		if len(lines) == 1 {
+			if lines[0]["status"] != float64(statusClientClosedRequest) || lines[0]["client_disconnected"] != true {
+				t.Errorf("access log = %v, want status 499 with client_disconnected", lines[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no access log line for the abandoned request")
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Checker is a dependency readiness check. Check should return promptly when
+// ctx is done.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// checkRegistry holds the checks consulted by /readyz. Components register
+// themselves at startup; Run may be called concurrently.
+type checkRegistry struct {
+	mu       sync.RWMutex
+	checkers []Checker
+}
+
+func newCheckRegistry() *checkRegistry {
+	return &checkRegistry{}
+}
+
+// Register adds c to the registry.
+func (reg *checkRegistry) Register(c Checker) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.checkers = append(reg.checkers, c)
+}
+
+// Run executes every check concurrently, each bounded by timeout, and returns
+// "ok" or the error text keyed by check name. A check that ignores its
+// context is reported as timed out rather than holding up the result.
+func (reg *checkRegistry) Run(ctx context.Context, timeout time.Duration) (map[string]string, bool) {
+	reg.mu.RLock()
+	checkers := append([]Checker(nil), reg.checkers...)
+	reg.mu.RUnlock()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(checkers))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, c := range checkers {
+		go func() {
+			results <- result{c.Name(), c.Check(ctx)}
+		}()
+	}
+
+	statuses := make(map[string]string, len(checkers))
+	healthy := true
+	for range checkers {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				statuses[res.name] = res.err.Error()
+				healthy = false
+			} else {
+				statuses[res.name] = "ok"
+			}
+		case <-ctx.Done():
+			for _, c := range checkers {
+				if _, done := statuses[c.Name()]; !done {
+					statuses[c.Name()] = "timed out after " + timeout.String()
+				}
+			}
+			return statuses, false
+		}
+	}
+	return statuses, healthy
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// HealthResponse is the body of /healthz and /readyz.
+type HealthResponse struct {
+	Status    string            `json:"status"`
+	Timestamp time.Time         `json:"timestamp"`
+	Version   string            `json:"version"`
+	GitCommit string            `json:"git_commit"`
+	BuildDate string            `json:"build_date"`
+	Uptime    string            `json:"uptime"`
+	Hostname  string            `json:"hostname"`
+	Pod       string            `json:"pod,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	Checks    map[string]string `json:"checks"`
+	Circuits  map[string]string `json:"circuits,omitempty"`
+}
+
+// MessageResponse is the greeting from /.
+type MessageResponse struct {
+	Message    string    `json:"message"`
+	Timestamp  time.Time `json:"timestamp"`
+	APIVersion string    `json:"api_version,omitempty"`
+	Lang       string    `json:"lang,omitempty"`
+	Name       string    `json:"name,omitempty"`
+}
+
+// EchoResponse is POST /api/v1 handing back what it received.
+type EchoResponse struct {
+	Received   map[string]any `json:"received"`
+	Timestamp  time.Time      `json:"timestamp"`
+	APIVersion string         `json:"api_version"`
+}
+
+// Item is a stored item. Version is bumped on every write; pass it back in
+// ItemRequest.Version to update only the version read.
+type Item struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Data      map[string]any `json:"data,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Version   int64          `json:"version"`
+	// DeletedAt is set on soft-deleted items, which only ListItems with
+	// IncludeDeleted returns.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Tenant owns the item; it is empty for the default tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// ItemRequest creates or replaces an item. A non-nil Version makes a
+// replace fail with a 409 APIError (code "version_conflict") if the item
+// has changed since.
+type ItemRequest struct {
+	Name    string         `json:"name"`
+	Data    map[string]any `json:"data,omitempty"`
+	Version *int64         `json:"version,omitempty"`
+}
+
+// ItemList is one page of items.
+type ItemList struct {
+	Items  []Item `json:"items"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// ListOptions filters and pages ListItems; zero values use the server's
+// defaults.
+type ListOptions struct {
+	Limit  int
+	Offset int
+	// Sort is "created_at" or "name", and Order "asc" or "desc".
+	Sort  string
+	Order string
+	// Name keeps items whose name starts with it.
+	Name string
+	// After is an item ID to continue from, for cursor pagination.
+	After string
+	// IncludeDeleted lists soft-deleted items too.
+	IncludeDeleted bool
+}
+
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	if o.Limit > 0 {
+		v.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		v.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.IncludeDeleted {
+		v.Set("include_deleted", "true")
+	}
+	for name, value := range map[string]string{"sort": o.Sort, "order": o.Order, "name": o.Name, "after": o.After} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	return v
+}
+
+// itemsPath is where the items API lives.
+const itemsPath = "/api/v1/items"
+
+// Health calls GET /healthz, the liveness probe.
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	var out HealthResponse
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: "/healthz"}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Hello calls GET /, the greeting.
+func (c *Client) Hello(ctx context.Context) (*MessageResponse, error) {
+	var out MessageResponse
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: "/"}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Echo posts payload, which must encode as a JSON object, to /api/v1 and
+// returns what the server received.
+func (c *Client) Echo(ctx context.Context, payload any) (*EchoResponse, error) {
+	var out EchoResponse
+	if _, err := c.do(ctx, call{method: http.MethodPost, path: "/api/v1", body: payload}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListItems returns one page of items.
+func (c *Client) ListItems(ctx context.Context, opts ListOptions) (*ItemList, error) {
+	var out ItemList
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: itemsPath, query: opts.values()}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetItem returns the item with id; a missing one is an APIError for which
+// IsNotFound is true.
+func (c *Client) GetItem(ctx context.Context, id string) (*Item, error) {
+	var out Item
+	if _, err := c.do(ctx, call{method: http.MethodGet, path: itemsPath + "/" + url.PathEscape(id)}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateItem creates an item. With retries on, it sends an Idempotency-Key
+// so that a retry after a lost response returns the first item rather than
+// creating another.
+func (c *Client) CreateItem(ctx context.Context, req ItemRequest) (*Item, error) {
+	cl := call{method: http.MethodPost, path: itemsPath, body: req}
+	if c.maxRetries > 0 {
+		cl.header = http.Header{idempotencyKeyHeader: {newUUID()}}
+	}
+	var out Item
+	if _, err := c.do(ctx, cl, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateItem replaces the item with id.
+func (c *Client) UpdateItem(ctx context.Context, id string, req ItemRequest) (*Item, error) {
+	var out Item
+	if _, err := c.do(ctx, call{method: http.MethodPut, path: itemsPath + "/" + url.PathEscape(id), body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PatchItem applies a JSON merge patch (RFC 7386) to the item with id, such
+// as {"data": {"color": nil}} to remove one data key. Only name and data
+// may change; a "version" key makes the patch conditional like
+// ItemRequest.Version.
+func (c *Client) PatchItem(ctx context.Context, id string, patch map[string]any) (*Item, error) {
+	var out Item
+	cl := call{method: http.MethodPatch, path: itemsPath + "/" + url.PathEscape(id), body: patch, contentType: "application/merge-patch+json"}
+	if _, err := c.do(ctx, cl, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteItem soft-deletes the item with id, which RestoreItem can undo
+// until the server purges it.
+func (c *Client) DeleteItem(ctx context.Context, id string) error {
+	_, err := c.do(ctx, call{method: http.MethodDelete, path: itemsPath + "/" + url.PathEscape(id)}, nil)
+	return err
+}
+
+// PurgeItem deletes the item with id for good, deleted or not.
+func (c *Client) PurgeItem(ctx context.Context, id string) error {
+	_, err := c.do(ctx, call{method: http.MethodDelete, path: itemsPath + "/" + url.PathEscape(id), query: url.Values{"hard": {"true"}}}, nil)
+	return err
+}
+
+// RestoreItem undoes DeleteItem. An item that was purged is an APIError
+// with code "item_purged".
+func (c *Client) RestoreItem(ctx context.Context, id string) (*Item, error) {
+	var out Item
+	if _, err := c.do(ctx, call{method: http.MethodPost, path: itemsPath + "/" + url.PathEscape(id) + "/restore"}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,308 @@
+// Package client is a typed Go client for the my-go-app HTTP API: the
+// health probe, the greeting, the /api echo, and the items CRUD.
+//
+//	c := client.New("http://localhost:8080", client.WithAPIKey(key), client.WithRetry(3, 200*time.Millisecond))
+//	item, err := c.CreateItem(ctx, client.ItemRequest{Name: "widget"})
+//	var apiErr *client.APIError
+//	if errors.As(err, &apiErr) && apiErr.Code == "validation_failed" { ... }
+//
+// Every request carries an X-Request-ID, taken from the context with
+// WithRequestID or generated, and the same ID is sent on each retry, so the
+// server's logs tie the attempts together.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyHeader         = "X-API-Key"
+	requestIDHeader      = "X-Request-ID"
+	idempotencyKeyHeader = "Idempotency-Key"
+	tenantHeader         = "X-Tenant-ID"
+)
+
+// maxRetryAfter caps how long a Retry-After from the server makes a retry
+// wait, so a misconfigured server can't stall the caller.
+const maxRetryAfter = time.Minute
+
+// Client calls the API at one base URL. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	timeout    time.Duration
+	apiKey     string
+	token      string
+	tenant     string
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout bounds each call, retries included. Zero, the default, leaves
+// only the context's deadline.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithAPIKey authenticates with an X-API-Key header, for servers with
+// API_KEYS.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithBearerToken authenticates with a JWT in the Authorization header.
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTenant sends every call as tenant, in X-Tenant-ID, so it only sees
+// that tenant's items and webhooks.
+func WithTenant(tenant string) Option {
+	return func(c *Client) { c.tenant = tenant }
+}
+
+// WithHTTPClient sends requests through hc instead of a default client,
+// e.g. for TLS settings or a test server's client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetry retries a call up to maxRetries times when the server answers
+// 429, 502, or 503, waiting as its Retry-After says or, without one,
+// backoff doubled on each attempt. Item creation then carries an
+// Idempotency-Key, so a retried create is never applied twice.
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) { c.maxRetries, c.backoff = maxRetries, backoff }
+}
+
+// New returns a client for the API at baseURL, such as
+// "http://localhost:8080". It panics if baseURL doesn't parse, which is a
+// programming error rather than a runtime one.
+func New(baseURL string, opts ...Option) *Client {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		panic(fmt.Sprintf("client: invalid base URL %q: %v", baseURL, err))
+	}
+	c := &Client{baseURL: u, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is a non-2xx answer, decoded from the server's error body. Code
+// is the stable machine-readable error code, e.g. "not_found".
+type APIError struct {
+	StatusCode int
+	Message    string       `json:"error"`
+	Code       string       `json:"code"`
+	Detail     string       `json:"detail,omitempty"`
+	Details    []FieldError `json:"details,omitempty"`
+	RequestID  string       `json:"request_id,omitempty"`
+	// RetryAfter is the server's Retry-After, when it sent one.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// FieldError is one invalid field of a rejected request.
+type FieldError struct {
+	Field      string `json:"field"`
+	Constraint string `json:"constraint,omitempty"`
+	Message    string `json:"message"`
+	Value      any    `json:"value,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// retryable reports whether a call answered with status may be retried.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusBadGateway || status == http.StatusServiceUnavailable
+}
+
+type requestIDKey struct{}
+
+// WithRequestID makes calls with ctx send id as their X-Request-ID, for
+// propagating an incoming request's ID to this one.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the ID set by WithRequestID, if any.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// call is one API request.
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   any
+	// contentType overrides application/json for the body.
+	contentType string
+}
+
+// do sends c, retrying as configured, and decodes a 2xx JSON body into out
+// unless out is nil. It returns the final response's headers.
+func (c *Client) do(ctx context.Context, req call, out any) (http.Header, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	var body []byte
+	if req.body != nil {
+		b, err := json.Marshal(req.body)
+		if err != nil {
+			return nil, fmt.Errorf("client: encode request: %w", err)
+		}
+		body = b
+	}
+	requestID := RequestIDFrom(ctx)
+	if requestID == "" {
+		requestID = newUUID()
+	}
+	u := c.baseURL.JoinPath(req.path)
+	u.RawQuery = req.query.Encode()
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("client: %w", err)
+		}
+		for k, v := range req.header {
+			httpReq.Header[k] = v
+		}
+		httpReq.Header.Set("Accept", "application/json")
+		httpReq.Header.Set(requestIDHeader, requestID)
+		if body != nil {
+			contentType := req.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			httpReq.Header.Set("Content-Type", contentType)
+		} else {
+			httpReq.Body = http.NoBody
+		}
+		if c.apiKey != "" {
+			httpReq.Header.Set(apiKeyHeader, c.apiKey)
+		}
+		if c.token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if c.tenant != "" {
+			httpReq.Header.Set(tenantHeader, c.tenant)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("client: %s %s: %w", req.method, req.path, err)
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			defer resp.Body.Close()
+			if out != nil && resp.StatusCode != http.StatusNoContent {
+				if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+					return resp.Header, fmt.Errorf("client: decode %s %s response: %w", req.method, req.path, err)
+				}
+			}
+			return resp.Header, nil
+		}
+		apiErr := decodeError(resp)
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = requestID
+		}
+		if attempt >= c.maxRetries || !retryable(resp.StatusCode) {
+			return resp.Header, apiErr
+		}
+		wait := backoff
+		if apiErr.RetryAfter > 0 {
+			wait = min(apiErr.RetryAfter, maxRetryAfter)
+		}
+		backoff *= 2
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp.Header, apiErr
+		case <-timer.C:
+		}
+	}
+}
+
+// decodeError reads resp's error body and closes it. Bodies that aren't
+// the server's JSON errors, from a proxy say, keep the status text.
+func decodeError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(raw, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+		apiErr.Detail = strings.TrimSpace(string(raw))
+	}
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	if apiErr.RetryAfter == 0 {
+		// A proxy may have dropped the header; the body repeats it.
+		var body struct {
+			RetryAfterSeconds int `json:"retry_after_seconds"`
+		}
+		if json.Unmarshal(raw, &body) == nil && body.RetryAfterSeconds > 0 {
+			apiErr.RetryAfter = time.Duration(body.RetryAfterSeconds) * time.Second
+		}
+	}
+	return apiErr
+}
+
+// parseRetryAfter reads Retry-After as seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// IsNotFound reports whether err is the API answering 404.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
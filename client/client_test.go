@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers with statuses in turn, then 200 with an empty
+// object, and records each request's headers.
+type flakyServer struct {
+	statuses   []int
+	retryAfter string
+
+	mu      sync.Mutex
+	headers []http.Header
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	attempt := len(s.headers)
+	s.headers = append(s.headers, r.Header.Clone())
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if attempt < len(s.statuses) {
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.WriteHeader(s.statuses[attempt])
+		w.Write([]byte(`{"error": "Unavailable", "code": "unavailable"}`))
+		return
+	}
+	w.Write([]byte(`{"id": "abc", "name": "widget"}`))
+}
+
+func (s *flakyServer) attempts() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headers
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		retries    int
+		wantErr    int
+		wantCalled int
+	}{
+		{"succeeds after retryable errors", []int{503, 429, 502}, 3, 0, 4},
+		{"gives up after max retries", []int{503, 503, 503}, 2, 503, 3},
+		{"no retries by default", []int{503}, 0, 503, 1},
+		{"client errors aren't retried", []int{400}, 3, 400, 1},
+		{"server errors other than 502 and 503 aren't retried", []int{500}, 3, 500, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &flakyServer{statuses: tc.statuses}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+			c := New(ts.URL, WithRetry(tc.retries, time.Millisecond))
+
+			item, err := c.CreateItem(WithRequestID(context.Background(), "retry-test"), ItemRequest{Name: "widget"})
+			var apiErr *APIError
+			switch {
+			case tc.wantErr == 0 && (err != nil || item.ID != "abc"):
+				t.Errorf("CreateItem() = %+v, %v, want the item", item, err)
+			case tc.wantErr != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tc.wantErr):
+				t.Errorf("CreateItem() error = %v, want a %d APIError", err, tc.wantErr)
+			}
+			attempts := srv.attempts()
+			if len(attempts) != tc.wantCalled {
+				t.Fatalf("server saw %d attempts, want %d", len(attempts), tc.wantCalled)
+			}
+			// Every attempt is the same request, to the server's logs and
+			// its idempotency cache.
+			for i, h := range attempts {
+				if h.Get(requestIDHeader) != "retry-test" {
+					t.Errorf("attempt %d X-Request-ID = %q, want the context's", i, h.Get(requestIDHeader))
+				}
+				if key := h.Get(idempotencyKeyHeader); (key == "") != (tc.retries == 0) || key != attempts[0].Get(idempotencyKeyHeader) {
+					t.Errorf("attempt %d Idempotency-Key = %q, want one shared key when retrying", i, key)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	srv := &flakyServer{statuses: []int{429}, retryAfter: "1"}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	start := time.Now()
+	if _, err := New(ts.URL, WithRetry(1, time.Millisecond)).GetItem(context.Background(), "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the server's Retry-After of 1s over the 1ms backoff", elapsed)
+	}
+
+	// A wait past the timeout gives up with the last answer.
+	srv = &flakyServer{statuses: []int{503}, retryAfter: "30"}
+	ts2 := httptest.NewServer(srv)
+	defer ts2.Close()
+	start = time.Now()
+	_, err := New(ts2.URL, WithRetry(1, time.Millisecond), WithTimeout(50*time.Millisecond)).GetItem(context.Background(), "abc")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.RetryAfter != 30*time.Second {
+		t.Errorf("GetItem() error = %v, want the 503 with its Retry-After", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %v, want at the timeout", elapsed)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/items/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"error": "Validation failed", "code": "validation_failed", "details": [{"field": "name", "message": "is required"}], "request_id": "from-server"}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("upstream connect error\n"))
+		}
+	}))
+	defer ts.Close()
+	c := New(ts.URL)
+
+	_, err := c.GetItem(context.Background(), "json")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "validation_failed" || len(apiErr.Details) != 1 || apiErr.Details[0].Field != "name" || apiErr.RequestID != "from-server" {
+		t.Errorf("GetItem() error = %#v, want the decoded error body", err)
+	}
+	_, err = c.GetItem(context.Background(), "proxy")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != "Bad Gateway" || apiErr.Detail != "upstream connect error" || apiErr.RequestID == "" {
+		t.Errorf("GetItem() error = %#v, want the status text, the raw body, and the sent request ID", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"7", 7 * time.Second},
+		{"-3", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	} {
+		if got := parseRetryAfter(tc.value); got != tc.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, want about an hour", future, got)
+	}
+}
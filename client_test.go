@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/example/my-go-app/client"
+)
+
+// TestClient runs the client package against the real handlers.
+func TestClient(t *testing.T) {
+	keys, err := parseAPIKeys([]string{"ci:s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, h := newTestServer(t, func(cfg *Config) { cfg.APIKeys = keys })
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	c := client.New(ts.URL, client.WithAPIKey("s3cret"), client.WithHTTPClient(ts.Client()))
+	ctx := context.Background()
+
+	if health, err := c.Health(ctx); err != nil || health.Status != "healthy" {
+		t.Errorf("Health() = %+v, %v", health, err)
+	}
+	if hello, err := c.Hello(ctx); err != nil || hello.Message == "" {
+		t.Errorf("Hello() = %+v, %v", hello, err)
+	}
+	if echo, err := c.Echo(ctx, map[string]any{"ping": "pong"}); err != nil || echo.Received["ping"] != "pong" {
+		t.Errorf("Echo() = %+v, %v", echo, err)
+	}
+
+	item, err := c.CreateItem(ctx, client.ItemRequest{Name: "widget", Data: map[string]any{"color": "red"}})
+	if err != nil || item.ID == "" || item.Name != "widget" || item.Version != 1 {
+		t.Fatalf("CreateItem() = %+v, %v", item, err)
+	}
+	if got, err := c.GetItem(ctx, item.ID); err != nil || got.Data["color"] != "red" {
+		t.Errorf("GetItem() = %+v, %v", got, err)
+	}
+	updated, err := c.UpdateItem(ctx, item.ID, client.ItemRequest{Name: "gadget", Version: &item.Version})
+	if err != nil || updated.Name != "gadget" || updated.Version != 2 {
+		t.Errorf("UpdateItem() = %+v, %v", updated, err)
+	}
+	var apiErr *client.APIError
+	if _, err := c.UpdateItem(ctx, item.ID, client.ItemRequest{Name: "stale", Version: &item.Version}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || apiErr.Code != codeVersionConflict {
+		t.Errorf("stale UpdateItem() error = %v, want a 409 version_conflict APIError", err)
+	}
+	if patched, err := c.PatchItem(ctx, item.ID, map[string]any{"data": map[string]any{"size": 3}}); err != nil || patched.Data["size"] != float64(3) {
+		t.Errorf("PatchItem() = %+v, %v", patched, err)
+	}
+	if list, err := c.ListItems(ctx, client.ListOptions{Name: "gad", Limit: 5}); err != nil || list.Total != 1 || list.Limit != 5 || list.Items[0].ID != item.ID {
+		t.Errorf("ListItems() = %+v, %v", list, err)
+	}
+	if err := c.DeleteItem(ctx, item.ID); err != nil {
+		t.Errorf("DeleteItem() = %v", err)
+	}
+	if _, err := c.GetItem(ctx, item.ID); !client.IsNotFound(err) {
+		t.Errorf("GetItem() after delete error = %v, want not found", err)
+	}
+	if restored, err := c.RestoreItem(ctx, item.ID); err != nil || restored.DeletedAt != nil {
+		t.Errorf("RestoreItem() = %+v, %v", restored, err)
+	}
+	if err := c.PurgeItem(ctx, item.ID); err != nil {
+		t.Errorf("PurgeItem() = %v", err)
+	}
+
+	// Errors decode into APIError, carrying the propagated request ID.
+	_, err = c.CreateItem(client.WithRequestID(ctx, "client-test-1"), client.ItemRequest{})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Code != codeValidationFailed || len(apiErr.Details) == 0 {
+		t.Fatalf("CreateItem() with no name error = %#v, want a 422 validation_failed APIError", err)
+	}
+	if apiErr.RequestID != "client-test-1" {
+		t.Errorf("APIError.RequestID = %q, want the one from the context", apiErr.RequestID)
+	}
+	if _, err := client.New(ts.URL, client.WithAPIKey("wrong")).ListItems(ctx, client.ListOptions{}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("ListItems() with a bad key error = %v, want 403", err)
+	}
+}
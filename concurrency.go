@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// concurrencyLimit bounds how many requests a route group serves at once.
+// Max of zero means unlimited. Requests over the limit wait up to
+// QueueTimeout for a slot, or are refused at once when it is zero.
+type concurrencyLimit struct {
+	Max          int
+	QueueTimeout time.Duration
+}
+
+// concurrencyLimiter is a semaphore in front of a route group, so a burst
+// of slow requests can exhaust the group without exhausting the pod: probes
+// and metrics are never behind one.
+type concurrencyLimiter struct {
+	group   string
+	limit   concurrencyLimit
+	slots   chan struct{}
+	running atomic.Int64
+	queued  atomic.Int64
+}
+
+func newConcurrencyLimiter(group string, limit concurrencyLimit) *concurrencyLimiter {
+	l := &concurrencyLimiter{group: group, limit: limit}
+	if limit.Max > 0 {
+		l.slots = make(chan struct{}, limit.Max)
+	}
+	return l
+}
+
+// acquire takes a slot, waiting in the queue if the limiter allows it. It
+// returns false when none freed up in time or the request was cancelled.
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.limit.QueueTimeout <= 0 {
+		return false
+	}
+	l.queued.Add(1)
+	concurrencyQueued.WithLabelValues(l.group).Inc()
+	defer func() {
+		l.queued.Add(-1)
+		concurrencyQueued.WithLabelValues(l.group).Dec()
+	}()
+	timer := time.NewTimer(l.limit.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// Middleware applies the limit. Batch operations run inside a request that
+// already holds a slot, so they pass straight through rather than queue
+// behind themselves.
+func (l *concurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	if l.slots == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inBatch(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !l.acquire(r) {
+			if r.Context().Err() != nil {
+				return
+			}
+			concurrencyRejected.WithLabelValues(l.group).Inc()
+			// A slot frees up about as fast as a request could wait for one.
+			writeRetryableError(w, r, http.StatusServiceUnavailable, l.limit.QueueTimeout, codeUnavailable, "Too many concurrent requests",
+				"the "+l.group+" routes are serving their limit of "+strconv.Itoa(l.limit.Max)+" requests")
+			return
+		}
+		l.running.Add(1)
+		concurrencyInFlight.WithLabelValues(l.group).Inc()
+		defer func() {
+			<-l.slots
+			l.running.Add(-1)
+			concurrencyInFlight.WithLabelValues(l.group).Dec()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ConcurrencyStats is one limiter's state in GET /stats.
+type ConcurrencyStats struct {
+	Group    string `json:"group"`
+	Limit    int    `json:"limit"`
+	InFlight int64  `json:"in_flight"`
+	Queued   int64  `json:"queued"`
+}
+
+func (l *concurrencyLimiter) Stats() ConcurrencyStats {
+	return ConcurrencyStats{Group: l.group, Limit: l.limit.Max, InFlight: l.running.Load(), Queued: l.queued.Load()}
+}
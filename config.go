@@ -0,0 +1,1332 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings loaded from the environment at startup.
+type Config struct {
+	// ConfigFile is an optional KEY=VALUE file, such as a mounted
+	// ConfigMap, whose entries override the environment. It is re-read on
+	// SIGHUP and when it changes.
+	ConfigFile string
+
+	// ListenNetwork is "tcp" or "unix". ListenAddr is the socket path for
+	// unix; for tcp it is a host:port that replaces Port when set.
+	// SocketMode sets the Unix socket's file permissions.
+	ListenNetwork string
+	ListenAddr    string
+	SocketMode    os.FileMode
+
+	Port          string
+	Version       string
+	LogLevel      string
+	LogFormat     string
+	LogSkipHealth bool
+	// LogSampleRate logs one in this many successful requests; errors and
+	// requests slower than LogSlowThreshold are always logged.
+	LogSampleRate    int
+	LogSlowThreshold time.Duration
+	// LogExcludePaths are never access logged, like probes with
+	// LogSkipHealth.
+	LogExcludePaths []string
+	// LogFile also receives every log line, and AccessLogFile the access
+	// log lines, both rotated as LogRotation says.
+	LogFile           string
+	AccessLogFile     string
+	LogRotation       logRotation
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	// ShutdownDelay is how long the server keeps serving, with /readyz
+	// failing, between a shutdown signal and closing its listener.
+	ShutdownDelay time.Duration
+
+	// RequestTimeout bounds each request: a handler that hasn't started its
+	// response by then is answered with a 504 and its context cancelled.
+	// Zero disables it. Streaming routes are exempt.
+	RequestTimeout time.Duration
+
+	// ReadinessCheckTimeout bounds each dependency check run by /readyz.
+	ReadinessCheckTimeout time.Duration
+
+	// EventsInterval is the gap between /events heartbeats.
+	EventsInterval time.Duration
+
+	// WarmupTimeout bounds the boot-time warm-up that /readyz waits for;
+	// zero skips it.
+	WarmupTimeout time.Duration
+
+	// DelayMax is the longest sleep /delay/{duration} will honor.
+	DelayMax time.Duration
+
+	// ConcurrencyAPI limits concurrent requests to /api and its versions,
+	// and ConcurrencyDelay to /delay.
+	ConcurrencyAPI   concurrencyLimit
+	ConcurrencyDelay concurrencyLimit
+
+	// TransformMaxRepeat caps repeat on /api/v1/transform, and
+	// TransformMaxBytes the bytes one request may process in total.
+	TransformMaxRepeat int
+	TransformMaxBytes  int64
+
+	// EchoMaxBodyBytes is how much of the request body /echo reflects.
+	// EchoUnsafe disables redaction of credential headers. EchoPreviewBytes
+	// is how much of a binary body POST /api/v1/echo previews.
+	EchoMaxBodyBytes int64
+	EchoUnsafe       bool
+	EchoPreviewBytes int64
+
+	// StrictAccept answers 406 to requests whose Accept header names no
+	// supported encoding instead of falling back to JSON.
+	StrictAccept bool
+
+	// StrictConcurrency answers 428 to item writes (PUT and PATCH) that
+	// carry neither If-Match nor a version, so no client can overwrite a
+	// change it hasn't seen.
+	StrictConcurrency bool
+
+	// TrailingSlash decides what happens to paths that only route once a
+	// trailing slash is dropped or doubled slashes are collapsed: "redirect"
+	// answers 308 to the canonical path, "serve" serves it in place.
+	TrailingSlash string
+
+	// MaxHeaderBytes caps the size of request headers.
+	MaxHeaderBytes int
+
+	// MaxBodyBytes caps the size of request bodies.
+	MaxBodyBytes int64
+
+	// DataFile, when set, persists the items store to this JSON file.
+	DataFile string
+
+	// DatabaseURL, when set, stores items in Postgres instead of memory.
+	DatabaseURL string
+
+	// RedisURL, when set, keeps rate limit buckets and Idempotency-Keys in
+	// Redis so every replica shares them. RedisStore stores items there
+	// too. Keys start with RedisKeyPrefix.
+	RedisURL       string
+	RedisStore     bool
+	RedisKeyPrefix string
+
+	// SessionSecret, when set, gives visitors to / a session cookie signed
+	// with it, counting their visits. Sessions expire SessionTTL after the
+	// last one.
+	SessionSecret string
+	SessionTTL    time.Duration
+
+	// ItemsMaxLimit caps the page size accepted by GET /api/items.
+	ItemsMaxLimit int
+	// DeletedItemRetention is how long a soft-deleted item can be
+	// restored before it is purged; zero keeps deleted items until DELETE
+	// ?hard=true.
+	DeletedItemRetention time.Duration
+	// Tenants and names matching TenantPattern may be given in X-Tenant-ID
+	// to keep items, webhooks, and Idempotency-Keys apart from other
+	// tenants'. Requests without it belong to DefaultTenant unless
+	// MultiTenant requires it.
+	MultiTenant   bool
+	Tenants       []string
+	TenantPattern string
+	DefaultTenant string
+
+	// GzipMinBytes is the smallest response body that gets compressed.
+	GzipMinBytes int
+
+	// RateLimitRPS and RateLimitBurst configure per-client rate limiting;
+	// an RPS of 0 disables it. RateLimitExempt lists paths never limited.
+	RateLimitRPS    float64
+	RateLimitBurst  int
+	RateLimitExempt []string
+
+	// TrustedProxies are the CIDRs whose X-Forwarded-For, Forwarded, and
+	// X-Real-IP headers are believed when identifying clients.
+	TrustedProxies []netip.Prefix
+
+	// APIKeys, when non-empty, are required on the /api routes.
+	APIKeys []apiKey
+
+	// JWTJWKSURL or JWTPublicKey enables bearer token validation on the
+	// /api routes. JWTIssuer and JWTAudience, when set, must match the
+	// token's iss and aud claims.
+	JWTJWKSURL   string
+	JWTPublicKey string
+	JWTIssuer    string
+	JWTAudience  string
+
+	// SigningSecrets, when set, require the /api routes' callers to sign
+	// each request with one of them in X-Signature; SigningMaxSkew, when
+	// positive, also requires a signed X-Signature-Timestamp that close to
+	// the server's clock.
+	SigningSecrets []string
+	SigningMaxSkew time.Duration
+
+	// IdempotencyTTL is how long an Idempotency-Key is remembered, and
+	// IdempotencyMaxKeys how many are kept at once.
+	IdempotencyTTL     time.Duration
+	IdempotencyMaxKeys int
+
+	// FetchAllowedHosts lists the hosts and URL prefixes /fetch may call;
+	// empty refuses every target. FetchTimeout bounds each call,
+	// FetchMaxBodyBytes how much of the body is returned, and
+	// FetchMaxRedirects how many redirects are followed.
+	FetchAllowedHosts hostAllowlist
+	FetchTimeout      time.Duration
+	FetchMaxBodyBytes int
+	FetchMaxRedirects int
+
+	// BackendURL, when set, proxies /backend/* to this base URL and adds its
+	// /healthz to the readiness checks. BackendTimeout bounds the wait for
+	// response headers.
+	BackendURL     string
+	BackendTimeout time.Duration
+
+	// RegistryURL, when set, receives a heartbeat describing the instance
+	// every RegistryInterval, and a deregistration on shutdown.
+	// RegistryTimeout bounds each request.
+	RegistryURL      string
+	RegistryInterval time.Duration
+	RegistryTimeout  time.Duration
+
+	// ShadowURL, when set, receives a copy of ShadowPercent of /api
+	// requests, sent by ShadowWorkers from a queue of ShadowQueueSize and
+	// each bounded by ShadowTimeout.
+	ShadowURL       string
+	ShadowPercent   float64
+	ShadowWorkers   int
+	ShadowQueueSize int
+	ShadowTimeout   time.Duration
+
+	// HTTPClient sets the timeout, connection pool, and retries of every
+	// outbound HTTP call.
+	HTTPClient httpClientConfig
+
+	// UploadDir, when set, enables POST /upload and GET /upload/{name},
+	// storing files there. UploadMaxFileBytes and UploadMaxTotalBytes cap
+	// each file and the whole request; UploadAllowedTypes lists accepted
+	// extensions (".png") and MIME types ("image/*").
+	UploadDir           string
+	UploadMaxFileBytes  int64
+	UploadMaxTotalBytes int64
+	UploadAllowedTypes  []string
+
+	// StaticDir is served under /static/; empty serves the embedded demo
+	// frontend. StaticSPAFallback also serves index.html for unknown paths
+	// under /app/.
+	StaticDir         string
+	StaticSPAFallback bool
+
+	// BatchMaxOperations caps the operations in one POST /api/v1/batch.
+	BatchMaxOperations int
+
+	// ImportMaxBytes caps the body of POST /api/v1/items/import, in place
+	// of MaxBodyBytes.
+	ImportMaxBytes int64
+
+	// WebhookAllowedHosts lists the hosts and URL prefixes webhooks may be
+	// registered for, matched like FetchAllowedHosts; empty refuses every
+	// URL. WebhookTimeout bounds each delivery attempt, WebhookMaxAttempts
+	// caps attempts per event, and WebhookRetryBackoff is the wait before
+	// the first retry, doubling after each.
+	WebhookAllowedHosts hostAllowlist
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookRetryBackoff time.Duration
+
+	// BrokerURL, when set, publishes item change events to NATS (nats://)
+	// or Kafka (kafka://host:port,...). BrokerSubject is the subject or
+	// topic, BrokerBufferSize bounds the events waiting to be sent, and
+	// BrokerTimeout bounds each publish.
+	BrokerURL        string
+	BrokerSubject    string
+	BrokerBufferSize int
+	BrokerTimeout    time.Duration
+
+	// JobWorkers is how many /jobs run at once. JobTimeout bounds each
+	// job and JobRetention is how long finished jobs stay visible.
+	JobWorkers   int
+	JobTimeout   time.Duration
+	JobRetention time.Duration
+
+	// CacheControl maps route paths to the Cache-Control sent on their GET
+	// and HEAD responses.
+	CacheControl map[string]string
+
+	// CircuitBreakerThreshold consecutive failures open the circuit to the
+	// backend or database for CircuitBreakerOpenDuration; zero disables it.
+	CircuitBreakerThreshold    int
+	CircuitBreakerOpenDuration time.Duration
+
+	// ReadOnlyAfterFailures consecutive failed item writes put the items
+	// API in read-only mode until a write probe, every
+	// ReadOnlyProbeInterval, succeeds; zero leaves it to POST
+	// /admin/readonly.
+	ReadOnlyAfterFailures int
+	ReadOnlyProbeInterval time.Duration
+
+	// WSMaxConnections and WSMaxMessageBytes bound the /ws echo endpoint.
+	WSMaxConnections  int
+	WSMaxMessageBytes int64
+
+	// OTLPEndpoint enables OpenTelemetry trace export over OTLP gRPC.
+	OTLPEndpoint string
+
+	// CORSAllowedOrigins lists the origins allowed to make cross-origin
+	// requests; "*" allows any. Empty disables CORS handling.
+	CORSAllowedOrigins []string
+
+	// SecurityHeaders are sent on every response, each unless set empty.
+	SecurityHeaders securityHeaders
+
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set.
+	// TLSClientCAFile additionally requires verified client certificates
+	// (mTLS) on the /api routes.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	// EnableH2C serves HTTP/2 without TLS (h2c) alongside HTTP/1.1 on the
+	// main port, for meshes that speak HTTP/2 to upstreams in plaintext.
+	EnableH2C bool
+
+	// EnablePprof registers the /debug/pprof/ profiling handlers.
+	EnablePprof bool
+
+	// AdminPort, when set, starts a second listener for the probes,
+	// /metrics, /debug, and /admin endpoints, which then leave the public
+	// port.
+	AdminPort string
+
+	// GRPCPort, when set, starts a gRPC listener with the grpc.health.v1
+	// and demo.echo.v1 services.
+	GRPCPort string
+
+	// EnableAdmin serves POST /admin/shutdown and POST /admin/panic on the
+	// admin port, for demoing rolling updates and crash recovery.
+	EnableAdmin bool
+	// DumpDir is where POST /admin/dump and the automatic triggers write
+	// profiles. MemDumpThreshold (bytes) and SlowDumpThreshold dump on
+	// their own when memory use or a request crosses them, at most once per
+	// DumpCooldown; zero disables each. All need EnableAdmin.
+	DumpDir           string
+	MemDumpThreshold  int64
+	SlowDumpThreshold time.Duration
+	DumpCooldown      time.Duration
+	// Maintenance answers everything but the probes and operational
+	// endpoints with 503, MaintenanceMessage, and a Retry-After of
+	// MaintenanceRetryAfter. MaintenanceFailReadiness fails /readyz too.
+	// POST /admin/maintenance switches it at runtime.
+	Maintenance              bool
+	MaintenanceMessage       string
+	MaintenanceRetryAfter    time.Duration
+	MaintenanceFailReadiness bool
+	// LoadTest caps POST /admin/loadtest runs and sets where their load
+	// goes: LOADTEST_TARGET_URL, or this server.
+	LoadTest loadTestConfig
+	// EnableRecorder keeps the last RecorderSize requests and their
+	// responses, bodies cut off at RecorderMaxBodyBytes, for GET
+	// /debug/requests and replay.
+	EnableRecorder       bool
+	RecorderSize         int
+	RecorderMaxBodyBytes int64
+	// AuditSize is how many audit entries GET /api/v1/audit can return;
+	// AuditFile, when set, also gets every entry appended as a JSON line.
+	AuditSize int
+	AuditFile string
+
+	// DebugEndpoints enables diagnostic routes that must never be exposed in
+	// production, such as /debug/panic.
+	DebugEndpoints bool
+
+	// EnvRedactPatterns are name substrings whose values /debug/env hides.
+	// EnvExpose, when set, restricts /debug/env to exactly these names.
+	EnvRedactPatterns []string
+	EnvExpose         []string
+
+	// Features holds each feature flag's value and source, from
+	// FEATURE_<NAME> variables over the defaults in featureFlags.
+	Features map[string]flagSetting
+
+	// ShowVersion is set by the -version flag; main prints the version and
+	// exits instead of starting the server.
+	ShowVersion bool
+	// SelfTest is set by the -selftest flag; main runs runSelfTest and
+	// exits with its result instead of serving.
+	SelfTest bool
+}
+
+// defaultConfig returns the settings used when no environment overrides are
+// present.
+func defaultConfig() Config {
+	return Config{
+		Port:                  "8080",
+		ListenNetwork:         "tcp",
+		SocketMode:            0o660,
+		Version:               readBuildInfo().Version,
+		LogLevel:              "info",
+		LogFormat:             "json",
+		LogSampleRate:         1,
+		LogSlowThreshold:      time.Second,
+		LogRotation:           logRotation{MaxSize: 100 << 20, MaxBackups: 5},
+		TrailingSlash:         trailingSlashRedirect,
+		ReadTimeout:           15 * time.Second,
+		ReadHeaderTimeout:     5 * time.Second,
+		WriteTimeout:          15 * time.Second,
+		IdleTimeout:           60 * time.Second,
+		ShutdownTimeout:       15 * time.Second,
+		RequestTimeout:        10 * time.Second,
+		ReadinessCheckTimeout: 2 * time.Second,
+		EventsInterval:        2 * time.Second,
+		DelayMax:              30 * time.Second,
+		WarmupTimeout:         10 * time.Second,
+		DumpDir:               "/tmp/dumps",
+		DumpCooldown:          5 * time.Minute,
+		MaintenanceRetryAfter: 120 * time.Second,
+		LoadTest:              loadTestConfig{MaxRPS: 200, MaxDuration: 5 * time.Minute, MaxConcurrency: 50},
+		RecorderSize:          100,
+		AuditSize:             1000,
+		RecorderMaxBodyBytes:  64 << 10,
+		TransformMaxRepeat:    10000,
+		TransformMaxBytes:     256 << 20,
+		EchoMaxBodyBytes:      64 << 10,
+		EchoPreviewBytes:      256,
+		EnvRedactPatterns:     []string{"PASSWORD", "SECRET", "TOKEN", "KEY"},
+		MaxHeaderBytes:        1 << 20,
+		MaxBodyBytes:          1 << 20,
+		GzipMinBytes:          1024,
+		ItemsMaxLimit:         100,
+		DeletedItemRetention:  7 * 24 * time.Hour,
+		DefaultTenant:         "default",
+		RateLimitBurst:        20,
+		RateLimitExempt:       []string{"/healthz", "/readyz", "/health", "/metrics"},
+		IdempotencyTTL:        24 * time.Hour,
+		IdempotencyMaxKeys:    10000,
+		RedisKeyPrefix:        "my-go-app:",
+		FetchTimeout:          5 * time.Second,
+		FetchMaxBodyBytes:     4096,
+		FetchMaxRedirects:     3,
+		BackendTimeout:        10 * time.Second,
+		RegistryInterval:      15 * time.Second,
+		RegistryTimeout:       5 * time.Second,
+		ShadowPercent:         100,
+		ShadowWorkers:         4,
+		ShadowQueueSize:       100,
+		ShadowTimeout:         5 * time.Second,
+		CacheControl:          defaultCacheControl(),
+		StaticSPAFallback:     true,
+		BatchMaxOperations:    100,
+		ImportMaxBytes:        32 << 20,
+		WebhookTimeout:        5 * time.Second,
+		WebhookMaxAttempts:    5,
+		WebhookRetryBackoff:   time.Second,
+		BrokerSubject:         "items.events",
+		BrokerBufferSize:      1000,
+		BrokerTimeout:         5 * time.Second,
+		JobWorkers:            2,
+		JobTimeout:            5 * time.Minute,
+		JobRetention:          time.Hour,
+		SessionTTL:            24 * time.Hour,
+		SigningMaxSkew:        5 * time.Minute,
+		HTTPClient: httpClientConfig{
+			Timeout:             30 * time.Second,
+			MaxIdleConnsPerHost: 10,
+			MaxConnsPerHost:     100,
+			MaxRetries:          2,
+			RetryBackoff:        100 * time.Millisecond,
+			RetryBudget:         3 * time.Second,
+		},
+		SecurityHeaders: securityHeaders{
+			ContentTypeOptions: defaultContentTypeOptions,
+			FrameOptions:       defaultFrameOptions,
+			ReferrerPolicy:     defaultReferrerPolicy,
+			HSTS:               defaultHSTS,
+			CSP:                defaultCSP,
+		},
+		UploadMaxFileBytes:         10 << 20,
+		UploadMaxTotalBytes:        32 << 20,
+		UploadAllowedTypes:         []string{".txt", ".json", ".csv", ".png", ".jpg", ".jpeg", ".gif", ".pdf"},
+		CircuitBreakerThreshold:    5,
+		CircuitBreakerOpenDuration: 30 * time.Second,
+		ReadOnlyAfterFailures:      5,
+		ReadOnlyProbeInterval:      10 * time.Second,
+		WSMaxConnections:           100,
+		WSMaxMessageBytes:          64 << 10,
+		Features:                   defaultFlagSettings(),
+	}
+}
+
+// LoadConfig reads the configuration from the process environment and the
+// given command-line arguments (normally os.Args[1:]).
+func LoadConfig(args []string) (Config, error) {
+	return loadConfig(os.LookupEnv, args)
+}
+
+// loadConfig builds a Config with precedence flags > CONFIG_FILE > env >
+// defaults and validates the result. It takes the env lookup function as a
+// parameter so callers can supply a fixed environment. A -help request is
+// reported as flag.ErrHelp.
+func loadConfig(lookupEnv func(string) (string, bool), args []string) (Config, error) {
+	path, _ := lookupEnv("CONFIG_FILE")
+	var values map[string]string
+	if path != "" {
+		var err error
+		values, err = readConfigFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid CONFIG_FILE: %w", err)
+		}
+		env := lookupEnv
+		lookupEnv = func(name string) (string, bool) {
+			if v, ok := values[name]; ok {
+				return v, true
+			}
+			return env(name)
+		}
+	}
+	cfg, err := configFromEnv(lookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ConfigFile = path
+	for name, setting := range cfg.Features {
+		if _, ok := values[featureEnvName(name)]; ok {
+			setting.Source = flagSourceFile
+			cfg.Features[name] = setting
+		}
+	}
+	if err := cfg.parseFlags(args); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// readConfigFile parses an env file: KEY=VALUE lines, optionally prefixed
+// with "export" and with the value in matching quotes. Blank lines and lines
+// starting with # are ignored.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// configFromEnv applies environment overrides on top of the defaults.
+func configFromEnv(lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := defaultConfig()
+
+	if v, ok := lookupEnv("PORT"); ok && v != "" {
+		cfg.Port = v
+	}
+	if v, ok := lookupEnv("VERSION"); ok && v != "" {
+		cfg.Version = v
+	}
+	if v, ok := lookupEnv("LOG_LEVEL"); ok && v != "" {
+		cfg.LogLevel = v
+	}
+	if v, ok := lookupEnv("LOG_FORMAT"); ok && v != "" {
+		cfg.LogFormat = v
+	} else if isTerminal(os.Stdout) {
+		// Someone is watching: default to the format meant for people.
+		cfg.LogFormat = "dev"
+	}
+	strs := []struct {
+		name string
+		dest *string
+	}{
+		{"LISTEN_NETWORK", &cfg.ListenNetwork},
+		{"LISTEN_ADDR", &cfg.ListenAddr},
+		{"TRAILING_SLASH", &cfg.TrailingSlash},
+		{"ADMIN_PORT", &cfg.AdminPort},
+		{"GRPC_PORT", &cfg.GRPCPort},
+		{"TLS_CERT_FILE", &cfg.TLSCertFile},
+		{"TLS_KEY_FILE", &cfg.TLSKeyFile},
+		{"TLS_CLIENT_CA_FILE", &cfg.TLSClientCAFile},
+		{"JWT_JWKS_URL", &cfg.JWTJWKSURL},
+		{"JWT_PUBLIC_KEY", &cfg.JWTPublicKey},
+		{"JWT_ISSUER", &cfg.JWTIssuer},
+		{"JWT_AUDIENCE", &cfg.JWTAudience},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", &cfg.OTLPEndpoint},
+		{"DATA_FILE", &cfg.DataFile},
+		{"LOG_FILE", &cfg.LogFile},
+		{"DUMP_DIR", &cfg.DumpDir},
+		{"AUDIT_FILE", &cfg.AuditFile},
+		{"LOADTEST_TARGET_URL", &cfg.LoadTest.TargetURL},
+		{"MAINTENANCE_MESSAGE", &cfg.MaintenanceMessage},
+		{"ACCESS_LOG_FILE", &cfg.AccessLogFile},
+		{"DATABASE_URL", &cfg.DatabaseURL},
+		{"REDIS_URL", &cfg.RedisURL},
+		{"REDIS_KEY_PREFIX", &cfg.RedisKeyPrefix},
+		{"SESSION_SECRET", &cfg.SessionSecret},
+		{"BACKEND_URL", &cfg.BackendURL},
+		{"REGISTRY_URL", &cfg.RegistryURL},
+		{"SHADOW_URL", &cfg.ShadowURL},
+		{"BROKER_URL", &cfg.BrokerURL},
+		{"BROKER_SUBJECT", &cfg.BrokerSubject},
+		{"TENANT_PATTERN", &cfg.TenantPattern},
+		{"DEFAULT_TENANT", &cfg.DefaultTenant},
+		{"UPLOAD_DIR", &cfg.UploadDir},
+		{"STATIC_DIR", &cfg.StaticDir},
+		{"X_CONTENT_TYPE_OPTIONS", &cfg.SecurityHeaders.ContentTypeOptions},
+		{"X_FRAME_OPTIONS", &cfg.SecurityHeaders.FrameOptions},
+		{"REFERRER_POLICY", &cfg.SecurityHeaders.ReferrerPolicy},
+		{"STRICT_TRANSPORT_SECURITY", &cfg.SecurityHeaders.HSTS},
+		{"CONTENT_SECURITY_POLICY", &cfg.SecurityHeaders.CSP},
+	}
+	for _, s := range strs {
+		if v, ok := lookupEnv(s.name); ok {
+			*s.dest = v
+		}
+	}
+	if v, ok := lookupEnv("MAX_HEADER_BYTES"); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid MAX_HEADER_BYTES %q: %w", v, err)
+		}
+		cfg.MaxHeaderBytes = n
+	}
+	int64s := []struct {
+		name string
+		dest *int64
+	}{
+		{"MAX_BODY_BYTES", &cfg.MaxBodyBytes},
+		{"ECHO_MAX_BODY_BYTES", &cfg.EchoMaxBodyBytes},
+		{"ECHO_PREVIEW_BYTES", &cfg.EchoPreviewBytes},
+		{"RECORDER_MAX_BODY_BYTES", &cfg.RecorderMaxBodyBytes},
+		{"IMPORT_MAX_BYTES", &cfg.ImportMaxBytes},
+		{"LOG_FILE_MAX_SIZE", &cfg.LogRotation.MaxSize},
+		{"MEM_DUMP_THRESHOLD", &cfg.MemDumpThreshold},
+		{"WS_MAX_MESSAGE_BYTES", &cfg.WSMaxMessageBytes},
+		{"TRANSFORM_MAX_BYTES", &cfg.TransformMaxBytes},
+		{"UPLOAD_MAX_FILE_BYTES", &cfg.UploadMaxFileBytes},
+		{"UPLOAD_MAX_TOTAL_BYTES", &cfg.UploadMaxTotalBytes},
+	}
+	for _, i := range int64s {
+		v, ok := lookupEnv(i.name)
+		if !ok || v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s %q: %w", i.name, v, err)
+		}
+		*i.dest = n
+	}
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"LOG_SAMPLE_RATE", &cfg.LogSampleRate},
+		{"LOG_FILE_MAX_BACKUPS", &cfg.LogRotation.MaxBackups},
+		{"LOADTEST_MAX_RPS", &cfg.LoadTest.MaxRPS},
+		{"LOADTEST_MAX_CONCURRENCY", &cfg.LoadTest.MaxConcurrency},
+		{"RECORDER_SIZE", &cfg.RecorderSize},
+		{"AUDIT_SIZE", &cfg.AuditSize},
+		{"HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST", &cfg.HTTPClient.MaxIdleConnsPerHost},
+		{"HTTP_CLIENT_MAX_CONNS_PER_HOST", &cfg.HTTPClient.MaxConnsPerHost},
+		{"HTTP_CLIENT_MAX_RETRIES", &cfg.HTTPClient.MaxRetries},
+		{"MAX_CONCURRENT_API", &cfg.ConcurrencyAPI.Max},
+		{"MAX_CONCURRENT_DELAY", &cfg.ConcurrencyDelay.Max},
+		{"GZIP_MIN_BYTES", &cfg.GzipMinBytes},
+		{"ITEMS_MAX_LIMIT", &cfg.ItemsMaxLimit},
+		{"RATE_LIMIT_BURST", &cfg.RateLimitBurst},
+		{"IDEMPOTENCY_MAX_KEYS", &cfg.IdempotencyMaxKeys},
+		{"FETCH_MAX_BODY_BYTES", &cfg.FetchMaxBodyBytes},
+		{"FETCH_MAX_REDIRECTS", &cfg.FetchMaxRedirects},
+		{"CIRCUIT_BREAKER_THRESHOLD", &cfg.CircuitBreakerThreshold},
+		{"READ_ONLY_AFTER_FAILURES", &cfg.ReadOnlyAfterFailures},
+		{"SHADOW_WORKERS", &cfg.ShadowWorkers},
+		{"SHADOW_QUEUE_SIZE", &cfg.ShadowQueueSize},
+		{"WS_MAX_CONNECTIONS", &cfg.WSMaxConnections},
+		{"BATCH_MAX_OPERATIONS", &cfg.BatchMaxOperations},
+		{"TRANSFORM_MAX_REPEAT", &cfg.TransformMaxRepeat},
+		{"WEBHOOK_MAX_ATTEMPTS", &cfg.WebhookMaxAttempts},
+		{"BROKER_BUFFER_SIZE", &cfg.BrokerBufferSize},
+		{"JOB_WORKERS", &cfg.JobWorkers},
+	}
+	for _, i := range ints {
+		v, ok := lookupEnv(i.name)
+		if !ok || v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s %q: %w", i.name, v, err)
+		}
+		*i.dest = n
+	}
+	if v, ok := lookupEnv("RATE_LIMIT_RPS"); ok && v != "" {
+		rps, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid RATE_LIMIT_RPS %q: %w", v, err)
+		}
+		cfg.RateLimitRPS = rps
+	}
+	if v, ok := lookupEnv("SHADOW_PERCENT"); ok && v != "" {
+		percent, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid SHADOW_PERCENT %q: %w", v, err)
+		}
+		cfg.ShadowPercent = percent
+	}
+	if v, ok := lookupEnv("LOG_EXCLUDE_PATHS"); ok {
+		cfg.LogExcludePaths = splitList(v)
+	}
+	if v, ok := lookupEnv("RATE_LIMIT_EXEMPT"); ok {
+		cfg.RateLimitExempt = splitList(v)
+	}
+	if v, ok := lookupEnv("TRUSTED_PROXIES"); ok {
+		for _, cidr := range splitList(v) {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", cidr, err)
+			}
+			cfg.TrustedProxies = append(cfg.TrustedProxies, prefix.Masked())
+		}
+	}
+	if v, ok := lookupEnv("SOCKET_MODE"); ok && v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid SOCKET_MODE %q: must be octal, e.g. 0660", v)
+		}
+		cfg.SocketMode = os.FileMode(mode)
+	}
+	if v, ok := lookupEnv("ENV_REDACT_PATTERNS"); ok {
+		cfg.EnvRedactPatterns = splitList(v)
+	}
+	if v, ok := lookupEnv("ENV_EXPOSE"); ok {
+		cfg.EnvExpose = splitList(v)
+	}
+	if v, ok := lookupEnv("API_KEYS"); ok {
+		keys, err := parseAPIKeys(splitList(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid API_KEYS: %w", err)
+		}
+		cfg.APIKeys = keys
+	}
+	if v, ok := lookupEnv("SIGNING_SECRET"); ok {
+		cfg.SigningSecrets = splitList(v)
+	}
+	if v, ok := lookupEnv("CACHE_CONTROL"); ok {
+		if err := parseCacheControl(v, cfg.CacheControl); err != nil {
+			return Config{}, fmt.Errorf("invalid CACHE_CONTROL: %w", err)
+		}
+	}
+	if v, ok := lookupEnv("UPLOAD_ALLOWED_TYPES"); ok {
+		cfg.UploadAllowedTypes = splitList(v)
+	}
+	if v, ok := lookupEnv("FETCH_ALLOWED_HOSTS"); ok {
+		allowed, err := parseHostAllowlist(splitList(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid FETCH_ALLOWED_HOSTS: %w", err)
+		}
+		cfg.FetchAllowedHosts = allowed
+	}
+	if v, ok := lookupEnv("WEBHOOK_ALLOWED_HOSTS"); ok {
+		allowed, err := parseHostAllowlist(splitList(v))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid WEBHOOK_ALLOWED_HOSTS: %w", err)
+		}
+		cfg.WebhookAllowedHosts = allowed
+	}
+	if v, ok := lookupEnv("TENANTS"); ok {
+		cfg.Tenants = splitList(v)
+	}
+	if v, ok := lookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		cfg.CORSAllowedOrigins = splitList(v)
+	}
+
+	bools := []struct {
+		name string
+		dest *bool
+	}{
+		{"LOG_SKIP_HEALTH", &cfg.LogSkipHealth},
+		{"ENABLE_DEBUG_ENDPOINTS", &cfg.DebugEndpoints},
+		{"ENABLE_PPROF", &cfg.EnablePprof},
+		{"ENABLE_H2C", &cfg.EnableH2C},
+		{"ENABLE_ADMIN", &cfg.EnableAdmin},
+		{"ENABLE_RECORDER", &cfg.EnableRecorder},
+		{"ECHO_UNSAFE", &cfg.EchoUnsafe},
+		{"STRICT_ACCEPT", &cfg.StrictAccept},
+		{"STRICT_CONCURRENCY", &cfg.StrictConcurrency},
+		{"STATIC_SPA_FALLBACK", &cfg.StaticSPAFallback},
+		{"REDIS_STORE", &cfg.RedisStore},
+		{"MULTI_TENANT", &cfg.MultiTenant},
+		{"MAINTENANCE", &cfg.Maintenance},
+		{"MAINTENANCE_FAIL_READINESS", &cfg.MaintenanceFailReadiness},
+	}
+	for _, b := range bools {
+		v, ok := lookupEnv(b.name)
+		if !ok || v == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s %q: %w", b.name, v, err)
+		}
+		*b.dest = parsed
+	}
+
+	for _, f := range featureFlags {
+		name := featureEnvName(f.name)
+		v, ok := lookupEnv(name)
+		if !ok || v == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s %q: %w", name, v, err)
+		}
+		cfg.Features[f.name] = flagSetting{Enabled: on, Source: flagSourceEnv}
+	}
+
+	durations := []struct {
+		name string
+		dest *time.Duration
+	}{
+		{"LOG_SLOW_THRESHOLD", &cfg.LogSlowThreshold},
+		{"WARMUP_TIMEOUT", &cfg.WarmupTimeout},
+		{"SLOW_DUMP_THRESHOLD", &cfg.SlowDumpThreshold},
+		{"DUMP_COOLDOWN", &cfg.DumpCooldown},
+		{"LOADTEST_MAX_DURATION", &cfg.LoadTest.MaxDuration},
+		{"MAINTENANCE_RETRY_AFTER", &cfg.MaintenanceRetryAfter},
+		{"LOG_FILE_MAX_AGE", &cfg.LogRotation.MaxAge},
+		{"QUEUE_TIMEOUT_API", &cfg.ConcurrencyAPI.QueueTimeout},
+		{"QUEUE_TIMEOUT_DELAY", &cfg.ConcurrencyDelay.QueueTimeout},
+		{"READ_TIMEOUT", &cfg.ReadTimeout},
+		{"READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout},
+		{"WRITE_TIMEOUT", &cfg.WriteTimeout},
+		{"IDLE_TIMEOUT", &cfg.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout},
+		{"SHUTDOWN_DELAY", &cfg.ShutdownDelay},
+		{"REQUEST_TIMEOUT", &cfg.RequestTimeout},
+		{"READINESS_CHECK_TIMEOUT", &cfg.ReadinessCheckTimeout},
+		{"EVENTS_INTERVAL", &cfg.EventsInterval},
+		{"DELAY_MAX", &cfg.DelayMax},
+		{"IDEMPOTENCY_TTL", &cfg.IdempotencyTTL},
+		{"FETCH_TIMEOUT", &cfg.FetchTimeout},
+		{"WEBHOOK_TIMEOUT", &cfg.WebhookTimeout},
+		{"WEBHOOK_RETRY_BACKOFF", &cfg.WebhookRetryBackoff},
+		{"BROKER_TIMEOUT", &cfg.BrokerTimeout},
+		{"JOB_TIMEOUT", &cfg.JobTimeout},
+		{"JOB_RETENTION", &cfg.JobRetention},
+		{"DELETED_ITEM_RETENTION", &cfg.DeletedItemRetention},
+		{"BACKEND_TIMEOUT", &cfg.BackendTimeout},
+		{"REGISTRY_INTERVAL", &cfg.RegistryInterval},
+		{"REGISTRY_TIMEOUT", &cfg.RegistryTimeout},
+		{"SHADOW_TIMEOUT", &cfg.ShadowTimeout},
+		{"HTTP_CLIENT_TIMEOUT", &cfg.HTTPClient.Timeout},
+		{"HTTP_CLIENT_RETRY_BACKOFF", &cfg.HTTPClient.RetryBackoff},
+		{"HTTP_CLIENT_RETRY_BUDGET", &cfg.HTTPClient.RetryBudget},
+		{"SESSION_TTL", &cfg.SessionTTL},
+		{"SIGNING_MAX_SKEW", &cfg.SigningMaxSkew},
+		{"CIRCUIT_BREAKER_OPEN_DURATION", &cfg.CircuitBreakerOpenDuration},
+		{"READ_ONLY_PROBE_INTERVAL", &cfg.ReadOnlyProbeInterval},
+	}
+	for _, d := range durations {
+		v, ok := lookupEnv(d.name)
+		if !ok || v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s %q: %w", d.name, v, err)
+		}
+		*d.dest = parsed
+	}
+	return cfg, nil
+}
+
+// parseFlags overrides cfg with any command-line flags present in args. Flag
+// defaults are the current (env-derived) values, so unset flags leave them
+// untouched.
+func (cfg *Config) parseFlags(args []string) error {
+	fs := flag.NewFlagSet("my-go-app", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: my-go-app [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Flags take precedence over the matching environment variables.\n\n")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&cfg.Port, "port", cfg.Port, "port to listen on (env PORT)")
+	fs.StringVar(&cfg.ListenNetwork, "listen-network", cfg.ListenNetwork, "tcp or unix (env LISTEN_NETWORK)")
+	fs.StringVar(&cfg.ListenAddr, "listen-addr", cfg.ListenAddr, "socket path for unix, or host:port overriding -port for tcp (env LISTEN_ADDR)")
+	fs.StringVar(&cfg.Version, "app-version", cfg.Version, "version reported by the health endpoints (env VERSION)")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn, error (env LOG_LEVEL)")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: json, text, or dev (env LOG_FORMAT; default dev on a terminal, else json)")
+	fs.BoolVar(&cfg.LogSkipHealth, "log-skip-health", cfg.LogSkipHealth, "omit probe requests from access logs (env LOG_SKIP_HEALTH)")
+	fs.IntVar(&cfg.LogSampleRate, "log-sample-rate", cfg.LogSampleRate, "access log one in N successful requests (env LOG_SAMPLE_RATE)")
+	fs.DurationVar(&cfg.LogSlowThreshold, "log-slow-threshold", cfg.LogSlowThreshold, "always access log requests at least this slow (env LOG_SLOW_THRESHOLD)")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "maximum duration for reading a request (env READ_TIMEOUT)")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", cfg.ReadHeaderTimeout, "maximum duration for reading request headers (env READ_HEADER_TIMEOUT)")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "maximum duration for writing a response (env WRITE_TIMEOUT)")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "maximum keep-alive idle time (env IDLE_TIMEOUT)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "drain window for in-flight requests on shutdown (env SHUTDOWN_TIMEOUT)")
+	fs.DurationVar(&cfg.ShutdownDelay, "shutdown-delay", cfg.ShutdownDelay, "keep serving this long after a shutdown signal, with /readyz failing, before draining (env SHUTDOWN_DELAY)")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", cfg.RequestTimeout, "answer 504 if a handler hasn't responded within this long; 0 disables (env REQUEST_TIMEOUT)")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", cfg.MaxHeaderBytes, "maximum request header size in bytes (env MAX_HEADER_BYTES)")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", cfg.MaxBodyBytes, "maximum request body size in bytes (env MAX_BODY_BYTES)")
+	fs.IntVar(&cfg.GzipMinBytes, "gzip-min-bytes", cfg.GzipMinBytes, "smallest response body to compress (env GZIP_MIN_BYTES)")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", cfg.TLSCertFile, "TLS certificate file (env TLS_CERT_FILE)")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "TLS private key file (env TLS_KEY_FILE)")
+	fs.StringVar(&cfg.TLSClientCAFile, "tls-client-ca", cfg.TLSClientCAFile, "CA bundle for verifying client certificates on /api (env TLS_CLIENT_CA_FILE)")
+	fs.BoolVar(&cfg.EnableH2C, "h2c", cfg.EnableH2C, "serve plaintext HTTP/2 (h2c) alongside HTTP/1.1 (env ENABLE_H2C)")
+	fs.StringVar(&cfg.DataFile, "data-file", cfg.DataFile, "JSON file to persist items to (env DATA_FILE)")
+	fs.BoolVar(&cfg.EnablePprof, "pprof", cfg.EnablePprof, "enable /debug/pprof/ profiling endpoints (env ENABLE_PPROF)")
+	fs.StringVar(&cfg.AdminPort, "admin-port", cfg.AdminPort, "separate port for probes, metrics, debug, and admin endpoints (env ADMIN_PORT)")
+	fs.StringVar(&cfg.GRPCPort, "grpc-port", cfg.GRPCPort, "port for the gRPC health and echo services (env GRPC_PORT)")
+	fs.BoolVar(&cfg.EnableAdmin, "admin", cfg.EnableAdmin, "enable POST /admin/shutdown and /admin/panic on the admin port (env ENABLE_ADMIN)")
+	fs.BoolVar(&cfg.DebugEndpoints, "debug-endpoints", cfg.DebugEndpoints, "enable diagnostic /debug routes (env ENABLE_DEBUG_ENDPOINTS)")
+	fs.BoolVar(&cfg.ShowVersion, "version", false, "print the version and exit")
+	fs.BoolVar(&cfg.SelfTest, "selftest", false, "check the binary against a loopback server, print a JSON report, and exit 0 if every check passed")
+
+	return fs.Parse(args)
+}
+
+// jwtEnabled reports whether bearer tokens are validated as JWTs.
+func (cfg Config) jwtEnabled() bool {
+	return cfg.JWTJWKSURL != "" || cfg.JWTPublicKey != ""
+}
+
+// Validate reports the first invalid setting in cfg.
+func (cfg Config) Validate() error {
+	if !validPort(cfg.Port) {
+		return fmt.Errorf("invalid PORT %q: must be a number between 1 and 65535", cfg.Port)
+	}
+	switch cfg.ListenNetwork {
+	case "tcp":
+		if cfg.ListenAddr != "" {
+			if _, port, err := net.SplitHostPort(cfg.ListenAddr); err != nil || !validPort(port) {
+				return fmt.Errorf("invalid LISTEN_ADDR %q: must be host:port for tcp", cfg.ListenAddr)
+			}
+		}
+	case "unix":
+		if cfg.ListenAddr == "" {
+			return fmt.Errorf("invalid LISTEN_ADDR: a socket path is required when LISTEN_NETWORK is unix")
+		}
+	default:
+		return fmt.Errorf("invalid LISTEN_NETWORK %q: must be tcp or unix", cfg.ListenNetwork)
+	}
+	if cfg.SocketMode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid SOCKET_MODE %#o: must be at most 0777", cfg.SocketMode)
+	}
+	if cfg.AdminPort != "" {
+		if !validPort(cfg.AdminPort) {
+			return fmt.Errorf("invalid ADMIN_PORT %q: must be a number between 1 and 65535", cfg.AdminPort)
+		}
+		if _, port, _ := net.SplitHostPort(cfg.listenAddr()); cfg.ListenNetwork == "tcp" && cfg.AdminPort == port {
+			return fmt.Errorf("invalid ADMIN_PORT %q: must differ from PORT", cfg.AdminPort)
+		}
+	}
+	if cfg.GRPCPort != "" {
+		if !validPort(cfg.GRPCPort) {
+			return fmt.Errorf("invalid GRPC_PORT %q: must be a number between 1 and 65535", cfg.GRPCPort)
+		}
+		if _, port, _ := net.SplitHostPort(cfg.listenAddr()); cfg.ListenNetwork == "tcp" && cfg.GRPCPort == port {
+			return fmt.Errorf("invalid GRPC_PORT %q: must differ from PORT", cfg.GRPCPort)
+		}
+		if cfg.GRPCPort == cfg.AdminPort {
+			return fmt.Errorf("invalid GRPC_PORT %q: must differ from ADMIN_PORT", cfg.GRPCPort)
+		}
+	}
+
+	if cfg.EnableAdmin {
+		if cfg.AdminPort == "" {
+			return fmt.Errorf("invalid ENABLE_ADMIN: requires ADMIN_PORT, since process control is never served on PORT")
+		}
+		if len(cfg.APIKeys) == 0 && !cfg.jwtEnabled() {
+			return fmt.Errorf("invalid ENABLE_ADMIN: requires API_KEYS or JWT authentication")
+		}
+		if cfg.DumpDir == "" {
+			return fmt.Errorf("invalid DUMP_DIR: must be set with ENABLE_ADMIN")
+		}
+	}
+	if cfg.MemDumpThreshold < 0 {
+		return fmt.Errorf("invalid MEM_DUMP_THRESHOLD %d: must not be negative", cfg.MemDumpThreshold)
+	}
+	if cfg.SlowDumpThreshold < 0 {
+		return fmt.Errorf("invalid SLOW_DUMP_THRESHOLD %s: must not be negative", cfg.SlowDumpThreshold)
+	}
+	if cfg.DumpCooldown < 0 {
+		return fmt.Errorf("invalid DUMP_COOLDOWN %s: must not be negative", cfg.DumpCooldown)
+	}
+	if cfg.MaintenanceRetryAfter < 0 {
+		return fmt.Errorf("invalid MAINTENANCE_RETRY_AFTER %s: must not be negative", cfg.MaintenanceRetryAfter)
+	}
+	if cfg.LoadTest.MaxRPS < 1 {
+		return fmt.Errorf("invalid LOADTEST_MAX_RPS %d: must be at least 1", cfg.LoadTest.MaxRPS)
+	}
+	if cfg.LoadTest.MaxConcurrency < 1 {
+		return fmt.Errorf("invalid LOADTEST_MAX_CONCURRENCY %d: must be at least 1", cfg.LoadTest.MaxConcurrency)
+	}
+	if cfg.LoadTest.MaxDuration <= 0 {
+		return fmt.Errorf("invalid LOADTEST_MAX_DURATION %s: must be positive", cfg.LoadTest.MaxDuration)
+	}
+	if cfg.RecorderSize < 1 {
+		return fmt.Errorf("invalid RECORDER_SIZE %d: must be at least 1", cfg.RecorderSize)
+	}
+	if cfg.AuditSize < 1 {
+		return fmt.Errorf("invalid AUDIT_SIZE %d: must be at least 1", cfg.AuditSize)
+	}
+	if cfg.RecorderMaxBodyBytes < 0 {
+		return fmt.Errorf("invalid RECORDER_MAX_BODY_BYTES %d: must not be negative", cfg.RecorderMaxBodyBytes)
+	}
+	// Recorded requests carry bodies, and replaying one writes again.
+	if cfg.EnableRecorder && len(cfg.APIKeys) == 0 && !cfg.jwtEnabled() && cfg.TLSClientCAFile == "" {
+		return fmt.Errorf("invalid ENABLE_RECORDER: requires API_KEYS, JWT authentication, or TLS_CLIENT_CA_FILE")
+	}
+	if cfg.LoadTest.TargetURL != "" {
+		u, err := url.Parse(cfg.LoadTest.TargetURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid LOADTEST_TARGET_URL %q: must be an absolute http or https URL", cfg.LoadTest.TargetURL)
+		}
+	}
+	if (cfg.MemDumpThreshold > 0 || cfg.SlowDumpThreshold > 0) && !cfg.EnableAdmin {
+		return fmt.Errorf("invalid MEM_DUMP_THRESHOLD or SLOW_DUMP_THRESHOLD: requires ENABLE_ADMIN")
+	}
+
+	switch cfg.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid LOG_LEVEL %q: must be one of debug, info, warn, error", cfg.LogLevel)
+	}
+
+	switch cfg.LogFormat {
+	case "json", "text", "dev":
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q: must be json, text, or dev", cfg.LogFormat)
+	}
+
+	switch cfg.TrailingSlash {
+	case trailingSlashRedirect, trailingSlashServe:
+	default:
+		return fmt.Errorf("invalid TRAILING_SLASH %q: must be redirect or serve", cfg.TrailingSlash)
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("invalid TLS configuration: TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return fmt.Errorf("invalid TLS configuration: TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if cfg.EnableH2C && cfg.TLSCertFile != "" {
+		return fmt.Errorf("invalid ENABLE_H2C: cannot be combined with TLS_CERT_FILE, which already negotiates HTTP/2")
+	}
+
+	if cfg.JWTJWKSURL != "" && cfg.JWTPublicKey != "" {
+		return fmt.Errorf("invalid JWT configuration: JWT_JWKS_URL and JWT_PUBLIC_KEY are mutually exclusive")
+	}
+	if cfg.JWTJWKSURL != "" {
+		if u, err := url.Parse(cfg.JWTJWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid JWT_JWKS_URL %q: must be an http(s) URL", cfg.JWTJWKSURL)
+		}
+	}
+	if len(cfg.APIKeys) > 0 && cfg.jwtEnabled() {
+		return fmt.Errorf("invalid auth configuration: API_KEYS and JWT validation are mutually exclusive")
+	}
+
+	if cfg.DataFile != "" && cfg.DatabaseURL != "" {
+		return fmt.Errorf("invalid storage configuration: DATA_FILE and DATABASE_URL are mutually exclusive")
+	}
+	if cfg.RedisURL != "" {
+		u, err := url.Parse(cfg.RedisURL)
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return fmt.Errorf("invalid REDIS_URL: must be a redis:// or rediss:// URL with a host")
+		}
+	}
+	if cfg.SessionSecret != "" && len(cfg.SessionSecret) < 32 {
+		return fmt.Errorf("invalid SESSION_SECRET: must be at least 32 bytes")
+	}
+	if cfg.SigningMaxSkew < 0 {
+		return fmt.Errorf("invalid SIGNING_MAX_SKEW %s: must not be negative", cfg.SigningMaxSkew)
+	}
+	if cfg.SessionTTL < time.Second {
+		return fmt.Errorf("invalid SESSION_TTL %s: must be at least 1s", cfg.SessionTTL)
+	}
+	if cfg.RedisStore {
+		if cfg.RedisURL == "" {
+			return fmt.Errorf("invalid REDIS_STORE: requires REDIS_URL")
+		}
+		if cfg.DataFile != "" || cfg.DatabaseURL != "" {
+			return fmt.Errorf("invalid storage configuration: REDIS_STORE cannot be combined with DATA_FILE or DATABASE_URL")
+		}
+	}
+
+	serverTimeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"READ_TIMEOUT", cfg.ReadTimeout},
+		{"READ_HEADER_TIMEOUT", cfg.ReadHeaderTimeout},
+		{"WRITE_TIMEOUT", cfg.WriteTimeout},
+		{"IDLE_TIMEOUT", cfg.IdleTimeout},
+		{"READINESS_CHECK_TIMEOUT", cfg.ReadinessCheckTimeout},
+	}
+	for _, t := range serverTimeouts {
+		if t.value <= 0 {
+			return fmt.Errorf("invalid %s %s: must be positive", t.name, t.value)
+		}
+	}
+	if cfg.EventsInterval <= 0 {
+		return fmt.Errorf("invalid EVENTS_INTERVAL %s: must be positive", cfg.EventsInterval)
+	}
+	if cfg.DelayMax < 0 {
+		return fmt.Errorf("invalid DELAY_MAX %s: must not be negative", cfg.DelayMax)
+	}
+	if cfg.RequestTimeout < 0 {
+		return fmt.Errorf("invalid REQUEST_TIMEOUT %s: must not be negative", cfg.RequestTimeout)
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return fmt.Errorf("invalid SHUTDOWN_TIMEOUT %s: must not be negative", cfg.ShutdownTimeout)
+	}
+	if cfg.ShutdownDelay < 0 {
+		return fmt.Errorf("invalid SHUTDOWN_DELAY %s: must not be negative", cfg.ShutdownDelay)
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		return fmt.Errorf("invalid MAX_HEADER_BYTES %d: must be positive", cfg.MaxHeaderBytes)
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		return fmt.Errorf("invalid MAX_BODY_BYTES %d: must be positive", cfg.MaxBodyBytes)
+	}
+	for name, limit := range map[string]concurrencyLimit{"API": cfg.ConcurrencyAPI, "DELAY": cfg.ConcurrencyDelay} {
+		if limit.Max < 0 {
+			return fmt.Errorf("invalid MAX_CONCURRENT_%s %d: must not be negative", name, limit.Max)
+		}
+		if limit.QueueTimeout < 0 {
+			return fmt.Errorf("invalid QUEUE_TIMEOUT_%s %s: must not be negative", name, limit.QueueTimeout)
+		}
+	}
+	if cfg.LogFile != "" && cfg.LogFile == cfg.AccessLogFile {
+		return fmt.Errorf("invalid ACCESS_LOG_FILE %q: must differ from LOG_FILE, which already gets access logs", cfg.AccessLogFile)
+	}
+	if cfg.LogRotation.MaxSize < 0 {
+		return fmt.Errorf("invalid LOG_FILE_MAX_SIZE %d: must not be negative", cfg.LogRotation.MaxSize)
+	}
+	if cfg.LogRotation.MaxBackups < 0 {
+		return fmt.Errorf("invalid LOG_FILE_MAX_BACKUPS %d: must not be negative", cfg.LogRotation.MaxBackups)
+	}
+	if cfg.LogRotation.MaxAge < 0 {
+		return fmt.Errorf("invalid LOG_FILE_MAX_AGE %s: must not be negative", cfg.LogRotation.MaxAge)
+	}
+	if cfg.WarmupTimeout < 0 {
+		return fmt.Errorf("invalid WARMUP_TIMEOUT %s: must not be negative", cfg.WarmupTimeout)
+	}
+	if cfg.LogSampleRate < 1 {
+		return fmt.Errorf("invalid LOG_SAMPLE_RATE %d: must be at least 1", cfg.LogSampleRate)
+	}
+	if cfg.LogSlowThreshold < 0 {
+		return fmt.Errorf("invalid LOG_SLOW_THRESHOLD %s: must not be negative", cfg.LogSlowThreshold)
+	}
+	if cfg.GzipMinBytes < 0 {
+		return fmt.Errorf("invalid GZIP_MIN_BYTES %d: must not be negative", cfg.GzipMinBytes)
+	}
+	if cfg.RateLimitRPS < 0 || math.IsNaN(cfg.RateLimitRPS) || math.IsInf(cfg.RateLimitRPS, 0) {
+		return fmt.Errorf("invalid RATE_LIMIT_RPS %v: must be a non-negative number", cfg.RateLimitRPS)
+	}
+	if cfg.RateLimitRPS > 0 && cfg.RateLimitBurst < 1 {
+		return fmt.Errorf("invalid RATE_LIMIT_BURST %d: must be at least 1", cfg.RateLimitBurst)
+	}
+	if cfg.EchoMaxBodyBytes < 0 {
+		return fmt.Errorf("invalid ECHO_MAX_BODY_BYTES %d: must not be negative", cfg.EchoMaxBodyBytes)
+	}
+	if cfg.EchoPreviewBytes < 0 {
+		return fmt.Errorf("invalid ECHO_PREVIEW_BYTES %d: must not be negative", cfg.EchoPreviewBytes)
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		return fmt.Errorf("invalid IDEMPOTENCY_TTL %s: must be positive", cfg.IdempotencyTTL)
+	}
+	if cfg.IdempotencyMaxKeys < 1 {
+		return fmt.Errorf("invalid IDEMPOTENCY_MAX_KEYS %d: must be positive", cfg.IdempotencyMaxKeys)
+	}
+	if cfg.FetchTimeout <= 0 {
+		return fmt.Errorf("invalid FETCH_TIMEOUT %s: must be positive", cfg.FetchTimeout)
+	}
+	if cfg.FetchMaxBodyBytes < 0 {
+		return fmt.Errorf("invalid FETCH_MAX_BODY_BYTES %d: must not be negative", cfg.FetchMaxBodyBytes)
+	}
+	if cfg.FetchMaxRedirects < 0 {
+		return fmt.Errorf("invalid FETCH_MAX_REDIRECTS %d: must not be negative", cfg.FetchMaxRedirects)
+	}
+	if cfg.BackendURL != "" {
+		u, err := url.Parse(cfg.BackendURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid BACKEND_URL %q: must be an absolute http or https URL", cfg.BackendURL)
+		}
+	}
+	if cfg.RegistryURL != "" {
+		u, err := url.Parse(cfg.RegistryURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid REGISTRY_URL %q: must be an absolute http or https URL", cfg.RegistryURL)
+		}
+	}
+	if cfg.RegistryInterval <= 0 {
+		return fmt.Errorf("invalid REGISTRY_INTERVAL %s: must be positive", cfg.RegistryInterval)
+	}
+	if cfg.RegistryTimeout <= 0 {
+		return fmt.Errorf("invalid REGISTRY_TIMEOUT %s: must be positive", cfg.RegistryTimeout)
+	}
+	if cfg.ShadowURL != "" {
+		u, err := url.Parse(cfg.ShadowURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid SHADOW_URL %q: must be an absolute http or https URL", cfg.ShadowURL)
+		}
+	}
+	if !(cfg.ShadowPercent >= 0 && cfg.ShadowPercent <= 100) {
+		return fmt.Errorf("invalid SHADOW_PERCENT %v: must be between 0 and 100", cfg.ShadowPercent)
+	}
+	if cfg.ShadowWorkers < 1 {
+		return fmt.Errorf("invalid SHADOW_WORKERS %d: must be positive", cfg.ShadowWorkers)
+	}
+	if cfg.ShadowQueueSize < 1 {
+		return fmt.Errorf("invalid SHADOW_QUEUE_SIZE %d: must be positive", cfg.ShadowQueueSize)
+	}
+	if cfg.ShadowTimeout <= 0 {
+		return fmt.Errorf("invalid SHADOW_TIMEOUT %s: must be positive", cfg.ShadowTimeout)
+	}
+	if cfg.BackendTimeout <= 0 {
+		return fmt.Errorf("invalid BACKEND_TIMEOUT %s: must be positive", cfg.BackendTimeout)
+	}
+	if cfg.HTTPClient.Timeout <= 0 {
+		return fmt.Errorf("invalid HTTP_CLIENT_TIMEOUT %s: must be positive", cfg.HTTPClient.Timeout)
+	}
+	if cfg.HTTPClient.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("invalid HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST %d: must not be negative", cfg.HTTPClient.MaxIdleConnsPerHost)
+	}
+	if cfg.HTTPClient.MaxConnsPerHost < 0 {
+		return fmt.Errorf("invalid HTTP_CLIENT_MAX_CONNS_PER_HOST %d: must not be negative", cfg.HTTPClient.MaxConnsPerHost)
+	}
+	if cfg.HTTPClient.MaxRetries < 0 || cfg.HTTPClient.MaxRetries > 10 {
+		return fmt.Errorf("invalid HTTP_CLIENT_MAX_RETRIES %d: must be from 0 to 10", cfg.HTTPClient.MaxRetries)
+	}
+	if cfg.HTTPClient.MaxRetries > 0 && cfg.HTTPClient.RetryBackoff <= 0 {
+		return fmt.Errorf("invalid HTTP_CLIENT_RETRY_BACKOFF %s: must be positive", cfg.HTTPClient.RetryBackoff)
+	}
+	if cfg.HTTPClient.MaxRetries > 0 && cfg.HTTPClient.RetryBudget <= 0 {
+		return fmt.Errorf("invalid HTTP_CLIENT_RETRY_BUDGET %s: must be positive", cfg.HTTPClient.RetryBudget)
+	}
+	if cfg.UploadMaxFileBytes <= 0 {
+		return fmt.Errorf("invalid UPLOAD_MAX_FILE_BYTES %d: must be positive", cfg.UploadMaxFileBytes)
+	}
+	if cfg.UploadMaxTotalBytes <= 0 {
+		return fmt.Errorf("invalid UPLOAD_MAX_TOTAL_BYTES %d: must be positive", cfg.UploadMaxTotalBytes)
+	}
+	if cfg.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("invalid CIRCUIT_BREAKER_THRESHOLD %d: must not be negative", cfg.CircuitBreakerThreshold)
+	}
+	if cfg.CircuitBreakerOpenDuration <= 0 {
+		return fmt.Errorf("invalid CIRCUIT_BREAKER_OPEN_DURATION %s: must be positive", cfg.CircuitBreakerOpenDuration)
+	}
+	if cfg.ReadOnlyAfterFailures < 0 {
+		return fmt.Errorf("invalid READ_ONLY_AFTER_FAILURES %d: must not be negative", cfg.ReadOnlyAfterFailures)
+	}
+	if cfg.ReadOnlyProbeInterval <= 0 {
+		return fmt.Errorf("invalid READ_ONLY_PROBE_INTERVAL %s: must be positive", cfg.ReadOnlyProbeInterval)
+	}
+	if cfg.WSMaxConnections < 1 {
+		return fmt.Errorf("invalid WS_MAX_CONNECTIONS %d: must be positive", cfg.WSMaxConnections)
+	}
+	if cfg.WSMaxMessageBytes <= 0 {
+		return fmt.Errorf("invalid WS_MAX_MESSAGE_BYTES %d: must be positive", cfg.WSMaxMessageBytes)
+	}
+	if cfg.TransformMaxRepeat < 1 {
+		return fmt.Errorf("invalid TRANSFORM_MAX_REPEAT %d: must be at least 1", cfg.TransformMaxRepeat)
+	}
+	if cfg.TransformMaxBytes <= 0 {
+		return fmt.Errorf("invalid TRANSFORM_MAX_BYTES %d: must be positive", cfg.TransformMaxBytes)
+	}
+	if cfg.BatchMaxOperations < 1 {
+		return fmt.Errorf("invalid BATCH_MAX_OPERATIONS %d: must be positive", cfg.BatchMaxOperations)
+	}
+	if cfg.ImportMaxBytes <= 0 {
+		return fmt.Errorf("invalid IMPORT_MAX_BYTES %d: must be positive", cfg.ImportMaxBytes)
+	}
+	if cfg.WebhookTimeout <= 0 {
+		return fmt.Errorf("invalid WEBHOOK_TIMEOUT %s: must be positive", cfg.WebhookTimeout)
+	}
+	if cfg.WebhookMaxAttempts < 1 {
+		return fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS %d: must be positive", cfg.WebhookMaxAttempts)
+	}
+	if cfg.WebhookRetryBackoff < 0 {
+		return fmt.Errorf("invalid WEBHOOK_RETRY_BACKOFF %s: must not be negative", cfg.WebhookRetryBackoff)
+	}
+	if cfg.BrokerURL != "" {
+		u, err := url.Parse(cfg.BrokerURL)
+		if err != nil || (u.Scheme != "nats" && u.Scheme != "kafka") || u.Host == "" {
+			return fmt.Errorf("invalid BROKER_URL: must be a nats:// or kafka:// URL with a host")
+		}
+		if cfg.BrokerSubject == "" {
+			return fmt.Errorf("invalid BROKER_SUBJECT: must not be empty")
+		}
+	}
+	if cfg.BrokerBufferSize < 1 {
+		return fmt.Errorf("invalid BROKER_BUFFER_SIZE %d: must be positive", cfg.BrokerBufferSize)
+	}
+	if cfg.BrokerTimeout <= 0 {
+		return fmt.Errorf("invalid BROKER_TIMEOUT %s: must be positive", cfg.BrokerTimeout)
+	}
+	if cfg.JobWorkers < 1 {
+		return fmt.Errorf("invalid JOB_WORKERS %d: must be positive", cfg.JobWorkers)
+	}
+	if cfg.JobTimeout <= 0 {
+		return fmt.Errorf("invalid JOB_TIMEOUT %s: must be positive", cfg.JobTimeout)
+	}
+	if cfg.JobRetention <= 0 {
+		return fmt.Errorf("invalid JOB_RETENTION %s: must be positive", cfg.JobRetention)
+	}
+	if cfg.ItemsMaxLimit < 1 {
+		return fmt.Errorf("invalid ITEMS_MAX_LIMIT %d: must be positive", cfg.ItemsMaxLimit)
+	}
+	if cfg.DeletedItemRetention < 0 {
+		return fmt.Errorf("invalid DELETED_ITEM_RETENTION %s: must not be negative", cfg.DeletedItemRetention)
+	}
+	for _, name := range append([]string{cfg.DefaultTenant}, cfg.Tenants...) {
+		if !validTenantName.MatchString(name) {
+			return fmt.Errorf("invalid tenant name %q: must be letters, digits, '-', or '_', at most 63, starting with a letter or digit", name)
+		}
+	}
+	if _, err := regexp.Compile(cfg.TenantPattern); err != nil {
+		return fmt.Errorf("invalid TENANT_PATTERN %q: %w", cfg.TenantPattern, err)
+	}
+	if cfg.MultiTenant && len(cfg.Tenants) == 0 && cfg.TenantPattern == "" {
+		return fmt.Errorf("invalid MULTI_TENANT: requires TENANTS or TENANT_PATTERN")
+	}
+	return nil
+}
+
+func validPort(value string) bool {
+	port, err := strconv.Atoi(value)
+	return err == nil && port >= 1 && port <= 65535
+}
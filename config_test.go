@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedEnv is a lookupEnv over env alone.
+func fixedEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.env")
+	if err := os.WriteFile(file, []byte("PORT=9200\nLOG_LEVEL=warn\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(Config) bool
+	}{
+		{"default port", nil, nil, func(c Config) bool { return c.Port == "8080" }},
+		{"env port", map[string]string{"PORT": "9000"}, nil, func(c Config) bool { return c.Port == "9000" }},
+		{"flag port over env", map[string]string{"PORT": "9000"}, []string{"-port", "9100"}, func(c Config) bool { return c.Port == "9100" }},
+		{"empty env port keeps default", map[string]string{"PORT": ""}, nil, func(c Config) bool { return c.Port == "8080" }},
+
+		{"default log level", nil, nil, func(c Config) bool { return c.LogLevel == "info" }},
+		{"env log level", map[string]string{"LOG_LEVEL": "debug"}, nil, func(c Config) bool { return c.LogLevel == "debug" }},
+		{"flag log level over env", map[string]string{"LOG_LEVEL": "debug"}, []string{"-log-level=error"}, func(c Config) bool { return c.LogLevel == "error" }},
+
+		{"default read timeout", nil, nil, func(c Config) bool { return c.ReadTimeout == 15*time.Second }},
+		{"env read timeout", map[string]string{"READ_TIMEOUT": "30s"}, nil, func(c Config) bool { return c.ReadTimeout == 30*time.Second }},
+		{"flag read timeout over env", map[string]string{"READ_TIMEOUT": "30s"}, []string{"-read-timeout", "45s"}, func(c Config) bool { return c.ReadTimeout == 45*time.Second }},
+
+		{"default pprof", nil, nil, func(c Config) bool { return !c.EnablePprof }},
+		{"env pprof", map[string]string{"ENABLE_PPROF": "true"}, nil, func(c Config) bool { return c.EnablePprof }},
+		{"flag pprof off over env", map[string]string{"ENABLE_PPROF": "true"}, []string{"-pprof=false"}, func(c Config) bool { return !c.EnablePprof }},
+
+		{"config file over env", map[string]string{"CONFIG_FILE": file, "PORT": "9000", "LOG_LEVEL": "debug"}, nil, func(c Config) bool {
+			return c.Port == "9200" && c.LogLevel == "warn" && c.ConfigFile == file
+		}},
+		{"flag over config file", map[string]string{"CONFIG_FILE": file}, []string{"-port", "9100"}, func(c Config) bool {
+			return c.Port == "9100" && c.LogLevel == "warn"
+		}},
+		{"env where the config file is silent", map[string]string{"CONFIG_FILE": file, "WRITE_TIMEOUT": "20s"}, nil, func(c Config) bool {
+			return c.WriteTimeout == 20*time.Second
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(fixedEnv(tt.env), tt.args)
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if !tt.want(cfg) {
+				t.Errorf("env %v, args %v: port %s, log level %s, read timeout %s, write timeout %s, pprof %v",
+					tt.env, tt.args, cfg.Port, cfg.LogLevel, cfg.ReadTimeout, cfg.WriteTimeout, cfg.EnablePprof)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFlagErrors(t *testing.T) {
+	// The flag package prints usage to stderr on each of these.
+	captureOutput(t, func() {
+		if _, err := loadConfig(fixedEnv(nil), []string{"-help"}); !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("-help: loadConfig error = %v, want flag.ErrHelp", err)
+		}
+		if _, err := loadConfig(fixedEnv(nil), []string{"-no-such-flag"}); err == nil || errors.Is(err, flag.ErrHelp) {
+			t.Errorf("unknown flag: loadConfig error = %v, want a parse error", err)
+		}
+		if _, err := loadConfig(fixedEnv(nil), []string{"-read-timeout", "soon"}); err == nil {
+			t.Error("-read-timeout soon: loadConfig accepted it")
+		}
+	})
+	cfg, err := loadConfig(fixedEnv(nil), []string{"-version"})
+	if err != nil || !cfg.ShowVersion {
+		t.Errorf("-version: ShowVersion = %v, err = %v, want true", cfg.ShowVersion, err)
+	}
+}
+
+// captureOutput runs f with os.Stdout and os.Stderr redirected, returning
+// what each received.
+func captureOutput(t *testing.T, f func()) (stdout, stderr string) {
+	t.Helper()
+	read := func(dest **os.File) (done func() string) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig := *dest
+		*dest = w
+		out := make(chan string)
+		go func() {
+			b, _ := io.ReadAll(r)
+			out <- string(b)
+		}()
+		return func() string {
+			*dest = orig
+			w.Close()
+			return <-out
+		}
+	}
+	doneOut, doneErr := read(&os.Stdout), read(&os.Stderr)
+	f()
+	return doneOut(), doneErr()
+}
+
+func TestRunExitsForVersionAndHelp(t *testing.T) {
+	t.Setenv("VERSION", "1.2.3-test")
+
+	var code int
+	stdout, _ := captureOutput(t, func() { code = run([]string{"-version"}) })
+	if code != 0 || stdout != "1.2.3-test\n" {
+		t.Errorf("run -version = %d, printed %q, want 0 and the version", code, stdout)
+	}
+
+	stdout, stderr := captureOutput(t, func() { code = run([]string{"-help"}) })
+	if code != 0 || stdout != "" || !strings.Contains(stderr, "Usage: my-go-app") || !strings.Contains(stderr, "-port") {
+		t.Errorf("run -help = %d, stdout %q, stderr %q, want 0 and the usage on stderr", code, stdout, stderr)
+	}
+
+	_, stderr = captureOutput(t, func() { code = run([]string{"-port", "none"}) })
+	if code != 2 || !strings.Contains(stderr, "invalid PORT") {
+		t.Errorf("run -port none = %d, stderr %q, want 2 and the error", code, stderr)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(fixedEnv(map[string]string{"LOG_FORMAT": "json"}), nil)
+	if err != nil {
+		t.Fatalf("loadConfig with an empty environment: %v", err)
+	}
+	want := defaultConfig()
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"Port", cfg.Port, "8080"},
+		{"ListenNetwork", cfg.ListenNetwork, "tcp"},
+		{"LogLevel", cfg.LogLevel, "info"},
+		{"LogFormat", cfg.LogFormat, "json"},
+		{"ReadTimeout", cfg.ReadTimeout, 15 * time.Second},
+		{"ReadHeaderTimeout", cfg.ReadHeaderTimeout, 5 * time.Second},
+		{"WriteTimeout", cfg.WriteTimeout, 15 * time.Second},
+		{"IdleTimeout", cfg.IdleTimeout, 60 * time.Second},
+		{"ShutdownTimeout", cfg.ShutdownTimeout, 15 * time.Second},
+		{"RequestTimeout", cfg.RequestTimeout, 10 * time.Second},
+		{"MaxHeaderBytes", cfg.MaxHeaderBytes, want.MaxHeaderBytes},
+		{"MaxBodyBytes", cfg.MaxBodyBytes, want.MaxBodyBytes},
+		{"Version", cfg.Version, want.Version},
+		{"TLSCertFile", cfg.TLSCertFile, ""},
+		{"APIKeys", len(cfg.APIKeys), 0},
+		{"EnablePprof", cfg.EnablePprof, false},
+		{"DebugEndpoints", cfg.DebugEndpoints, false},
+		{"ConfigFile", cfg.ConfigFile, ""},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if err := want.Validate(); err != nil {
+		t.Errorf("defaultConfig().Validate() = %v", err)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"port not a number", map[string]string{"PORT": "http"}, nil, "invalid PORT"},
+		{"port zero", map[string]string{"PORT": "0"}, nil, "invalid PORT"},
+		{"port too large", map[string]string{"PORT": "65536"}, nil, "invalid PORT"},
+		{"negative port", map[string]string{"PORT": "-80"}, nil, "invalid PORT"},
+		{"bad port flag", nil, []string{"-port", "eighty"}, "invalid PORT"},
+		{"admin port equal to port", map[string]string{"PORT": "9000", "ADMIN_PORT": "9000"}, nil, "invalid ADMIN_PORT"},
+		{"negative read timeout", map[string]string{"READ_TIMEOUT": "-1s"}, nil, "invalid READ_TIMEOUT"},
+		{"negative write timeout flag", nil, []string{"-write-timeout=-5s"}, "invalid WRITE_TIMEOUT"},
+		{"negative shutdown timeout", map[string]string{"SHUTDOWN_TIMEOUT": "-1s"}, nil, "invalid SHUTDOWN_TIMEOUT"},
+		{"negative shutdown delay", map[string]string{"SHUTDOWN_DELAY": "-1s"}, nil, "invalid SHUTDOWN_DELAY"},
+		{"negative request timeout", map[string]string{"REQUEST_TIMEOUT": "-1ms"}, nil, "invalid REQUEST_TIMEOUT"},
+		{"unparsable timeout", map[string]string{"IDLE_TIMEOUT": "a minute"}, nil, "IDLE_TIMEOUT"},
+		{"cert without key", map[string]string{"TLS_CERT_FILE": "server.crt"}, nil, "TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		{"key without cert", map[string]string{"TLS_KEY_FILE": "server.key"}, nil, "TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		{"cert flag without key", nil, []string{"-tls-cert", "server.crt"}, "TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		{"client CA without TLS", map[string]string{"TLS_CLIENT_CA_FILE": "ca.pem"}, nil, "TLS_CLIENT_CA_FILE requires"},
+		{"unknown log level", map[string]string{"LOG_LEVEL": "verbose"}, nil, "invalid LOG_LEVEL"},
+		{"missing config file", map[string]string{"CONFIG_FILE": filepath.Join(t.TempDir(), "missing.env")}, nil, "invalid CONFIG_FILE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(fixedEnv(tt.env), tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connTracker follows one listener's connections through
+// http.Server.ConnState. Open connections are kept by their state until
+// they close or are hijacked, which both end the server's part in them, so
+// WebSocket and h2c connections don't linger in it.
+type connTracker struct {
+	listener string
+	now      func() time.Time
+
+	mu    sync.Mutex
+	conns map[net.Conn]trackedConn
+	// states counts open connections by state: new, active, idle.
+	states   [3]int
+	opened   uint64
+	closed   uint64
+	hijacked uint64
+	// lifetimes is a ring of the last statsSamples closed connections'
+	// lifetimes; next is where the next one goes once it is full.
+	lifetimes []time.Duration
+	next      int
+
+	gauges      [3]prometheus.Gauge
+	openedTotal prometheus.Counter
+	closedTotal prometheus.Counter
+	hijackTotal prometheus.Counter
+	lifetime    prometheus.Observer
+}
+
+type trackedConn struct {
+	state  http.ConnState
+	opened time.Time
+}
+
+// connStates names the states connTracker.states counts, by index.
+var connStates = [3]string{"new", "active", "idle"}
+
+func newConnTracker(listener string, now func() time.Time) *connTracker {
+	t := &connTracker{
+		listener:    listener,
+		now:         now,
+		conns:       make(map[net.Conn]trackedConn),
+		openedTotal: httpConnectionsOpened.WithLabelValues(listener),
+		closedTotal: httpConnectionsClosed.WithLabelValues(listener),
+		hijackTotal: httpConnectionsHijacked.WithLabelValues(listener),
+		lifetime:    httpConnectionDuration.WithLabelValues(listener),
+	}
+	for i, state := range connStates {
+		t.gauges[i] = httpConnections.WithLabelValues(listener, state)
+	}
+	return t
+}
+
+// connStateIndex maps state to its index in connTracker.states, or -1 for
+// the final states, hijacked and closed.
+func connStateIndex(state http.ConnState) int {
+	switch state {
+	case http.StateNew:
+		return 0
+	case http.StateActive:
+		return 1
+	case http.StateIdle:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// ConnState is the http.Server.ConnState hook.
+func (t *connTracker) ConnState(c net.Conn, state http.ConnState) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, known := t.conns[c]
+	if known {
+		if i := connStateIndex(prev.state); i >= 0 {
+			t.states[i]--
+			t.gauges[i].Dec()
+		}
+	}
+	switch state {
+	case http.StateNew:
+		t.opened++
+		t.openedTotal.Inc()
+		prev.opened = now
+	case http.StateHijacked:
+		t.hijacked++
+		t.hijackTotal.Inc()
+		delete(t.conns, c)
+		return
+	case http.StateClosed:
+		if !known {
+			// Closed after a hijack, or never seen as new.
+			return
+		}
+		t.closed++
+		t.closedTotal.Inc()
+		lifetime := now.Sub(prev.opened)
+		t.lifetime.Observe(lifetime.Seconds())
+		if len(t.lifetimes) < statsSamples {
+			t.lifetimes = append(t.lifetimes, lifetime)
+		} else {
+			t.lifetimes[t.next] = lifetime
+			t.next = (t.next + 1) % statsSamples
+		}
+		delete(t.conns, c)
+		return
+	}
+	if !known && state != http.StateNew {
+		// Connections accepted before the hook was set start here.
+		prev.opened = now
+	}
+	i := connStateIndex(state)
+	t.states[i]++
+	t.gauges[i].Inc()
+	t.conns[c] = trackedConn{state: state, opened: prev.opened}
+}
+
+// ConnectionStats is one listener's connections in GET /stats. Lifetime
+// quantiles cover the last statsSamples closed connections.
+type ConnectionStats struct {
+	Listener string `json:"listener"`
+	Open     int    `json:"open"`
+	New      int    `json:"new"`
+	Active   int    `json:"active"`
+	Idle     int    `json:"idle"`
+	Opened   uint64 `json:"opened"`
+	Closed   uint64 `json:"closed"`
+	Hijacked uint64 `json:"hijacked"`
+	// OldestMS is the age of the longest-open connection.
+	OldestMS      float64 `json:"oldest_ms"`
+	LifetimeP50MS float64 `json:"lifetime_p50_ms"`
+	LifetimeP95MS float64 `json:"lifetime_p95_ms"`
+	LifetimeP99MS float64 `json:"lifetime_p99_ms"`
+}
+
+func (t *connTracker) Stats() ConnectionStats {
+	now := t.now()
+	t.mu.Lock()
+	st := ConnectionStats{
+		Listener: t.listener,
+		Open:     len(t.conns),
+		New:      t.states[0],
+		Active:   t.states[1],
+		Idle:     t.states[2],
+		Opened:   t.opened,
+		Closed:   t.closed,
+		Hijacked: t.hijacked,
+	}
+	for _, c := range t.conns {
+		st.OldestMS = max(st.OldestMS, durationMS(now.Sub(c.opened)))
+	}
+	lifetimes := slices.Clone(t.lifetimes)
+	t.mu.Unlock()
+	slices.Sort(lifetimes)
+	st.LifetimeP50MS = durationMS(quantile(lifetimes, 0.50))
+	st.LifetimeP95MS = durationMS(quantile(lifetimes, 0.95))
+	st.LifetimeP99MS = durationMS(quantile(lifetimes, 0.99))
+	return st
+}
+
+// logConnectionsDrained summarizes the public listener's shutdown: of the
+// open connections it started with, how many finished on their own and how
+// many were still open when the drain timeout cut them off. Hijacked
+// connections aren't counted; their owners drain them.
+func logConnectionsDrained(logger *slog.Logger, open, forceClosed int) {
+	logger.Info("connections drained",
+		slog.Int("open_at_shutdown", open),
+		slog.Int("drained", max(open-forceClosed, 0)),
+		slog.Int("force_closed", forceClosed),
+	)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitForConns polls tracker until want accepts its stats, as ConnState
+// runs on the server's goroutines after each transition.
+func waitForConns(t *testing.T, tracker *connTracker, what string, want func(ConnectionStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := tracker.Stats()
+		if want(st) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiting for %s: stats = %+v", what, st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnTracker(t *testing.T) {
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/block", func(w http.ResponseWriter, r *http.Request) { <-release })
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	tracker := newConnTracker("test-"+t.Name(), time.Now)
+	closedBefore := testutil.ToFloat64(httpConnectionsClosed.WithLabelValues(tracker.listener))
+	ts := httptest.NewUnstartedServer(mux)
+	ts.Config.ConnState = tracker.ConnState
+	ts.Start()
+	defer ts.Close()
+
+	conns := make([]net.Conn, 3)
+	readers := make([]*bufio.Reader, 3)
+	for i := range conns {
+		c, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns[i], readers[i] = c, bufio.NewReader(c)
+	}
+	send := func(i int, path string) {
+		t.Helper()
+		if _, err := io.WriteString(conns[i], "GET "+path+" HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func(i int) {
+		t.Helper()
+		resp, err := http.ReadResponse(readers[i], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	gauge := func(state string) float64 {
+		return testutil.ToFloat64(httpConnections.WithLabelValues(tracker.listener, state))
+	}
+
+	// The server sees a connection once it reads from it.
+	for i := range conns {
+		send(i, "/ok")
+		receive(i)
+	}
+	waitForConns(t, tracker, "three idle keep-alive connections", func(st ConnectionStats) bool {
+		return st.Open == 3 && st.Idle == 3 && st.Opened == 3
+	})
+	if gauge("idle") != 3 || gauge("active") != 0 || gauge("new") != 0 {
+		t.Errorf("gauges new/active/idle = %v/%v/%v, want 0/0/3", gauge("new"), gauge("active"), gauge("idle"))
+	}
+
+	send(0, "/block")
+	waitForConns(t, tracker, "one active connection", func(st ConnectionStats) bool { return st.Active == 1 && st.Idle == 2 })
+	if gauge("active") != 1 || gauge("idle") != 2 {
+		t.Errorf("gauges active/idle = %v/%v while one request runs, want 1/2", gauge("active"), gauge("idle"))
+	}
+	close(release)
+	receive(0)
+	waitForConns(t, tracker, "the connection idle again", func(st ConnectionStats) bool { return st.Active == 0 && st.Idle == 3 })
+
+	// A hijacked connection leaves the tracker for good.
+	send(1, "/hijack")
+	waitForConns(t, tracker, "the hijack", func(st ConnectionStats) bool { return st.Hijacked == 1 && st.Open == 2 })
+
+	conns[0].Close()
+	conns[2].Close()
+	waitForConns(t, tracker, "the rest closing", func(st ConnectionStats) bool { return st.Open == 0 && st.Closed == 2 })
+	st := tracker.Stats()
+	if st.LifetimeP50MS <= 0 || st.OldestMS != 0 {
+		t.Errorf("stats = %+v, want lifetimes recorded and nothing open", st)
+	}
+	tracker.mu.Lock()
+	leaked := len(tracker.conns)
+	tracker.mu.Unlock()
+	if leaked != 0 || gauge("idle") != 0 || gauge("active") != 0 {
+		t.Errorf("%d connections still tracked, gauges active/idle %v/%v; want none", leaked, gauge("active"), gauge("idle"))
+	}
+	if got := testutil.ToFloat64(httpConnectionsClosed.WithLabelValues(tracker.listener)) - closedBefore; got != 2 {
+		t.Errorf("closed connections counter rose by %v, want 2, the hijacked one not among them", got)
+	}
+}
+
+func TestConnTrackerStatsEndpoint(t *testing.T) {
+	srv, h := newTestServer(t)
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.ConnState = srv.conns.ConnState
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats struct {
+		Connections []ConnectionStats `json:"connections"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Connections) == 0 || stats.Connections[0].Listener != "public" || stats.Connections[0].Active != 1 {
+		t.Errorf("/stats connections = %+v, want the public listener with this request active", stats.Connections)
+	}
+}
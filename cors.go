@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	corsAllowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, X-API-Key, X-Request-ID"
+	corsMaxAge         = 600 // seconds
+)
+
+// corsPolicy is the set of allowed cross-origin callers. It can be replaced
+// while requests are being served.
+type corsPolicy struct {
+	current atomic.Pointer[corsOrigins]
+}
+
+type corsOrigins struct {
+	allowAll bool
+	origins  map[string]bool
+}
+
+func newCORSPolicy(allowed []string) *corsPolicy {
+	p := &corsPolicy{}
+	p.Set(allowed)
+	return p
+}
+
+// Set replaces the allowed origins. An entry of "*" allows any origin.
+func (p *corsPolicy) Set(allowed []string) {
+	o := &corsOrigins{origins: make(map[string]bool, len(allowed))}
+	for _, origin := range allowed {
+		if origin == "*" {
+			o.allowAll = true
+		}
+		o.origins[origin] = true
+	}
+	p.current.Store(o)
+}
+
+// enabled reports whether any origin is allowed.
+func (p *corsPolicy) enabled() bool {
+	return len(p.current.Load().origins) > 0
+}
+
+// allows reports whether origin may make cross-origin requests, and whether
+// that is because every origin may.
+func (p *corsPolicy) allows(origin string) (ok, all bool) {
+	o := p.current.Load()
+	return o.allowAll || o.origins[origin], o.allowAll
+}
+
+// withCORS answers preflight requests and attaches CORS headers for origins
+// the policy allows. While the policy is empty the middleware is a no-op.
+func withCORS(next http.Handler, policy *corsPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !policy.enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		allowed, allowAll := policy.allows(origin)
+		if !allowed {
+			if preflight {
+				writeError(w, r, http.StatusForbidden, codeForbidden, "Origin not allowed", "")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowAll {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		// Lets the browser's Resource Timing API show Server-Timing.
+		w.Header().Set("Timing-Allow-Origin", w.Header().Get("Access-Control-Allow-Origin"))
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requested)
+		} else {
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		}
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// splitList parses a comma-separated setting, trimming whitespace and
+// dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// EnvResponse is the body of GET /debug/env.
+type EnvResponse struct {
+	Env       map[string]string `json:"env"`
+	Runtime   RuntimeInfo       `json:"runtime"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// RuntimeInfo summarizes the Go runtime's view of the process.
+type RuntimeInfo struct {
+	GoVersion    string     `json:"go_version"`
+	GOMAXPROCS   int        `json:"gomaxprocs"`
+	NumCPU       int        `json:"num_cpu"`
+	NumGoroutine int        `json:"num_goroutine"`
+	Memory       MemoryInfo `json:"memory"`
+}
+
+// MemoryInfo is a subset of runtime.MemStats, in bytes unless noted.
+type MemoryInfo struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	Sys          uint64 `json:"sys"`
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+}
+
+// envHandler lists the process environment and runtime stats. Variables whose
+// names contain any of ENV_REDACT_PATTERNS (case-insensitive) have their values
+// replaced. A non-empty ENV_EXPOSE limits the listing to exactly those names;
+// redaction still applies to them.
+func (s *Server) envHandler(w http.ResponseWriter, r *http.Request) {
+	expose := s.cfg.EnvExpose
+	patterns := make([]string, len(s.cfg.EnvRedactPatterns))
+	for i, p := range s.cfg.EnvRedactPatterns {
+		patterns[i] = strings.ToUpper(p)
+	}
+
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if len(expose) > 0 && !slices.Contains(expose, name) {
+			continue
+		}
+		upper := strings.ToUpper(name)
+		if slices.ContainsFunc(patterns, func(p string) bool { return strings.Contains(upper, p) }) {
+			value = redactedValue
+		}
+		env[name] = value
+	}
+
+	writeResponse(w, r, http.StatusOK, EnvResponse{
+		Env: env,
+		Runtime: RuntimeInfo{
+			GoVersion:    runtime.Version(),
+			GOMAXPROCS:   runtime.GOMAXPROCS(0),
+			NumCPU:       runtime.NumCPU(),
+			NumGoroutine: runtime.NumGoroutine(),
+			Memory:       readMemoryInfo(),
+		},
+		Timestamp: s.clock.Now(),
+	})
+}
+
+// readMemoryInfo samples runtime.ReadMemStats, which briefly stops the world.
+func readMemoryInfo() MemoryInfo {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return MemoryInfo{
+		Alloc:        mem.Alloc,
+		TotalAlloc:   mem.TotalAlloc,
+		Sys:          mem.Sys,
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		NumGC:        mem.NumGC,
+		PauseTotalNs: mem.PauseTotalNs,
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// decodeError describes why a request body could not be decoded and which
+// HTTP status and error code the client should receive: 400 invalid_json for
+// malformed JSON, 422 invalid_body for well-formed JSON that doesn't match the
+// expected shape.
+type decodeError struct {
+	Status  int
+	Code    string
+	Message string
+	Detail  string
+}
+
+func (e *decodeError) Error() string {
+	if e.Detail == "" {
+		return e.Message
+	}
+	return e.Message + ": " + e.Detail
+}
+
+// decodeJSON strictly decodes a single JSON document from the request body
+// into dst. Unknown fields are rejected when dst is a struct, and trailing
+// data after the first document is an error. Failures are returned as
+// *decodeError and logged at debug level.
+func decodeJSON(r *http.Request, dst any) error {
+	err := decodeJSONBody(r, dst)
+	if err != nil {
+		var decErr *decodeError
+		errors.As(err, &decErr)
+		loggerFrom(r.Context()).Debug("request body rejected",
+			slog.Int("status", decErr.Status),
+			slog.String("error", decErr.Error()),
+		)
+	}
+	return err
+}
+
+func decodeJSONBody(r *http.Request, dst any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return classifyDecodeError(err)
+	}
+	offset := dec.InputOffset()
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		var encodingErr *bodyEncodingError
+		if errors.As(err, &maxBytesErr) || errors.As(err, &encodingErr) {
+			return classifyDecodeError(err)
+		}
+		return &decodeError{
+			Status:  http.StatusBadRequest,
+			Code:    codeInvalidJSON,
+			Message: "Invalid JSON",
+			Detail:  fmt.Sprintf("body must contain a single JSON document (extra data after offset %d)", offset),
+		}
+	}
+	return nil
+}
+
+func classifyDecodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	var encodingErr *bodyEncodingError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &decodeError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    codeBodyTooLarge,
+			Message: "Request body too large",
+			Detail:  fmt.Sprintf("body must not exceed %d bytes", maxBytesErr.Limit),
+		}
+	case errors.As(err, &encodingErr):
+		return &decodeError{Status: http.StatusBadRequest, Code: codeInvalidBody, Message: "Cannot decompress request body", Detail: encodingErr.err.Error()}
+	case errors.Is(err, io.EOF):
+		return &decodeError{Status: http.StatusBadRequest, Code: codeInvalidJSON, Message: "Invalid JSON", Detail: "empty body"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &decodeError{Status: http.StatusBadRequest, Code: codeInvalidJSON, Message: "Invalid JSON", Detail: "unexpected end of JSON input"}
+	case errors.As(err, &syntaxErr):
+		return &decodeError{
+			Status:  http.StatusBadRequest,
+			Code:    codeInvalidJSON,
+			Message: "Invalid JSON",
+			Detail:  fmt.Sprintf("%s (at offset %d)", syntaxErr.Error(), syntaxErr.Offset),
+		}
+	case errors.As(err, &typeErr):
+		detail := fmt.Sprintf("expected %s but got JSON %s (at offset %d)", typeErr.Type, typeErr.Value, typeErr.Offset)
+		if typeErr.Field != "" {
+			detail = fmt.Sprintf("field %q: %s", typeErr.Field, detail)
+		}
+		return &decodeError{Status: http.StatusUnprocessableEntity, Code: codeInvalidBody, Message: "Invalid request body", Detail: detail}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return &decodeError{Status: http.StatusUnprocessableEntity, Code: codeInvalidBody, Message: "Invalid request body", Detail: "unknown field " + field}
+	default:
+		return &decodeError{Status: http.StatusBadRequest, Code: codeInvalidJSON, Message: "Invalid JSON", Detail: err.Error()}
+	}
+}
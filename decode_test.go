@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantDetail string
+	}{
+		{"valid", `{"name": "a", "count": 1}`, 0, ""},
+		{"trailing whitespace", "{\"name\": \"a\"}\n", 0, ""},
+		{"empty body", ``, http.StatusBadRequest, "empty body"},
+		{"truncated", `{"name": "a"`, http.StatusBadRequest, "unexpected end of JSON input"},
+		{"syntax error", `{"name": }`, http.StatusBadRequest, "invalid character '}' looking for beginning of value (at offset 10)"},
+		{"two documents", `{"name": "a"} {"name": "b"}`, http.StatusBadRequest, "single JSON document (extra data after offset 13)"},
+		{"trailing garbage", `{"name": "a"}x`, http.StatusBadRequest, "single JSON document"},
+		{"unknown field", `{"name": "a", "colour": "red"}`, http.StatusUnprocessableEntity, `unknown field "colour"`},
+		{"wrong type", `{"count": "many"}`, http.StatusUnprocessableEntity, `field "count": expected int but got JSON string`},
+		{"not an object", `[1, 2]`, http.StatusUnprocessableEntity, "expected main.payload but got JSON array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst payload
+			err := decodeJSON(newRequest(t, "POST", "/", tt.body), &dst)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("decodeJSON(%q) = %v, want success", tt.body, err)
+				}
+				return
+			}
+			var decErr *decodeError
+			if !errors.As(err, &decErr) {
+				t.Fatalf("decodeJSON(%q) = %v, want a *decodeError", tt.body, err)
+			}
+			if decErr.Status != tt.wantStatus || !strings.Contains(decErr.Detail, tt.wantDetail) {
+				t.Errorf("decodeJSON(%q) = %d %q, want %d containing %q", tt.body, decErr.Status, decErr.Detail, tt.wantStatus, tt.wantDetail)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func compress(t *testing.T, encoding, body string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestRequestDecompression(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.MaxBodyBytes = 1 << 10 })
+	valid := `{"name": "compressed", "data": {"color": "red"}}`
+	// Zeros compress to almost nothing, so this is well under the limit on
+	// the wire and far over it inflated.
+	bomb := compress(t, "gzip", fmt.Sprintf(`{"name": "bomb", "data": {"pad": %q}}`, strings.Repeat("0", 64<<10)))
+	if len(bomb) >= 1<<10 {
+		t.Fatalf("compressed bomb is %d bytes, want it under the limit", len(bomb))
+	}
+
+	tests := []struct {
+		name, encoding, body string
+		want                 int
+		wantBody             string
+	}{
+		{"identity", "", valid, http.StatusCreated, `"compressed"`},
+		{"gzip", "gzip", compress(t, "gzip", valid), http.StatusCreated, `"compressed"`},
+		{"gzip upper case", "GZIP", compress(t, "gzip", valid), http.StatusCreated, `"compressed"`},
+		{"deflate", "deflate", compress(t, "deflate", valid), http.StatusCreated, `"compressed"`},
+		{"inflates past the limit", "gzip", bomb, http.StatusRequestEntityTooLarge, ""},
+		{"plain JSON labelled gzip", "gzip", valid, http.StatusBadRequest, "Cannot decompress request body"},
+		{"truncated gzip", "gzip", compress(t, "gzip", valid)[:20], http.StatusBadRequest, "Cannot decompress request body"},
+		{"unsupported", "br", valid, http.StatusUnsupportedMediaType, "gzip or deflate"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRequest(t, "POST", "/api/v1/items", tc.body)
+			if tc.encoding != "" {
+				r.Header.Set("Content-Encoding", tc.encoding)
+			}
+			rec := serve(h, r)
+			if rec.Code != tc.want || !strings.Contains(rec.Body.String(), tc.wantBody) {
+				t.Errorf("POST = %d %s, want %d containing %q", rec.Code, rec.Body, tc.want, tc.wantBody)
+			}
+			if tc.want == http.StatusUnsupportedMediaType && rec.Header().Get("Accept-Encoding") != "gzip, deflate" {
+				t.Errorf("Accept-Encoding = %q, want the supported encodings", rec.Header().Get("Accept-Encoding"))
+			}
+		})
+	}
+}
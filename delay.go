@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// parseDelay parses a duration such as "500ms" or "2s" and checks it lies
+// within [0, max].
+func parseDelay(value string, max time.Duration) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration such as 500ms or 2s", value)
+	}
+	if d < 0 || d > max {
+		return 0, fmt.Errorf("%s is outside the allowed range 0s to %s", d, max)
+	}
+	return d, nil
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// delayResponse pauses for wait, first pushing the write deadline out so the
+// server's WriteTimeout doesn't cut off a deliberately slow response. It
+// reports false if the client went away first.
+func delayResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) bool {
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+	return sleepContext(r.Context(), wait) == nil
+}
+
+// delayHandler serves /delay/{duration}: it sleeps for the duration plus up
+// to ?jitter= of random extra, never more than DELAY_MAX in total, then
+// reports how long it actually waited.
+func (s *Server) delayHandler(w http.ResponseWriter, r *http.Request) {
+	max := s.cfg.DelayMax
+	wait, err := parseDelay(r.PathValue("duration"), max)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid delay", err.Error())
+		return
+	}
+	if v := r.URL.Query().Get("jitter"); v != "" {
+		jitter, err := parseDelay(v, max-wait)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid jitter", err.Error()+" (delay plus jitter may not exceed "+max.String()+")")
+			return
+		}
+		if jitter > 0 {
+			wait += rand.N(jitter)
+		}
+	}
+
+	start := time.Now()
+	if !delayResponse(w, r, wait) {
+		return
+	}
+	writeResponse(w, r, http.StatusOK, MessageResponse{
+		Message:   fmt.Sprintf("Waited %s", time.Since(start).Round(time.Millisecond)),
+		Timestamp: s.clock.Now(),
+	})
+}
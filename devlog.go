@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ANSI escapes for the dev log format.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// devAccessOmitted are the access log attributes the dev format leaves out
+// to keep each request to one readable line; the json and text formats
+// still have them.
+var devAccessOmitted = []string{"proto", "remote_addr", "client_ip", "user_agent"}
+
+// devRequestIDLength is how much of a request ID the dev format shows; the
+// rest rarely tells two requests apart.
+const devRequestIDLength = 8
+
+// isTerminal reports whether w is a terminal rather than a pipe or file.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorEnabled reports whether output to w may be colored: only on a
+// terminal, and never with NO_COLOR set (https://no-color.org).
+func colorEnabled(w io.Writer) bool {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return !noColor && isTerminal(w)
+}
+
+// devHandler is the slog.Handler behind LOG_FORMAT=dev: one short line per
+// record for reading in a terminal, not for machines. Access log lines
+// lead with method, path, status, duration, and a shortened request ID;
+// other records with their level and message. Every other attribute, but
+// the few in devAccessOmitted, follows as key=value, as the text format
+// would print it, and multi-line values such as stack traces are indented
+// on the lines below.
+type devHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Leveler
+	color bool
+	// attrs are those added with WithAttrs, their keys already qualified
+	// by the groups open at the time; group is the prefix for later ones.
+	attrs []slog.Attr
+	group string
+}
+
+func newDevHandler(out io.Writer, level slog.Leveler, color bool) *devHandler {
+	return &devHandler{mu: new(sync.Mutex), out: out, level: level, color: color}
+}
+
+func (h *devHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *devHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clip(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = appendDevAttr(h2.attrs, h.group, a)
+	}
+	return &h2
+}
+
+func (h *devHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group = h.group + name + "."
+	return &h2
+}
+
+// appendDevAttr appends a to attrs with its key prefixed by group,
+// flattening groups the way the text format does.
+func appendDevAttr(attrs []slog.Attr, group string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			attrs = appendDevAttr(attrs, prefix, ga)
+		}
+		return attrs
+	}
+	a.Key = group + a.Key
+	return append(attrs, a)
+}
+
+func (h *devHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := slices.Clip(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendDevAttr(attrs, h.group, a)
+		return true
+	})
+	index := func(key string) int {
+		return slices.IndexFunc(attrs, func(a slog.Attr) bool { return a.Key == key })
+	}
+	take := func(key string) (slog.Value, bool) {
+		i := index(key)
+		if i < 0 {
+			return slog.Value{}, false
+		}
+		v := attrs[i].Value
+		attrs = slices.Delete(attrs, i, i+1)
+		return v, true
+	}
+
+	var buf bytes.Buffer
+	if !r.Time.IsZero() {
+		h.paint(&buf, ansiDim, r.Time.Format("15:04:05.000"))
+		buf.WriteByte(' ')
+	}
+	if r.Message == "request" && index("method") >= 0 && index("status") >= 0 {
+		method, _ := take("method")
+		status, _ := take("status")
+		path, _ := take("path")
+		buf.WriteString(method.String())
+		buf.WriteByte(' ')
+		buf.WriteString(path.String())
+		buf.WriteByte(' ')
+		h.paint(&buf, statusColor(int(status.Int64())), status.String())
+		if d, ok := take("duration_ms"); ok {
+			buf.WriteByte(' ')
+			buf.WriteString(time.Duration(d.Float64() * float64(time.Millisecond)).Round(time.Microsecond).String())
+		}
+		attrs = slices.DeleteFunc(attrs, func(a slog.Attr) bool { return slices.Contains(devAccessOmitted, a.Key) })
+	} else {
+		h.paint(&buf, levelColor(r.Level), devLevel(r.Level))
+		buf.WriteByte(' ')
+		buf.WriteString(r.Message)
+	}
+	if id, ok := take("request_id"); ok {
+		s := id.String()
+		if len(s) > devRequestIDLength {
+			s = s[:devRequestIDLength]
+		}
+		buf.WriteByte(' ')
+		h.paint(&buf, ansiDim, "["+s+"]")
+	}
+
+	var blocks []slog.Attr
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindString && strings.Contains(a.Value.String(), "\n") {
+			blocks = append(blocks, a)
+			continue
+		}
+		buf.WriteByte(' ')
+		h.paint(&buf, ansiDim, a.Key+"=")
+		buf.WriteString(devValue(a.Value))
+	}
+	buf.WriteByte('\n')
+	for _, a := range blocks {
+		color := ansiDim
+		if r.Level >= slog.LevelError {
+			color = ansiRed
+		}
+		buf.WriteString("  ")
+		h.paint(&buf, color, a.Key+":")
+		buf.WriteByte('\n')
+		for _, line := range strings.Split(strings.TrimRight(a.Value.String(), "\n"), "\n") {
+			buf.WriteString("    ")
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(buf.Bytes())
+	return err
+}
+
+// paint writes s to buf, in color when the handler has it.
+func (h *devHandler) paint(buf *bytes.Buffer, color, s string) {
+	if !h.color {
+		buf.WriteString(s)
+		return
+	}
+	buf.WriteString(color)
+	buf.WriteString(s)
+	buf.WriteString(ansiReset)
+}
+
+// devValue formats v as the text format would, quoting strings that need
+// it.
+func devValue(v slog.Value) string {
+	var s string
+	switch v.Kind() {
+	case slog.KindTime:
+		s = v.Time().Format(time.RFC3339Nano)
+	default:
+		s = v.String()
+	}
+	if s == "" || strings.ContainsFunc(s, func(r rune) bool { return r <= ' ' || r == '"' || r == '=' }) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func devLevel(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "ERR"
+	case level >= slog.LevelWarn:
+		return "WRN"
+	case level >= slog.LevelInfo:
+		return "INF"
+	default:
+		return "DBG"
+	}
+}
+
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return ansiRed
+	case level >= slog.LevelWarn:
+		return ansiYellow
+	case level >= slog.LevelInfo:
+		return ansiBlue
+	default:
+		return ansiDim
+	}
+}
+
+// statusColor colors a status by class: green for success, cyan for
+// redirects, yellow for client errors, red for server errors.
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return ansiRed
+	case status >= 400:
+		return ansiYellow
+	case status >= 300:
+		return ansiCyan
+	default:
+		return ansiGreen
+	}
+}
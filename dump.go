@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// dumpMaxCPU bounds the CPU profile POST /admin/dump can ask for.
+const dumpMaxCPU = time.Minute
+
+// memDumpInterval is how often memory use is checked against
+// MEM_DUMP_THRESHOLD.
+const memDumpInterval = 10 * time.Second
+
+// dumpTimeFormat prefixes dump file names, so they sort by time and two
+// dumps in the same second don't collide.
+const dumpTimeFormat = "20060102T150405.000Z"
+
+// errCPUProfileRunning is returned when another CPU profile, from an
+// earlier dump or /debug/pprof/profile, is still running.
+var errCPUProfileRunning = errors.New("a CPU profile is already running")
+
+// DumpFile is one profile written by a dump.
+type DumpFile struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Bytes int64  `json:"bytes"`
+}
+
+// DumpResponse is the body of POST /admin/dump.
+type DumpResponse struct {
+	Trigger string     `json:"trigger"`
+	Files   []DumpFile `json:"files"`
+}
+
+// dumper writes goroutine, heap, and CPU profiles to DUMP_DIR, so there is
+// something to look at after a misbehaving pod is gone. Besides POST
+// /admin/dump, it writes one on its own when memory use or a request's
+// duration crosses its threshold, at most once per DUMP_COOLDOWN.
+type dumper struct {
+	dir      string
+	cooldown time.Duration
+	now      func() time.Time
+	logger   *slog.Logger
+
+	// mu serializes dumps; lastAuto is when an automatic one last ran.
+	mu       sync.Mutex
+	lastAuto time.Time
+}
+
+func newDumper(dir string, cooldown time.Duration, now func() time.Time, logger *slog.Logger) (*dumper, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &dumper{dir: dir, cooldown: cooldown, now: now, logger: logger}, nil
+}
+
+// Dump writes a goroutine and a heap profile, and a CPU profile over cpu
+// when it is positive.
+func (d *dumper) Dump(trigger string, cpu time.Duration) ([]DumpFile, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dumpLocked(trigger, cpu)
+}
+
+func (d *dumper) dumpLocked(trigger string, cpu time.Duration) ([]DumpFile, error) {
+	prefix := d.now().UTC().Format(dumpTimeFormat) + "-" + trigger + "-"
+	var files []DumpFile
+	write := func(kind, ext string, run func(w *bytes.Buffer) error) error {
+		var buf bytes.Buffer
+		if err := run(&buf); err != nil {
+			return err
+		}
+		name := prefix + kind + ext
+		if err := os.WriteFile(filepath.Join(d.dir, name), buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		files = append(files, DumpFile{Name: name, Kind: kind, Bytes: int64(buf.Len())})
+		return nil
+	}
+	if cpu > 0 {
+		err := write("cpu", ".pb.gz", func(buf *bytes.Buffer) error {
+			if err := pprof.StartCPUProfile(buf); err != nil {
+				return errCPUProfileRunning
+			}
+			time.Sleep(cpu)
+			pprof.StopCPUProfile()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Goroutines as text, with full stacks, since that is readable without
+	// go tool pprof; the heap in the binary format pprof reads.
+	if err := write("goroutine", ".txt", func(buf *bytes.Buffer) error {
+		return pprof.Lookup("goroutine").WriteTo(buf, 2)
+	}); err != nil {
+		return files, err
+	}
+	if err := write("heap", ".pb.gz", func(buf *bytes.Buffer) error {
+		runtime.GC()
+		return pprof.Lookup("heap").WriteTo(buf, 0)
+	}); err != nil {
+		return files, err
+	}
+	dumpsWritten.WithLabelValues(trigger).Inc()
+	return files, nil
+}
+
+// autoDump writes a dump for trigger unless one was written less than the
+// cooldown ago. It never blocks on a dump already in progress.
+func (d *dumper) autoDump(trigger string, attrs ...any) {
+	if !d.mu.TryLock() {
+		return
+	}
+	defer d.mu.Unlock()
+	now := d.now()
+	if !d.lastAuto.IsZero() && now.Sub(d.lastAuto) < d.cooldown {
+		return
+	}
+	d.lastAuto = now
+	files, err := d.dumpLocked(trigger, 0)
+	if err != nil {
+		d.logger.Warn("cannot write dump", slog.String("trigger", trigger), slog.Any("error", err))
+		return
+	}
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	d.logger.Warn("dump written", append(attrs, slog.String("trigger", trigger), slog.Any("files", names))...)
+}
+
+// checkMemory dumps when the memory the Go runtime holds from the OS, close
+// to the pod's RSS, reaches threshold bytes.
+func (d *dumper) checkMemory(threshold uint64) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if used := m.Sys - m.HeapReleased; used >= threshold {
+		d.autoDump("memory", slog.Uint64("memory_bytes", used), slog.Uint64("threshold_bytes", threshold))
+	}
+}
+
+// watchSlowRequests dumps while a request is still running after
+// threshold, so the goroutine profile shows what it is stuck on. Routes
+// that timeouts exempts from the request timeout, like /ws and /events,
+// are long-lived by design and never trigger one.
+func (d *dumper) watchSlowRequests(next http.Handler, threshold time.Duration, timeouts map[string]time.Duration) http.Handler {
+	if d == nil || threshold <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t, ok := timeouts[r.URL.Path]; ok && t == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path, requestID := r.URL.Path, requestIDFrom(r.Context())
+		timer := time.AfterFunc(threshold, func() {
+			go d.autoDump("slow_request", slog.String("path", path), slog.String("request_id", requestID))
+		})
+		defer timer.Stop()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dumpHandler serves POST /admin/dump. ?cpu= adds a CPU profile over that
+// long, e.g. 10s, before the response is sent.
+func (s *Server) dumpHandler(w http.ResponseWriter, r *http.Request) {
+	var cpu time.Duration
+	if v := r.URL.Query().Get("cpu"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > dumpMaxCPU {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid cpu parameter",
+				fmt.Sprintf("cpu must be a duration from 0 to %s, such as 10s", dumpMaxCPU))
+			return
+		}
+		cpu = d
+		// The server's WriteTimeout would otherwise cut off the response
+		// to a long CPU profile.
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(cpu + 10*time.Second))
+	}
+	files, err := s.dumps.Dump("manual", cpu)
+	if errors.Is(err, errCPUProfileRunning) {
+		writeError(w, r, http.StatusConflict, codeDumpInProgress, "CPU profile already running", err.Error())
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("cannot write dump", slog.Any("error", err))
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Cannot write dump", "")
+		return
+	}
+	loggerFrom(r.Context()).Info("dump written", slog.Int("files", len(files)))
+	writeResponse(w, r, http.StatusCreated, DumpResponse{Trigger: "manual", Files: files})
+}
+
+// dumpFileHandler serves GET /admin/dump/{file}, downloading a dump.
+func (s *Server) dumpFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("file")
+	if sanitizeFilename(name) != name {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Dump not found", "")
+		return
+	}
+	f, err := os.Open(filepath.Join(s.dumps.dir, name))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Dump not found", "")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Dump not found", "")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// redactedValue replaces secrets in debug output.
+const redactedValue = "***"
+
+// echoRedactedHeaders carry credentials and are hidden unless ECHO_UNSAFE is
+// set.
+var echoRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", apiKeyHeader}
+
+// EchoResponse is everything /echo knows about the request it received.
+type EchoResponse struct {
+	Method       string              `json:"method"`
+	URL          string              `json:"url"`
+	Proto        string              `json:"proto"`
+	Host         string              `json:"host"`
+	RemoteAddr   string              `json:"remote_addr"`
+	ClientIP     string              `json:"client_ip"`
+	Headers      map[string][]string `json:"headers"`
+	Query        url.Values          `json:"query"`
+	TLS          *echoTLS            `json:"tls"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding"`
+	BodyBytes    int                 `json:"body_bytes"`
+	Truncated    bool                `json:"truncated"`
+	RequestID    string              `json:"request_id"`
+	Timestamp    time.Time           `json:"timestamp"`
+}
+
+type echoTLS struct {
+	Version            string   `json:"version"`
+	CipherSuite        string   `json:"cipher_suite"`
+	ServerName         string   `json:"server_name,omitempty"`
+	NegotiatedProtocol string   `json:"negotiated_protocol,omitempty"`
+	PeerCertificates   []string `json:"peer_certificates,omitempty"`
+}
+
+// echoHandler reflects the request back as JSON. Bodies beyond
+// ECHO_MAX_BODY_BYTES are cut off and flagged as truncated; bodies that aren't
+// valid UTF-8 are base64 encoded. Credential headers are redacted unless
+// ECHO_UNSAFE is set.
+func (s *Server) echoHandler(w http.ResponseWriter, r *http.Request) {
+	maxBody := s.cfg.EchoMaxBodyBytes
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		status, code, message := http.StatusBadRequest, codeInvalidBody, "Cannot read request body"
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status, code, message = http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Request body too large"
+		}
+		writeError(w, r, status, code, message, err.Error())
+		return
+	}
+
+	resp := EchoResponse{
+		Method:     r.Method,
+		URL:        requestURL(r),
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		ClientIP:   clientIP(r),
+		Headers:    make(map[string][]string, len(r.Header)),
+		Query:      r.URL.Query(),
+		TLS:        describeTLS(r.TLS),
+		RequestID:  requestIDFrom(r.Context()),
+		Timestamp:  s.clock.Now(),
+	}
+	for name, values := range r.Header {
+		resp.Headers[name] = values
+	}
+	if !s.cfg.EchoUnsafe {
+		for _, name := range echoRedactedHeaders {
+			if values, ok := resp.Headers[http.CanonicalHeaderKey(name)]; ok {
+				redacted := make([]string, len(values))
+				for i := range redacted {
+					redacted[i] = redactedValue
+				}
+				resp.Headers[http.CanonicalHeaderKey(name)] = redacted
+			}
+		}
+	}
+
+	if int64(len(body)) > maxBody {
+		body = body[:maxBody]
+		resp.Truncated = true
+	}
+	resp.BodyBytes = len(body)
+	if utf8.Valid(body) {
+		resp.Body, resp.BodyEncoding = string(body), "utf-8"
+	} else {
+		resp.Body, resp.BodyEncoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	writeResponse(w, r, http.StatusOK, resp)
+}
+
+// requestURL reconstructs the absolute URL the client asked for.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func describeTLS(state *tls.ConnectionState) *echoTLS {
+	if state == nil {
+		return nil
+	}
+	info := &echoTLS{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+	}
+	for _, cert := range state.PeerCertificates {
+		info.PeerCertificates = append(info.PeerCertificates, cert.Subject.String())
+	}
+	return info
+}
+
+// Parsers POST /api/v1/echo picks from the Content-Type.
+const (
+	echoParserForm      = "form"
+	echoParserMultipart = "multipart"
+	echoParserText      = "text"
+	echoParserBinary    = "binary"
+)
+
+// BodyEchoResponse describes a POST /api/v1/echo body as the parser named
+// in Parser understood it. Bytes is the size of the whole body.
+type BodyEchoResponse struct {
+	Parser      string     `json:"parser"`
+	ContentType string     `json:"content_type,omitempty"`
+	Bytes       int64      `json:"bytes"`
+	Form        url.Values `json:"form,omitempty"`
+	Parts       []EchoPart `json:"parts,omitempty"`
+	Text        string     `json:"text,omitempty"`
+	Characters  int        `json:"characters,omitempty"`
+	SHA256      string     `json:"sha256,omitempty"`
+	// Preview is the first ECHO_PREVIEW_BYTES of a binary body, base64
+	// encoded.
+	Preview          string `json:"preview,omitempty"`
+	PreviewTruncated bool   `json:"preview_truncated,omitempty"`
+}
+
+// EchoPart is one part of a multipart body. File contents are counted and
+// discarded, never stored.
+type EchoPart struct {
+	Name        string `json:"name"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// bodyEchoHandler handles POST /api/v1/echo: form fields for
+// application/x-www-form-urlencoded, part names, file names, and sizes for
+// multipart/form-data, the text and its length for text/*, and for
+// anything else, or text that isn't UTF-8, the size, SHA-256, and a base64
+// preview. Bodies over MAX_BODY_BYTES get 413 and malformed ones 400.
+func (s *Server) bodyEchoHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	body := &countingReader{r: r.Body}
+	resp := BodyEchoResponse{ContentType: contentType}
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		data, err := io.ReadAll(body)
+		if err != nil {
+			writeBodyReadError(w, r, err, "Cannot read request body")
+			return
+		}
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Invalid form body", err.Error())
+			return
+		}
+		resp.Parser, resp.Form = echoParserForm, form
+	case mediaType == "multipart/form-data":
+		if params["boundary"] == "" {
+			writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Invalid multipart body", "Content-Type has no boundary")
+			return
+		}
+		resp.Parser, resp.Parts = echoParserMultipart, []EchoPart{}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) && len(resp.Parts) == 0 {
+				// Also what a body without a single boundary line gets.
+				writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Invalid multipart body", "body has no parts")
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				writeBodyReadError(w, r, err, "Invalid multipart body")
+				return
+			}
+			size, err := io.Copy(io.Discard, part)
+			part.Close()
+			if err != nil {
+				writeBodyReadError(w, r, err, "Invalid multipart body")
+				return
+			}
+			resp.Parts = append(resp.Parts, EchoPart{Name: part.FormName(), Filename: part.FileName(), ContentType: part.Header.Get("Content-Type"), Size: size})
+		}
+	default:
+		data, err := io.ReadAll(body)
+		if err != nil {
+			writeBodyReadError(w, r, err, "Cannot read request body")
+			return
+		}
+		if strings.HasPrefix(mediaType, "text/") && utf8.Valid(data) {
+			resp.Parser, resp.Text, resp.Characters = echoParserText, string(data), utf8.RuneCount(data)
+			break
+		}
+		sum := sha256.Sum256(data)
+		preview := data[:min(int64(len(data)), s.cfg.EchoPreviewBytes)]
+		resp.Parser, resp.SHA256 = echoParserBinary, hex.EncodeToString(sum[:])
+		resp.Preview, resp.PreviewTruncated = base64.StdEncoding.EncodeToString(preview), len(preview) < len(data)
+	}
+	resp.Bytes = body.n
+	writeResponse(w, r, http.StatusOK, resp)
+}
+
+// writeBodyReadError answers a body that couldn't be read: 413 past the
+// body limit, 400 with message otherwise.
+func writeBodyReadError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var maxBytesErr *http.MaxBytesError
+	var encodingErr *bodyEncodingError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Request body too large", fmt.Sprintf("body must not exceed %d bytes", maxBytesErr.Limit))
+	case errors.As(err, &encodingErr):
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Cannot decompress request body", encodingErr.err.Error())
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, message, err.Error())
+	}
+}
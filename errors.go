@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error codes returned in ErrorResponse.Code. They are part of the API
+// contract: clients branch on them, so existing codes never change meaning.
+const (
+	codeInvalidJSON          = "invalid_json"
+	codeInvalidBody          = "invalid_body"
+	codeValidationFailed     = "validation_failed"
+	codeBodyTooLarge         = "body_too_large"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeNotAcceptable        = "not_acceptable"
+	codeInvalidParameter     = "invalid_parameter"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeUnauthorized         = "unauthorized"
+	codeInvalidToken         = "invalid_token"
+	codeInvalidSignature     = "invalid_signature"
+	codeForbidden            = "forbidden"
+	codeRateLimited          = "rate_limited"
+	codeIdempotencyConflict  = "idempotency_key_conflict"
+	codeFaultInjected        = "fault_injected"
+	codeUpstreamError        = "upstream_error"
+	codeUpstreamTimeout      = "upstream_timeout"
+	codeRequestTimeout       = "request_timeout"
+	codeUnavailable          = "unavailable"
+	codeCircuitOpen          = "circuit_open"
+	codeReadOnly             = "read_only"
+	codeBatchAborted         = "batch_aborted"
+	codeJobFinished          = "job_finished"
+	codeVersionConflict      = "version_conflict"
+	codePreconditionRequired = "precondition_required"
+	codeShutdownPending      = "shutdown_pending"
+	codeDumpInProgress       = "dump_in_progress"
+	codeLoadTestRunning      = "loadtest_running"
+	codeMaintenance          = "maintenance"
+	codeItemPurged           = "item_purged"
+	codeNotReplayable        = "not_replayable"
+	codeTenantRequired       = "tenant_required"
+	codeUnknownTenant        = "unknown_tenant"
+	codeInternal             = "internal_error"
+)
+
+// errorCodes lists every code for the OpenAPI document.
+var errorCodes = []any{
+	codeInvalidJSON,
+	codeInvalidBody,
+	codeValidationFailed,
+	codeBodyTooLarge,
+	codeUnsupportedMediaType,
+	codeNotAcceptable,
+	codeInvalidParameter,
+	codeNotFound,
+	codeMethodNotAllowed,
+	codeUnauthorized,
+	codeInvalidToken,
+	codeInvalidSignature,
+	codeForbidden,
+	codeRateLimited,
+	codeIdempotencyConflict,
+	codeFaultInjected,
+	codeUpstreamError,
+	codeUpstreamTimeout,
+	codeRequestTimeout,
+	codeUnavailable,
+	codeCircuitOpen,
+	codeReadOnly,
+	codeBatchAborted,
+	codeJobFinished,
+	codeVersionConflict,
+	codePreconditionRequired,
+	codeShutdownPending,
+	codeDumpInProgress,
+	codeLoadTestRunning,
+	codeMaintenance,
+	codeItemPurged,
+	codeNotReplayable,
+	codeTenantRequired,
+	codeUnknownTenant,
+	codeInternal,
+}
+
+// ErrorResponse is the body of every error. Error is the human-readable
+// message kept from before codes existed; Code is the stable,
+// machine-readable form.
+type ErrorResponse struct {
+	Error     string       `json:"error"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+	Path      string       `json:"path,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	// RetryAfterSeconds repeats Retry-After on 429, 502, and 503, for
+	// clients that don't read headers.
+	RetryAfterSeconds int `json:"retry_after_seconds,omitempty"`
+}
+
+// FieldError describes one invalid field of a request.
+type FieldError struct {
+	Field      string `json:"field"`
+	Constraint string `json:"constraint,omitempty"`
+	Message    string `json:"message"`
+	Value      any    `json:"value,omitempty"`
+}
+
+// writeError writes an ErrorResponse with the request's ID. detail is an
+// optional free-text explanation and details lists per-field problems. 404
+// and 405 responses also echo the requested path. msg is replaced by its
+// translation when the request asks for another language than English;
+// detail and details stay in English.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg, detail string, details ...FieldError) {
+	varyLanguage(w.Header())
+	writeResponse(w, r, status, newErrorResponse(r, status, code, msg, detail, details...))
+}
+
+func newErrorResponse(r *http.Request, status int, code, msg, detail string, details ...FieldError) ErrorResponse {
+	resp := ErrorResponse{
+		Error:     localizeError(r, code, msg),
+		Code:      code,
+		Detail:    detail,
+		Details:   details,
+		RequestID: requestIDFrom(r.Context()),
+	}
+	if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+		resp.Path = r.URL.Path
+	}
+	return resp
+}
+
+// retryAfterDefault is the backoff asked for when the failed component
+// can't tell when it will recover, such as a full queue or an unreachable
+// Redis.
+const retryAfterDefault = 5 * time.Second
+
+// retryAfterSeconds rounds d up to Retry-After's whole seconds, and to at
+// least one so clients never retry straight away.
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// writeRetryableError is writeError for 429, 502, and 503 answers. The
+// component refusing the request knows when to come back, so it passes
+// retryAfter, which is sent both as Retry-After and in the body.
+func writeRetryableError(w http.ResponseWriter, r *http.Request, status int, retryAfter time.Duration, code, msg, detail string) {
+	resp := newErrorResponse(r, status, code, msg, detail)
+	resp.RetryAfterSeconds = retryAfterSeconds(retryAfter)
+	varyLanguage(w.Header())
+	w.Header().Set("Retry-After", strconv.Itoa(resp.RetryAfterSeconds))
+	writeResponse(w, r, status, resp)
+}
+
+// writeDecodeError reports a decodeJSON failure.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var decErr *decodeError
+	errors.As(err, &decErr)
+	writeError(w, r, decErr.Status, decErr.Code, decErr.Message, decErr.Detail)
+}
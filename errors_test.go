@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// checkRetryAfter asserts rec is a status answer with code whose
+// Retry-After header and body agree on seconds.
+func checkRetryAfter(t *testing.T, rec *httptest.ResponseRecorder, status int, code string, seconds int) {
+	t.Helper()
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%d %s: %v", rec.Code, rec.Body, err)
+	}
+	if rec.Code != status || body.Code != code {
+		t.Errorf("got %d %s, want %d %s", rec.Code, body.Code, status, code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(seconds) || body.RetryAfterSeconds != seconds {
+		t.Errorf("Retry-After %q, retry_after_seconds %d; want both %d", got, body.RetryAfterSeconds, seconds)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 1},
+		{-time.Second, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{2 * time.Minute, 120},
+	} {
+		if got := retryAfterSeconds(tc.d); got != tc.want {
+			t.Errorf("retryAfterSeconds(%v) = %d, want %d", tc.d, got, tc.want)
+		}
+	}
+}
+
+// TestRetryAfterSources checks each component that refuses requests asks
+// for the backoff it knows about.
+func TestRetryAfterSources(t *testing.T) {
+	t.Run("rate limiter", func(t *testing.T) {
+		_, h := newTestServer(t, func(cfg *Config) { cfg.RateLimitRPS, cfg.RateLimitBurst = 0.25, 1 })
+		serve(h, newRequest(t, "GET", "/version", ""))
+		checkRetryAfter(t, serve(h, newRequest(t, "GET", "/version", "")), http.StatusTooManyRequests, codeRateLimited, 4)
+	})
+	t.Run("circuit breaker", func(t *testing.T) {
+		cfg := defaultConfig()
+		cfg.CircuitBreakerThreshold = 1
+		cfg.CircuitBreakerOpenDuration = 45 * time.Second
+		cfg.WarmupTimeout = 0
+		store := &downStore{memoryStore: newMemoryStore()}
+		srv, h := startTestServer(t, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+		srv.warmUp(context.Background())
+		store.down.Store(true)
+		serve(h, newRequest(t, "GET", "/api/v1/items/x", ""))
+		checkRetryAfter(t, serve(h, newRequest(t, "GET", "/api/v1/items/x", "")), http.StatusServiceUnavailable, codeCircuitOpen, 45)
+	})
+	t.Run("maintenance", func(t *testing.T) {
+		srv, h := newTestServer(t)
+		srv.maintenance.Set(true, "upgrading", 90*time.Second, "test", time.Now())
+		checkRetryAfter(t, serve(h, newRequest(t, "GET", "/api/v1/items", "")), http.StatusServiceUnavailable, codeMaintenance, 90)
+	})
+	t.Run("read-only mode", func(t *testing.T) {
+		srv, h := newTestServer(t, func(cfg *Config) { cfg.ReadOnlyProbeInterval = 15 * time.Second })
+		srv.readOnly.Set(true, "test")
+		checkRetryAfter(t, serve(h, newRequest(t, "POST", "/api/v1/items", `{"name": "x"}`)), http.StatusServiceUnavailable, codeReadOnly, 15)
+	})
+	t.Run("concurrency limit", func(t *testing.T) {
+		l := newConcurrencyLimiter("test", concurrencyLimit{Max: 1, QueueTimeout: 20 * time.Millisecond})
+		release := make(chan struct{})
+		started := make(chan struct{})
+		h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}))
+		go serve(h, newRequest(t, "GET", "/api/v1/items", ""))
+		<-started
+		defer close(release)
+		checkRetryAfter(t, serve(h, newRequest(t, "GET", "/api/v1/items", "")), http.StatusServiceUnavailable, codeUnavailable, 1)
+	})
+	t.Run("backend proxy", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend := "http://" + ln.Addr().String()
+		ln.Close()
+		_, h := newTestServer(t, func(cfg *Config) { cfg.BackendURL = backend })
+		checkRetryAfter(t, serve(h, newRequest(t, "GET", "/backend/thing", "")), http.StatusBadGateway, codeUpstreamError, retryAfterSeconds(retryAfterDefault))
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// heartbeatEvent is the payload of each /events message.
+type heartbeatEvent struct {
+	Seq       uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Hostname  string    `json:"hostname"`
+}
+
+// eventStreams serves /events and ends every open stream on shutdown, since
+// http.Server.Shutdown otherwise waits on them until its deadline.
+type eventStreams struct {
+	interval time.Duration
+	hostname string
+	clock    Clock
+
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+func newEventStreams(interval time.Duration, hostname string, clock Clock) *eventStreams {
+	return &eventStreams{interval: interval, hostname: hostname, clock: clock, closing: make(chan struct{})}
+}
+
+// Close ends all open streams. It is registered with
+// http.Server.RegisterOnShutdown.
+func (es *eventStreams) Close() {
+	es.closeOnce.Do(func() { close(es.closing) })
+}
+
+// ServeHTTP streams a heartbeat event every interval until the client
+// disconnects, ?count= events have been sent, or the server shuts down.
+// A Last-Event-ID header resumes the sequence after that ID.
+func (es *eventStreams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	count := 0
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", fmt.Sprintf("count must be a positive integer, got %q", v))
+			return
+		}
+		count = n
+	}
+	var seq uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		last, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid Last-Event-ID", fmt.Sprintf("expected a sequence number, got %q", v))
+			return
+		}
+		seq = last
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// Stop nginx-style proxies from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func() error {
+		seq++
+		data, err := json.Marshal(heartbeatEvent{Seq: seq, Timestamp: es.clock.Now(), Hostname: es.hostname})
+		if err != nil {
+			return err
+		}
+		// The server's WriteTimeout would otherwise cut long streams off.
+		rc.SetWriteDeadline(time.Now().Add(es.interval + 10*time.Second))
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: heartbeat\ndata: %s\n\n", seq, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	ticker := time.NewTicker(es.interval)
+	defer ticker.Stop()
+	for sent := 0; count == 0 || sent < count; sent++ {
+		if sent > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-es.closing:
+				return
+			case <-ticker.C:
+			}
+		}
+		if err := send(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault modes accepted by POST /admin/fault.
+const (
+	faultUnhealthy = "unhealthy"
+	faultNotReady  = "not_ready"
+	faultLatency   = "latency"
+	faultErrorRate = "error_rate"
+)
+
+// Fault is one injected misbehavior. Latency carries the added delay for
+// latency faults and Percent the failure rate for error_rate faults.
+type Fault struct {
+	Mode      string        `json:"mode"`
+	Latency   time.Duration `json:"-"`
+	Percent   float64       `json:"-"`
+	Value     any           `json:"value,omitempty"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// faultInjector holds the active faults, one per mode. Faults expire on
+// their own; expired entries are simply ignored and pruned on the next
+// change.
+type faultInjector struct {
+	mu     sync.RWMutex
+	faults map[string]Fault
+	now    func() time.Time
+}
+
+func newFaultInjector(now func() time.Time) *faultInjector {
+	return &faultInjector{faults: make(map[string]Fault), now: now}
+}
+
+// active returns the unexpired fault for mode, if any.
+func (fi *faultInjector) active(mode string) (Fault, bool) {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	f, ok := fi.faults[mode]
+	if !ok || !fi.now().Before(f.ExpiresAt) {
+		return Fault{}, false
+	}
+	return f, true
+}
+
+// List returns the unexpired faults ordered by mode.
+func (fi *faultInjector) List() []Fault {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	now := fi.now()
+	list := make([]Fault, 0, len(fi.faults))
+	for _, f := range fi.faults {
+		if now.Before(f.ExpiresAt) {
+			list = append(list, f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Mode < list[j].Mode })
+	return list
+}
+
+// Set activates f, replacing any fault with the same mode.
+func (fi *faultInjector) Set(f Fault) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	now := fi.now()
+	for mode, existing := range fi.faults {
+		if !now.Before(existing.ExpiresAt) {
+			delete(fi.faults, mode)
+		}
+	}
+	fi.faults[f.Mode] = f
+}
+
+// Clear removes every fault.
+func (fi *faultInjector) Clear() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	clear(fi.faults)
+}
+
+// Unhealthy reports whether liveness should fail.
+func (fi *faultInjector) Unhealthy() bool {
+	_, ok := fi.active(faultUnhealthy)
+	return ok
+}
+
+// Name and Check make the not_ready fault a readiness check.
+func (fi *faultInjector) Name() string { return "fault" }
+
+func (fi *faultInjector) Check(ctx context.Context) error {
+	if f, ok := fi.active(faultNotReady); ok {
+		return fmt.Errorf("not_ready fault injected until %s", f.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// withFaults applies active latency and error_rate faults to every request
+// except those under /admin/, so faults can always be cleared, and /metrics.
+func withFaults(next http.Handler, fi *faultInjector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f, ok := fi.active(faultLatency); ok {
+			if !delayResponse(w, r, f.Latency) {
+				return
+			}
+		}
+		if f, ok := fi.active(faultErrorRate); ok && rand.Float64()*100 < f.Percent {
+			writeError(w, r, http.StatusInternalServerError, codeFaultInjected, "Injected fault", fmt.Sprintf("error_rate fault failing %g%% of requests", f.Percent))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// faultRequest is the body of POST /admin/fault.
+type faultRequest struct {
+	Mode     string          `json:"mode"`
+	Value    json.RawMessage `json:"value"`
+	Duration string          `json:"duration"`
+}
+
+// parseFault validates req into a Fault expiring duration from now.
+func parseFault(req faultRequest, now time.Time) (Fault, error) {
+	if req.Duration == "" {
+		return Fault{}, errors.New("duration is required")
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		return Fault{}, fmt.Errorf("duration %q must be a positive duration such as 30s", req.Duration)
+	}
+	f := Fault{Mode: req.Mode, ExpiresAt: now.Add(duration)}
+
+	switch req.Mode {
+	case faultUnhealthy, faultNotReady:
+	case faultLatency:
+		var value string
+		if err := json.Unmarshal(req.Value, &value); err != nil {
+			return Fault{}, errors.New(`latency value must be a duration string such as "500ms"`)
+		}
+		f.Latency, err = time.ParseDuration(value)
+		if err != nil || f.Latency <= 0 {
+			return Fault{}, fmt.Errorf("latency value %q must be a positive duration", value)
+		}
+		f.Value = value
+	case faultErrorRate:
+		if err := json.Unmarshal(req.Value, &f.Percent); err != nil || f.Percent < 0 || f.Percent > 100 {
+			return Fault{}, errors.New("error_rate value must be a percentage between 0 and 100")
+		}
+		f.Value = f.Percent
+	default:
+		return Fault{}, fmt.Errorf("mode %q must be one of unhealthy, not_ready, latency, error_rate", req.Mode)
+	}
+	return f, nil
+}
+
+// faultsHandler serves /admin/fault: GET lists active faults, POST adds one,
+// and DELETE clears them all.
+func (s *Server) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	fi := s.faults
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeResponse(w, r, http.StatusOK, map[string]any{"faults": fi.List()})
+	case http.MethodPost:
+		var req faultRequest
+		if err := decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		f, err := parseFault(req, fi.now())
+		if err != nil {
+			writeError(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "Invalid fault", err.Error())
+			return
+		}
+		fi.Set(f)
+		writeResponse(w, r, http.StatusCreated, f)
+	case http.MethodDelete:
+		fi.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FetchResponse summarizes an outbound request made by /fetch.
+type FetchResponse struct {
+	URL          string              `json:"url"`
+	FinalURL     string              `json:"final_url"`
+	StatusCode   int                 `json:"status_code"`
+	Status       string              `json:"status"`
+	LatencyMS    float64             `json:"latency_ms"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding"`
+	BodyBytes    int                 `json:"body_bytes"`
+	Truncated    bool                `json:"truncated"`
+	Timestamp    time.Time           `json:"timestamp"`
+}
+
+// errFetchRedirectLimit stops a redirect chain longer than FETCH_MAX_REDIRECTS.
+var errFetchRedirectLimit = errors.New("too many redirects")
+
+// allowedTarget is one entry of FETCH_ALLOWED_HOSTS or
+// WEBHOOK_ALLOWED_HOSTS, parsed once at startup: a URL prefix (scheme and
+// host, with the port and a path if given), a host with or without a port,
+// or a subdomain wildcard, whose suffix is ".example.com".
+type allowedTarget struct {
+	entry  string
+	scheme string
+	host   string
+	port   string
+	path   string
+	suffix string
+}
+
+// hostAllowlist lists the targets an outbound call may go to.
+type hostAllowlist []allowedTarget
+
+// parseHostAllowlist parses allowlist entries. Entries containing "://"
+// are URL prefixes; others are hosts, where a leading "." or "*." admits
+// every subdomain.
+func parseHostAllowlist(entries []string) (hostAllowlist, error) {
+	allowed := make(hostAllowlist, 0, len(entries))
+	for _, entry := range entries {
+		t := allowedTarget{entry: entry}
+		lower := strings.ToLower(entry)
+		switch {
+		case strings.Contains(lower, "://"):
+			u, err := url.Parse(lower)
+			switch {
+			case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "":
+				return nil, fmt.Errorf("entry %q must be an http or https URL with a host", entry)
+			case u.User != nil || u.RawQuery != "" || u.Fragment != "":
+				return nil, fmt.Errorf("entry %q must not have credentials, a query, or a fragment", entry)
+			case hasDotSegment(u.Path):
+				return nil, fmt.Errorf("entry %q must not have . or .. in its path", entry)
+			}
+			t.scheme, t.host, t.port = u.Scheme, u.Hostname(), effectivePort(u)
+			t.path = strings.TrimSuffix(u.Path, "/")
+		case strings.HasPrefix(lower, "*.") || strings.HasPrefix(lower, "."):
+			t.suffix = "." + strings.TrimPrefix(strings.TrimPrefix(lower, "*"), ".")
+			if t.suffix == "." || strings.ContainsAny(t.suffix, "*:/@") {
+				return nil, fmt.Errorf("entry %q must be a wildcard like *.example.com", entry)
+			}
+		case strings.ContainsAny(lower, "*/@"):
+			return nil, fmt.Errorf("entry %q is not a host, a *.domain wildcard, or a URL", entry)
+		default:
+			t.host = lower
+			if h, port, err := net.SplitHostPort(lower); err == nil {
+				t.host, t.port = h, port
+			}
+			if t.host == "" {
+				return nil, fmt.Errorf("entry %q has no host", entry)
+			}
+		}
+		allowed = append(allowed, t)
+	}
+	return allowed, nil
+}
+
+// Entries returns the allowlist as configured.
+func (a hostAllowlist) Entries() []string {
+	entries := make([]string, len(a))
+	for i, t := range a {
+		entries[i] = t.entry
+	}
+	return entries
+}
+
+// Allows reports whether target matches an entry. Targets carrying
+// credentials never do, since "https://api.example.com@evil.net/" is a
+// request to evil.net. URL prefixes need the same scheme, host, and port,
+// and match the path on whole segments: "https://example.com/public" admits
+// /public and /public/x but not /publicity or /public/../admin. Hosts
+// without a port admit every port, and wildcards every subdomain, but not
+// "evilexample.com" for "*.example.com".
+func (a hostAllowlist) Allows(target *url.URL) bool {
+	if target.User != nil {
+		return false
+	}
+	host := strings.ToLower(target.Hostname())
+	for _, t := range a {
+		switch {
+		case t.scheme != "":
+			if strings.ToLower(target.Scheme) == t.scheme && host == t.host &&
+				effectivePort(target) == t.port && pathWithin(target.Path, t.path) {
+				return true
+			}
+		case t.suffix != "":
+			if len(host) > len(t.suffix) && strings.HasSuffix(host, t.suffix) {
+				return true
+			}
+		case host == t.host && (t.port == "" || effectivePort(target) == t.port):
+			return true
+		}
+	}
+	return false
+}
+
+// effectivePort is u's port, or its scheme's default.
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return "443"
+	case "http":
+		return "80"
+	}
+	return ""
+}
+
+// pathWithin reports whether p is prefix or below it, segment by segment.
+// Dot segments are refused, since the server resolving them decides where
+// they lead.
+func pathWithin(p, prefix string) bool {
+	if hasDotSegment(p) {
+		return false
+	}
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+func hasDotSegment(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// newFetchClient returns the outbound client for /fetch. Redirects are
+// followed only up to maxRedirects and only to allowed targets; requests are
+// traced, retried as HTTP_CLIENT_* says, and carry the inbound request's
+// context.
+func newFetchClient(hc httpClientConfig, allowed hostAllowlist, maxRedirects int) *http.Client {
+	client := newHTTPClient(hc, 0)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return errFetchRedirectLimit
+		}
+		if !allowed.Allows(req.URL) {
+			return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+		}
+		return nil
+	}
+	return client
+}
+
+// fetchHandler serves GET /fetch?url=: an outbound GET to an allowlisted
+// target, bounded by FETCH_TIMEOUT, summarized with the first
+// FETCH_MAX_BODY_BYTES of the body. The upstream call is canceled if the
+// client goes away.
+func (s *Server) fetchHandler(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("url")
+	target, err := url.Parse(raw)
+	if raw == "" || err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid url", "url must be an absolute http or https URL")
+		return
+	}
+	if !s.cfg.FetchAllowedHosts.Allows(target) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "Target not allowed", target.Host+" is not in FETCH_ALLOWED_HOSTS")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.FetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid url", err.Error())
+		return
+	}
+	req.Header.Set("User-Agent", "my-go-app/"+s.build.Version+" (fetch)")
+
+	start := time.Now()
+	resp, err := s.fetchClient.Do(req)
+	if err != nil {
+		observeFetch(ctx, target.Hostname(), "error", time.Since(start))
+		s.writeFetchError(w, r, ctx, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	limit := int64(s.cfg.FetchMaxBodyBytes)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	latency := time.Since(start)
+	observeFetch(ctx, target.Hostname(), strconv.Itoa(resp.StatusCode), latency)
+	if err != nil {
+		s.writeFetchError(w, r, ctx, err)
+		return
+	}
+
+	out := FetchResponse{
+		URL:        target.String(),
+		FinalURL:   resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		LatencyMS:  float64(latency.Microseconds()) / 1000,
+		Headers:    resp.Header,
+		Timestamp:  s.clock.Now(),
+	}
+	if int64(len(body)) > limit {
+		body = body[:limit]
+		out.Truncated = true
+	}
+	out.BodyBytes = len(body)
+	if utf8.Valid(body) {
+		out.Body, out.BodyEncoding = string(body), "utf-8"
+	} else {
+		out.Body, out.BodyEncoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	writeResponse(w, r, http.StatusOK, out)
+}
+
+// writeFetchError reports a failed upstream call: 504 on timeout, 502
+// otherwise. Nothing is written if the client itself went away.
+func (s *Server) writeFetchError(w http.ResponseWriter, r *http.Request, ctx context.Context, err error) {
+	if r.Context().Err() != nil {
+		return
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		writeError(w, r, http.StatusGatewayTimeout, codeUpstreamTimeout, "Upstream timed out", "no response within "+s.cfg.FetchTimeout.String())
+		return
+	}
+	writeRetryableError(w, r, http.StatusBadGateway, retryAfterDefault, codeUpstreamError, "Upstream request failed", err.Error())
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// itemFields are the top-level fields of an item's JSON form, in order:
+// what ?fields= and ?exclude= may name.
+var itemFields = jsonFieldNames(reflect.TypeFor[Item]())
+
+// jsonFieldNames lists the json names of t's fields.
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// fieldSelection is a parsed ?fields= or ?exclude=: the top-level item
+// fields a response keeps. The id is always kept.
+type fieldSelection struct {
+	keep map[string]bool
+	// key identifies the selection in ETags, whatever order or repeats
+	// the query named the fields in.
+	key string
+}
+
+// parseFieldSelection reads ?fields= (a comma-separated list of the fields
+// to return) or ?exclude= (those to leave out), which can't be combined. It
+// returns nil when neither is given. Unknown names are reported as a
+// FieldError listing the valid ones.
+func parseFieldSelection(r *http.Request) (*fieldSelection, *FieldError) {
+	values := r.URL.Query()
+	fields, exclude := values.Get("fields"), values.Get("exclude")
+	param, list := "fields", fields
+	switch {
+	case fields != "" && exclude != "":
+		return nil, &FieldError{Field: "exclude", Constraint: "conflict", Message: "cannot be combined with fields"}
+	case exclude != "":
+		param, list = "exclude", exclude
+	case fields == "":
+		return nil, nil
+	}
+
+	named := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(itemFields, name) {
+			return nil, &FieldError{
+				Field:      param,
+				Constraint: "enum",
+				Message:    fmt.Sprintf("has unknown field %q; valid fields are %s", name, strings.Join(itemFields, ", ")),
+				Value:      name,
+			}
+		}
+		named[name] = true
+	}
+	if param == "exclude" && named["id"] {
+		return nil, &FieldError{Field: param, Constraint: "enum", Message: "may not name id, which is always returned", Value: "id"}
+	}
+
+	sel := &fieldSelection{keep: make(map[string]bool)}
+	for _, name := range itemFields {
+		if name == "id" || named[name] != (param == "exclude") {
+			sel.keep[name] = true
+			sel.key += name + ","
+		}
+	}
+	return sel, nil
+}
+
+// readFieldSelection is parseFieldSelection for handlers: it answers a bad
+// selection with a 400 itself and returns false.
+func readFieldSelection(w http.ResponseWriter, r *http.Request) (*fieldSelection, bool) {
+	sel, problem := parseFieldSelection(r)
+	if problem != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", problem.Field+" "+problem.Message, *problem)
+		return nil, false
+	}
+	return sel, true
+}
+
+// etag derives the ETag of the selected representation from the full
+// one's: weak, since the partial body can't stand in for the item in
+// If-Match, and distinct per selection.
+func (sel *fieldSelection) etag(full string) string {
+	h := fnv.New64a()
+	io.WriteString(h, sel.key)
+	return fmt.Sprintf(`W/"%s-%x"`, strings.Trim(strings.TrimPrefix(full, "W/"), `"`), h.Sum64())
+}
+
+// selectedJSON is a response already shaped by a fieldSelection. It
+// remembers the type it was made from, so XML has the same root element.
+type selectedJSON struct {
+	data json.RawMessage
+	root string
+}
+
+func (s selectedJSON) MarshalJSON() ([]byte, error) { return s.data, nil }
+
+func (s selectedJSON) xmlRoot() string { return s.root }
+
+// object filters the serialized form of v, a JSON object, down to the
+// selected fields, keeping their order. Fields v omits when empty stay
+// omitted even if selected.
+func (sel *fieldSelection) object(v any) (selectedJSON, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return selectedJSON{}, err
+	}
+	out, err := filterJSONObject(data, func(key string, value json.RawMessage) (json.RawMessage, bool) {
+		return value, sel.keep[key]
+	})
+	return selectedJSON{data: out, root: xmlRootName(v)}, err
+}
+
+// list applies sel to every object in the array at key of v's serialized
+// form, a list envelope, leaving the envelope's other members, such as
+// total and limit, as they are.
+func (sel *fieldSelection) list(v any, key string) (selectedJSON, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return selectedJSON{}, err
+	}
+	keep := func(k string, value json.RawMessage) (json.RawMessage, bool) { return value, sel.keep[k] }
+	var elemErr error
+	out, err := filterJSONObject(data, func(k string, value json.RawMessage) (json.RawMessage, bool) {
+		if k != key {
+			return value, true
+		}
+		var elems []json.RawMessage
+		if elemErr = json.Unmarshal(value, &elems); elemErr != nil {
+			return value, true
+		}
+		for i, elem := range elems {
+			if elems[i], elemErr = filterJSONObject(elem, keep); elemErr != nil {
+				return value, true
+			}
+		}
+		filtered, _ := json.Marshal(elems)
+		return filtered, true
+	})
+	if err == nil {
+		err = elemErr
+	}
+	return selectedJSON{data: out, root: xmlRootName(v)}, err
+}
+
+// filterJSONObject rewrites the JSON object data member by member, in
+// order: fn returns each member's new value, or false to drop it.
+func filterJSONObject(data []byte, fn func(key string, value json.RawMessage) (json.RawMessage, bool)) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		value, keep := fn(key, value)
+		if !keep {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeSelected is writeResponse for an item or, with listKey naming its
+// items, a list envelope, with sel applied when it isn't nil.
+func writeSelected(w http.ResponseWriter, r *http.Request, sel *fieldSelection, v any, listKey string) {
+	if sel == nil {
+		writeResponse(w, r, http.StatusOK, v)
+		return
+	}
+	var shaped selectedJSON
+	var err error
+	if listKey != "" {
+		shaped, err = sel.list(v, listKey)
+	} else {
+		shaped, err = sel.object(v)
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("cannot select response fields", slog.String("type", fmt.Sprintf("%T", v)), slog.Any("error", err))
+		w.Header().Del("ETag")
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "internal server error", "response could not be encoded")
+		return
+	}
+	writeResponse(w, r, http.StatusOK, shaped)
+}
+
+// ndjsonFormat is ndjsonFormat with sel applied to each line.
+func (sel *fieldSelection) ndjsonFormat() itemFormat {
+	return itemFormat{contentType: contentTypeNDJSON, encoder: func(w io.Writer) itemEncoder {
+		return selectedNDJSONEncoder{w: w, sel: sel}
+	}}
+}
+
+type selectedNDJSONEncoder struct {
+	w   io.Writer
+	sel *fieldSelection
+}
+
+func (e selectedNDJSONEncoder) Encode(item Item) error {
+	line, err := e.sel.object(item)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(line.data, '\n'))
+	return err
+}
+
+func (selectedNDJSONEncoder) Close() error { return nil }
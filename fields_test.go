@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// topLevelKeys returns the keys of the JSON object data, in order.
+func topLevelKeys(t *testing.T, data []byte) []string {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		t.Fatalf("not a JSON object: %s", data)
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func TestFieldSelection(t *testing.T) {
+	_, h := newTestServer(t)
+	withData := createItem(t, h, `{"name": "widget", "data": {"color": "red"}}`)
+	noData := createItem(t, h, `{"name": "bare"}`)
+
+	tests := []struct {
+		name   string
+		item   Item
+		query  string
+		want   []string
+		status int
+	}{
+		{"fields", withData, "?fields=name,created_at", []string{"id", "name", "created_at"}, http.StatusOK},
+		{"fields in any order", withData, "?fields=created_at,,name", []string{"id", "name", "created_at"}, http.StatusOK},
+		{"id is always kept", withData, "?fields=version", []string{"id", "version"}, http.StatusOK},
+		{"exclude", withData, "?exclude=data,created_at,updated_at", []string{"id", "name", "version"}, http.StatusOK},
+		{"omitted field stays omitted", noData, "?fields=name,data", []string{"id", "name"}, http.StatusOK},
+		{"excluding an omitted field", noData, "?exclude=data", []string{"id", "name", "created_at", "updated_at", "version"}, http.StatusOK},
+		{"unknown field", withData, "?fields=name,colour", nil, http.StatusBadRequest},
+		{"both fields and exclude", withData, "?fields=name&exclude=data", nil, http.StatusBadRequest},
+		{"excluding id", withData, "?exclude=id", nil, http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(h, newRequest(t, "GET", "/api/v1/items/"+tc.item.ID+tc.query, ""))
+			if rec.Code != tc.status {
+				t.Fatalf("GET %s = %d %s, want %d", tc.query, rec.Code, rec.Body, tc.status)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if keys := topLevelKeys(t, rec.Body.Bytes()); !slices.Equal(keys, tc.want) {
+				t.Errorf("keys = %q, want %q", keys, tc.want)
+			}
+		})
+	}
+
+	rec := serve(h, newRequest(t, "GET", "/api/v1/items/"+withData.ID+"?fields=name,colour", ""))
+	var body ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if len(body.Details) != 1 || body.Details[0].Field != "fields" || !strings.Contains(body.Details[0].Message, strings.Join(itemFields, ", ")) {
+		t.Errorf("unknown field error = %s, want it to list the valid fields", rec.Body)
+	}
+	rec = serve(h, newRequest(t, "GET", "/api/v1/items/"+withData.ID+"?fields=name&exclude=data", ""))
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if len(body.Details) != 1 || body.Details[0].Constraint != "conflict" {
+		t.Errorf("fields with exclude error = %s, want a conflict", rec.Body)
+	}
+}
+
+func TestFieldSelectionListsAndStreams(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, name := range []string{"a", "b", "c"} {
+		createItem(t, h, `{"name": "`+name+`", "data": {"n": 1}}`)
+	}
+
+	rec := serve(h, newRequest(t, "GET", "/api/v1/items?fields=name&sort=name&limit=2&offset=1", ""))
+	var list struct {
+		Items  []json.RawMessage `json:"items"`
+		Total  int               `json:"total"`
+		Limit  int               `json:"limit"`
+		Offset int               `json:"offset"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 3 || list.Limit != 2 || list.Offset != 1 || len(list.Items) != 2 {
+		t.Fatalf("list = %s, want the envelope untouched", rec.Body)
+	}
+	for _, item := range list.Items {
+		if keys := topLevelKeys(t, item); !slices.Equal(keys, []string{"id", "name"}) {
+			t.Errorf("listed item keys = %q, want id and name", keys)
+		}
+	}
+
+	rec = serve(h, newRequest(t, "GET", "/api/v1/items?format=ndjson&exclude=data,created_at,updated_at,version", ""))
+	lines := 0
+	for s := bufio.NewScanner(rec.Body); s.Scan(); lines++ {
+		if keys := topLevelKeys(t, s.Bytes()); !slices.Equal(keys, []string{"id", "name"}) {
+			t.Errorf("streamed item keys = %q, want id and name", keys)
+		}
+	}
+	if lines != 3 {
+		t.Errorf("streamed %d items, want 3", lines)
+	}
+}
+
+func TestFieldSelectionETags(t *testing.T) {
+	_, h := newTestServer(t)
+	item := createItem(t, h, `{"name": "widget", "data": {"color": "red"}}`)
+	etag := func(target string) string {
+		t.Helper()
+		rec := serve(h, newRequest(t, "GET", target, ""))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d", target, rec.Code)
+		}
+		return rec.Header().Get("ETag")
+	}
+
+	for _, base := range []string{"/api/v1/items/" + item.ID, "/api/v1/items"} {
+		full := etag(base)
+		name := etag(base + "?fields=name")
+		if name == full || name == etag(base+"?fields=data") || !strings.HasPrefix(name, "W/") {
+			t.Errorf("%s ETags: full %q, fields=name %q; want a weak ETag per selection", base, full, name)
+		}
+		r := newRequest(t, "GET", base+"?fields=name", "")
+		r.Header.Set("If-None-Match", name)
+		if rec := serve(h, r); rec.Code != http.StatusNotModified {
+			t.Errorf("%s?fields=name with its ETag = %d, want 304", base, rec.Code)
+		}
+		r = newRequest(t, "GET", base+"?fields=data", "")
+		r.Header.Set("If-None-Match", name)
+		if rec := serve(h, r); rec.Code != http.StatusOK {
+			t.Errorf("%s?fields=data with the fields=name ETag = %d, want 200", base, rec.Code)
+		}
+	}
+
+	// An item's ETag depends on the selection, not how the query spells it.
+	if etag("/api/v1/items/"+item.ID+"?fields=id,name,name") != etag("/api/v1/items/"+item.ID+"?fields=name") {
+		t.Error("equal selections got different ETags")
+	}
+
+	// A selected ETag is weak, so it can't stand in for the item in If-Match.
+	r := newRequest(t, "PUT", "/api/v1/items/"+item.ID, `{"name": "changed"}`)
+	r.Header.Set("If-Match", etag("/api/v1/items/"+item.ID+"?fields=name"))
+	if rec := serve(h, r); rec.Code != http.StatusConflict {
+		t.Errorf("PUT with a selected ETag = %d, want 409", rec.Code)
+	}
+}
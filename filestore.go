@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// openFileStore returns a memory store persisted to path. Existing items are
+// loaded from the file; a missing or corrupt file logs a warning and starts
+// empty rather than failing startup.
+func openFileStore(path string, logger *slog.Logger) *memoryStore {
+	s := newMemoryStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		logger.Info("data file does not exist yet, starting with an empty store", slog.String("file", path))
+		return s
+	case err != nil:
+		logger.Warn("cannot read data file, starting with an empty store", slog.String("file", path), slog.Any("error", err))
+		return s
+	}
+
+	var items []Item
+	if err := json.Unmarshal(data, &items); err != nil {
+		logger.Warn("data file is corrupt, starting with an empty store", slog.String("file", path), slog.Any("error", err))
+		return s
+	}
+	for _, item := range items {
+		if item.ID == "" {
+			continue
+		}
+		s.items[item.ID] = item
+	}
+	s.index.rebuild(s.items)
+	logger.Info("loaded items from data file", slog.Int("items", len(s.items)), slog.String("file", path))
+	return s
+}
+
+// flushLocked writes all items to s.path via a temp file and rename, so a
+// crash mid-write never leaves torn JSON behind. It is a no-op for purely
+// in-memory stores. The caller must hold s.mu, which also keeps concurrent
+// mutations out of the snapshot being written.
+func (s *memoryStore) flushLocked() error {
+	if s.path == "" {
+		return nil
+	}
+
+	items := make([]Item, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b Item) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode items: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("persist items: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("persist items: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("persist items: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("persist items: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("persist items: %w", err)
+	}
+	return nil
+}
+
+// ProbeWrite rewrites the data file unchanged, to find out whether writes
+// to it work again.
+func (s *memoryStore) ProbeWrite(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// featureOverridesHeader carries per-request flag overrides, e.g.
+// "newGreeting=true,otherFlag=false". It is honored only with debug
+// endpoints enabled.
+const featureOverridesHeader = "X-Feature-Overrides"
+
+// Where a flag's value came from, lowest precedence first.
+const (
+	flagSourceDefault = "default"
+	flagSourceEnv     = "env"
+	flagSourceFile    = "file"
+	flagSourceHeader  = "header"
+)
+
+// featureFlag is a behavior that can be switched per deployment.
+type featureFlag struct {
+	name        string
+	description string
+	defaultOn   bool
+}
+
+// featureFlags lists every flag. Each is set with FEATURE_<NAME>, the name in
+// upper snake case: newGreeting is FEATURE_NEW_GREETING.
+var featureFlags = []featureFlag{
+	{name: "newGreeting", description: "Reworded message on GET /"},
+}
+
+// flagSetting is a flag's configured value and where it came from.
+type flagSetting struct {
+	Enabled bool
+	Source  string
+}
+
+// FlagStatus reports a feature flag for /debug/flags.
+type FlagStatus struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+	Enabled     bool   `json:"enabled"`
+	Source      string `json:"source"`
+}
+
+// featureEnvName is the environment variable that sets the flag name.
+func featureEnvName(name string) string {
+	var b strings.Builder
+	b.WriteString("FEATURE_")
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// knownFlag reports whether name is defined in featureFlags.
+func knownFlag(name string) bool {
+	return slices.ContainsFunc(featureFlags, func(f featureFlag) bool { return f.name == name })
+}
+
+// defaultFlagSettings returns every flag at its default.
+func defaultFlagSettings() map[string]flagSetting {
+	settings := make(map[string]flagSetting, len(featureFlags))
+	for _, f := range featureFlags {
+		settings[f.name] = flagSetting{Enabled: f.defaultOn, Source: flagSourceDefault}
+	}
+	return settings
+}
+
+// Flags answers whether a feature is on for a request. The configured
+// values can be replaced while requests are being served.
+type Flags struct {
+	current atomic.Pointer[map[string]flagSetting]
+	// overrides enables the X-Feature-Overrides header.
+	overrides bool
+}
+
+func newFlags(settings map[string]flagSetting, overrides bool) *Flags {
+	f := &Flags{overrides: overrides}
+	f.Set(settings)
+	return f
+}
+
+// Set replaces the configured flag values.
+func (f *Flags) Set(settings map[string]flagSetting) {
+	f.current.Store(&settings)
+}
+
+// Enabled reports whether the flag name is on for the request behind ctx.
+// Unknown flags are off.
+func (f *Flags) Enabled(ctx context.Context, name string) bool {
+	return f.setting(ctx, name).Enabled
+}
+
+func (f *Flags) setting(ctx context.Context, name string) flagSetting {
+	if on, ok := flagOverridesFrom(ctx)[name]; ok {
+		return flagSetting{Enabled: on, Source: flagSourceHeader}
+	}
+	return (*f.current.Load())[name]
+}
+
+// List reports every flag as seen by the request behind ctx.
+func (f *Flags) List(ctx context.Context) []FlagStatus {
+	statuses := make([]FlagStatus, len(featureFlags))
+	for i, flag := range featureFlags {
+		setting := f.setting(ctx, flag.name)
+		statuses[i] = FlagStatus{
+			Name:        flag.name,
+			Description: flag.description,
+			Default:     flag.defaultOn,
+			Enabled:     setting.Enabled,
+			Source:      setting.Source,
+		}
+	}
+	return statuses
+}
+
+type flagOverridesKey struct{}
+
+func flagOverridesFrom(ctx context.Context) map[string]bool {
+	overrides, _ := ctx.Value(flagOverridesKey{}).(map[string]bool)
+	return overrides
+}
+
+// withFlagOverrides applies X-Feature-Overrides to the request, rejecting
+// unknown flags and non-boolean values. Without overrides enabled the
+// middleware is a no-op.
+func withFlagOverrides(next http.Handler, flags *Flags) http.Handler {
+	if !flags.overrides {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", featureOverridesHeader)
+		header := r.Header.Get(featureOverridesHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		overrides, err := parseFlagOverrides(header)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid "+featureOverridesHeader+" header", err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), flagOverridesKey{}, overrides)))
+	})
+}
+
+// parseFlagOverrides parses a comma-separated list of name=bool pairs.
+func parseFlagOverrides(header string) (map[string]bool, error) {
+	overrides := make(map[string]bool)
+	for _, entry := range splitList(header) {
+		name, value, _ := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !knownFlag(name) {
+			return nil, fmt.Errorf("unknown flag %q", name)
+		}
+		on, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("flag %q: value %q is not a boolean", name, value)
+		}
+		overrides[name] = on
+	}
+	return overrides, nil
+}
+
+// flagsHandler serves GET /debug/flags.
+func (s *Server) flagsHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, s.flags.List(r.Context()))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/example/my-go-app/echopb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcWatchInterval is how often Health.Watch re-evaluates readiness.
+const grpcWatchInterval = time.Second
+
+// grpcServer serves the gRPC health and echo services on GRPC_PORT. It
+// shares the HTTP server's readiness, logger, and metrics registry.
+type grpcServer struct {
+	srv    *grpc.Server
+	health *grpcHealth
+}
+
+func (s *Server) newGRPCServer() *grpcServer {
+	gs := &grpcServer{
+		srv: grpc.NewServer(
+			grpc.ChainUnaryInterceptor(s.grpcUnaryInterceptor),
+			grpc.ChainStreamInterceptor(s.grpcStreamInterceptor),
+		),
+		health: &grpcHealth{s: s, stopping: make(chan struct{})},
+	}
+	grpc_health_v1.RegisterHealthServer(gs.srv, gs.health)
+	echopb.RegisterEchoServiceServer(gs.srv, grpcEcho{s: s})
+	// Lets grpcurl list and call the services without the .proto files.
+	reflection.Register(gs.srv)
+	return gs
+}
+
+// Serve accepts connections on ln until Shutdown.
+func (gs *grpcServer) Serve(ln net.Listener) error {
+	return gs.srv.Serve(ln)
+}
+
+// Shutdown ends Health.Watch streams, which never finish on their own, then
+// lets in-flight calls finish. Calls still running when ctx ends are cut off.
+func (gs *grpcServer) Shutdown(ctx context.Context) error {
+	close(gs.health.stopping)
+	done := make(chan struct{})
+	go func() {
+		gs.srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		gs.srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// grpcHealth implements grpc.health.v1.Health from the same state as
+// /readyz. The empty service name and each registered service are known.
+type grpcHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+	s        *Server
+	stopping chan struct{}
+}
+
+func (h *grpcHealth) status(ctx context.Context, service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	if service != "" && service != grpc_health_v1.Health_ServiceDesc.ServiceName && service != echopb.EchoService_ServiceDesc.ServiceName {
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+	if code, _ := h.s.readiness(ctx); code != http.StatusOK {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING, nil
+}
+
+func (h *grpcHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	st, err := h.status(ctx, req.GetService())
+	if err != nil {
+		return nil, err
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status now and again whenever it changes. Unknown
+// services are reported as SERVICE_UNKNOWN rather than failing, as the
+// protocol asks.
+func (h *grpcHealth) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ticker := time.NewTicker(grpcWatchInterval)
+	defer ticker.Stop()
+	last := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+	for {
+		st, _ := h.status(stream.Context(), req.GetService())
+		if st != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-h.stopping:
+			// Tell watchers the server is going away before ending the
+			// stream, so they stop sending before the connection closes.
+			if last != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+				stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING})
+			}
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-ticker.C:
+		}
+	}
+}
+
+// grpcEcho implements demo.echo.v1.EchoService.
+type grpcEcho struct {
+	echopb.UnimplementedEchoServiceServer
+	s *Server
+}
+
+func (e grpcEcho) Echo(ctx context.Context, req *echopb.EchoRequest) (*echopb.EchoResponse, error) {
+	return &echopb.EchoResponse{
+		Message:   req.GetMessage(),
+		Timestamp: timestamppb.New(e.s.clock.Now()),
+	}, nil
+}
+
+// grpcContext gives a call the request ID from its x-request-id metadata,
+// or a new one, and a logger carrying it, as withRequestID and logRequests
+// do for HTTP.
+func (s *Server) grpcContext(ctx context.Context, method string) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(strings.ToLower(requestIDHeader)); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !validRequestID(id) {
+		id = newUUID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIDHeader), id))
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return contextWithLogger(ctx, s.logger.With(slog.String("request_id", id), slog.String("grpc_method", method)))
+}
+
+// observeGRPC logs a finished call and records it in the gRPC metrics.
+func observeGRPC(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	elapsed := time.Since(start)
+	grpcRequestsTotal.WithLabelValues(method, code.String()).Inc()
+	observeDuration(ctx, grpcRequestDuration.WithLabelValues(method), elapsed)
+
+	level := slog.LevelInfo
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("remote_addr", p.Addr.String()))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	loggerFrom(ctx).LogAttrs(ctx, level, "grpc request", attrs...)
+}
+
+func (s *Server) grpcUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx = s.grpcContext(ctx, info.FullMethod)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeGRPC(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (s *Server) grpcStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := s.grpcContext(ss.Context(), info.FullMethod)
+	start := time.Now()
+	err := handler(srv, &grpcStream{ServerStream: ss, ctx: ctx})
+	observeGRPC(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// grpcStream replaces a stream's context.
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (gs *grpcStream) Context() context.Context { return gs.ctx }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// incompressibleTypes are content type prefixes that are already compressed,
+// so gzipping them again only costs CPU.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/gzip",
+	"application/zip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/octet-stream",
+}
+
+// withGzip compresses responses for clients that accept gzip. Bodies smaller
+// than minBytes, already-encoded responses, and incompressible content types
+// are sent as-is. Upgrade requests are passed through untouched so the
+// connection can be hijacked.
+func withGzip(next http.Handler, minBytes int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minBytes: minBytes, status: http.StatusOK}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of the body so it can decide whether
+// compression is worthwhile before committing headers. WriteHeader is
+// deferred until that decision is made.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minBytes int
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		// Interim responses go straight out; the final status is still to
+		// come.
+		gw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	gw.wroteHeader = true
+	gw.status = status
+	// Bodyless responses are never compressed.
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		gw.decide(false)
+	}
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(b)
+		}
+		return gw.ResponseWriter.Write(b)
+	}
+
+	gw.buf.Write(b)
+	if gw.buf.Len() >= gw.minBytes {
+		if err := gw.decide(gw.compressible()); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// compressible reports whether the response headers allow compression.
+func (gw *gzipResponseWriter) compressible() bool {
+	h := gw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(gw.buf.Bytes())
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// decide commits the headers, with or without gzip, and flushes any buffered
+// body bytes.
+func (gw *gzipResponseWriter) decide(compress bool) error {
+	if gw.decided {
+		return nil
+	}
+	gw.decided = true
+
+	if compress {
+		h := gw.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		gw.gz = gzipWriterPool.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	if gw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf.Bytes())
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf.Bytes())
+	}
+	gw.buf.Reset()
+	return err
+}
+
+// Flush commits the compression decision immediately so streaming handlers
+// aren't held back by the buffer.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	gw.decide(gw.compressible())
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Close finishes the response. Bodies that never reached minBytes are sent
+// uncompressed.
+func (gw *gzipResponseWriter) Close() {
+	if !gw.decided {
+		if !gw.wroteHeader && gw.buf.Len() == 0 {
+			// The handler wrote nothing; let net/http send its default.
+			return
+		}
+		gw.decide(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+		gw.gz.Reset(nil)
+		gzipWriterPool.Put(gw.gz)
+		gw.gz = nil
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGzip(t *testing.T) {
+	big := strings.Repeat("compress me ", 200)
+	tests := []struct {
+		name         string
+		contentType  string
+		status       int
+		body         string
+		wantEncoding string
+	}{
+		{"large text", "application/json", 0, big, "gzip"},
+		{"status before write", "application/json", http.StatusCreated, big, "gzip"},
+		{"tiny", "application/json", 0, "{}", ""},
+		{"incompressible", "image/png", 0, big, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				io.WriteString(w, tt.body)
+			}), 1024)
+			r := newRequest(t, "GET", "/", "")
+			r.Header.Set("Accept-Encoding", "gzip")
+			rec := serve(h, r)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if !slices.Contains(rec.Header().Values("Vary"), "Accept-Encoding") {
+				t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Values("Vary"))
+			}
+			if want := max(tt.status, http.StatusOK); rec.Code != want {
+				t.Errorf("status = %d, want %d", rec.Code, want)
+			}
+			body := rec.Body.String()
+			if tt.wantEncoding == "gzip" {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(zr)
+				body = string(b)
+			}
+			if body != tt.body {
+				t.Errorf("decoded body is %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+// BenchmarkItemListGzip compares listing items with and without
+// compression; run with -benchmem to see the allocation cost.
+func BenchmarkItemListGzip(b *testing.B) {
+	for _, encoding := range []string{"identity", "gzip"} {
+		b.Run(encoding, func(b *testing.B) {
+			_, h := newTestServer(b)
+			for i := range 100 {
+				serve(h, newRequest(b, "POST", "/api/v1/items", fmt.Sprintf(`{"name": "item %d", "data": {"color": "red", "size": %d}}`, i, i)))
+			}
+			b.ResetTimer()
+			for range b.N {
+				r := newRequest(b, "GET", "/api/v1/items?limit=100", "")
+				r.Header.Set("Accept-Encoding", encoding)
+				rec := serve(h, r)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("GET /api/v1/items = %d", rec.Code)
+				}
+				b.SetBytes(int64(rec.Body.Len()))
+			}
+		})
+	}
+}
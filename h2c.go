@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cServer adds plaintext HTTP/2 to an http.Server. h2c connections are
+// hijacked from the HTTP/1.1 server, which stops tracking them, so the
+// server's Shutdown neither waits for them nor closes them; Shutdown here
+// does.
+type h2cServer struct {
+	// conns counts requests in the HTTP/1.1 server's handler. An h2c
+	// connection is served from inside the request that started it, so it
+	// stays counted until it closes.
+	conns sync.WaitGroup
+}
+
+// enableH2C wraps server.Handler to accept h2c by prior knowledge and by
+// Upgrade. Call it after the handler and timeouts are set.
+func enableH2C(server *http.Server, maxUpgradeBody int64) (*h2cServer, error) {
+	h2s := &http2.Server{}
+	// Registers a shutdown hook that sends GOAWAY on every HTTP/2
+	// connection, letting in-flight streams finish.
+	if err := http2.ConfigureServer(server, h2s); err != nil {
+		return nil, err
+	}
+	hs := &h2cServer{}
+	next := h2c.NewHandler(server.Handler, h2s)
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hs.conns.Add(1)
+		defer hs.conns.Done()
+		if isH2CUpgrade(r) {
+			// h2c reads the whole upgrade request body into memory before
+			// the handler, and so limitBody, sees it.
+			r.Body = http.MaxBytesReader(w, r.Body, maxUpgradeBody)
+		}
+		next.ServeHTTP(w, r)
+	})
+	return hs, nil
+}
+
+func isH2CUpgrade(r *http.Request) bool {
+	for _, v := range r.Header.Values("Upgrade") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "h2c") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Shutdown waits for h2c connections to drain. Call it after the
+// http.Server's Shutdown, which has sent them GOAWAY and stopped new ones.
+func (hs *h2cServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		hs.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
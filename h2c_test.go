@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// startH2CServer serves h the way run does with ENABLE_H2C, returning its
+// http.Server, the h2c tracker, and its base URL.
+func startH2CServer(t *testing.T, srv *Server, h http.Handler) (*http.Server, *h2cServer, string) {
+	t.Helper()
+	cfg := srv.cfg
+	server := &http.Server{
+		Handler:           h,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	server.RegisterOnShutdown(srv.events.Close)
+	hs, err := enableH2C(server, cfg.MaxBodyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return server, hs, "http://" + ln.Addr().String()
+}
+
+// h2cClient speaks HTTP/2 by prior knowledge over plain TCP.
+func h2cClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func TestH2CStreamsEventsThroughShutdown(t *testing.T) {
+	srv, h := newTestServer(t, func(cfg *Config) {
+		cfg.EnableH2C = true
+		cfg.EventsInterval = 20 * time.Millisecond
+	})
+	server, hs, url := startH2CServer(t, srv, h)
+	client := h2cClient()
+
+	resp, err := client.Get(url + "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var echo EchoResponse
+	json.NewDecoder(resp.Body).Decode(&echo)
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || echo.Proto != "HTTP/2.0" {
+		t.Errorf("GET /echo over h2c = %s, echoed proto %q, want HTTP/2.0", resp.Proto, echo.Proto)
+	}
+	// HTTP/1.1 clients are unaffected.
+	resp, err = http.Get(url + "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /echo over HTTP/1.1 = %s %d", resp.Proto, resp.StatusCode)
+	}
+
+	resp, err = client.Get(url + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /events = %s %s, want an HTTP/2 event stream", resp.Proto, resp.Header.Get("Content-Type"))
+	}
+	// Several heartbeats arrive one interval apart, each flushed on its
+	// own HTTP/2 DATA frame.
+	lines := bufio.NewScanner(resp.Body)
+	events := 0
+	for events < 3 && lines.Scan() {
+		if lines.Text() == "event: heartbeat" {
+			events++
+		}
+	}
+	if events < 3 {
+		t.Fatalf("read %d heartbeats before %v, want 3", events, lines.Err())
+	}
+
+	// Shutdown ends the stream cleanly rather than resetting it, and
+	// drains the hijacked h2c connection well inside its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		if err := server.Shutdown(ctx); err != nil {
+			stopped <- err
+			return
+		}
+		stopped <- hs.Shutdown(ctx)
+	}()
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("reading /events during shutdown: %v, want a clean end of stream", err)
+	}
+	if len(rest) > 0 && !strings.HasSuffix(string(rest), "\n\n") {
+		t.Errorf("stream ended mid-event: %q", rest)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown = %v, want the h2c connection drained", err)
+	}
+	if _, err := client.Get(url + "/version"); err == nil {
+		t.Error("GET /version after Shutdown succeeded, want the listener closed")
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GET /version after Shutdown hung: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+)
+
+// InstanceInfo identifies the pod serving a request. Pod, Namespace, and Node
+// come from downward-API env vars and are empty outside Kubernetes.
+type InstanceInfo struct {
+	Hostname  string
+	Pod       string
+	Namespace string
+	Node      string
+}
+
+// readInstanceInfo collects the hostname and downward-API metadata.
+func readInstanceInfo(getenv func(string) string) InstanceInfo {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return InstanceInfo{
+		Hostname:  hostname,
+		Pod:       getenv("POD_NAME"),
+		Namespace: getenv("POD_NAMESPACE"),
+		Node:      getenv("NODE_NAME"),
+	}
+}
+
+// liveness reports that the process is running. It is 200 so Kubernetes
+// only restarts the pod when it stops responding entirely, unless an
+// unhealthy fault has been injected.
+func (s *Server) liveness() (int, HealthResponse) {
+	if s.faults.Unhealthy() {
+		return http.StatusInternalServerError, s.healthResponse(HealthResponse{
+			Status: "unhealthy",
+			Checks: map[string]string{"process": "unhealthy fault injected"},
+		})
+	}
+	return http.StatusOK, s.healthResponse(HealthResponse{
+		Status: "healthy",
+		Checks: map[string]string{"process": "ok"},
+	})
+}
+
+// readiness is 200 while the server is accepting traffic and all registered
+// dependency checks pass, and 503 during startup and warm-up, shutdown
+// drain, maintenance with MAINTENANCE_FAIL_READINESS, or when any check
+// fails or exceeds READINESS_CHECK_TIMEOUT. Circuit breaker states are
+// reported alongside but don't affect the status. In read-only mode the
+// status is "degraded" but still 200: reads are worth routing.
+func (s *Server) readiness(ctx context.Context) (int, HealthResponse) {
+	if !s.ready.Load() {
+		return http.StatusServiceUnavailable, s.healthResponse(HealthResponse{
+			Status: "not ready",
+			Checks: map[string]string{"server": "not ready"},
+		})
+	}
+	if s.maintenance.FailsReadiness() {
+		return http.StatusServiceUnavailable, s.healthResponse(HealthResponse{
+			Status: "maintenance",
+			Checks: map[string]string{"server": "ok", "maintenance": "enabled"},
+		})
+	}
+	if !s.warmup.Done() {
+		return http.StatusServiceUnavailable, s.healthResponse(HealthResponse{
+			Status: "warming_up",
+			Checks: map[string]string{"server": "ok", "warmup": "running"},
+		})
+	}
+
+	statuses, healthy := s.checks.Run(ctx, s.cfg.ReadinessCheckTimeout)
+	statuses["server"] = "ok"
+	var circuits map[string]string
+	if len(s.breakers) > 0 {
+		circuits = make(map[string]string, len(s.breakers))
+		for _, b := range s.breakers {
+			circuits[b.name] = b.State().String()
+		}
+	}
+	if !healthy {
+		return http.StatusServiceUnavailable, s.healthResponse(HealthResponse{
+			Status:   "not ready",
+			Checks:   statuses,
+			Circuits: circuits,
+		})
+	}
+	status := "ready"
+	if state := s.readOnly.State(); state.Enabled {
+		status = "degraded"
+		statuses["read_only"] = "enabled by " + state.SetBy
+	}
+	return http.StatusOK, s.healthResponse(HealthResponse{
+		Status:   status,
+		Checks:   statuses,
+		Circuits: circuits,
+	})
+}
+
+// livenessHandler serves /healthz and /health.
+func (s *Server) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	status, response := s.liveness()
+	writeResponse(w, r, status, response)
+}
+
+// readinessHandler serves /readyz.
+func (s *Server) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	status, response := s.readiness(r.Context())
+	writeResponse(w, r, status, response)
+}
+
+// healthResponse fills in the build and instance fields shared by every
+// health response.
+func (s *Server) healthResponse(response HealthResponse) HealthResponse {
+	now := s.clock.Now()
+	response.Timestamp = now
+	response.Version = s.build.Version
+	response.GitCommit = s.build.GitCommit
+	response.BuildDate = s.build.BuildDate
+	response.Uptime = now.Sub(s.started).Round(time.Second).String()
+	response.Hostname = s.instance.Hostname
+	response.Pod = s.instance.Pod
+	response.Namespace = s.instance.Namespace
+	response.Node = s.instance.Node
+	return response
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHealthReportsInstance(t *testing.T) {
+	t.Setenv("POD_NAME", "app-7d9f-x2k")
+	t.Setenv("POD_NAMESPACE", "demo")
+	t.Setenv("NODE_NAME", "node-1")
+	_, h := newTestServer(t)
+
+	rec := serve(h, newRequest(t, "GET", "/health", ""))
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /health = %d %s", rec.Code, rec.Body)
+	}
+	hostname, _ := os.Hostname()
+	want := map[string]string{"pod": "app-7d9f-x2k", "namespace": "demo", "node": "node-1", "hostname": hostname}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %q", key, body[key], value)
+		}
+	}
+	uptime, ok := body["uptime"].(string)
+	if _, err := time.ParseDuration(uptime); !ok || err != nil {
+		t.Errorf("uptime = %v, want a duration", body["uptime"])
+	}
+}
+
+func TestHealthOmitsMissingInstanceFields(t *testing.T) {
+	for _, name := range []string{"POD_NAME", "POD_NAMESPACE", "NODE_NAME"} {
+		t.Setenv(name, "")
+	}
+	_, h := newTestServer(t)
+
+	var body map[string]any
+	json.Unmarshal(serve(h, newRequest(t, "GET", "/health", "")).Body.Bytes(), &body)
+	for _, key := range []string{"pod", "namespace", "node"} {
+		if _, ok := body[key]; ok {
+			t.Errorf("%s present outside Kubernetes: %v", key, body)
+		}
+	}
+	if body["hostname"] == "" || body["hostname"] == nil {
+		t.Errorf("hostname missing: %v", body)
+	}
+}
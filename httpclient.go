@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// httpClientConfig tunes the transport every outbound call shares: /fetch
+// and http_check jobs, webhook deliveries, the BACKEND_URL proxy, and JWKS
+// refreshes.
+type httpClientConfig struct {
+	// Timeout bounds a call, retries included, where the call site sets
+	// none of its own.
+	Timeout time.Duration
+	// MaxIdleConnsPerHost and MaxConnsPerHost size the connection pool;
+	// zero MaxConnsPerHost is unlimited.
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	// MaxRetries retries an idempotent request after a connection reset or
+	// a 429 or 5xx, waiting RetryBackoff doubled on each attempt, with
+	// jitter, or the answer's Retry-After. No retry starts once RetryBudget
+	// has passed since the first attempt.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	RetryBudget  time.Duration
+}
+
+// maxRetryBackoff caps the doubled backoff between two attempts.
+const maxRetryBackoff = 10 * time.Second
+
+// newHTTPClient returns a client for outbound calls through
+// newOutboundTransport, bounded by timeout, or cfg.Timeout when that is
+// zero.
+func newHTTPClient(cfg httpClientConfig, timeout time.Duration) *http.Client {
+	if timeout == 0 {
+		timeout = cfg.Timeout
+	}
+	return &http.Client{Transport: newOutboundTransport(cfg, nil), Timeout: timeout}
+}
+
+// newOutboundTransport returns a pooled transport, adjusted by tune when it
+// is not nil, that retries as cfg says, traces each attempt, and sends the
+// inbound request's X-Request-ID.
+func newOutboundTransport(cfg httpClientConfig, tune func(*http.Transport)) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	if tune != nil {
+		tune(transport)
+	}
+	return retryTransport{
+		base:       tracedTransport(transport),
+		maxRetries: cfg.MaxRetries,
+		backoff:    cfg.RetryBackoff,
+		budget:     cfg.RetryBudget,
+	}
+}
+
+// retryTransport retries failed attempts and records each one in the
+// http_client_* metrics. Hosts are a label: outbound targets are
+// configured or, for webhooks, registered by authenticated callers.
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+	budget     time.Duration
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if id := requestIDFrom(ctx); id != "" && req.Header.Get(requestIDHeader) == "" {
+		req = req.Clone(ctx)
+		req.Header.Set(requestIDHeader, id)
+	}
+	host := req.URL.Host
+	retries := 0
+	if retryableRequest(req) {
+		retries = t.maxRetries
+	}
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		attemptStart := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(resp.StatusCode)
+		}
+		httpClientRequests.WithLabelValues(host, status).Inc()
+		observeDuration(ctx, httpClientDuration.WithLabelValues(host), time.Since(attemptStart))
+
+		failed := retryableFailure(resp, err)
+		if failed && attempt < retries && ctx.Err() == nil {
+			if wait, ok := t.wait(attempt, resp); ok && time.Since(start)+wait <= t.budget {
+				if resp != nil {
+					// Drain a little so the connection can be reused.
+					io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+					resp.Body.Close()
+				}
+				httpClientRetries.WithLabelValues(host).Inc()
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				case <-timer.C:
+					continue
+				}
+			}
+		}
+		// A caller that gave up already knows; anything else that didn't
+		// get an answer, or got one worth retrying, is worth a log line.
+		if (err == nil && !failed) || ctx.Err() != nil {
+			return resp, err
+		}
+		attrs := []any{slog.String("method", req.Method), slog.String("host", host),
+			slog.String("url", req.URL.Redacted()), slog.Int("attempts", attempt+1),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000)}
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		} else {
+			attrs = append(attrs, slog.Int("status", resp.StatusCode))
+		}
+		loggerFrom(ctx).Warn("outbound request failed", attrs...)
+		return resp, err
+	}
+}
+
+// wait returns how long to wait before the attempt after attempt: the
+// answer's Retry-After, or the doubled backoff with jitter. ok is false for
+// a Retry-After longer than maxRetryBackoff.
+func (t retryTransport) wait(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if v := resp.Header.Get("Retry-After"); v != "" {
+			if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+				d := time.Duration(secs) * time.Second
+				return d, d <= maxRetryBackoff
+			}
+		}
+	}
+	d := min(t.backoff<<attempt, maxRetryBackoff)
+	if d <= 0 {
+		return 0, true
+	}
+	// Half fixed, half random, so callers failing together don't retry
+	// together.
+	return d/2 + rand.N(d/2+1), true
+}
+
+// retryableRequest reports whether req may be sent again: its method is
+// idempotent, or it carries an Idempotency-Key, and its body can be
+// replayed.
+func retryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		if req.Header.Get(idempotencyKeyHeader) == "" {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryableFailure reports whether an attempt failed in a way another may
+// not: a reset or refused connection, one closed before the answer, a 429,
+// or a 5xx other than 501 and 505.
+func retryableFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return resp.StatusCode >= 500
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// hangUp makes flakyUpstream close the connection without answering.
+const hangUp = -1
+
+// flakyUpstream answers each attempt with the next of statuses, then 200,
+// and records what every attempt sent.
+type flakyUpstream struct {
+	statuses   []int
+	retryAfter string
+
+	mu       sync.Mutex
+	bodies   []string
+	requests []http.Header
+}
+
+func (u *flakyUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	u.mu.Lock()
+	attempt := len(u.requests)
+	u.requests = append(u.requests, r.Header.Clone())
+	u.bodies = append(u.bodies, string(body))
+	u.mu.Unlock()
+	if attempt >= len(u.statuses) {
+		w.Write([]byte("ok"))
+		return
+	}
+	if u.statuses[attempt] == hangUp {
+		conn, _, _ := http.NewResponseController(w).Hijack()
+		conn.Close()
+		return
+	}
+	if u.retryAfter != "" {
+		w.Header().Set("Retry-After", u.retryAfter)
+	}
+	w.WriteHeader(u.statuses[attempt])
+}
+
+func (u *flakyUpstream) attempts() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.requests)
+}
+
+func TestOutboundRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		method, body string
+		idempotent   bool
+		statuses     []int
+		retryAfter   string
+		budget       time.Duration
+		want         int
+		wantAttempts int
+	}{
+		{name: "retries 5xx until success", method: "GET", statuses: []int{503, 500}, want: 200, wantAttempts: 3},
+		{name: "retries 429", method: "GET", statuses: []int{429}, want: 200, wantAttempts: 2},
+		{name: "retries a dropped connection", method: "GET", statuses: []int{hangUp}, want: 200, wantAttempts: 2},
+		{name: "gives up after max retries", method: "GET", statuses: []int{502, 502, 502, 502}, want: 502, wantAttempts: 4},
+		{name: "doesn't retry 501", method: "GET", statuses: []int{501}, want: 501, wantAttempts: 1},
+		{name: "doesn't retry 4xx", method: "GET", statuses: []int{404}, want: 404, wantAttempts: 1},
+		{name: "retries PUT with its body", method: "PUT", body: `{"n": 1}`, statuses: []int{503}, want: 200, wantAttempts: 2},
+		{name: "doesn't retry POST", method: "POST", body: `{"n": 1}`, statuses: []int{503}, want: 503, wantAttempts: 1},
+		{name: "retries POST with an idempotency key", method: "POST", body: `{"n": 1}`, idempotent: true, statuses: []int{503}, want: 200, wantAttempts: 2},
+		{name: "honors a short Retry-After", method: "GET", statuses: []int{503}, retryAfter: "0", want: 200, wantAttempts: 2},
+		{name: "won't wait out a long Retry-After", method: "GET", statuses: []int{503}, retryAfter: "120", want: 503, wantAttempts: 1},
+		{name: "stops when the budget is spent", method: "GET", statuses: []int{503}, budget: time.Millisecond, want: 503, wantAttempts: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := &flakyUpstream{statuses: tc.statuses, retryAfter: tc.retryAfter}
+			ts := httptest.NewServer(upstream)
+			defer ts.Close()
+			budget := tc.budget
+			if budget == 0 {
+				budget = time.Minute
+			}
+			c := newHTTPClient(httpClientConfig{MaxRetries: 3, RetryBackoff: 10 * time.Millisecond, RetryBudget: budget}, time.Minute)
+			u, _ := url.Parse(ts.URL)
+			retries := func() float64 { return testutil.ToFloat64(httpClientRetries.WithLabelValues(u.Host)) }
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			ctx := contextWithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+			req, err := http.NewRequestWithContext(ctx, tc.method, ts.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.idempotent {
+				req.Header.Set(idempotencyKeyHeader, "key-1")
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.want || upstream.attempts() != tc.wantAttempts {
+				t.Errorf("%s = %d after %d attempts, want %d after %d", tc.method, resp.StatusCode, upstream.attempts(), tc.want, tc.wantAttempts)
+			}
+			if got := retries(); got != float64(tc.wantAttempts-1) {
+				t.Errorf("http_client_retries_total = %v, want %d", got, tc.wantAttempts-1)
+			}
+			for i, b := range upstream.bodies {
+				if b != tc.body {
+					t.Errorf("attempt %d body = %q, want %q replayed", i, b, tc.body)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboundRequestContext(t *testing.T) {
+	upstream := &flakyUpstream{statuses: []int{503, 503}}
+	ts := httptest.NewServer(upstream)
+	defer ts.Close()
+
+	var logs bytes.Buffer
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "outbound-1")
+	ctx = contextWithLogger(ctx, slog.New(slog.NewJSONHandler(&logs, nil)))
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/thing?token=secret", nil)
+	c := newHTTPClient(httpClientConfig{MaxRetries: 1, RetryBudget: time.Minute}, time.Minute)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for i, h := range upstream.requests {
+		if h.Get(requestIDHeader) != "outbound-1" {
+			t.Errorf("attempt %d X-Request-ID = %q, want the inbound request's", i, h.Get(requestIDHeader))
+		}
+	}
+	lines := logLines(t, &logs, "outbound request failed")
+	if len(lines) != 1 || lines[0]["attempts"] != float64(2) || lines[0]["status"] != float64(503) {
+		t.Fatalf("failure logs = %v, want one line after both attempts", lines)
+	}
+
+	// A caller that cancels gets no log line and no further attempts.
+	logs.Reset()
+	upstream = &flakyUpstream{statuses: []int{503}, retryAfter: "5"}
+	ts2 := httptest.NewServer(upstream)
+	defer ts2.Close()
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", ts2.URL, nil)
+	if _, err := newHTTPClient(httpClientConfig{MaxRetries: 3, RetryBudget: time.Minute}, time.Minute).Do(req); err == nil {
+		t.Error("Do() with the context ending mid-wait succeeded, want its error")
+	}
+	if upstream.attempts() != 1 || logs.Len() != 0 {
+		t.Errorf("%d attempts and logs %q after cancelling, want one attempt and no logs", upstream.attempts(), logs.String())
+	}
+}
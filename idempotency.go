@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader names the client-chosen key that makes a POST safe to
+// retry.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the header value accepted as a key.
+const maxIdempotencyKeyLen = 255
+
+// errIdempotencyMismatch is returned by idempotencyStore.Begin for a key
+// already used with a different request body.
+var errIdempotencyMismatch = errors.New("idempotency key reused with a different body")
+
+// idempotencyStore remembers responses by Idempotency-Key: the in-process
+// idempotencyCache, or Redis to share keys across replicas.
+type idempotencyStore interface {
+	// Begin claims key for a request whose body hashes to bodyHash. The
+	// first caller gets a finish func, which it must call with the
+	// response to keep, or nil to give the key up. Later callers wait for
+	// the first and get its response, or neither if it gave the key up.
+	Begin(ctx context.Context, key string, bodyHash [sha256.Size]byte) (finish func(*idempotentResponse), resp *idempotentResponse, err error)
+}
+
+// idempotentResponse is a completed response kept for replay.
+type idempotentResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyEntry tracks one key. done is closed once the first request
+// finishes; until then duplicates wait on it.
+type idempotencyEntry struct {
+	key      string
+	bodyHash [sha256.Size]byte
+	expires  time.Time
+	done     chan struct{}
+	resp     *idempotentResponse
+	elem     *list.Element
+}
+
+// idempotencyCache remembers responses by Idempotency-Key for ttl, holding at
+// most max keys. Keys expire in insertion order, so the oldest entry is both
+// the next to expire and the one evicted when the cache is full.
+type idempotencyCache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	order   *list.List
+}
+
+func newIdempotencyCache(ttl time.Duration, max int, now func() time.Time) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		max:     max,
+		now:     now,
+		entries: make(map[string]*idempotencyEntry),
+		order:   list.New(),
+	}
+}
+
+// begin looks key up. A nil entry with owner set means the caller is the
+// first and must call finish; otherwise the returned entry belongs to an
+// earlier request, which may still be running.
+func (c *idempotencyCache) begin(key string, bodyHash [sha256.Size]byte) (entry *idempotencyEntry, owner bool) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pruneLocked(now)
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	for len(c.entries) >= c.max {
+		c.removeLocked(c.order.Front().Value.(*idempotencyEntry))
+	}
+	e := &idempotencyEntry{key: key, bodyHash: bodyHash, expires: now.Add(c.ttl), done: make(chan struct{})}
+	e.elem = c.order.PushBack(e)
+	c.entries[key] = e
+	return e, true
+}
+
+// finish records the outcome for e and releases waiting duplicates. A nil
+// resp forgets the key so the request may be retried.
+func (c *idempotencyCache) finish(e *idempotencyEntry, resp *idempotentResponse) {
+	c.mu.Lock()
+	e.resp = resp
+	if resp == nil && c.entries[e.key] == e {
+		c.removeLocked(e)
+	}
+	c.mu.Unlock()
+	close(e.done)
+}
+
+// Begin implements idempotencyStore.
+func (c *idempotencyCache) Begin(ctx context.Context, key string, bodyHash [sha256.Size]byte) (func(*idempotentResponse), *idempotentResponse, error) {
+	entry, owner := c.begin(key, bodyHash)
+	if owner {
+		return func(resp *idempotentResponse) { c.finish(entry, resp) }, nil, nil
+	}
+	if entry.bodyHash != bodyHash {
+		return nil, nil, errIdempotencyMismatch
+	}
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	return nil, entry.resp, nil
+}
+
+// pruneLocked drops expired entries from the front of the order.
+func (c *idempotencyCache) pruneLocked(now time.Time) {
+	for front := c.order.Front(); front != nil; front = c.order.Front() {
+		e := front.Value.(*idempotencyEntry)
+		if now.Before(e.expires) {
+			return
+		}
+		c.removeLocked(e)
+	}
+}
+
+// prune drops expired entries without waiting for the next request.
+func (c *idempotencyCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(c.now())
+}
+
+func (c *idempotencyCache) removeLocked(e *idempotencyEntry) {
+	c.order.Remove(e.elem)
+	delete(c.entries, e.key)
+}
+
+// idempotencyRecorder copies the response into a buffer as it is written.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 && status >= 200 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// withIdempotency makes next safe to retry with an Idempotency-Key header.
+// The first request for a key runs normally and its response is cached;
+// repeats with the same body get the cached response back, flagged with an
+// Idempotent-Replayed header, and repeats with a different body get a 409.
+// A duplicate arriving while the first is still running waits for it.
+// Server errors are not cached, so those requests can be retried. Keys are
+// scoped to the request path and the caller's credentials. If the store
+// can't be reached, keyed requests get a 503 rather than risk running twice.
+func withIdempotency(next http.HandlerFunc, store idempotencyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid Idempotency-Key", "the key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeDecodeError(w, r, classifyDecodeError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := r.URL.Path + "\x00" + callerIdentity(r) + "\x00" + key
+		finish, prior, err := store.Begin(r.Context(), scope, sha256.Sum256(body))
+		switch {
+		case errors.Is(err, errIdempotencyMismatch):
+			writeError(w, r, http.StatusConflict, codeIdempotencyConflict, "Idempotency key reused",
+				"this key was already used with a different request body")
+			return
+		case r.Context().Err() != nil:
+			return
+		case err != nil:
+			redisFailed("idempotency")
+			loggerFrom(r.Context()).Warn("idempotency store unavailable", slog.Any("error", err))
+			writeRetryableError(w, r, http.StatusServiceUnavailable, retryAfterDefault, codeUnavailable, "Idempotency store unavailable", "retry the request later")
+			return
+		case finish == nil && prior == nil:
+			// The original failed and was forgotten; run this one afresh.
+			withIdempotency(next, store)(w, r)
+			return
+		case finish == nil:
+			replay(w, prior)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if !completed || rec.status >= 500 {
+				finish(nil)
+				return
+			}
+			finish(&idempotentResponse{status: rec.status, header: w.Header().Clone(), body: rec.body.Bytes()})
+		}()
+		next(rec, r)
+		completed = true
+	}
+}
+
+// callerIdentity names the authenticated caller, if any, and the tenant,
+// so two clients can't replay each other's responses by guessing keys.
+func callerIdentity(r *http.Request) string {
+	if info, ok := r.Context().Value(logInfoKey{}).(*requestLogInfo); ok {
+		return info.APIKey + "\x00" + info.Subject + "\x00" + info.Tenant
+	}
+	return ""
+}
+
+// replay writes a cached response.
+func replay(w http.ResponseWriter, resp *idempotentResponse) {
+	for name, values := range resp.header {
+		if name == "X-Request-Id" {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
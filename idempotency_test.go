@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCreate answers 201 with a body numbering each call it serves.
+func countingCreate(calls *atomic.Int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call": %d}`, n)
+	}
+}
+
+func postWithKey(t *testing.T, h http.Handler, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := newRequest(t, "POST", "/api/v1/items", body)
+	r.Header.Set(idempotencyKeyHeader, key)
+	return serve(h, r)
+}
+
+func TestIdempotencyReplayAndConflict(t *testing.T) {
+	_, h := newTestServer(t)
+	first := postWithKey(t, h, "retry-1", `{"name": "once"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first POST = %d %s", first.Code, first.Body)
+	}
+	again := postWithKey(t, h, "retry-1", `{"name": "once"}`)
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retried POST = %d %s replayed=%q, want the first response replayed", again.Code, again.Body, again.Header().Get("Idempotent-Replayed"))
+	}
+	if again.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("replayed Location = %q, want %q", again.Header().Get("Location"), first.Header().Get("Location"))
+	}
+	if conflict := postWithKey(t, h, "retry-1", `{"name": "different"}`); conflict.Code != http.StatusConflict {
+		t.Errorf("same key with another body = %d, want 409", conflict.Code)
+	}
+	if other := postWithKey(t, h, "retry-2", `{"name": "once"}`); other.Code != http.StatusCreated || other.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("new key = %d replayed=%q, want a fresh create", other.Code, other.Header().Get("Idempotent-Replayed"))
+	}
+
+	var list ItemList
+	json.Unmarshal(serve(h, newRequest(t, "GET", "/api/v1/items", "")).Body.Bytes(), &list)
+	if list.Total != 2 {
+		t.Errorf("%d items created, want 2", list.Total)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	var calls atomic.Int64
+	h := withIdempotency(countingCreate(&calls), newIdempotencyCache(time.Hour, 10, clock.now))
+
+	postWithKey(t, h, "k", `{}`)
+	clock.advance(59 * time.Minute)
+	if rec := postWithKey(t, h, "k", `{}`); rec.Body.String() != `{"call": 1}` {
+		t.Errorf("retry within the TTL = %s, want the replay", rec.Body)
+	}
+	clock.advance(2 * time.Minute)
+	if rec := postWithKey(t, h, "k", `{"changed": true}`); rec.Code != http.StatusCreated || rec.Body.String() != `{"call": 2}` {
+		t.Errorf("reuse after the TTL = %d %s, want a fresh run", rec.Code, rec.Body)
+	}
+}
+
+func TestIdempotencyCacheIsBounded(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	cache := newIdempotencyCache(time.Hour, 3, clock.now)
+	var calls atomic.Int64
+	h := withIdempotency(countingCreate(&calls), cache)
+	for i := range 5 {
+		postWithKey(t, h, fmt.Sprint(i), `{}`)
+	}
+	if n := len(cache.entries); n != 3 {
+		t.Errorf("cache holds %d keys, want at most 3", n)
+	}
+	// The oldest keys were evicted, so they run again.
+	if rec := postWithKey(t, h, "0", `{}`); rec.Body.String() != `{"call": 6}` {
+		t.Errorf("evicted key = %s, want a fresh run", rec.Body)
+	}
+	if rec := postWithKey(t, h, "4", `{}`); rec.Body.String() != `{"call": 5}` {
+		t.Errorf("recent key = %s, want its replay", rec.Body)
+	}
+}
+
+func TestIdempotencyConcurrentDuplicates(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		countingCreate(&calls)(w, r)
+	}
+	h := withIdempotency(slow, newIdempotencyCache(time.Hour, 10, time.Now))
+
+	const duplicates = 20
+	bodies := make([]string, duplicates)
+	var wg sync.WaitGroup
+	for i := range duplicates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i] = postWithKey(t, h, "race", `{"name": "x"}`).Body.String()
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times for one key, want 1", n)
+	}
+	for i, body := range bodies {
+		if body != `{"call": 1}` {
+			t.Errorf("duplicate %d got %q, want the single response", i, body)
+		}
+	}
+}
+
+func TestIdempotencyDoesNotCacheServerErrors(t *testing.T) {
+	var calls atomic.Int64
+	h := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}, newIdempotencyCache(time.Hour, 10, time.Now))
+	postWithKey(t, h, "k", `{}`)
+	if rec := postWithKey(t, h, "k", `{}`); rec.Code != http.StatusCreated || calls.Load() != 2 {
+		t.Errorf("retry after a 503 = %d after %d calls, want a fresh 201", rec.Code, calls.Load())
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const contentTypeCSV = "text/csv"
+
+// csvColumns are the columns of an export, in order. data holds the item's
+// data as a JSON object.
+var csvColumns = []string{"id", "name", "data", "created_at", "updated_at", "version"}
+
+// importMaxErrors caps the row errors an import summary lists; the rest are
+// only counted.
+const importMaxErrors = 100
+
+// Import conflict policies, for items whose name is already taken.
+const (
+	importSkip      = "skip"
+	importOverwrite = "overwrite"
+	importFail      = "fail"
+)
+
+// ImportSummary is the response of POST /api/v1/items/import. Failed counts
+// every row in error, of which Errors lists the first importMaxErrors.
+// RolledBack is set when an atomic import failed and nothing was kept.
+type ImportSummary struct {
+	Imported   int           `json:"imported"`
+	Skipped    int           `json:"skipped"`
+	Failed     int           `json:"failed"`
+	Errors     []ImportError `json:"errors"`
+	Atomic     bool          `json:"atomic"`
+	RolledBack bool          `json:"rolled_back,omitempty"`
+}
+
+// ImportError is why the row at Line was not imported.
+type ImportError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// fail records a row that was not imported.
+func (s *ImportSummary) fail(line int, reason string) {
+	s.Failed++
+	if len(s.Errors) < importMaxErrors {
+		s.Errors = append(s.Errors, ImportError{Line: line, Reason: reason})
+	}
+}
+
+// exportFormat picks the export encoding: ?format=json|csv, else CSV when
+// Accept names it, else JSON.
+func exportFormat(r *http.Request) (string, error) {
+	switch v := r.URL.Query().Get("format"); v {
+	case "json", "csv":
+		return v, nil
+	case "":
+	default:
+		return "", fmt.Errorf("format must be json or csv")
+	}
+	if acceptsMediaType(r.Header.Get("Accept"), contentTypeCSV) {
+		return "csv", nil
+	}
+	return "json", nil
+}
+
+// exportItemsHandler handles GET /api/items/export: every item, or those the
+// list filters select, streamed as a JSON array or CSV download.
+func (s *Server) exportItemsHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := exportFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", err.Error())
+		return
+	}
+	q, err := parseItemQuery(r, math.MaxInt32)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", err.Error())
+		return
+	}
+	if !r.URL.Query().Has("limit") {
+		q.Limit = 0
+	}
+	name := "items"
+	if tenant := tenantFrom(r.Context()); tenant != "" {
+		name += "-" + tenant
+	}
+	name += "-" + s.clock.Now().UTC().Format("20060102T150405Z")
+	f := itemFormat{contentType: "application/json", filename: name + ".json", encoder: newJSONArrayEncoder}
+	if format == "csv" {
+		f = itemFormat{contentType: contentTypeCSV + "; charset=utf-8", filename: name + ".csv", encoder: newCSVItemEncoder}
+	}
+	s.streamItems(w, r, q, f)
+}
+
+// jsonArrayEncoder writes items as one JSON array, an item per line.
+type jsonArrayEncoder struct {
+	w       io.Writer
+	started bool
+}
+
+func newJSONArrayEncoder(w io.Writer) itemEncoder {
+	return &jsonArrayEncoder{w: w}
+}
+
+func (e *jsonArrayEncoder) Encode(item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if !e.started {
+		sep, e.started = "[\n", true
+	}
+	_, err = io.WriteString(e.w, sep+string(data))
+	return err
+}
+
+func (e *jsonArrayEncoder) Close() error {
+	end := "\n]\n"
+	if !e.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// csvItemEncoder writes a header row and then a row per item.
+type csvItemEncoder struct {
+	w       *csv.Writer
+	started bool
+}
+
+func newCSVItemEncoder(w io.Writer) itemEncoder {
+	return &csvItemEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvItemEncoder) header() {
+	if !e.started {
+		e.started = true
+		e.w.Write(csvColumns)
+	}
+}
+
+func (e *csvItemEncoder) Encode(item Item) error {
+	e.header()
+	var data string
+	if item.Data != nil {
+		b, err := json.Marshal(item.Data)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+	e.w.Write([]string{
+		item.ID,
+		item.Name,
+		data,
+		item.CreatedAt.Format(time.RFC3339Nano),
+		item.UpdatedAt.Format(time.RFC3339Nano),
+		strconv.FormatInt(item.Version, 10),
+	})
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvItemEncoder) Close() error {
+	e.header()
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// importRow is one item read from an import body, or, with err set, a row
+// that could not be read as one.
+type importRow struct {
+	line int
+	req  itemRequest
+	err  error
+}
+
+// readImportJSON decodes a JSON array of items one element at a time. Keys
+// other than name and data, such as those an export adds, are ignored. A
+// yielded error means the rest of the body can't be read.
+func readImportJSON(body io.Reader) iter.Seq2[importRow, error] {
+	return func(yield func(importRow, error) bool) {
+		lines := &lineCounter{r: body}
+		dec := json.NewDecoder(lines)
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			if err != nil {
+				err = classifyDecodeError(err)
+			} else {
+				err = &decodeError{Status: http.StatusUnprocessableEntity, Code: codeInvalidBody, Message: "Invalid request body", Detail: "body must be a JSON array of items"}
+			}
+			yield(importRow{}, err)
+			return
+		}
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				yield(importRow{}, classifyDecodeError(err))
+				return
+			}
+			row := importRow{line: lines.lineAt(dec.InputOffset() - int64(len(raw)))}
+			var req itemRequest
+			if err := json.Unmarshal(raw, &req); err != nil {
+				row.err = errors.New("must be an object with a string name and an object data")
+			} else {
+				row.req = itemRequest{Name: req.Name, Data: req.Data}
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			yield(importRow{}, classifyDecodeError(err))
+			return
+		}
+		if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+			var maxBytesErr *http.MaxBytesError
+			if !errors.As(err, &maxBytesErr) {
+				err = errors.New("body must contain a single JSON array")
+			}
+			yield(importRow{}, classifyDecodeError(err))
+		}
+	}
+}
+
+// readImportCSV reads CSV with a header row, which must name a name column
+// and may name a data column of JSON objects; other columns, such as those
+// an export adds, are ignored. Rows that don't parse are yielded as row
+// errors, and reading goes on with the next one.
+func readImportCSV(body io.Reader) iter.Seq2[importRow, error] {
+	return func(yield func(importRow, error) bool) {
+		cr := csv.NewReader(body)
+		header, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			err = errors.New("CSV must start with a header row")
+		}
+		if err != nil {
+			yield(importRow{}, csvReadError(err))
+			return
+		}
+		// Spreadsheets often save CSV with a byte order mark.
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		nameCol, dataCol := slices.Index(header, "name"), slices.Index(header, "data")
+		if nameCol < 0 {
+			yield(importRow{}, &decodeError{Status: http.StatusUnprocessableEntity, Code: codeInvalidBody, Message: "Invalid request body", Detail: "CSV header must have a name column"})
+			return
+		}
+		for {
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				if !yield(importRow{line: parseErr.StartLine, err: parseErr.Err}, nil) {
+					return
+				}
+				continue
+			}
+			if err != nil {
+				yield(importRow{}, csvReadError(err))
+				return
+			}
+			line, _ := cr.FieldPos(0)
+			row := importRow{line: line, req: itemRequest{Name: record[nameCol]}}
+			if dataCol >= 0 && record[dataCol] != "" {
+				if err := json.Unmarshal([]byte(record[dataCol]), &row.req.Data); err != nil {
+					row.err = errors.New("data must be a JSON object")
+				}
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// csvReadError classifies an error reading a CSV body like decodeJSON does
+// for JSON ones.
+func csvReadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var encodingErr *bodyEncodingError
+	if errors.As(err, &maxBytesErr) || errors.As(err, &encodingErr) {
+		return classifyDecodeError(err)
+	}
+	return &decodeError{Status: http.StatusUnprocessableEntity, Code: codeInvalidBody, Message: "Invalid request body", Detail: err.Error()}
+}
+
+// lineCounter tells the line of an offset in what was read through it. It
+// only remembers newlines past the last offset asked about, which the
+// decoder's read-ahead bounds.
+type lineCounter struct {
+	r        io.Reader
+	read     int64
+	newlines []int64
+	line     int
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			c.newlines = append(c.newlines, c.read+int64(i))
+		}
+	}
+	c.read += int64(n)
+	return n, err
+}
+
+// lineAt returns the 1-based line of offset, which must not be before one
+// asked about earlier.
+func (c *lineCounter) lineAt(offset int64) int {
+	n := 0
+	for n < len(c.newlines) && c.newlines[n] < offset {
+		n++
+	}
+	c.line += n
+	c.newlines = c.newlines[n:]
+	return c.line + 1
+}
+
+// importItems handles POST /api/items/import with a JSON array or CSV body,
+// as exported, chosen by ?format= or the Content-Type. Each row is
+// validated like a create. ?on_conflict= decides what happens to a row
+// whose name an item already has: skip it (the default), overwrite that
+// item, or fail the row. Rows in error are reported by line and the rest
+// imported, unless ?atomic=true, where any error rolls the whole import
+// back.
+func (s *Server) importItemsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	onConflict := query.Get("on_conflict")
+	switch onConflict {
+	case "":
+		onConflict = importSkip
+	case importSkip, importOverwrite, importFail:
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", "on_conflict must be skip, overwrite, or fail")
+		return
+	}
+	atomic := false
+	if v := query.Get("atomic"); v != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", "atomic must be true or false")
+			return
+		}
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == contentTypeCSV {
+			format = "csv"
+		}
+	}
+	var rows iter.Seq2[importRow, error]
+	switch format {
+	case "json":
+		rows = readImportJSON(r.Body)
+	case "csv":
+		rows = readImportCSV(r.Body)
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", "format must be json or csv")
+		return
+	}
+
+	if !atomic {
+		summary, err := s.importRows(r, s.storeFor(r), rows, onConflict, func(eventType string, item Item) {
+			s.itemChanged(r, eventType, item)
+		})
+		if err != nil {
+			writeImportError(w, r, err, summary)
+			return
+		}
+		writeResponse(w, r, http.StatusOK, summary)
+		return
+	}
+
+	if s.atomic == nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Atomic imports not supported", "atomic=true needs the in-memory or file store")
+		return
+	}
+	if inBatch(r.Context()) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Atomic import in a batch", "use an atomic batch instead")
+		return
+	}
+	// The whole body is read before the transaction starts, so a slow
+	// upload never holds the store.
+	var read []importRow
+	for row, err := range rows {
+		if err != nil {
+			writeImportError(w, r, err, ImportSummary{})
+			return
+		}
+		read = append(read, row)
+	}
+	var summary ImportSummary
+	var pending []WebhookEvent
+	err := s.atomic.Atomically(r.Context(), func(tx Store) error {
+		pending = nil
+		var err error
+		summary, err = s.importRows(r, instrumentedStore{tx}, preparsedRows(read), onConflict, func(eventType string, item Item) {
+			pending = append(pending, WebhookEvent{Type: eventType, Item: item})
+		})
+		if err == nil && summary.Failed > 0 {
+			err = errBatchFailed
+		}
+		return err
+	})
+	summary.Atomic = true
+	switch {
+	case errors.Is(err, errBatchFailed):
+		summary.Imported, summary.Skipped, summary.RolledBack = 0, 0, true
+		writeResponse(w, r, http.StatusConflict, summary)
+	case err != nil:
+		writeStoreError(w, r, err)
+	default:
+		for _, event := range pending {
+			s.publishChange(r.Context(), event.Type, event.Item)
+		}
+		writeResponse(w, r, http.StatusOK, summary)
+	}
+}
+
+// preparsedRows yields rows already read.
+func preparsedRows(rows []importRow) iter.Seq2[importRow, error] {
+	return func(yield func(importRow, error) bool) {
+		for _, row := range rows {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// importRows writes rows to store, matching names against the items it
+// holds and those imported before, and calls changed for each item
+// written. It stops at the first error reading the body or writing the
+// store, returning the summary so far.
+func (s *Server) importRows(r *http.Request, store Store, rows iter.Seq2[importRow, error], onConflict string, changed func(string, Item)) (ImportSummary, error) {
+	ctx := r.Context()
+	summary := ImportSummary{Errors: []ImportError{}}
+	names := make(map[string]string)
+	for item, err := range store.ListStream(ctx, itemQuery{SortBy: "created_at"}) {
+		if err != nil {
+			return summary, err
+		}
+		if _, ok := names[item.Name]; !ok {
+			names[item.Name] = item.ID
+		}
+	}
+	for row, err := range rows {
+		if err != nil {
+			return summary, err
+		}
+		if row.err != nil {
+			summary.fail(row.line, row.err.Error())
+			continue
+		}
+		if problems := row.req.Validate(); len(problems) > 0 {
+			summary.fail(row.line, problems[0].Field+" "+problems[0].Message)
+			continue
+		}
+		id, exists := names[row.req.Name]
+		switch {
+		case !exists:
+			item, err := store.Create(ctx, Item{Name: row.req.Name, Data: row.req.Data})
+			if err != nil {
+				return summary, err
+			}
+			names[item.Name] = item.ID
+			changed(webhookItemCreated, item)
+			summary.Imported++
+		case onConflict == importSkip:
+			summary.Skipped++
+		case onConflict == importOverwrite:
+			item, err := store.Update(ctx, id, Item{Name: row.req.Name, Data: row.req.Data})
+			if err != nil {
+				return summary, err
+			}
+			changed(webhookItemUpdated, item)
+			summary.Imported++
+		default:
+			summary.fail(row.line, fmt.Sprintf("an item named %q already exists", row.req.Name))
+		}
+	}
+	return summary, nil
+}
+
+// writeImportError reports an import that stopped early: a body that could
+// not be read on, as a *decodeError, or a failed store write. Items
+// imported before it, which summary counts, are kept, and the detail says
+// how many.
+func writeImportError(w http.ResponseWriter, r *http.Request, err error, summary ImportSummary) {
+	var decErr *decodeError
+	if !errors.As(err, &decErr) {
+		writeStoreError(w, r, err)
+		return
+	}
+	detail := decErr.Detail
+	if summary.Imported > 0 {
+		detail = fmt.Sprintf("%s; items imported before it: %d", detail, summary.Imported)
+	}
+	writeError(w, r, decErr.Status, decErr.Code, decErr.Message, detail)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// trickyNames need quoting, or look like they might, in CSV.
+var trickyNames = []string{
+	"plain",
+	"comma, inside",
+	`quote " inside`,
+	`"fully quoted"`,
+	"line\nbreak",
+	"crlf\r\nbreak",
+	" leading and trailing ",
+	"ünïcødé ✓",
+	"semi;colon",
+}
+
+func importItems(t *testing.T, h http.Handler, query, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := newRequest(t, "POST", "/api/v1/items/import"+query, body)
+	r.Header.Set("Content-Type", contentType)
+	return serve(h, r)
+}
+
+func decodeSummary(t *testing.T, rec *httptest.ResponseRecorder) ImportSummary {
+	t.Helper()
+	var summary ImportSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("import = %d %s: %v", rec.Code, rec.Body, err)
+	}
+	return summary
+}
+
+// itemsByName lists the stored items by name.
+func itemsByName(t *testing.T, h http.Handler) map[string]Item {
+	t.Helper()
+	rec := serve(h, newRequest(t, "GET", "/api/v1/items/export?format=json", ""))
+	var items []Item
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatalf("export = %d %s: %v", rec.Code, rec.Body, err)
+	}
+	byName := make(map[string]Item, len(items))
+	for _, item := range items {
+		byName[item.Name] = item
+	}
+	return byName
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []struct{ name, accept, contentType string }{
+		{"csv", "text/csv", contentTypeCSV},
+		{"json", "", "application/json"},
+	} {
+		t.Run(format.name, func(t *testing.T) {
+			_, src := newTestServer(t)
+			for i, name := range trickyNames {
+				body, _ := json.Marshal(map[string]any{"name": name, "data": map[string]any{"n": i, "note": `a "b", c` + "\n"}})
+				createItem(t, src, string(body))
+			}
+			r := newRequest(t, "GET", "/api/v1/items/export", "")
+			if format.accept != "" {
+				r.Header.Set("Accept", format.accept)
+			}
+			rec := serve(src, r)
+			if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), format.contentType) {
+				t.Fatalf("export = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment; filename=items-") || !strings.HasSuffix(cd, "."+format.name) {
+				t.Errorf("Content-Disposition = %q, want an attachment named items-*.%s", cd, format.name)
+			}
+			if format.name == "csv" {
+				records, err := csv.NewReader(strings.NewReader(rec.Body.String())).ReadAll()
+				if err != nil || len(records) != len(trickyNames)+1 || !slices.Equal(records[0], csvColumns) {
+					t.Fatalf("export is not the expected CSV (%v): %q", err, rec.Body)
+				}
+			}
+
+			_, dst := newTestServer(t)
+			summary := decodeSummary(t, importItems(t, dst, "", format.contentType, rec.Body.String()))
+			if summary.Imported != len(trickyNames) || summary.Failed != 0 {
+				t.Fatalf("import summary = %+v, want every item imported", summary)
+			}
+			want, got := itemsByName(t, src), itemsByName(t, dst)
+			for _, name := range trickyNames {
+				imported := name
+				if format.name == "csv" {
+					// encoding/csv reads a quoted \r\n as \n.
+					imported = strings.ReplaceAll(name, "\r\n", "\n")
+				}
+				if item, ok := got[imported]; !ok || !reflect.DeepEqual(item.Data, want[name].Data) {
+					t.Errorf("%q imported as %v, want its data %v", name, item, want[name].Data)
+				}
+			}
+		})
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantNames  []string
+		wantErrors []ImportError
+	}{
+		{
+			name:      "byte order mark and CRLF",
+			body:      "\ufeffname,data\r\nfirst,\r\nsecond,\"{\"\"k\"\": 1}\"\r\n",
+			wantNames: []string{"first", "second"},
+		},
+		{
+			name:      "columns in any order, extras ignored",
+			body:      "id,data,name,color\nx,,first,red\n",
+			wantNames: []string{"first"},
+		},
+		{
+			name:       "lines count quoted newlines",
+			body:       "name\n\"multi\nline\"\n\"\"\nafter\n",
+			wantNames:  []string{"after", "multi\nline"},
+			wantErrors: []ImportError{{Line: 4, Reason: "name is required"}},
+		},
+		{
+			name:       "bare quote",
+			body:       "name\nfine\nbad \"quote\nalso fine\n",
+			wantNames:  []string{"also fine", "fine"},
+			wantErrors: []ImportError{{Line: 3, Reason: `bare " in non-quoted-field`}},
+		},
+		{
+			name:       "wrong field count",
+			body:       "name,data\nfine,\nextra,,field\n",
+			wantNames:  []string{"fine"},
+			wantErrors: []ImportError{{Line: 3, Reason: "wrong number of fields"}},
+		},
+		{
+			name:       "data that isn't JSON",
+			body:       "name,data\nfine,\nbroken,not json\n",
+			wantNames:  []string{"fine"},
+			wantErrors: []ImportError{{Line: 3, Reason: "data must be a JSON object"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := importItems(t, h, "", contentTypeCSV, tc.body)
+			summary := decodeSummary(t, rec)
+			if rec.Code != http.StatusOK || summary.Imported != len(tc.wantNames) || summary.Failed != len(tc.wantErrors) {
+				t.Errorf("import = %d %+v, want %d imported and %d failed", rec.Code, summary, len(tc.wantNames), len(tc.wantErrors))
+			}
+			if tc.wantErrors == nil {
+				tc.wantErrors = []ImportError{}
+			}
+			if !reflect.DeepEqual(summary.Errors, tc.wantErrors) {
+				t.Errorf("errors = %+v, want %+v", summary.Errors, tc.wantErrors)
+			}
+			names := slices.Sorted(func(yield func(string) bool) {
+				for name := range itemsByName(t, h) {
+					if !yield(name) {
+						return
+					}
+				}
+			})
+			if !slices.Equal(names, tc.wantNames) {
+				t.Errorf("stored %q, want %q", names, tc.wantNames)
+			}
+		})
+	}
+
+	_, h := newTestServer(t)
+	if rec := importItems(t, h, "", contentTypeCSV, "title,data\nx,\n"); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "name column") {
+		t.Errorf("import without a name column = %d %s, want 422", rec.Code, rec.Body)
+	}
+	if rec := importItems(t, h, "", contentTypeCSV, ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("empty import = %d %s, want 422", rec.Code, rec.Body)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	body := `[{"name": "taken", "data": {"v": 2}}, {"name": "new"}]`
+	tests := []struct {
+		query                     string
+		imported, skipped, failed int
+		wantV                     float64
+	}{
+		{"", 1, 1, 0, 1},
+		{"?on_conflict=skip", 1, 1, 0, 1},
+		{"?on_conflict=overwrite", 2, 0, 0, 2},
+		{"?on_conflict=fail", 1, 0, 1, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			_, h := newTestServer(t)
+			createItem(t, h, `{"name": "taken", "data": {"v": 1}}`)
+			summary := decodeSummary(t, importItems(t, h, tc.query, "application/json", body))
+			if summary.Imported != tc.imported || summary.Skipped != tc.skipped || summary.Failed != tc.failed {
+				t.Errorf("summary = %+v, want %d imported, %d skipped, %d failed", summary, tc.imported, tc.skipped, tc.failed)
+			}
+			items := itemsByName(t, h)
+			if len(items) != 2 || items["taken"].Data["v"] != tc.wantV {
+				t.Errorf("stored %v, want taken at v=%v and new", items, tc.wantV)
+			}
+		})
+	}
+}
+
+func TestImportAtomic(t *testing.T) {
+	_, h := newTestServer(t)
+	createItem(t, h, `{"name": "existing"}`)
+	rows := make([]string, 0, 51)
+	for i := range 50 {
+		rows = append(rows, fmt.Sprintf(`{"name": "row %d"}`, i))
+	}
+	bad := append(rows, `{"name": ""}`)
+
+	rec := importItems(t, h, "?atomic=true", "application/json", "["+strings.Join(bad, ",\n")+"]")
+	summary := decodeSummary(t, rec)
+	if rec.Code != http.StatusConflict || !summary.RolledBack || summary.Imported != 0 || !reflect.DeepEqual(summary.Errors, []ImportError{{Line: 51, Reason: "name is required"}}) {
+		t.Fatalf("atomic import with a bad row = %d %+v, want 409 rolled back, reporting line 51", rec.Code, summary)
+	}
+	if items := itemsByName(t, h); len(items) != 1 {
+		t.Fatalf("stored %d items after a rolled-back import, want just the existing one", len(items))
+	}
+
+	rec = importItems(t, h, "?atomic=true", "application/json", "["+strings.Join(rows, ",\n")+"]")
+	if summary := decodeSummary(t, rec); rec.Code != http.StatusOK || summary.Imported != 50 || !summary.Atomic {
+		t.Errorf("atomic import = %d %+v, want all 50 imported", rec.Code, summary)
+	}
+	if rec := importItems(t, h, "?on_conflict=maybe", "application/json", "[]"); rec.Code != http.StatusBadRequest {
+		t.Errorf("import with an unknown on_conflict = %d, want 400", rec.Code)
+	}
+}
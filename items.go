@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Item is a resource managed through /api/items.
+type Item struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Data      map[string]any `json:"data,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	// Version starts at 1 and is bumped on every write. It is also the
+	// item's ETag, for If-Match.
+	Version int64 `json:"version"`
+	// DeletedAt is set while the item is soft-deleted, until it is
+	// restored or purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Tenant owns the item; it is empty for the default tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// itemRequest is the body accepted by create and replace.
+type itemRequest struct {
+	Name string         `json:"name"`
+	Data map[string]any `json:"data"`
+	// Version, on replace, is the version the client read, like If-Match.
+	Version *int64 `json:"version,omitempty"`
+}
+
+// Validate requires a name.
+func (req *itemRequest) Validate() []FieldError {
+	if req.Name == "" {
+		return []FieldError{{Field: "name", Constraint: "required", Message: "is required"}}
+	}
+	return nil
+}
+
+// decodeItemRequest decodes and validates an item body, writing the error
+// response itself and returning false on failure.
+func decodeItemRequest(w http.ResponseWriter, r *http.Request) (itemRequest, bool) {
+	var req itemRequest
+	if !decodeValid(w, r, &req) {
+		return itemRequest{}, false
+	}
+	return req, true
+}
+
+// writeStoreError reports a store failure: 404 for unknown IDs, 409 for
+// version conflicts, 503 while the store's circuit is open or it is
+// read-only, 500 for anything else (such as a failed write to the data
+// file).
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		// The client went away or the request timed out, which withTimeout
+		// answers; either way there is nothing to report here.
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeItemNotFound(w, r)
+		return
+	}
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		writeCircuitOpen(w, r, open)
+		return
+	}
+	var readOnly *readOnlyError
+	if errors.As(err, &readOnly) {
+		writeReadOnly(w, r, readOnly)
+		return
+	}
+	var conflict *versionConflictError
+	if errors.As(err, &conflict) {
+		writeVersionConflict(w, r, conflict)
+		return
+	}
+	loggerFrom(r.Context()).Error("store operation failed", slog.String("method", r.Method), slog.Any("error", err))
+	writeError(w, r, http.StatusInternalServerError, codeInternal, "internal server error", "")
+}
+
+func writeItemNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, codeNotFound, "Item not found", "")
+}
+
+// itemETag is the strong validator for item: its version.
+func itemETag(item Item) string {
+	return `"` + strconv.FormatInt(item.Version, 10) + `"`
+}
+
+// versionConflictError rejects a write whose precondition doesn't match the
+// stored item.
+type versionConflictError struct {
+	Current Item
+}
+
+func (e *versionConflictError) Error() string {
+	return fmt.Sprintf("item is at version %d", e.Current.Version)
+}
+
+// writeVersionConflict answers 409 with the current version, in the body and
+// as the ETag, so the client can re-read and retry.
+func writeVersionConflict(w http.ResponseWriter, r *http.Request, err *versionConflictError) {
+	w.Header().Set("ETag", itemETag(err.Current))
+	writeError(w, r, http.StatusConflict, codeVersionConflict, "Version conflict", err.Error(), FieldError{
+		Field:      "version",
+		Constraint: "match",
+		Message:    "does not match the current version",
+		Value:      err.Current.Version,
+	})
+}
+
+// itemPrecondition is what a write expects of the stored item: the ETags in
+// If-Match, or a version from the body, or both.
+type itemPrecondition struct {
+	ifMatch string
+	version *int64
+}
+
+func (p itemPrecondition) present() bool {
+	return p.ifMatch != "" || p.version != nil
+}
+
+// check returns a *versionConflictError unless item satisfies p. It runs
+// inside the store's Patch, so nothing can write between the check and the
+// update.
+func (p itemPrecondition) check(item Item) error {
+	if p.version != nil && *p.version != item.Version {
+		return &versionConflictError{Current: item}
+	}
+	if p.ifMatch != "" && !ifMatchCovers(p.ifMatch, itemETag(item)) {
+		return &versionConflictError{Current: item}
+	}
+	return nil
+}
+
+// ifMatchCovers applies the strong comparison If-Match calls for: weak
+// validators never match, and "*" matches any item.
+func ifMatchCovers(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// readPrecondition reads the write's precondition. With
+// STRICT_CONCURRENCY a write without one is answered with 428 and false.
+func (s *Server) readPrecondition(w http.ResponseWriter, r *http.Request, version *int64) (itemPrecondition, bool) {
+	p := itemPrecondition{ifMatch: r.Header.Get("If-Match"), version: version}
+	if !p.present() && s.cfg.StrictConcurrency {
+		writeError(w, r, http.StatusPreconditionRequired, codePreconditionRequired, "Precondition required",
+			"send If-Match with the item's ETag, or its version in the body")
+		return p, false
+	}
+	return p, true
+}
+
+// createItemHandler handles POST /api/items under each API version prefix.
+func (s *Server) createItemHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeItemRequest(w, r)
+	if !ok {
+		return
+	}
+	item, err := s.storeFor(r).Create(r.Context(), Item{Name: req.Name, Data: req.Data})
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	s.itemChanged(r, webhookItemCreated, item)
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+item.ID)
+	w.Header().Set("ETag", itemETag(item))
+	writeResponse(w, r, http.StatusCreated, item)
+}
+
+// defaultPageLimit is the page size used when ?limit= is omitted.
+const defaultPageLimit = 20
+
+// ItemList is the paginated envelope returned by GET /api/items.
+type ItemList struct {
+	Items  []Item `json:"items"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// parseItemQuery reads the pagination, sort, and filter parameters, capping
+// limit at maxLimit.
+func parseItemQuery(r *http.Request, maxLimit int) (itemQuery, error) {
+	values := r.URL.Query()
+	q := itemQuery{
+		NamePrefix: values.Get("name"),
+		SortBy:     "created_at",
+		After:      values.Get("after"),
+		Limit:      min(defaultPageLimit, maxLimit),
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			return itemQuery{}, fmt.Errorf("limit must be an integer between 1 and %d", maxLimit)
+		}
+		q.Limit = n
+	}
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return itemQuery{}, fmt.Errorf("offset must be a non-negative integer")
+		}
+		q.Offset = n
+	}
+	if v := values.Get("sort"); v != "" {
+		if v != "created_at" && v != "name" {
+			return itemQuery{}, fmt.Errorf("sort must be one of created_at, name")
+		}
+		q.SortBy = v
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return itemQuery{}, fmt.Errorf("order must be asc or desc")
+	}
+	if v := values.Get("include_deleted"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return itemQuery{}, fmt.Errorf("include_deleted must be true or false")
+		}
+		q.IncludeDeleted = include
+	}
+	return q, nil
+}
+
+// listItemsHandler handles GET /api/items with ?limit=, ?offset= or
+// ?after=<id>, ?sort=created_at|name, ?order=asc|desc, ?name= prefix
+// filtering, and ?include_deleted=true for soft-deleted items too. With a
+// generation-tracking store, If-None-Match is answered before the store is
+// queried. ?fields= or ?exclude= trims every item, streamed or not, to the
+// fields named or the rest.
+//
+// ?format=ndjson or Accept: application/x-ndjson streams the items one per
+// line instead. Streams aren't held in memory, so they list every match
+// unless ?limit= is given, and ITEMS_MAX_LIMIT doesn't apply.
+func (s *Server) listItemsHandler(w http.ResponseWriter, r *http.Request) {
+	ndjson, err := wantsNDJSON(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", err.Error())
+		return
+	}
+	maxLimit := s.cfg.ItemsMaxLimit
+	if ndjson {
+		maxLimit = math.MaxInt32
+	}
+	q, err := parseItemQuery(r, maxLimit)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", err.Error())
+		return
+	}
+	if ndjson && !r.URL.Query().Has("limit") {
+		q.Limit = 0
+	}
+	sel, ok := readFieldSelection(w, r)
+	if !ok {
+		return
+	}
+	if s.generation != nil && !inBatch(r.Context()) {
+		// The store can say whether anything changed without listing it.
+		etag := generationETag(s.generation(tenantFrom(r.Context())), r)
+		w.Header().Set("ETag", etag)
+		if notModified(w, r, etag) {
+			return
+		}
+	}
+	if ndjson {
+		format := ndjsonFormat
+		if sel != nil {
+			format = sel.ndjsonFormat()
+		}
+		s.streamItems(w, r, q, format)
+		return
+	}
+	items, total, err := s.storeFor(r).List(r.Context(), q)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		writeStoreError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", fmt.Sprintf("after references unknown item %q", q.After))
+		return
+	}
+	writeSelected(w, r, sel, ItemList{Items: items, Total: total, Limit: q.Limit, Offset: q.Offset}, "items")
+}
+
+// getItemHandler handles GET /api/items/{id}. The ETag is the item's
+// version, or with ?fields= or ?exclude= a weak one derived from it.
+func (s *Server) getItemHandler(w http.ResponseWriter, r *http.Request) {
+	sel, ok := readFieldSelection(w, r)
+	if !ok {
+		return
+	}
+	item, err := s.storeFor(r).Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	etag := itemETag(item)
+	if sel != nil {
+		etag = sel.etag(etag)
+	}
+	w.Header().Set("ETag", etag)
+	writeSelected(w, r, sel, item, "")
+}
+
+// updateItemHandler handles PUT /api/items/{id}, replacing the name and data.
+// With If-Match or a body version, the replace only happens if the item is
+// still at that version; otherwise it gets a 409.
+func (s *Server) updateItemHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeItemRequest(w, r)
+	if !ok {
+		return
+	}
+	pre, ok := s.readPrecondition(w, r, req.Version)
+	if !ok {
+		return
+	}
+	var item Item
+	var err error
+	if pre.present() {
+		item, err = s.storeFor(r).Patch(r.Context(), r.PathValue("id"), func(existing Item) (Item, error) {
+			if err := pre.check(existing); err != nil {
+				return Item{}, err
+			}
+			existing.Name = req.Name
+			existing.Data = req.Data
+			return existing, nil
+		})
+	} else {
+		item, err = s.storeFor(r).Update(r.Context(), r.PathValue("id"), Item{Name: req.Name, Data: req.Data})
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	s.itemChanged(r, webhookItemUpdated, item)
+	w.Header().Set("ETag", itemETag(item))
+	writeResponse(w, r, http.StatusOK, item)
+}
+
+// deleteItemHandler handles DELETE /api/items/{id}, a soft delete that POST
+// /api/items/{id}/restore undoes until DELETED_ITEM_RETENTION passes.
+// ?hard=true purges the item, deleted or not, at once.
+func (s *Server) deleteItemHandler(w http.ResponseWriter, r *http.Request) {
+	hard := false
+	if v := r.URL.Query().Get("hard"); v != "" {
+		var err error
+		if hard, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", "hard must be true or false")
+			return
+		}
+	}
+	id := r.PathValue("id")
+	var err error
+	if hard {
+		err = s.storeFor(r).Purge(r.Context(), id)
+	} else {
+		err = s.storeFor(r).Delete(r.Context(), id)
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	s.itemChanged(r, webhookItemDeleted, deletedItem{ID: id, Purged: hard})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreItemHandler handles POST /api/items/{id}/restore. An item that
+// isn't deleted is returned as it is; one that is gone, purged or never
+// created, is a 404 with code item_purged, since no retry will bring it
+// back.
+func (s *Server) restoreItemHandler(w http.ResponseWriter, r *http.Request) {
+	item, restored, err := s.storeFor(r).Restore(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, codeItemPurged, "Item not found", "the item was purged or never existed")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if restored {
+		s.itemChanged(r, webhookItemRestored, item)
+	}
+	w.Header().Set("ETag", itemETag(item))
+	writeResponse(w, r, http.StatusOK, item)
+}
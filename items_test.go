@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestItemsCRUD(t *testing.T) {
+	_, h := newTestServer(t)
+
+	rec := serve(h, newRequest(t, "POST", "/api/v1/items", `{"name": "widget", "data": {"color": "red"}}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST = %d %s, want 201", rec.Code, rec.Body)
+	}
+	item := decodeItem(t, rec)
+	if len(item.ID) != 36 || item.Name != "widget" || item.CreatedAt.IsZero() {
+		t.Errorf("created item = %+v, want a UUID, the name, and timestamps", item)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/v1/items/"+item.ID {
+		t.Errorf("Location = %q, want /api/v1/items/%s", loc, item.ID)
+	}
+
+	rec = serve(h, newRequest(t, "GET", "/api/v1/items/"+item.ID, ""))
+	if got := decodeItem(t, rec); rec.Code != http.StatusOK || got.ID != item.ID || got.Data["color"] != "red" {
+		t.Errorf("GET = %d %+v, want the created item", rec.Code, got)
+	}
+
+	rec = serve(h, newRequest(t, "GET", "/api/v1/items", ""))
+	var list ItemList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || list.Total != 1 || list.Items[0].ID != item.ID {
+		t.Errorf("GET list = %d %s, want the one item", rec.Code, rec.Body)
+	}
+
+	rec = serve(h, newRequest(t, "PUT", "/api/v1/items/"+item.ID, `{"name": "gadget"}`))
+	if got := decodeItem(t, rec); rec.Code != http.StatusOK || got.Name != "gadget" || got.Data != nil || !got.CreatedAt.Equal(item.CreatedAt) {
+		t.Errorf("PUT = %d %+v, want the replaced item keeping created_at", rec.Code, got)
+	}
+
+	if rec := serve(h, newRequest(t, "DELETE", "/api/v1/items/"+item.ID, "")); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("DELETE = %d %q, want an empty 204", rec.Code, rec.Body)
+	}
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		body := ""
+		if method == "PUT" {
+			body = `{"name": "ghost"}`
+		}
+		rec := serve(h, newRequest(t, method, "/api/v1/items/"+item.ID, body))
+		var resp ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); rec.Code != http.StatusNotFound || err != nil || resp.Code != codeNotFound {
+			t.Errorf("%s of a deleted item = %d %s, want a JSON 404", method, rec.Code, rec.Body)
+		}
+	}
+}
+
+// TestItemsConcurrentAccess is meant for go test -race.
+func TestItemsConcurrentAccess(t *testing.T) {
+	_, h := newTestServer(t)
+	const writers, perWriter = 8, 25
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := range perWriter {
+				rec := serve(h, newRequest(t, "POST", "/api/v1/items", fmt.Sprintf(`{"name": "item %d-%d"}`, w, i)))
+				if rec.Code != http.StatusCreated {
+					t.Errorf("POST = %d", rec.Code)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range perWriter {
+				if rec := serve(h, newRequest(t, "GET", "/api/v1/items", "")); rec.Code != http.StatusOK {
+					t.Errorf("GET = %d", rec.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var list ItemList
+	json.Unmarshal(serve(h, newRequest(t, "GET", "/api/v1/items?limit=1", "")).Body.Bytes(), &list)
+	if list.Total != writers*perWriter {
+		t.Errorf("total = %d after concurrent creates, want %d", list.Total, writers*perWriter)
+	}
+}
+
+func TestListItemsQuery(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.ItemsMaxLimit = 10 })
+	ids := map[string]string{}
+	for _, name := range []string{"echo", "charlie", "alpha", "delta", "bravo", "alpine"} {
+		ids[name] = createItem(t, h, fmt.Sprintf(`{"name": %q}`, name)).ID
+	}
+	list := func(query string) ItemList {
+		t.Helper()
+		rec := serve(h, newRequest(t, "GET", "/api/v1/items?"+query, ""))
+		var list ItemList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/v1/items?%s = %d %s", query, rec.Code, rec.Body)
+		}
+		return list
+	}
+	names := func(list ItemList) string {
+		var names []string
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		return fmt.Sprint(names)
+	}
+
+	tests := []struct {
+		query     string
+		wantNames string
+		wantTotal int
+	}{
+		{"sort=name", "[alpha alpine bravo charlie delta echo]", 6},
+		{"sort=name&order=desc&limit=2", "[echo delta]", 6},
+		{"sort=name&limit=2&offset=2", "[bravo charlie]", 6},
+		{"sort=name&offset=5", "[echo]", 6},
+		{"sort=name&offset=6", "[]", 6},
+		{"sort=name&offset=100", "[]", 6},
+		{"sort=name&name=al", "[alpha alpine]", 2},
+		{"name=zulu", "[]", 0},
+	}
+	for _, tt := range tests {
+		got := list(tt.query)
+		if names(got) != tt.wantNames || got.Total != tt.wantTotal {
+			t.Errorf("?%s = %s of %d, want %s of %d", tt.query, names(got), got.Total, tt.wantNames, tt.wantTotal)
+		}
+	}
+	if got := list("sort=name&limit=2&offset=1"); got.Limit != 2 || got.Offset != 1 {
+		t.Errorf("envelope limit %d offset %d, want 2 and 1", got.Limit, got.Offset)
+	}
+	if got := list("sort=name&after=" + ids["charlie"]); names(got) != "[delta echo]" {
+		t.Errorf("?after=charlie = %s, want [delta echo]", names(got))
+	}
+
+	for _, query := range []string{"limit=0", "limit=-1", "limit=11", "limit=ten", "offset=-1", "sort=color", "order=up"} {
+		rec := serve(h, newRequest(t, "GET", "/api/v1/items?"+query, ""))
+		var resp ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); rec.Code != http.StatusBadRequest || err != nil || resp.Detail == "" {
+			t.Errorf("?%s = %d %s, want a 400 explaining the parameter", query, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	_, h := newTestServer(t)
+	item := createItem(t, h, `{"name": "widget"}`)
+	if item.Version != 1 {
+		t.Fatalf("created version = %d, want 1", item.Version)
+	}
+	rec := serve(h, newRequest(t, "GET", "/api/v1/items/"+item.ID, ""))
+	if etag := rec.Header().Get("ETag"); etag != `"1"` {
+		t.Fatalf("GET ETag = %q, want the version", etag)
+	}
+
+	put := func(ifMatch, body string) *httptest.ResponseRecorder {
+		r := newRequest(t, "PUT", "/api/v1/items/"+item.ID, body)
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		return serve(h, r)
+	}
+	if rec := put(`"1"`, `{"name": "v2"}`); rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"2"` || decodeItem(t, rec).Version != 2 {
+		t.Fatalf("PUT If-Match current = %d ETag %q, want 200 at version 2", rec.Code, rec.Header().Get("ETag"))
+	}
+	for _, tc := range []struct{ name, ifMatch, body string }{
+		{"stale If-Match", `"1"`, `{"name": "lost"}`},
+		{"weak If-Match", `W/"2"`, `{"name": "lost"}`},
+		{"stale body version", "", `{"name": "lost", "version": 1}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := put(tc.ifMatch, tc.body)
+			if rec.Code != http.StatusConflict || rec.Header().Get("ETag") != `"2"` {
+				t.Fatalf("PUT = %d ETag %q, want 409 with the current ETag", rec.Code, rec.Header().Get("ETag"))
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Details) != 1 || body.Details[0].Value != float64(2) {
+				t.Errorf("409 body = %s, want the current version", rec.Body)
+			}
+		})
+	}
+	if rec := patchItem(t, h, item.ID, `{"name": "lost", "version": 1}`); rec.Code != http.StatusConflict {
+		t.Errorf("PATCH stale version = %d, want 409", rec.Code)
+	}
+	if rec := put("*", `{"name": "v3"}`); rec.Code != http.StatusOK || decodeItem(t, rec).Version != 3 {
+		t.Errorf("PUT If-Match * = %d, want 200 at version 3", rec.Code)
+	}
+	if rec := put("", `{"name": "v4"}`); rec.Code != http.StatusOK {
+		t.Errorf("PUT without a precondition = %d, want 200 unless STRICT_CONCURRENCY", rec.Code)
+	}
+}
+
+func TestStrictConcurrencyRequiresPrecondition(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.StrictConcurrency = true })
+	item := createItem(t, h, `{"name": "widget"}`)
+	if rec := serve(h, newRequest(t, "PUT", "/api/v1/items/"+item.ID, `{"name": "blind"}`)); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("PUT without a precondition = %d, want 428", rec.Code)
+	}
+	if rec := patchItem(t, h, item.ID, `{"name": "blind"}`); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("PATCH without a precondition = %d, want 428", rec.Code)
+	}
+	if rec := patchItem(t, h, item.ID, `{"name": "careful", "version": 1}`); rec.Code != http.StatusOK {
+		t.Errorf("PATCH with a version = %d %s, want 200", rec.Code, rec.Body)
+	}
+}
+
+func TestConcurrentUpdatesOneWins(t *testing.T) {
+	_, h := newTestServer(t)
+	for round := range 50 {
+		item := createItem(t, h, `{"name": "contended"}`)
+		var wg sync.WaitGroup
+		codes := make([]int, 2)
+		for i := range codes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := newRequest(t, "PUT", "/api/v1/items/"+item.ID, fmt.Sprintf(`{"name": "writer %d"}`, i))
+				r.Header.Set("If-Match", itemETag(item))
+				codes[i] = serve(h, r).Code
+			}()
+		}
+		wg.Wait()
+		if !slices.Equal(codes, []int{200, 409}) && !slices.Equal(codes, []int{409, 200}) {
+			t.Fatalf("round %d: racing updates got %v, want exactly one 200 and one 409", round, codes)
+		}
+		got := decodeItem(t, serve(h, newRequest(t, "GET", "/api/v1/items/"+item.ID, "")))
+		if got.Version != 2 {
+			t.Fatalf("round %d: version = %d after one update, want 2", round, got.Version)
+		}
+	}
+}
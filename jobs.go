@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job statuses. Queued and running jobs are unfinished; the rest are final.
+const (
+	jobQueued      = "queued"
+	jobRunning     = "running"
+	jobDone        = "done"
+	jobFailed      = "failed"
+	jobCancelled   = "cancelled"
+	jobInterrupted = "interrupted"
+)
+
+// jobTypes are the kinds of work POST /jobs accepts.
+var jobTypes = []string{"sleep", "fibonacci", "http_check"}
+
+const (
+	// jobQueueSize bounds the jobs waiting for a worker; submissions
+	// beyond it get 503.
+	jobQueueSize = 100
+	// maxSleepJob and maxFibonacciN keep demo jobs from running forever.
+	maxSleepJob   = time.Hour
+	maxFibonacciN = 100000
+)
+
+var (
+	errJobQueueFull = errors.New("job queue is full")
+	errJobsClosed   = errors.New("server is shutting down")
+	errJobNotFound  = errors.New("job not found")
+	errJobFinished  = errors.New("job already finished")
+)
+
+// Job is the state of a background job as returned by /jobs. Progress runs
+// from 0 to 1.
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Status     string          `json:"status"`
+	Progress   float64         `json:"progress"`
+	Result     any             `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status != jobQueued && j.Status != jobRunning
+}
+
+// JobRequest is the body of POST /jobs.
+type JobRequest struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params"`
+
+	// allowedHosts restricts http_check targets; params holds the decoded
+	// parameters once Validate succeeds.
+	allowedHosts hostAllowlist
+	params       any
+}
+
+type sleepParams struct {
+	Duration string `json:"duration"`
+
+	duration time.Duration
+}
+
+type fibonacciParams struct {
+	N int `json:"n"`
+}
+
+type httpCheckParams struct {
+	URL string `json:"url"`
+}
+
+// Validate checks the type and decodes and checks its params: a duration of
+// up to an hour for sleep, n from 0 to 100000 for fibonacci, and an
+// allowlisted http or https URL for http_check.
+func (req *JobRequest) Validate() []FieldError {
+	if req.Type == "" {
+		return []FieldError{{Field: "type", Constraint: "required", Message: "is required"}}
+	}
+	if !slices.Contains(jobTypes, req.Type) {
+		return []FieldError{{Field: "type", Constraint: "enum", Message: "must be one of " + strings.Join(jobTypes, ", "), Value: req.Type}}
+	}
+
+	raw := map[string]json.RawMessage{}
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &raw); err != nil {
+			return []FieldError{{Field: "params", Constraint: "type", Message: "must be an object", Value: rawValue(req.Params)}}
+		}
+	}
+	var problems []FieldError
+	switch req.Type {
+	case "sleep":
+		var p sleepParams
+		problems = decodeFields(raw, &p)
+		if len(problems) == 0 {
+			d, err := time.ParseDuration(p.Duration)
+			switch {
+			case p.Duration == "":
+				problems = append(problems, FieldError{Field: "duration", Constraint: "required", Message: "is required"})
+			case err != nil || d <= 0 || d > maxSleepJob:
+				problems = append(problems, FieldError{Field: "duration", Constraint: "format", Message: fmt.Sprintf("must be a duration such as 5s, up to %s", maxSleepJob), Value: p.Duration})
+			}
+			p.duration = d
+		}
+		req.params = p
+	case "fibonacci":
+		var p fibonacciParams
+		problems = decodeFields(raw, &p)
+		if len(problems) == 0 && (p.N < 0 || p.N > maxFibonacciN) {
+			problems = append(problems, FieldError{Field: "n", Constraint: "range", Message: fmt.Sprintf("must be between 0 and %d", maxFibonacciN), Value: p.N})
+		}
+		req.params = p
+	case "http_check":
+		var p httpCheckParams
+		problems = decodeFields(raw, &p)
+		if len(problems) == 0 {
+			target, err := url.Parse(p.URL)
+			switch {
+			case p.URL == "":
+				problems = append(problems, FieldError{Field: "url", Constraint: "required", Message: "is required"})
+			case err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "":
+				problems = append(problems, FieldError{Field: "url", Constraint: "format", Message: "must be an absolute http or https URL", Value: p.URL})
+			case !req.allowedHosts.Allows(target):
+				problems = append(problems, FieldError{Field: "url", Constraint: "allowed_hosts", Message: "must match FETCH_ALLOWED_HOSTS", Value: p.URL})
+			}
+		}
+		req.params = p
+	}
+	for i := range problems {
+		problems[i].Field = "params." + problems[i].Field
+	}
+	return problems
+}
+
+// jobFunc does a job's work, reporting progress from 0 to 1 as it goes. It
+// must return promptly once ctx is done.
+type jobFunc func(ctx context.Context, progress func(float64)) (any, error)
+
+// jobEntry is a job with what its worker needs to run and cancel it.
+type jobEntry struct {
+	job       Job
+	run       jobFunc
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// jobQueue runs submitted jobs on a fixed pool of workers. Each job gets
+// timeout to finish; finished jobs are kept for retention and then
+// forgotten.
+type jobQueue struct {
+	timeout   time.Duration
+	retention time.Duration
+	now       func() time.Time
+	logger    *slog.Logger
+
+	mu     sync.Mutex
+	jobs   map[string]*jobEntry
+	closed bool
+
+	queue chan *jobEntry
+	// ctx is cancelled when a shutdown runs out of time, interrupting
+	// running jobs.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobQueue(workers int, timeout, retention time.Duration, now func() time.Time, logger *slog.Logger) *jobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &jobQueue{
+		timeout:   timeout,
+		retention: retention,
+		now:       now,
+		logger:    logger,
+		jobs:      make(map[string]*jobEntry),
+		queue:     make(chan *jobEntry, jobQueueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	for range workers {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for e := range q.queue {
+				q.runJob(e)
+			}
+		}()
+	}
+	return q
+}
+
+// Queued counts the jobs waiting for a worker.
+func (q *jobQueue) Queued() int {
+	return len(q.queue)
+}
+
+// Submit queues a job, returning its initial state.
+func (q *jobQueue) Submit(jobType string, params json.RawMessage, run jobFunc) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, errJobsClosed
+	}
+	q.pruneLocked(q.now())
+	e := &jobEntry{
+		job: Job{ID: newUUID(), Type: jobType, Params: params, Status: jobQueued, CreatedAt: q.now().UTC()},
+		run: run,
+	}
+	select {
+	case q.queue <- e:
+	default:
+		return Job{}, errJobQueueFull
+	}
+	q.jobs[e.job.ID] = e
+	return e.job, nil
+}
+
+// Get returns a job's current state.
+func (q *jobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pruneLocked(q.now())
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// List returns every retained job, newest first.
+func (q *jobQueue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pruneLocked(q.now())
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, e := range q.jobs {
+		jobs = append(jobs, e.job)
+	}
+	slices.SortFunc(jobs, func(a, b Job) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return jobs
+}
+
+// Cancel stops a job. A queued job is cancelled at once; a running one is
+// signalled and reaches cancelled when its work returns.
+func (q *jobQueue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	switch {
+	case !ok:
+		return Job{}, errJobNotFound
+	case e.job.finished():
+		return e.job, errJobFinished
+	case e.job.Status == jobQueued:
+		q.finishLocked(e, jobCancelled, "cancelled before it started")
+	default:
+		e.cancelled = true
+		e.cancel()
+	}
+	return e.job, nil
+}
+
+func (q *jobQueue) runJob(e *jobEntry) {
+	q.mu.Lock()
+	if e.job.Status != jobQueued {
+		// Cancelled or interrupted while waiting.
+		q.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithTimeout(q.ctx, q.timeout)
+	defer cancel()
+	e.cancel = cancel
+	started := q.now().UTC()
+	e.job.Status = jobRunning
+	e.job.StartedAt = &started
+	q.mu.Unlock()
+
+	result, err := e.run(ctx, func(p float64) {
+		q.mu.Lock()
+		e.job.Progress = min(max(p, 0), 1)
+		q.mu.Unlock()
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case err == nil:
+		e.job.Progress = 1
+		e.job.Result = result
+		q.finishLocked(e, jobDone, "")
+	case e.cancelled:
+		q.finishLocked(e, jobCancelled, "cancelled while running")
+	case q.ctx.Err() != nil:
+		q.finishLocked(e, jobInterrupted, "server shut down before the job finished")
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		q.finishLocked(e, jobFailed, fmt.Sprintf("timed out after %s", q.timeout))
+	default:
+		q.finishLocked(e, jobFailed, err.Error())
+	}
+	q.logger.Info("job finished", slog.String("job_id", e.job.ID), slog.String("type", e.job.Type), slog.String("status", e.job.Status))
+}
+
+func (q *jobQueue) finishLocked(e *jobEntry, status, msg string) {
+	finished := q.now().UTC()
+	e.job.Status = status
+	e.job.Error = msg
+	e.job.FinishedAt = &finished
+}
+
+// pruneLocked forgets jobs that finished more than q.retention ago.
+func (q *jobQueue) pruneLocked(now time.Time) {
+	for id, e := range q.jobs {
+		if e.job.FinishedAt != nil && now.Sub(*e.job.FinishedAt) > q.retention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// prune forgets expired jobs, so they are freed even when nobody calls
+// /jobs.
+func (q *jobQueue) prune() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pruneLocked(q.now())
+}
+
+// Shutdown stops accepting jobs, marks queued ones interrupted, and waits
+// for running ones to finish. When ctx ends first, running jobs are
+// cancelled and marked interrupted, and ctx's error is returned.
+func (q *jobQueue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, e := range q.jobs {
+			if e.job.Status == jobQueued {
+				q.finishLocked(e, jobInterrupted, "server shut down before the job started")
+			}
+		}
+		close(q.queue)
+	}
+	idle := true
+	for _, e := range q.jobs {
+		if e.job.Status == jobRunning {
+			idle = false
+		}
+	}
+	q.mu.Unlock()
+	if idle {
+		// Workers exit as soon as they see the closed queue.
+		q.cancel()
+		q.wg.Wait()
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// jobFunc returns the work for a validated request.
+func (s *Server) jobFunc(req JobRequest) jobFunc {
+	switch p := req.params.(type) {
+	case sleepParams:
+		return func(ctx context.Context, progress func(float64)) (any, error) {
+			return sleepJob(ctx, p.duration, progress)
+		}
+	case fibonacciParams:
+		return func(ctx context.Context, progress func(float64)) (any, error) {
+			return fibonacciJob(ctx, p.N, progress)
+		}
+	case httpCheckParams:
+		return func(ctx context.Context, progress func(float64)) (any, error) {
+			return s.httpCheckJob(ctx, p.URL)
+		}
+	}
+	panic("unhandled job type " + req.Type)
+}
+
+// sleepJob waits for d, reporting progress every tenth of it.
+func sleepJob(ctx context.Context, d time.Duration, progress func(float64)) (any, error) {
+	start := time.Now()
+	ticker := time.NewTicker(max(d/10, 10*time.Millisecond))
+	defer ticker.Stop()
+	deadline := time.NewTimer(d)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+			progress(float64(time.Since(start)) / float64(d))
+		case <-deadline.C:
+			return map[string]any{"slept": d.String()}, nil
+		}
+	}
+}
+
+// fibonacciJob computes the nth Fibonacci number. The value is a decimal
+// string since it quickly outgrows JSON numbers.
+func fibonacciJob(ctx context.Context, n int, progress func(float64)) (any, error) {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for i := range n {
+		if i%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			progress(float64(i) / float64(n))
+		}
+		a.Add(a, b)
+		a, b = b, a
+	}
+	value := a.String()
+	return map[string]any{"n": n, "digits": len(value), "value": value}, nil
+}
+
+// httpCheckJob GETs target through the /fetch client and reports the status
+// and latency. Any response counts as done; only a failed request fails.
+func (s *Server) httpCheckJob(ctx context.Context, target string) (any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "my-go-app/"+s.build.Version+" (job)")
+	start := time.Now()
+	resp, err := s.fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return map[string]any{
+		"url":         target,
+		"status_code": resp.StatusCode,
+		"healthy":     resp.StatusCode < 400,
+		"latency_ms":  float64(time.Since(start).Microseconds()) / 1000,
+	}, nil
+}
+
+// createJobHandler handles POST /jobs.
+func (s *Server) createJobHandler(w http.ResponseWriter, r *http.Request) {
+	req := JobRequest{allowedHosts: s.cfg.FetchAllowedHosts}
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	job, err := s.jobs.Submit(req.Type, req.Params, s.jobFunc(req))
+	if err != nil {
+		writeRetryableError(w, r, http.StatusServiceUnavailable, retryAfterDefault, codeUnavailable, "Cannot queue job", err.Error())
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeResponse(w, r, http.StatusAccepted, job)
+}
+
+// listJobsHandler handles GET /jobs.
+func (s *Server) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, s.jobs.List())
+}
+
+// getJobHandler handles GET /jobs/{id}.
+func (s *Server) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Job not found", "")
+		return
+	}
+	writeResponse(w, r, http.StatusOK, job)
+}
+
+// cancelJobHandler handles DELETE /jobs/{id}: 200 once the job is
+// cancelled, 202 while a running job is still stopping, and 409 for jobs
+// that already finished.
+func (s *Server) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Cancel(r.PathValue("id"))
+	switch {
+	case errors.Is(err, errJobNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Job not found", "")
+	case errors.Is(err, errJobFinished):
+		writeError(w, r, http.StatusConflict, codeJobFinished, "Job already finished", "job is "+job.Status)
+	case job.finished():
+		writeResponse(w, r, http.StatusOK, job)
+	default:
+		writeResponse(w, r, http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHTTPCheckJobRespectsAllowlist(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	allowed, _ := url.Parse(upstream.URL)
+	_, handler := newTestServer(t, func(cfg *Config) {
+		cfg.FetchAllowedHosts = mustAllowlist(t, upstream.URL)
+	})
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{upstream.URL + "/healthz", http.StatusAccepted},
+		{"http://" + allowed.Host + "@evil.net/", http.StatusUnprocessableEntity},
+		{"http://" + allowed.Hostname() + ".evil.net:" + allowed.Port() + "/", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		body := `{"type": "http_check", "params": {"url": "` + tt.url + `"}}`
+		rec := serve(handler, newRequest(t, "POST", "/jobs", body))
+		if rec.Code != tt.want {
+			t.Errorf("POST /jobs http_check %s = %d %s, want %d", tt.url, rec.Code, rec.Body, tt.want)
+		}
+		if tt.want != http.StatusAccepted && !strings.Contains(rec.Body.String(), "allowed_hosts") {
+			t.Errorf("POST /jobs http_check %s: %s, want an allowed_hosts error", tt.url, rec.Body)
+		}
+	}
+}
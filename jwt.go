@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtLeeway absorbs clock skew between the issuer and this server when
+// checking exp and nbf.
+const jwtLeeway = 30 * time.Second
+
+// jwtSigningMethods are the asymmetric algorithms accepted. HMAC is excluded
+// so a public key can never be used as a shared secret.
+var jwtSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwtVerifier validates bearer tokens against either a static public key or
+// keys fetched from a JWKS endpoint.
+type jwtVerifier struct {
+	staticKey any
+	jwks      *jwksCache
+	parser    *jwt.Parser
+}
+
+// newJWTVerifier builds a verifier from the JWT_* settings. Exactly one of
+// jwksURL and publicKeyPEM should be set.
+func newJWTVerifier(hc httpClientConfig, jwksURL, publicKeyPEM, issuer, audience string) (*jwtVerifier, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(jwtSigningMethods), jwt.WithLeeway(jwtLeeway)}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+	v := &jwtVerifier{parser: jwt.NewParser(opts...)}
+
+	if publicKeyPEM != "" {
+		key, err := parsePublicKeyPEM(publicKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		v.staticKey = key
+		return v, nil
+	}
+	v.jwks = newJWKSCache(jwksURL, newHTTPClient(hc, 10*time.Second))
+	return v, nil
+}
+
+// Verify parses and validates token, returning its claims.
+func (v *jwtVerifier) Verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		if v.staticKey != nil {
+			return v.staticKey, nil
+		}
+		kid, _ := t.Header["kid"].(string)
+		return v.jwks.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// parsePublicKeyPEM accepts a PKIX or PKCS#1 public key, or a certificate.
+func parsePublicKeyPEM(data string) (any, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block found in public key")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate: %w", err)
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+type subjectKey struct{}
+
+// subjectFrom returns the authenticated JWT subject on ctx, or "".
+func subjectFrom(ctx context.Context) string {
+	sub, _ := ctx.Value(subjectKey{}).(string)
+	return sub
+}
+
+// requireJWT rejects requests without a valid bearer token with 401. The
+// WWW-Authenticate header follows RFC 6750 so clients can tell an expired
+// token from a malformed one. The token's subject is put on the request
+// context and recorded for the access log.
+func requireJWT(next http.HandlerFunc, verifier *jwtVerifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Bearer token required", "")
+			return
+		}
+
+		claims, err := verifier.Verify(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			description := "token is invalid"
+			switch {
+			case errors.Is(err, jwt.ErrTokenExpired):
+				description = "token is expired"
+			case errors.Is(err, jwt.ErrTokenNotValidYet):
+				description = "token is not valid yet"
+			case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+				description = "token issuer is not accepted"
+			case errors.Is(err, jwt.ErrTokenInvalidAudience):
+				description = "token audience is not accepted"
+			case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+				description = "token signature is invalid"
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="api", error="invalid_token", error_description=%q`, description))
+			writeError(w, r, http.StatusUnauthorized, codeInvalidToken, "Invalid bearer token", description)
+			return
+		}
+
+		sub, _ := claims.GetSubject()
+		setLogSubject(r.Context(), sub)
+		next(w, r.WithContext(context.WithValue(r.Context(), subjectKey{}, sub)))
+	}
+}
+
+// jwksTTL is how long fetched keys are used before the next lookup refetches
+// them; jwksMinRefresh rate-limits refetches triggered by unknown kids so
+// bogus tokens can't hammer the JWKS endpoint.
+const (
+	jwksTTL        = 15 * time.Minute
+	jwksMinRefresh = 30 * time.Second
+)
+
+// jwksCache holds the keys published at a JWKS URL, indexed by kid.
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string, client *http.Client) *jwksCache {
+	return &jwksCache{url: url, client: client}
+}
+
+// Key returns the key for kid, refetching the set when it is stale or kid is
+// unknown. An empty kid matches the only key in a single-key set.
+func (c *jwksCache) Key(ctx context.Context, kid string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stale := time.Since(c.fetchedAt) > jwksTTL
+	key, found := c.lookupLocked(kid)
+	if stale || (!found && time.Since(c.fetchedAt) > jwksMinRefresh) {
+		if err := c.refreshLocked(ctx); err != nil {
+			if found {
+				// Serve the cached key rather than fail every request while
+				// the JWKS endpoint is down.
+				return key, nil
+			}
+			return nil, err
+		}
+		key, found = c.lookupLocked(kid)
+	}
+	if !found {
+		return nil, fmt.Errorf("no JWKS key with kid %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) lookupLocked(kid string) (any, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// Refresh refetches the key set ahead of expiry, keeping the current keys
+// if the fetch fails.
+func (c *jwksCache) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshLocked(ctx)
+}
+
+func (c *jwksCache) refreshLocked(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch JWKS: unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decode JWKS: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we can't use rather than rejecting the whole set.
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+// jsonWebKey is the subset of RFC 7517 needed for RSA, EC, and Ed25519
+// public keys.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key length")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestErrorCatalogComplete fails when an error code lacks a message in a
+// supported language, or the catalog keeps a code that no longer exists.
+func TestErrorCatalogComplete(t *testing.T) {
+	if errorCatalogErr != nil {
+		t.Fatalf("i18n/errors.json: %v", errorCatalogErr)
+	}
+	for _, c := range errorCodes {
+		code := c.(string)
+		for _, lang := range supportedLangs() {
+			if errorMessages[code][lang] == "" {
+				t.Errorf("no %s message for %s", lang, code)
+			}
+		}
+	}
+}
+
+func TestLoadErrorCatalogIsLenient(t *testing.T) {
+	catalog, err := loadErrorCatalog([]byte(`{"not_found": {"en": "Not found", "fr": "Introuvable"}}`))
+	if err == nil || !strings.Contains(err.Error(), "no messages for invalid_json") {
+		t.Errorf("loadErrorCatalog error = %v, want it to name the missing codes", err)
+	}
+	if catalog["not_found"]["fr"] != "Introuvable" {
+		t.Errorf("loadErrorCatalog dropped the messages it has: %v", catalog)
+	}
+	if catalog, err := loadErrorCatalog([]byte(`{`)); err == nil || catalog == nil {
+		t.Errorf("loadErrorCatalog of invalid JSON = %v, %v; want an empty catalog and an error", catalog, err)
+	}
+}
+
+func TestLocalizeError(t *testing.T) {
+	saved := errorMessages
+	t.Cleanup(func() { errorMessages = saved })
+	errorMessages = map[string]map[string]string{
+		codeNotFound:    {"en": "Not found", "fr": "Introuvable"},
+		codeRateLimited: {"en": "Rate limited"},
+	}
+	tests := []struct {
+		target, acceptLanguage, code, msg, want string
+	}{
+		{"/", "", codeNotFound, "Item not found", "Item not found"},
+		{"/", "fr-CA,fr;q=0.9", codeNotFound, "Item not found", "Introuvable"},
+		{"/?lang=fr", "de", codeNotFound, "Item not found", "Introuvable"},
+		// Missing translations fall back to English.
+		{"/?lang=fr", "", codeRateLimited, "Too many requests", "Too many requests"},
+		{"/?lang=fr", "", codeRateLimited, "", "Rate limited"},
+		{"/?lang=xx", "", codeNotFound, "Item not found", "Item not found"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if got := localizeError(r, tt.code, tt.msg); got != tt.want {
+			t.Errorf("localizeError(%s, Accept-Language %q, %s) = %q, want %q", tt.target, tt.acceptLanguage, tt.code, got, tt.want)
+		}
+	}
+}
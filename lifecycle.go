@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// shutdownRequest asks run to shut down as it does on SIGTERM, then exit
+// with exitCode.
+type shutdownRequest struct {
+	reason   string
+	exitCode int
+}
+
+// ShutdownRequest is the optional body of POST /admin/shutdown.
+type ShutdownRequest struct {
+	// Delay postpones the shutdown, e.g. "5s".
+	Delay string `json:"delay"`
+	// ExitCode is the process exit status once drained; non-zero simulates
+	// a crash loop.
+	ExitCode int `json:"exit_code"`
+}
+
+// ShutdownResponse acknowledges POST /admin/shutdown.
+type ShutdownResponse struct {
+	Message  string `json:"message"`
+	Delay    string `json:"delay"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// panicDelay gives the acknowledgement of POST /admin/panic time to reach
+// the client before the process dies.
+const panicDelay = 100 * time.Millisecond
+
+// parseShutdownRequest validates req, returning the delay.
+func parseShutdownRequest(req ShutdownRequest) (time.Duration, error) {
+	var delay time.Duration
+	if req.Delay != "" {
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("delay %q must be a non-negative duration such as 5s", req.Delay)
+		}
+		delay = d
+	}
+	if req.ExitCode < 0 || req.ExitCode > 125 {
+		return 0, errors.New("exit_code must be between 0 and 125")
+	}
+	return delay, nil
+}
+
+// adminShutdownHandler serves POST /admin/shutdown. It answers, flushes, and
+// only then starts the delay, so the caller always gets a response.
+func (s *Server) adminShutdownHandler(w http.ResponseWriter, r *http.Request) {
+	var req ShutdownRequest
+	// The body is optional; a bare POST shuts down at once with status 0.
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+	}
+	delay, err := parseShutdownRequest(req)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "Invalid shutdown request", err.Error())
+		return
+	}
+	if !s.shutdownRequested.CompareAndSwap(false, true) {
+		writeError(w, r, http.StatusConflict, codeShutdownPending, "Shutdown already requested", "")
+		return
+	}
+
+	loggerFrom(r.Context()).Warn("shutdown requested", slog.Duration("delay", delay), slog.Int("exit_code", req.ExitCode))
+	writeResponse(w, r, http.StatusAccepted, ShutdownResponse{
+		Message:  "Shutting down",
+		Delay:    delay.String(),
+		ExitCode: req.ExitCode,
+	})
+	http.NewResponseController(w).Flush()
+
+	time.AfterFunc(delay, func() {
+		s.shutdowns <- shutdownRequest{reason: "POST /admin/shutdown", exitCode: req.ExitCode}
+	})
+}
+
+// adminPanicHandler serves POST /admin/panic. It answers, then panics in a
+// goroutine of its own, where nothing recovers, so the process crashes the
+// way an unhandled bug would.
+func (s *Server) adminPanicHandler(w http.ResponseWriter, r *http.Request) {
+	loggerFrom(r.Context()).Warn("crash requested")
+	writeResponse(w, r, http.StatusAccepted, MessageResponse{
+		Message:   "Panicking",
+		Timestamp: s.clock.Now().UTC(),
+	})
+	http.NewResponseController(w).Flush()
+
+	go func() {
+		time.Sleep(panicDelay)
+		panic("deliberate panic from POST /admin/panic")
+	}()
+}
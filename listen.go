@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"time"
+)
+
+// listenAddr is the address the main listener binds.
+func (cfg Config) listenAddr() string {
+	if cfg.ListenAddr == "" && cfg.ListenNetwork == "tcp" {
+		return ":" + cfg.Port
+	}
+	return cfg.ListenAddr
+}
+
+// listen opens the main listener. A Unix socket gets cfg.SocketMode and is
+// removed again when the listener is closed.
+func listen(cfg Config) (net.Listener, error) {
+	addr := cfg.listenAddr()
+	if cfg.ListenNetwork != "unix" {
+		return net.Listen(cfg.ListenNetwork, addr)
+	}
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, cfg.SocketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting socket mode: %w", err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a server that
+// didn't shut down cleanly. It refuses to touch anything else at path,
+// including a socket another process is still serving.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return os.Remove(path)
+}
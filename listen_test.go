@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// socketDir returns a short temporary directory; t.TempDir paths can exceed
+// the limit on Unix socket path length.
+func socketDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(socketDir(t), "app.sock")
+	// Leave a stale socket behind, as a crashed server would.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := defaultConfig()
+	cfg.ListenNetwork = "unix"
+	cfg.ListenAddr = path
+	cfg.SocketMode = 0o600
+	ln, err := listen(cfg)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	_, h := newTestServer(t)
+	server := &http.Server{Handler: h}
+	go server.Serve(ln)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz over the socket = %d, want 200", resp.StatusCode)
+	}
+
+	server.Close()
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file after shutdown: %v, want it removed", err)
+	}
+}
+
+func TestRemoveStaleSocketRefuses(t *testing.T) {
+	dir := socketDir(t)
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0o600)
+	if err := removeStaleSocket(file); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("removeStaleSocket(regular file) = %v, want a refusal", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+
+	live := filepath.Join(dir, "live.sock")
+	ln, err := net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := removeStaleSocket(live); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("removeStaleSocket(live socket) = %v, want a refusal", err)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Port = "9090"
+	if got := cfg.listenAddr(); got != ":9090" {
+		t.Errorf("default listenAddr = %q, want :9090", got)
+	}
+	cfg.ListenAddr = "127.0.0.1:7000"
+	if got := cfg.listenAddr(); got != "127.0.0.1:7000" {
+		t.Errorf("listenAddr with LISTEN_ADDR = %q", got)
+	}
+}
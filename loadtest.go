@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadTestConfig bounds POST /admin/loadtest. TargetURL is where the load
+// goes; empty means this server's own listener.
+type loadTestConfig struct {
+	TargetURL      string
+	MaxRPS         int
+	MaxDuration    time.Duration
+	MaxConcurrency int
+}
+
+// loadTestRequestTimeout bounds each generated request.
+const loadTestRequestTimeout = 10 * time.Second
+
+// loadTestHistory is how many finished runs GET /admin/loadtest/{id} can
+// still report.
+const loadTestHistory = 10
+
+// Load test states.
+const (
+	loadTestRunning   = "running"
+	loadTestCompleted = "completed"
+	loadTestCancelled = "cancelled"
+)
+
+var errLoadTestRunning = errors.New("a load test is already running")
+
+// LoadTestRequest is the body of POST /admin/loadtest.
+type LoadTestRequest struct {
+	// Target is a path on the target, such as /api, with an optional query.
+	Target      string `json:"target"`
+	RPS         int    `json:"rps"`
+	Duration    string `json:"duration"`
+	Concurrency int    `json:"concurrency"`
+
+	limits   loadTestConfig
+	duration time.Duration
+}
+
+// Validate checks the request against the configured caps.
+func (req *LoadTestRequest) Validate() []FieldError {
+	var problems []FieldError
+	target, err := url.Parse(req.Target)
+	switch {
+	case req.Target == "":
+		problems = append(problems, FieldError{Field: "target", Constraint: "required", Message: "is required"})
+	case err != nil || !strings.HasPrefix(req.Target, "/") || strings.HasPrefix(req.Target, "//") || target.Host != "":
+		problems = append(problems, FieldError{Field: "target", Constraint: "format", Message: "must be a path such as /api", Value: req.Target})
+	}
+	if req.RPS < 1 || req.RPS > req.limits.MaxRPS {
+		problems = append(problems, FieldError{Field: "rps", Constraint: "range", Message: fmt.Sprintf("must be between 1 and %d", req.limits.MaxRPS), Value: req.RPS})
+	}
+	d, err := time.ParseDuration(req.Duration)
+	switch {
+	case req.Duration == "":
+		problems = append(problems, FieldError{Field: "duration", Constraint: "required", Message: "is required"})
+	case err != nil || d <= 0 || d > req.limits.MaxDuration:
+		problems = append(problems, FieldError{Field: "duration", Constraint: "format", Message: fmt.Sprintf("must be a duration such as 60s, up to %s", req.limits.MaxDuration), Value: req.Duration})
+	}
+	req.duration = d
+	if req.Concurrency == 0 {
+		req.Concurrency = min(10, req.limits.MaxConcurrency)
+	}
+	if req.Concurrency < 1 || req.Concurrency > req.limits.MaxConcurrency {
+		problems = append(problems, FieldError{Field: "concurrency", Constraint: "range", Message: fmt.Sprintf("must be between 1 and %d", req.limits.MaxConcurrency), Value: req.Concurrency})
+	}
+	return problems
+}
+
+// LoadTestLatency is a run's latency distribution.
+type LoadTestLatency struct {
+	P50MS float64 `json:"p50_ms"`
+	P90MS float64 `json:"p90_ms"`
+	P99MS float64 `json:"p99_ms"`
+	MaxMS float64 `json:"max_ms"`
+}
+
+// LoadTestStatus reports a run for GET /admin/loadtest/{id}.
+type LoadTestStatus struct {
+	ID          string     `json:"id"`
+	State       string     `json:"state"`
+	URL         string     `json:"url"`
+	RPS         int        `json:"rps"`
+	Concurrency int        `json:"concurrency"`
+	Duration    string     `json:"duration"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ElapsedMS   float64    `json:"elapsed_ms"`
+	Requests    int        `json:"requests"`
+	// Skipped counts ticks with every worker busy, when the target is too
+	// slow for the requested rate at this concurrency.
+	Skipped     int             `json:"skipped"`
+	AchievedRPS float64         `json:"achieved_rps"`
+	Latency     LoadTestLatency `json:"latency"`
+	// Statuses counts responses by status code, and transport failures as
+	// "error".
+	Statuses map[string]int `json:"statuses"`
+}
+
+// loadTestRun is one run's live state.
+type loadTestRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.Mutex
+	status    LoadTestStatus
+	latencies []time.Duration
+}
+
+func (run *loadTestRun) observe(status string, d time.Duration) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.status.Requests++
+	run.status.Statuses[status]++
+	run.latencies = append(run.latencies, d)
+}
+
+// Status summarizes the run so far.
+func (run *loadTestRun) Status() LoadTestStatus {
+	run.mu.Lock()
+	status := run.status
+	status.Statuses = make(map[string]int, len(run.status.Statuses))
+	for code, n := range run.status.Statuses {
+		status.Statuses[code] = n
+	}
+	latencies := slices.Clone(run.latencies)
+	run.mu.Unlock()
+
+	end := time.Now()
+	if status.FinishedAt != nil {
+		end = *status.FinishedAt
+	}
+	elapsed := end.Sub(status.StartedAt)
+	status.ElapsedMS = durationMS(elapsed)
+	if elapsed > 0 {
+		status.AchievedRPS = float64(int(float64(status.Requests)/elapsed.Seconds()*10)) / 10
+	}
+	slices.Sort(latencies)
+	status.Latency = LoadTestLatency{
+		P50MS: durationMS(quantile(latencies, 0.50)),
+		P90MS: durationMS(quantile(latencies, 0.90)),
+		P99MS: durationMS(quantile(latencies, 0.99)),
+	}
+	if len(latencies) > 0 {
+		status.Latency.MaxMS = durationMS(latencies[len(latencies)-1])
+	}
+	return status
+}
+
+// loadTester runs POST /admin/loadtest, one run at a time, so an HPA demo
+// needs no separate load tool. The load goes through a real client and
+// listener, so it costs what outside traffic would.
+type loadTester struct {
+	baseURL string
+	client  *http.Client
+	logger  *slog.Logger
+	// ctx is cancelled by Shutdown, stopping any run.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	current *loadTestRun
+	runs    []*loadTestRun
+	closed  bool
+}
+
+// newLoadTester targets cfg.LoadTest.TargetURL, or this server's own
+// listener on the loopback interface.
+func newLoadTester(cfg Config, logger *slog.Logger) *loadTester {
+	// Every generated request is sent once, so the run measures what the
+	// target does rather than what retries hide, and each worker keeps its
+	// own connection.
+	hc := cfg.HTTPClient
+	hc.MaxRetries = 0
+	hc.MaxIdleConnsPerHost, hc.MaxConnsPerHost = cfg.LoadTest.MaxConcurrency, 0
+	var insecure bool
+	var socket string
+	baseURL := cfg.LoadTest.TargetURL
+	if baseURL == "" {
+		scheme := "http"
+		if cfg.TLSCertFile != "" {
+			// The certificate is for the Service's name, not the loopback
+			// address, and it is our own.
+			scheme = "https"
+			insecure = true
+		}
+		host, port := "127.0.0.1", cfg.Port
+		if cfg.ListenNetwork == "unix" {
+			socket = cfg.ListenAddr
+		} else if h, p, err := net.SplitHostPort(cfg.listenAddr()); err == nil {
+			port = p
+			if ip := net.ParseIP(h); ip != nil && !ip.IsUnspecified() {
+				host = h
+			}
+		}
+		baseURL = scheme + "://" + net.JoinHostPort(host, port)
+	}
+	transport := newOutboundTransport(hc, func(t *http.Transport) {
+		if insecure {
+			t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		if socket != "" {
+			t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			}
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	return &loadTester{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Transport: transport, Timeout: loadTestRequestTimeout},
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start begins a run. header is copied onto every request, carrying the
+// caller's credentials to authenticated targets.
+func (lt *loadTester) Start(req LoadTestRequest, header http.Header) (*loadTestRun, error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.closed {
+		return nil, errors.New("server is shutting down")
+	}
+	if lt.current != nil {
+		return lt.current, errLoadTestRunning
+	}
+	ctx, cancel := context.WithTimeout(lt.ctx, req.duration)
+	run := &loadTestRun{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: LoadTestStatus{
+			ID:          newUUID(),
+			State:       loadTestRunning,
+			URL:         lt.baseURL + req.Target,
+			RPS:         req.RPS,
+			Concurrency: req.Concurrency,
+			Duration:    req.duration.String(),
+			StartedAt:   time.Now().UTC(),
+			Statuses:    make(map[string]int),
+		},
+	}
+	lt.current = run
+	lt.runs = append(lt.runs, run)
+	if len(lt.runs) > loadTestHistory {
+		lt.runs = slices.Delete(lt.runs, 0, len(lt.runs)-loadTestHistory)
+	}
+	go lt.run(ctx, run, header)
+	return run, nil
+}
+
+// run paces requests at the run's rate and hands them to its workers. A
+// tick finding every worker busy is skipped rather than queued, so a slow
+// target sees the configured concurrency at most, not a growing backlog.
+func (lt *loadTester) run(ctx context.Context, run *loadTestRun, header http.Header) {
+	defer close(run.done)
+	status := run.Status()
+	lt.logger.Info("load test started", slog.String("id", status.ID), slog.String("url", status.URL),
+		slog.Int("rps", status.RPS), slog.Int("concurrency", status.Concurrency), slog.String("duration", status.Duration))
+
+	ticks := make(chan struct{})
+	var wg sync.WaitGroup
+	for range status.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range ticks {
+				lt.send(ctx, run, status.URL, header)
+			}
+		}()
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(status.RPS))
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			select {
+			case ticks <- struct{}{}:
+			default:
+				run.mu.Lock()
+				run.status.Skipped++
+				run.mu.Unlock()
+			}
+		}
+	}
+	ticker.Stop()
+	close(ticks)
+	wg.Wait()
+
+	state := loadTestCompleted
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		state = loadTestCancelled
+	}
+	finished := time.Now().UTC()
+	run.mu.Lock()
+	run.status.State = state
+	run.status.FinishedAt = &finished
+	run.mu.Unlock()
+	lt.mu.Lock()
+	if lt.current == run {
+		lt.current = nil
+	}
+	lt.mu.Unlock()
+	status = run.Status()
+	lt.logger.Info("load test finished", slog.String("id", status.ID), slog.String("state", state),
+		slog.Int("requests", status.Requests), slog.Float64("achieved_rps", status.AchievedRPS), slog.Float64("p99_ms", status.Latency.P99MS))
+}
+
+// send makes one request and records it. Requests cut off by the end of the
+// run aren't counted.
+func (lt *loadTester) send(ctx context.Context, run *loadTestRun, target string, header http.Header) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return
+	}
+	req.Header = header.Clone()
+	start := time.Now()
+	resp, err := lt.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			run.observe("error", time.Since(start))
+		}
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	run.observe(strconv.Itoa(resp.StatusCode), time.Since(start))
+}
+
+// Get returns the run with id, if it is recent enough to be kept.
+func (lt *loadTester) Get(id string) (*loadTestRun, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, run := range lt.runs {
+		if run.status.ID == id {
+			return run, true
+		}
+	}
+	return nil, false
+}
+
+// Cancel stops the run with id and waits for its workers to return.
+// Cancelling a finished run is a no-op.
+func (lt *loadTester) Cancel(id string) (*loadTestRun, bool) {
+	run, ok := lt.Get(id)
+	if !ok {
+		return nil, false
+	}
+	run.cancel()
+	<-run.done
+	return run, true
+}
+
+// Shutdown stops any run and refuses new ones. It is called as soon as
+// shutdown begins, so the generator doesn't keep its own listener from
+// draining.
+func (lt *loadTester) Shutdown(ctx context.Context) error {
+	lt.mu.Lock()
+	lt.closed = true
+	run := lt.current
+	lt.mu.Unlock()
+	lt.cancel()
+	if run == nil {
+		return nil
+	}
+	select {
+	case <-run.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadTestHeaders are the request headers a run copies from the POST that
+// started it.
+var loadTestHeaders = []string{apiKeyHeader, "Authorization"}
+
+// startLoadTestHandler handles POST /admin/loadtest.
+func (s *Server) startLoadTestHandler(w http.ResponseWriter, r *http.Request) {
+	req := LoadTestRequest{limits: s.cfg.LoadTest}
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	header := make(http.Header)
+	header.Set("User-Agent", "my-go-app-loadtest")
+	for _, name := range loadTestHeaders {
+		if v := r.Header.Get(name); v != "" {
+			header.Set(name, v)
+		}
+	}
+	run, err := s.loadTests.Start(req, header)
+	if errors.Is(err, errLoadTestRunning) {
+		writeError(w, r, http.StatusConflict, codeLoadTestRunning, "Load test already running", "cancel run "+run.Status().ID+" first")
+		return
+	}
+	if err != nil {
+		writeRetryableError(w, r, http.StatusServiceUnavailable, retryAfterDefault, codeUnavailable, "Cannot start load test", err.Error())
+		return
+	}
+	status := run.Status()
+	w.Header().Set("Location", "/admin/loadtest/"+status.ID)
+	writeResponse(w, r, http.StatusAccepted, status)
+}
+
+// getLoadTestHandler handles GET /admin/loadtest/{id}.
+func (s *Server) getLoadTestHandler(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.loadTests.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Load test not found", "")
+		return
+	}
+	writeResponse(w, r, http.StatusOK, run.Status())
+}
+
+// cancelLoadTestHandler handles DELETE /admin/loadtest/{id}, answering with
+// the final stats.
+func (s *Server) cancelLoadTestHandler(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.loadTests.Cancel(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Load test not found", "")
+		return
+	}
+	writeResponse(w, r, http.StatusOK, run.Status())
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// logRotation configures rotatingFile. MaxSize of zero never rotates;
+// MaxAge of zero keeps backups until MaxBackups pushes them out.
+type logRotation struct {
+	MaxSize    int64
+	MaxBackups int
+	MaxAge     time.Duration
+}
+
+// logFileRetry is how often a broken log file is reopened.
+const logFileRetry = time.Second
+
+// rotatingFile is an io.Writer appending to a log file, renaming it to
+// path.1 (and older backups to path.2, ...) once a write would take it past
+// MaxSize. Logs always go to stdout as well, so Write never fails: a file
+// that can't be written is reported once through warn and retried at most
+// every logFileRetry, and request handling carries on.
+type rotatingFile struct {
+	path     string
+	rotation logRotation
+	warn     func(msg string, err error)
+
+	mu        sync.Mutex
+	f         *os.File
+	size      int64
+	broken    bool
+	lastRetry time.Time
+}
+
+// openRotatingFile opens path for appending, failing if it can't be.
+func openRotatingFile(path string, rotation logRotation, warn func(string, error)) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, rotation: rotation, warn: warn}
+	if err := rf.openLocked(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) openLocked() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		if time.Since(rf.lastRetry) < logFileRetry {
+			return len(p), nil
+		}
+		rf.lastRetry = time.Now()
+		if err := rf.openLocked(); err != nil {
+			rf.failLocked("cannot open log file", err)
+			return len(p), nil
+		}
+	}
+	if rf.rotation.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.rotation.MaxSize {
+		if err := rf.rotateLocked(); err != nil {
+			rf.failLocked("cannot rotate log file", err)
+			return len(p), nil
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	if err != nil {
+		rf.f.Close()
+		rf.f = nil
+		rf.failLocked("cannot write log file", err)
+		return len(p), nil
+	}
+	if rf.broken {
+		rf.broken = false
+		rf.warn("log file writable again", nil)
+	}
+	return len(p), nil
+}
+
+// failLocked reports the first failure of a streak.
+func (rf *rotatingFile) failLocked(msg string, err error) {
+	if !rf.broken {
+		rf.broken = true
+		rf.warn(msg+", logging to stdout only", err)
+	}
+}
+
+// backup is the name of the nth most recent rotated file.
+func (rf *rotatingFile) backup(n int) string {
+	return rf.path + "." + strconv.Itoa(n)
+}
+
+// rotateLocked shifts the backups up by one, moves the current file to
+// path.1, drops backups past MaxBackups or older than MaxAge, and starts a
+// new file.
+func (rf *rotatingFile) rotateLocked() error {
+	rf.f.Close()
+	rf.f = nil
+	if rf.rotation.MaxBackups > 0 {
+		for n := rf.rotation.MaxBackups - 1; n >= 1; n-- {
+			if err := os.Rename(rf.backup(n), rf.backup(n+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(rf.path, rf.backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if rf.rotation.MaxAge > 0 {
+		cutoff := time.Now().Add(-rf.rotation.MaxAge)
+		for n := 1; n <= rf.rotation.MaxBackups; n++ {
+			if info, err := os.Stat(rf.backup(n)); err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(rf.backup(n))
+			}
+		}
+	}
+	return rf.openLocked()
+}
+
+// Reopen closes and reopens the file, for external rotation tools that
+// move it aside and signal the process (SIGUSR1).
+func (rf *rotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f != nil {
+		rf.f.Close()
+		rf.f = nil
+	}
+	if err := rf.openLocked(); err != nil {
+		rf.failLocked("cannot reopen log file", err)
+		return fmt.Errorf("reopen %s: %w", rf.path, err)
+	}
+	rf.broken = false
+	return nil
+}
+
+// Close closes the file.
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
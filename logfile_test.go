@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func openTestLog(t *testing.T, rotation logRotation) (*rotatingFile, string, *[]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "access.log")
+	var warnings []string
+	rf, err := openRotatingFile(path, rotation, func(msg string, err error) { warnings = append(warnings, msg) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rf.Close() })
+	return rf, path, &warnings
+}
+
+func writeLog(t *testing.T, rf *rotatingFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if n, err := rf.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", line, n, err)
+		}
+	}
+}
+
+func TestRotatingFileSizeBoundary(t *testing.T) {
+	rf, path, _ := openTestLog(t, logRotation{MaxSize: 10, MaxBackups: 2})
+
+	// A write that exactly fills the file doesn't rotate it.
+	writeLog(t, rf, "12345", "67890")
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("rotated at exactly MaxSize: %v", err)
+	}
+	// The next byte would pass it, so the file rotates first.
+	writeLog(t, rf, "a")
+	if got := readFile(t, path+".1"); got != "1234567890" {
+		t.Errorf("%s.1 = %q, want the full file", path, got)
+	}
+	if got := readFile(t, path); got != "a" {
+		t.Errorf("%s = %q, want a fresh file", path, got)
+	}
+
+	// A line bigger than MaxSize goes whole into an empty file.
+	writeLog(t, rf, "0123456789abcdef", "b", "c")
+	if got := readFile(t, path+".1"); got != "0123456789abcdef" {
+		t.Errorf("%s.1 = %q, want the oversized line alone", path, got)
+	}
+	if got := readFile(t, path+".2"); got != "a" {
+		t.Errorf("%s.2 = %q, want the older backup shifted up", path, got)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept a third backup past MaxBackups: %v", err)
+	}
+	if got := readFile(t, path); got != "bc" {
+		t.Errorf("%s = %q, want the lines since", path, got)
+	}
+}
+
+func TestRotatingFileRetention(t *testing.T) {
+	t.Run("no backups", func(t *testing.T) {
+		rf, path, _ := openTestLog(t, logRotation{MaxSize: 4})
+		writeLog(t, rf, "1234", "5")
+		if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+			t.Errorf("kept a backup with MaxBackups 0: %v", err)
+		}
+		if got := readFile(t, path); got != "5" {
+			t.Errorf("%s = %q, want only the newest line", path, got)
+		}
+	})
+	t.Run("max age", func(t *testing.T) {
+		rf, path, _ := openTestLog(t, logRotation{MaxSize: 4, MaxBackups: 3, MaxAge: time.Hour})
+		writeLog(t, rf, "1234", "5678")
+		old := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(path+".1", old, old); err != nil {
+			t.Fatal(err)
+		}
+		writeLog(t, rf, "9")
+		if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+			t.Errorf("kept a backup older than MaxAge: %v", err)
+		}
+		if got := readFile(t, path+".1"); got != "5678" {
+			t.Errorf("%s.1 = %q, want the recent backup", path, got)
+		}
+	})
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	rf, path, _ := openTestLog(t, logRotation{})
+	writeLog(t, rf, "before\n")
+	// An external tool moves the file aside, then signals.
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	writeLog(t, rf, "still old\n")
+	if err := rf.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	writeLog(t, rf, "after\n")
+	if got := readFile(t, path+".moved"); got != "before\nstill old\n" {
+		t.Errorf("moved file = %q", got)
+	}
+	if got := readFile(t, path); got != "after\n" {
+		t.Errorf("reopened file = %q, want only later lines", got)
+	}
+}
+
+func TestRotatingFileFailuresDontFailWrites(t *testing.T) {
+	rf, path, warnings := openTestLog(t, logRotation{MaxSize: 4, MaxBackups: 1})
+	writeLog(t, rf, "1234")
+	if err := os.RemoveAll(filepath.Dir(path)); err != nil {
+		t.Fatal(err)
+	}
+	// Rotation fails with the directory gone; the writes still succeed.
+	writeLog(t, rf, "lost", "lost", "lost")
+	if len(*warnings) != 1 {
+		t.Fatalf("warnings = %q, want one for the whole streak", *warnings)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rf.mu.Lock()
+	rf.lastRetry = time.Time{}
+	rf.mu.Unlock()
+	writeLog(t, rf, "back")
+	if got := readFile(t, path); got != "back" {
+		t.Errorf("%s = %q after recovering, want the new line", path, got)
+	}
+	if len(*warnings) != 2 || (*warnings)[1] != "log file writable again" {
+		t.Errorf("warnings = %q, want the recovery reported", *warnings)
+	}
+}
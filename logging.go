@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// newLogger returns the process logger. format is json, text, or dev,
+// validated by Config; a *slog.LevelVar level lets the level change at
+// runtime. The dev format is colored only when out is a terminal.
+func newLogger(out io.Writer, level slog.Leveler, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(out, opts))
+	case "dev":
+		return slog.New(newDevHandler(out, level, colorEnabled(out)))
+	}
+	return slog.New(slog.NewJSONHandler(out, opts))
+}
+
+// parseLogLevel maps a LOG_LEVEL of debug, info, warn, or error to its
+// slog level.
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+type loggerKey struct{}
+
+// contextWithLogger returns ctx carrying logger for loggerFrom.
+func contextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the request-scoped logger installed by logRequests,
+// which already carries the request ID and path. Outside a request it falls
+// back to slog.Default.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"time"
+)
+
+// logSampling decides which requests logRequests writes access log lines
+// for. Client and server errors and slow requests are always logged; of the
+// rest, one in Rate is. Paths in Exclude are never logged. Metrics count
+// every request regardless.
+type logSampling struct {
+	// Rate logs one in Rate successful requests; 1 logs every one.
+	Rate int
+	// SlowThreshold logs any request taking at least this long. Zero
+	// disables it.
+	SlowThreshold time.Duration
+	Exclude       map[string]bool
+}
+
+func newLogSampling(cfg Config) logSampling {
+	exclude := make(map[string]bool, len(cfg.LogExcludePaths))
+	for _, path := range cfg.LogExcludePaths {
+		exclude[path] = true
+	}
+	if cfg.LogSkipHealth {
+		for path := range probePaths {
+			exclude[path] = true
+		}
+	}
+	return logSampling{Rate: cfg.LogSampleRate, SlowThreshold: cfg.LogSlowThreshold, Exclude: exclude}
+}
+
+// sampledIn reports whether requestID falls in the logged one in Rate. It
+// hashes the ID, so every replica and log line makes the same choice for a
+// request whose ID is passed along.
+func (s logSampling) sampledIn(requestID string) bool {
+	if s.Rate <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(requestID))
+	return h.Sum32()%uint32(s.Rate) == 0
+}
+
+// keep reports whether a finished request gets an access log line.
+func (s logSampling) keep(sampledIn bool, status int, elapsed time.Duration) bool {
+	return sampledIn || status < 200 || status >= 300 ||
+		(s.SlowThreshold > 0 && elapsed >= s.SlowThreshold)
+}
+
+// sampledHandler drops the info and debug lines handlers log for a request
+// that was sampled out, so a successful request shows up in all its log
+// lines or none. Warnings and errors always get through.
+type sampledHandler struct {
+	slog.Handler
+}
+
+func (h sampledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn && h.Handler.Enabled(ctx, level)
+}
+
+func (h sampledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return sampledHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h sampledHandler) WithGroup(name string) slog.Handler {
+	return sampledHandler{h.Handler.WithGroup(name)}
+}
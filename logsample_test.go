@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// sampledRequest runs a request with the given ID through logRequests.
+func sampledRequest(t *testing.T, h http.Handler, id, target string) {
+	t.Helper()
+	r := newRequest(t, "GET", target, "")
+	serve(h, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+}
+
+func TestLogSamplingKeepsErrorsAndSlowRequests(t *testing.T) {
+	var logs bytes.Buffer
+	sampling := logSampling{Rate: 1 << 30, SlowThreshold: 20 * time.Millisecond}
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("slow") {
+			time.Sleep(30 * time.Millisecond)
+		}
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}), slog.New(slog.NewJSONHandler(&logs, nil)), sampling)
+
+	var ids []string
+	for i := 0; len(ids) < 50; i++ {
+		if id := fmt.Sprintf("req-%d", i); !sampling.sampledIn(id) {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		for _, status := range []int{http.StatusOK, http.StatusNoContent, http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+			sampledRequest(t, h, id, fmt.Sprintf("/x?status=%d", status))
+		}
+	}
+	sampledRequest(t, h, ids[0], "/x?status=200&slow")
+
+	counts := map[float64]int{}
+	for _, line := range logLines(t, &logs, "request") {
+		counts[line["status"].(float64)]++
+	}
+	for _, status := range []float64{400, 404, 429, 500, 503} {
+		if counts[status] != len(ids) {
+			t.Errorf("logged %d of %d %v responses, want all", counts[status], len(ids), status)
+		}
+	}
+	if counts[204] != 0 || counts[200] != 1 {
+		t.Errorf("logged %d 200s and %d 204s, want only the slow 200", counts[200], counts[204])
+	}
+}
+
+func TestLogSamplingIsPerRequest(t *testing.T) {
+	var logs bytes.Buffer
+	sampling := logSampling{Rate: 4}
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loggerFrom(r.Context()).Info("handling")
+		loggerFrom(r.Context()).Warn("worth seeing")
+	}), slog.New(slog.NewJSONHandler(&logs, nil)), sampling)
+
+	const n = 400
+	for i := range n {
+		sampledRequest(t, h, fmt.Sprintf("req-%d", i), "/x")
+	}
+	byID := func(msg string) map[string]int {
+		ids := map[string]int{}
+		for _, line := range logLines(t, &logs, msg) {
+			ids[line["request_id"].(string)]++
+		}
+		return ids
+	}
+	access, handling, warnings := byID("request"), byID("handling"), byID("worth seeing")
+	if len(access) < n/8 || len(access) > n/2 {
+		t.Errorf("logged %d of %d requests, want about one in 4", len(access), n)
+	}
+	for i := range n {
+		id := fmt.Sprintf("req-%d", i)
+		if access[id] != handling[id] || (access[id] == 1) != sampling.sampledIn(id) {
+			t.Errorf("%s: %d access lines and %d handler lines, sampled in %v", id, access[id], handling[id], sampling.sampledIn(id))
+		}
+		if warnings[id] != 1 {
+			t.Errorf("%s: %d warnings logged, want warnings never sampled away", id, warnings[id])
+		}
+	}
+}
+
+func TestLogSamplingExcludesPathsAndStillCounts(t *testing.T) {
+	var logs bytes.Buffer
+	_, h := newLoggedTestServer(t, slog.New(slog.NewJSONHandler(&logs, nil)), func(cfg *Config) {
+		cfg.LogSampleRate = 1 << 30
+		cfg.LogExcludePaths = []string{"/openapi.json"}
+	})
+	served := func() float64 { return testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/version", "GET", "200")) }
+	before := served()
+	for range 20 {
+		serve(h, newRequest(t, "GET", "/version", ""))
+		serve(h, newRequest(t, "GET", "/openapi.json", ""))
+	}
+	serve(h, newRequest(t, "GET", "/nope", ""))
+
+	if got := served() - before; got != 20 {
+		t.Errorf("http_requests_total for /version rose by %v, want 20 counted though sampled out", got)
+	}
+	lines := logLines(t, &logs, "request")
+	for _, line := range lines {
+		if line["path"] != "/nope" {
+			t.Errorf("logged %v, want only the 404", line["path"])
+		}
+	}
+	if len(lines) != 1 {
+		t.Errorf("logged %d requests, want the 404 alone", len(lines))
+	}
+}
@@ -1,89 +1,406 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-type HealthResponse struct {
-	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-	Version   string    `json:"version"`
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
 
-type MessageResponse struct {
-	Message   string    `json:"message"`
-	Timestamp time.Time `json:"timestamp"`
-}
+// run starts the server and blocks until it stops, returning the process
+// exit code. Keeping this out of main lets deferred cleanup run before exit.
+func run(args []string) int {
+	cfg, err := LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		// No configured logger yet; report in the default format.
+		newLogger(os.Stderr, slog.LevelInfo, "json").Error("invalid configuration", slog.Any("error", err))
+		return 2
+	}
+	if cfg.ShowVersion {
+		fmt.Println(cfg.Version)
+		return 0
+	}
+	if cfg.SelfTest {
+		return runSelfTest(cfg, os.Stdout)
+	}
 
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
+	// The level is a LevelVar so a config reload can change it.
+	level := new(slog.LevelVar)
+	level.Set(parseLogLevel(cfg.LogLevel))
+	// Log files are written alongside stdout, and report their own failures
+	// there.
+	stdoutLogger := newLogger(os.Stdout, level, cfg.LogFormat)
+	warnOnStdout := func(msg string, err error) {
+		if err == nil {
+			stdoutLogger.Info(msg)
+			return
+		}
+		stdoutLogger.Warn(msg, slog.Any("error", err))
+	}
+	var logFiles []*rotatingFile
+	openLogFile := func(path string) (*rotatingFile, bool) {
+		if path == "" {
+			return nil, true
+		}
+		rf, err := openRotatingFile(path, cfg.LogRotation, warnOnStdout)
+		if err != nil {
+			stdoutLogger.Error("cannot open log file", slog.String("file", path), slog.Any("error", err))
+			return nil, false
+		}
+		logFiles = append(logFiles, rf)
+		return rf, true
+	}
+	defer func() {
+		for _, rf := range logFiles {
+			rf.Close()
+		}
+	}()
+	logFile, ok := openLogFile(cfg.LogFile)
+	if !ok {
+		return 1
+	}
+	accessLogFile, ok := openLogFile(cfg.AccessLogFile)
+	if !ok {
+		return 1
+	}
+	var logOut io.Writer = os.Stdout
+	if logFile != nil {
+		logOut = io.MultiWriter(os.Stdout, logFile)
+	}
+	logger := newLogger(logOut, level, cfg.LogFormat)
+	slog.SetDefault(logger)
+	if errorCatalogErr != nil {
+		logger.Warn("error message catalog is incomplete, falling back to English", slog.String("file", "i18n/errors.json"), slog.Any("error", errorCatalogErr))
+	}
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	response := HealthResponse{
-		Status:    "healthy",
-		Timestamp: time.Now(),
-		Version:   "1.0.0",
+	shutdownTracing, err := setupTracing(context.Background(), cfg.OTLPEndpoint, cfg.Version)
+	if err != nil {
+		logger.Error("cannot set up tracing", slog.Any("error", err))
+		return 1
 	}
-	json.NewEncoder(w).Encode(response)
-}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warn("flushing traces failed", slog.Any("error", err))
+		}
+	}()
+
+	// background is cancelled on shutdown to stop maintenance goroutines.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
 
-func helloHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	response := MessageResponse{
-		Message:   "Hello from Go + Kubernetes + Skaffold with Air hot reload!",
-		Timestamp: time.Now(),
+	var certs *certReloader
+	if cfg.TLSCertFile != "" {
+		certs, err = newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			logger.Error("invalid TLS configuration", slog.Any("error", err))
+			return 1
+		}
 	}
-	json.NewEncoder(w).Encode(response)
-}
 
-func apiHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	switch r.Method {
-	case "GET":
-		response := MessageResponse{
-			Message:   "API endpoint is working",
-			Timestamp: time.Now(),
-		}
-		json.NewEncoder(w).Encode(response)
-	case "POST":
-		var data map[string]interface{}
-		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid JSON"})
-			return
+	var store Store
+	switch {
+	case cfg.DatabaseURL != "":
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		sqlStore, err := openSQLStore(ctx, cfg.DatabaseURL)
+		cancel()
+		if err != nil {
+			logger.Error("cannot open database", slog.Any("error", err))
+			return 1
 		}
-		response := map[string]interface{}{
-			"received":  data,
-			"timestamp": time.Now(),
+		defer sqlStore.Close()
+		store = sqlStore
+	case cfg.RedisStore:
+		client, err := openRedis(cfg.RedisURL)
+		if err != nil {
+			logger.Error("invalid REDIS_URL", slog.Any("error", err))
+			return 1
 		}
-		json.NewEncoder(w).Encode(response)
+		redisStore := newRedisStore(client, cfg.RedisKeyPrefix)
+		defer redisStore.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = redisStore.Check(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("cannot connect to Redis", slog.Any("error", err))
+			return 1
+		}
+		store = redisStore
+	case cfg.DataFile != "":
+		store = openFileStore(cfg.DataFile, logger)
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed"})
+		store = newMemoryStore()
 	}
-}
 
-func main() {
-	http.HandleFunc("/", helloHandler)
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/api", apiHandler)
+	srv, err := NewServer(cfg, logger, store, nil)
+	if err != nil {
+		logger.Error("cannot start server", slog.Any("error", err))
+		return 1
+	}
+	if accessLogFile != nil {
+		srv.accessLogger = newLogger(io.MultiWriter(logOut, accessLogFile), level, cfg.LogFormat)
+	}
+	reloader := newConfigReloader(cfg, args, level, srv, logger)
+	if cfg.ConfigFile != "" {
+		srv.tasks.Register("watch-config-file", configWatchInterval, reloader.watchFile)
+	}
+	go srv.runMaintenance(background)
+
+	public, admin := srv.Routes()
+	var adminServer *http.Server
+	pprofStatus := "disabled"
+	if admin != nil {
+		adminServer = &http.Server{
+			Addr:              ":" + cfg.AdminPort,
+			Handler:           admin,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+			ConnState:         srv.adminConns.ConnState,
+		}
+		if cfg.EnablePprof {
+			pprofStatus = "enabled on admin port " + cfg.AdminPort
+		}
+	} else if cfg.EnablePprof {
+		pprofStatus = "enabled"
+	}
+	faultStatus := "disabled (configure API_KEYS, JWT auth, or client certificates)"
+	if srv.adminEnabled() {
+		faultStatus = "enabled"
+	}
+
+	server := &http.Server{
+		Addr:              cfg.listenAddr(),
+		Handler:           public,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		ConnState:         srv.conns.ConnState,
+	}
+	server.RegisterOnShutdown(srv.events.Close)
+	scheme := "http"
+	if certs != nil {
+		server.TLSConfig = certs.TLSConfig()
+		scheme = "https"
+	}
+	var h2cSrv *h2cServer
+	if cfg.EnableH2C {
+		if h2cSrv, err = enableH2C(server, cfg.MaxBodyBytes); err != nil {
+			logger.Error("cannot enable h2c", slog.Any("error", err))
+			return 1
+		}
+	}
+
+	var grpcSrv *grpcServer
+	var grpcLn net.Listener
+	if cfg.GRPCPort != "" {
+		if grpcLn, err = net.Listen("tcp", ":"+cfg.GRPCPort); err != nil {
+			logger.Error("cannot listen", slog.String("grpc_port", cfg.GRPCPort), slog.Any("error", err))
+			return 1
+		}
+		grpcSrv = srv.newGRPCServer()
+	}
+
+	// Shutdown closes the listener, which also removes a Unix socket file.
+	ln, err := listen(cfg)
+	if err != nil {
+		logger.Error("cannot listen", slog.String("network", cfg.ListenNetwork), slog.String("addr", cfg.listenAddr()), slog.Any("error", err))
+		return 1
+	}
+
+	logger.Info("server starting",
+		slog.String("network", ln.Addr().Network()),
+		slog.String("addr", ln.Addr().String()),
+		slog.String("scheme", scheme),
+		slog.Bool("h2c", h2cSrv != nil),
+		slog.String("version", srv.build.Version),
+		slog.String("git_commit", srv.build.GitCommit),
+		slog.String("admin_port", cfg.AdminPort),
+		slog.String("grpc_port", cfg.GRPCPort),
+		slog.String("pprof", pprofStatus),
+		slog.String("fault_injection", faultStatus),
+		slog.Bool("tracing", cfg.OTLPEndpoint != ""),
+	)
+	serverErr := make(chan error, 1)
+	go func() {
+		if certs != nil {
+			// Certificates come from TLSConfig.GetCertificate.
+			serverErr <- server.ServeTLS(ln, "", "")
+			return
+		}
+		serverErr <- server.Serve(ln)
+	}()
+	if adminServer != nil {
+		go func() {
+			serverErr <- adminServer.ListenAndServe()
+		}()
+	}
+	if grpcSrv != nil {
+		go func() {
+			if err := grpcSrv.Serve(grpcLn); err != nil {
+				serverErr <- err
+			}
+		}()
+	}
+	// Signals are caught before the server reports ready, so a SIGTERM
+	// that arrives right after still drains rather than kills.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+
+	go srv.warmUp(background)
+	srv.ready.Store(true)
+
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGUSR1)
+	go func() {
+		for range reopen {
+			for _, rf := range logFiles {
+				if err := rf.Reopen(); err == nil {
+					logger.Info("log file reopened", slog.String("file", rf.path))
+				}
+			}
+		}
+	}()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			reloader.Reload("SIGHUP")
+			if certs == nil {
+				continue
+			}
+			if err := certs.Reload(); err != nil {
+				logger.Error("TLS reload failed, keeping previous certificates", slog.Any("error", err))
+				continue
+			}
+			logger.Info("TLS certificates reloaded")
+		}
+	}()
+
+	exitCode := 0
+	var trigger slog.Attr
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("server failed", slog.Any("error", err))
+			return 1
+		}
+		return 0
+	case sig := <-stop:
+		trigger = slog.String("signal", sig.String())
+	case req := <-srv.shutdowns:
+		trigger, exitCode = slog.String("reason", req.reason), req.exitCode
+	}
 
-	port := "8080"
-	fmt.Printf("Server starting on port %s...\n", port)
-	fmt.Println("Available endpoints:")
-	fmt.Println("  GET  /          - Hello message")
-	fmt.Println("  GET  /health    - Health check")
-	fmt.Println("  GET  /api       - API test")
-	fmt.Println("  POST /api       - Echo JSON data")
+	logger.Info("shutting down", trigger, slog.String("drain_timeout", cfg.ShutdownTimeout.String()))
+	srv.ready.Store(false)
+	if cfg.ShutdownDelay > 0 {
+		// The listener stays open while the endpoints controller and load
+		// balancers notice /readyz failing, so no request sent in the
+		// meantime is refused. A second signal skips the wait.
+		logger.Info("waiting for traffic to stop before draining", slog.String("shutdown_delay", cfg.ShutdownDelay.String()))
+		select {
+		case <-time.After(cfg.ShutdownDelay):
+		case <-stop:
+		}
+	}
+	stopBackground()
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	// Deregistering first lets the registry stop sending traffic while the
+	// rest drains.
+	if srv.registry != nil {
+		if err := srv.registry.Shutdown(ctx); err != nil {
+			logger.Warn("registry deregistration failed", slog.Any("error", err))
+		}
+	}
+	// A load test against this server would keep it from draining.
+	if srv.loadTests != nil {
+		if err := srv.loadTests.Shutdown(ctx); err != nil {
+			logger.Warn("load test still stopping", slog.Any("error", err))
+		}
+	}
+	// gRPC drains alongside HTTP rather than after it, sharing the timeout.
+	grpcStopped := make(chan error, 1)
+	if grpcSrv != nil {
+		go func() { grpcStopped <- grpcSrv.Shutdown(ctx) }()
+	} else {
+		grpcStopped <- nil
+	}
+	openAtShutdown := srv.conns.Stats().Open
+	if err := server.Shutdown(ctx); err != nil {
+		// Whatever is still open is cut off rather than left to the exit,
+		// and the rest still gets torn down.
+		forceClosed := srv.conns.Stats().Open
+		server.Close()
+		logConnectionsDrained(logger, openAtShutdown, forceClosed)
+		logger.Error("graceful shutdown failed", slog.Any("error", err))
+		exitCode = 1
+	} else {
+		logConnectionsDrained(logger, openAtShutdown, 0)
+	}
+	// h2c connections are hijacked from HTTP/1.1, so server.Shutdown
+	// doesn't wait for them.
+	if h2cSrv != nil {
+		if err := h2cSrv.Shutdown(ctx); err != nil {
+			logger.Warn("h2c connections still open", slog.Any("error", err))
+		}
+	}
+	if err := <-grpcStopped; err != nil {
+		logger.Warn("gRPC calls interrupted", slog.Any("error", err))
+	}
+	// Hijacked WebSocket connections aren't covered by server.Shutdown.
+	if err := srv.ws.Shutdown(ctx); err != nil {
+		logger.Warn("websocket shutdown incomplete", slog.Any("error", err))
+	}
+	if err := srv.jobs.Shutdown(ctx); err != nil {
+		logger.Warn("jobs interrupted", slog.Any("error", err))
+	}
+	// Item changes made while draining still get delivered.
+	if err := srv.webhooks.Shutdown(ctx); err != nil {
+		logger.Warn("webhook deliveries abandoned", slog.Any("error", err))
+	}
+	if err := srv.broker.Shutdown(ctx); err != nil {
+		logger.Warn("broker events abandoned", slog.Any("error", err))
+	}
+	if err := srv.shadow.Shutdown(ctx); err != nil {
+		logger.Warn("shadow workers still stopping", slog.Any("error", err))
+	}
+	if err := srv.audit.Shutdown(ctx); err != nil {
+		logger.Warn("audit entries abandoned", slog.Any("error", err))
+	}
+	// The admin server stops last so probes keep answering, with /readyz
+	// failing, while everything else drains.
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			logger.Warn("admin server shutdown failed", slog.Any("error", err))
+		}
 	}
+	logger.Info("server stopped")
+	return exitCode
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freePort returns a TCP port that was free a moment ago.
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+// runServer runs the real server in-process on a free port, with the
+// environment already set by the caller, and waits for it to become ready
+// on ADMIN_PORT if that is set. It returns the base URL and a channel
+// receiving run's exit code; the test stops it with SIGTERM.
+func runServer(t *testing.T) (string, <-chan int) {
+	t.Helper()
+	port := freePort(t)
+	t.Setenv("PORT", port)
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("LOG_SKIP_HEALTH", "true")
+	base := "http://127.0.0.1:" + port
+	probes := base
+	if admin := os.Getenv("ADMIN_PORT"); admin != "" {
+		probes = "http://127.0.0.1:" + admin
+	}
+
+	exited := make(chan int, 1)
+	go func() { exited <- run(nil) }()
+	for deadline := time.Now().Add(5 * time.Second); getStatus(probes+"/readyz") != http.StatusOK; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server never became ready")
+		}
+	}
+	return base, exited
+}
+
+// stopServer sends SIGTERM and waits for run to return 0.
+func stopServer(t *testing.T, exited <-chan int) {
+	t.Helper()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Errorf("run exited %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after draining")
+	}
+}
+
+// getStatus returns the status of a GET, or 0 if it fails.
+func getStatus(url string) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestShutdownDrainsSlowRequest runs the real server, sends it SIGTERM
+// while a slow request is in flight, and checks the sequence: /readyz
+// fails at once, the listener keeps accepting for SHUTDOWN_DELAY, the slow
+// request still completes, and run exits 0.
+func TestShutdownDrainsSlowRequest(t *testing.T) {
+	t.Setenv("SHUTDOWN_DELAY", "500ms")
+	t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+	base, exited := runServer(t)
+	status := func(path string) int { return getStatus(base + path) }
+
+	slow := make(chan int, 1)
+	go func() { slow <- status("/delay/1500ms") }()
+	time.Sleep(100 * time.Millisecond)
+	signaled := time.Now()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	for status("/readyz") != http.StatusServiceUnavailable {
+		if time.Since(signaled) > 250*time.Millisecond {
+			t.Fatal("/readyz still passing after SIGTERM")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := status("/healthz"); code != http.StatusOK || time.Since(signaled) > 400*time.Millisecond {
+		t.Errorf("GET /healthz during SHUTDOWN_DELAY = %d, want 200 on a still open listener", code)
+	}
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("in-flight slow request = %d, want 200", code)
+	}
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Errorf("run exited %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after draining")
+	}
+	if code := status("/healthz"); code != 0 {
+		t.Errorf("GET /healthz after shutdown = %d, want the listener closed", code)
+	}
+}
+
+// TestFailedShutdownStillTearsDown lets SHUTDOWN_TIMEOUT pass with a
+// request in flight and checks that run exits 1 having still stopped the
+// admin server.
+func TestFailedShutdownStillTearsDown(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "200ms")
+	admin := "http://127.0.0.1:" + freePort(t)
+	t.Setenv("ADMIN_PORT", strings.TrimPrefix(admin, "http://127.0.0.1:"))
+	base, exited := runServer(t)
+
+	slow := make(chan int, 1)
+	go func() { slow <- getStatus(base + "/delay/3s") }()
+	time.Sleep(100 * time.Millisecond)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("run exited %d, want 1 for a drain that timed out", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after the drain timed out")
+	}
+	if code := <-slow; code != 0 {
+		t.Errorf("request still in flight at the deadline = %d, want it cut off", code)
+	}
+	if code := getStatus(admin + "/healthz"); code != 0 {
+		t.Errorf("GET /healthz on the admin port after shutdown = %d, want the listener closed", code)
+	}
+}
+
+// TestReadHeaderTimeoutClosesSlowClients holds a connection open with an
+// unfinished request, slow-loris style, and expects the server to hang up
+// once READ_HEADER_TIMEOUT passes.
+func TestReadHeaderTimeoutClosesSlowClients(t *testing.T) {
+	t.Setenv("READ_HEADER_TIMEOUT", "200ms")
+	base, exited := runServer(t)
+	defer stopServer(t, exited)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET /healthz HTTP/1.1\r\nHost: test\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(3 * time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	if elapsed := time.Since(start); err != nil || elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("server closed the connection after %s with %v, want a clean close after about 200ms", elapsed, err)
+	}
+}
+
+func TestServerTimeoutsMustBePositive(t *testing.T) {
+	for _, name := range []string{"READ_TIMEOUT", "READ_HEADER_TIMEOUT", "WRITE_TIMEOUT", "IDLE_TIMEOUT"} {
+		for _, value := range []string{"0s", "-1s"} {
+			env := map[string]string{name: value}
+			_, err := loadConfig(func(k string) (string, bool) { v, ok := env[k]; return v, ok }, nil)
+			if err == nil || !strings.Contains(err.Error(), "invalid "+name) {
+				t.Errorf("%s=%s: loadConfig error = %v, want invalid %s", name, value, err, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceState reports maintenance mode for GET /admin/maintenance.
+type MaintenanceState struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter string `json:"retry_after"`
+	// FailReadiness is MAINTENANCE_FAIL_READINESS: /readyz fails too, so
+	// the Service stops routing to the pod.
+	FailReadiness bool `json:"fail_readiness"`
+	// SetBy is "config" or the caller of POST /admin/maintenance.
+	SetBy string     `json:"set_by,omitempty"`
+	SetAt *time.Time `json:"set_at,omitempty"`
+}
+
+// MaintenanceRequest is the body of POST /admin/maintenance.
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	// RetryAfter defaults to MAINTENANCE_RETRY_AFTER.
+	RetryAfter string `json:"retry_after"`
+
+	retryAfter time.Duration
+}
+
+// Validate checks retry_after.
+func (req *MaintenanceRequest) Validate() []FieldError {
+	if req.RetryAfter == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(req.RetryAfter)
+	if err != nil || d < 0 {
+		return []FieldError{{Field: "retry_after", Constraint: "format", Message: "must be a non-negative duration such as 120s", Value: req.RetryAfter}}
+	}
+	req.retryAfter = d
+	return nil
+}
+
+// MaintenanceResponse is the 503 body while in maintenance.
+type MaintenanceResponse struct {
+	ErrorResponse
+	Message    string    `json:"message,omitempty"`
+	RetryAfter string    `json:"retry_after"`
+	Since      time.Time `json:"since"`
+}
+
+// maintenanceMode answers every request but the probes, /metrics, /debug,
+// and /admin with 503 while enabled. It is switched by POST
+// /admin/maintenance and by MAINTENANCE in the configuration, whichever
+// changed last.
+type maintenanceMode struct {
+	mu            sync.RWMutex
+	enabled       bool
+	message       string
+	retryAfter    time.Duration
+	failReadiness bool
+	setBy         string
+	setAt         time.Time
+}
+
+func newMaintenanceMode(cfg Config, now time.Time) *maintenanceMode {
+	m := &maintenanceMode{}
+	m.Configure(cfg, now)
+	m.SetFailReadiness(cfg.MaintenanceFailReadiness)
+	return m
+}
+
+// Configure applies MAINTENANCE, MAINTENANCE_MESSAGE, and
+// MAINTENANCE_RETRY_AFTER from cfg. A reload calls it only when one of them
+// changed, so reloading something else doesn't undo POST
+// /admin/maintenance.
+func (m *maintenanceMode) Configure(cfg Config, now time.Time) {
+	m.Set(cfg.Maintenance, cfg.MaintenanceMessage, cfg.MaintenanceRetryAfter, "config", now)
+}
+
+// maintenanceChanged reports whether Configure would apply anything new.
+func maintenanceChanged(old, next Config) bool {
+	return old.Maintenance != next.Maintenance || old.MaintenanceMessage != next.MaintenanceMessage ||
+		old.MaintenanceRetryAfter != next.MaintenanceRetryAfter
+}
+
+// SetFailReadiness sets MAINTENANCE_FAIL_READINESS.
+func (m *maintenanceMode) SetFailReadiness(fail bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failReadiness = fail
+}
+
+// Set switches maintenance mode on or off on behalf of setBy.
+func (m *maintenanceMode) Set(enabled bool, message string, retryAfter time.Duration, setBy string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setLocked(enabled, message, retryAfter, setBy, now)
+}
+
+func (m *maintenanceMode) setLocked(enabled bool, message string, retryAfter time.Duration, setBy string, now time.Time) {
+	if enabled != m.enabled || message != m.message || retryAfter != m.retryAfter {
+		m.setBy, m.setAt = setBy, now
+	}
+	m.enabled, m.message, m.retryAfter = enabled, message, retryAfter
+}
+
+// Enabled reports whether requests are being refused.
+func (m *maintenanceMode) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+// FailsReadiness reports whether /readyz should fail.
+func (m *maintenanceMode) FailsReadiness() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled && m.failReadiness
+}
+
+// State reports the current mode.
+func (m *maintenanceMode) State() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state := MaintenanceState{
+		Enabled:       m.enabled,
+		Message:       m.message,
+		RetryAfter:    m.retryAfter.String(),
+		FailReadiness: m.failReadiness,
+		SetBy:         m.setBy,
+	}
+	if !m.setAt.IsZero() {
+		setAt := m.setAt.UTC()
+		state.SetAt = &setAt
+	}
+	return state
+}
+
+// maintenanceExempt reports whether path is answered during maintenance:
+// the probes, so Kubernetes doesn't restart the pod, and the operational
+// endpoints, so maintenance can be switched off again.
+func maintenanceExempt(path string) bool {
+	return probePaths[path] || path == "/metrics" ||
+		strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/")
+}
+
+// withMaintenance refuses requests with 503 while maintenance mode is on.
+func withMaintenance(next http.Handler, m *maintenanceMode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceExempt(r.URL.Path) || !m.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.mu.RLock()
+		message, retryAfter, since := m.message, m.retryAfter, m.setAt
+		m.mu.RUnlock()
+		seconds := retryAfterSeconds(retryAfter)
+		varyLanguage(w.Header())
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeResponse(w, r, http.StatusServiceUnavailable, MaintenanceResponse{
+			ErrorResponse: ErrorResponse{
+				Error:             localizeError(r, codeMaintenance, "Down for maintenance"),
+				Code:              codeMaintenance,
+				RequestID:         requestIDFrom(r.Context()),
+				RetryAfterSeconds: seconds,
+			},
+			Message:    message,
+			RetryAfter: retryAfter.String(),
+			Since:      since.UTC(),
+		})
+	})
+}
+
+// callerFrom names who made an authenticated request: the API key's name,
+// the JWT subject, or failing those the client IP.
+func callerFrom(r *http.Request) string {
+	if info, ok := r.Context().Value(logInfoKey{}).(*requestLogInfo); ok {
+		if info.APIKey != "" {
+			return "api_key:" + info.APIKey
+		}
+		if info.Subject != "" {
+			return "subject:" + info.Subject
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// maintenanceHandler serves GET and POST /admin/maintenance.
+func (s *Server) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeResponse(w, r, http.StatusOK, s.maintenance.State())
+	case http.MethodPost:
+		var req MaintenanceRequest
+		if !decodeValid(w, r, &req) {
+			return
+		}
+		retryAfter := s.active.Load().MaintenanceRetryAfter
+		if req.RetryAfter != "" {
+			retryAfter = req.retryAfter
+		}
+		caller := callerFrom(r)
+		s.maintenance.Set(req.Enabled, req.Message, retryAfter, caller, s.clock.Now())
+		loggerFrom(r.Context()).Warn("maintenance mode set", slog.Bool("enabled", req.Enabled),
+			slog.String("message", req.Message), slog.Duration("retry_after", retryAfter), slog.String("set_by", caller))
+		writeResponse(w, r, http.StatusOK, s.maintenance.State())
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
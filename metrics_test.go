@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanContext returns ctx carrying a remote span, sampled or not.
+func spanContext(ctx context.Context, sampled bool) context.Context {
+	cfg := trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	}
+	if sampled {
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(cfg))
+}
+
+func TestMetricsExemplarsAndRuntime(t *testing.T) {
+	observeDuration(spanContext(context.Background(), true), httpRequestDuration.WithLabelValues("/sampled-test", "GET"), 300*time.Millisecond)
+	observeDuration(spanContext(context.Background(), false), httpRequestDuration.WithLabelValues("/unsampled-test", "GET"), 300*time.Millisecond)
+	observeDuration(context.Background(), httpRequestDuration.WithLabelValues("/untraced-test", "GET"), 300*time.Millisecond)
+
+	r := newRequest(t, "GET", "/metrics", "")
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := serve(metricsHandler(), r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", rec.Code)
+	}
+	exemplars := map[string]int{}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "http_request_duration_seconds_bucket{") || !strings.Contains(line, "-test\"") {
+			continue
+		}
+		path := line[strings.Index(line, `path="`)+6:]
+		path = path[:strings.IndexByte(path, '"')]
+		if strings.Contains(line, " # {") {
+			exemplars[path]++
+			if !strings.Contains(line, `trace_id="4bf92f3577b34da6a3ce929d0e0e4736"`) {
+				t.Errorf("exemplar %q, want the span's trace ID", line)
+			}
+		}
+	}
+	if exemplars["/sampled-test"] != 1 || exemplars["/unsampled-test"] != 0 || exemplars["/untraced-test"] != 0 {
+		t.Errorf("exemplars by path = %v, want one on the sampled observation only", exemplars)
+	}
+
+	for _, name := range []string{"go_goroutines", "go_gc_duration_seconds", "go_memstats_heap_alloc_bytes", "process_resident_memory_bytes"} {
+		if !strings.Contains(rec.Body.String(), "\n"+name) {
+			t.Errorf("/metrics has no %s", name)
+		}
+	}
+}
+
+func BenchmarkObserveDuration(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"untraced", context.Background()},
+		{"unsampled", spanContext(context.Background(), false)},
+		{"exemplar", spanContext(context.Background(), true)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			obs := httpRequestDuration.WithLabelValues("/benchmark", "GET")
+			b.ReportAllocs()
+			for range b.N {
+				observeDuration(bc.ctx, obs, 42*time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code and
+// number of bytes written, since handlers call WriteHeader directly.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	// Informational responses precede the real one; only record the final
+	// status.
+	if !rec.wroteHeader && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Hijack hands the connection to protocols such as WebSocket. The recorder
+// logs the upgrade as 101 since the response bypasses WriteHeader.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+		rec.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Middleware wraps a handler with cross-cutting behavior such as logging or
+// authentication.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in mws with the first one outermost, so Chain(h, a, b)
+// serves a(b(h)) and a sees every request first.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// statusClientClosedRequest is logged, after nginx, for requests the client
+// abandoned before any response was written. It is never sent.
+const statusClientClosedRequest = 499
+
+// probePaths are excluded from access logs when LOG_SKIP_HEALTH is enabled.
+var probePaths = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// logRequests installs a request-scoped logger carrying the request ID, path,
+// and trace and span IDs (when the request is traced) on the context, then
+// writes one access log entry per request, including the matched route
+// pattern. 5xx responses are logged at error level, and requests the client
+// abandoned before a response as 499 with client_disconnected. Excluded
+// paths get the logger but no access log entry, and successful requests are
+// sampled as described on logSampling.
+func logRequests(next http.Handler, logger *slog.Logger, sampling logSampling) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := requestIDFrom(r.Context())
+		reqAttrs := []any{
+			slog.String("request_id", requestID),
+			slog.String("path", r.URL.Path),
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			reqAttrs = append(reqAttrs, slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+		excluded := sampling.Exclude[r.URL.Path]
+		sampledIn := excluded || sampling.sampledIn(requestID)
+		accessLogger := logger.With(reqAttrs...)
+		reqLogger := accessLogger
+		if !sampledIn {
+			reqLogger = slog.New(sampledHandler{accessLogger.Handler()})
+		}
+		info := &requestLogInfo{}
+		ctx := context.WithValue(contextWithLogger(r.Context(), reqLogger), logInfoKey{}, info)
+		r = r.WithContext(ctx)
+
+		if excluded {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		// The request context ends early only when the client closes the
+		// connection (or resets the HTTP/2 stream).
+		disconnected := ctx.Err() != nil
+		status := rec.status
+		if disconnected && !rec.wroteHeader {
+			status = statusClientClosedRequest
+		}
+		elapsed := time.Since(start)
+		if !sampling.keep(sampledIn, status, elapsed) {
+			return
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("proto", r.Proto),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+			slog.Int("bytes", rec.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("client_ip", clientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if disconnected {
+			attrs = append(attrs, slog.Bool("client_disconnected", true))
+		}
+		if info.Route != "" {
+			attrs = append(attrs, slog.String("route", info.Route))
+		}
+		if info.APIKey != "" {
+			attrs = append(attrs, slog.String("api_key", info.APIKey))
+		}
+		if info.Subject != "" {
+			attrs = append(attrs, slog.String("subject", info.Subject))
+		}
+		if info.Tenant != "" {
+			attrs = append(attrs, slog.String("tenant", info.Tenant))
+		}
+		accessLogger.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+// allowMethods rejects requests whose method is not in methods with a 405 and
+// an Allow header listing the permitted ones.
+func allowMethods(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireContentType rejects write requests (POST, PUT, PATCH, or any request
+// carrying a body) whose Content-Type media type is not one of types with a
+// 415. Parameters such as charset are ignored. Bodyless GET and DELETE
+// requests pass through untouched.
+func requireContentType(next http.HandlerFunc, types ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			if r.ContentLength <= 0 && r.Header.Get("Content-Type") == "" {
+				next(w, r)
+				return
+			}
+		}
+
+		received := r.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(received)
+		if err != nil || !slices.Contains(types, mediaType) {
+			if received == "" {
+				received = "none"
+			}
+			writeError(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Unsupported content type", fmt.Sprintf("received %s, expected %s", received, strings.Join(types, " or ")))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limitBody caps request bodies at maxBytes. Requests that declare a larger
+// Content-Length are rejected up front with 413; others are wrapped in
+// http.MaxBytesReader so decoding fails with *http.MaxBytesError once the
+// limit is crossed. Paths in overrides use their own limit instead.
+func limitBody(next http.Handler, maxBytes int64, overrides map[string]int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBytes := maxBytes
+		if n, ok := overrides[r.URL.Path]; ok {
+			maxBytes = n
+		}
+		if r.ContentLength > maxBytes {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Request body too large", fmt.Sprintf("body must not exceed %d bytes", maxBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanics turns a handler panic into a 500 JSON response and logs the
+// stack trace with the request ID. The panic value is never sent to the
+// client. If the handler already started writing, the response can't be
+// replaced, so the connection is aborted to signal the truncation. An
+// abort passes through, so the outer backstop doesn't log it twice.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			loggerFrom(r.Context()).Error("panic serving request",
+				slog.String("method", r.Method),
+				slog.Any("panic", err),
+				slog.String("stack", string(debug.Stack())),
+			)
+			if rec.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			writeError(rec, r, http.StatusInternalServerError, codeInternal, "internal server error", "")
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// panicHandler panics on purpose so the recovery path can be exercised end
+// to end. With ?partial=true it writes part of a response first.
+func panicHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("partial") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"partial":`))
+	}
+	panic("deliberate panic from /debug/panic")
+}
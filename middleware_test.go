@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// logLines decodes the JSON log lines in buf with the given msg.
+func logLines(t *testing.T, buf *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry["msg"] == msg {
+			lines = append(lines, entry)
+		}
+	}
+	return lines
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name+" in")
+				next.ServeHTTP(w, r)
+				order = append(order, name+" out")
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { order = append(order, "handler") }), mark("a"), mark("b"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	want := []string{"a in", "b in", "handler", "b out", "a out"}
+	if !slices.Equal(order, want) {
+		t.Errorf("Chain order = %v, want %v", order, want)
+	}
+}
+
+// TestMiddlewareEffects follows one panicking request through the real
+// stack: the request ID is assigned before anything logs, the panic is
+// recovered into a 500 carrying that ID, and the access log, outside
+// recovery, records the 500 under the same ID.
+func TestMiddlewareEffects(t *testing.T) {
+	var logs bytes.Buffer
+	_, public := newLoggedTestServer(t, slog.New(slog.NewJSONHandler(&logs, nil)), func(cfg *Config) { cfg.DebugEndpoints = true })
+
+	rec := serve(public, newRequest(t, "GET", "/debug/panic", ""))
+	id := rec.Header().Get(requestIDHeader)
+	if rec.Code != http.StatusInternalServerError || id == "" {
+		t.Fatalf("GET /debug/panic = %d with request ID %q, want a 500 with one", rec.Code, id)
+	}
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("recovered 500 lacks the security headers: %v", rec.Header())
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != codeInternal || body.RequestID != id {
+		t.Errorf("body = %s, want an internal_error carrying request ID %s", rec.Body, id)
+	}
+	if strings.Contains(rec.Body.String(), "deliberate") {
+		t.Errorf("body leaks the panic value: %s", rec.Body)
+	}
+	panics := logLines(t, &logs, "panic serving request")
+	if len(panics) != 1 || panics[0]["request_id"] != id {
+		t.Errorf("panic log lines = %v, want one with request_id %s", panics, id)
+	}
+	access := logLines(t, &logs, "request")
+	if len(access) != 1 || access[0]["request_id"] != id || access[0]["status"] != float64(500) {
+		t.Errorf("access log lines = %v, want one 500 with request_id %s", access, id)
+	}
+}
+
+// TestRecoveryIsOutermost panics in a middleware between the request ID
+// and the inner recovery: the backstop still answers 500.
+func TestRecoveryIsOutermost(t *testing.T) {
+	srv, _ := newTestServer(t)
+	boom := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("middleware panic") })
+	}
+	mws := slices.Insert(srv.middleware(nil), 2, Middleware(boom))
+	h := Chain(http.NotFoundHandler(), mws...)
+	rec := serve(h, newRequest(t, "GET", "/", ""))
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get(requestIDHeader) == "" {
+		t.Errorf("panicking middleware = %d with headers %v, want a 500 with a request ID", rec.Code, rec.Header())
+	}
+	if b, _ := io.ReadAll(rec.Body); !strings.Contains(string(b), codeInternal) {
+		t.Errorf("body = %s, want an internal_error", b)
+	}
+}
+
+func TestRecoverPanicsDoesNotLeak(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.DebugEndpoints = true })
+	rec := serve(h, newRequest(t, "GET", "/debug/panic", ""))
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /debug/panic = %d %s, want a JSON 500", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != "internal server error" {
+		t.Errorf("body = %s, want error \"internal server error\"", rec.Body)
+	}
+}
+
+// A panic after the response has started can't become a 500; the
+// connection is cut instead so the client sees a failed or truncated
+// response rather than a second set of headers. Buffering middleware may
+// hold the partial write back, so either is acceptable.
+func TestRecoverPanicsAfterPartialResponse(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.DebugEndpoints = true })
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+
+	resp, err := ts.Client().Get(ts.URL + "/debug/panic?partial=true")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want the 200 already started", resp.StatusCode)
+	}
+	if b, err := io.ReadAll(resp.Body); err == nil || strings.Contains(string(b), codeInternal) {
+		t.Errorf("read body %q with error %v, want the connection cut mid-response", b, err)
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	_, h := newTestServer(t)
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantAllow    string
+	}{
+		{"GET", "/health", http.StatusOK, ""},
+		{"POST", "/health", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"DELETE", "/health", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"GET", "/", http.StatusOK, ""},
+		{"DELETE", "/", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{"PUT", "/", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+	}
+	for _, tt := range tests {
+		rec := serve(h, newRequest(t, tt.method, tt.path, ""))
+		if rec.Code != tt.wantStatus || rec.Header().Get("Allow") != tt.wantAllow {
+			t.Errorf("%s %s = %d with Allow %q, want %d with %q", tt.method, tt.path, rec.Code, rec.Header().Get("Allow"), tt.wantStatus, tt.wantAllow)
+		}
+		if tt.wantStatus == http.StatusMethodNotAllowed {
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != codeMethodNotAllowed {
+				t.Errorf("%s %s body = %s, want method_not_allowed", tt.method, tt.path, rec.Body)
+			}
+		}
+	}
+}
+
+func TestHeadOmitsBody(t *testing.T) {
+	_, h := newTestServer(t)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	for _, path := range []string{"/", "/health"} {
+		get, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		get.Body.Close()
+		head, err := ts.Client().Head(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(head.Body)
+		head.Body.Close()
+		if head.StatusCode != http.StatusOK || len(b) != 0 {
+			t.Errorf("HEAD %s = %d with %d body bytes, want 200 and none", path, head.StatusCode, len(b))
+		}
+		if head.Header.Get("Content-Type") != get.Header.Get("Content-Type") {
+			t.Errorf("HEAD %s Content-Type = %q, want GET's %q", path, head.Header.Get("Content-Type"), get.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	const limit = 64
+	_, h := newTestServer(t, func(cfg *Config) { cfg.MaxBodyBytes = limit })
+	body := func(n int) string {
+		// {"pad":"…"} is 10 bytes of framing.
+		return `{"pad":"` + strings.Repeat("x", n-10) + `"}`
+	}
+	tests := []struct {
+		name       string
+		size       int
+		streamed   bool
+		wantStatus int
+	}{
+		{"at the limit", limit, false, http.StatusOK},
+		{"streamed at the limit", limit, true, http.StatusOK},
+		{"declared over the limit", limit + 1, false, http.StatusRequestEntityTooLarge},
+		{"streamed over the limit", limit + 1, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRequest(t, "POST", "/api", body(tt.size))
+			if tt.streamed {
+				// Hide the length so only MaxBytesReader can catch it.
+				r.Body = io.NopCloser(io.MultiReader(r.Body))
+				r.ContentLength = -1
+			}
+			rec := serve(h, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("POST /api with %d bytes = %d %s, want %d", tt.size, rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusRequestEntityTooLarge {
+				return
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Code != codeBodyTooLarge || !strings.Contains(resp.Detail, "64 bytes") {
+				t.Errorf("body = %s, want body_too_large stating the limit", rec.Body)
+			}
+		})
+	}
+}
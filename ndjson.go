@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+// ndjsonFlushEvery is how many items are written between flushes, so the
+// client sees progress without a syscall per line.
+const ndjsonFlushEvery = 100
+
+// wantsNDJSON reports whether the list request asked for newline-delimited
+// JSON, with ?format=ndjson or an Accept header naming it.
+func wantsNDJSON(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("format") {
+	case "ndjson":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+	default:
+		return false, fmt.Errorf("format must be json or ndjson")
+	}
+	return acceptsNDJSON(r.Header.Get("Accept")), nil
+}
+
+// acceptsNDJSON reports whether an Accept header names NDJSON with a
+// non-zero quality.
+func acceptsNDJSON(accept string) bool {
+	return acceptsMediaType(accept, contentTypeNDJSON)
+}
+
+// acceptsMediaType reports whether an Accept header names mediaType, exactly,
+// with a non-zero quality.
+func acceptsMediaType(accept, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), mediaType) {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if name, value, found := strings.Cut(strings.TrimSpace(param), "="); found && strings.TrimSpace(name) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// itemFormat is an encoding items can be streamed in.
+type itemFormat struct {
+	contentType string
+	// filename, when set, makes the response a download under that name.
+	filename string
+	encoder  func(io.Writer) itemEncoder
+}
+
+// itemEncoder writes items one at a time; Close ends the document.
+type itemEncoder interface {
+	Encode(item Item) error
+	Close() error
+}
+
+// ndjsonFormat writes one item per line.
+var ndjsonFormat = itemFormat{contentType: contentTypeNDJSON, encoder: func(w io.Writer) itemEncoder {
+	return ndjsonEncoder{json.NewEncoder(w)}
+}}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e ndjsonEncoder) Encode(item Item) error { return e.enc.Encode(item) }
+
+func (ndjsonEncoder) Close() error { return nil }
+
+// streamItems writes the items selected by q in format as the store yields
+// them. Errors before the first item get the usual error response; after it
+// the status is already sent, so the stream just ends and the error is
+// logged. A client that disconnects cancels the store iteration.
+func (s *Server) streamItems(w http.ResponseWriter, r *http.Request, q itemQuery, format itemFormat) {
+	var enc itemEncoder
+	start := func() {
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Add("Vary", "Accept")
+		if format.filename != "" {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": format.filename}))
+		}
+		w.WriteHeader(http.StatusOK)
+		enc = format.encoder(w)
+	}
+	rc := http.NewResponseController(w)
+	written := 0
+	for item, err := range s.storeFor(r).ListStream(r.Context(), q) {
+		switch {
+		case err == nil:
+		case r.Context().Err() != nil:
+			return
+		case enc != nil:
+			loggerFrom(r.Context()).Error("item stream failed", slog.Int("items_written", written), slog.Any("error", err))
+			return
+		case errors.Is(err, ErrNotFound):
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", fmt.Sprintf("after references unknown item %q", q.After))
+			return
+		default:
+			writeStoreError(w, r, err)
+			return
+		}
+		if enc == nil {
+			start()
+		}
+		if enc.Encode(item) != nil {
+			return
+		}
+		if written++; written%ndjsonFlushEvery == 0 {
+			rc.Flush()
+		}
+	}
+	if enc == nil {
+		start()
+	}
+	enc.Close()
+}
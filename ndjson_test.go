@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// seedItems creates n items named "item 00000" onwards straight in store.
+func seedItems(t *testing.T, store Store, n int) {
+	t.Helper()
+	for i := range n {
+		if _, err := store.Create(context.Background(), Item{Name: fmt.Sprintf("item %05d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListItemsNDJSON(t *testing.T) {
+	const n = 20000
+	store := newMemoryStore()
+	seedItems(t, store, n)
+	_, h := startTestServer(t, defaultConfig(), slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+
+	for _, tc := range []struct {
+		name, target, accept string
+		first, count         int
+	}{
+		{"format parameter", "/api/v1/items?format=ndjson&sort=name", "", 0, n},
+		{"accept header", "/api/v1/items?sort=name", contentTypeNDJSON, 0, n},
+		{"filtered", "/api/v1/items?format=ndjson&sort=name&name=item%20012", "", 1200, 100},
+		{"paged", "/api/v1/items?format=ndjson&sort=name&offset=500&limit=10", "", 500, 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newRequest(t, "GET", tc.target, "")
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			rec := serve(h, r)
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentTypeNDJSON {
+				t.Fatalf("GET %s = %d %s", tc.target, rec.Code, rec.Header().Get("Content-Type"))
+			}
+			lines := bufio.NewScanner(rec.Body)
+			i := 0
+			for ; lines.Scan(); i++ {
+				var item Item
+				if err := json.Unmarshal(lines.Bytes(), &item); err != nil {
+					t.Fatalf("line %d: %v: %s", i, err, lines.Bytes())
+				}
+				if want := fmt.Sprintf("item %05d", tc.first+i); item.Name != want {
+					t.Fatalf("line %d is %q, want %q", i, item.Name, want)
+				}
+			}
+			if i != tc.count {
+				t.Errorf("streamed %d items, want %d", i, tc.count)
+			}
+		})
+	}
+}
+
+// cancellingWriter cancels its request's context on the first flush, as a
+// client disconnecting mid-stream would.
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	cancel  context.CancelFunc
+	flushes int
+}
+
+func (w *cancellingWriter) Flush() {
+	w.flushes++
+	w.ResponseRecorder.Flush()
+	w.cancel()
+}
+
+func TestStreamItemsStopsWhenClientGoes(t *testing.T) {
+	store := newMemoryStore()
+	seedItems(t, store, 10*ndjsonFlushEvery)
+	srv, _ := startTestServer(t, defaultConfig(), slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancellingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	srv.streamItems(w, newRequest(t, "GET", "/api/v1/items?format=ndjson", "").WithContext(ctx), itemQuery{SortBy: "created_at"}, ndjsonFormat)
+
+	if w.flushes != 1 {
+		t.Errorf("flushed %d times after the client went, want 1", w.flushes)
+	}
+	lines := 0
+	for s := bufio.NewScanner(w.Body); s.Scan(); {
+		lines++
+	}
+	if lines < ndjsonFlushEvery || lines > ndjsonFlushEvery+1 {
+		t.Errorf("streamed %d items, want to stop just after the first %d", lines, ndjsonFlushEvery)
+	}
+}
@@ -0,0 +1,822 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAPI is the subset of an OpenAPI 3.1 document this service publishes at
+// /openapi.json. It is built by hand as routes are registered rather than by
+// reflecting over handlers.
+type OpenAPI struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       OpenAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components OpenAPIComponents               `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// Operation documents one method on a path. Method is taken from the route
+// pattern when it has one.
+type Operation struct {
+	Method      string                `json:"-"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON Schema as used by OpenAPI 3.1.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MaxLength            int                `json:"maxLength,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// documentedMux registers routes on a ServeMux and records each one's
+// OpenAPI operations alongside it, so a route can't be added without its
+// spec entry.
+type documentedMux struct {
+	*http.ServeMux
+	doc      *OpenAPI
+	security []map[string][]string
+	// timeouts overrides the request timeout for individual paths.
+	timeouts map[string]time.Duration
+}
+
+func newDocumentedMux(mux *http.ServeMux, version string) *documentedMux {
+	return &documentedMux{
+		ServeMux: mux,
+		timeouts: make(map[string]time.Duration),
+		doc: &OpenAPI{
+			OpenAPI: "3.1.0",
+			Info: OpenAPIInfo{
+				Title:       "my-go-app",
+				Version:     version,
+				Description: "Demo service for Go, Kubernetes, and Skaffold.",
+			},
+			Paths:      make(map[string]map[string]Operation),
+			Components: OpenAPIComponents{Schemas: componentSchemas()},
+		},
+	}
+}
+
+// Route registers h for pattern and documents it with ops. It panics when ops
+// is empty so undocumented routes fail at startup; use HandleFunc directly for
+// the few routes deliberately left out of the spec.
+func (d *documentedMux) Route(pattern string, h http.Handler, ops ...Operation) {
+	if len(ops) == 0 {
+		panic("openapi: route " + pattern + " registered without an operation")
+	}
+	d.ServeMux.Handle(pattern, h)
+
+	method, path := splitPattern(pattern)
+	item := d.doc.Paths[path]
+	if item == nil {
+		item = make(map[string]Operation)
+		d.doc.Paths[path] = item
+	}
+	for _, op := range ops {
+		if op.Method == "" {
+			op.Method = method
+		}
+		if op.Method == "" {
+			panic("openapi: operation for " + pattern + " has no method")
+		}
+		item[strings.ToLower(op.Method)] = op
+	}
+}
+
+// Timeout overrides the request timeout for every route on path; zero
+// disables it, as streaming routes need.
+func (d *documentedMux) Timeout(path string, timeout time.Duration) {
+	d.timeouts[path] = timeout
+}
+
+// RequireAuth records the security schemes that protected operations
+// declare; call it before registering them.
+func (d *documentedMux) RequireAuth(apiKeys, bearer bool) {
+	schemes := make(map[string]SecurityScheme)
+	if apiKeys {
+		schemes["apiKey"] = SecurityScheme{Type: "apiKey", In: "header", Name: apiKeyHeader}
+		d.security = append(d.security, map[string][]string{"apiKey": {}})
+	}
+	if bearer {
+		schemes["bearer"] = SecurityScheme{Type: "http", Scheme: "bearer"}
+		d.security = append(d.security, map[string][]string{"bearer": {}})
+	}
+	if len(schemes) > 0 {
+		d.doc.Components.SecuritySchemes = schemes
+	}
+}
+
+// Secured marks ops as requiring the configured authentication.
+func (d *documentedMux) Secured(ops ...Operation) []Operation {
+	for i := range ops {
+		ops[i].Security = d.security
+	}
+	return ops
+}
+
+// Spec returns the document built so far.
+func (d *documentedMux) Spec() *OpenAPI {
+	return d.doc
+}
+
+// splitPattern turns a ServeMux pattern into its method (possibly empty) and
+// an OpenAPI path: "GET /items/{id}" gives "GET", "/items/{id}", and the
+// exact-match marker in "/{$}" is dropped.
+func splitPattern(pattern string) (string, string) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	path = strings.TrimSuffix(path, "{$}")
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	path = strings.ReplaceAll(path, "...}", "}")
+	return method, path
+}
+
+// docsPage loads Swagger UI from a CDN and points it at /openapi.json.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>my-go-app API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => { SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" }); };
+  </script>
+</body>
+</html>
+`
+
+// docsHandler serves docsPage as a staticDocument.
+func (s *Server) docsHandler() http.HandlerFunc {
+	doc := newStaticDocument("text/html; charset=utf-8", s.started, []byte(docsPage), []byte(docsPage))
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc.serve(w, r, false)
+	}
+}
+
+// Helpers for building operations concisely at the registration site.
+
+func schemaRef(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func jsonResponse(description, schema string) Response {
+	return Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: schemaRef(schema)}},
+	}
+}
+
+func errorResponse(description string) Response {
+	return jsonResponse(description, "ErrorResponse")
+}
+
+func jsonBody(schema string, contentTypes ...string) *RequestBody {
+	if len(contentTypes) == 0 {
+		contentTypes = []string{"application/json"}
+	}
+	body := &RequestBody{Required: true, Content: make(map[string]MediaType)}
+	for _, ct := range contentTypes {
+		body.Content[ct] = MediaType{Schema: schemaRef(schema)}
+	}
+	return body
+}
+
+func pathParam(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "path", Description: description, Required: true, Schema: schema}
+}
+
+func queryParam(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func headerParam(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "header", Description: description, Schema: schema}
+}
+
+func stringSchema() *Schema                 { return &Schema{Type: "string"} }
+func integerSchema() *Schema                { return &Schema{Type: "integer"} }
+func enumSchema(values ...any) *Schema      { return &Schema{Type: "string", Enum: values} }
+func formatSchema(t, format string) *Schema { return &Schema{Type: t, Format: format} }
+
+// enumValues converts names for enumSchema.
+func enumValues(names []string) []any {
+	values := make([]any, len(names))
+	for i, name := range names {
+		values[i] = name
+	}
+	return values
+}
+
+func objectSchema(required []string, properties map[string]*Schema) *Schema {
+	return &Schema{Type: "object", Required: required, Properties: properties}
+}
+
+// componentSchemas describes the response and request types.
+func componentSchemas() map[string]*Schema {
+	timestamp := formatSchema("string", "date-time")
+	zero := 0.0
+	freeform := &Schema{Type: "object", AdditionalProperties: &Schema{}}
+	return map[string]*Schema{
+		"HealthResponse": objectSchema([]string{"status", "timestamp", "version", "uptime", "hostname", "checks"}, map[string]*Schema{
+			"status":     enumSchema("healthy", "unhealthy", "ready", "degraded", "not ready"),
+			"timestamp":  timestamp,
+			"version":    stringSchema(),
+			"git_commit": stringSchema(),
+			"build_date": stringSchema(),
+			"uptime":     stringSchema(),
+			"hostname":   stringSchema(),
+			"pod":        stringSchema(),
+			"namespace":  stringSchema(),
+			"node":       stringSchema(),
+			"checks":     {Type: "object", AdditionalProperties: stringSchema()},
+			"circuits":   {Type: "object", Description: "Circuit breaker state per dependency: closed, half-open, or open", AdditionalProperties: stringSchema()},
+		}),
+		"MessageResponse": objectSchema([]string{"message", "timestamp"}, map[string]*Schema{
+			"message":     stringSchema(),
+			"timestamp":   timestamp,
+			"api_version": stringSchema(),
+			"lang":        {Type: "string", Description: "Language of message on /"},
+			"name":        {Type: "string", Description: "The name greeted on /, if any"},
+			"visits":      {Type: "integer", Description: "Visits to / in this session, with SESSION_SECRET set"},
+			"session_id":  {Type: "string", Description: "The session's ID, with SESSION_SECRET set"},
+		}),
+		"Session": objectSchema([]string{"session_id", "visits", "first_seen", "last_seen", "expires_at"}, map[string]*Schema{
+			"session_id": stringSchema(),
+			"visits":     integerSchema(),
+			"first_seen": formatSchema("string", "date-time"),
+			"last_seen":  formatSchema("string", "date-time"),
+			"expires_at": formatSchema("string", "date-time"),
+		}),
+		"Envelope": objectSchema([]string{"data", "meta"}, map[string]*Schema{
+			"data": {Description: "The response payload"},
+			"meta": objectSchema([]string{"api_version", "timestamp", "request_id"}, map[string]*Schema{
+				"api_version": stringSchema(),
+				"timestamp":   timestamp,
+				"request_id":  stringSchema(),
+			}),
+		}),
+		"ErrorResponse": objectSchema([]string{"error", "code"}, map[string]*Schema{
+			"error":               {Type: "string", Description: "Human-readable message, in the language of ?lang= or Accept-Language"},
+			"code":                {Type: "string", Enum: errorCodes, Description: "Stable machine-readable error code"},
+			"detail":              stringSchema(),
+			"details":             {Type: "array", Items: schemaRef("FieldError")},
+			"path":                stringSchema(),
+			"request_id":          stringSchema(),
+			"retry_after_seconds": {Type: "integer", Description: "On 429, 502, and 503: the Retry-After header, in seconds"},
+		}),
+		"HelloRequest": objectSchema(nil, map[string]*Schema{
+			"name": {Type: "string", MaxLength: maxHelloName, Description: "Who to greet; omit for the generic greeting"},
+		}),
+		"UUIDResponse": objectSchema([]string{"uuid"}, map[string]*Schema{
+			"uuid": formatSchema("string", "uuid"),
+		}),
+		"RandomResponse": objectSchema([]string{"data", "bytes", "encoding"}, map[string]*Schema{
+			"data":     stringSchema(),
+			"bytes":    integerSchema(),
+			"encoding": enumSchema("hex", "base64"),
+		}),
+		"TransformRequest": objectSchema([]string{"text", "ops"}, map[string]*Schema{
+			"text":   stringSchema(),
+			"ops":    {Type: "array", Items: enumSchema(enumValues(transformOpNames())...), Description: fmt.Sprintf("Applied in order; at most %d", maxTransformOps)},
+			"repeat": {Type: "integer", Minimum: &zero, Description: "Run the pipeline this many times (up to TRANSFORM_MAX_REPEAT)"},
+		}),
+		"TransformResponse": objectSchema([]string{"steps", "result", "repeat", "bytes_processed", "duration_ms"}, map[string]*Schema{
+			"steps": {Type: "array", Items: objectSchema([]string{"op", "result"}, map[string]*Schema{
+				"op":     stringSchema(),
+				"result": stringSchema(),
+			})},
+			"result":          stringSchema(),
+			"repeat":          integerSchema(),
+			"bytes_processed": {Type: "integer", Description: "Input bytes read by all operations across all runs"},
+			"duration_ms":     {Type: "number", Description: "Time spent transforming"},
+		}),
+		"ValidateRequest": objectSchema([]string{"name"}, map[string]*Schema{
+			"name":  {Type: "string", Description: "Required"},
+			"count": {Type: "integer", Minimum: &zero},
+			"tags":  {Type: "array", Items: stringSchema(), Description: fmt.Sprintf("At most %d non-empty tags", maxValidateTags)},
+		}),
+		"BatchRequest": objectSchema([]string{"operations"}, map[string]*Schema{
+			"operations": {Type: "array", Items: objectSchema([]string{"method", "path"}, map[string]*Schema{
+				"method": enumSchema("GET", "POST", "PUT", "PATCH", "DELETE"),
+				"path":   {Type: "string", Description: "Path under /api/, optionally with a query string"},
+				"body":   {Description: "JSON request body"},
+			})},
+		}),
+		"BatchResponse": objectSchema([]string{"results", "atomic"}, map[string]*Schema{
+			"results": {Type: "array", Items: objectSchema([]string{"status", "body"}, map[string]*Schema{
+				"status": integerSchema(),
+				"body":   {Description: "The operation's JSON response body, or null"},
+			})},
+			"atomic":      {Type: "boolean"},
+			"rolled_back": {Type: "boolean"},
+		}),
+		"SearchResponse": objectSchema([]string{"query", "results", "total", "limit", "offset"}, map[string]*Schema{
+			"query": stringSchema(),
+			"results": {Type: "array", Items: objectSchema([]string{"item", "score", "matches"}, map[string]*Schema{
+				"item":  schemaRef("Item"),
+				"score": {Type: "number", Description: "Sum over the matching fields: 1 for the whole field, 0.9 for its start, 0.8 for a word's start, 0.6 elsewhere, and up to 0.5 for a fuzzy match; doubled for the name"},
+				"matches": {Type: "array", Items: objectSchema([]string{"field", "value"}, map[string]*Schema{
+					"field":     {Type: "string", Description: "/name, or a JSON Pointer to a string in data"},
+					"value":     stringSchema(),
+					"highlight": {Type: "array", Items: integerSchema(), Description: "Start and end of the match in value, in characters; absent for fuzzy matches"},
+				})},
+			})},
+			"total":  integerSchema(),
+			"limit":  integerSchema(),
+			"offset": integerSchema(),
+		}),
+		"ImportSummary": objectSchema([]string{"imported", "skipped", "failed", "errors", "atomic"}, map[string]*Schema{
+			"imported": {Type: "integer", Description: "Items created or, with on_conflict=overwrite, replaced"},
+			"skipped":  {Type: "integer", Description: "Rows whose name was taken, with on_conflict=skip"},
+			"failed":   integerSchema(),
+			"errors": {Type: "array", Description: fmt.Sprintf("The first %d failed rows", importMaxErrors), Items: objectSchema([]string{"line", "reason"}, map[string]*Schema{
+				"line":   {Type: "integer", Description: "Where the row starts in the body, from 1"},
+				"reason": stringSchema(),
+			})},
+			"atomic":      {Type: "boolean"},
+			"rolled_back": {Type: "boolean"},
+		}),
+		"AuditList": objectSchema([]string{"entries", "dropped"}, map[string]*Schema{
+			"entries": {Type: "array", Items: objectSchema([]string{"id", "time", "method", "path", "status", "failed"}, map[string]*Schema{
+				"id":         integerSchema(),
+				"time":       formatSchema("string", "date-time"),
+				"request_id": stringSchema(),
+				"principal":  {Type: "string", Description: "The API key name or JWT subject"},
+				"tenant":     stringSchema(),
+				"method":     stringSchema(),
+				"path":       stringSchema(),
+				"query":      stringSchema(),
+				"status":     integerSchema(),
+				"failed":     {Type: "boolean", Description: "Whether the status was 4xx or 5xx"},
+				"changes": {Type: "array", Description: fmt.Sprintf("The first %d items changed, as their webhook events carry them", auditMaxChanges), Items: objectSchema([]string{"type", "item"}, map[string]*Schema{
+					"type": stringSchema(),
+					"item": {Type: "object"},
+				})},
+				"changes_omitted": integerSchema(),
+			})},
+			"dropped": {Type: "integer", Description: "Entries lost since startup because the audit writer fell behind"},
+		}),
+		"TaskStatus": objectSchema([]string{"name", "interval", "running", "runs", "last_duration_ms"}, map[string]*Schema{
+			"name":             stringSchema(),
+			"interval":         stringSchema(),
+			"running":          {Type: "boolean"},
+			"runs":             integerSchema(),
+			"last_run":         formatSchema("string", "date-time"),
+			"last_duration_ms": {Type: "number"},
+			"last_error":       stringSchema(),
+			"next_run":         formatSchema("string", "date-time"),
+		}),
+		"FlagStatus": objectSchema([]string{"name", "description", "default", "enabled", "source"}, map[string]*Schema{
+			"name":        stringSchema(),
+			"description": stringSchema(),
+			"default":     {Type: "boolean"},
+			"enabled":     {Type: "boolean"},
+			"source":      enumSchema(flagSourceDefault, flagSourceEnv, flagSourceFile, flagSourceHeader),
+		}),
+		"JobRequest": objectSchema([]string{"type"}, map[string]*Schema{
+			"type": enumSchema("sleep", "fibonacci", "http_check"),
+			"params": {
+				Type:        "object",
+				Description: `sleep: {"duration": "5s"}; fibonacci: {"n": 1000}; http_check: {"url": "..."} matching FETCH_ALLOWED_HOSTS`,
+			},
+		}),
+		"Job": objectSchema([]string{"id", "type", "status", "progress", "created_at"}, map[string]*Schema{
+			"id":          stringSchema(),
+			"type":        stringSchema(),
+			"params":      {Type: "object"},
+			"status":      enumSchema(jobQueued, jobRunning, jobDone, jobFailed, jobCancelled, jobInterrupted),
+			"progress":    {Type: "number", Description: "From 0 to 1"},
+			"result":      {Description: "The job's output once done"},
+			"error":       stringSchema(),
+			"created_at":  formatSchema("string", "date-time"),
+			"started_at":  formatSchema("string", "date-time"),
+			"finished_at": formatSchema("string", "date-time"),
+		}),
+		"Webhook": objectSchema([]string{"id", "url", "has_secret", "created_at"}, map[string]*Schema{
+			"id":         stringSchema(),
+			"url":        formatSchema("string", "uri"),
+			"has_secret": {Type: "boolean", Description: "Whether deliveries are signed; the secret itself is never returned"},
+			"created_at": formatSchema("string", "date-time"),
+			"tenant":     {Type: "string", Description: "The X-Tenant-ID it was registered under; absent for the default tenant"},
+		}),
+		"WebhookRequest": objectSchema([]string{"url"}, map[string]*Schema{
+			"url":    formatSchema("string", "uri"),
+			"secret": {Type: "string", Description: "Key for the X-Webhook-Signature HMAC-SHA256 of each delivery body"},
+		}),
+		"WebhookEvent": objectSchema([]string{"id", "type", "item", "timestamp"}, map[string]*Schema{
+			"id":        {Type: "string", Description: "Also sent as X-Webhook-Delivery; the same on every retry"},
+			"type":      enumSchema(webhookItemCreated, webhookItemUpdated, webhookItemDeleted, webhookItemRestored),
+			"item":      {Description: "The item, or only its id, and purged: true for a hard delete, for item.deleted"},
+			"timestamp": formatSchema("string", "date-time"),
+		}),
+		"WebhookDelivery": objectSchema([]string{"event_id", "event_type", "attempt", "succeeded", "duration_ms", "timestamp"}, map[string]*Schema{
+			"event_id":    stringSchema(),
+			"event_type":  stringSchema(),
+			"attempt":     integerSchema(),
+			"status_code": integerSchema(),
+			"error":       stringSchema(),
+			"succeeded":   {Type: "boolean"},
+			"duration_ms": {Type: "number"},
+			"timestamp":   formatSchema("string", "date-time"),
+		}),
+		"UploadedFile": objectSchema([]string{"filename", "size", "sha256", "content_type", "stored_path"}, map[string]*Schema{
+			"filename":     {Type: "string", Description: "Stored name, after sanitizing and collision suffixing"},
+			"size":         integerSchema(),
+			"sha256":       stringSchema(),
+			"content_type": stringSchema(),
+			"stored_path":  stringSchema(),
+		}),
+		"FetchResponse": objectSchema([]string{"url", "final_url", "status_code", "status", "latency_ms", "headers", "body", "body_encoding", "body_bytes", "truncated", "timestamp"}, map[string]*Schema{
+			"url":           stringSchema(),
+			"final_url":     stringSchema(),
+			"status_code":   integerSchema(),
+			"status":        stringSchema(),
+			"latency_ms":    {Type: "number"},
+			"headers":       {Type: "object", AdditionalProperties: &Schema{Type: "array", Items: stringSchema()}},
+			"body":          stringSchema(),
+			"body_encoding": enumSchema("utf-8", "base64"),
+			"body_bytes":    integerSchema(),
+			"truncated":     {Type: "boolean"},
+			"timestamp":     timestamp,
+		}),
+		"FieldError": objectSchema([]string{"field", "message"}, map[string]*Schema{
+			"field":      stringSchema(),
+			"constraint": stringSchema(),
+			"message":    stringSchema(),
+			"value":      {Description: "The offending value as received"},
+		}),
+		"TimeResponse": objectSchema([]string{"rfc3339", "unix", "unix_milli", "human", "timezone", "utc_offset", "uptime", "uptime_seconds", "hostname"}, map[string]*Schema{
+			"rfc3339":        stringSchema(),
+			"unix":           integerSchema(),
+			"unix_milli":     integerSchema(),
+			"human":          stringSchema(),
+			"timezone":       stringSchema(),
+			"utc_offset":     stringSchema(),
+			"format":         stringSchema(),
+			"formatted":      stringSchema(),
+			"uptime":         stringSchema(),
+			"uptime_seconds": {Type: "number", Description: "Measured on the monotonic clock"},
+			"hostname":       stringSchema(),
+			"node":           {Type: "string", Description: "NODE_NAME, when set"},
+		}),
+		"BuildInfo": objectSchema([]string{"version", "git_commit", "build_date", "go_version", "platform"}, map[string]*Schema{
+			"version":    stringSchema(),
+			"git_commit": stringSchema(),
+			"build_date": stringSchema(),
+			"go_version": stringSchema(),
+			"platform":   stringSchema(),
+		}),
+		"Item": objectSchema([]string{"id", "name", "created_at", "updated_at", "version"}, map[string]*Schema{
+			"id":         stringSchema(),
+			"name":       stringSchema(),
+			"data":       freeform,
+			"created_at": timestamp,
+			"updated_at": timestamp,
+			"version":    integerSchema(),
+			"deleted_at": {Type: "string", Format: "date-time", Description: "Set while the item is soft-deleted; only listed with include_deleted=true"},
+			"tenant":     {Type: "string", Description: "The X-Tenant-ID that owns it; absent for the default tenant"},
+		}),
+		"ItemRequest": objectSchema([]string{"name"}, map[string]*Schema{
+			"name":    stringSchema(),
+			"data":    freeform,
+			"version": {Type: "integer", Description: "On replace, apply only if the item is still at this version, like If-Match."},
+		}),
+		"ItemPatch": {
+			Type:        "object",
+			Description: "RFC 7386 merge patch applied to an Item; id and created_at may not change, and version is a precondition like If-Match.",
+			Properties: map[string]*Schema{
+				"name":    stringSchema(),
+				"data":    freeform,
+				"version": integerSchema(),
+			},
+		},
+		"ItemList": objectSchema([]string{"items", "total", "limit", "offset"}, map[string]*Schema{
+			"items":  {Type: "array", Items: schemaRef("Item")},
+			"total":  integerSchema(),
+			"limit":  integerSchema(),
+			"offset": integerSchema(),
+		}),
+		"StatusResponse": objectSchema([]string{"code", "description", "timestamp"}, map[string]*Schema{
+			"code":        integerSchema(),
+			"description": stringSchema(),
+			"timestamp":   timestamp,
+		}),
+		"IPResponse": objectSchema([]string{"remote_addr", "client_ip", "source"}, map[string]*Schema{
+			"remote_addr": {Type: "string", Description: "The immediate peer"},
+			"client_ip":   stringSchema(),
+			"source":      enumSchema("RemoteAddr", "X-Forwarded-For", "Forwarded", "X-Real-IP"),
+		}),
+		"EchoResponse": objectSchema([]string{"method", "url", "headers", "body"}, map[string]*Schema{
+			"method":        stringSchema(),
+			"url":           stringSchema(),
+			"proto":         stringSchema(),
+			"host":          stringSchema(),
+			"remote_addr":   stringSchema(),
+			"client_ip":     {Type: "string", Description: "The caller, from forwarding headers when the peer is in TRUSTED_PROXIES"},
+			"headers":       {Type: "object", AdditionalProperties: &Schema{Type: "array", Items: stringSchema()}},
+			"query":         {Type: "object", AdditionalProperties: &Schema{Type: "array", Items: stringSchema()}},
+			"tls":           {Type: "object"},
+			"body":          stringSchema(),
+			"body_encoding": enumSchema("utf-8", "base64"),
+			"body_bytes":    integerSchema(),
+			"truncated":     {Type: "boolean"},
+			"request_id":    stringSchema(),
+			"timestamp":     timestamp,
+		}),
+		"BodyEchoResponse": objectSchema([]string{"parser", "bytes"}, map[string]*Schema{
+			"parser":       enumSchema(echoParserForm, echoParserMultipart, echoParserText, echoParserBinary),
+			"content_type": stringSchema(),
+			"bytes":        integerSchema(),
+			"form":         {Type: "object", Description: "Form fields, with form", AdditionalProperties: &Schema{Type: "array", Items: stringSchema()}},
+			"parts": {Type: "array", Description: "The parts, with multipart", Items: objectSchema([]string{"name", "size"}, map[string]*Schema{
+				"name":         stringSchema(),
+				"filename":     stringSchema(),
+				"content_type": stringSchema(),
+				"size":         integerSchema(),
+			})},
+			"text":              {Type: "string", Description: "The body, with text"},
+			"characters":        integerSchema(),
+			"sha256":            {Type: "string", Description: "Hex SHA-256 of the body, with binary"},
+			"preview":           {Type: "string", Description: "The first ECHO_PREVIEW_BYTES, base64 encoded"},
+			"preview_truncated": {Type: "boolean"},
+		}),
+		"FaultRequest": objectSchema([]string{"mode", "duration"}, map[string]*Schema{
+			"mode":     enumSchema(faultUnhealthy, faultNotReady, faultLatency, faultErrorRate),
+			"value":    {Description: `Latency as a duration string such as "500ms", or error rate as a percentage.`},
+			"duration": {Type: "string", Description: "How long the fault lasts, e.g. 30s."},
+		}),
+		"Fault": objectSchema([]string{"mode", "expires_at"}, map[string]*Schema{
+			"mode":       enumSchema(faultUnhealthy, faultNotReady, faultLatency, faultErrorRate),
+			"value":      {},
+			"expires_at": timestamp,
+		}),
+		"FaultList": objectSchema([]string{"faults"}, map[string]*Schema{
+			"faults": {Type: "array", Items: schemaRef("Fault")},
+		}),
+		"ShutdownRequest": objectSchema(nil, map[string]*Schema{
+			"delay":     {Type: "string", Description: "Wait this long before shutting down, e.g. 5s. Default 0."},
+			"exit_code": {Type: "integer", Description: "Exit status once drained, 0 to 125. Default 0."},
+		}),
+		"DumpResponse": objectSchema([]string{"trigger", "files"}, map[string]*Schema{
+			"trigger": enumSchema("manual", "memory", "slow_request"),
+			"files": {Type: "array", Items: objectSchema([]string{"name", "kind", "bytes"}, map[string]*Schema{
+				"name":  stringSchema(),
+				"kind":  enumSchema("cpu", "goroutine", "heap"),
+				"bytes": integerSchema(),
+			})},
+		}),
+		"MaintenanceRequest": objectSchema([]string{"enabled"}, map[string]*Schema{
+			"enabled":     {Type: "boolean"},
+			"message":     {Type: "string", Description: "Explains the maintenance window to clients"},
+			"retry_after": {Type: "string", Description: "Retry-After for refused requests, e.g. 120s. Default MAINTENANCE_RETRY_AFTER."},
+		}),
+		"MaintenanceState": objectSchema([]string{"enabled", "retry_after", "fail_readiness"}, map[string]*Schema{
+			"enabled":        {Type: "boolean"},
+			"message":        stringSchema(),
+			"retry_after":    stringSchema(),
+			"fail_readiness": {Type: "boolean", Description: "MAINTENANCE_FAIL_READINESS: /readyz fails while enabled"},
+			"set_by":         {Type: "string", Description: `"config", or the API key name, JWT subject, or IP of the caller that set it`},
+			"set_at":         timestamp,
+		}),
+		"RegistryStatus": objectSchema([]string{"url", "interval", "sent", "failed", "consecutive_failures", "deregistered"}, map[string]*Schema{
+			"url":                  {Type: "string", Description: "REGISTRY_URL, with any password redacted"},
+			"interval":             stringSchema(),
+			"sent":                 integerSchema(),
+			"failed":               integerSchema(),
+			"consecutive_failures": integerSchema(),
+			"last_sent":            timestamp,
+			"last_heartbeat": objectSchema([]string{"event", "hostname", "version", "address", "port", "ready", "status", "stats", "timestamp"}, map[string]*Schema{
+				"event":      enumSchema(registryHeartbeat, registryDeregister),
+				"hostname":   stringSchema(),
+				"pod":        stringSchema(),
+				"namespace":  stringSchema(),
+				"version":    stringSchema(),
+				"address":    {Type: "string", Description: "POD_IP, or the hostname"},
+				"port":       stringSchema(),
+				"ready":      {Type: "boolean"},
+				"status":     {Type: "string", Description: "The /readyz status"},
+				"stats":      {Type: "object", Description: "uptime_seconds, total_requests, and goroutines"},
+				"components": {Type: "object", Description: "What other parts of the server contribute, by name: jobs and websocket"},
+				"timestamp":  timestamp,
+			}),
+			"last_response": objectSchema([]string{"duration_ms"}, map[string]*Schema{
+				"status_code": integerSchema(),
+				"body":        {Type: "string", Description: "Up to 4 KiB of the registry's answer"},
+				"error":       stringSchema(),
+				"duration_ms": {Type: "number"},
+			}),
+			"next_send":    timestamp,
+			"deregistered": {Type: "boolean"},
+		}),
+		"ReadOnlyRequest": objectSchema([]string{"enabled"}, map[string]*Schema{
+			"enabled": {Type: "boolean"},
+		}),
+		"ReadOnlyState": objectSchema([]string{"enabled", "consecutive_failures"}, map[string]*Schema{
+			"enabled":              {Type: "boolean"},
+			"set_by":               {Type: "string", Description: `"write_failures", or the API key name, JWT subject, or IP of the caller that set it`},
+			"since":                timestamp,
+			"last_error":           {Type: "string", Description: "The write failure that entered the mode, or the last failed probe since"},
+			"consecutive_failures": {Type: "integer", Description: "Failed item writes since the last success"},
+		}),
+		"LoadTestRequest": objectSchema([]string{"target", "rps", "duration"}, map[string]*Schema{
+			"target":      {Type: "string", Description: "Path to load, with an optional query, e.g. /api"},
+			"rps":         {Type: "integer", Description: "Requests per second, up to LOADTEST_MAX_RPS"},
+			"duration":    {Type: "string", Description: "How long to run, e.g. 60s, up to LOADTEST_MAX_DURATION"},
+			"concurrency": {Type: "integer", Description: "Workers sending requests, up to LOADTEST_MAX_CONCURRENCY. Default 10."},
+		}),
+		"LoadTestStatus": objectSchema([]string{"id", "state", "url", "rps", "concurrency", "duration", "started_at", "elapsed_ms", "requests", "skipped", "achieved_rps", "latency", "statuses"}, map[string]*Schema{
+			"id":           stringSchema(),
+			"state":        enumSchema(loadTestRunning, loadTestCompleted, loadTestCancelled),
+			"url":          stringSchema(),
+			"rps":          integerSchema(),
+			"concurrency":  integerSchema(),
+			"duration":     stringSchema(),
+			"started_at":   timestamp,
+			"finished_at":  timestamp,
+			"elapsed_ms":   {Type: "number"},
+			"requests":     integerSchema(),
+			"skipped":      {Type: "integer", Description: "Ticks skipped because every worker was busy"},
+			"achieved_rps": {Type: "number"},
+			"latency": objectSchema([]string{"p50_ms", "p90_ms", "p99_ms", "max_ms"}, map[string]*Schema{
+				"p50_ms": {Type: "number"},
+				"p90_ms": {Type: "number"},
+				"p99_ms": {Type: "number"},
+				"max_ms": {Type: "number"},
+			}),
+			"statuses": {Type: "object", Description: `Responses by status code, and transport failures as "error"`, AdditionalProperties: integerSchema()},
+		}),
+		"RecordedSummary": objectSchema([]string{"id", "request_id", "timestamp", "method", "path", "status", "duration_ms"}, map[string]*Schema{
+			"id":          stringSchema(),
+			"request_id":  stringSchema(),
+			"timestamp":   timestamp,
+			"method":      stringSchema(),
+			"path":        {Type: "string", Description: "The path with its query"},
+			"status":      integerSchema(),
+			"duration_ms": {Type: "number"},
+		}),
+		"RecordedMessage": objectSchema([]string{"headers", "body", "body_encoding", "body_bytes", "truncated"}, map[string]*Schema{
+			"headers":       {Type: "object", Description: "Credential headers show as ***", AdditionalProperties: &Schema{Type: "array", Items: stringSchema()}},
+			"body":          stringSchema(),
+			"body_encoding": enumSchema("utf-8", "base64"),
+			"body_bytes":    integerSchema(),
+			"truncated":     {Type: "boolean", Description: "The body was over RECORDER_MAX_BODY_BYTES or, for a request, not read to its end"},
+		}),
+		"RecordedExchange": objectSchema([]string{"id", "request_id", "timestamp", "method", "path", "status", "duration_ms", "client_ip", "request", "response"}, map[string]*Schema{
+			"id":          stringSchema(),
+			"request_id":  stringSchema(),
+			"timestamp":   timestamp,
+			"method":      stringSchema(),
+			"path":        {Type: "string", Description: "The path with its query"},
+			"status":      integerSchema(),
+			"duration_ms": {Type: "number"},
+			"client_ip":   stringSchema(),
+			"request":     schemaRef("RecordedMessage"),
+			"response":    schemaRef("RecordedMessage"),
+		}),
+		"ReplayResponse": objectSchema([]string{"id", "request_id", "status", "duration_ms", "response", "identical", "differences"}, map[string]*Schema{
+			"id":          {Type: "string", Description: "The recorded exchange"},
+			"request_id":  {Type: "string", Description: "The replay's request ID"},
+			"status":      integerSchema(),
+			"duration_ms": {Type: "number"},
+			"response":    schemaRef("RecordedMessage"),
+			"identical":   {Type: "boolean"},
+			"differences": {Type: "array", Items: objectSchema([]string{"field", "original", "replayed"}, map[string]*Schema{
+				"field":    enumSchema("status", "header", "body"),
+				"path":     {Type: "string", Description: "The header name, or a JSON Pointer into a JSON body"},
+				"original": {Description: "null when missing from the recorded response"},
+				"replayed": {Description: "null when missing from the replayed response"},
+			})},
+			"differences_truncated": {Type: "boolean", Description: "Only the first 100 differences are listed"},
+		}),
+		"ShutdownResponse": objectSchema([]string{"message", "delay", "exit_code"}, map[string]*Schema{
+			"message":   stringSchema(),
+			"delay":     stringSchema(),
+			"exit_code": {Type: "integer"},
+		}),
+		"EnvResponse": objectSchema([]string{"env", "runtime", "timestamp"}, map[string]*Schema{
+			"env":       {Type: "object", AdditionalProperties: stringSchema()},
+			"runtime":   {Type: "object"},
+			"timestamp": timestamp,
+		}),
+		"StatsResponse": objectSchema([]string{"since", "total_requests", "bytes_written", "goroutines", "memory", "routes", "connections", "read_only"}, map[string]*Schema{
+			"since":          timestamp,
+			"total_requests": integerSchema(),
+			"bytes_written":  integerSchema(),
+			"goroutines":     integerSchema(),
+			"memory":         {Type: "object", Description: "Selected runtime.MemStats fields, in bytes unless noted"},
+			"routes":         {Type: "array", Items: schemaRef("RouteStats")},
+			"concurrency":    {Type: "array", Items: schemaRef("ConcurrencyStats")},
+			"connections":    {Type: "array", Items: schemaRef("ConnectionStats")},
+			"read_only":      schemaRef("ReadOnlyState"),
+		}),
+		"StartupStatus": objectSchema([]string{"started_at", "done", "steps"}, map[string]*Schema{
+			"started_at":  timestamp,
+			"finished_at": timestamp,
+			"duration_ms": {Type: "number"},
+			"done":        {Type: "boolean"},
+			"steps": {Type: "array", Items: objectSchema([]string{"name", "duration_ms"}, map[string]*Schema{
+				"name":        stringSchema(),
+				"duration_ms": {Type: "number"},
+				"error":       stringSchema(),
+			})},
+		}),
+		"ConcurrencyStats": objectSchema([]string{"group", "limit", "in_flight", "queued"}, map[string]*Schema{
+			"group":     stringSchema(),
+			"limit":     integerSchema(),
+			"in_flight": integerSchema(),
+			"queued":    integerSchema(),
+		}),
+		"ConnectionStats": objectSchema([]string{"listener", "open", "new", "active", "idle", "opened", "closed", "hijacked", "oldest_ms", "lifetime_p50_ms", "lifetime_p95_ms", "lifetime_p99_ms"}, map[string]*Schema{
+			"listener":        {Type: "string", Enum: []any{"public", "admin"}},
+			"open":            integerSchema(),
+			"new":             integerSchema(),
+			"active":          integerSchema(),
+			"idle":            integerSchema(),
+			"opened":          integerSchema(),
+			"closed":          integerSchema(),
+			"hijacked":        integerSchema(),
+			"oldest_ms":       {Type: "number"},
+			"lifetime_p50_ms": {Type: "number"},
+			"lifetime_p95_ms": {Type: "number"},
+			"lifetime_p99_ms": {Type: "number"},
+		}),
+		"RouteStats": objectSchema([]string{"path", "method", "total", "status", "bytes", "avg_ms", "p50_ms", "p95_ms", "p99_ms"}, map[string]*Schema{
+			"path":   stringSchema(),
+			"method": stringSchema(),
+			"total":  integerSchema(),
+			"status": {Type: "object", Description: `Responses per status class, e.g. {"2xx": 10}`, AdditionalProperties: integerSchema()},
+			"bytes":  integerSchema(),
+			"avg_ms": {Type: "number"},
+			"p50_ms": {Type: "number"},
+			"p95_ms": {Type: "number"},
+			"p99_ms": {Type: "number"},
+		}),
+	}
+}
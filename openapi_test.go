@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// validateOpenAPI checks doc against the rules of the OpenAPI 3.1 schema
+// that a hand-built document can get wrong, returning every violation.
+func validateOpenAPI(doc map[string]any) []string {
+	var problems []string
+	fail := func(format string, args ...any) { problems = append(problems, fmt.Sprintf(format, args...)) }
+	obj := func(v any) map[string]any { m, _ := v.(map[string]any); return m }
+	str := func(v any) string { s, _ := v.(string); return s }
+
+	if !regexp.MustCompile(`^3\.1\.\d+$`).MatchString(str(doc["openapi"])) {
+		fail("openapi = %v, want 3.1.x", doc["openapi"])
+	}
+	info := obj(doc["info"])
+	if str(info["title"]) == "" || str(info["version"]) == "" {
+		fail("info needs a title and version: %v", info)
+	}
+	components := obj(doc["components"])
+	schemes := obj(components["securitySchemes"])
+	for name, raw := range schemes {
+		scheme := obj(raw)
+		switch str(scheme["type"]) {
+		case "apiKey":
+			if str(scheme["name"]) == "" || !slices.Contains([]string{"query", "header", "cookie"}, str(scheme["in"])) {
+				fail("security scheme %s: apiKey needs name and in", name)
+			}
+		case "http":
+			if str(scheme["scheme"]) == "" {
+				fail("security scheme %s: http needs a scheme", name)
+			}
+		case "mutualTLS", "oauth2", "openIdConnect":
+		default:
+			fail("security scheme %s: unknown type %v", name, scheme["type"])
+		}
+	}
+
+	// Every $ref anywhere must resolve to a component schema.
+	schemas := obj(components["schemas"])
+	var walk func(path string, v any)
+	walk = func(path string, v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name, found := strings.CutPrefix(ref, "#/components/schemas/")
+				if _, ok := schemas[name]; !found || !ok {
+					fail("%s: $ref %s does not resolve", path, ref)
+				}
+			}
+			for k, child := range v {
+				walk(path+"/"+k, child)
+			}
+		case []any:
+			for i, child := range v {
+				walk(fmt.Sprintf("%s/%d", path, i), child)
+			}
+		}
+	}
+	walk("#", doc)
+
+	methods := []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+	statusKey := regexp.MustCompile(`^([1-5](\d\d|XX)|default)$`)
+	templateVar := regexp.MustCompile(`\{([^}]+)\}`)
+	operationIDs := map[string]string{}
+	paths := obj(doc["paths"])
+	if len(paths) == 0 {
+		fail("no paths")
+	}
+	for path, rawItem := range paths {
+		if !strings.HasPrefix(path, "/") {
+			fail("path %q must start with /", path)
+		}
+		for method, rawOp := range obj(rawItem) {
+			where := strings.ToUpper(method) + " " + path
+			if !slices.Contains(methods, method) {
+				fail("%s: %q is not an HTTP method", where, method)
+				continue
+			}
+			op := obj(rawOp)
+			if id := str(op["operationId"]); id != "" {
+				if other, dup := operationIDs[id]; dup {
+					fail("%s: operationId %s already used by %s", where, id, other)
+				}
+				operationIDs[id] = where
+			}
+			responses := obj(op["responses"])
+			if len(responses) == 0 {
+				fail("%s: no responses", where)
+			}
+			for code, resp := range responses {
+				if !statusKey.MatchString(code) {
+					fail("%s: response key %q is not a status code", where, code)
+				}
+				if str(obj(resp)["description"]) == "" {
+					fail("%s: response %s has no description", where, code)
+				}
+			}
+			if body, ok := op["requestBody"]; ok && len(obj(obj(body)["content"])) == 0 {
+				fail("%s: requestBody without content", where)
+			}
+			pathParams := map[string]bool{}
+			for _, rawParam := range asSlice(op["parameters"]) {
+				param := obj(rawParam)
+				in := str(param["in"])
+				if str(param["name"]) == "" || !slices.Contains([]string{"query", "header", "path", "cookie"}, in) {
+					fail("%s: parameter %v needs a name and a valid in", where, param)
+				}
+				if param["schema"] == nil {
+					fail("%s: parameter %s has no schema", where, param["name"])
+				}
+				if in == "path" {
+					if param["required"] != true {
+						fail("%s: path parameter %s must be required", where, param["name"])
+					}
+					pathParams[str(param["name"])] = true
+				}
+			}
+			for _, m := range templateVar.FindAllStringSubmatch(path, -1) {
+				if !pathParams[m[1]] {
+					fail("%s: path parameter {%s} is not documented", where, m[1])
+				}
+			}
+			for _, rawReq := range asSlice(op["security"]) {
+				for name := range obj(rawReq) {
+					if _, ok := schemes[name]; !ok {
+						fail("%s: security requirement %s has no scheme", where, name)
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func asSlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
+
+func TestOpenAPIDocumentIsValid(t *testing.T) {
+	keys, _ := parseAPIKeys([]string{"k"})
+	_, h := newTestServer(t, func(cfg *Config) {
+		cfg.DebugEndpoints = true
+		cfg.APIKeys = keys
+	})
+	rec := serve(h, newRequest(t, "GET", "/openapi.json", ""))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /openapi.json = %d %s, want JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range validateOpenAPI(doc) {
+		t.Error(problem)
+	}
+	paths := doc["paths"].(map[string]any)
+	for _, path := range []string{"/", "/health", "/api/v1/items", "/api/v1/items/{id}"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("spec doesn't document %s", path)
+		}
+	}
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	for _, name := range []string{"HealthResponse", "MessageResponse", "ErrorResponse", "Item"} {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("spec lacks the %s schema", name)
+		}
+	}
+}
+
+func TestValidateOpenAPICatchesMistakes(t *testing.T) {
+	var doc map[string]any
+	json.Unmarshal([]byte(`{
+		"openapi": "3.1.0",
+		"info": {"title": "t", "version": "1"},
+		"components": {"schemas": {}},
+		"paths": {"/items/{id}": {"get": {
+			"parameters": [],
+			"responses": {"ok": {"description": "x", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Missing"}}}}},
+			"security": [{"apiKey": []}]
+		}}}
+	}`), &doc)
+	got := strings.Join(validateOpenAPI(doc), "\n")
+	for _, want := range []string{"does not resolve", "not a status code", "{id} is not documented", "apiKey has no scheme"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("validateOpenAPI missed %q; reported:\n%s", want, got)
+		}
+	}
+}
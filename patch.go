@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// errInvalidPatch marks merge patches that are valid JSON but can't be
+// applied to an item.
+var errInvalidPatch = errors.New("invalid patch")
+
+// mergePatch applies an RFC 7386 JSON merge patch to target: object members
+// in patch replace those in target, null members delete them, and nested
+// objects are merged recursively. A non-object patch replaces target.
+func mergePatch(target any, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	// A typed nil map, such as an item without data, is as good as none.
+	targetObj, ok := target.(map[string]any)
+	if !ok || targetObj == nil {
+		targetObj = map[string]any{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// applyItemPatch merges patch into item. Only name and data may change; id
+// and created_at are accepted only if they match the current values.
+func applyItemPatch(item Item, patch map[string]json.RawMessage) (Item, error) {
+	for field, raw := range patch {
+		switch field {
+		case "name":
+			var name *string
+			if err := json.Unmarshal(raw, &name); err != nil {
+				return Item{}, fmt.Errorf("%w: field \"name\" must be a string", errInvalidPatch)
+			}
+			if name == nil || *name == "" {
+				return Item{}, fmt.Errorf("%w: field \"name\" cannot be removed or empty", errInvalidPatch)
+			}
+			item.Name = *name
+		case "data":
+			var value any
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return Item{}, fmt.Errorf("%w: field \"data\": %v", errInvalidPatch, err)
+			}
+			if value == nil {
+				item.Data = nil
+				continue
+			}
+			data, ok := mergePatch(map[string]any(item.Data), value).(map[string]any)
+			if !ok {
+				return Item{}, fmt.Errorf("%w: field \"data\" must be an object or null", errInvalidPatch)
+			}
+			if len(data) == 0 {
+				data = nil
+			}
+			item.Data = data
+		case "id":
+			var id string
+			if err := json.Unmarshal(raw, &id); err != nil || id != item.ID {
+				return Item{}, fmt.Errorf("%w: field \"id\" is read-only", errInvalidPatch)
+			}
+		case "created_at":
+			var createdAt time.Time
+			if err := json.Unmarshal(raw, &createdAt); err != nil || !createdAt.Equal(item.CreatedAt) {
+				return Item{}, fmt.Errorf("%w: field \"created_at\" is read-only", errInvalidPatch)
+			}
+		case "updated_at":
+			// Server-managed; refreshed on every write regardless of the patch.
+		default:
+			return Item{}, fmt.Errorf("%w: unknown field %q", errInvalidPatch, field)
+		}
+	}
+	return item, nil
+}
+
+// patchItemHandler handles PATCH /api/items/{id} with JSON merge patch
+// semantics and returns the full updated item. A "version" member in the
+// patch is a precondition, like If-Match, rather than a change.
+func (s *Server) patchItemHandler(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := decodeJSON(r, &patch); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	var version *int64
+	if raw, ok := patch["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil || version == nil {
+			writeError(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "Invalid patch", "field \"version\" must be an integer")
+			return
+		}
+		delete(patch, "version")
+	}
+	pre, ok := s.readPrecondition(w, r, version)
+	if !ok {
+		return
+	}
+
+	item, err := s.storeFor(r).Patch(r.Context(), r.PathValue("id"), func(item Item) (Item, error) {
+		if err := pre.check(item); err != nil {
+			return Item{}, err
+		}
+		return applyItemPatch(item, patch)
+	})
+	switch {
+	case err == nil:
+		s.itemChanged(r, webhookItemUpdated, item)
+		w.Header().Set("ETag", itemETag(item))
+		writeResponse(w, r, http.StatusOK, item)
+	case errors.Is(err, errInvalidPatch):
+		writeError(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "Invalid patch", err.Error())
+	default:
+		writeStoreError(w, r, err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// createItem posts body to /api/v1/items and returns the created item.
+func createItem(t *testing.T, handler http.Handler, body string) Item {
+	t.Helper()
+	rec := serve(handler, newRequest(t, "POST", "/api/v1/items", body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/items = %d: %s", rec.Code, rec.Body)
+	}
+	return decodeItem(t, rec)
+}
+
+func decodeItem(t *testing.T, rec *httptest.ResponseRecorder) Item {
+	t.Helper()
+	var item Item
+	if err := json.Unmarshal(rec.Body.Bytes(), &item); err != nil {
+		t.Fatalf("decoding item %s: %v", rec.Body, err)
+	}
+	return item
+}
+
+func patchItem(t *testing.T, handler http.Handler, id, patch string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := newRequest(t, "PATCH", "/api/v1/items/"+id, patch)
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	return serve(handler, r)
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		target, patch string
+		want          string
+	}{
+		{"overwrite", `{"a": 1}`, `{"a": 2}`, `{"a": 2}`},
+		{"null deletes", `{"a": 1, "b": 2}`, `{"a": null}`, `{"b": 2}`},
+		{"absent untouched", `{"a": 1, "b": 2}`, `{"c": 3}`, `{"a": 1, "b": 2, "c": 3}`},
+		{"nested merge", `{"a": {"x": 1, "y": 2}}`, `{"a": {"y": null, "z": 3}}`, `{"a": {"x": 1, "z": 3}}`},
+		{"array replaced", `{"a": [1, 2]}`, `{"a": [3]}`, `{"a": [3]}`},
+		{"null target", `null`, `{"a": 1, "b": null}`, `{"a": 1}`},
+		{"scalar patch", `{"a": 1}`, `"x"`, `"x"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target, patch, want any
+			json.Unmarshal([]byte(tt.target), &target)
+			json.Unmarshal([]byte(tt.patch), &patch)
+			json.Unmarshal([]byte(tt.want), &want)
+			got, _ := json.Marshal(mergePatch(target, patch))
+			wantJSON, _ := json.Marshal(want)
+			if string(got) != string(wantJSON) {
+				t.Errorf("mergePatch(%s, %s) = %s, want %s", tt.target, tt.patch, got, wantJSON)
+			}
+		})
+	}
+}
+
+func TestPatchItem(t *testing.T) {
+	_, handler := newTestServer(t)
+	item := createItem(t, handler, `{"name": "widget", "data": {"color": "red", "size": 3}}`)
+
+	rec := patchItem(t, handler, item.ID, `{"data": {"color": null, "weight": 2}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d: %s", rec.Code, rec.Body)
+	}
+	patched := decodeItem(t, rec)
+	want := map[string]any{"size": float64(3), "weight": float64(2)}
+	if !maps.Equal(patched.Data, want) {
+		t.Errorf("data = %v, want %v", patched.Data, want)
+	}
+	if patched.Name != "widget" || !patched.CreatedAt.Equal(item.CreatedAt) {
+		t.Errorf("patch changed untouched fields: %+v", patched)
+	}
+	if patched.UpdatedAt.Before(item.UpdatedAt) || patched.Version != item.Version+1 {
+		t.Errorf("updated_at %v, version %d after patch, want refreshed from %v, %d", patched.UpdatedAt, patched.Version, item.UpdatedAt, item.Version)
+	}
+
+	// Deleting every key leaves no data at all.
+	rec = patchItem(t, handler, item.ID, `{"data": {"size": null, "weight": null}}`)
+	if got := decodeItem(t, rec); rec.Code != http.StatusOK || got.Data != nil {
+		t.Errorf("PATCH deleting every key = %d with data %v, want 200 and no data", rec.Code, got.Data)
+	}
+
+	for _, patch := range []string{`{"id": "other"}`, `{"created_at": "2001-01-01T00:00:00Z"}`, `{"name": null}`, `{"data": [1]}`, `{"owner": "me"}`} {
+		if rec := patchItem(t, handler, item.ID, patch); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("PATCH %s = %d, want 422", patch, rec.Code)
+		}
+	}
+}
+
+// An item created without data has a nil Data map; patching keys into it,
+// or deleting keys it never had, must not panic.
+func TestPatchItemWithoutData(t *testing.T) {
+	_, handler := newTestServer(t)
+	item := createItem(t, handler, `{"name": "bare"}`)
+
+	rec := patchItem(t, handler, item.ID, `{"data": {"gone": null}}`)
+	if got := decodeItem(t, rec); rec.Code != http.StatusOK || got.Data != nil {
+		t.Errorf("PATCH deleting from nil data = %d with data %v, want 200 and no data", rec.Code, got.Data)
+	}
+	rec = patchItem(t, handler, item.ID, `{"data": {"color": "blue"}}`)
+	if got := decodeItem(t, rec); rec.Code != http.StatusOK || got.Data["color"] != "blue" {
+		t.Errorf("PATCH adding to nil data = %d with data %v, want 200 and color blue", rec.Code, got.Data)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// backendPrefix is where the proxied backend is mounted.
+const backendPrefix = "/backend"
+
+// backendProxy forwards /backend/* to BACKEND_URL, stripping the prefix, so
+// two copies of the service can be chained frontend to backend.
+type backendProxy struct {
+	target *url.URL
+	client *http.Client
+	proxy  *httputil.ReverseProxy
+}
+
+// Proxied requests go through breaker; the readiness check bypasses it so
+// /readyz keeps reporting the backend's real health while the circuit is
+// open.
+// Retries happen inside the breaker, so a request counts against it once.
+func newBackendProxy(hc httpClientConfig, target *url.URL, timeout time.Duration, breaker *circuitBreaker) *backendProxy {
+	transport := newOutboundTransport(hc, func(t *http.Transport) { t.ResponseHeaderTimeout = timeout })
+
+	bp := &backendProxy{
+		target: target,
+		client: &http.Client{Transport: transport, Timeout: timeout},
+	}
+	bp.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path, pr.Out.URL.RawPath = stripBackendPrefix(pr.In.URL)
+			pr.Out.URL.Path = singleJoin(target.Path, pr.Out.URL.Path)
+			if pr.Out.URL.RawPath != "" {
+				pr.Out.URL.RawPath = singleJoin(target.EscapedPath(), pr.Out.URL.RawPath)
+			}
+			pr.SetXForwarded()
+			pr.Out.Header.Set(requestIDHeader, requestIDFrom(pr.In.Context()))
+		},
+		// The request ID middleware already echoed the same ID.
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del(requestIDHeader)
+			return nil
+		},
+		Transport:    breakerTransport{base: transport, breaker: breaker},
+		ErrorHandler: backendError,
+	}
+	return bp
+}
+
+// stripBackendPrefix removes backendPrefix from u's path, keeping a leading
+// slash.
+func stripBackendPrefix(u *url.URL) (path, rawPath string) {
+	path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, backendPrefix), "/")
+	if u.RawPath != "" {
+		rawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(u.RawPath, backendPrefix), "/")
+	}
+	return path, rawPath
+}
+
+// singleJoin joins a base path and a request path with exactly one slash.
+func singleJoin(base, path string) string {
+	if base == "" || base == "/" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+func (bp *backendProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bp.proxy.ServeHTTP(w, r)
+}
+
+// backendError replaces ReverseProxy's plain-text 502 with the JSON error
+// model: 504 when the backend timed out, 502 for anything else.
+func backendError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		// The client went away; there is no one to answer.
+		return
+	}
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		writeCircuitOpen(w, r, open)
+		return
+	}
+	loggerFrom(r.Context()).Warn("backend request failed", slog.Any("error", err))
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()) {
+		writeError(w, r, http.StatusGatewayTimeout, codeUpstreamTimeout, "Backend timed out", err.Error())
+		return
+	}
+	writeRetryableError(w, r, http.StatusBadGateway, retryAfterDefault, codeUpstreamError, "Backend unavailable", err.Error())
+}
+
+func (bp *backendProxy) Name() string { return "backend" }
+
+// Check probes the backend's liveness endpoint.
+func (bp *backendProxy) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bp.target.JoinPath("healthz").String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := bp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("backend /healthz returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits for /api/v1/uuid and /api/v1/random.
+const (
+	maxUUIDCount   = 10000
+	maxRandomBytes = 1024
+	maxRandomCount = 1000
+)
+
+// newUUIDv7 returns a random UUID prefixed with the Unix time in
+// milliseconds (RFC 9562), so IDs sort by creation time.
+func newUUIDv7(now time.Time) string {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(b[:6], ms[2:])
+	b[6] = (b[6] & 0x0f) | 0x70
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// queryInt parses query parameter name as an integer from lo to hi, or
+// returns def when it's absent.
+func queryInt(r *http.Request, name string, def, lo, hi int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be an integer from %d to %d", name, lo, hi)
+	}
+	return n, nil
+}
+
+// UUIDResponse is GET /api/v1/uuid without ?count=.
+type UUIDResponse struct {
+	UUID string `json:"uuid"`
+}
+
+// uuidHandler serves GET /api/v1/uuid: a version 4 UUID, or version 7 with
+// ?version=7. ?count= returns that many as a JSON array instead.
+func (s *Server) uuidHandler(w http.ResponseWriter, r *http.Request) {
+	gen := func() string { return newUUID() }
+	switch v := r.URL.Query().Get("version"); v {
+	case "", "4":
+	case "7":
+		gen = func() string { return newUUIDv7(s.clock.Now()) }
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid version", "version must be 4 or 7")
+		return
+	}
+	if r.URL.Query().Has("count") {
+		count, err := queryInt(r, "count", 1, 1, maxUUIDCount)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid count", err.Error())
+			return
+		}
+		streamJSONArray(w, r, count, gen)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, UUIDResponse{UUID: gen()})
+}
+
+// RandomResponse is GET /api/v1/random without ?count=.
+type RandomResponse struct {
+	Data     string `json:"data"`
+	Bytes    int    `json:"bytes"`
+	Encoding string `json:"encoding"`
+}
+
+// randomHandler serves GET /api/v1/random: ?bytes= (default 32) random
+// bytes from crypto/rand, hex or, with ?encoding=base64, base64 encoded.
+// ?count= returns that many as a JSON array instead.
+func (s *Server) randomHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := queryInt(r, "bytes", 32, 1, maxRandomBytes)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid bytes", err.Error())
+		return
+	}
+	encoding := r.URL.Query().Get("encoding")
+	var encode func([]byte) string
+	switch encoding {
+	case "", "hex":
+		encoding, encode = "hex", hex.EncodeToString
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid encoding", "encoding must be hex or base64")
+		return
+	}
+	buf := make([]byte, n)
+	gen := func() string {
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Sprintf("crypto/rand failed: %v", err))
+		}
+		return encode(buf)
+	}
+	if r.URL.Query().Has("count") {
+		count, err := queryInt(r, "count", 1, 1, maxRandomCount)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid count", err.Error())
+			return
+		}
+		streamJSONArray(w, r, count, gen)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, RandomResponse{Data: gen(), Bytes: n, Encoding: encoding})
+}
+
+// streamJSONArray writes count values of gen as a JSON array, generating
+// each as it is written so large counts don't sit in memory. It stops if
+// the client goes away.
+func streamJSONArray(w http.ResponseWriter, r *http.Request, count int, gen func() string) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+	for i := range count {
+		if r.Context().Err() != nil {
+			return
+		}
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		value, _ := json.Marshal(gen())
+		if _, err := bw.Write(value); err != nil {
+			return
+		}
+	}
+	bw.WriteString("]\n")
+	bw.Flush()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitIdleTTL is how long a client's bucket may sit unused before the
+// cleanup loop evicts it.
+const rateLimitIdleTTL = 3 * time.Minute
+
+// tokenBucket holds one client's remaining tokens as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-client token bucket limiter. Buckets refill at rps
+// tokens per second up to burst; an rps of 0 allows everything. Paths in
+// exempt are never limited.
+//
+// With a Redis client the buckets live there instead, shared by every
+// replica under prefix. If Redis can't be reached requests are let through
+// rather than refused, counted in redis_errors_total.
+type rateLimiter struct {
+	now    func() time.Time
+	redis  *redis.Client
+	prefix string
+
+	mu      sync.Mutex
+	rps     float64
+	burst   float64
+	exempt  map[string]bool
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rps float64, burst int, exempt []string) *rateLimiter {
+	rl := &rateLimiter{now: time.Now, buckets: make(map[string]*tokenBucket)}
+	rl.SetLimits(rps, burst, exempt)
+	return rl
+}
+
+// SetLimits changes the rate, burst, and exempt paths in place. Existing
+// buckets keep their tokens, capped at the new burst on their next request.
+func (rl *rateLimiter) SetLimits(rps float64, burst int, exempt []string) {
+	exemptPaths := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		exemptPaths[path] = true
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rps = rps
+	rl.burst = float64(burst)
+	rl.exempt = exemptPaths
+}
+
+// Allow takes a token from key's bucket for a request to path. When none
+// are left it reports how long until the next token is available.
+func (rl *rateLimiter) Allow(ctx context.Context, key, path string) (bool, time.Duration) {
+	now := rl.now()
+
+	rl.mu.Lock()
+	if rl.rps == 0 || rl.exempt[path] {
+		rl.mu.Unlock()
+		return true, 0
+	}
+	if rl.redis != nil {
+		rps, burst := rl.rps, rl.burst
+		rl.mu.Unlock()
+		allowed, tokens, err := redisRateLimit(ctx, rl.redis, rl.prefix+"ratelimit:"+key, rps, burst, now)
+		if err != nil {
+			redisFailed("rate_limit")
+			loggerFrom(ctx).Warn("rate limiter unavailable, allowing request", slog.Any("error", err))
+			return true, 0
+		}
+		if allowed {
+			return true, 0
+		}
+		return false, time.Duration((1 - tokens) / rps * float64(time.Second))
+	}
+	defer rl.mu.Unlock()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rps)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rl.rps * float64(time.Second))
+	return false, wait
+}
+
+// evictIdle drops buckets unused for longer than ttl, or than the time a
+// bucket takes to refill from empty if that is longer. An evicted bucket has
+// refilled to burst anyway, so forgetting it doesn't change behavior.
+func (rl *rateLimiter) evictIdle(ttl time.Duration) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.rps > 0 {
+		ttl = max(ttl, time.Duration(rl.burst/rl.rps*float64(time.Second)))
+	}
+	cutoff := rl.now().Add(-ttl)
+	evicted := 0
+	for key, b := range rl.buckets {
+		if b.last.Before(cutoff) {
+			delete(rl.buckets, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// withRateLimit rejects requests beyond a client's quota with 429 and a
+// Retry-After header.
+func withRateLimit(next http.Handler, rl *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, wait := rl.Allow(r.Context(), clientIP(r), r.URL.Path)
+		if !allowed {
+			writeRetryableError(w, r, http.StatusTooManyRequests, wait, codeRateLimited, "Rate limit exceeded", "retry after "+strconv.Itoa(retryAfterSeconds(wait))+"s")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for the limiter.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	rl := newRateLimiter(1, 5, nil)
+	rl.now = clock.now
+
+	rl.Allow(context.Background(), "idle", "/")
+	clock.advance(10 * time.Second)
+	rl.Allow(context.Background(), "busy", "/")
+	clock.advance(5 * time.Second)
+
+	if n := rl.evictIdle(8 * time.Second); n != 1 {
+		t.Fatalf("evictIdle evicted %d buckets, want 1", n)
+	}
+	if _, ok := rl.buckets["idle"]; ok {
+		t.Error("idle bucket survived eviction")
+	}
+	if _, ok := rl.buckets["busy"]; !ok {
+		t.Error("recently used bucket was evicted")
+	}
+}
+
+// A TTL shorter than the refill time would forget a drained bucket and hand
+// its client a fresh burst, so eviction waits for the bucket to refill.
+func TestRateLimiterKeepsBucketsUntilRefilled(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	rl := newRateLimiter(1, 10, nil)
+	rl.now = clock.now
+
+	for range 10 {
+		rl.Allow(context.Background(), "client", "/")
+	}
+	clock.advance(5 * time.Second)
+	if n := rl.evictIdle(time.Second); n != 0 {
+		t.Fatalf("evictIdle evicted %d half-refilled buckets, want 0", n)
+	}
+	for range 5 {
+		if ok, _ := rl.Allow(context.Background(), "client", "/"); !ok {
+			t.Fatal("request refused within the refilled tokens")
+		}
+	}
+	if ok, _ := rl.Allow(context.Background(), "client", "/"); ok {
+		t.Error("request allowed beyond the refilled tokens")
+	}
+	clock.advance(11 * time.Second)
+	if n := rl.evictIdle(time.Second); n != 1 {
+		t.Errorf("evictIdle evicted %d refilled buckets, want 1", n)
+	}
+}
+
+func TestRateLimitRejectsWithRetryAfter(t *testing.T) {
+	srv, h := newTestServer(t, func(cfg *Config) {
+		cfg.RateLimitRPS = 0.5
+		cfg.RateLimitBurst = 2
+	})
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	srv.limiter.now = clock.now
+
+	for i := range 2 {
+		if rec := serve(h, newRequest(t, "GET", "/version", "")); rec.Code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200 within the burst", i+1, rec.Code)
+		}
+	}
+	rec := serve(h, newRequest(t, "GET", "/version", ""))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != codeRateLimited || body.RetryAfterSeconds != 2 {
+		t.Errorf("body = %s, want a rate_limited error with retry_after_seconds 2", rec.Body)
+	}
+
+	if rec := serve(h, newRequest(t, "GET", "/healthz", "")); rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz while limited = %d, want 200 (exempt)", rec.Code)
+	}
+	clock.advance(2 * time.Second)
+	if rec := serve(h, newRequest(t, "GET", "/version", "")); rec.Code != http.StatusOK {
+		t.Errorf("request after Retry-After = %d, want 200", rec.Code)
+	}
+}
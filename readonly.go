@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readOnlyError is returned instead of attempting a write while the store
+// is read-only.
+type readOnlyError struct {
+	retryAfter time.Duration
+}
+
+func (e *readOnlyError) Error() string {
+	return "the item store is read-only"
+}
+
+func writeReadOnly(w http.ResponseWriter, r *http.Request, err *readOnlyError) {
+	writeRetryableError(w, r, http.StatusServiceUnavailable, err.retryAfter, codeReadOnly, "Read-only mode", "writes are paused until the item store accepts them again; reads still work")
+}
+
+// writeProber is implemented by stores that can check they accept writes
+// without changing any item. Stores without it are probed with their
+// readiness Check, if they have one.
+type writeProber interface {
+	ProbeWrite(ctx context.Context) error
+}
+
+// ReadOnlyState reports read-only mode in GET /admin/readonly, /stats,
+// and /readyz.
+type ReadOnlyState struct {
+	Enabled bool `json:"enabled"`
+	// SetBy is "write_failures" when the mode was entered automatically,
+	// or the caller of POST /admin/readonly.
+	SetBy string     `json:"set_by,omitempty"`
+	Since *time.Time `json:"since,omitempty"`
+	// LastError is the write failure that entered the mode, or the last
+	// failed probe since.
+	LastError string `json:"last_error,omitempty"`
+	// ConsecutiveFailures counts write failures since the last success;
+	// READ_ONLY_AFTER_FAILURES of them enter the mode.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
+
+// ReadOnlyRequest is the body of POST /admin/readonly.
+type ReadOnlyRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Validate has nothing to check.
+func (req *ReadOnlyRequest) Validate() []FieldError { return nil }
+
+// readOnlyMode stops item writes once threshold of them fail in a row, so
+// a broken data file or database costs clients a quick 503 rather than a
+// 500 each, while reads go on. A background probe ends the mode once the
+// store takes writes again. POST /admin/readonly switches it too; a mode
+// entered that way stays until it is switched off the same way.
+type readOnlyMode struct {
+	threshold  int
+	retryAfter time.Duration
+	now        func() time.Time
+	logger     *slog.Logger
+	// probeWrite checks the store takes writes; nil when it can't tell.
+	probeWrite func(context.Context) error
+
+	mu        sync.Mutex
+	enabled   bool
+	setBy     string
+	since     time.Time
+	failures  int
+	lastError string
+}
+
+// readOnlyAuto is setBy for the mode entered on write failures.
+const readOnlyAuto = "write_failures"
+
+func newReadOnlyMode(store Store, threshold int, probeInterval time.Duration, now func() time.Time, logger *slog.Logger) *readOnlyMode {
+	m := &readOnlyMode{threshold: threshold, retryAfter: probeInterval, now: now, logger: logger}
+	switch p := store.(type) {
+	case writeProber:
+		m.probeWrite = p.ProbeWrite
+	case Checker:
+		m.probeWrite = p.Check
+	}
+	return m
+}
+
+// Err returns a *readOnlyError while the mode is on.
+func (m *readOnlyMode) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.enabled {
+		return nil
+	}
+	return &readOnlyError{retryAfter: m.retryAfter}
+}
+
+// record counts the outcome of a write. Only failures of the store itself
+// count, as for the circuit breaker; while the circuit is open the breaker
+// is already failing fast, reads included.
+func (m *readOnlyMode) record(ctx context.Context, err error) {
+	failed := storeFailed(ctx, err) && !errors.As(err, new(*circuitOpenError))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !failed {
+		if err == nil {
+			m.failures = 0
+		}
+		return
+	}
+	m.failures++
+	if m.enabled || m.threshold <= 0 || m.failures < m.threshold {
+		return
+	}
+	m.setLocked(true, readOnlyAuto)
+	m.lastError = err.Error()
+	m.logger.Error("item store is read-only after write failures",
+		slog.Int("consecutive_failures", m.failures), slog.Any("error", err))
+}
+
+// Set switches the mode on behalf of setBy. Enabling a mode entered on
+// write failures hands it to setBy, so the probe no longer ends it.
+func (m *readOnlyMode) Set(enabled bool, setBy string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case enabled != m.enabled:
+		m.setLocked(enabled, setBy)
+	case enabled:
+		m.setBy = setBy
+	}
+}
+
+func (m *readOnlyMode) setLocked(enabled bool, setBy string) {
+	m.enabled, m.setBy, m.since = enabled, setBy, m.now()
+	if enabled {
+		storeReadOnly.Set(1)
+		storeReadOnlyEntered.WithLabelValues(readOnlyTrigger(setBy)).Inc()
+		return
+	}
+	m.failures, m.lastError = 0, ""
+	storeReadOnly.Set(0)
+}
+
+// readOnlyTrigger is the storeReadOnlyEntered label for setBy.
+func readOnlyTrigger(setBy string) string {
+	if setBy == readOnlyAuto {
+		return readOnlyAuto
+	}
+	return "admin"
+}
+
+// State reports the current mode.
+func (m *readOnlyMode) State() ReadOnlyState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := ReadOnlyState{Enabled: m.enabled, LastError: m.lastError, ConsecutiveFailures: m.failures}
+	if m.enabled {
+		since := m.since.UTC()
+		state.SetBy, state.Since = m.setBy, &since
+	}
+	return state
+}
+
+// probe asks the store whether it takes writes again, and ends a mode
+// entered on write failures once it does. It is a scheduled task.
+func (m *readOnlyMode) probe(ctx context.Context) error {
+	m.mu.Lock()
+	auto := m.enabled && m.setBy == readOnlyAuto
+	m.mu.Unlock()
+	if !auto {
+		return nil
+	}
+	var err error
+	if m.probeWrite != nil {
+		err = m.probeWrite(ctx)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.enabled || m.setBy != readOnlyAuto {
+		return nil
+	}
+	if err != nil {
+		m.lastError = err.Error()
+		return err
+	}
+	since := m.since
+	m.setLocked(false, readOnlyAuto)
+	m.logger.Info("item store accepts writes again, leaving read-only mode",
+		slog.Duration("read_only_for", m.now().Sub(since)))
+	return nil
+}
+
+// readOnlyStore refuses writes while mode is on and reports the outcome
+// of the others to it. Reads always go through.
+type readOnlyStore struct {
+	Store
+	mode *readOnlyMode
+}
+
+func (s readOnlyStore) Create(ctx context.Context, item Item) (Item, error) {
+	if err := s.mode.Err(); err != nil {
+		return Item{}, err
+	}
+	created, err := s.Store.Create(ctx, item)
+	s.mode.record(ctx, err)
+	return created, err
+}
+
+func (s readOnlyStore) Update(ctx context.Context, id string, item Item) (Item, error) {
+	if err := s.mode.Err(); err != nil {
+		return Item{}, err
+	}
+	updated, err := s.Store.Update(ctx, id, item)
+	s.mode.record(ctx, err)
+	return updated, err
+}
+
+func (s readOnlyStore) Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	if err := s.mode.Err(); err != nil {
+		return Item{}, err
+	}
+	patched, err := s.Store.Patch(ctx, id, fn)
+	s.mode.record(ctx, err)
+	return patched, err
+}
+
+func (s readOnlyStore) Delete(ctx context.Context, id string) error {
+	if err := s.mode.Err(); err != nil {
+		return err
+	}
+	err := s.Store.Delete(ctx, id)
+	s.mode.record(ctx, err)
+	return err
+}
+
+func (s readOnlyStore) Restore(ctx context.Context, id string) (Item, bool, error) {
+	if err := s.mode.Err(); err != nil {
+		return Item{}, false, err
+	}
+	item, restored, err := s.Store.Restore(ctx, id)
+	s.mode.record(ctx, err)
+	return item, restored, err
+}
+
+func (s readOnlyStore) Purge(ctx context.Context, id string) error {
+	if err := s.mode.Err(); err != nil {
+		return err
+	}
+	err := s.Store.Purge(ctx, id)
+	s.mode.record(ctx, err)
+	return err
+}
+
+// PurgeDeleted waits for the mode to end rather than fail the scheduled
+// purge every minute.
+func (s readOnlyStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	if s.mode.Err() != nil {
+		return 0, nil
+	}
+	n, err := s.Store.PurgeDeleted(ctx, cutoff)
+	s.mode.record(ctx, err)
+	return n, err
+}
+
+// readOnlyAtomic refuses atomic batches and imports while mode is on.
+type readOnlyAtomic struct {
+	atomicStore
+	mode *readOnlyMode
+}
+
+func (a readOnlyAtomic) Atomically(ctx context.Context, fn func(Store) error) error {
+	if err := a.mode.Err(); err != nil {
+		return err
+	}
+	return a.atomicStore.Atomically(ctx, fn)
+}
+
+// readOnlyHandler serves GET and POST /admin/readonly.
+func (s *Server) readOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeResponse(w, r, http.StatusOK, s.readOnly.State())
+	case http.MethodPost:
+		var req ReadOnlyRequest
+		if !decodeValid(w, r, &req) {
+			return
+		}
+		caller := callerFrom(r)
+		s.readOnly.Set(req.Enabled, caller)
+		loggerFrom(r.Context()).Warn("read-only mode set", slog.Bool("enabled", req.Enabled), slog.String("set_by", caller))
+		writeResponse(w, r, http.StatusOK, s.readOnly.State())
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// failingWriteStore is a memory store whose writes, and write probe, fail
+// while failWrites is set, like a full disk behind the data file.
+type failingWriteStore struct {
+	*memoryStore
+	failWrites atomic.Bool
+}
+
+var errDiskFull = errors.New("no space left on device")
+
+func (s *failingWriteStore) Create(ctx context.Context, item Item) (Item, error) {
+	if s.failWrites.Load() {
+		return Item{}, errDiskFull
+	}
+	return s.memoryStore.Create(ctx, item)
+}
+
+func (s *failingWriteStore) Delete(ctx context.Context, id string) error {
+	if s.failWrites.Load() {
+		return errDiskFull
+	}
+	return s.memoryStore.Delete(ctx, id)
+}
+
+func (s *failingWriteStore) ProbeWrite(ctx context.Context) error {
+	if s.failWrites.Load() {
+		return errDiskFull
+	}
+	return nil
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ReadOnlyAfterFailures = 3
+	cfg.CircuitBreakerOpenDuration = 0
+	cfg.WarmupTimeout = 0
+	store := &failingWriteStore{memoryStore: newMemoryStore()}
+	var logs bytes.Buffer
+	srv, h := startTestServer(t, cfg, slog.New(slog.NewJSONHandler(&logs, nil)), store)
+	srv.warmUp(context.Background())
+	item := createItem(t, h, `{"name": "kept"}`)
+
+	store.failWrites.Store(true)
+	for i := range 3 {
+		if rec := serve(h, newRequest(t, "POST", "/api/v1/items", `{"name": "x"}`)); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("write %d with the store failing = %d, want 500", i, rec.Code)
+		}
+	}
+	if lines := logLines(t, &logs, "item store is read-only after write failures"); len(lines) != 1 || lines[0]["consecutive_failures"] != float64(3) {
+		t.Errorf("transition logs = %v, want one after 3 failures", lines)
+	}
+	if testutil.ToFloat64(storeReadOnly) != 1 {
+		t.Error("store_read_only gauge isn't set")
+	}
+
+	// Writes are refused without touching the store; reads still work.
+	for _, r := range []*http.Request{
+		newRequest(t, "POST", "/api/v1/items", `{"name": "x"}`),
+		newRequest(t, "PUT", "/api/v1/items/"+item.ID, `{"name": "x"}`),
+		newRequest(t, "DELETE", "/api/v1/items/"+item.ID, ""),
+	} {
+		checkRetryAfter(t, serve(h, r), http.StatusServiceUnavailable, codeReadOnly, retryAfterSeconds(cfg.ReadOnlyProbeInterval))
+	}
+	for _, target := range []string{"/api/v1/items/" + item.ID, "/api/v1/items", "/api/v1/items/export"} {
+		if rec := serve(h, newRequest(t, "GET", target, "")); rec.Code != http.StatusOK {
+			t.Errorf("GET %s in read-only mode = %d, want 200", target, rec.Code)
+		}
+	}
+	var ready HealthResponse
+	rec := serve(h, newRequest(t, "GET", "/readyz", ""))
+	json.Unmarshal(rec.Body.Bytes(), &ready)
+	if rec.Code != http.StatusOK || ready.Status != "degraded" || ready.Checks["read_only"] != "enabled by write_failures" {
+		t.Errorf("/readyz = %d %s, want 200 degraded", rec.Code, rec.Body)
+	}
+	var stats struct {
+		ReadOnly ReadOnlyState `json:"read_only"`
+	}
+	json.Unmarshal(serve(h, newRequest(t, "GET", "/stats", "")).Body.Bytes(), &stats)
+	if !stats.ReadOnly.Enabled || stats.ReadOnly.SetBy != readOnlyAuto || stats.ReadOnly.LastError != errDiskFull.Error() {
+		t.Errorf("/stats read_only = %+v, want enabled on write failures", stats.ReadOnly)
+	}
+
+	// The probe keeps the mode while the store fails, and ends it after.
+	if err := srv.readOnly.probe(context.Background()); err == nil || !srv.readOnly.State().Enabled {
+		t.Fatalf("probe with the store failing = %v, mode %+v; want it kept", err, srv.readOnly.State())
+	}
+	store.failWrites.Store(false)
+	if err := srv.readOnly.probe(context.Background()); err != nil || srv.readOnly.State().Enabled {
+		t.Fatalf("probe with the store back = %v, mode %+v; want it ended", err, srv.readOnly.State())
+	}
+	if len(logLines(t, &logs, "item store accepts writes again, leaving read-only mode")) != 1 {
+		t.Error("leaving read-only mode wasn't logged")
+	}
+	if testutil.ToFloat64(storeReadOnly) != 0 {
+		t.Error("store_read_only gauge is still set")
+	}
+	createItem(t, h, `{"name": "after"}`)
+}
+
+func TestReadOnlyModeByAdmin(t *testing.T) {
+	keys, err := parseAPIKeys([]string{"ops:s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.APIKeys = keys
+	store := &failingWriteStore{memoryStore: newMemoryStore()}
+	srv, h := startTestServer(t, cfg, slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil)), store)
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := newRequest(t, method, target, body)
+		r.Header.Set(apiKeyHeader, "s3cret")
+		return serve(h, r)
+	}
+	set := func(enabled bool) ReadOnlyState {
+		t.Helper()
+		body := `{"enabled": false}`
+		if enabled {
+			body = `{"enabled": true}`
+		}
+		rec := send("POST", "/admin/readonly", body)
+		var state ReadOnlyState
+		if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("POST /admin/readonly = %d %s", rec.Code, rec.Body)
+		}
+		return state
+	}
+
+	if state := set(true); !state.Enabled || state.SetBy != "api_key:ops" {
+		t.Errorf("state = %+v, want enabled by the caller", state)
+	}
+	if rec := send("POST", "/api/v1/items", `{"name": "x"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST in read-only mode = %d, want 503", rec.Code)
+	}
+	// The probe only ends a mode entered on write failures.
+	srv.readOnly.probe(context.Background())
+	if !srv.readOnly.State().Enabled {
+		t.Error("the probe ended a mode set by an operator")
+	}
+	if state := set(false); state.Enabled {
+		t.Errorf("state = %+v after disabling, want off", state)
+	}
+	if rec := send("POST", "/api/v1/items", `{"name": "x"}`); rec.Code != http.StatusCreated {
+		t.Errorf("POST after read-only mode = %d, want 201", rec.Code)
+	}
+}
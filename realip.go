@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientAddr is who sent a request, as resolved by withRealIP. Source names
+// the header the IP came from, or "RemoteAddr" when it's the peer itself.
+type clientAddr struct {
+	IP     string
+	Source string
+}
+
+type clientAddrKey struct{}
+
+// withRealIP resolves the client IP once per request, so logging, rate
+// limiting, and handlers agree on it. Forwarding headers are only believed
+// when the immediate peer is in trusted; otherwise anyone could claim any
+// address.
+func withRealIP(next http.Handler, trusted []netip.Prefix) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := resolveClientAddr(r, trusted)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, addr)))
+	})
+}
+
+// clientAddrFrom returns the address withRealIP resolved for r, or r's peer
+// when the middleware didn't run.
+func clientAddrFrom(r *http.Request) clientAddr {
+	if addr, ok := r.Context().Value(clientAddrKey{}).(clientAddr); ok {
+		return addr
+	}
+	return clientAddr{IP: hostOnly(r.RemoteAddr), Source: "RemoteAddr"}
+}
+
+// clientIP is the IP of clientAddrFrom.
+func clientIP(r *http.Request) string {
+	return clientAddrFrom(r).IP
+}
+
+// resolveClientAddr returns the client behind a trusted peer from, in order
+// of preference, X-Forwarded-For, Forwarded, or X-Real-IP. In the list
+// headers the rightmost entry that isn't itself a trusted proxy is the
+// client, since everything to its left was supplied by the client.
+func resolveClientAddr(r *http.Request, trusted []netip.Prefix) clientAddr {
+	host := hostOnly(r.RemoteAddr)
+	peer, ok := parseIP(host)
+	if !ok || !inPrefixes(peer, trusted) {
+		return clientAddr{IP: host, Source: "RemoteAddr"}
+	}
+
+	if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+		if ip, ok := rightmostUntrusted(strings.Split(strings.Join(hops, ","), ","), trusted); ok {
+			return clientAddr{IP: ip.String(), Source: "X-Forwarded-For"}
+		}
+	}
+	if fwd := r.Header.Values("Forwarded"); len(fwd) > 0 {
+		if ip, ok := rightmostUntrusted(forwardedFor(strings.Join(fwd, ",")), trusted); ok {
+			return clientAddr{IP: ip.String(), Source: "Forwarded"}
+		}
+	}
+	if ip, ok := parseIP(r.Header.Get("X-Real-IP")); ok {
+		return clientAddr{IP: ip.String(), Source: "X-Real-IP"}
+	}
+	return clientAddr{IP: host, Source: "RemoteAddr"}
+}
+
+// rightmostUntrusted walks hops from the right, past trusted proxies. If
+// every hop is trusted the leftmost is the client; an unparsable hop stops
+// the walk at the last good one, as nothing beyond it can be believed.
+func rightmostUntrusted(hops []string, trusted []netip.Prefix) (netip.Addr, bool) {
+	var last netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseIP(hops[i])
+		if !ok {
+			break
+		}
+		if !inPrefixes(addr, trusted) {
+			return addr, true
+		}
+		last = addr
+	}
+	return last, last.IsValid()
+}
+
+// forwardedFor returns the for= values of an RFC 7239 Forwarded header, one
+// per forwarded element.
+func forwardedFor(header string) []string {
+	var hops []string
+	for _, element := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "for") {
+				hops = append(hops, strings.Trim(strings.TrimSpace(value), `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// parseIP parses an IPv4 or IPv6 address, with or without a port, brackets,
+// or zone: "192.0.2.1", "192.0.2.1:80", "2001:db8::1", "[2001:db8::1]:80".
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// hostOnly strips the port from a RemoteAddr. Addresses without one, such
+// as Unix socket peers, are returned as is.
+func hostOnly(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func inPrefixes(addr netip.Addr, prefixes []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPResponse is GET /ip.
+type IPResponse struct {
+	RemoteAddr string `json:"remote_addr"`
+	ClientIP   string `json:"client_ip"`
+	Source     string `json:"source"`
+}
+
+// ipHandler reports the peer address and the client IP resolved from it.
+func (s *Server) ipHandler(w http.ResponseWriter, r *http.Request) {
+	addr := clientAddrFrom(r)
+	writeResponse(w, r, http.StatusOK, IPResponse{RemoteAddr: r.RemoteAddr, ClientIP: addr.IP, Source: addr.Source})
+}
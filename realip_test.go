@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{" 192.0.2.1 ", "192.0.2.1"},
+		{"192.0.2.1:8080", "192.0.2.1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1%eth0"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"[::ffff:192.0.2.1]:80", "192.0.2.1"},
+		{"", ""},
+		{"unknown", ""},
+		{"_hidden", ""},
+		{"192.0.2.256", ""},
+		// Unbracketed, a port can't be told from the last group.
+		{"2001:db8::1:443", "2001:db8::1:443"},
+	}
+	for _, tt := range tests {
+		addr, ok := parseIP(tt.in)
+		if got := addr.String(); ok != (tt.want != "") || ok && got != tt.want {
+			t.Errorf("parseIP(%q) = %s, %v, want %q", tt.in, got, ok, tt.want)
+		}
+	}
+}
+
+func TestResolveClientAddr(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		wantIP     string
+		wantSource string
+	}{
+		{"untrusted peer", "203.0.113.7:5000", nil, "203.0.113.7", "RemoteAddr"},
+		{"untrusted IPv6 peer", "[2001:db8::7]:5000", nil, "2001:db8::7", "RemoteAddr"},
+		{"peer without a port", "@", nil, "@", "RemoteAddr"},
+		{"spoofed X-Forwarded-For from an untrusted peer", "203.0.113.7:5000",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}, "Forwarded": {"for=198.51.100.2"}, "X-Real-Ip": {"198.51.100.3"}},
+			"203.0.113.7", "RemoteAddr"},
+		{"trusted peer without headers", "10.1.2.3:5000", nil, "10.1.2.3", "RemoteAddr"},
+		{"trusted peer", "10.1.2.3:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"trusted IPv6 peer", "[fd00::1]:5000", http.Header{"X-Forwarded-For": {"2001:db8::9"}}, "2001:db8::9", "X-Forwarded-For"},
+		{"rightmost untrusted hop", "10.1.2.3:5000",
+			http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.9.9.9"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"hops across repeated headers", "10.1.2.3:5000",
+			http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1", "10.9.9.9"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"client-supplied hop left of the client is ignored", "10.1.2.3:5000",
+			http.Header{"X-Forwarded-For": {"10.0.0.1, 198.51.100.1"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"every hop trusted", "10.1.2.3:5000", http.Header{"X-Forwarded-For": {"10.0.0.5, 10.0.0.6"}}, "10.0.0.5", "X-Forwarded-For"},
+		{"hops with ports", "10.1.2.3:5000", http.Header{"X-Forwarded-For": {"198.51.100.1:1234, [fd00::2]:80"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"garbage stops the walk", "10.1.2.3:5000",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, garbage, 10.0.0.5"}}, "10.0.0.5", "X-Forwarded-For"},
+		{"unparsable X-Forwarded-For falls back", "10.1.2.3:5000",
+			http.Header{"X-Forwarded-For": {"garbage"}, "X-Real-Ip": {"198.51.100.3"}}, "198.51.100.3", "X-Real-IP"},
+		{"Forwarded", "10.1.2.3:5000", http.Header{"Forwarded": {`for=198.51.100.2;proto=https;by=10.0.0.1`}}, "198.51.100.2", "Forwarded"},
+		{"Forwarded IPv6 with port", "10.1.2.3:5000", http.Header{"Forwarded": {`for="[2001:db8::2]:4711"`}}, "2001:db8::2", "Forwarded"},
+		{"Forwarded rightmost untrusted", "10.1.2.3:5000",
+			http.Header{"Forwarded": {"for=1.1.1.1, For=198.51.100.2", "for=10.0.0.7"}}, "198.51.100.2", "Forwarded"},
+		{"Forwarded obfuscated", "10.1.2.3:5000", http.Header{"Forwarded": {"for=_hidden"}, "X-Real-Ip": {"198.51.100.3"}}, "198.51.100.3", "X-Real-IP"},
+		{"X-Forwarded-For over Forwarded", "10.1.2.3:5000",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}, "Forwarded": {"for=198.51.100.2"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"X-Real-IP", "10.1.2.3:5000", http.Header{"X-Real-Ip": {"198.51.100.3"}}, "198.51.100.3", "X-Real-IP"},
+		{"unparsable X-Real-IP", "10.1.2.3:5000", http.Header{"X-Real-Ip": {"nope"}}, "10.1.2.3", "RemoteAddr"},
+		{"IPv4-mapped trusted peer", "[::ffff:10.1.2.3]:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1", "X-Forwarded-For"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remoteAddr, Header: tt.header}
+			if r.Header == nil {
+				r.Header = http.Header{}
+			}
+			if got := resolveClientAddr(r, trusted); got.IP != tt.wantIP || got.Source != tt.wantSource {
+				t.Errorf("resolveClientAddr = %+v, want %s from %s", got, tt.wantIP, tt.wantSource)
+			}
+		})
+	}
+
+	r := &http.Request{RemoteAddr: "10.1.2.3:5000", Header: http.Header{"X-Forwarded-For": {"198.51.100.1"}}}
+	if got := resolveClientAddr(r, nil); got.IP != "10.1.2.3" || got.Source != "RemoteAddr" {
+		t.Errorf("with no trusted proxies = %+v, want the peer", got)
+	}
+}
+
+func TestIPEndpoint(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) {
+		cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	})
+	for _, tt := range []struct {
+		remoteAddr, wantIP, wantSource string
+	}{
+		{"10.1.2.3:5000", "198.51.100.1", "X-Forwarded-For"},
+		{"203.0.113.7:5000", "203.0.113.7", "RemoteAddr"},
+	} {
+		r := newRequest(t, "GET", "/ip", "")
+		r.RemoteAddr = tt.remoteAddr
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		rec := serve(h, r)
+		var body IPResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET /ip = %d %s", rec.Code, rec.Body)
+		}
+		if body.RemoteAddr != tt.remoteAddr || body.ClientIP != tt.wantIP || body.Source != tt.wantSource {
+			t.Errorf("GET /ip from %s = %+v, want %s from %s", tt.remoteAddr, body, tt.wantIP, tt.wantSource)
+		}
+	}
+}
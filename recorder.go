@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// recorderPath is where the recorder serves what it keeps. Requests under
+// it are never recorded, so listing the buffer doesn't push entries out.
+const recorderPath = "/debug/requests"
+
+// maxReplayDifferences caps the differences a replay reports.
+const maxReplayDifferences = 100
+
+// replayIgnoredHeaders differ on every response, so a replay never reports
+// them.
+var replayIgnoredHeaders = []string{"Date", requestIDHeader, "Server-Timing"}
+
+// RecordedSummary is one exchange in GET /debug/requests.
+type RecordedSummary struct {
+	ID         string    `json:"id"`
+	RequestID  string    `json:"request_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// RecordedMessage is the headers and body of one side of an exchange.
+// Credential headers are redacted, and bodies that aren't valid UTF-8 are
+// base64 encoded.
+type RecordedMessage struct {
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding"`
+	// BodyBytes is the whole body's size. Truncated means Body stops
+	// short: the body was over RECORDER_MAX_BODY_BYTES or, for a request,
+	// the handler didn't read all of it.
+	BodyBytes int64 `json:"body_bytes"`
+	Truncated bool  `json:"truncated"`
+}
+
+// RecordedExchange is GET /debug/requests/{id}.
+type RecordedExchange struct {
+	RecordedSummary
+	ClientIP string          `json:"client_ip"`
+	Request  RecordedMessage `json:"request"`
+	Response RecordedMessage `json:"response"`
+}
+
+// ReplayDifference is one way a replayed response differs from the
+// recorded one. Field is "status", "header", or "body"; Path is the header
+// name or, within a JSON body, a JSON Pointer. A null Original or Replayed
+// means the header or value is missing from that response.
+type ReplayDifference struct {
+	Field    string `json:"field"`
+	Path     string `json:"path,omitempty"`
+	Original any    `json:"original"`
+	Replayed any    `json:"replayed"`
+}
+
+// ReplayResponse is POST /debug/requests/{id}/replay.
+type ReplayResponse struct {
+	ID          string             `json:"id"`
+	RequestID   string             `json:"request_id"`
+	Status      int                `json:"status"`
+	DurationMS  float64            `json:"duration_ms"`
+	Response    RecordedMessage    `json:"response"`
+	Identical   bool               `json:"identical"`
+	Differences []ReplayDifference `json:"differences"`
+	// DifferencesTruncated means there were more than
+	// maxReplayDifferences.
+	DifferencesTruncated bool `json:"differences_truncated,omitempty"`
+}
+
+// recordedMessage is a RecordedMessage before encoding.
+type recordedMessage struct {
+	header    http.Header
+	body      []byte
+	size      int64
+	truncated bool
+}
+
+func (m recordedMessage) view() RecordedMessage {
+	out := RecordedMessage{Headers: m.header, BodyBytes: m.size, Truncated: m.truncated}
+	if utf8.Valid(m.body) {
+		out.Body, out.BodyEncoding = string(m.body), "utf-8"
+	} else {
+		out.Body, out.BodyEncoding = base64.StdEncoding.EncodeToString(m.body), "base64"
+	}
+	return out
+}
+
+// recordedExchange is one entry of the buffer. It isn't changed once
+// added.
+type recordedExchange struct {
+	RecordedSummary
+	clientIP string
+	host     string
+	request  recordedMessage
+	response recordedMessage
+	// preset is the response headers set outside the recorder before the
+	// handlers ran, which a replay starts from so that its headers compare
+	// with the recorded ones.
+	preset http.Header
+	// handler is what the request went through after the recorder, which
+	// a replay goes through again.
+	handler http.Handler
+}
+
+// recorder keeps the last exchanges, oldest overwritten first, for
+// ENABLE_RECORDER.
+type recorder struct {
+	size    int
+	maxBody int64
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries []*recordedExchange
+	next    int
+	seq     uint64
+}
+
+func newRecorder(size int, maxBody int64, now func() time.Time) *recorder {
+	return &recorder{size: size, maxBody: maxBody, now: now, entries: make([]*recordedExchange, 0, size)}
+}
+
+// add numbers ex and puts it in the buffer, evicting the oldest entry when
+// full.
+func (rec *recorder) add(ex *recordedExchange) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.seq++
+	ex.ID = strconv.FormatUint(rec.seq, 10)
+	if len(rec.entries) < rec.size {
+		rec.entries = append(rec.entries, ex)
+		return
+	}
+	rec.entries[rec.next] = ex
+	rec.next = (rec.next + 1) % len(rec.entries)
+}
+
+// list returns the entries newest first.
+func (rec *recorder) list() []*recordedExchange {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	out := make([]*recordedExchange, 0, len(rec.entries))
+	for i := range len(rec.entries) {
+		out = append(out, rec.entries[(rec.next+len(rec.entries)-1-i)%len(rec.entries)])
+	}
+	return out
+}
+
+func (rec *recorder) get(id string) (*recordedExchange, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, ex := range rec.entries {
+		if ex.ID == id {
+			return ex, true
+		}
+	}
+	return nil, false
+}
+
+// record adds every request that passes through, except the recorder's own
+// endpoints and the long-lived routes whose timeout is zero, to the buffer
+// once it has been answered. It returns next unchanged when rec is nil.
+func (rec *recorder) record(next http.Handler, timeouts map[string]time.Duration) http.Handler {
+	if rec == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if t, ok := timeouts[path]; (ok && t == 0) || path == recorderPath || strings.HasPrefix(path, recorderPath+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ex := &recordedExchange{
+			RecordedSummary: RecordedSummary{
+				RequestID: requestIDFrom(r.Context()),
+				Timestamp: rec.now().UTC(),
+				Method:    r.Method,
+				Path:      r.URL.RequestURI(),
+			},
+			clientIP: clientIP(r),
+			host:     r.Host,
+			request:  recordedMessage{header: redactHeaders(r.Header)},
+			preset:   w.Header().Clone(),
+			handler:  next,
+		}
+		var body *teeBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &teeBody{ReadCloser: r.Body, max: rec.maxBody}
+			r.Body = body
+		}
+		rw := &recordingWriter{ResponseWriter: w, max: rec.maxBody}
+		next.ServeHTTP(rw, r)
+
+		ex.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		if body != nil {
+			// A body of known length can be read to its end without the
+			// reader reporting EOF.
+			complete := body.eof || (r.ContentLength >= 0 && body.n >= r.ContentLength)
+			ex.request.body, ex.request.size = body.buf.Bytes(), body.n
+			ex.request.truncated = body.n > int64(body.buf.Len()) || !complete
+		}
+		if rw.header == nil {
+			rw.header = w.Header().Clone()
+			rw.status = http.StatusOK
+		}
+		ex.Status = rw.status
+		ex.response = recordedMessage{header: redactHeaders(rw.header), body: rw.buf.Bytes(), size: rw.n,
+			truncated: rw.n > int64(rw.buf.Len())}
+		rec.add(ex)
+	})
+}
+
+// redactHeaders copies h with the credential headers replaced by
+// redactedValue, so secrets never reach the buffer.
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range echoRedactedHeaders {
+		if values, ok := out[http.CanonicalHeaderKey(name)]; ok {
+			out[http.CanonicalHeaderKey(name)] = slices.Repeat([]string{redactedValue}, len(values))
+		}
+	}
+	return out
+}
+
+// teeBody keeps the first max bytes the handler reads from a request body.
+type teeBody struct {
+	io.ReadCloser
+	max int64
+	buf bytes.Buffer
+	n   int64
+	eof bool
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.max - int64(b.buf.Len()); room > 0 {
+		b.buf.Write(p[:min(int64(n), room)])
+	}
+	b.n += int64(n)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// recordingWriter keeps the status, the headers as sent, and the first max
+// bytes of a response body.
+type recordingWriter struct {
+	http.ResponseWriter
+	max    int64
+	status int
+	header http.Header
+	buf    bytes.Buffer
+	n      int64
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	// Informational responses precede the real one; only record the final
+	// one.
+	if w.header == nil && status >= 200 {
+		w.status, w.header = status, w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if room := w.max - int64(w.buf.Len()); room > 0 {
+		w.buf.Write(p[:min(int64(n), room)])
+	}
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recordedRequestsHandler serves GET /debug/requests, newest first.
+// ?request_id= keeps the exchange with that X-Request-ID.
+func (s *Server) recordedRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := r.URL.Query().Get("request_id")
+	out := []RecordedSummary{}
+	for _, ex := range s.recorder.list() {
+		if requestID == "" || ex.RequestID == requestID {
+			out = append(out, ex.RecordedSummary)
+		}
+	}
+	writeResponse(w, r, http.StatusOK, out)
+}
+
+// recordedRequest finds the exchange named by the {id} path parameter,
+// answering 404 when it isn't there.
+func (s *Server) recordedRequest(w http.ResponseWriter, r *http.Request) (*recordedExchange, bool) {
+	ex, ok := s.recorder.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Recorded request not found",
+			fmt.Sprintf("only the last %d requests are kept", s.recorder.size))
+	}
+	return ex, ok
+}
+
+// recordedRequestHandler serves GET /debug/requests/{id}.
+func (s *Server) recordedRequestHandler(w http.ResponseWriter, r *http.Request) {
+	ex, ok := s.recordedRequest(w, r)
+	if !ok {
+		return
+	}
+	writeResponse(w, r, http.StatusOK, RecordedExchange{
+		RecordedSummary: ex.RecordedSummary,
+		ClientIP:        ex.clientIP,
+		Request:         ex.request.view(),
+		Response:        ex.response.view(),
+	})
+}
+
+// replayRequestHandler serves POST /debug/requests/{id}/replay. The
+// recorded request goes through the same handlers again, with the caller's
+// credentials in place of the redacted ones, and the answer is compared
+// with the recorded one. Anything the request changed, it changes again.
+func (s *Server) replayRequestHandler(w http.ResponseWriter, r *http.Request) {
+	ex, ok := s.recordedRequest(w, r)
+	if !ok {
+		return
+	}
+	if ex.request.truncated {
+		writeError(w, r, http.StatusConflict, codeNotReplayable, "Request cannot be replayed",
+			"only part of its body was recorded")
+		return
+	}
+	// A fresh log info keeps the replay from overwriting this request's
+	// route and credentials in the access log.
+	ctx := context.WithValue(r.Context(), logInfoKey{}, &requestLogInfo{})
+	req, err := http.NewRequestWithContext(ctx, ex.Method, ex.Path, bytes.NewReader(ex.request.body))
+	if err != nil {
+		writeError(w, r, http.StatusConflict, codeNotReplayable, "Request cannot be replayed", err.Error())
+		return
+	}
+	req.Header = ex.request.header.Clone()
+	for _, name := range echoRedactedHeaders {
+		req.Header.Del(name)
+		if values := r.Header.Values(name); len(values) > 0 {
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	requestID := requestIDFrom(ctx)
+	req.Header.Set(requestIDHeader, requestID)
+	req.RemoteAddr = r.RemoteAddr
+	req.Host = ex.host
+	req.TLS = r.TLS
+
+	rw := &batchRecorder{header: ex.preset.Clone()}
+	rw.header.Set(requestIDHeader, requestID)
+	start := time.Now()
+	ex.handler.ServeHTTP(rw, req)
+	duration := time.Since(start)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	body := rw.body.Bytes()
+	replayed := recordedMessage{header: redactHeaders(rw.header), body: body[:min(int64(len(body)), s.recorder.maxBody)],
+		size: int64(len(body)), truncated: int64(len(body)) > s.recorder.maxBody}
+
+	diff := &replayDiff{}
+	if ex.Status != rw.status {
+		diff.add("status", "", ex.Status, rw.status)
+	}
+	diff.headers(ex.response.header, replayed.header)
+	diff.body(ex.response, replayed)
+	writeResponse(w, r, http.StatusOK, ReplayResponse{
+		ID:                   ex.ID,
+		RequestID:            requestID,
+		Status:               rw.status,
+		DurationMS:           float64(duration.Microseconds()) / 1000,
+		Response:             replayed.view(),
+		Identical:            len(diff.out) == 0,
+		Differences:          diff.out,
+		DifferencesTruncated: diff.truncated,
+	})
+}
+
+// replayDiff collects the differences between two responses.
+type replayDiff struct {
+	out       []ReplayDifference
+	truncated bool
+}
+
+func (d *replayDiff) add(field, path string, original, replayed any) {
+	if len(d.out) == maxReplayDifferences {
+		d.truncated = true
+		return
+	}
+	d.out = append(d.out, ReplayDifference{Field: field, Path: path, Original: original, Replayed: replayed})
+}
+
+// headers compares every header but replayIgnoredHeaders.
+func (d *replayDiff) headers(original, replayed http.Header) {
+	names := slices.Collect(maps.Keys(original))
+	for name := range replayed {
+		if _, ok := original[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if slices.ContainsFunc(replayIgnoredHeaders, func(h string) bool { return http.CanonicalHeaderKey(h) == name }) {
+			continue
+		}
+		a, b := original[name], replayed[name]
+		if slices.Equal(a, b) {
+			continue
+		}
+		d.add("header", name, headerValue(a), headerValue(b))
+	}
+}
+
+// headerValue is nil for a missing header, its value for one value, and
+// the values otherwise.
+func headerValue(values []string) any {
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		return values[0]
+	}
+	return values
+}
+
+// body compares JSON bodies value by value, other than the top-level
+// request_id, and other bodies whole.
+func (d *replayDiff) body(original, replayed recordedMessage) {
+	var a, b any
+	if !original.truncated && !replayed.truncated && decodeNumbers(original.body, &a) == nil && decodeNumbers(replayed.body, &b) == nil {
+		if ma, ok := a.(map[string]any); ok {
+			delete(ma, "request_id")
+		}
+		if mb, ok := b.(map[string]any); ok {
+			delete(mb, "request_id")
+		}
+		d.json("", a, b)
+		return
+	}
+	if !bytes.Equal(original.body, replayed.body) {
+		d.add("body", "", original.view().Body, replayed.view().Body)
+	}
+}
+
+// json compares two decoded JSON values at the JSON Pointer path.
+func (d *replayDiff) json(path string, a, b any) {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			keys := slices.Collect(maps.Keys(a))
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				d.json(path+"/"+jsonPointerEscaper.Replace(k), a[k], b[k])
+			}
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			for i := range max(len(a), len(b)) {
+				var x, y any
+				if i < len(a) {
+					x = a[i]
+				}
+				if i < len(b) {
+					y = b[i]
+				}
+				d.json(path+"/"+strconv.Itoa(i), x, y)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		d.add("body", path, a, b)
+	}
+}
+
+// jsonPointerEscaper escapes an object key for a JSON Pointer (RFC 6901).
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// decodeNumbers unmarshals JSON keeping numbers as written.
+func decodeNumbers(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("trailing data after JSON value")
+	}
+	return nil
+}
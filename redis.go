@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisIdempotencyPendingTTL is how long a key stays claimed by a
+	// request still running. A replica that dies mid-request releases it
+	// after this rather than after IDEMPOTENCY_TTL.
+	redisIdempotencyPendingTTL = time.Minute
+	// redisIdempotencyPoll is how often a duplicate checks whether the
+	// request holding its key has finished.
+	redisIdempotencyPoll = 50 * time.Millisecond
+	// redisStoreRetries bounds the optimistic retries of an update that
+	// keeps racing other writers.
+	redisStoreRetries = 10
+)
+
+// openRedis parses a redis:// or rediss:// URL. Connections are made on
+// first use, so an unreachable server shows up in /readyz rather than at
+// startup.
+func openRedis(redisURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(opts), nil
+}
+
+// redisCheck is the /readyz check for the shared Redis connection.
+type redisCheck struct {
+	client *redis.Client
+}
+
+func (c redisCheck) Name() string { return "redis" }
+
+func (c redisCheck) Check(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// redisFailed records a failed Redis call by what it was for.
+func redisFailed(component string) {
+	redisErrorsTotal.WithLabelValues(component).Inc()
+}
+
+// redisTokenBucket is rateLimiter.Allow as a script, so replicas sharing a
+// key take tokens from one bucket. It returns whether a token was taken and
+// the tokens left, as a string since Redis truncates Lua numbers. Idle
+// buckets expire once they would have refilled.
+var redisTokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// redisRateLimit takes a token from key's shared bucket, returning the
+// tokens left.
+func redisRateLimit(ctx context.Context, client *redis.Client, key string, rps, burst float64, now time.Time) (bool, float64, error) {
+	res, err := redisTokenBucket.Run(ctx, client, []string{key},
+		strconv.FormatFloat(rps, 'f', -1, 64), strconv.FormatFloat(burst, 'f', -1, 64), now.UnixMilli()).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", res)
+	}
+	allowed, _ := res[0].(int64)
+	tokens, _ := res[1].(string)
+	left, err := strconv.ParseFloat(tokens, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", res)
+	}
+	return allowed == 1, left, nil
+}
+
+// redisIdempotencyRecord is an Idempotency-Key as stored in Redis: claimed
+// while Done is false, then the response to replay.
+type redisIdempotencyRecord struct {
+	BodyHash []byte      `json:"body_hash"`
+	Done     bool        `json:"done"`
+	Status   int         `json:"status,omitempty"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     []byte      `json:"body,omitempty"`
+}
+
+// redisIdempotency shares Idempotency-Keys across replicas. Keys are
+// claimed with SET NX; duplicates poll until the holder stores its
+// response or gives the key up.
+type redisIdempotency struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func (c *redisIdempotency) redisKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.prefix + "idempotency:" + hex.EncodeToString(sum[:])
+}
+
+func (c *redisIdempotency) Begin(ctx context.Context, key string, bodyHash [sha256.Size]byte) (func(*idempotentResponse), *idempotentResponse, error) {
+	rkey := c.redisKey(key)
+	claim, _ := json.Marshal(redisIdempotencyRecord{BodyHash: bodyHash[:]})
+	owner, err := c.client.SetNX(ctx, rkey, claim, min(c.ttl, redisIdempotencyPendingTTL)).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	if owner {
+		return func(resp *idempotentResponse) { c.finish(ctx, rkey, bodyHash, resp) }, nil, nil
+	}
+
+	for {
+		raw, err := c.client.Get(ctx, rkey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// The holder failed and gave the key up.
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var rec redisIdempotencyRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return nil, nil, fmt.Errorf("decode idempotency record: %w", err)
+		}
+		if !bytes32Equal(rec.BodyHash, bodyHash) {
+			return nil, nil, errIdempotencyMismatch
+		}
+		if rec.Done {
+			return nil, &idempotentResponse{status: rec.Status, header: rec.Header, body: rec.Body}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(redisIdempotencyPoll):
+		}
+	}
+}
+
+// finish stores resp for the rest of the TTL, or releases the key. It runs
+// even if the client has gone, so duplicates aren't left waiting.
+func (c *redisIdempotency) finish(ctx context.Context, rkey string, bodyHash [sha256.Size]byte, resp *idempotentResponse) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	var err error
+	if resp == nil {
+		err = c.client.Del(ctx, rkey).Err()
+	} else {
+		rec, _ := json.Marshal(redisIdempotencyRecord{
+			BodyHash: bodyHash[:],
+			Done:     true,
+			Status:   resp.status,
+			Header:   resp.header,
+			Body:     resp.body,
+		})
+		err = c.client.Set(ctx, rkey, rec, c.ttl).Err()
+	}
+	if err != nil {
+		redisFailed("idempotency")
+		loggerFrom(ctx).Warn("cannot record idempotency key", slog.Any("error", err))
+	}
+}
+
+func bytes32Equal(b []byte, sum [sha256.Size]byte) bool {
+	return len(b) == sha256.Size && [sha256.Size]byte(b) == sum
+}
+
+// redisStore is a Store shared by every replica. Each item is a JSON value
+// under its own key, with a set of IDs for List. Updates WATCH the item so
+// concurrent writers retry instead of overwriting each other. List reads
+// every item, which suits the demo's data sizes.
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func newRedisStore(client *redis.Client, prefix string) *redisStore {
+	return &redisStore{client: client, prefix: prefix}
+}
+
+func (s *redisStore) ids() string { return s.prefix + "items" }
+
+func (s *redisStore) itemKey(id string) string { return s.prefix + "item:" + id }
+
+// Name identifies the store in readiness checks. It stands in for the
+// shared connection's check too.
+func (s *redisStore) Name() string {
+	return "redis"
+}
+
+// Check pings Redis for /readyz.
+func (s *redisStore) Check(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *redisStore) Create(ctx context.Context, item Item) (Item, error) {
+	now := time.Now().UTC()
+	item.ID = newUUID()
+	item.Tenant = tenantFrom(ctx)
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	item.Version = 1
+	value, err := json.Marshal(item)
+	if err != nil {
+		return Item{}, err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.itemKey(item.ID), value, 0)
+		pipe.SAdd(ctx, s.ids(), item.ID)
+		return nil
+	})
+	if err != nil {
+		return Item{}, fmt.Errorf("create item: %w", err)
+	}
+	return item, nil
+}
+
+func (s *redisStore) Get(ctx context.Context, id string) (Item, error) {
+	item, err := s.get(ctx, s.client, id)
+	if err == nil && item.DeletedAt != nil {
+		return Item{}, ErrNotFound
+	}
+	return item, err
+}
+
+// get reads the item with id, deleted or not, if ctx's tenant owns it.
+func (s *redisStore) get(ctx context.Context, c redis.Cmdable, id string) (Item, error) {
+	item, err := s.load(ctx, c, id)
+	if err == nil && item.Tenant != tenantFrom(ctx) {
+		return Item{}, ErrNotFound
+	}
+	return item, err
+}
+
+// load reads the item with id, whichever tenant owns it.
+func (s *redisStore) load(ctx context.Context, c redis.Cmdable, id string) (Item, error) {
+	raw, err := c.Get(ctx, s.itemKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Item{}, ErrNotFound
+	}
+	if err != nil {
+		return Item{}, fmt.Errorf("get item: %w", err)
+	}
+	var item Item
+	if err := json.Unmarshal(raw, &item); err != nil {
+		return Item{}, fmt.Errorf("decode item %s: %w", id, err)
+	}
+	return item, nil
+}
+
+// List filters and pages in process, like memoryStore.List.
+func (s *redisStore) List(ctx context.Context, q itemQuery) ([]Item, int, error) {
+	q.Tenant = tenantFrom(ctx)
+	items, err := s.matching(ctx, q.matches)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list items: %w", err)
+	}
+	return pageItems(items, q)
+}
+
+// matching reads every item, in every tenant, and keeps those keep selects.
+func (s *redisStore) matching(ctx context.Context, keep func(Item) bool) ([]Item, error) {
+	ids, err := s.client.SMembers(ctx, s.ids()).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(ids))
+	if len(ids) == 0 {
+		return items, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.itemKey(id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		// Purged between the two reads.
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var item Item
+		if err := json.Unmarshal([]byte(raw), &item); err != nil {
+			return nil, fmt.Errorf("decode item: %w", err)
+		}
+		if keep(item) {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// ListStream has to read every item to sort them, so it streams List's
+// page.
+func (s *redisStore) ListStream(ctx context.Context, q itemQuery) iter.Seq2[Item, error] {
+	return listStream(ctx, q, s.List)
+}
+
+func (s *redisStore) Update(ctx context.Context, id string, item Item) (Item, error) {
+	return s.Patch(ctx, id, func(existing Item) (Item, error) {
+		existing.Name = item.Name
+		existing.Data = item.Data
+		return existing, nil
+	})
+}
+
+// Patch applies fn and writes the result only if nobody changed the item
+// meanwhile, retrying otherwise.
+func (s *redisStore) Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	return s.modify(ctx, id, func(existing Item) (Item, error) {
+		if existing.DeletedAt != nil {
+			return Item{}, ErrNotFound
+		}
+		return fn(existing)
+	})
+}
+
+// modify is Patch for deleted items as well.
+func (s *redisStore) modify(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	key := s.itemKey(id)
+	var patched Item
+	txf := func(tx *redis.Tx) error {
+		existing, err := s.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		patched, err = fn(existing)
+		if err != nil {
+			return err
+		}
+		patched.ID = existing.ID
+		patched.Tenant = existing.Tenant
+		patched.CreatedAt = existing.CreatedAt
+		patched.UpdatedAt = time.Now().UTC()
+		patched.Version = existing.Version + 1
+		value, err := json.Marshal(patched)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.Set(ctx, key, value, 0).Err()
+		})
+		return err
+	}
+	for range redisStoreRetries {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return Item{}, err
+		}
+		return patched, nil
+	}
+	return Item{}, fmt.Errorf("update item %s: too many concurrent writers", id)
+}
+
+func (s *redisStore) Delete(ctx context.Context, id string) error {
+	_, err := s.Patch(ctx, id, func(existing Item) (Item, error) {
+		now := time.Now().UTC()
+		existing.DeletedAt = &now
+		return existing, nil
+	})
+	return err
+}
+
+// errNotDeleted stops Restore's modify from writing an item that isn't
+// deleted.
+var errNotDeleted = errors.New("item is not deleted")
+
+func (s *redisStore) Restore(ctx context.Context, id string) (Item, bool, error) {
+	var current Item
+	restored, err := s.modify(ctx, id, func(existing Item) (Item, error) {
+		if existing.DeletedAt == nil {
+			current = existing
+			return Item{}, errNotDeleted
+		}
+		existing.DeletedAt = nil
+		return existing, nil
+	})
+	if errors.Is(err, errNotDeleted) {
+		return current, false, nil
+	}
+	return restored, err == nil, err
+}
+
+// Purge WATCHes the item so it is only removed while ctx's tenant owns
+// it.
+func (s *redisStore) Purge(ctx context.Context, id string) error {
+	key := s.itemKey(id)
+	txf := func(tx *redis.Tx) error {
+		if _, err := s.get(ctx, tx, id); err != nil {
+			return err
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.SRem(ctx, s.ids(), id)
+			return nil
+		})
+		return err
+	}
+	for range redisStoreRetries {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("purge item: %w", err)
+		}
+		return err
+	}
+	return fmt.Errorf("purge item %s: too many concurrent writers", id)
+}
+
+// PurgeDeleted reads every item to find the deleted ones, like List, then
+// purges each one that is still deleted. One restored or purged meanwhile
+// is left to the next run.
+func (s *redisStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	expired := func(item Item) bool {
+		return item.DeletedAt != nil && item.DeletedAt.Before(cutoff)
+	}
+	items, err := s.matching(ctx, expired)
+	if err != nil {
+		return 0, fmt.Errorf("purge deleted items: %w", err)
+	}
+	n := 0
+	for _, item := range items {
+		key := s.itemKey(item.ID)
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			current, err := s.load(ctx, tx, item.ID)
+			if err != nil || !expired(current) {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, key)
+				pipe.SRem(ctx, s.ids(), item.ID)
+				return nil
+			})
+			if err == nil {
+				n++
+			}
+			return err
+		}, key)
+		if err != nil && !errors.Is(err, redis.TxFailedErr) && !errors.Is(err, ErrNotFound) {
+			return n, fmt.Errorf("purge deleted items: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// Close releases the connection pool.
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
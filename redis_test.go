@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// startRedis runs an in-process Redis for the test and returns its
+// REDIS_URL. Retries are off, so calls fail at once after Close.
+func startRedis(t *testing.T) (*miniredis.Miniredis, string) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return mr, "redis://" + mr.Addr() + "/0?max_retries=-1&dial_timeout=100ms"
+}
+
+// newRedisClient connects to url, closing the client when the test ends.
+func newRedisClient(t *testing.T, url string) *redis.Client {
+	t.Helper()
+	client, err := openRedis(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRedisRateLimitSharedAndFailsOpen(t *testing.T) {
+	mr, url := startRedis(t)
+	var logs bytes.Buffer
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	replica := func(logger *slog.Logger) http.Handler {
+		cfg := defaultConfig()
+		cfg.RedisURL = url
+		cfg.RateLimitRPS = 0.5
+		cfg.RateLimitBurst = 2
+		srv, h := startTestServer(t, cfg, logger, newMemoryStore())
+		srv.limiter.now = clock.now
+		return h
+	}
+	a := replica(slog.New(slog.NewJSONHandler(&logs, nil)))
+	b := replica(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	// One bucket for both replicas: the burst of 2 is spent across them.
+	for i, h := range []http.Handler{a, b} {
+		if rec := serve(h, newRequest(t, "GET", "/version", "")); rec.Code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200 within the burst", i+1, rec.Code)
+		}
+	}
+	rec := serve(a, newRequest(t, "GET", "/version", ""))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("third request = %d with Retry-After %q, want 429 with 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if keys := mr.Keys(); len(keys) != 1 || !strings.HasPrefix(keys[0], "my-go-app:ratelimit:") {
+		t.Errorf("Redis keys = %v, want one my-go-app:ratelimit: bucket", keys)
+	}
+	clock.advance(2 * time.Second)
+	if rec := serve(b, newRequest(t, "GET", "/version", "")); rec.Code != http.StatusOK {
+		t.Errorf("request after Retry-After = %d, want 200", rec.Code)
+	}
+
+	mr.Close()
+	errorsBefore := testutil.ToFloat64(redisErrorsTotal.WithLabelValues("rate_limit"))
+	for i := range 5 {
+		if rec := serve(a, newRequest(t, "GET", "/version", "")); rec.Code != http.StatusOK {
+			t.Errorf("request %d with Redis down = %d, want 200 (fail open)", i+1, rec.Code)
+		}
+	}
+	if got := testutil.ToFloat64(redisErrorsTotal.WithLabelValues("rate_limit")) - errorsBefore; got != 5 {
+		t.Errorf("redis_errors_total{component=rate_limit} rose by %v, want 5", got)
+	}
+	if !strings.Contains(logs.String(), "rate limiter unavailable, allowing request") {
+		t.Errorf("logs = %s, want the fail-open warning", logs.String())
+	}
+}
+
+func TestRedisIdempotencySharedAndFailsClosed(t *testing.T) {
+	mr, url := startRedis(t)
+	replica := func() http.Handler {
+		cfg := defaultConfig()
+		cfg.RedisURL = url
+		_, h := startTestServer(t, cfg, slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), newMemoryStore())
+		return h
+	}
+	a, b := replica(), replica()
+
+	first := postWithKey(t, a, "retry-1", `{"name": "once"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first POST = %d %s", first.Code, first.Body)
+	}
+	again := postWithKey(t, b, "retry-1", `{"name": "once"}`)
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry on the other replica = %d %s replayed=%q, want the first response replayed", again.Code, again.Body, again.Header().Get("Idempotent-Replayed"))
+	}
+	if conflict := postWithKey(t, b, "retry-1", `{"name": "different"}`); conflict.Code != http.StatusConflict {
+		t.Errorf("same key with another body = %d, want 409", conflict.Code)
+	}
+	if ttl := mr.TTL(mr.Keys()[0]); ttl <= 0 || ttl > defaultConfig().IdempotencyTTL {
+		t.Errorf("stored key TTL = %s, want at most IDEMPOTENCY_TTL", ttl)
+	}
+
+	mr.Close()
+	errorsBefore := testutil.ToFloat64(redisErrorsTotal.WithLabelValues("idempotency"))
+	down := postWithKey(t, a, "retry-2", `{"name": "twice"}`)
+	checkRetryAfter(t, down, http.StatusServiceUnavailable, codeUnavailable, int(retryAfterDefault/time.Second))
+	if got := testutil.ToFloat64(redisErrorsTotal.WithLabelValues("idempotency")) - errorsBefore; got != 1 {
+		t.Errorf("redis_errors_total{component=idempotency} rose by %v, want 1", got)
+	}
+	// Only requests carrying a key depend on Redis, and the refused one
+	// didn't run.
+	if rec := serve(a, newRequest(t, "POST", "/api/v1/items", `{"name": "unkeyed"}`)); rec.Code != http.StatusCreated {
+		t.Errorf("POST without a key while Redis is down = %d, want 201", rec.Code)
+	}
+	var list ItemList
+	json.Unmarshal(serve(a, newRequest(t, "GET", "/api/v1/items", "")).Body.Bytes(), &list)
+	if list.Total != 2 {
+		t.Errorf("replica holds %d items, want 2: the first create and the unkeyed one", list.Total)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	_, url := startRedis(t)
+	// Two replicas' stores on the same Redis.
+	a := newRedisStore(newRedisClient(t, url), "test:")
+	b := newRedisStore(newRedisClient(t, url), "test:")
+	ctx := context.Background()
+
+	created, err := a.Create(ctx, Item{Name: "shared", Data: map[string]any{"n": 1.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Version != 1 || created.CreatedAt.IsZero() {
+		t.Fatalf("created = %+v, want an ID, version 1, and timestamps", created)
+	}
+	got, err := b.Get(ctx, created.ID)
+	if err != nil || got.Name != "shared" || got.Data["n"] != 1.0 {
+		t.Fatalf("Get on the other replica = %+v, %v", got, err)
+	}
+
+	// A write from another replica between the read and the write makes
+	// the WATCH fail; Patch retries on the new version.
+	calls := 0
+	patched, err := a.Patch(ctx, created.ID, func(item Item) (Item, error) {
+		calls++
+		if calls == 1 {
+			if _, err := b.Update(ctx, created.ID, Item{Name: "renamed", Data: item.Data}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		item.Data = map[string]any{"n": item.Data["n"].(float64) + 1}
+		return item, nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Patch = %v after %d calls, want success on the retry", err, calls)
+	}
+	if patched.Name != "renamed" || patched.Data["n"] != 2.0 || patched.Version != 3 {
+		t.Errorf("patched = %+v, want the concurrent rename kept, n 2, version 3", patched)
+	}
+
+	other := withTenant(ctx, "acme")
+	if _, err := a.Get(other, created.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get from another tenant = %v, want ErrNotFound", err)
+	}
+	if err := a.Purge(other, created.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Purge from another tenant = %v, want ErrNotFound", err)
+	}
+	if _, err := a.Create(other, Item{Name: "acme's"}); err != nil {
+		t.Fatal(err)
+	}
+	if items, total, err := b.List(ctx, itemQuery{}); err != nil || total != 1 || items[0].ID != created.ID {
+		t.Errorf("List = %v, %d, %v, want only the default tenant's item", items, total, err)
+	}
+
+	if err := a.Delete(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Update(ctx, created.ID, Item{Name: "x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update after Delete = %v, want ErrNotFound", err)
+	}
+	if _, total, _ := b.List(ctx, itemQuery{IncludeDeleted: true}); total != 1 {
+		t.Errorf("List including deleted = %d items, want 1", total)
+	}
+	restored, ok, err := b.Restore(ctx, created.ID)
+	if err != nil || !ok || restored.DeletedAt != nil {
+		t.Fatalf("Restore = %+v, %v, %v", restored, ok, err)
+	}
+	if _, ok, err := b.Restore(ctx, created.ID); err != nil || ok {
+		t.Errorf("Restore of a live item = %v, %v, want not restored", ok, err)
+	}
+
+	if err := a.Delete(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.PurgeDeleted(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeDeleted before the cutoff = %d, %v, want 0", n, err)
+	}
+	if n, err := b.PurgeDeleted(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("PurgeDeleted = %d, %v, want 1", n, err)
+	}
+	if _, ok, err := a.Restore(ctx, created.ID); !errors.Is(err, ErrNotFound) || ok {
+		t.Errorf("Restore after purge = %v, %v, want ErrNotFound", ok, err)
+	}
+}
+
+func TestRedisStoreThroughAPI(t *testing.T) {
+	mr, url := startRedis(t)
+	cfg := defaultConfig()
+	cfg.RedisURL, cfg.RedisStore = url, true
+	store := newRedisStore(newRedisClient(t, url), cfg.RedisKeyPrefix)
+	_, h := startTestServer(t, cfg, slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)), store)
+
+	item := createItem(t, h, `{"name": "persisted"}`)
+	if !mr.Exists(cfg.RedisKeyPrefix + "item:" + item.ID) {
+		t.Errorf("Redis keys = %v, want the item under %sitem:%s", mr.Keys(), cfg.RedisKeyPrefix, item.ID)
+	}
+	if rec := serve(h, newRequest(t, "GET", "/api/v1/items/"+item.ID, "")); rec.Code != http.StatusOK {
+		t.Errorf("GET = %d, want 200", rec.Code)
+	}
+
+	mr.Close()
+	if rec := serve(h, newRequest(t, "GET", "/api/v1/items/"+item.ID, "")); rec.Code < 500 {
+		t.Errorf("GET with Redis down = %d, want a 5xx", rec.Code)
+	}
+	if rec := serve(h, newRequest(t, "GET", "/readyz", "")); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz with Redis down = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Registry events, the Event of each RegistryHeartbeat.
+const (
+	registryHeartbeat  = "heartbeat"
+	registryDeregister = "deregister"
+)
+
+const (
+	// registryRetryBackoff is the wait after a failed heartbeat, doubled
+	// on each failure in a row up to REGISTRY_INTERVAL.
+	registryRetryBackoff = time.Second
+	// registryResponseLimit is how much of the registry's answer
+	// /debug/registry keeps.
+	registryResponseLimit = 4 << 10
+)
+
+// RegistryHeartbeat is the body POSTed to REGISTRY_URL. Components holds
+// whatever the parts of the server that contribute to it report, by name.
+type RegistryHeartbeat struct {
+	Event     string `json:"event"`
+	Hostname  string `json:"hostname"`
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Version   string `json:"version"`
+	// Address is POD_IP, or the hostname outside Kubernetes.
+	Address string `json:"address"`
+	Port    string `json:"port"`
+	// Ready and Status are what /readyz would answer.
+	Ready      bool           `json:"ready"`
+	Status     string         `json:"status"`
+	Stats      RegistryStats  `json:"stats"`
+	Components map[string]any `json:"components,omitempty"`
+	Timestamp  time.Time      `json:"timestamp"`
+}
+
+// RegistryStats are the basic numbers each heartbeat carries.
+type RegistryStats struct {
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	TotalRequests uint64  `json:"total_requests"`
+	Goroutines    int     `json:"goroutines"`
+}
+
+// RegistryResponse is the registry's answer to a heartbeat, or the error
+// that kept it from answering.
+type RegistryResponse struct {
+	StatusCode int     `json:"status_code,omitempty"`
+	Body       string  `json:"body,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// RegistryStatus is the body of GET /debug/registry.
+type RegistryStatus struct {
+	URL      string `json:"url"`
+	Interval string `json:"interval"`
+	Sent     int    `json:"sent"`
+	Failed   int    `json:"failed"`
+	// ConsecutiveFailures is what the retry backoff doubles on.
+	ConsecutiveFailures int                `json:"consecutive_failures"`
+	LastSent            *time.Time         `json:"last_sent,omitempty"`
+	LastHeartbeat       *RegistryHeartbeat `json:"last_heartbeat,omitempty"`
+	LastResponse        *RegistryResponse  `json:"last_response,omitempty"`
+	NextSend            *time.Time         `json:"next_send,omitempty"`
+	Deregistered        bool               `json:"deregistered"`
+}
+
+type registryContributor struct {
+	name string
+	fn   func() any
+}
+
+// registryClient announces this instance to REGISTRY_URL: a heartbeat as
+// soon as it runs and then every interval, plus up to a tenth of it so
+// replicas don't beat in lockstep, and on shutdown a last POST with event
+// "deregister". A failed heartbeat is logged and counted and retried
+// sooner, after registryRetryBackoff doubled on each failure up to
+// interval. None of it touches request serving: heartbeats are sent from
+// their own goroutine, and an unreachable registry only costs log lines.
+type registryClient struct {
+	url      string
+	client   *http.Client
+	interval time.Duration
+	now      func() time.Time
+	logger   *slog.Logger
+	// instance fills in a heartbeat's fields but Event, Components, and
+	// Timestamp.
+	instance func(ctx context.Context) RegistryHeartbeat
+
+	mu           sync.Mutex
+	contributors []registryContributor
+	status       RegistryStatus
+	// done is closed once Run returns; nil until it starts.
+	done chan struct{}
+}
+
+func newRegistryClient(target string, client *http.Client, interval time.Duration, now func() time.Time, logger *slog.Logger, instance func(context.Context) RegistryHeartbeat) *registryClient {
+	shown := target
+	if u, err := url.Parse(target); err == nil {
+		shown = u.Redacted()
+	}
+	return &registryClient{
+		url:      target,
+		client:   client,
+		interval: interval,
+		now:      now,
+		logger:   logger,
+		instance: instance,
+		status:   RegistryStatus{URL: shown, Interval: interval.String()},
+	}
+}
+
+// Contribute adds fn's result to every heartbeat under Components[name].
+// fn runs on the heartbeat goroutine and must not block.
+func (rc *registryClient) Contribute(name string, fn func() any) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.contributors = append(rc.contributors, registryContributor{name, fn})
+}
+
+// Run sends heartbeats until ctx is cancelled.
+func (rc *registryClient) Run(ctx context.Context) {
+	rc.mu.Lock()
+	rc.done = make(chan struct{})
+	rc.mu.Unlock()
+	defer close(rc.done)
+	for {
+		rc.send(ctx, registryHeartbeat)
+		rc.mu.Lock()
+		wait := rc.interval
+		if n := rc.status.ConsecutiveFailures; n > 0 {
+			wait = min(registryRetryBackoff<<min(n-1, 30), rc.interval)
+		}
+		wait += rand.N(wait/10 + 1)
+		next := rc.now().Add(wait).UTC()
+		rc.status.NextSend = &next
+		rc.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			rc.mu.Lock()
+			rc.status.NextSend = nil
+			rc.mu.Unlock()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Shutdown waits for Run to stop, once its context is cancelled, and
+// deregisters the instance, giving up when ctx ends.
+func (rc *registryClient) Shutdown(ctx context.Context) error {
+	rc.mu.Lock()
+	done := rc.done
+	rc.mu.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := rc.send(ctx, registryDeregister); err != nil {
+		return err
+	}
+	rc.mu.Lock()
+	rc.status.Deregistered = true
+	rc.mu.Unlock()
+	return nil
+}
+
+// send POSTs one heartbeat with event and records the outcome.
+func (rc *registryClient) send(ctx context.Context, event string) error {
+	hb := rc.instance(ctx)
+	hb.Event = event
+	rc.mu.Lock()
+	contributors := rc.contributors
+	rc.mu.Unlock()
+	for _, c := range contributors {
+		if hb.Components == nil {
+			hb.Components = make(map[string]any, len(contributors))
+		}
+		hb.Components[c.name] = c.fn()
+	}
+	hb.Timestamp = rc.now().UTC()
+
+	start := time.Now()
+	resp, err := rc.post(ctx, hb)
+	resp.DurationMS = durationMS(time.Since(start))
+	if ctx.Err() != nil && event == registryHeartbeat {
+		// Shutting down; the deregistration says the rest.
+		return ctx.Err()
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+		resp.Error = err.Error()
+	}
+	registryRequests.WithLabelValues(event, result).Inc()
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	sent := hb.Timestamp
+	rc.status.Sent++
+	rc.status.LastSent, rc.status.LastHeartbeat, rc.status.LastResponse = &sent, &hb, &resp
+	if err != nil {
+		rc.status.Failed++
+		rc.status.ConsecutiveFailures++
+		rc.logger.Warn("registry request failed", slog.String("event", event),
+			slog.String("url", rc.status.URL), slog.Int("consecutive_failures", rc.status.ConsecutiveFailures), slog.Any("error", err))
+		return err
+	}
+	if rc.status.ConsecutiveFailures > 0 {
+		rc.logger.Info("registry reachable again", slog.String("url", rc.status.URL), slog.Int("failures", rc.status.ConsecutiveFailures))
+	}
+	rc.status.ConsecutiveFailures = 0
+	rc.logger.Debug("registry request sent", slog.String("event", event), slog.Int("status", resp.StatusCode))
+	return nil
+}
+
+// post sends hb. Anything but a 2xx is an error.
+func (rc *registryClient) post(ctx context.Context, hb RegistryHeartbeat) (RegistryResponse, error) {
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return RegistryResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rc.url, bytes.NewReader(body))
+	if err != nil {
+		return RegistryResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := rc.client.Do(req)
+	if err != nil {
+		return RegistryResponse{}, err
+	}
+	defer res.Body.Close()
+	answer, _ := io.ReadAll(io.LimitReader(res.Body, registryResponseLimit))
+	resp := RegistryResponse{StatusCode: res.StatusCode, Body: string(answer)}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return resp, fmt.Errorf("registry answered %s", res.Status)
+	}
+	return resp, nil
+}
+
+// Status reports the last heartbeat and the next one.
+func (rc *registryClient) Status() RegistryStatus {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.status
+}
+
+// registryInstance describes the server for a heartbeat.
+func (s *Server) registryInstance(ctx context.Context) RegistryHeartbeat {
+	code, health := s.readiness(ctx)
+	address := s.instance.Hostname
+	if ip := os.Getenv("POD_IP"); ip != "" {
+		address = ip
+	}
+	hb := RegistryHeartbeat{
+		Hostname:  s.instance.Hostname,
+		Pod:       s.instance.Pod,
+		Namespace: s.instance.Namespace,
+		Version:   s.build.Version,
+		Address:   address,
+		Port:      s.cfg.Port,
+		Ready:     code == http.StatusOK,
+		Status:    health.Status,
+		Stats: RegistryStats{
+			UptimeSeconds: s.clock.Now().Sub(s.started).Seconds(),
+			Goroutines:    runtime.NumGoroutine(),
+		},
+	}
+	for _, rs := range httpStats.Routes() {
+		hb.Stats.TotalRequests += rs.Total
+	}
+	return hb
+}
+
+// registryHandler serves GET /debug/registry.
+func (s *Server) registryHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, s.registry.Status())
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// configWatchInterval is how often CONFIG_FILE is checked for changes.
+const configWatchInterval = 5 * time.Second
+
+// reloadableFields are the Config fields applied to the running server on
+// reload. Changes to any other field are logged as needing a restart.
+var reloadableFields = []string{
+	"LogLevel",
+	"RateLimitRPS",
+	"RateLimitBurst",
+	"RateLimitExempt",
+	"CORSAllowedOrigins",
+	"Features",
+	"Maintenance",
+	"MaintenanceMessage",
+	"MaintenanceRetryAfter",
+	"MaintenanceFailReadiness",
+}
+
+// configChange is one setting that differs between two configurations.
+type configChange struct {
+	Field string
+	Old   any
+	New   any
+}
+
+// diffConfig lists the fields that differ between old and next, with
+// secrets redacted.
+func diffConfig(old, next Config) []configChange {
+	var changes []configChange
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(next)
+	for i := range ov.NumField() {
+		name := ov.Type().Field(i).Name
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changes = append(changes, configChange{
+				Field: name,
+				Old:   redactConfigValue(name, ov.Field(i).Interface()),
+				New:   redactConfigValue(name, nv.Field(i).Interface()),
+			})
+		}
+	}
+	return changes
+}
+
+// redactedConfig returns every field of cfg by name for /debug/config, with
+// secrets redacted.
+func redactedConfig(cfg Config) map[string]any {
+	v := reflect.ValueOf(cfg)
+	fields := make(map[string]any, v.NumField())
+	for i := range v.NumField() {
+		name := v.Type().Field(i).Name
+		fields[name] = redactConfigValue(name, v.Field(i).Interface())
+	}
+	return fields
+}
+
+// redactConfigValue hides credentials: API keys are reduced to their names,
+// SESSION_SECRET and SIGNING_SECRET are hidden, and DATABASE_URL,
+// REDIS_URL, and BROKER_URL lose their passwords. Durations are spelled out
+// rather than left as nanoseconds.
+func redactConfigValue(field string, value any) any {
+	if d, ok := value.(time.Duration); ok {
+		return d.String()
+	}
+	if m, ok := value.(os.FileMode); ok {
+		return fmt.Sprintf("%#o", m)
+	}
+	if a, ok := value.(hostAllowlist); ok {
+		return a.Entries()
+	}
+	switch field {
+	case "APIKeys":
+		keys := value.([]apiKey)
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.Name + ":" + redactedValue
+		}
+		return names
+	case "SessionSecret":
+		if value.(string) != "" {
+			return redactedValue
+		}
+	case "SigningSecrets":
+		secrets := make([]string, len(value.([]string)))
+		for i := range secrets {
+			secrets[i] = redactedValue
+		}
+		return secrets
+	case "DatabaseURL", "RedisURL", "BrokerURL":
+		dsn := value.(string)
+		if u, err := url.Parse(dsn); err == nil && u.User != nil {
+			return u.Redacted()
+		}
+		if dsn != "" {
+			return redactedValue
+		}
+	}
+	return value
+}
+
+// configReloader re-reads the configuration on demand and applies the
+// reloadable settings to the running server.
+type configReloader struct {
+	path   string
+	args   []string
+	level  *slog.LevelVar
+	srv    *Server
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	current Config
+	fileSum [sha256.Size]byte
+}
+
+func newConfigReloader(cfg Config, args []string, level *slog.LevelVar, srv *Server, logger *slog.Logger) *configReloader {
+	r := &configReloader{path: cfg.ConfigFile, args: args, level: level, srv: srv, logger: logger, current: cfg}
+	if cfg.ConfigFile != "" {
+		if data, err := os.ReadFile(cfg.ConfigFile); err == nil {
+			r.fileSum = sha256.Sum256(data)
+		}
+	}
+	return r
+}
+
+// Reload loads the configuration again, as at startup, and applies the
+// reloadable settings that changed. An invalid configuration is rejected
+// as a whole and the running one is kept.
+func (r *configReloader) Reload(trigger string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := LoadConfig(r.args)
+	if err != nil {
+		r.logger.Error("config reload rejected, keeping the running config", slog.String("trigger", trigger), slog.Any("error", err))
+		return err
+	}
+	changes := diffConfig(r.current, next)
+	if len(changes) == 0 {
+		r.logger.Info("config reloaded, nothing changed", slog.String("trigger", trigger))
+		return nil
+	}
+
+	applied := r.current
+	av, nv := reflect.ValueOf(&applied).Elem(), reflect.ValueOf(next)
+	for _, change := range changes {
+		if !slices.Contains(reloadableFields, change.Field) {
+			r.logger.Warn("config change needs a restart to take effect",
+				slog.String("field", change.Field), slog.Any("running", change.Old), slog.Any("configured", change.New))
+			continue
+		}
+		av.FieldByName(change.Field).Set(nv.FieldByName(change.Field))
+		r.logger.Info("config changed", slog.String("trigger", trigger),
+			slog.String("field", change.Field), slog.Any("old", change.Old), slog.Any("new", change.New))
+	}
+	r.level.Set(parseLogLevel(applied.LogLevel))
+	r.srv.applyConfig(applied)
+	r.current = applied
+	return nil
+}
+
+// watchFile reloads when the config file's contents change. Kubernetes
+// updates ConfigMap volumes by swapping a symlink, so contents are compared
+// rather than modification times.
+func (r *configReloader) watchFile(context.Context) error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	r.mu.Lock()
+	changed := sum != r.fileSum
+	r.fileSum = sum
+	r.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return r.Reload("config file changed")
+}
+
+// applyConfig swaps the reloadable settings of cfg into the running server.
+func (s *Server) applyConfig(cfg Config) {
+	s.limiter.SetLimits(cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitExempt)
+	s.cors.Set(cfg.CORSAllowedOrigins)
+	s.flags.Set(cfg.Features)
+	if maintenanceChanged(*s.active.Load(), cfg) {
+		s.maintenance.Configure(cfg, s.clock.Now())
+	}
+	s.maintenance.SetFailReadiness(cfg.MaintenanceFailReadiness)
+	s.active.Store(&cfg)
+}
+
+// configHandler serves GET /debug/config.
+func (s *Server) configHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, redactedConfig(*s.active.Load()))
+}
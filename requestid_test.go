@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFrom(r.Context())
+	}))
+	tests := []struct {
+		name, incoming string
+		keep           bool
+	}{
+		{"propagated", "abc-123", true},
+		{"absent", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"control characters", "abc\x01", false},
+		{"spaces", "abc def", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set(requestIDHeader, tt.incoming)
+			}
+			rec := serve(h, r)
+			got := rec.Header().Get(requestIDHeader)
+			if got != seen {
+				t.Errorf("response ID %q, context ID %q, want them equal", got, seen)
+			}
+			if tt.keep && got != tt.incoming {
+				t.Errorf("response ID = %q, want the caller's %q", got, tt.incoming)
+			}
+			if !tt.keep && (got == tt.incoming || len(got) != 36) {
+				t.Errorf("response ID = %q, want a generated UUID", got)
+			}
+		})
+	}
+}
+
+func TestErrorResponseCarriesRequestID(t *testing.T) {
+	_, h := newTestServer(t)
+	r := newRequest(t, "POST", "/api", "{")
+	r.Header.Set(requestIDHeader, "quote-me")
+	rec := serve(h, r)
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.RequestID != "quote-me" {
+		t.Errorf("POST /api with bad JSON = %d %s, want request_id quote-me", rec.Code, rec.Body)
+	}
+}
+
+func TestRequestIDsUniqueUnderLoad(t *testing.T) {
+	h := withRequestID(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	const workers, perWorker = 16, 500
+	ids := make(chan string, workers*perWorker)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				ids <- serve(h, httptest.NewRequest("GET", "/", nil)).Header().Get(requestIDHeader)
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[string]bool, workers*perWorker)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("request ID %s generated twice", id)
+		}
+		seen[id] = true
+	}
+}
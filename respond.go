@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Response encodings offered through content negotiation.
+const (
+	contentTypeJSON = "application/json"
+	contentTypeXML  = "application/xml"
+	contentTypeYAML = "application/yaml"
+)
+
+// acceptedMediaTypes maps the media types clients may ask for to the
+// encoding served for them.
+var acceptedMediaTypes = map[string]string{
+	"application/json":   contentTypeJSON,
+	"application/xml":    contentTypeXML,
+	"text/xml":           contentTypeXML,
+	"application/yaml":   contentTypeYAML,
+	"application/x-yaml": contentTypeYAML,
+	"text/yaml":          contentTypeYAML,
+}
+
+// writeResponse sends v with the given status, encoded as JSON, XML, or YAML
+// according to the request's Accept header. JSON is the default and the
+// fallback for Accept values we can't satisfy.
+//
+// XML and YAML are transcoded from the JSON encoding rather than built from
+// their own struct tags, so field names and order are identical across all
+// three and maps (which encoding/xml can't marshal) work everywhere.
+//
+// The body is encoded in full before anything is sent, so a value that
+// fails to encode still gets a proper 500 ErrorResponse, and is written in
+// one go with its Content-Length. Errors writing it, such as a client that
+// hung up, are logged with the request ID.
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v any) {
+	start := time.Now()
+	data, err := json.Marshal(v)
+	if err != nil {
+		loggerFrom(r.Context()).Error("cannot encode response", slog.String("type", fmt.Sprintf("%T", v)), slog.Any("error", err))
+		if _, ok := v.(ErrorResponse); ok {
+			// Can't happen, but must not recurse.
+			http.Error(w, `{"error":"internal server error"}`, http.StatusInternalServerError)
+			return
+		}
+		// Headers describing the response that couldn't be sent don't
+		// apply to the error.
+		for _, name := range []string{"ETag", "Last-Modified", "Location", "Content-Disposition"} {
+			w.Header().Del(name)
+		}
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "internal server error", "response could not be encoded")
+		return
+	}
+
+	contentType, _ := negotiate(r.Header.Get("Accept"))
+	indent := wantsPretty(r)
+	switch contentType {
+	case contentTypeJSON:
+		if indent {
+			var buf bytes.Buffer
+			if json.Indent(&buf, data, "", "  ") == nil {
+				data = buf.Bytes()
+			}
+		}
+	case contentTypeXML:
+		if out, err := jsonToXML(data, xmlRootName(v), indent); err == nil {
+			data = out
+		} else {
+			contentType = contentTypeJSON
+		}
+	case contentTypeYAML:
+		if out, err := jsonToYAML(data); err == nil {
+			data = out
+		} else {
+			contentType = contentTypeJSON
+		}
+	}
+	if contentType == contentTypeJSON {
+		data = append(data, '\n')
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	// Successful reads carry an ETag unless the route forbids storing them;
+	// handlers that can derive one more cheaply set it beforehand.
+	if status == http.StatusOK && !strings.Contains(w.Header().Get("Cache-Control"), "no-store") {
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			etag = bodyETag(data)
+			w.Header().Set("ETag", etag)
+		}
+		if notModified(w, r, etag) {
+			return
+		}
+	}
+	addTiming(r.Context(), timingEncode, time.Since(start))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil && !errors.Is(err, http.ErrBodyNotAllowed) {
+		loggerFrom(r.Context()).Warn("cannot write response", slog.Int("status", status), slog.Int("bytes", len(data)), slog.Any("error", err))
+	}
+}
+
+// wantsPretty reports whether to indent the response: ?pretty=1 (or any true
+// value) asks for it, and browsers get it unless they pass ?pretty=0.
+func wantsPretty(r *http.Request) bool {
+	if r.URL.RawQuery == "" {
+		// Skip parsing, and allocating, an empty query.
+	} else if v := r.URL.Query().Get("pretty"); v != "" {
+		pretty, err := strconv.ParseBool(v)
+		return err == nil && pretty
+	}
+	return strings.HasPrefix(r.UserAgent(), "Mozilla/")
+}
+
+// negotiate picks the encoding for an Accept header. ok is false when the
+// header names only media types we can't produce; the encoding is then JSON.
+func negotiate(accept string) (contentType string, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return contentTypeJSON, true
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if name, value, found := strings.Cut(strings.TrimSpace(param), "="); found && strings.TrimSpace(name) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		candidate := acceptedMediaTypes[mediaType]
+		if mediaType == "*/*" || mediaType == "application/*" {
+			candidate = contentTypeJSON
+		}
+		// Ties keep the earlier entry, and JSON wins ties with wildcards.
+		if candidate != "" && q > bestQ {
+			best, bestQ = candidate, q
+		}
+	}
+	if best == "" {
+		return contentTypeJSON, false
+	}
+	return best, true
+}
+
+// withNegotiation rejects requests whose Accept header we can't satisfy with
+// 406 when strict is set. Otherwise such requests get JSON.
+func withNegotiation(next http.Handler, strict bool) http.Handler {
+	if !strict {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The items list also streams NDJSON, and the export CSV; elsewhere
+		// they get JSON.
+		accept := r.Header.Get("Accept")
+		if _, ok := negotiate(accept); !ok && !acceptsNDJSON(accept) && !acceptsMediaType(accept, contentTypeCSV) {
+			writeError(w, r, http.StatusNotAcceptable, codeNotAcceptable, "Not acceptable", "supported types are application/json, application/xml, and application/yaml")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// jsonToYAML re-encodes a JSON document as block-style YAML, keeping key
+// order.
+func jsonToYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	resetYAMLStyle(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
+
+// resetYAMLStyle drops the flow style and quoting inherited from JSON
+// syntax; the encoder still quotes strings that would otherwise be misread.
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// xmlRootName names the document element after v's type, e.g.
+// <HealthResponse>; anonymous types and slices become <response>.
+func xmlRootName(v any) string {
+	if n, ok := v.(interface{ xmlRoot() string }); ok {
+		return n.xmlRoot()
+	}
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Name() == "" || !validXMLName(t.Name()) {
+		return "response"
+	}
+	return t.Name()
+}
+
+// jsonToXML transcodes a JSON document to XML, indented if asked. Object
+// keys become child elements, array entries become repeated <item>
+// elements, and null values are empty elements. Keys that aren't valid XML
+// names are written as <entry key="...">.
+func jsonToXML(data []byte, root string, indent bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if indent {
+		enc.Indent("", "  ")
+	}
+	if err := transcodeXMLValue(dec, enc, xml.StartElement{Name: xml.Name{Local: root}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func transcodeXMLValue(dec *json.Decoder, enc *xml.Encoder, start xml.StartElement) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch tok {
+		case '{':
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key := keyTok.(string)
+				child := xml.StartElement{Name: xml.Name{Local: key}}
+				if !validXMLName(key) {
+					child = xml.StartElement{
+						Name: xml.Name{Local: "entry"},
+						Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+					}
+				}
+				if err := transcodeXMLValue(dec, enc, child); err != nil {
+					return err
+				}
+			}
+		case '[':
+			for dec.More() {
+				if err := transcodeXMLValue(dec, enc, xml.StartElement{Name: xml.Name{Local: "item"}}); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := dec.Token(); err != nil && err != io.EOF {
+			return err
+		}
+	case string:
+		if err := enc.EncodeToken(xml.CharData(tok)); err != nil {
+			return err
+		}
+	case json.Number:
+		if err := enc.EncodeToken(xml.CharData(tok.String())); err != nil {
+			return err
+		}
+	case bool:
+		if err := enc.EncodeToken(xml.CharData(strconv.FormatBool(tok))); err != nil {
+			return err
+		}
+	case nil:
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// validXMLName reports whether s can be used as an element name. It accepts
+// a conservative ASCII subset of the XML Name production.
+func validXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		wantOK bool
+	}{
+		{"", contentTypeJSON, true},
+		{"*/*", contentTypeJSON, true},
+		{"application/xml", contentTypeXML, true},
+		{"text/xml", contentTypeXML, true},
+		{"application/yaml", contentTypeYAML, true},
+		{"application/x-yaml", contentTypeYAML, true},
+		{"application/xml;q=0.5, application/yaml", contentTypeYAML, true},
+		{"application/yaml;q=0, */*", contentTypeJSON, true},
+		{"text/html, application/xml;q=0.9", contentTypeXML, true},
+		{"text/html", contentTypeJSON, false},
+		{"image/png;q=1", contentTypeJSON, false},
+	}
+	for _, tt := range tests {
+		if got, ok := negotiate(tt.accept); got != tt.want || ok != tt.wantOK {
+			t.Errorf("negotiate(%q) = %s, %v, want %s, %v", tt.accept, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// TestResponseEncodingsRoundTrip fetches an item as JSON, YAML, and XML and
+// checks that each decodes back to the same item.
+func TestResponseEncodingsRoundTrip(t *testing.T) {
+	_, h := newTestServer(t)
+	item := createItem(t, h, `{"name": "widget", "data": {"color": "red", "size": 3}}`)
+	get := func(accept string) (string, []byte) {
+		t.Helper()
+		r := newRequest(t, "GET", "/api/v1/items/"+item.ID, "")
+		r.Header.Set("Accept", accept)
+		rec := serve(h, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET with Accept %s = %d", accept, rec.Code)
+		}
+		return rec.Header().Get("Content-Type"), rec.Body.Bytes()
+	}
+
+	contentType, body := get("application/json")
+	var fromJSON any
+	if err := json.Unmarshal(body, &fromJSON); err != nil || contentType != contentTypeJSON {
+		t.Fatalf("JSON = %s %s", contentType, body)
+	}
+
+	contentType, body = get("application/yaml")
+	var fromYAML any
+	if err := yaml.Unmarshal(body, &fromYAML); err != nil || contentType != contentTypeYAML {
+		t.Fatalf("YAML = %s %s: %v", contentType, body, err)
+	}
+	// Compare as JSON values; YAML decodes timestamps and integers as
+	// richer types.
+	normalized, _ := json.Marshal(fromYAML)
+	var yamlAsJSON any
+	json.Unmarshal(normalized, &yamlAsJSON)
+	if !reflect.DeepEqual(yamlAsJSON, fromJSON) {
+		t.Errorf("YAML decodes to %v, want %v", yamlAsJSON, fromJSON)
+	}
+
+	contentType, body = get("application/xml")
+	var fromXML struct {
+		XMLName   xml.Name  `xml:"Item"`
+		ID        string    `xml:"id"`
+		Name      string    `xml:"name"`
+		Color     string    `xml:"data>color"`
+		Size      int       `xml:"data>size"`
+		CreatedAt time.Time `xml:"created_at"`
+		Version   int64     `xml:"version"`
+	}
+	if err := xml.Unmarshal(body, &fromXML); err != nil || contentType != contentTypeXML {
+		t.Fatalf("XML = %s %s: %v", contentType, body, err)
+	}
+	if fromXML.ID != item.ID || fromXML.Name != item.Name || fromXML.Color != "red" || fromXML.Size != 3 ||
+		!fromXML.CreatedAt.Equal(item.CreatedAt) || fromXML.Version != item.Version {
+		t.Errorf("XML decodes to %+v, want %+v", fromXML, item)
+	}
+}
+
+func TestResponseEncodingsForOtherTypes(t *testing.T) {
+	_, h := newTestServer(t)
+	createItem(t, h, `{"name": "one"}`)
+	for _, tt := range []struct{ path, root string }{
+		{"/health", "<HealthResponse>"},
+		{"/", "<MessageResponse>"},
+		{"/api/v1/items", "<ItemList>"},
+		{"/nope", "<ErrorResponse>"},
+	} {
+		r := newRequest(t, "GET", tt.path, "")
+		r.Header.Set("Accept", "application/xml")
+		rec := serve(h, r)
+		if !strings.Contains(rec.Body.String(), tt.root) {
+			t.Errorf("GET %s as XML = %s, want a %s document", tt.path, rec.Body, tt.root)
+		}
+		var v any
+		r.Header.Set("Accept", "application/yaml")
+		if rec := serve(h, r); yaml.Unmarshal(rec.Body.Bytes(), &v) != nil || rec.Header().Get("Content-Type") != contentTypeYAML {
+			t.Errorf("GET %s as YAML = %s %s", tt.path, rec.Header().Get("Content-Type"), rec.Body)
+		}
+	}
+}
+
+func TestUnsupportedAccept(t *testing.T) {
+	_, lenient := newTestServer(t)
+	r := newRequest(t, "GET", "/health", "")
+	r.Header.Set("Accept", "text/html")
+	if rec := serve(lenient, r); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentTypeJSON {
+		t.Errorf("Accept text/html = %d %s, want JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	_, strict := newTestServer(t, func(cfg *Config) { cfg.StrictAccept = true })
+	if rec := serve(strict, r); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Accept text/html with STRICT_ACCEPT = %d, want 406", rec.Code)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	var logs bytes.Buffer
+	_, h := newLoggedTestServer(t, slog.New(slog.NewJSONHandler(&logs, nil)))
+	tests := []struct {
+		name, query, userAgent string
+		wantPretty             bool
+	}{
+		{"default", "", "curl/8.5.0", false},
+		{"pretty=1", "?pretty=1", "curl/8.5.0", true},
+		{"pretty=true", "?pretty=true", "", true},
+		{"browser", "", "Mozilla/5.0 (X11; Linux x86_64)", true},
+		{"browser opting out", "?pretty=0", "Mozilla/5.0 (X11; Linux x86_64)", false},
+		{"invalid value", "?pretty=maybe", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			r := newRequest(t, "GET", "/health"+tt.query, "")
+			r.Header.Set("User-Agent", tt.userAgent)
+			rec := serve(h, r)
+			body := rec.Body.String()
+			if pretty := strings.HasPrefix(body, "{\n  \""); pretty != tt.wantPretty {
+				t.Errorf("body %q: pretty = %v, want %v", body, pretty, tt.wantPretty)
+			}
+			if !tt.wantPretty && strings.Count(body, "\n") != 1 {
+				t.Errorf("compact body spans lines: %q", body)
+			}
+			if !json.Valid(rec.Body.Bytes()) || rec.Header().Get("Content-Type") != contentTypeJSON {
+				t.Errorf("Content-Type %s with body %q, want valid JSON", rec.Header().Get("Content-Type"), body)
+			}
+			if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+				t.Errorf("Content-Length = %s, want %d", got, len(body))
+			}
+			access := logLines(t, &logs, "request")
+			if len(access) != 1 || access[0]["bytes"] != float64(len(body)) {
+				t.Errorf("access log = %v, want bytes %d", access, len(body))
+			}
+		})
+	}
+}
+
+// failingMarshaler is a value that can't be encoded.
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalJSON() ([]byte, error) { return nil, errors.New("cannot marshal") }
+
+// brokenPipeWriter fails every write, like a client that went away.
+type brokenPipeWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (brokenPipeWriter) Write([]byte) (int, error) { return 0, syscall.EPIPE }
+
+func TestWriteResponseFailures(t *testing.T) {
+	var logs bytes.Buffer
+	respond := func(w http.ResponseWriter, v any) {
+		h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"stale"`)
+			w.Header().Set("Location", "/api/v1/items/1")
+			writeResponse(w, r, http.StatusCreated, v)
+		}), slog.New(slog.NewJSONHandler(&logs, nil)), logSampling{Rate: 1})
+		r := newRequest(t, "GET", "/x", "")
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, "respond-1")))
+	}
+
+	rec := httptest.NewRecorder()
+	respond(rec, map[string]any{"item": failingMarshaler{}})
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusInternalServerError || body.Code != codeInternal || body.RequestID != "respond-1" {
+		t.Fatalf("unencodable response = %d %s, want a 500 ErrorResponse", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("ETag") != "" || rec.Header().Get("Location") != "" {
+		t.Errorf("500 kept the failed response's headers: %v", rec.Header())
+	}
+	if lines := logLines(t, &logs, "cannot encode response"); len(lines) != 1 || lines[0]["request_id"] != "respond-1" || lines[0]["error"] == nil {
+		t.Errorf("encode failure logs = %v, want one line with the request ID and error", lines)
+	}
+
+	rec = httptest.NewRecorder()
+	respond(rec, map[string]string{"ok": "yes"})
+	if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); rec.Code != http.StatusCreated || got != want {
+		t.Errorf("response = %d with Content-Length %q, want 201 with %q", rec.Code, got, want)
+	}
+
+	respond(brokenPipeWriter{httptest.NewRecorder()}, map[string]string{"ok": "yes"})
+	if lines := logLines(t, &logs, "cannot write response"); len(lines) != 1 || lines[0]["request_id"] != "respond-1" || lines[0]["status"] != float64(http.StatusCreated) {
+		t.Errorf("write failure logs = %v, want one line with the request ID and status", lines)
+	}
+}
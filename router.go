@@ -0,0 +1,325 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TRAILING_SLASH modes.
+const (
+	trailingSlashRedirect = "redirect"
+	trailingSlashServe    = "serve"
+)
+
+// routerMethods are probed to build the Allow header when a path is known
+// but the request's method is not.
+var routerMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// router dispatches through an explicit ServeMux, recording the matched
+// pattern for the access log and answering unmatched requests itself with
+// JSON errors: 405 with an Allow header when the path exists under other
+// methods, 404 otherwise.
+//
+// HEAD runs the GET route through a writer that counts and discards the
+// body, so headers and Content-Length match GET, except on streaming and
+// upgrade routes, which answer it 405. OPTIONS answers 204 with
+// the path's methods in Allow, taken from the documented operations since a
+// route registered without a method may still accept only some.
+//
+// A path that only routes without its trailing slash or with doubled slashes
+// collapsed is redirected there with 308, which keeps the method and body,
+// or with serveCanonical served as if it had been sent there.
+//
+// Reads of a matched route get the Cache-Control configured for its path,
+// and every matched route runs under the timeout configured for its path or,
+// failing that, the default timeout.
+type router struct {
+	mux            *http.ServeMux
+	notFound       http.HandlerFunc
+	cacheControl   map[string]string
+	timeout        time.Duration
+	timeouts       map[string]time.Duration
+	serveCanonical bool
+	// methods lists each documented path's methods for Allow.
+	methods map[string][]string
+}
+
+// newRouter returns a router for mux whose Allow headers follow spec.
+func newRouter(mux *http.ServeMux, spec *OpenAPI) *router {
+	rt := &router{mux: mux, methods: make(map[string][]string, len(spec.Paths))}
+	for path, item := range spec.Paths {
+		var methods []string
+		for _, method := range routerMethods {
+			_, documented := item[strings.ToLower(method)]
+			_, get := item["get"]
+			if documented || method == http.MethodOptions || method == http.MethodHead && get {
+				methods = append(methods, method)
+			}
+		}
+		rt.methods[path] = methods
+	}
+	return rt
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if canonical, pattern, ok := rt.canonicalPath(r); ok {
+		if rt.serveCanonical {
+			r = withPath(r, canonical)
+		} else {
+			setLogRoute(r.Context(), pattern)
+			_, label := splitPattern(pattern)
+			instrument(label, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Location", (&url.URL{Path: canonical, RawQuery: r.URL.RawQuery}).RequestURI())
+				w.WriteHeader(http.StatusPermanentRedirect)
+			})(w, r)
+			return
+		}
+	}
+	switch r.Method {
+	case http.MethodHead:
+		if pattern := rt.routePattern(r, http.MethodGet, r.URL.Path); pattern != "" {
+			if _, path := splitPattern(pattern); rt.streams(path) {
+				allowed, _ := rt.methodsFor(r)
+				setLogRoute(r.Context(), pattern)
+				instrument(path, func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Allow", strings.Join(allowed, ", "))
+					writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+				})(w, r)
+				return
+			}
+			hw := &headWriter{ResponseWriter: w}
+			defer hw.finish()
+			w, r = hw, withMethod(r, http.MethodGet)
+		}
+	case http.MethodOptions:
+		if allowed, pattern := rt.methodsFor(r); pattern != "" {
+			setLogRoute(r.Context(), pattern)
+			_, label := splitPattern(pattern)
+			instrument(label, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Allow", strings.Join(allowed, ", "))
+				w.WriteHeader(http.StatusNoContent)
+			})(w, r)
+			return
+		}
+	}
+	if _, pattern := rt.mux.Handler(r); pattern != "" {
+		setLogRoute(r.Context(), pattern)
+		_, path := splitPattern(pattern)
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if v, ok := rt.cacheControl[path]; ok {
+				w.Header().Set("Cache-Control", v)
+			}
+		}
+		timeout, ok := rt.timeouts[path]
+		if !ok {
+			timeout = rt.timeout
+		}
+		withTimeout(rt.mux, timeout).ServeHTTP(w, r)
+		return
+	}
+
+	allowed, _ := rt.methodsFor(r)
+	if len(allowed) == 0 {
+		instrument("unmatched", rt.notFound)(w, r)
+		return
+	}
+	instrument("unmatched", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	})(w, r)
+}
+
+// allowedMethods lists the methods some route accepts for r's path.
+func (rt *router) allowedMethods(r *http.Request) []string {
+	var allowed []string
+	for _, method := range routerMethods {
+		if rt.routePattern(r, method, r.URL.Path) != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// methodsFor lists the methods r's path accepts, and a pattern routing it,
+// or nothing when no route does.
+func (rt *router) methodsFor(r *http.Request) (allowed []string, pattern string) {
+	for _, method := range routerMethods {
+		if pattern = rt.routePattern(r, method, r.URL.Path); pattern != "" {
+			break
+		}
+	}
+	if pattern == "" {
+		return nil, ""
+	}
+	_, path := splitPattern(pattern)
+	if allowed = rt.methods[path]; allowed == nil {
+		// Undocumented routes, such as pprof, fall back to probing the mux.
+		allowed = rt.allowedMethods(r)
+		if !slices.Contains(allowed, http.MethodOptions) {
+			allowed = append(allowed, http.MethodOptions)
+		}
+	}
+	if rt.streams(path) {
+		allowed = slices.DeleteFunc(slices.Clone(allowed), func(method string) bool { return method == http.MethodHead })
+	}
+	return allowed, pattern
+}
+
+// streams reports whether path is a long-lived route, one exempt from the
+// request timeout. HEAD gets a 405 there: the GET handler would stream or
+// upgrade the connection, and a HEAD response can carry neither.
+func (rt *router) streams(path string) bool {
+	timeout, ok := rt.timeouts[path]
+	return ok && timeout == 0
+}
+
+// routePattern returns the pattern serving method on path, or "" if none
+// does.
+func (rt *router) routePattern(r *http.Request, method, path string) string {
+	u := r.URL
+	if path != u.Path {
+		u = &url.URL{Path: path}
+	}
+	probe := &http.Request{Method: method, URL: u, Host: r.Host, Header: r.Header}
+	_, pattern := rt.mux.Handler(probe)
+	return pattern
+}
+
+// canonicalPath reports the path r should have used when its own path has
+// doubled slashes or a trailing slash and no route, but the tidied path has
+// one. The pattern is the tidied path's route for r's method, or its first
+// route if that method isn't allowed. Routes registered with a trailing
+// slash, such as /debug/pprof/, keep it.
+func (rt *router) canonicalPath(r *http.Request) (canonical, pattern string, ok bool) {
+	path := r.URL.Path
+	doubled := strings.Contains(path, "//")
+	if !doubled && (path == "/" || !strings.HasSuffix(path, "/")) {
+		return "", "", false
+	}
+	if !doubled && rt.routePattern(r, r.Method, path) != "" {
+		return "", "", false
+	}
+	for _, candidate := range []string{collapseSlashes(path), strings.TrimSuffix(collapseSlashes(path), "/")} {
+		if candidate == "" || candidate == path {
+			continue
+		}
+		if pattern = rt.routePattern(r, r.Method, candidate); pattern != "" {
+			return candidate, pattern, true
+		}
+		for _, method := range routerMethods {
+			if pattern = rt.routePattern(r, method, candidate); pattern != "" {
+				return candidate, pattern, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func collapseSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
+
+// withMethod returns a shallow copy of r with method.
+func withMethod(r *http.Request, method string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Method = method
+	return r2
+}
+
+// withPath returns a shallow copy of r for path.
+func withPath(r *http.Request, path string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path, u.RawPath = path, ""
+	r2.URL = &u
+	return r2
+}
+
+// canonicalPaths serves requests under their canonical path from the start,
+// so logs, traces, and metrics all see it. The router does the same for
+// requests that reach it directly, such as batch operations.
+func (rt *router) canonicalPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if canonical, _, ok := rt.canonicalPath(r); ok {
+			r = withPath(r, canonical)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headWriter answers HEAD with what the GET handler would have sent, minus
+// the body. The header is held back until the handler returns so
+// Content-Length can be the counted body size; a flush sends it early, for
+// streams, without one.
+type headWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int
+	committed bool
+}
+
+func (hw *headWriter) WriteHeader(status int) {
+	if hw.committed || hw.status != 0 {
+		return
+	}
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		hw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	hw.status = status
+}
+
+func (hw *headWriter) Write(b []byte) (int, error) {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	hw.bytes += len(b)
+	return len(b), nil
+}
+
+// FlushError sends the header without a Content-Length.
+func (hw *headWriter) FlushError() error {
+	hw.commit(false)
+	return http.NewResponseController(hw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (hw *headWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+func (hw *headWriter) finish() {
+	hw.commit(true)
+}
+
+func (hw *headWriter) commit(sized bool) {
+	if hw.committed {
+		return
+	}
+	hw.committed = true
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	h := hw.ResponseWriter.Header()
+	if sized && h.Get("Content-Length") == "" && hw.status != http.StatusNoContent && hw.status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.Itoa(hw.bytes))
+	}
+	hw.ResponseWriter.WriteHeader(hw.status)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.TrailingSlash = "redirect" })
+	tests := []struct {
+		method, target string
+		wantStatus     int
+		wantLocation   string
+	}{
+		{"GET", "/health/", http.StatusPermanentRedirect, "/health"},
+		{"GET", "/health//", http.StatusPermanentRedirect, "/health"},
+		{"GET", "//api//v1/items?limit=5", http.StatusPermanentRedirect, "/api/v1/items?limit=5"},
+		{"POST", "/api/v1/items/", http.StatusPermanentRedirect, "/api/v1/items"},
+		{"GET", "/", http.StatusOK, ""},
+		{"GET", "/health", http.StatusOK, ""},
+		{"GET", "/nope/", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		body := ""
+		if tt.method == "POST" {
+			body = `{"name": "x"}`
+		}
+		rec := serve(h, newRequest(t, tt.method, tt.target, body))
+		if rec.Code != tt.wantStatus || rec.Header().Get("Location") != tt.wantLocation {
+			t.Errorf("%s %s = %d to %q, want %d to %q", tt.method, tt.target, rec.Code, rec.Header().Get("Location"), tt.wantStatus, tt.wantLocation)
+		}
+	}
+}
+
+func TestTrailingSlashServe(t *testing.T) {
+	var logs bytes.Buffer
+	_, h := newLoggedTestServer(t, slog.New(slog.NewJSONHandler(&logs, nil)), func(cfg *Config) { cfg.TrailingSlash = "serve" })
+
+	if rec := serve(h, newRequest(t, "GET", "/health/", "")); rec.Code != http.StatusOK {
+		t.Errorf("GET /health/ = %d, want it served", rec.Code)
+	}
+	logs.Reset()
+	if rec := serve(h, newRequest(t, "POST", "//api/v1//items/", `{"name": "x"}`)); rec.Code != http.StatusCreated {
+		t.Fatalf("POST //api/v1//items/ = %d %s, want it served", rec.Code, rec.Body)
+	}
+	access := logLines(t, &logs, "request")
+	if len(access) != 1 || access[0]["path"] != "/api/v1/items" || access[0]["route"] != "POST /api/v1/items" {
+		t.Errorf("access log = %v, want the canonical path and route", access)
+	}
+}
+
+func TestHeadMatchesGet(t *testing.T) {
+	_, h := newTestServer(t)
+	item := createItem(t, h, `{"name": "sized", "data": {"padding": "some bytes"}}`)
+	// Paths whose bodies don't carry the current time, so GET and HEAD
+	// sizes agree.
+	for _, path := range []string{"/version", "/api/v1/items", "/api/v1/items/" + item.ID, "/openapi.json"} {
+		get := serve(h, newRequest(t, "GET", path, ""))
+		head := serve(h, newRequest(t, "HEAD", path, ""))
+		if head.Code != get.Code || head.Body.Len() != 0 {
+			t.Errorf("HEAD %s = %d with %d body bytes, want GET's %d and none", path, head.Code, head.Body.Len(), get.Code)
+			continue
+		}
+		if want := get.Body.Len(); head.Header().Get("Content-Length") != strconv.Itoa(want) {
+			t.Errorf("HEAD %s Content-Length = %q, want GET's body size %d", path, head.Header().Get("Content-Length"), want)
+		}
+		if head.Header().Get("Content-Type") != get.Header().Get("Content-Type") {
+			t.Errorf("HEAD %s Content-Type = %q, want %q", path, head.Header().Get("Content-Type"), get.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestOptionsListsRouteMethods(t *testing.T) {
+	_, h := newTestServer(t)
+	tests := []struct{ path, want string }{
+		{"/health", "GET, HEAD, OPTIONS"},
+		{"/", "GET, HEAD, POST, OPTIONS"},
+		{"/api/v1/items", "GET, HEAD, POST, OPTIONS"},
+		{"/api/v1/items/abc", "GET, HEAD, PUT, PATCH, DELETE, OPTIONS"},
+	}
+	for _, tt := range tests {
+		rec := serve(h, newRequest(t, "OPTIONS", tt.path, ""))
+		if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != tt.want || rec.Body.Len() != 0 {
+			t.Errorf("OPTIONS %s = %d with Allow %q, want 204 with %q", tt.path, rec.Code, rec.Header().Get("Allow"), tt.want)
+		}
+	}
+	if rec := serve(h, newRequest(t, "OPTIONS", "/nope", "")); rec.Code != http.StatusNotFound {
+		t.Errorf("OPTIONS /nope = %d, want 404", rec.Code)
+	}
+}
+
+func TestHeadOnStreamingRoutes(t *testing.T) {
+	_, h := newTestServer(t)
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	for _, path := range []string{"/events", "/ws"} {
+		r, err := http.NewRequest("HEAD", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path == "/ws" {
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Sec-WebSocket-Version", "13")
+			r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatalf("HEAD %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, OPTIONS" {
+			t.Errorf("HEAD %s = %d with Allow %q, want 405 with %q", path, resp.StatusCode, resp.Header.Get("Allow"), "GET, OPTIONS")
+		}
+
+		if rec := serve(h, newRequest(t, "OPTIONS", path, "")); rec.Header().Get("Allow") != "GET, OPTIONS" {
+			t.Errorf("OPTIONS %s Allow = %q, want %q", path, rec.Header().Get("Allow"), "GET, OPTIONS")
+		}
+	}
+}
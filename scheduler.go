@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TaskStatus reports a scheduled task for /debug/tasks.
+type TaskStatus struct {
+	Name           string     `json:"name"`
+	Interval       string     `json:"interval"`
+	Running        bool       `json:"running"`
+	Runs           int        `json:"runs"`
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMS float64    `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextRun        *time.Time `json:"next_run,omitempty"`
+}
+
+// scheduledTask is a function run every interval, plus up to a tenth of
+// interval of jitter so tasks on many pods don't fire in lockstep.
+type scheduledTask struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+
+	mu     sync.Mutex
+	status TaskStatus
+}
+
+// scheduler runs periodic maintenance tasks, each on its own goroutine.
+// A task's runs never overlap: the next wait starts only when a run ends.
+type scheduler struct {
+	now    func() time.Time
+	logger *slog.Logger
+	tasks  []*scheduledTask
+}
+
+func newScheduler(now func() time.Time, logger *slog.Logger) *scheduler {
+	return &scheduler{now: now, logger: logger}
+}
+
+// Register adds a task. It must be called before Run.
+func (sc *scheduler) Register(name string, interval time.Duration, run func(ctx context.Context) error) {
+	sc.tasks = append(sc.tasks, &scheduledTask{
+		name:     name,
+		interval: interval,
+		run:      run,
+		status:   TaskStatus{Name: name, Interval: interval.String()},
+	})
+}
+
+// Run runs every task until ctx is cancelled, then waits for in-progress
+// runs, which see the cancellation through their context, to return.
+func (sc *scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, task := range sc.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sc.loop(ctx, task)
+		}()
+	}
+	wg.Wait()
+}
+
+func (sc *scheduler) loop(ctx context.Context, task *scheduledTask) {
+	for {
+		wait := task.interval + rand.N(task.interval/10+1)
+		next := sc.now().Add(wait).UTC()
+		task.mu.Lock()
+		task.status.NextRun = &next
+		task.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		sc.runOnce(ctx, task)
+	}
+}
+
+// runOnce runs task, turning a panic into its last error so one bad run
+// doesn't stop the task or the process.
+func (sc *scheduler) runOnce(ctx context.Context, task *scheduledTask) {
+	started := sc.now().UTC()
+	task.mu.Lock()
+	task.status.Running = true
+	task.status.NextRun = nil
+	task.mu.Unlock()
+
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				sc.logger.Error("panic in scheduled task",
+					slog.String("task", task.name),
+					slog.Any("panic", p),
+					slog.String("stack", string(debug.Stack())),
+				)
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return task.run(ctx)
+	}()
+	elapsed := time.Since(start)
+
+	task.mu.Lock()
+	task.status.Running = false
+	task.status.Runs++
+	task.status.LastRun = &started
+	task.status.LastDurationMS = float64(elapsed.Microseconds()) / 1000
+	task.status.LastError = ""
+	if err != nil {
+		task.status.LastError = err.Error()
+	}
+	task.mu.Unlock()
+
+	if err != nil && ctx.Err() == nil {
+		sc.logger.Warn("scheduled task failed", slog.String("task", task.name), slog.Any("error", err))
+		return
+	}
+	sc.logger.Debug("scheduled task ran", slog.String("task", task.name), slog.Duration("duration", elapsed))
+}
+
+// Status reports every task in registration order.
+func (sc *scheduler) Status() []TaskStatus {
+	statuses := make([]TaskStatus, len(sc.tasks))
+	for i, task := range sc.tasks {
+		task.mu.Lock()
+		statuses[i] = task.status
+		task.mu.Unlock()
+	}
+	return statuses
+}
+
+// registerTasks schedules the server's housekeeping.
+func (s *Server) registerTasks() {
+	s.tasks.Register("heartbeat", 30*time.Second, func(context.Context) error {
+		s.logger.Info("heartbeat",
+			slog.String("uptime", s.clock.Now().Sub(s.started).Round(time.Second).String()),
+			slog.Int("goroutines", runtime.NumGoroutine()),
+			slog.Bool("ready", s.ready.Load()),
+		)
+		return nil
+	})
+	if cache, ok := s.idem.(*idempotencyCache); ok {
+		s.tasks.Register("prune-idempotency-keys", 5*time.Minute, func(context.Context) error {
+			cache.prune()
+			return nil
+		})
+	}
+	if s.sessions != nil {
+		if st, ok := s.sessions.store.(*memorySessions); ok {
+			s.tasks.Register("prune-sessions", 5*time.Minute, func(context.Context) error {
+				st.prune()
+				return nil
+			})
+		}
+	}
+	if retention := s.cfg.DeletedItemRetention; retention > 0 {
+		s.tasks.Register("purge-deleted-items", purgeDeletedInterval, func(ctx context.Context) error {
+			// The stores stamp DeletedAt with the wall clock.
+			n, err := s.store.PurgeDeleted(ctx, time.Now().Add(-retention))
+			if n > 0 {
+				s.logger.Info("purged deleted items", slog.Int("items", n), slog.Duration("retention", retention))
+			}
+			return err
+		})
+	}
+	s.tasks.Register("probe-read-only", s.cfg.ReadOnlyProbeInterval, s.readOnly.probe)
+	s.tasks.Register("prune-jobs", time.Minute, func(context.Context) error {
+		s.jobs.prune()
+		return nil
+	})
+	s.tasks.Register("evict-rate-limit-buckets", time.Minute, func(context.Context) error {
+		s.limiter.evictIdle(rateLimitIdleTTL)
+		return nil
+	})
+	if s.verifier != nil && s.verifier.jwks != nil {
+		s.tasks.Register("refresh-jwks", time.Hour, s.verifier.jwks.Refresh)
+	}
+	if s.dumps != nil && s.cfg.MemDumpThreshold > 0 {
+		s.tasks.Register("check-memory-dump", memDumpInterval, func(context.Context) error {
+			s.dumps.checkMemory(uint64(s.cfg.MemDumpThreshold))
+			return nil
+		})
+	}
+}
+
+// tasksHandler serves GET /debug/tasks.
+func (s *Server) tasksHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, s.tasks.Status())
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Search query length bounds, in characters. Three is the trigram size, so
+// every query can use the index.
+const (
+	searchMinQuery = 3
+	searchMaxQuery = 100
+)
+
+// fuzzyThreshold is the share of a query's trigrams a field must contain to
+// match it with ?fuzzy=true.
+const fuzzyThreshold = 0.5
+
+// SearchResponse is one page of GET /api/items/search results, best first.
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+// SearchResult is a matching item, how well it matched, and where.
+type SearchResult struct {
+	Item    Item          `json:"item"`
+	Score   float64       `json:"score"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// SearchMatch is a field that matched: "/name", or a JSON Pointer to a
+// string in data. Highlight is the [start, end) of the match in Value, in
+// characters; fuzzy matches have none.
+type SearchMatch struct {
+	Field     string `json:"field"`
+	Value     string `json:"value"`
+	Highlight []int  `json:"highlight,omitempty"`
+}
+
+// searchQuery is a parsed ?q=, folded for case-insensitive matching.
+type searchQuery struct {
+	text   string
+	folded string
+	grams  []string
+	fuzzy  bool
+}
+
+// minHits is how many of the query's trigrams an item must have to be a
+// candidate.
+func (q searchQuery) minHits() int {
+	if !q.fuzzy {
+		return len(q.grams)
+	}
+	return int(math.Ceil(fuzzyThreshold * float64(len(q.grams))))
+}
+
+func parseSearchQuery(r *http.Request) (searchQuery, error) {
+	values := r.URL.Query()
+	text := strings.TrimSpace(values.Get("q"))
+	switch n := utf8.RuneCountInString(text); {
+	case n == 0:
+		return searchQuery{}, fmt.Errorf("q is required")
+	case n < searchMinQuery || n > searchMaxQuery:
+		return searchQuery{}, fmt.Errorf("q must be %d to %d characters", searchMinQuery, searchMaxQuery)
+	}
+	q := searchQuery{text: text, folded: foldText(text)}
+	q.grams = trigrams(q.folded)
+	if v := values.Get("fuzzy"); v != "" {
+		fuzzy, err := strconv.ParseBool(v)
+		if err != nil {
+			return searchQuery{}, fmt.Errorf("fuzzy must be true or false")
+		}
+		q.fuzzy = fuzzy
+	}
+	return q, nil
+}
+
+// foldText lower-cases s rune by rune, so offsets in it are offsets in s.
+func foldText(s string) string {
+	return strings.Map(unicode.ToLower, s)
+}
+
+// trigrams returns the distinct three-character substrings of s.
+func trigrams(s string) []string {
+	runes := []rune(s)
+	seen := make(map[string]struct{}, len(runes))
+	var grams []string
+	for i := 0; i+3 <= len(runes); i++ {
+		g := string(runes[i : i+3])
+		if _, ok := seen[g]; !ok {
+			seen[g] = struct{}{}
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// searchFields calls fn with each searchable string of item: its name, and
+// every string in its data, nested ones included.
+func searchFields(item Item, fn func(field, value string)) {
+	fn("/name", item.Name)
+	var walk func(path string, v any)
+	walk = func(path string, v any) {
+		switch v := v.(type) {
+		case string:
+			fn(path, v)
+		case map[string]any:
+			for _, k := range slices.Sorted(maps.Keys(v)) {
+				walk(path+"/"+jsonPointerEscaper.Replace(k), v[k])
+			}
+		case []any:
+			for i, elem := range v {
+				walk(path+"/"+strconv.Itoa(i), elem)
+			}
+		}
+	}
+	walk("/data", map[string]any(item.Data))
+}
+
+// searchIndex maps trigrams of items' searchable strings to item IDs. It is
+// guarded by the memoryStore's lock.
+type searchIndex struct {
+	postings map[string]map[string]struct{}
+	grams    map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{postings: make(map[string]map[string]struct{}), grams: make(map[string][]string)}
+}
+
+// add indexes item, replacing what was indexed under its ID.
+func (x *searchIndex) add(item Item) {
+	x.remove(item.ID)
+	seen := make(map[string]struct{})
+	searchFields(item, func(_, value string) {
+		for _, g := range trigrams(foldText(value)) {
+			seen[g] = struct{}{}
+		}
+	})
+	grams := make([]string, 0, len(seen))
+	for g := range seen {
+		grams = append(grams, g)
+		ids := x.postings[g]
+		if ids == nil {
+			ids = make(map[string]struct{})
+			x.postings[g] = ids
+		}
+		ids[item.ID] = struct{}{}
+	}
+	x.grams[item.ID] = grams
+}
+
+// remove drops the item with id from the index.
+func (x *searchIndex) remove(id string) {
+	for _, g := range x.grams[id] {
+		delete(x.postings[g], id)
+		if len(x.postings[g]) == 0 {
+			delete(x.postings, g)
+		}
+	}
+	delete(x.grams, id)
+}
+
+// rebuild indexes items from scratch.
+func (x *searchIndex) rebuild(items map[string]Item) {
+	x.postings = make(map[string]map[string]struct{})
+	x.grams = make(map[string][]string, len(items))
+	for _, item := range items {
+		x.add(item)
+	}
+}
+
+// candidates returns the IDs of items with at least minHits of grams.
+func (x *searchIndex) candidates(grams []string, minHits int) []string {
+	if len(grams) == 0 || minHits < 1 {
+		return nil
+	}
+	if minHits == len(grams) {
+		// Every trigram is needed: walk the rarest one's items.
+		rarest := slices.MinFunc(grams, func(a, b string) int { return cmp.Compare(len(x.postings[a]), len(x.postings[b])) })
+		var ids []string
+		for id := range x.postings[rarest] {
+			if !slices.ContainsFunc(grams, func(g string) bool { _, ok := x.postings[g][id]; return !ok }) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	hits := make(map[string]int)
+	for _, g := range grams {
+		for id := range x.postings[g] {
+			hits[id]++
+		}
+	}
+	var ids []string
+	for id, n := range hits {
+		if n >= minHits {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// itemSearcher is implemented by stores that index items for search.
+type itemSearcher interface {
+	// SearchCandidates returns the live items of ctx's tenant that may
+	// match q: every one that does, and possibly others.
+	SearchCandidates(ctx context.Context, q searchQuery) ([]Item, error)
+}
+
+// SearchCandidates looks q's trigrams up in the index, so only items
+// sharing them are returned.
+func (s *memoryStore) SearchCandidates(ctx context.Context, q searchQuery) ([]Item, error) {
+	tenant := tenantFrom(ctx)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, id := range s.index.candidates(q.grams, q.minHits()) {
+		if item, ok := s.items[id]; ok && item.Tenant == tenant && item.DeletedAt == nil {
+			items = append(items, cloneItem(item))
+		}
+	}
+	return items, nil
+}
+
+// rankItem scores item against q, reporting false if nothing matched. A
+// field scores 1 when it is the query, 0.9 when it starts with it, 0.8
+// when a word in it does, 0.6 for any other substring, and, with fuzzy,
+// half its share of the query's trigrams. The name counts double.
+func rankItem(item Item, q searchQuery) (SearchResult, bool) {
+	result := SearchResult{Item: item, Matches: []SearchMatch{}}
+	var total float64
+	searchFields(item, func(field, value string) {
+		folded := foldText(value)
+		var score float64
+		match := SearchMatch{Field: field, Value: value}
+		if i := strings.Index(folded, q.folded); i >= 0 {
+			start := utf8.RuneCountInString(folded[:i])
+			match.Highlight = []int{start, start + utf8.RuneCountInString(q.folded)}
+			prev, _ := utf8.DecodeLastRuneInString(folded[:i])
+			switch {
+			case folded == q.folded:
+				score = 1
+			case i == 0:
+				score = 0.9
+			case !unicode.IsLetter(prev) && !unicode.IsDigit(prev):
+				score = 0.8
+			default:
+				score = 0.6
+			}
+		} else if q.fuzzy {
+			have := trigrams(folded)
+			shared := 0
+			for _, g := range q.grams {
+				if slices.Contains(have, g) {
+					shared++
+				}
+			}
+			similarity := float64(shared) / float64(len(q.grams))
+			if similarity < fuzzyThreshold {
+				return
+			}
+			score = similarity / 2
+		} else {
+			return
+		}
+		if field == "/name" {
+			score *= 2
+		}
+		total += score
+		result.Matches = append(result.Matches, match)
+	})
+	result.Score = math.Round(total*1000) / 1000
+	return result, len(result.Matches) > 0
+}
+
+// searchItemsHandler handles GET /api/items/search?q=, matching q
+// case-insensitively against item names and the strings in their data,
+// and with ?fuzzy=true against near misses too. Results are ranked by
+// score, then name, and paged with ?limit= and ?offset=. The in-memory and
+// file stores answer from a trigram index; other stores are scanned.
+func (s *Server) searchItemsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", err.Error())
+		return
+	}
+	page, err := parseItemQuery(r, s.cfg.ItemsMaxLimit)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", err.Error())
+		return
+	}
+
+	results := []SearchResult{}
+	rank := func(item Item) {
+		if result, ok := rankItem(item, q); ok {
+			results = append(results, result)
+		}
+	}
+	if bc, ok := r.Context().Value(batchKey{}).(batchContext); s.searcher != nil && !(ok && bc.store != nil) {
+		ctx, done := startStoreOp(r.Context(), "search")
+		items, err := s.searcher.SearchCandidates(ctx, q)
+		done("", err)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		for _, item := range items {
+			rank(item)
+		}
+	} else {
+		// No index to ask, or an atomic batch whose changes it doesn't see.
+		for item, err := range s.storeFor(r).ListStream(r.Context(), itemQuery{SortBy: "created_at"}) {
+			if err != nil {
+				writeStoreError(w, r, err)
+				return
+			}
+			rank(item)
+		}
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Item.Name, b.Item.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Item.ID, b.Item.ID)
+	})
+	total := len(results)
+	results = results[min(page.Offset, total):min(page.Offset+page.Limit, total)]
+	writeResponse(w, r, http.StatusOK, SearchResponse{Query: q.text, Results: results, Total: total, Limit: page.Limit, Offset: page.Offset})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	_, h := newTestServer(t)
+	api := serve(h, newRequest(t, "GET", "/api/v1/items", ""))
+	ui := serve(h, newRequest(t, "GET", "/ui", ""))
+	if ui.Code != http.StatusOK || ui.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("GET /ui = %d %s, want the HTML dashboard", ui.Code, ui.Header().Get("Content-Type"))
+	}
+	for name, want := range map[string]string{
+		"X-Content-Type-Options": defaultContentTypeOptions,
+		"X-Frame-Options":        defaultFrameOptions,
+		"Referrer-Policy":        defaultReferrerPolicy,
+	} {
+		if got := api.Header().Get(name); got != want {
+			t.Errorf("API %s = %q, want %q", name, got, want)
+		}
+		if got := ui.Header().Get(name); got != want {
+			t.Errorf("/ui %s = %q, want %q", name, got, want)
+		}
+	}
+	if got := ui.Header().Get("Content-Security-Policy"); got != defaultCSP {
+		t.Errorf("/ui Content-Security-Policy = %q, want the default", got)
+	}
+	if got := api.Header().Get("Content-Security-Policy"); got != "" {
+		t.Errorf("API Content-Security-Policy = %q, want none on JSON", got)
+	}
+	if got := api.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Strict-Transport-Security over plain HTTP = %q, want none", got)
+	}
+
+	r := newRequest(t, "GET", "/api/v1/items", "")
+	r.TLS = &tls.ConnectionState{}
+	if got := serve(h, r).Header().Get("Strict-Transport-Security"); got != defaultHSTS {
+		t.Errorf("Strict-Transport-Security over TLS = %q, want %q", got, defaultHSTS)
+	}
+	if got := serve(h, newRequest(t, "GET", "/health", "")).Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("/health Cache-Control = %q, want no-store", got)
+	}
+}
+
+func TestSecurityHeadersConfigurable(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) {
+		cfg.SecurityHeaders.FrameOptions = ""
+		cfg.SecurityHeaders.CSP = "default-src 'none'"
+	})
+	rec := serve(h, newRequest(t, "GET", "/docs", ""))
+	if _, ok := rec.Header()["X-Frame-Options"]; ok {
+		t.Errorf("X-Frame-Options = %q, want it disabled", rec.Header().Get("X-Frame-Options"))
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); got != "default-src 'none'" {
+		t.Errorf("Content-Security-Policy = %q, want the override", got)
+	}
+}
+
+func TestSecurityHeadersDontClobberHandlers(t *testing.T) {
+	h := withSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}), defaultConfig().SecurityHeaders)
+	rec := serve(h, newRequest(t, "GET", "/ui", ""))
+	if got := rec.Header().Get("Referrer-Policy"); got != "no-referrer" {
+		t.Errorf("Referrer-Policy = %q, want the handler's", got)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Errorf("Content-Security-Policy = %q, want the handler's", got)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+// selfTestTimeout bounds the whole -selftest run, and so each check in it.
+const selfTestTimeout = 5 * time.Second
+
+// SelfTestReport is what -selftest prints: every check, and whether all of
+// them passed.
+type SelfTestReport struct {
+	Passed     bool            `json:"passed"`
+	DurationMS float64         `json:"duration_ms"`
+	Checks     []SelfTestCheck `json:"checks"`
+	Build      BuildInfo       `json:"build"`
+}
+
+// SelfTestCheck is one check's outcome.
+type SelfTestCheck struct {
+	Name       string  `json:"name"`
+	Passed     bool    `json:"passed"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// selfTestConfig is cfg without what would make the checks depend on
+// anything outside the process, or be refused: external services,
+// credentials, the admin port, tenants, maintenance, rate limits, and the
+// audit file.
+func selfTestConfig(cfg Config) Config {
+	cfg.DatabaseURL, cfg.DataFile, cfg.RedisURL, cfg.RedisStore = "", "", "", false
+	cfg.BrokerURL, cfg.BackendURL, cfg.ShadowURL, cfg.OTLPEndpoint = "", "", "", ""
+	cfg.APIKeys, cfg.JWTJWKSURL, cfg.JWTPublicKey, cfg.TLSClientCAFile = nil, "", "", ""
+	cfg.SigningSecrets = nil
+	cfg.AdminPort, cfg.GRPCPort = "", ""
+	cfg.MultiTenant, cfg.Maintenance, cfg.RateLimitRPS = false, false, 0
+	cfg.AuditFile, cfg.ConfigFile = "", ""
+	return cfg
+}
+
+// runSelfTest serves cfg's router, middleware and all, on a loopback port
+// with an in-memory store, sends it a battery of requests, prints the
+// report to out, and returns the exit code: 0 if every check passed.
+func runSelfTest(cfg Config, out io.Writer) int {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+	report := SelfTestReport{Passed: true, Checks: []SelfTestCheck{}}
+	check := func(name string, fn func() error) {
+		began := time.Now()
+		c := SelfTestCheck{Name: name, Passed: true}
+		if err := fn(); err != nil {
+			c.Passed, c.Error = false, err.Error()
+			report.Passed = false
+		}
+		c.DurationMS = durationMS(time.Since(began))
+		report.Checks = append(report.Checks, c)
+	}
+	finish := func() int {
+		report.DurationMS = durationMS(time.Since(start))
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		if !report.Passed {
+			return 1
+		}
+		return 0
+	}
+
+	check("config", func() error {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		_, err := loadConfig(func(string) (string, bool) { return "", false }, nil)
+		return err
+	})
+	check("ui_template", func() error {
+		_, err := parseUITemplate()
+		return err
+	})
+
+	logger := newLogger(io.Discard, slog.LevelError, cfg.LogFormat)
+	var srv *Server
+	check("server", func() error {
+		var err error
+		srv, err = NewServer(selfTestConfig(cfg), logger, newMemoryStore(), nil)
+		return err
+	})
+	if srv == nil {
+		return finish()
+	}
+	report.Build = srv.build
+	public, _ := srv.Routes()
+	srv.ready.Store(true)
+	ts := httptest.NewServer(public)
+	defer ts.Close()
+	defer func() {
+		srv.webhooks.Shutdown(ctx)
+		srv.audit.Shutdown(ctx)
+	}()
+
+	client := ts.Client()
+	send := func(method, path, contentType, body string, want int) (map[string]any, error) {
+		req, err := http.NewRequestWithContext(ctx, method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != want {
+			return nil, fmt.Errorf("%s %s: got %d, want %d: %s", method, path, resp.StatusCode, want, bytes.TrimSpace(data))
+		}
+		var decoded map[string]any
+		if len(data) > 0 && strings.HasPrefix(resp.Header.Get("Content-Type"), contentTypeJSON) {
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				return nil, fmt.Errorf("%s %s: invalid JSON response: %w", method, path, err)
+			}
+		}
+		return decoded, nil
+	}
+	get := func(path string) error {
+		_, err := send(http.MethodGet, path, "", "", http.StatusOK)
+		return err
+	}
+
+	check("root", func() error { return get("/") })
+	check("healthz", func() error {
+		body, err := send(http.MethodGet, "/healthz", "", "", http.StatusOK)
+		if err == nil && body["status"] != "healthy" {
+			err = fmt.Errorf("GET /healthz: status is %v, want healthy", body["status"])
+		}
+		return err
+	})
+	check("ui", func() error { return get("/ui") })
+	check("openapi", func() error { return get("/openapi.json") })
+	check("api_valid_json", func() error {
+		body, err := send(http.MethodPost, "/api", contentTypeJSON, `{"selftest":true}`, http.StatusOK)
+		if received, _ := body["received"].(map[string]any); err == nil && received["selftest"] != true {
+			err = fmt.Errorf("POST /api: body not echoed back: %v", body)
+		}
+		return err
+	})
+	check("api_invalid_json", func() error {
+		body, err := send(http.MethodPost, "/api", contentTypeJSON, `{"selftest":`, http.StatusBadRequest)
+		if err == nil && body["code"] != codeInvalidJSON {
+			err = fmt.Errorf("POST /api: code is %v, want %s", body["code"], codeInvalidJSON)
+		}
+		return err
+	})
+	check("store_crud", func() error {
+		created, err := send(http.MethodPost, "/api/v1/items", contentTypeJSON, `{"name":"selftest","data":{"n":1}}`, http.StatusCreated)
+		if err != nil {
+			return err
+		}
+		id, _ := created["id"].(string)
+		if id == "" {
+			return fmt.Errorf("POST /api/v1/items: no id in %v", created)
+		}
+		path := "/api/v1/items/" + id
+		if err := get(path); err != nil {
+			return err
+		}
+		updated, err := send(http.MethodPut, path, contentTypeJSON, `{"name":"selftest-updated"}`, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		if updated["name"] != "selftest-updated" {
+			return fmt.Errorf("PUT %s: name is %v", path, updated["name"])
+		}
+		if _, err := send(http.MethodDelete, path, "", "", http.StatusNoContent); err != nil {
+			return err
+		}
+		_, err = send(http.MethodGet, path, "", "", http.StatusNotFound)
+		return err
+	})
+	return finish()
+}
@@ -0,0 +1,1266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Clock supplies the current time. Handlers read it instead of calling
+// time.Now so tests can pin timestamps.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Server holds the configuration and dependencies shared by the HTTP
+// handlers, which are its methods.
+type Server struct {
+	cfg    Config
+	clock  Clock
+	logger *slog.Logger
+	// accessLogger writes the access log: logger, plus ACCESS_LOG_FILE.
+	accessLogger *slog.Logger
+	store        Store
+	build        BuildInfo
+	instance     InstanceInfo
+	started      time.Time
+
+	// ready reports whether the server should receive new traffic. It is
+	// set once startup completes and cleared as soon as shutdown begins so
+	// the Service stops routing to the pod before the listener closes.
+	ready atomic.Bool
+	// shutdowns delivers POST /admin/shutdown to run, which treats it like
+	// SIGTERM. shutdownRequested makes sure only one is sent.
+	shutdowns         chan shutdownRequest
+	shutdownRequested atomic.Bool
+
+	checks   *checkRegistry
+	faults   *faultInjector
+	verifier *jwtVerifier
+	limiter  *rateLimiter
+	cors     *corsPolicy
+	flags    *Flags
+	// active is the running configuration: cfg with any settings reloaded
+	// since startup.
+	active atomic.Pointer[Config]
+	idem   idempotencyStore
+
+	fetchClient *http.Client
+	backend     *backendProxy
+	static      *staticFiles
+	// atomic is the store when it supports atomic batches.
+	atomic atomicStore
+	// searcher is the store when it indexes items for search.
+	searcher itemSearcher
+	// api is the router without the outer middleware, for batch operations.
+	api http.Handler
+	ui  *template.Template
+	// generation reports the store's generation of a tenant's items for
+	// list ETags; nil when the store can't provide one cheaply.
+	generation func(tenant string) string
+	breakers   []*circuitBreaker
+	ws         *wsHub
+	events     *eventStreams
+	webhooks   *webhookDispatcher
+	// broker publishes item changes to BROKER_URL, or nowhere.
+	broker Publisher
+	// redis is the REDIS_URL connection, if any.
+	redis *redis.Client
+	// sessions is nil without SESSION_SECRET.
+	sessions *sessionManager
+	jobs     *jobQueue
+	tasks    *scheduler
+	// warmup tracks the boot-time warm-up that /readyz waits for.
+	warmup *warmupState
+	// apiLimit and delayLimit bound concurrent /api and /delay requests.
+	apiLimit   *concurrencyLimiter
+	delayLimit *concurrencyLimiter
+	// dumps writes profiles to DUMP_DIR; nil without ENABLE_ADMIN.
+	dumps *dumper
+	// loadTests runs POST /admin/loadtest, with ENABLE_ADMIN.
+	loadTests   *loadTester
+	maintenance *maintenanceMode
+	// readOnly refuses item writes after repeated write failures or POST
+	// /admin/readonly.
+	readOnly *readOnlyMode
+	// recorder keeps recent exchanges for /debug/requests; nil without
+	// ENABLE_RECORDER.
+	recorder *recorder
+	// tenants scopes API and webhook requests to their X-Tenant-ID.
+	tenants *tenants
+	// audit records mutating API requests for GET /api/v1/audit.
+	audit *auditLog
+	// signatures verifies X-Signature on the /api routes; nil without
+	// SIGNING_SECRET.
+	signatures *signatureVerifier
+	// conns and adminConns track the connections of the public and admin
+	// listeners, once main hooks them into their http.Server; adminConns is
+	// nil without ADMIN_PORT.
+	conns      *connTracker
+	adminConns *connTracker
+	// registry sends heartbeats to REGISTRY_URL; nil without it.
+	registry *registryClient
+	// shadow mirrors /api requests to SHADOW_URL; nil without it.
+	shadow *shadowMirror
+}
+
+// NewServer wires a Server around store. A nil clock means the system
+// clock. Stores that implement Checker are added to the readiness checks.
+func NewServer(cfg Config, logger *slog.Logger, store Store, clock Clock) (*Server, error) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	build := readBuildInfo()
+	build.Version = cfg.Version
+	instance := readInstanceInfo(os.Getenv)
+
+	cors := newCORSPolicy(cfg.CORSAllowedOrigins)
+	s := &Server{
+		cfg:          cfg,
+		clock:        clock,
+		logger:       logger,
+		accessLogger: logger,
+		build:        build,
+		instance:     instance,
+		started:      clock.Now(),
+		checks:       newCheckRegistry(),
+		faults:       newFaultInjector(clock.Now),
+		limiter:      newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitExempt),
+		cors:         cors,
+		flags:        newFlags(cfg.Features, cfg.DebugEndpoints),
+		ws:           newWSHub(cfg.WSMaxConnections, cfg.WSMaxMessageBytes, cors),
+		events:       newEventStreams(cfg.EventsInterval, instance.Hostname, clock),
+		idem:         newIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys, clock.Now),
+		webhooks:     newWebhookDispatcher(cfg.HTTPClient, cfg.WebhookTimeout, cfg.WebhookMaxAttempts, cfg.WebhookRetryBackoff, clock.Now, logger),
+		jobs:         newJobQueue(cfg.JobWorkers, cfg.JobTimeout, cfg.JobRetention, clock.Now, logger),
+		tasks:        newScheduler(clock.Now, logger),
+		warmup:       newWarmupState(),
+		maintenance:  newMaintenanceMode(cfg, clock.Now()),
+		readOnly:     newReadOnlyMode(store, cfg.ReadOnlyAfterFailures, cfg.ReadOnlyProbeInterval, clock.Now, logger),
+		tenants:      newTenants(cfg),
+		conns:        newConnTracker("public", clock.Now),
+		signatures:   newSignatureVerifier(cfg.SigningSecrets, cfg.SigningMaxSkew, cfg.MaxBodyBytes, clock.Now),
+		shadow:       newShadowMirror(cfg.ShadowURL, cfg.HTTPClient, cfg.ShadowTimeout, cfg.ShadowPercent, cfg.ShadowWorkers, cfg.ShadowQueueSize, cfg.DebugEndpoints, logger),
+
+		apiLimit:   newConcurrencyLimiter("api", cfg.ConcurrencyAPI),
+		delayLimit: newConcurrencyLimiter("delay", cfg.ConcurrencyDelay),
+
+		broker: noopPublisher{},
+
+		shutdowns: make(chan shutdownRequest, 1),
+
+		fetchClient: newFetchClient(cfg.HTTPClient, cfg.FetchAllowedHosts, cfg.FetchMaxRedirects),
+	}
+	s.checks.Register(s.faults)
+	if cfg.AdminPort != "" {
+		s.adminConns = newConnTracker("admin", clock.Now)
+	}
+	if cfg.RegistryURL != "" {
+		s.registry = newRegistryClient(cfg.RegistryURL, newHTTPClient(cfg.HTTPClient, cfg.RegistryTimeout), cfg.RegistryInterval, clock.Now, logger, s.registryInstance)
+		s.registry.Contribute("jobs", func() any { return map[string]int{"queued": s.jobs.Queued()} })
+		s.registry.Contribute("websocket", func() any { return map[string]int{"clients": s.ws.Count()} })
+	}
+	if g, ok := store.(generationStore); ok {
+		s.generation = g.Generation
+	}
+	if a, ok := store.(atomicStore); ok {
+		s.atomic = readOnlyAtomic{a, s.readOnly}
+	}
+	if x, ok := store.(itemSearcher); ok {
+		s.searcher = x
+	}
+	guarded := store
+	if c, ok := store.(Checker); ok {
+		s.checks.Register(c)
+		// A store with a readiness check depends on an external service.
+		if b := s.newBreaker(c.Name()); b != nil {
+			guarded = breakerStore{store, b}
+		}
+	}
+	s.store = instrumentedStore{readOnlyStore{guarded, s.readOnly}}
+	ui, err := parseUITemplate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid /ui template: %w", err)
+	}
+	s.ui = ui
+	static, err := newStaticFiles(cfg.StaticDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STATIC_DIR: %w", err)
+	}
+	s.static = static
+	if cfg.EnableAdmin {
+		dumps, err := newDumper(cfg.DumpDir, cfg.DumpCooldown, clock.Now, logger)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create DUMP_DIR: %w", err)
+		}
+		s.dumps = dumps
+		s.loadTests = newLoadTester(cfg, logger)
+	}
+	if cfg.EnableRecorder {
+		s.recorder = newRecorder(cfg.RecorderSize, cfg.RecorderMaxBodyBytes, clock.Now)
+	}
+	audit, err := newAuditLog(cfg.AuditSize, cfg.AuditFile, clock.Now, logger)
+	if err != nil {
+		return nil, err
+	}
+	s.audit = audit
+	if cfg.UploadDir != "" {
+		if err := os.MkdirAll(cfg.UploadDir, 0o755); err != nil {
+			return nil, fmt.Errorf("cannot create UPLOAD_DIR: %w", err)
+		}
+	}
+	if cfg.BackendURL != "" {
+		target, err := url.Parse(cfg.BackendURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BACKEND_URL: %w", err)
+		}
+		s.backend = newBackendProxy(cfg.HTTPClient, target, cfg.BackendTimeout, s.newBreaker("backend"))
+		s.checks.Register(s.backend)
+	}
+	if cfg.RedisURL != "" {
+		// A Redis items store brings its own connection and readiness
+		// check; share them.
+		var client *redis.Client
+		if rs, ok := store.(*redisStore); ok {
+			client = rs.client
+		} else {
+			if client, err = openRedis(cfg.RedisURL); err != nil {
+				return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+			}
+			s.checks.Register(redisCheck{client})
+		}
+		s.redis = client
+		s.limiter.redis, s.limiter.prefix = client, cfg.RedisKeyPrefix
+		s.idem = &redisIdempotency{client: client, prefix: cfg.RedisKeyPrefix, ttl: cfg.IdempotencyTTL}
+	}
+	if cfg.SessionSecret != "" {
+		s.sessions = &sessionManager{
+			secret: []byte(cfg.SessionSecret),
+			ttl:    cfg.SessionTTL,
+			secure: cfg.TLSCertFile != "",
+			store:  newMemorySessions(cfg.SessionTTL, clock.Now),
+		}
+		if s.redis != nil {
+			s.sessions.store = &redisSessions{client: s.redis, prefix: cfg.RedisKeyPrefix, ttl: cfg.SessionTTL}
+		}
+	}
+	if cfg.BrokerURL != "" {
+		conn, err := openBrokerConn(cfg.BrokerURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BROKER_URL: %w", err)
+		}
+		broker := newBrokerPublisher(conn, cfg.BrokerSubject, cfg.BrokerBufferSize, cfg.BrokerTimeout, logger)
+		s.checks.Register(broker)
+		s.broker = broker
+	}
+
+	if cfg.jwtEnabled() {
+		verifier, err := newJWTVerifier(cfg.HTTPClient, cfg.JWTJWKSURL, cfg.JWTPublicKey, cfg.JWTIssuer, cfg.JWTAudience)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT configuration: %w", err)
+		}
+		s.verifier = verifier
+	}
+	s.active.Store(&cfg)
+	s.registerTasks()
+	return s, nil
+}
+
+// newBreaker returns a circuit breaker for the named dependency, recorded
+// for /readyz, or nil when breakers are disabled.
+func (s *Server) newBreaker(name string) *circuitBreaker {
+	b := newCircuitBreaker(name, s.cfg.CircuitBreakerThreshold, s.cfg.CircuitBreakerOpenDuration, s.clock.Now, s.logger)
+	if b != nil {
+		s.breakers = append(s.breakers, b)
+	}
+	return b
+}
+
+// runMaintenance runs the server's scheduled tasks until ctx is cancelled.
+func (s *Server) runMaintenance(ctx context.Context) {
+	if s.registry != nil {
+		go s.registry.Run(ctx)
+	}
+	s.tasks.Run(ctx)
+}
+
+// authenticate applies whichever of API keys, JWTs, and client certificates
+// are configured to a protected route, timing them for Server-Timing.
+func (s *Server) authenticate(h http.HandlerFunc) http.HandlerFunc {
+	if len(s.cfg.APIKeys) == 0 && s.verifier == nil && s.cfg.TLSClientCAFile == "" {
+		return h
+	}
+	return timeAuth(func(h http.HandlerFunc) http.HandlerFunc {
+		if len(s.cfg.APIKeys) > 0 {
+			h = requireAPIKey(h, s.cfg.APIKeys)
+		}
+		if s.verifier != nil {
+			h = requireJWT(h, s.verifier)
+		}
+		if s.cfg.TLSClientCAFile != "" {
+			h = requireClientCert(h)
+		}
+		return h
+	}, h)
+}
+
+// requireAuth is authenticate as a Middleware for route groups.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return s.authenticate(next.ServeHTTP)
+}
+
+// adminEnabled reports whether the admin endpoints, /admin/fault and
+// POST /stats/reset, are served. Fault injection can take the pod down, so
+// they are only registered when authenticate has credentials to check.
+func (s *Server) adminEnabled() bool {
+	return len(s.cfg.APIKeys) > 0 || s.verifier != nil || s.cfg.TLSClientCAFile != ""
+}
+
+// Routes registers every endpoint and returns the full middleware chain
+// around them. With ADMIN_PORT set, the probes, /metrics, /debug, and /admin
+// move to a second handler for the admin listener so the ingress never sees
+// them; otherwise admin is nil and public serves everything.
+func (s *Server) Routes() (public, admin http.Handler) {
+	mux := http.NewServeMux()
+	routes := newDocumentedMux(mux, s.build.Version)
+	routes.RequireAuth(len(s.cfg.APIKeys) > 0, s.verifier != nil)
+	ops := routes
+	if s.cfg.AdminPort != "" {
+		ops = newDocumentedMux(http.NewServeMux(), s.build.Version)
+		ops.RequireAuth(len(s.cfg.APIKeys) > 0, s.verifier != nil)
+	}
+
+	// Each route is registered with its OpenAPI operations so /openapi.json
+	// stays in step with what the server actually serves.
+	getOp := func(summary string, responses map[string]Response) Operation {
+		return Operation{Method: http.MethodGet, Summary: summary, Responses: responses}
+	}
+	liveness := getOp("Liveness probe", map[string]Response{
+		"200": jsonResponse("Process is running", "HealthResponse"),
+		"500": jsonResponse("An unhealthy fault is injected", "HealthResponse"),
+	})
+	itemID := pathParam("id", "Item ID", stringSchema())
+	fieldsParam := queryParam("fields", "Comma-separated item fields to return, id always included: "+strings.Join(itemFields, ", "), stringSchema())
+	excludeParam := queryParam("exclude", "Comma-separated item fields to leave out, instead of fields", stringSchema())
+	ifMatch := headerParam("If-Match", "Apply only if the item's ETag (its version) is still this one", stringSchema())
+	bodyErrors := map[string]Response{
+		"400": errorResponse("Malformed JSON"),
+		"413": errorResponse("Body too large"),
+		"415": errorResponse("Unsupported Content-Type"),
+		"422": errorResponse("Body failed validation"),
+	}
+	withResponses := func(base map[string]Response, extra map[string]Response) map[string]Response {
+		merged := make(map[string]Response, len(base)+len(extra))
+		for code, resp := range base {
+			merged[code] = resp
+		}
+		for code, resp := range extra {
+			merged[code] = resp
+		}
+		return merged
+	}
+
+	hello := getOp("Hello message", map[string]Response{
+		"200": jsonResponse("Greeting", "MessageResponse"),
+		"400": errorResponse("Unsupported lang or invalid name"),
+	})
+	hello.Parameters = []Parameter{
+		queryParam("lang", "Greeting language, overriding Accept-Language", enumSchema(enumValues(supportedLangs())...)),
+		queryParam("name", fmt.Sprintf("Who to greet, at most %d characters", maxHelloName), stringSchema()),
+	}
+	helloPost := hello
+	helloPost.Method = http.MethodPost
+	helloPost.Summary = "Hello message for the name in the body"
+	helloPost.Parameters = hello.Parameters[:1]
+	helloPost.RequestBody = jsonBody("HelloRequest")
+	helloPost.Responses = withResponses(bodyErrors, map[string]Response{"200": hello.Responses["200"]})
+	delete(helloPost.Responses, "422")
+	helloPost.Responses["400"] = errorResponse("Malformed JSON, unsupported lang, or invalid name")
+	routes.Route("/{$}", instrument("/", allowMethods(s.helloHandler, "GET", "HEAD", "POST")),
+		hello, helloPost)
+	if s.sessions != nil {
+		routes.Route("GET /session", instrument("/session", s.sessionHandler), getOp("The visitor's session", map[string]Response{
+			"200": jsonResponse("The session named by the session cookie", "Session"),
+			"404": errorResponse("No valid session cookie, or the session expired"),
+		}))
+		routes.Route("DELETE /session", instrument("/session", s.sessionHandler), Operation{
+			Summary: "End the visitor's session and clear its cookie",
+			Responses: map[string]Response{
+				"204": {Description: "Ended, or there was none"},
+			},
+		})
+	}
+	ops.Route("/health", instrument("/health", allowMethods(s.livenessHandler, "GET", "HEAD")),
+		Operation{Method: http.MethodGet, Summary: "Alias for /healthz", Responses: liveness.Responses})
+	ops.Route("/healthz", instrument("/healthz", allowMethods(s.livenessHandler, "GET", "HEAD")), liveness)
+	ops.Route("/readyz", instrument("/readyz", allowMethods(s.readinessHandler, "GET", "HEAD")),
+		getOp("Readiness probe", map[string]Response{
+			"200": jsonResponse("Ready for traffic", "HealthResponse"),
+			"503": jsonResponse("Starting, draining, or a dependency check failed", "HealthResponse"),
+		}))
+	routes.Route("/version", instrument("/version", allowMethods(s.staticJSON("/version", s.build), "GET", "HEAD")),
+		getOp("Build information", map[string]Response{"200": jsonResponse("Build metadata", "BuildInfo")}))
+	routes.Route("/ip", instrument("/ip", allowMethods(s.ipHandler, "GET", "HEAD")),
+		getOp("The caller's IP as seen by the server", map[string]Response{"200": jsonResponse("Peer address and resolved client IP", "IPResponse")}))
+	timeOp := getOp("The server's clock", map[string]Response{
+		"200": jsonResponse("Current time and uptime", "TimeResponse"),
+		"400": errorResponse("Unknown tz or format"),
+	})
+	timeOp.Parameters = []Parameter{
+		queryParam("tz", "IANA time zone for rfc3339, human, and formatted (default UTC)", stringSchema()),
+		queryParam("format", "Also render the time in this format, as formatted", enumSchema(enumValues(timeFormatNames())...)),
+	}
+	routes.Route("/time", instrument("/time", allowMethods(s.timeHandler, "GET", "HEAD")), timeOp)
+
+	// The API is served under /api/v1 and, deprecated, at the original
+	// unversioned paths. Later versions are further groups.
+	v1 := routes.Group("/api/v1", "v1", s.audit.Middleware, s.requireAuth, s.signatures.Middleware, s.tenants.Middleware, s.apiLimit.Middleware, s.shadow.Middleware)
+	for _, g := range []*routeGroup{v1, routes.Group("/api", "v1", s.audit.Middleware, s.requireAuth, s.signatures.Middleware, s.tenants.Middleware, s.apiLimit.Middleware, s.shadow.Middleware).Deprecate("/api/v1")} {
+		g.Route("", requireContentType(s.apiHandler, "application/json"), routes.Secured(
+			getOp("API test", map[string]Response{"200": jsonResponse("API is working", "MessageResponse")}),
+			Operation{
+				Method:      http.MethodPost,
+				Summary:     "Echo JSON data",
+				RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}}},
+				Responses: withResponses(bodyErrors, map[string]Response{
+					"200": {Description: "The decoded body and a timestamp", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}}},
+				}),
+			},
+		)...)
+
+		g.Route("POST /items", withIdempotency(requireContentType(s.createItemHandler, "application/json"), s.idem), routes.Secured(Operation{
+			Summary:     "Create an item",
+			Tags:        []string{"items"},
+			Parameters:  []Parameter{headerParam(idempotencyKeyHeader, "Replay the original response when this request is retried", stringSchema())},
+			RequestBody: jsonBody("ItemRequest"),
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"201": jsonResponse("Created; Location points at the item", "Item"),
+				"409": errorResponse("Idempotency-Key reused with a different body"),
+			}),
+		})...)
+		g.Route("GET /items", s.listItemsHandler, routes.Secured(Operation{
+			Summary: "List items",
+			Tags:    []string{"items"},
+			Parameters: []Parameter{
+				queryParam("limit", fmt.Sprintf("Page size, 1 to %d (default %d)", s.cfg.ItemsMaxLimit, defaultPageLimit), integerSchema()),
+				queryParam("offset", "Items to skip", integerSchema()),
+				queryParam("sort", "Sort field", enumSchema("created_at", "name")),
+				queryParam("order", "Sort direction", enumSchema("asc", "desc")),
+				queryParam("name", "Only items whose name starts with this prefix", stringSchema()),
+				queryParam("after", "Return items after this item ID (cursor pagination)", stringSchema()),
+				queryParam("include_deleted", "List soft-deleted items too, with deleted_at set", &Schema{Type: "boolean"}),
+				queryParam("format", "ndjson streams every matching item, one per line, like Accept: application/x-ndjson; limit is then optional and uncapped", enumSchema("json", "ndjson")),
+				fieldsParam, excludeParam,
+			},
+			Responses: map[string]Response{
+				"200": {Description: "A page of items, or a stream of them", Content: map[string]MediaType{
+					"application/json": {Schema: schemaRef("ItemList")},
+					contentTypeNDJSON:  {Schema: schemaRef("Item")},
+				}},
+				"400": errorResponse("Invalid query parameter"),
+				"404": errorResponse("Unknown after cursor"),
+			},
+		})...)
+		g.Route("GET /items/{id}", s.getItemHandler, routes.Secured(Operation{
+			Summary:    "Get an item",
+			Tags:       []string{"items"},
+			Parameters: []Parameter{itemID, fieldsParam, excludeParam},
+			Responses: map[string]Response{
+				"200": jsonResponse("The item", "Item"),
+				"404": errorResponse("No such item"),
+			},
+		})...)
+		g.Route("PUT /items/{id}", requireContentType(s.updateItemHandler, "application/json"), routes.Secured(Operation{
+			Summary:     "Replace an item",
+			Tags:        []string{"items"},
+			Parameters:  []Parameter{itemID, ifMatch},
+			RequestBody: jsonBody("ItemRequest"),
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"200": jsonResponse("The updated item", "Item"),
+				"404": errorResponse("No such item"),
+				"409": errorResponse("The item is no longer at the expected version"),
+				"428": errorResponse("No If-Match or version, with STRICT_CONCURRENCY"),
+			}),
+		})...)
+		g.Route("PATCH /items/{id}", requireContentType(s.patchItemHandler, "application/merge-patch+json", "application/json"), routes.Secured(Operation{
+			Summary:     "Merge-patch an item",
+			Tags:        []string{"items"},
+			Parameters:  []Parameter{itemID, ifMatch},
+			RequestBody: jsonBody("ItemPatch", "application/merge-patch+json", "application/json"),
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"200": jsonResponse("The patched item", "Item"),
+				"404": errorResponse("No such item"),
+				"409": errorResponse("The item is no longer at the expected version"),
+				"428": errorResponse("No If-Match or version, with STRICT_CONCURRENCY"),
+			}),
+		})...)
+		g.Route("POST /batch", requireContentType(s.batchHandler, "application/json"), routes.Secured(Operation{
+			Summary:     "Run several API calls in one request",
+			Description: fmt.Sprintf("Runs up to %d operations in order and returns a result for each. With ?atomic=true the first failure stops the batch and rolls every change back.", s.cfg.BatchMaxOperations),
+			Parameters:  []Parameter{queryParam("atomic", "Roll back all changes if any operation fails", &Schema{Type: "boolean"})},
+			RequestBody: jsonBody("BatchRequest"),
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"200": jsonResponse("A result per operation", "BatchResponse"),
+				"409": jsonResponse("An atomic batch failed and was rolled back", "BatchResponse"),
+			}),
+		})...)
+		g.Route("POST /transform", requireContentType(s.transformHandler, "application/json"), routes.Secured(Operation{
+			Summary:     "Apply text operations, optionally repeatedly",
+			Description: "Runs ops over text in order and returns every step. repeat re-runs the pipeline to generate CPU load, e.g. for demoing CPU-based autoscaling.",
+			RequestBody: jsonBody("TransformRequest"),
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"200": jsonResponse("Each step and the work done", "TransformResponse"),
+				"400": errorResponse("Malformed JSON, unsupported operation, or too much work"),
+			}),
+		})...)
+		g.Route("GET /uuid", s.uuidHandler, routes.Secured(Operation{
+			Summary: "Generate UUIDs",
+			Parameters: []Parameter{
+				queryParam("version", "4 (random, the default) or 7 (time-ordered)", enumSchema("4", "7")),
+				queryParam("count", fmt.Sprintf("Return this many, 1 to %d, as a JSON array of strings", maxUUIDCount), integerSchema()),
+			},
+			Responses: map[string]Response{
+				"200": jsonResponse("A UUID, or an array of count UUIDs", "UUIDResponse"),
+				"400": errorResponse("Invalid version or count"),
+			},
+		})...)
+		g.Route("GET /random", s.randomHandler, routes.Secured(Operation{
+			Summary: "Generate cryptographically random bytes",
+			Parameters: []Parameter{
+				queryParam("bytes", fmt.Sprintf("How many bytes, 1 to %d (default 32)", maxRandomBytes), integerSchema()),
+				queryParam("encoding", "hex (the default) or base64", enumSchema("hex", "base64")),
+				queryParam("count", fmt.Sprintf("Return this many, 1 to %d, as a JSON array of strings", maxRandomCount), integerSchema()),
+			},
+			Responses: map[string]Response{
+				"200": jsonResponse("Random data, or an array of count encodings", "RandomResponse"),
+				"400": errorResponse("Invalid bytes, encoding, or count"),
+			},
+		})...)
+		g.Route("DELETE /items/{id}", s.deleteItemHandler, routes.Secured(Operation{
+			Summary:     "Delete an item",
+			Description: "Soft-deletes the item, which POST /items/{id}/restore brings back until DELETED_ITEM_RETENTION passes. ?hard=true purges it at once.",
+			Tags:        []string{"items"},
+			Parameters:  []Parameter{itemID, queryParam("hard", "Purge the item, deleted or not, instead of soft-deleting it", &Schema{Type: "boolean"})},
+			Responses: map[string]Response{
+				"204": {Description: "Deleted"},
+				"400": errorResponse("Invalid hard parameter"),
+				"404": errorResponse("No such item"),
+			},
+		})...)
+		g.Route("POST /items/{id}/restore", s.restoreItemHandler, routes.Secured(Operation{
+			Summary:    "Restore a deleted item",
+			Tags:       []string{"items"},
+			Parameters: []Parameter{itemID},
+			Responses: map[string]Response{
+				"200": jsonResponse("The restored item, or the item as it is if it wasn't deleted", "Item"),
+				"404": errorResponse("The item was purged or never existed (code item_purged)"),
+			},
+		})...)
+		g.Route("GET /items/search", s.searchItemsHandler, routes.Secured(Operation{
+			Summary:     "Search items",
+			Description: "Matches q case-insensitively as a substring of item names and of the strings in their data, ranked best first. Each result lists the fields that matched, with the match's character offsets in highlight.",
+			Tags:        []string{"items"},
+			Parameters: []Parameter{
+				{Name: "q", In: "query", Required: true, Description: fmt.Sprintf("Text to find, %d to %d characters", searchMinQuery, searchMaxQuery), Schema: stringSchema()},
+				queryParam("fuzzy", "Also match fields sharing at least half of q's three-character sequences, for typos", &Schema{Type: "boolean"}),
+				queryParam("limit", fmt.Sprintf("Page size, 1 to %d (default %d)", s.cfg.ItemsMaxLimit, defaultPageLimit), integerSchema()),
+				queryParam("offset", "Results to skip", integerSchema()),
+			},
+			Responses: map[string]Response{
+				"200": jsonResponse("A page of results", "SearchResponse"),
+				"400": errorResponse("q is missing, too short, or too long, or another parameter is invalid"),
+			},
+		})...)
+		g.Route("GET /items/export", s.exportItemsHandler, routes.Secured(Operation{
+			Summary:     "Download items",
+			Description: "Streams every item the list filters select as a JSON array or CSV, with Content-Disposition: attachment. The result imports unchanged.",
+			Tags:        []string{"items"},
+			Parameters: []Parameter{
+				queryParam("format", "Encoding; without it Accept: text/csv picks CSV, and anything else JSON", enumSchema("json", "csv")),
+				queryParam("sort", "Sort field", enumSchema("created_at", "name")),
+				queryParam("order", "Sort direction", enumSchema("asc", "desc")),
+				queryParam("name", "Only items whose name starts with this prefix", stringSchema()),
+				queryParam("include_deleted", "Export soft-deleted items too", &Schema{Type: "boolean"}),
+				queryParam("limit", "Export at most this many items (default all)", integerSchema()),
+			},
+			Responses: map[string]Response{
+				"200": {Description: "The items", Content: map[string]MediaType{
+					"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("Item")}},
+					contentTypeCSV:     {Schema: &Schema{Type: "string", Description: "Columns " + strings.Join(csvColumns, ", ") + "; data is a JSON object"}},
+				}},
+				"400": errorResponse("Invalid query parameter"),
+			},
+		})...)
+		g.Route("POST /echo", s.bodyEchoHandler, routes.Secured(Operation{
+			Summary:     "Describe a form, multipart, text, or binary body",
+			Description: "The Content-Type picks the parser, which the response names: form fields, multipart part names with file names and sizes (files are discarded), text with its length, or for anything else the size, SHA-256, and a base64 preview of the first ECHO_PREVIEW_BYTES.",
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{"*/*": {Schema: stringSchema()}}},
+			Responses: map[string]Response{
+				"200": jsonResponse("What the body contained", "BodyEchoResponse"),
+				"400": errorResponse("Malformed form or multipart body"),
+				"413": errorResponse("Body larger than MAX_BODY_BYTES"),
+			},
+		})...)
+		g.Route("POST /items/import", requireContentType(s.importItemsHandler, "application/json", contentTypeCSV), routes.Secured(Operation{
+			Summary:     "Create items in bulk",
+			Description: "Reads a JSON array of items, or CSV with a header row naming name and optionally data, as GET /items/export writes them; other keys and columns are ignored. Rows are validated like a create and reported by line when they fail. IMPORT_MAX_BYTES caps the body.",
+			Tags:        []string{"items"},
+			Parameters: []Parameter{
+				queryParam("on_conflict", "For rows whose name an item already has: skip them (default), overwrite that item, or fail the row", enumSchema(importSkip, importOverwrite, importFail)),
+				queryParam("atomic", "Roll the whole import back if any row fails; needs the in-memory or file store", &Schema{Type: "boolean"}),
+				queryParam("format", "Encoding, instead of going by the Content-Type", enumSchema("json", "csv")),
+			},
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{
+				"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("ItemRequest")}},
+				contentTypeCSV:     {Schema: &Schema{Type: "string"}},
+			}},
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"200": jsonResponse("What was imported, skipped, and failed", "ImportSummary"),
+				"409": jsonResponse("An atomic import had a failed row and was rolled back", "ImportSummary"),
+			}),
+		})...)
+		if s.adminEnabled() {
+			g.Route("GET /audit", s.auditHandler, routes.Secured(Operation{
+				Summary:     "List audit entries, newest first",
+				Description: "Every POST, PUT, PATCH, and DELETE under /api, failed ones included, across all tenants. Filter with `principal`, `tenant`, a `path` prefix, and an RFC 3339 `since`/`until` range.",
+				Parameters: []Parameter{
+					queryParam("principal", "Only entries by this API key name or JWT subject", stringSchema()),
+					queryParam("tenant", "Only entries for this tenant", stringSchema()),
+					queryParam("path", "Only entries whose path starts with this", stringSchema()),
+					queryParam("since", "Only entries at or after this time", formatSchema("string", "date-time")),
+					queryParam("until", "Only entries before this time", formatSchema("string", "date-time")),
+					queryParam("failed", "Only failed (true) or successful (false) requests", &Schema{Type: "boolean"}),
+					queryParam("limit", "At most this many entries (default 100), up to AUDIT_SIZE", integerSchema()),
+				},
+				Responses: map[string]Response{
+					"200": jsonResponse("Matching entries", "AuditList"),
+					"400": errorResponse("Invalid query parameter"),
+				},
+			})...)
+		}
+	}
+	v1.Route("POST /validate", requireContentType(s.validateHandler, "application/json"), routes.Secured(Operation{
+		Summary:     "Validate a typed request body",
+		Description: "Rejects unknown fields and reports every invalid field at once in details.",
+		RequestBody: jsonBody("ValidateRequest"),
+		Responses: withResponses(bodyErrors, map[string]Response{
+			"200": {Description: "The accepted body under value", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}}},
+		}),
+	})...)
+	routes.Group("/api/v2", "v2", s.audit.Middleware, s.requireAuth, s.signatures.Middleware, s.tenants.Middleware, s.apiLimit.Middleware, s.shadow.Middleware).Route("", requireContentType(s.apiV2Handler, "application/json"), routes.Secured(
+		getOp("API test, enveloped", map[string]Response{"200": jsonResponse("API is working", "Envelope")}),
+		Operation{
+			Method:      http.MethodPost,
+			Summary:     "Echo JSON data, enveloped",
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}}},
+			Responses:   withResponses(bodyErrors, map[string]Response{"200": jsonResponse("The decoded body under data.received", "Envelope")}),
+		},
+	)...)
+
+	routes.Route("GET /ws", instrument("/ws", s.ws.ServeHTTP), Operation{
+		Summary:     "WebSocket echo",
+		Description: "Upgrades to a WebSocket and echoes every text and binary message.",
+		Responses: map[string]Response{
+			"101": {Description: "Switched to the WebSocket protocol"},
+			"503": errorResponse("Connection limit reached"),
+		},
+	})
+	routes.Timeout("/ws", 0)
+	routes.Route("GET /delay/{duration}", instrument("/delay/{duration}", s.delayLimit.Middleware(http.HandlerFunc(s.delayHandler)).ServeHTTP), Operation{
+		Summary: "Respond after a delay",
+		Parameters: []Parameter{
+			pathParam("duration", fmt.Sprintf("How long to wait, e.g. 500ms, up to %s", s.cfg.DelayMax), stringSchema()),
+			queryParam("jitter", "Random extra delay of up to this duration", stringSchema()),
+		},
+		Responses: map[string]Response{
+			"200": jsonResponse("How long the server waited", "MessageResponse"),
+			"400": errorResponse("Invalid or out-of-range duration"),
+			"503": errorResponse("MAX_CONCURRENT_DELAY requests are already running"),
+		},
+	})
+	routes.Route("GET /fetch", instrument("/fetch", s.fetchHandler), Operation{
+		Summary:     "Make an outbound GET request",
+		Description: "Calls an allowlisted URL and summarizes the response. Targets must match FETCH_ALLOWED_HOSTS.",
+		Parameters:  []Parameter{{Name: "url", In: "query", Description: "Absolute http or https URL", Required: true, Schema: formatSchema("string", "uri")}},
+		Responses: map[string]Response{
+			"200": jsonResponse("The upstream response summary", "FetchResponse"),
+			"400": errorResponse("Missing or invalid url"),
+			"403": errorResponse("Target not allowed"),
+			"502": errorResponse("Upstream request failed"),
+			"504": errorResponse("Upstream timed out"),
+		},
+	})
+	routes.Route("/ui", instrument("/ui", allowMethods(s.uiHandler, "GET", "HEAD")),
+		getOp("Status dashboard", map[string]Response{"200": {Description: "HTML page with health, faults, and request counts, refreshing every few seconds"}}))
+	routes.Route("GET /static/{path...}", instrument("/static/{path}", s.staticHandler), Operation{
+		Summary:     "Static frontend files",
+		Description: "Serves STATIC_DIR or the embedded demo frontend. Directories serve their index.html; ?v=<etag> marks a URL as immutable.",
+		Parameters:  []Parameter{pathParam("path", "File path; empty for index.html", stringSchema())},
+		Responses: map[string]Response{
+			"200": {Description: "The file, with a Content-Type from its extension"},
+			"304": {Description: "Unchanged since the ETag sent in If-None-Match"},
+			"404": errorResponse("No such file"),
+		},
+	})
+	if s.cfg.StaticSPAFallback {
+		routes.Route("GET /app/{path...}", instrument("/app/{path}", s.appHandler), Operation{
+			Summary:     "Single-page app",
+			Description: "Serves static files like /static/, falling back to index.html for unknown paths so client-side routes load.",
+			Parameters:  []Parameter{pathParam("path", "Client-side route or file path", stringSchema())},
+			Responses: map[string]Response{
+				"200": {Description: "The file or index.html"},
+			},
+		})
+	}
+	if s.cfg.UploadDir != "" {
+		routes.Route("POST /upload", instrument("/upload", requireContentType(s.uploadHandler, "multipart/form-data")), Operation{
+			Summary:     "Upload files",
+			Description: fmt.Sprintf("Stores each file part under UPLOAD_DIR. Files are limited to %d bytes each and %d in total, and must match UPLOAD_ALLOWED_TYPES.", s.cfg.UploadMaxFileBytes, s.cfg.UploadMaxTotalBytes),
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{"multipart/form-data": {Schema: &Schema{Type: "object", AdditionalProperties: formatSchema("string", "binary")}}}},
+			Responses: map[string]Response{
+				"201": {Description: "The stored files", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("UploadedFile")}}}},
+				"400": errorResponse("Malformed multipart body or no files"),
+				"413": errorResponse("A file or the whole upload is too large"),
+				"415": errorResponse("Not multipart, or a file type is not allowed"),
+			},
+		})
+		routes.Route("GET /upload/{name}", instrument("/upload/{name}", s.downloadHandler), Operation{
+			Summary:    "Download an uploaded file",
+			Parameters: []Parameter{pathParam("name", "Stored file name returned by POST /upload", stringSchema())},
+			Responses: map[string]Response{
+				"200": {Description: "The file, with a Content-Type from its extension"},
+				"404": errorResponse("No such file"),
+			},
+		})
+	}
+	if s.backend != nil {
+		// The backend documents its own API, so the proxy stays out of the spec.
+		routes.Handle(backendPrefix+"/", instrument(backendPrefix+"/", s.backend.ServeHTTP))
+	}
+	routes.Route("/echo", instrument("/echo", s.echoHandler), Operation{
+		Method:    http.MethodGet,
+		Summary:   "Inspect the request as received",
+		Responses: map[string]Response{"200": jsonResponse("The request", "EchoResponse")},
+	}, Operation{
+		Method:      http.MethodPost,
+		Summary:     "Inspect the request and its body",
+		Description: "Any method is accepted; the body is returned as text or base64.",
+		RequestBody: &RequestBody{Content: map[string]MediaType{"*/*": {Schema: stringSchema()}}},
+		Responses:   map[string]Response{"200": jsonResponse("The request", "EchoResponse")},
+	})
+	routes.Route("/status/{code}", instrument("/status/{code}", s.statusHandler), Operation{
+		Method:  http.MethodGet,
+		Summary: "Respond with the given status",
+		Parameters: []Parameter{
+			pathParam("code", "Status code from 100 to 599, except 101", integerSchema()),
+			queryParam("delay", "Wait this long before responding", stringSchema()),
+			queryParam("body", "Set to false to omit the JSON body", &Schema{Type: "boolean"}),
+		},
+		Responses: map[string]Response{
+			"default": jsonResponse("The requested status", "StatusResponse"),
+			"400":     errorResponse("Invalid status code or parameter"),
+		},
+	})
+	routes.Route("GET /events", instrument("/events", s.events.ServeHTTP), Operation{
+		Summary: "Server-sent heartbeat stream",
+		Parameters: []Parameter{
+			queryParam("count", "Close the stream after this many events", integerSchema()),
+			headerParam("Last-Event-ID", "Resume the sequence after this event ID", integerSchema()),
+		},
+		Responses: map[string]Response{
+			"200": {Description: "An event stream", Content: map[string]MediaType{"text/event-stream": {Schema: stringSchema()}}},
+			"400": errorResponse("Invalid count or Last-Event-ID"),
+		},
+	})
+	routes.Timeout("/events", 0)
+	jobID := pathParam("id", "Job ID", stringSchema())
+	routes.Route("POST /jobs", instrument("/jobs", s.authenticate(requireContentType(s.createJobHandler, "application/json"))), routes.Secured(Operation{
+		Summary:     "Queue a background job",
+		Description: fmt.Sprintf("Runs on one of %d workers with a %s timeout. Poll GET /jobs/{id} for progress and the result.", s.cfg.JobWorkers, s.cfg.JobTimeout),
+		RequestBody: jsonBody("JobRequest"),
+		Responses: withResponses(bodyErrors, map[string]Response{
+			"202": jsonResponse("Queued; Location points at the job", "Job"),
+			"503": errorResponse("Queue full or shutting down"),
+		}),
+	})...)
+	routes.Route("GET /jobs", instrument("/jobs", s.authenticate(s.listJobsHandler)), routes.Secured(
+		getOp("List recent jobs, newest first", map[string]Response{"200": {Description: "Jobs", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("Job")}}}}}),
+	)...)
+	routes.Route("GET /jobs/{id}", instrument("/jobs/{id}", s.authenticate(s.getJobHandler)), routes.Secured(Operation{
+		Summary:    "Get a job's status, progress, and result",
+		Parameters: []Parameter{jobID},
+		Responses: map[string]Response{
+			"200": jsonResponse("The job", "Job"),
+			"404": errorResponse("No such job, or it expired"),
+		},
+	})...)
+	routes.Route("DELETE /jobs/{id}", instrument("/jobs/{id}", s.authenticate(s.cancelJobHandler)), routes.Secured(Operation{
+		Summary:    "Cancel a job",
+		Parameters: []Parameter{jobID},
+		Responses: map[string]Response{
+			"200": jsonResponse("Cancelled", "Job"),
+			"202": jsonResponse("Running; cancellation requested", "Job"),
+			"404": errorResponse("No such job"),
+			"409": errorResponse("Job already finished"),
+		},
+	})...)
+	webhookID := pathParam("id", "Webhook ID", stringSchema())
+	routes.Route("/webhooks", instrument("/webhooks", s.authenticate(s.tenants.Middleware(requireContentType(s.webhooksHandler, "application/json")).ServeHTTP)), routes.Secured(
+		getOp("List webhooks", map[string]Response{"200": {Description: "Registered webhooks", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("Webhook")}}}}}),
+		Operation{
+			Method:      http.MethodPost,
+			Summary:     "Register a webhook",
+			Description: "Item changes are POSTed to the URL as a WebhookEvent, signed with the secret in X-Webhook-Signature when one is set. Targets must match WEBHOOK_ALLOWED_HOSTS.",
+			RequestBody: jsonBody("WebhookRequest"),
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"201": jsonResponse("The registered webhook", "Webhook"),
+				"403": errorResponse("Target not allowed"),
+			}),
+		},
+	)...)
+	routes.Route("DELETE /webhooks/{id}", instrument("/webhooks/{id}", s.authenticate(s.tenants.Middleware(http.HandlerFunc(s.deleteWebhookHandler)).ServeHTTP)), routes.Secured(Operation{
+		Summary:    "Remove a webhook",
+		Parameters: []Parameter{webhookID},
+		Responses: map[string]Response{
+			"204": {Description: "Removed"},
+			"404": errorResponse("No such webhook"),
+		},
+	})...)
+	routes.Route("GET /webhooks/{id}/deliveries", instrument("/webhooks/{id}/deliveries", s.authenticate(s.tenants.Middleware(http.HandlerFunc(s.webhookDeliveriesHandler)).ServeHTTP)), routes.Secured(Operation{
+		Summary:    "Recent delivery attempts, newest first",
+		Parameters: []Parameter{webhookID},
+		Responses: map[string]Response{
+			"200": {Description: "Delivery attempts", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("WebhookDelivery")}}}},
+			"404": errorResponse("No such webhook"),
+		},
+	})...)
+	ops.Route("/metrics", metricsHandler(), getOp("Prometheus metrics", map[string]Response{
+		"200": {Description: "Metrics in the Prometheus text format", Content: map[string]MediaType{"text/plain": {Schema: stringSchema()}}},
+	}))
+	routes.Route("GET /stats", http.HandlerFunc(s.statsHandler), Operation{
+		Summary:     "Request statistics",
+		Description: "Per-route counts, status classes, latency quantiles over recent requests, and runtime memory stats, since startup or the last reset.",
+		Responses:   map[string]Response{"200": jsonResponse("Request and runtime statistics", "StatsResponse")},
+	})
+	if s.cfg.DebugEndpoints {
+		ops.Route("/debug/panic", http.HandlerFunc(panicHandler), getOp("Trigger a panic to exercise recovery", map[string]Response{
+			"500": errorResponse("The recovered panic"),
+		}))
+		ops.Route("/debug/env", instrument("/debug/env", allowMethods(s.envHandler, "GET", "HEAD")),
+			getOp("Environment and runtime information", map[string]Response{"200": jsonResponse("Redacted environment and runtime stats", "EnvResponse")}))
+		ops.Route("/debug/config", instrument("/debug/config", allowMethods(s.configHandler, "GET", "HEAD")),
+			getOp("Active configuration", map[string]Response{"200": {Description: "Every setting as currently applied, with secrets redacted", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}}}}))
+		ops.Route("/debug/flags", instrument("/debug/flags", allowMethods(s.flagsHandler, "GET", "HEAD")),
+			getOp("Feature flags", map[string]Response{"200": {Description: "Each flag's value for this request and where it came from", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("FlagStatus")}}}}}))
+		ops.Route("/debug/startup", instrument("/debug/startup", allowMethods(s.startupHandler, "GET", "HEAD")),
+			getOp("Startup warm-up", map[string]Response{"200": jsonResponse("Each warm-up step's duration and error, and whether warm-up is done", "StartupStatus")}))
+		ops.Route("/debug/tasks", instrument("/debug/tasks", allowMethods(s.tasksHandler, "GET", "HEAD")),
+			getOp("Scheduled background tasks", map[string]Response{"200": {Description: "Each task's last run, duration, error, and next run", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("TaskStatus")}}}}}))
+		if s.registry != nil {
+			ops.Route("/debug/registry", instrument("/debug/registry", allowMethods(s.registryHandler, "GET", "HEAD")),
+				getOp("Registry heartbeats", map[string]Response{"200": jsonResponse("The last heartbeat sent to REGISTRY_URL, its response, and the next scheduled send", "RegistryStatus")}))
+		}
+	}
+	// Validate refuses ENABLE_RECORDER without credentials; this keeps
+	// the recordings closed for a Config that skipped it.
+	if s.recorder != nil && s.adminEnabled() {
+		recordedID := pathParam("id", "ID from GET /debug/requests", stringSchema())
+		ops.Route("GET "+recorderPath, instrument(recorderPath, s.authenticate(s.recordedRequestsHandler)), ops.Secured(Operation{
+			Summary:     "Recently recorded requests",
+			Description: "The last RECORDER_SIZE exchanges, newest first, other than these endpoints and the long-lived routes.",
+			Parameters:  []Parameter{queryParam("request_id", "Keep only the exchange with this X-Request-ID", stringSchema())},
+			Responses: map[string]Response{
+				"200": {Description: "Method, path, status, and latency of each", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "array", Items: schemaRef("RecordedSummary")}}}},
+			},
+		})...)
+		ops.Route("GET "+recorderPath+"/{id}", instrument(recorderPath+"/{id}", s.authenticate(s.recordedRequestHandler)), ops.Secured(Operation{
+			Summary:    "A recorded request and its response",
+			Parameters: []Parameter{recordedID},
+			Responses: map[string]Response{
+				"200": jsonResponse("Headers, with credentials redacted, and bodies up to RECORDER_MAX_BODY_BYTES", "RecordedExchange"),
+				"404": errorResponse("No such exchange, or it has been evicted"),
+			},
+		})...)
+		ops.Route("POST "+recorderPath+"/{id}/replay", instrument(recorderPath+"/{id}/replay", s.authenticate(s.replayRequestHandler)), ops.Secured(Operation{
+			Summary:     "Send a recorded request again and compare the responses",
+			Description: "Runs the recorded request through the current handlers with the caller's credentials in place of the redacted ones, then lists how the status, headers, and body differ from the recorded response. Date, X-Request-ID, and Server-Timing are not compared, nor a JSON body's top-level request_id. A replayed write is applied again.",
+			Parameters:  []Parameter{recordedID},
+			Responses: map[string]Response{
+				"200": jsonResponse("The replayed response and its differences", "ReplayResponse"),
+				"404": errorResponse("No such exchange, or it has been evicted"),
+				"409": errorResponse("Only part of the request body was recorded"),
+			},
+		})...)
+	}
+
+	if s.adminEnabled() {
+		routes.Route("POST /stats/reset", instrument("/stats/reset", s.authenticate(s.statsResetHandler)), routes.Secured(Operation{
+			Summary:   "Zero the /stats counters",
+			Responses: map[string]Response{"204": {Description: "Reset"}},
+		})...)
+		ops.Route("/admin/fault", instrument("/admin/fault", s.authenticate(requireContentType(s.faultsHandler, "application/json"))), ops.Secured(
+			getOp("List active faults", map[string]Response{"200": jsonResponse("Active faults", "FaultList")}),
+			Operation{
+				Method:      http.MethodPost,
+				Summary:     "Inject a fault",
+				RequestBody: jsonBody("FaultRequest"),
+				Responses:   withResponses(bodyErrors, map[string]Response{"201": jsonResponse("The active fault", "Fault")}),
+			},
+			Operation{Method: http.MethodDelete, Summary: "Clear all faults", Responses: map[string]Response{"204": {Description: "Cleared"}}},
+		)...)
+		ops.Route("/admin/maintenance", instrument("/admin/maintenance", s.authenticate(requireContentType(s.maintenanceHandler, "application/json"))), ops.Secured(
+			getOp("Maintenance mode, and who set it when", map[string]Response{"200": jsonResponse("Current state", "MaintenanceState")}),
+			Operation{
+				Method:      http.MethodPost,
+				Summary:     "Switch maintenance mode",
+				Description: "While enabled, everything but the probes, /metrics, /debug, and /admin answers 503 with Retry-After and a MaintenanceResponse body. A later change to MAINTENANCE in CONFIG_FILE overrides it again.",
+				RequestBody: jsonBody("MaintenanceRequest"),
+				Responses:   withResponses(bodyErrors, map[string]Response{"200": jsonResponse("The new state", "MaintenanceState")}),
+			},
+		)...)
+		ops.Route("/admin/readonly", instrument("/admin/readonly", s.authenticate(requireContentType(s.readOnlyHandler, "application/json"))), ops.Secured(
+			getOp("Read-only mode of the items API", map[string]Response{"200": jsonResponse("Current state", "ReadOnlyState")}),
+			Operation{
+				Method:      http.MethodPost,
+				Summary:     "Switch read-only mode",
+				Description: "While enabled, item reads go on and writes answer 503 with Retry-After and code read_only. Set this way, the mode stays until switched off here; entered after READ_ONLY_AFTER_FAILURES failed writes, it also ends once a write probe succeeds.",
+				RequestBody: jsonBody("ReadOnlyRequest"),
+				Responses:   withResponses(bodyErrors, map[string]Response{"200": jsonResponse("The new state", "ReadOnlyState")}),
+			},
+		)...)
+	}
+
+	// Process control is opt-in and never on the public port.
+	if s.cfg.EnableAdmin {
+		ops.Route("POST /admin/shutdown", instrument("/admin/shutdown", s.authenticate(s.adminShutdownHandler)), ops.Secured(Operation{
+			Summary:     "Shut down gracefully, as on SIGTERM",
+			Description: "Responds first, then waits `delay` and drains like SIGTERM before exiting with `exit_code`. The body is optional.",
+			RequestBody: &RequestBody{Content: map[string]MediaType{"application/json": {Schema: schemaRef("ShutdownRequest")}}},
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"202": jsonResponse("Shutdown scheduled", "ShutdownResponse"),
+				"409": errorResponse("A shutdown was already requested"),
+			}),
+		})...)
+		ops.Route("POST /admin/panic", instrument("/admin/panic", s.authenticate(s.adminPanicHandler)), ops.Secured(Operation{
+			Summary:     "Crash the process",
+			Description: "Responds first, then panics in a goroutine nothing recovers, so the process exits as on an unhandled bug.",
+			Responses:   map[string]Response{"202": jsonResponse("Crash scheduled", "MessageResponse")},
+		})...)
+		ops.Route("POST /admin/dump", instrument("/admin/dump", s.authenticate(s.dumpHandler)), ops.Secured(Operation{
+			Summary:     "Write goroutine, heap, and CPU profiles to DUMP_DIR",
+			Description: "Writes a goroutine profile (text, full stacks) and a heap profile, plus a CPU profile over `cpu` when given. The files stay in DUMP_DIR for GET /admin/dump/{file}.",
+			Parameters:  []Parameter{queryParam("cpu", "Also profile CPU for this long before answering, at most 1m, e.g. 10s", stringSchema())},
+			Responses: map[string]Response{
+				"201": jsonResponse("The files written", "DumpResponse"),
+				"400": errorResponse("Invalid cpu duration"),
+				"409": errorResponse("A CPU profile is already running"),
+			},
+		})...)
+		ops.Route("GET /admin/dump/{file}", instrument("/admin/dump/{file}", s.authenticate(s.dumpFileHandler)), ops.Secured(Operation{
+			Summary:    "Download a dump file",
+			Parameters: []Parameter{pathParam("file", "File name from POST /admin/dump", stringSchema())},
+			Responses: map[string]Response{
+				"200": {Description: "The profile", Content: map[string]MediaType{"application/octet-stream": {Schema: &Schema{Type: "string", Format: "binary"}}}},
+				"404": errorResponse("No such dump"),
+			},
+		})...)
+		// A CPU profile runs for as long as ?cpu= asks.
+		ops.Timeout("/admin/dump", 0)
+		loadTestID := pathParam("id", "Run ID returned by POST /admin/loadtest", stringSchema())
+		ops.Route("POST /admin/loadtest", instrument("/admin/loadtest", s.authenticate(requireContentType(s.startLoadTestHandler, "application/json"))), ops.Secured(Operation{
+			Summary:     "Generate load against this server or LOADTEST_TARGET_URL",
+			Description: "Sends GET requests to `target` at `rps` from `concurrency` workers for `duration`, carrying the caller's X-API-Key or Authorization header. One run at a time; LOADTEST_MAX_RPS, LOADTEST_MAX_DURATION, and LOADTEST_MAX_CONCURRENCY cap it.",
+			RequestBody: jsonBody("LoadTestRequest"),
+			Responses: withResponses(bodyErrors, map[string]Response{
+				"202": jsonResponse("Started; Location points at the run", "LoadTestStatus"),
+				"409": errorResponse("A run is already in progress"),
+			}),
+		})...)
+		ops.Route("GET /admin/loadtest/{id}", instrument("/admin/loadtest/{id}", s.authenticate(s.getLoadTestHandler)), ops.Secured(Operation{
+			Summary:    "Live stats of a load test run",
+			Parameters: []Parameter{loadTestID},
+			Responses: map[string]Response{
+				"200": jsonResponse("Achieved rate, latency percentiles, and status codes so far", "LoadTestStatus"),
+				"404": errorResponse("No such run, or it is too old to be kept"),
+			},
+		})...)
+		ops.Route("DELETE /admin/loadtest/{id}", instrument("/admin/loadtest/{id}", s.authenticate(s.cancelLoadTestHandler)), ops.Secured(Operation{
+			Summary:    "Stop a load test run",
+			Parameters: []Parameter{loadTestID},
+			Responses: map[string]Response{
+				"200": jsonResponse("Stopped, or already finished; the final stats", "LoadTestStatus"),
+				"404": errorResponse("No such run"),
+			},
+		})...)
+	}
+
+	// The spec documents itself last so it sees every other route. Each
+	// listener documents its own routes.
+	for _, d := range slices.Compact([]*documentedMux{routes, ops}) {
+		d.Route("/openapi.json", instrument("/openapi.json", allowMethods(s.staticJSON("/openapi.json", d.Spec()), "GET", "HEAD")),
+			getOp("This OpenAPI document", map[string]Response{"200": {Description: "OpenAPI 3.1 document", Content: map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}}}}))
+	}
+	routes.Route("/docs", instrument("/docs", allowMethods(s.docsHandler(), "GET", "HEAD")),
+		getOp("Interactive API documentation", map[string]Response{"200": {Description: "Swagger UI page", Content: map[string]MediaType{"text/html": {Schema: stringSchema()}}}}))
+
+	var pprofPaths []string
+	if s.cfg.EnablePprof {
+		registerPprof(ops.ServeMux)
+		// CPU profiles and traces run for as long as ?seconds= asks.
+		ops.Timeout("/debug/pprof/profile", 0)
+		ops.Timeout("/debug/pprof/trace", 0)
+		pprofPaths = []string{"/debug/pprof/"}
+	}
+
+	newRouter := func(d *documentedMux) (*router, http.Handler) {
+		rt := newRouter(d.ServeMux, d.Spec())
+		rt.notFound = s.notFoundHandler
+		rt.cacheControl = s.cfg.CacheControl
+		rt.timeout, rt.timeouts = s.cfg.RequestTimeout, d.timeouts
+		rt.serveCanonical = s.cfg.TrailingSlash == trailingSlashServe
+		mws := s.middleware(rt.timeouts)
+		if rt.serveCanonical {
+			// Inside the recovery backstop and outside everything that
+			// logs, traces, or counts the path.
+			mws = slices.Insert(mws, 1, rt.canonicalPaths)
+		}
+		return rt, Chain(rt, mws...)
+	}
+	s.api, public = newRouter(routes)
+	if ops == routes {
+		s.logRoutes("public", s.cfg.listenAddr(), routes, pprofPaths)
+		return public, nil
+	}
+	s.logRoutes("public", s.cfg.listenAddr(), routes, nil)
+	s.logRoutes("admin", ":"+s.cfg.AdminPort, ops, pprofPaths)
+	_, admin = newRouter(ops)
+	return public, admin
+}
+
+// logRoutes reports the endpoints d serves on a listener: each route at
+// debug level, and the paths, plus any undocumented extra ones, at info.
+func (s *Server) logRoutes(listener, addr string, d *documentedMux, extra []string) {
+	spec := d.Spec()
+	paths := slices.Sorted(maps.Keys(spec.Paths))
+	for _, path := range paths {
+		for _, method := range slices.Sorted(maps.Keys(spec.Paths[path])) {
+			s.logger.Debug("route", slog.String("listener", listener), slog.String("method", strings.ToUpper(method)), slog.String("path", path), slog.String("summary", spec.Paths[path][method].Summary))
+		}
+	}
+	s.logger.Info("endpoints", slog.String("listener", listener), slog.String("addr", addr), slog.Any("paths", append(paths, extra...)))
+}
+
+// middleware is the canonical order of the server-wide middleware, outermost
+// first:
+//
+//   - panic recovery outermost, as a backstop: a panic in any middleware
+//     still gets a 500 rather than a dropped connection;
+//   - request ID next, so the trace, logs, and every response carry it;
+//   - the client IP next, so logs and the rate limiter see the same one;
+//   - Server-Timing next, so its total covers everything after;
+//   - tracing before logging, so log lines get the trace and span IDs;
+//   - logging next, so it sees the final status of everything inside;
+//   - security headers inside logging, so even early rejections carry them;
+//   - CORS before gzip, so preflight answers skip compression;
+//   - the recorder inside gzip, so it keeps bodies uncompressed, and
+//     outside recovery, so it keeps the 500 a panic becomes; it takes the
+//     router's timeouts to skip the long-lived routes;
+//   - panic recovery again inside logging, so a panic in a handler is
+//     logged and counted as a 500 with its request ID, but outside
+//     everything that runs handler code;
+//   - the slow-request dump trigger inside recovery, timing the handlers
+//     and not the log line; it takes the router's timeouts to skip the
+//     long-lived routes;
+//   - maintenance mode and rate limiting before any request body or handler
+//     work;
+//   - request decompression outside the body limit, so the limit counts
+//     decompressed bytes;
+//   - body limits, content negotiation, feature-flag overrides, and fault
+//     injection just outside the router.
+//
+// Per-route middleware such as authentication is attached by route groups.
+func (s *Server) middleware(timeouts map[string]time.Duration) []Middleware {
+	stack := []Middleware{
+		recoverPanics,
+		withRequestID,
+		func(h http.Handler) http.Handler { return withRealIP(h, s.cfg.TrustedProxies) },
+		withServerTiming,
+		withTracing,
+		func(h http.Handler) http.Handler { return logRequests(h, s.accessLogger, newLogSampling(s.cfg)) },
+		func(h http.Handler) http.Handler { return withSecurityHeaders(h, s.cfg.SecurityHeaders) },
+		func(h http.Handler) http.Handler { return withCORS(h, s.cors) },
+		func(h http.Handler) http.Handler { return withGzip(h, s.cfg.GzipMinBytes) },
+		func(h http.Handler) http.Handler { return s.recorder.record(h, timeouts) },
+		recoverPanics,
+		func(h http.Handler) http.Handler {
+			return s.dumps.watchSlowRequests(h, s.cfg.SlowDumpThreshold, timeouts)
+		},
+	}
+	return append(stack,
+		func(h http.Handler) http.Handler { return withMaintenance(h, s.maintenance) },
+		func(h http.Handler) http.Handler { return withRateLimit(h, s.limiter) },
+		withRequestDecompression,
+		func(h http.Handler) http.Handler { return limitBody(h, s.cfg.MaxBodyBytes, s.bodyLimits()) },
+		func(h http.Handler) http.Handler { return withNegotiation(h, s.cfg.StrictAccept) },
+		func(h http.Handler) http.Handler { return withFlagOverrides(h, s.flags) },
+		func(h http.Handler) http.Handler { return withFaults(h, s.faults) },
+	)
+}
+
+// bodyLimits lists the paths whose request bodies may exceed MAX_BODY_BYTES.
+func (s *Server) bodyLimits() map[string]int64 {
+	limits := map[string]int64{
+		"/api/v1/items/import": s.cfg.ImportMaxBytes,
+		"/api/items/import":    s.cfg.ImportMaxBytes,
+	}
+	if s.cfg.UploadDir != "" {
+		limits["/upload"] = s.cfg.UploadMaxTotalBytes
+	}
+	return limits
+}
+
+type HealthResponse struct {
+	Status    string            `json:"status"`
+	Timestamp time.Time         `json:"timestamp"`
+	Version   string            `json:"version"`
+	GitCommit string            `json:"git_commit"`
+	BuildDate string            `json:"build_date"`
+	Uptime    string            `json:"uptime"`
+	Hostname  string            `json:"hostname"`
+	Pod       string            `json:"pod,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	Checks    map[string]string `json:"checks"`
+	Circuits  map[string]string `json:"circuits,omitempty"`
+}
+
+type MessageResponse struct {
+	Message    string    `json:"message"`
+	Timestamp  time.Time `json:"timestamp"`
+	APIVersion string    `json:"api_version,omitempty"`
+	// Lang is the language of Message on /, and Name who it greets.
+	Lang string `json:"lang,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Visits and SessionID are set on / with sessions enabled.
+	Visits    int64  `json:"visits,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// notFoundHandler answers any path without a registered route, including
+// unknown subpaths under /api.
+func (s *Server) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, codeNotFound, "Not found", "")
+}
+
+func (s *Server) helloHandler(w http.ResponseWriter, r *http.Request) {
+	// The greeting depends on Accept-Language even when ?lang= overrides it.
+	w.Header().Add("Vary", "Accept-Language")
+	lang, ok := requestLang(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Unsupported lang",
+			"supported languages are "+strings.Join(supportedLangs(), ", "))
+		return
+	}
+	name, ok := helloName(w, r)
+	if !ok {
+		return
+	}
+	message := greetings[lang].Hello
+	switch {
+	case name != "":
+		message = fmt.Sprintf(greetings[lang].Named, name)
+	case s.flags.Enabled(r.Context(), "newGreeting"):
+		message = greetings[lang].NewHello
+	}
+	w.Header().Set("Content-Language", lang)
+	response := MessageResponse{
+		Message:   message,
+		Timestamp: s.clock.Now(),
+		Lang:      lang,
+		Name:      name,
+	}
+	s.helloSession(w, r, &response)
+	writeResponse(w, r, http.StatusOK, response)
+}
+
+// maxHelloName is the longest name / greets, in characters.
+const maxHelloName = 100
+
+// HelloRequest is the optional body of POST /.
+type HelloRequest struct {
+	Name string `json:"name"`
+}
+
+// helloName returns the name to greet, from ?name= or a POST body, trimmed.
+// Names that are too long or contain control characters get a 400; the
+// encoders escape everything else. It writes the error response itself and
+// returns false on failure.
+func helloName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, code := r.URL.Query().Get("name"), codeInvalidParameter
+	if r.Method == http.MethodPost {
+		var req HelloRequest
+		if err := decodeJSON(r, &req); err != nil {
+			writeDecodeError(w, r, err)
+			return "", false
+		}
+		name, code = req.Name, codeInvalidBody
+	}
+	name = strings.TrimSpace(name)
+	var problem *FieldError
+	switch {
+	case utf8.RuneCountInString(name) > maxHelloName:
+		problem = &FieldError{Field: "name", Constraint: "max_length", Message: fmt.Sprintf("must be at most %d characters", maxHelloName)}
+	case !utf8.ValidString(name) || strings.ContainsFunc(name, unicode.IsControl):
+		problem = &FieldError{Field: "name", Constraint: "pattern", Message: "must be printable text", Value: name}
+	}
+	if problem != nil {
+		writeError(w, r, http.StatusBadRequest, code, "Invalid name", problem.Message, *problem)
+		return "", false
+	}
+	return name, true
+}
+
+func (s *Server) apiHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		response := MessageResponse{
+			Message:    "API endpoint is working",
+			Timestamp:  s.clock.Now(),
+			APIVersion: apiVersionFrom(r.Context()),
+		}
+		writeResponse(w, r, http.StatusOK, response)
+	case "POST":
+		var data map[string]interface{}
+		if err := decodeJSON(r, &data); err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		response := map[string]interface{}{
+			"received":    data,
+			"timestamp":   s.clock.Now(),
+			"api_version": apiVersionFrom(r.Context()),
+		}
+		writeResponse(w, r, http.StatusOK, response)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEpoch is where the test servers' clocks start.
+var testEpoch = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// testClock is a Clock that only moves when told to. Background
+// components read it too, hence the lock.
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// newTestServer builds a Server on an in-memory store from the default
+// configuration, changed by each of opts, and returns it with its public
+// handler. The server and its store run on a testClock from testEpoch.
+// Its background components are shut down when the test ends.
+func newTestServer(t testing.TB, opts ...func(*Config)) (*Server, http.Handler) {
+	t.Helper()
+	return newLoggedTestServer(t, slog.New(slog.NewTextHandler(io.Discard, nil)), opts...)
+}
+
+// newLoggedTestServer is newTestServer logging to logger, for tests that
+// inspect the logs.
+func newLoggedTestServer(t testing.TB, logger *slog.Logger, opts ...func(*Config)) (*Server, http.Handler) {
+	t.Helper()
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	clock := &testClock{t: testEpoch}
+	store := newMemoryStore()
+	store.now = clock.Now
+	return startClockedTestServer(t, cfg, logger, store, clock)
+}
+
+// startTestServer builds a Server on store and returns it with its public
+// handler, shutting its background components down when the test ends.
+func startTestServer(t testing.TB, cfg Config, logger *slog.Logger, store Store) (*Server, http.Handler) {
+	t.Helper()
+	return startClockedTestServer(t, cfg, logger, store, nil)
+}
+
+// startClockedTestServer is startTestServer on clock; nil means the
+// system clock.
+func startClockedTestServer(t testing.TB, cfg Config, logger *slog.Logger, store Store, clock Clock) (*Server, http.Handler) {
+	t.Helper()
+	srv, err := NewServer(cfg, logger, store, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _ := srv.Routes()
+	srv.ready.Store(true)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.jobs.Shutdown(ctx)
+		srv.webhooks.Shutdown(ctx)
+		srv.shadow.Shutdown(ctx)
+		srv.audit.Shutdown(ctx)
+	})
+	return srv, public
+}
+
+// newRequest builds a request for handler tests, with a JSON body when
+// body is set.
+func newRequest(t testing.TB, method, target, body string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+// serve runs r through handler and returns the recorded response.
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestUnknownPathsReturnJSON404(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, path := range []string{"/foo", "/doesnotexist", "/api/nope", "/api/v1/items/x/y"} {
+		rec := serve(h, newRequest(t, "GET", path, ""))
+		if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("GET %s = %d %s, want a JSON 404", path, rec.Code, rec.Header().Get("Content-Type"))
+			continue
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != codeNotFound || body.Path != path {
+			t.Errorf("GET %s body = %s, want not_found with the path", path, rec.Body)
+		}
+	}
+	if rec := serve(h, newRequest(t, "GET", "/", "")); rec.Code != http.StatusOK {
+		t.Errorf("GET / = %d, want 200", rec.Code)
+	}
+}
+
+func TestHello(t *testing.T) {
+	_, h := newTestServer(t)
+	const greeting = "Hello from Go + Kubernetes + Skaffold with Air hot reload!"
+	long := strings.Repeat("x", maxHelloName+1)
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		wantStatus   int
+		wantMessage  string
+		wantName     string
+		wantCode     string
+		wantProblems string
+	}{
+		{"default", "GET", "/", "", http.StatusOK, greeting, "", "", ""},
+		{"empty name", "GET", "/?name=", "", http.StatusOK, greeting, "", "", ""},
+		{"query name", "GET", "/?name=Ada", "", http.StatusOK, "Hello, Ada, from Go + Kubernetes + Skaffold with Air hot reload!", "Ada", "", ""},
+		{"query name trimmed", "GET", "/?name=%20Ada%20", "", http.StatusOK, "Hello, Ada, from Go + Kubernetes + Skaffold with Air hot reload!", "Ada", "", ""},
+		{"longest name", "GET", "/?name=" + strings.Repeat("é", maxHelloName), "", http.StatusOK, "", strings.Repeat("é", maxHelloName), "", ""},
+		{"post name", "POST", "/", `{"name": "Grace"}`, http.StatusOK, "Hello, Grace, from Go + Kubernetes + Skaffold with Air hot reload!", "Grace", "", ""},
+		{"post body wins over query", "POST", "/?name=Ada", `{"name": "Grace"}`, http.StatusOK, "", "Grace", "", ""},
+		{"post without name", "POST", "/", `{}`, http.StatusOK, greeting, "", "", ""},
+		{"query name too long", "GET", "/?name=" + long, "", http.StatusBadRequest, "", "", codeInvalidParameter, "name:max_length"},
+		{"post name too long", "POST", "/", `{"name": "` + long + `"}`, http.StatusBadRequest, "", "", codeInvalidBody, "name:max_length"},
+		{"control characters", "GET", "/?name=a%07b", "", http.StatusBadRequest, "", "", codeInvalidParameter, "name:pattern"},
+		{"post invalid JSON", "POST", "/", `{"name": `, http.StatusBadRequest, "", "", codeInvalidJSON, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, newRequest(t, tt.method, tt.target, tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("%s %s = %d %s, want %d", tt.method, tt.target, rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode {
+					t.Fatalf("body = %s, want code %s", rec.Body, tt.wantCode)
+				}
+				var problems []string
+				for _, d := range body.Details {
+					problems = append(problems, d.Field+":"+d.Constraint)
+				}
+				if got := strings.Join(problems, ","); got != tt.wantProblems {
+					t.Errorf("details = %q, want %q", got, tt.wantProblems)
+				}
+				return
+			}
+			var body MessageResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantMessage != "" && body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+			if body.Name != tt.wantName || body.Lang != "en" || !body.Timestamp.Equal(testEpoch) {
+				t.Errorf("body = %+v, want name %q, lang en, and timestamp %s", body, tt.wantName, testEpoch)
+			}
+		})
+	}
+}
+
+func TestResponsesUseTheServerClock(t *testing.T) {
+	srv, h := newTestServer(t)
+	clock := srv.clock.(*testClock)
+
+	item := createItem(t, h, `{"name": "clocked"}`)
+	if !item.CreatedAt.Equal(testEpoch) || !item.UpdatedAt.Equal(testEpoch) {
+		t.Errorf("created item at %s, updated %s, want both %s", item.CreatedAt, item.UpdatedAt, testEpoch)
+	}
+	clock.Advance(90 * time.Second)
+	rec := serve(h, newRequest(t, "PUT", "/api/v1/items/"+item.ID, `{"name": "reclocked"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", rec.Code, rec.Body)
+	}
+	want := testEpoch.Add(90 * time.Second)
+	if updated := decodeItem(t, rec); !updated.CreatedAt.Equal(testEpoch) || !updated.UpdatedAt.Equal(want) {
+		t.Errorf("updated item created %s, updated %s, want %s and %s", updated.CreatedAt, updated.UpdatedAt, testEpoch, want)
+	}
+
+	var health HealthResponse
+	if err := json.Unmarshal(serve(h, newRequest(t, "GET", "/healthz", "")).Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Uptime != "1m30s" || !health.Timestamp.Equal(want) {
+		t.Errorf("GET /healthz uptime %q at %s, want 1m30s at %s", health.Uptime, health.Timestamp, want)
+	}
+	var now TimeResponse
+	if err := json.Unmarshal(serve(h, newRequest(t, "GET", "/time", "")).Body.Bytes(), &now); err != nil {
+		t.Fatal(err)
+	}
+	if now.Uptime != "1m30s" || now.UptimeSecs != 90 || now.Unix != want.Unix() || now.RFC3339 != "2024-03-01T12:01:30Z" {
+		t.Errorf("GET /time = %+v, want uptime 1m30s (90 s) at %s", now, want)
+	}
+	var hello MessageResponse
+	if err := json.Unmarshal(serve(h, newRequest(t, "GET", "/", "")).Body.Bytes(), &hello); err != nil {
+		t.Fatal(err)
+	}
+	if !hello.Timestamp.Equal(want) {
+		t.Errorf("GET / timestamp = %s, want %s", hello.Timestamp, want)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionCookie names the cookie holding the signed session ID.
+const sessionCookie = "session"
+
+// Session is a visitor as seen by GET /session.
+type Session struct {
+	ID        string    `json:"session_id"`
+	Visits    int64     `json:"visits"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sessionStore keeps sessions for ttl after their last visit.
+type sessionStore interface {
+	// Visit counts a visit to session id, creating it if needed.
+	Visit(ctx context.Context, id string, now time.Time) (Session, error)
+	// Get returns session id, or false if it doesn't exist or expired.
+	Get(ctx context.Context, id string) (Session, bool, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// sessionManager issues and verifies session cookies. The cookie is the
+// session ID and its HMAC-SHA256 under the secret; only the ID is stored,
+// so a leaked store doesn't yield valid cookies.
+type sessionManager struct {
+	secret []byte
+	ttl    time.Duration
+	secure bool
+	store  sessionStore
+}
+
+// sign returns the cookie value for id.
+func (m *sessionManager) sign(id string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionID returns the ID in r's session cookie. A cookie that is missing
+// or fails verification gives ok false; tampered reports the latter.
+func (m *sessionManager) sessionID(r *http.Request) (id string, ok, tampered bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false, false
+	}
+	id, _, found := strings.Cut(c.Value, ".")
+	if !found || id == "" || !hmac.Equal([]byte(c.Value), []byte(m.sign(id))) {
+		return "", false, true
+	}
+	return id, true, false
+}
+
+func (m *sessionManager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// visit counts a visit for r's session, starting a new one, and setting its
+// cookie, when r has no valid session. The cookie's lifetime is refreshed
+// on every visit since the session's is.
+func (m *sessionManager) visit(w http.ResponseWriter, r *http.Request, now time.Time) (Session, error) {
+	id, ok, tampered := m.sessionID(r)
+	if tampered {
+		loggerFrom(r.Context()).Info("invalid session cookie, issuing a new one")
+	}
+	if !ok {
+		id = newUUID()
+	}
+	sess, err := m.store.Visit(r.Context(), id, now)
+	if err != nil {
+		return Session{}, err
+	}
+	http.SetCookie(w, m.cookie(m.sign(id), int(m.ttl.Seconds())))
+	// The response now depends on who asked.
+	w.Header().Add("Vary", "Cookie")
+	w.Header().Set("Cache-Control", "private, no-store")
+	return sess, nil
+}
+
+// sessionHandler serves GET and DELETE /session.
+func (s *Server) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	m := s.sessions
+	w.Header().Set("Cache-Control", "private, no-store")
+	id, ok, _ := m.sessionID(r)
+	var sess Session
+	if ok {
+		var err error
+		if sess, ok, err = m.store.Get(r.Context(), id); err != nil {
+			writeSessionError(w, r, err)
+			return
+		}
+	}
+	switch r.Method {
+	case http.MethodDelete:
+		if ok {
+			if err := m.store.Delete(r.Context(), id); err != nil {
+				writeSessionError(w, r, err)
+				return
+			}
+		}
+		http.SetCookie(w, m.cookie("", -1))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if !ok {
+			writeError(w, r, http.StatusNotFound, codeNotFound, "No session", "visit / to start one")
+			return
+		}
+		writeResponse(w, r, http.StatusOK, sess)
+	}
+}
+
+func writeSessionError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		return
+	}
+	loggerFrom(r.Context()).Error("session store failed", slog.Any("error", err))
+	writeRetryableError(w, r, http.StatusServiceUnavailable, retryAfterDefault, codeUnavailable, "Session store unavailable", "")
+}
+
+// memorySessions keeps sessions in process.
+type memorySessions struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func newMemorySessions(ttl time.Duration, now func() time.Time) *memorySessions {
+	return &memorySessions{ttl: ttl, now: now, sessions: make(map[string]Session)}
+}
+
+func (st *memorySessions) Visit(ctx context.Context, id string, now time.Time) (Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[id]
+	if !ok || !now.Before(sess.ExpiresAt) {
+		sess = Session{ID: id, FirstSeen: now.UTC()}
+	}
+	sess.Visits++
+	sess.LastSeen = now.UTC()
+	sess.ExpiresAt = sess.LastSeen.Add(st.ttl)
+	st.sessions[id] = sess
+	return sess, nil
+}
+
+func (st *memorySessions) Get(ctx context.Context, id string) (Session, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[id]
+	if !ok || !st.now().Before(sess.ExpiresAt) {
+		return Session{}, false, nil
+	}
+	return sess, true, nil
+}
+
+func (st *memorySessions) Delete(ctx context.Context, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, id)
+	return nil
+}
+
+// prune drops expired sessions.
+func (st *memorySessions) prune() int {
+	now := st.now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	pruned := 0
+	for id, sess := range st.sessions {
+		if !now.Before(sess.ExpiresAt) {
+			delete(st.sessions, id)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// redisSessions keeps each session in a hash that expires ttl after the
+// last visit.
+type redisSessions struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func (st *redisSessions) key(id string) string { return st.prefix + "session:" + id }
+
+func (st *redisSessions) Visit(ctx context.Context, id string, now time.Time) (Session, error) {
+	key := st.key(id)
+	ms := strconv.FormatInt(now.UnixMilli(), 10)
+	var visits *redis.IntCmd
+	var fields *redis.SliceCmd
+	_, err := st.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, key, "first_seen", ms)
+		visits = pipe.HIncrBy(ctx, key, "visits", 1)
+		pipe.HSet(ctx, key, "last_seen", ms)
+		pipe.PExpire(ctx, key, st.ttl)
+		fields = pipe.HMGet(ctx, key, "first_seen")
+		return nil
+	})
+	if err != nil {
+		return Session{}, fmt.Errorf("record visit: %w", err)
+	}
+	firstSeen, _ := fields.Val()[0].(string)
+	return Session{
+		ID:        id,
+		Visits:    visits.Val(),
+		FirstSeen: parseMillis(firstSeen),
+		LastSeen:  now.UTC(),
+		ExpiresAt: now.UTC().Add(st.ttl),
+	}, nil
+}
+
+func (st *redisSessions) Get(ctx context.Context, id string) (Session, bool, error) {
+	key := st.key(id)
+	var fields *redis.MapStringStringCmd
+	var ttl *redis.DurationCmd
+	_, err := st.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HGetAll(ctx, key)
+		ttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return Session{}, false, fmt.Errorf("get session: %w", err)
+	}
+	if len(fields.Val()) == 0 {
+		return Session{}, false, nil
+	}
+	visits, _ := strconv.ParseInt(fields.Val()["visits"], 10, 64)
+	return Session{
+		ID:        id,
+		Visits:    visits,
+		FirstSeen: parseMillis(fields.Val()["first_seen"]),
+		LastSeen:  parseMillis(fields.Val()["last_seen"]),
+		ExpiresAt: time.Now().UTC().Add(ttl.Val()).Truncate(time.Millisecond),
+	}, true, nil
+}
+
+func (st *redisSessions) Delete(ctx context.Context, id string) error {
+	if err := st.client.Del(ctx, st.key(id)).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
+
+func parseMillis(s string) time.Time {
+	ms, _ := strconv.ParseInt(s, 10, 64)
+	return time.UnixMilli(ms).UTC()
+}
+
+// helloSession adds the visitor's session to the / response. Sessions are
+// a nicety there, so a store failure is logged rather than failing the
+// greeting.
+func (s *Server) helloSession(w http.ResponseWriter, r *http.Request, resp *MessageResponse) {
+	if s.sessions == nil {
+		return
+	}
+	sess, err := s.sessions.visit(w, r, s.clock.Now())
+	if err != nil {
+		loggerFrom(r.Context()).Warn("cannot record session visit", slog.Any("error", err))
+		return
+	}
+	resp.Visits, resp.SessionID = sess.Visits, sess.ID
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shadowHeader marks mirrored requests, so the shadow can tell them from
+// real traffic.
+const shadowHeader = "X-Shadow-Request"
+
+// shadowMaxBodyBytes is the largest request body mirrored. Copying a body
+// means holding it until the copy is sent, so larger ones, which are
+// rarely what a canary comparison needs, go to the primary alone.
+const shadowMaxBodyBytes = 64 << 10
+
+// shadowStrippedHeaders are the credentials never sent to the shadow.
+var shadowStrippedHeaders = []string{
+	"Authorization", "Proxy-Authorization", "X-API-Key", "Cookie",
+	signatureHeader, signatureTimestampHeader,
+}
+
+// shadowJob is one mirrored request, and what the primary answered.
+type shadowJob struct {
+	method    string
+	path      string
+	target    string
+	header    http.Header
+	body      []byte
+	requestID string
+	primary   int
+}
+
+// shadowMirror copies a share of /api requests to SHADOW_URL, for canary
+// testing: same method, path, query, and headers, less credentials, and the
+// same body. Copies are sent from workers once the primary response is
+// written, so the client never waits for them, and only their status and
+// latency are kept. A full queue drops the copy rather than the pool
+// growing behind a slow shadow.
+type shadowMirror struct {
+	base    *url.URL
+	client  *http.Client
+	percent float64
+	// force lets ?shadow=force mirror a request whatever percent says.
+	force  bool
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan shadowJob
+	// ctx is cancelled on shutdown, abandoning copies in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newShadowMirror returns nil without a target.
+func newShadowMirror(target string, hc httpClientConfig, timeout time.Duration, percent float64, workers, queueSize int, force bool, logger *slog.Logger) *shadowMirror {
+	if target == "" {
+		return nil
+	}
+	base, _ := url.Parse(strings.TrimSuffix(target, "/"))
+	// A copy is sent once and its answer reported as is; retrying or
+	// following redirects would measure something else.
+	hc.MaxRetries = 0
+	client := newHTTPClient(hc, timeout)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &shadowMirror{
+		base:    base,
+		client:  client,
+		percent: percent,
+		force:   force,
+		logger:  logger,
+		queue:   make(chan shadowJob, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for range workers {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			for job := range m.queue {
+				m.send(job)
+			}
+		}()
+	}
+	return m
+}
+
+// selected reports whether r is mirrored. Streams and upgrades never are:
+// imports and exports, NDJSON listings, and event streams. Nor are batch
+// sub-requests, whose batch already was, or bodies known to be over
+// shadowMaxBodyBytes.
+func (m *shadowMirror) selected(r *http.Request) bool {
+	if inBatch(r.Context()) || r.Header.Get("Upgrade") != "" || r.ContentLength > shadowMaxBodyBytes ||
+		strings.HasSuffix(r.URL.Path, "/items/import") || strings.HasSuffix(r.URL.Path, "/items/export") ||
+		r.URL.Query().Get("format") == "ndjson" ||
+		acceptsMediaType(r.Header.Get("Accept"), contentTypeNDJSON) ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return false
+	}
+	if m.force && r.URL.Query().Get("shadow") == "force" {
+		return true
+	}
+	return m.percent > 0 && rand.Float64()*100 < m.percent
+}
+
+// Middleware mirrors the requests selected. A nil mirror lets everything
+// through untouched.
+func (m *shadowMirror) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.selected(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			var err error
+			if body, err = io.ReadAll(io.LimitReader(r.Body, shadowMaxBodyBytes+1)); err != nil {
+				writeBodyReadError(w, r, err, "Cannot read request body")
+				return
+			}
+			if len(body) > shadowMaxBodyBytes {
+				// A chunked body turned out too large: the primary gets what
+				// was read and the rest, unmirrored.
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		job := m.job(r, body)
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		if r.Context().Err() != nil {
+			// The primary never finished; there is nothing to compare.
+			return
+		}
+		job.primary = rec.status
+		m.enqueue(job)
+	})
+}
+
+// job copies what the shadow needs from r before the handler runs.
+func (m *shadowMirror) job(r *http.Request, body []byte) shadowJob {
+	query := r.URL.Query()
+	query.Del("shadow")
+	target := *m.base
+	target.Path += r.URL.Path
+	target.RawQuery = query.Encode()
+	header := r.Header.Clone()
+	for _, name := range shadowStrippedHeaders {
+		header.Del(name)
+	}
+	header.Set(shadowHeader, "true")
+	if header.Get(requestIDHeader) == "" {
+		// The same ID ties the shadow's logs to the primary's.
+		header.Set(requestIDHeader, requestIDFrom(r.Context()))
+	}
+	return shadowJob{
+		method:    r.Method,
+		path:      r.URL.Path,
+		target:    target.String(),
+		header:    header,
+		body:      body,
+		requestID: requestIDFrom(r.Context()),
+	}
+}
+
+func (m *shadowMirror) enqueue(job shadowJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.queue <- job:
+	default:
+		shadowRequestsDropped.Inc()
+		m.logger.Debug("shadow queue full, dropping copy", slog.String("request_id", job.requestID), slog.String("path", job.path))
+	}
+}
+
+// send mirrors job, records the result, and logs a status that differs
+// from the primary's.
+func (m *shadowMirror) send(job shadowJob) {
+	req, err := http.NewRequestWithContext(m.ctx, job.method, job.target, bytes.NewReader(job.body))
+	if err != nil {
+		m.logger.Warn("cannot build shadow request", slog.String("request_id", job.requestID), slog.Any("error", err))
+		return
+	}
+	req.Header = job.header
+	start := time.Now()
+	resp, err := m.client.Do(req)
+	elapsed := time.Since(start)
+	if m.ctx.Err() != nil {
+		return
+	}
+	status, shadowStatus := "error", 0
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		shadowStatus = resp.StatusCode
+		status = strconv.Itoa(shadowStatus)
+	}
+	shadowRequests.WithLabelValues(status).Inc()
+	shadowRequestDuration.Observe(elapsed.Seconds())
+	if shadowStatus == job.primary {
+		return
+	}
+	shadowDivergences.Inc()
+	attrs := []any{
+		slog.String("request_id", job.requestID),
+		slog.String("method", job.method),
+		slog.String("path", job.path),
+		slog.Int("primary_status", job.primary),
+		slog.Float64("shadow_duration_ms", durationMS(elapsed)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	} else {
+		attrs = append(attrs, slog.Int("shadow_status", shadowStatus))
+	}
+	m.logger.Warn("shadow response diverged", attrs...)
+}
+
+// Shutdown stops mirroring and abandons the copies still queued or in
+// flight: nothing waits on them, so they mustn't hold up the drain.
+func (m *shadowMirror) Shutdown(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mu.Unlock()
+	m.cancel()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
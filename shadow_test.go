@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mirroredRequest struct {
+	method, path, authorization, shadow string
+	body                                string
+}
+
+func TestShadowMirrorsSelectedRequests(t *testing.T) {
+	mirrored := make(chan mirroredRequest, 16)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- mirroredRequest{r.Method, r.URL.Path, r.Header.Get("Authorization"), r.Header.Get(shadowHeader), string(body)}
+	}))
+	defer shadow.Close()
+	_, handler := newTestServer(t, func(cfg *Config) {
+		cfg.ShadowURL = shadow.URL
+		cfg.ShadowPercent = 100
+		// One worker sends copies in order, so the last request mirrored
+		// shows that none of those before it were.
+		cfg.ShadowWorkers = 1
+		cfg.ImportMaxBytes = 1 << 20
+	})
+	next := func() mirroredRequest {
+		t.Helper()
+		select {
+		case m := <-mirrored:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("no request mirrored")
+			return mirroredRequest{}
+		}
+	}
+
+	r := newRequest(t, "POST", "/api/v1/items", `{"name": "copied"}`)
+	r.Header.Set("Authorization", "Bearer secret")
+	if rec := serve(handler, r); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/v1/items = %d, want 201", rec.Code)
+	}
+	m := next()
+	if m.method != "POST" || m.path != "/api/v1/items" || m.body != `{"name": "copied"}` || m.shadow != "true" {
+		t.Errorf("mirrored %+v, want the POST with its body and %s", m, shadowHeader)
+	}
+	if m.authorization != "" {
+		t.Errorf("mirrored Authorization %q, want it stripped", m.authorization)
+	}
+
+	large := `{"name": "large", "data": {"blob": "` + strings.Repeat("x", shadowMaxBodyBytes) + `"}}`
+	chunked := newRequest(t, "POST", "/api/v1/items", large)
+	chunked.ContentLength = -1
+	skipped := []*http.Request{
+		newRequest(t, "POST", "/api/v1/items/import", `[{"name": "imported"}]`),
+		newRequest(t, "GET", "/api/v1/items/export", ""),
+		newRequest(t, "GET", "/api/v1/items?format=ndjson", ""),
+		newRequest(t, "POST", "/api/v1/items", large),
+		chunked,
+	}
+	ndjson := newRequest(t, "GET", "/api/v1/items", "")
+	ndjson.Header.Set("Accept", contentTypeNDJSON)
+	skipped = append(skipped, ndjson)
+	for _, r := range skipped {
+		if rec := serve(handler, r); rec.Code >= 400 {
+			t.Errorf("%s %s = %d %s, want it served", r.Method, r.URL, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(handler, newRequest(t, "GET", "/api/v1/items", "")); rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/v1/items = %d, want 200", rec.Code)
+	}
+	if m := next(); m.method != "GET" || m.path != "/api/v1/items" {
+		t.Errorf("mirrored %s %s, want only the plain GET /api/v1/items after the first POST", m.method, m.path)
+	}
+}
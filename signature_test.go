@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "s3cret"
+
+func sign(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	if timestamp != "" {
+		h.Write([]byte(timestamp + "."))
+	}
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSignatureVerification(t *testing.T) {
+	srv, handler := newTestServer(t, func(cfg *Config) {
+		cfg.SigningSecrets = []string{"new-secret", testSigningSecret}
+		cfg.SigningMaxSkew = time.Minute
+	})
+	body := []byte(`{"name": "signed"}`)
+	now := strconv.FormatInt(srv.clock.Now().Unix(), 10)
+	stale := strconv.FormatInt(srv.clock.Now().Add(-time.Hour).Unix(), 10)
+	post := func(signature, timestamp string, wire []byte, encoding string) int {
+		r := newRequest(t, "POST", "/api/v1/items", string(wire))
+		if signature != "" {
+			r.Header.Set(signatureHeader, signature)
+		}
+		if timestamp != "" {
+			r.Header.Set(signatureTimestampHeader, timestamp)
+		}
+		if encoding != "" {
+			r.Header.Set("Content-Encoding", encoding)
+		}
+		return serve(handler, r).Code
+	}
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		wire      []byte
+		encoding  string
+		want      int
+	}{
+		{"unsigned", "", now, body, "", http.StatusUnauthorized},
+		{"valid", sign(testSigningSecret, now, body), now, body, "", http.StatusCreated},
+		{"first secret", sign("new-secret", now, body), now, body, "", http.StatusCreated},
+		{"unknown secret", sign("other", now, body), now, body, "", http.StatusUnauthorized},
+		{"tampered body", sign(testSigningSecret, now, body), now, []byte(`{"name": "tampered"}`), "", http.StatusUnauthorized},
+		{"no timestamp", sign(testSigningSecret, "", body), "", body, "", http.StatusUnauthorized},
+		{"stale timestamp", sign(testSigningSecret, stale, body), stale, body, "", http.StatusUnauthorized},
+		{"not hex", "sha256=zz", now, body, "", http.StatusUnauthorized},
+		// The signature covers the body as decoded, not the bytes on the wire.
+		{"gzip, signed decoded", sign(testSigningSecret, now, body), now, gzipped(t, body), "gzip", http.StatusCreated},
+		{"gzip, signed compressed", sign(testSigningSecret, now, gzipped(t, body)), now, gzipped(t, body), "gzip", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := post(tt.signature, tt.timestamp, tt.wire, tt.encoding); got != tt.want {
+			t.Errorf("%s: POST /api/v1/items = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSignatureBuffersAtMostMaxBodyBytes(t *testing.T) {
+	_, handler := newTestServer(t, func(cfg *Config) {
+		cfg.SigningSecrets = []string{testSigningSecret}
+		cfg.SigningMaxSkew = 0
+		cfg.MaxBodyBytes = 256
+		cfg.ImportMaxBytes = 1 << 20
+	})
+	send := func(body []byte) int {
+		r := newRequest(t, "POST", "/api/v1/items/import", string(body))
+		r.Header.Set(signatureHeader, sign(testSigningSecret, "", body))
+		return serve(handler, r).Code
+	}
+	small := []byte(`[{"name": "a"}]`)
+	if code := send(small); code != http.StatusOK && code != http.StatusCreated {
+		t.Errorf("signed import under MAX_BODY_BYTES = %d, want success", code)
+	}
+	large := []byte("[" + strings.Repeat(`{"name": "a"},`, 100) + `{"name": "a"}]`)
+	if code := send(large); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("signed import over MAX_BODY_BYTES = %d, want 413", code)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver
+)
+
+// sqlMigrations are applied in order at startup. Append new statements;
+// never edit ones that may already have run.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS items (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		data       JSONB,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS items_name_idx ON items (name COLLATE "C")`,
+	`CREATE INDEX IF NOT EXISTS items_created_at_idx ON items (created_at, id)`,
+	`ALTER TABLE items ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1`,
+	`ALTER TABLE items ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS items_deleted_at_idx ON items (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`ALTER TABLE items ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS items_tenant_created_at_idx ON items (tenant, created_at, id)`,
+}
+
+// migrationLockID serializes migrations across replicas starting at once.
+const migrationLockID = 7_201_844
+
+const itemColumns = `id, name, data, created_at, updated_at, version, deleted_at, tenant`
+
+// notDeleted restricts a query to items that aren't soft-deleted.
+const notDeleted = ` AND deleted_at IS NULL`
+
+// sqlStore is a Store backed by Postgres. Every query takes the request
+// context so cancelled requests abort their database work.
+type sqlStore struct {
+	db *sql.DB
+}
+
+// openSQLStore connects to databaseURL and applies pending migrations.
+func openSQLStore(ctx context.Context, databaseURL string) (*sqlStore, error) {
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+	s := &sqlStore{db: db}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies any sqlMigrations newer than the recorded schema version.
+func (s *sqlStore) migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("migrate: acquire lock: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("migrate: create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("migrate: read version: %w", err)
+	}
+	for i := current; i < len(sqlMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, sqlMigrations[i]); err != nil {
+			return fmt.Errorf("migrate: apply migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return fmt.Errorf("migrate: record migration %d: %w", i+1, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Name identifies the store in readiness checks.
+func (s *sqlStore) Name() string {
+	return "database"
+}
+
+// Check pings the database for /readyz.
+func (s *sqlStore) Check(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// ProbeWrite runs an UPDATE that matches no rows, which a database that
+// rejects writes, such as a read-only replica after a failover, still
+// refuses.
+func (s *sqlStore) ProbeWrite(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE items SET name = name WHERE false`); err != nil {
+		return fmt.Errorf("probe write: %w", err)
+	}
+	return nil
+}
+
+// Close releases the connection pool.
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// Create inserts item with a new ID and timestamps.
+func (s *sqlStore) Create(ctx context.Context, item Item) (Item, error) {
+	// Postgres stores microseconds; truncate so the returned item matches what
+	// later reads will see.
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	item.ID = newUUID()
+	item.Tenant = tenantFrom(ctx)
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	item.Version = 1
+
+	data, err := encodeItemData(item.Data)
+	if err != nil {
+		return Item{}, err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO items (id, name, data, created_at, updated_at, version, tenant) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		item.ID, item.Name, data, item.CreatedAt, item.UpdatedAt, item.Version, item.Tenant)
+	if err != nil {
+		return Item{}, fmt.Errorf("insert item: %w", err)
+	}
+	return item, nil
+}
+
+// Get returns the item with the given ID.
+func (s *sqlStore) Get(ctx context.Context, id string) (Item, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+itemColumns+` FROM items WHERE id = $1 AND tenant = $2`+notDeleted, id, tenantFrom(ctx))
+	return scanItem(row)
+}
+
+// List mirrors memoryStore.List: names compare bytewise (COLLATE "C"), ties
+// break on created_at then id, and an unknown After cursor is ErrNotFound.
+func (s *sqlStore) List(ctx context.Context, q itemQuery) ([]Item, int, error) {
+	filter, args := ` WHERE tenant = $1`, []any{tenantFrom(ctx)}
+	if q.NamePrefix != "" {
+		filter, args = filter+` AND name LIKE $2 ESCAPE '\'`, append(args, escapeLike(q.NamePrefix)+"%")
+	}
+	if !q.IncludeDeleted {
+		filter += notDeleted
+	}
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM items`+filter, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count items: %w", err)
+	}
+
+	items := []Item{}
+	for item, err := range s.ListStream(ctx, q) {
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	return items, total, nil
+}
+
+// ListStream yields List's page straight from the result set, holding one
+// row at a time.
+func (s *sqlStore) ListStream(ctx context.Context, q itemQuery) iter.Seq2[Item, error] {
+	return func(yield func(Item, error) bool) {
+		query, args, err := s.pageQuery(ctx, q)
+		if err != nil {
+			yield(Item{}, err)
+			return
+		}
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			yield(Item{}, fmt.Errorf("list items: %w", err))
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			item, err := scanItem(rows)
+			if err != nil {
+				yield(Item{}, err)
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(Item{}, fmt.Errorf("list items: %w", err))
+		}
+	}
+}
+
+// pageQuery builds the SELECT for q's filter, cursor, order, and page.
+func (s *sqlStore) pageQuery(ctx context.Context, q itemQuery) (string, []any, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	tenant := tenantFrom(ctx)
+	where = append(where, `tenant = `+arg(tenant))
+	if q.NamePrefix != "" {
+		where = append(where, `name LIKE `+arg(escapeLike(q.NamePrefix)+"%")+` ESCAPE '\'`)
+	}
+	if !q.IncludeDeleted {
+		where = append(where, `deleted_at IS NULL`)
+	}
+
+	key := `(created_at, id COLLATE "C")`
+	if q.SortBy == "name" {
+		key = `(name COLLATE "C", created_at, id COLLATE "C")`
+	}
+	direction, cmp := "ASC", ">"
+	if q.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	if q.After != "" {
+		cursor, err := scanItem(s.db.QueryRowContext(ctx, `SELECT `+itemColumns+` FROM items WHERE id = $1 AND tenant = $2`, q.After, tenant))
+		if err == nil && cursor.DeletedAt != nil && !q.IncludeDeleted {
+			err = ErrNotFound
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if q.SortBy == "name" {
+			where = append(where, key+" "+cmp+" ("+arg(cursor.Name)+` COLLATE "C", `+arg(cursor.CreatedAt)+", "+arg(cursor.ID)+` COLLATE "C")`)
+		} else {
+			where = append(where, key+" "+cmp+" ("+arg(cursor.CreatedAt)+", "+arg(cursor.ID)+` COLLATE "C")`)
+		}
+	}
+	filter := " WHERE " + strings.Join(where, " AND ")
+
+	order := strings.ReplaceAll(strings.Trim(key, "()"), ",", " "+direction+",") + " " + direction
+	query := `SELECT ` + itemColumns + ` FROM items` + filter + ` ORDER BY ` + order + ` OFFSET ` + arg(q.Offset)
+	if q.Limit > 0 {
+		query += ` LIMIT ` + arg(q.Limit)
+	}
+	return query, args, nil
+}
+
+// Update replaces the name and data of an existing item.
+func (s *sqlStore) Update(ctx context.Context, id string, item Item) (Item, error) {
+	data, err := encodeItemData(item.Data)
+	if err != nil {
+		return Item{}, err
+	}
+	row := s.db.QueryRowContext(ctx,
+		`UPDATE items SET name = $2, data = $3, updated_at = $4, version = version + 1 WHERE id = $1 AND tenant = $5`+notDeleted+` RETURNING `+itemColumns,
+		id, item.Name, data, time.Now().UTC().Truncate(time.Microsecond), tenantFrom(ctx))
+	return scanItem(row)
+}
+
+// Patch applies fn inside a transaction holding a row lock, so concurrent
+// patches to the same item are serialized.
+func (s *sqlStore) Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Item{}, fmt.Errorf("patch item: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := scanItem(tx.QueryRowContext(ctx, `SELECT `+itemColumns+` FROM items WHERE id = $1 AND tenant = $2`+notDeleted+` FOR UPDATE`, id, tenantFrom(ctx)))
+	if err != nil {
+		return Item{}, err
+	}
+	patched, err := fn(existing)
+	if err != nil {
+		return Item{}, err
+	}
+	data, err := encodeItemData(patched.Data)
+	if err != nil {
+		return Item{}, err
+	}
+	updated, err := scanItem(tx.QueryRowContext(ctx,
+		`UPDATE items SET name = $2, data = $3, updated_at = $4, version = version + 1 WHERE id = $1 RETURNING `+itemColumns,
+		id, patched.Name, data, time.Now().UTC().Truncate(time.Microsecond)))
+	if err != nil {
+		return Item{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Item{}, fmt.Errorf("patch item: %w", err)
+	}
+	return updated, nil
+}
+
+// Delete marks the item with the given ID deleted.
+func (s *sqlStore) Delete(ctx context.Context, id string) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE items SET deleted_at = $2, updated_at = $2, version = version + 1 WHERE id = $1 AND tenant = $3`+notDeleted, id, now, tenantFrom(ctx))
+	if err != nil {
+		return fmt.Errorf("delete item: %w", err)
+	}
+	return affectedOne(result, "delete item")
+}
+
+// Restore clears the item's deleted_at, or returns it as it is when it
+// isn't deleted.
+func (s *sqlStore) Restore(ctx context.Context, id string) (Item, bool, error) {
+	item, err := scanItem(s.db.QueryRowContext(ctx,
+		`UPDATE items SET deleted_at = NULL, updated_at = $2, version = version + 1 WHERE id = $1 AND tenant = $3 AND deleted_at IS NOT NULL RETURNING `+itemColumns,
+		id, time.Now().UTC().Truncate(time.Microsecond), tenantFrom(ctx)))
+	if errors.Is(err, ErrNotFound) {
+		item, err = s.Get(ctx, id)
+		return item, false, err
+	}
+	return item, err == nil, err
+}
+
+// Purge removes the item with the given ID.
+func (s *sqlStore) Purge(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM items WHERE id = $1 AND tenant = $2`, id, tenantFrom(ctx))
+	if err != nil {
+		return fmt.Errorf("purge item: %w", err)
+	}
+	return affectedOne(result, "purge item")
+}
+
+// PurgeDeleted removes the items deleted before cutoff, in every tenant.
+func (s *sqlStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM items WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("purge deleted items: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("purge deleted items: %w", err)
+	}
+	return int(n), nil
+}
+
+// affectedOne returns ErrNotFound when result changed no row.
+func affectedOne(result sql.Result, op string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanItem reads one item row, mapping sql.ErrNoRows to ErrNotFound.
+func scanItem(row rowScanner) (Item, error) {
+	var item Item
+	var data []byte
+	var deletedAt sql.NullTime
+	err := row.Scan(&item.ID, &item.Name, &data, &item.CreatedAt, &item.UpdatedAt, &item.Version, &deletedAt, &item.Tenant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, ErrNotFound
+	}
+	if err != nil {
+		return Item{}, fmt.Errorf("scan item: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &item.Data); err != nil {
+			return Item{}, fmt.Errorf("decode item data: %w", err)
+		}
+	}
+	item.CreatedAt = item.CreatedAt.UTC()
+	item.UpdatedAt = item.UpdatedAt.UTC()
+	if deletedAt.Valid {
+		t := deletedAt.Time.UTC()
+		item.DeletedAt = &t
+	}
+	return item, nil
+}
+
+// encodeItemData marshals Data for the JSONB column, storing NULL when empty.
+func encodeItemData(data map[string]any) (any, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("encode item data: %w", err)
+	}
+	return string(b), nil
+}
+
+// escapeLike escapes LIKE wildcards so a name prefix matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
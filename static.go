@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embeddedStatic holds the demo frontend served when STATIC_DIR is unset.
+//
+//go:embed static
+var embeddedStatic embed.FS
+
+// staticFiles serves a frontend from an fs.FS: the embedded assets or
+// STATIC_DIR. Directory listings are never produced and dotfiles are
+// treated as missing.
+type staticFiles struct {
+	fsys fs.FS
+
+	mu     sync.Mutex
+	hashes map[string]staticHash
+}
+
+// staticHash caches a file's content hash until its size or modification
+// time changes.
+type staticHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// newStaticFiles serves dir, or the embedded assets when dir is empty.
+func newStaticFiles(dir string) (*staticFiles, error) {
+	var fsys fs.FS
+	if dir == "" {
+		sub, err := fs.Sub(embeddedStatic, "static")
+		if err != nil {
+			return nil, err
+		}
+		fsys = sub
+	} else {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		fsys = os.DirFS(dir)
+	}
+	return &staticFiles{fsys: fsys, hashes: make(map[string]staticHash)}, nil
+}
+
+// hiddenPath reports whether any element of name starts with a dot.
+func hiddenPath(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// errStaticNotFound covers missing, hidden, and unindexed paths alike.
+var errStaticNotFound = errors.New("static file not found")
+
+// open resolves name to a regular file. Directories resolve to their
+// index.html; isDir reports that so the caller can redirect to a trailing
+// slash.
+func (sf *staticFiles) open(name string) (f fs.File, info fs.FileInfo, resolved string, isDir bool, err error) {
+	name = path.Clean("/" + name)[1:]
+	if hiddenPath(name) {
+		return nil, nil, "", false, errStaticNotFound
+	}
+	if name == "" {
+		name = "."
+	}
+	f, err = sf.fsys.Open(name)
+	if err != nil {
+		return nil, nil, "", false, errStaticNotFound
+	}
+	info, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, "", false, errStaticNotFound
+	}
+	if info.IsDir() {
+		f.Close()
+		resolved = path.Join(name, "index.html")
+		f, err = sf.fsys.Open(resolved)
+		if err != nil {
+			return nil, nil, "", false, errStaticNotFound
+		}
+		if info, err = f.Stat(); err != nil {
+			f.Close()
+			return nil, nil, "", false, errStaticNotFound
+		}
+		isDir = true
+	} else {
+		resolved = name
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, "", false, errStaticNotFound
+	}
+	return f, info, resolved, isDir, nil
+}
+
+// hash returns the content hash of the open file at name, rewinding it.
+func (sf *staticFiles) hash(name string, info fs.FileInfo, rs io.ReadSeeker) (string, error) {
+	sf.mu.Lock()
+	cached, ok := sf.hashes[name]
+	sf.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rs); err != nil {
+		return "", err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil)[:8])
+	sf.mu.Lock()
+	sf.hashes[name] = staticHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	sf.mu.Unlock()
+	return sum, nil
+}
+
+// serve writes the file at name, reporting false when there is none. The
+// ETag is the content hash; a ?v= matching it marks the URL as
+// content-addressed and cacheable for a year, while plain URLs must be
+// revalidated on each use.
+func (sf *staticFiles) serve(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, info, resolved, isDir, err := sf.open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if isDir && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return true
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+	sum, err := sf.hash(resolved, info, rs)
+	if err != nil {
+		return false
+	}
+
+	h := w.Header()
+	h.Set("ETag", `"`+sum+`"`)
+	if v := r.URL.Query().Get("v"); v != "" && v == sum {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "public, no-cache")
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, resolved, info.ModTime(), rs)
+	return true
+}
+
+// staticHandler handles GET /static/{path...}.
+func (s *Server) staticHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.static.serve(w, r, r.PathValue("path")) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "File not found", "")
+	}
+}
+
+// appHandler handles GET /app/{path...} for single-page apps: existing
+// files are served as under /static/, and every other path gets the root
+// index.html so client-side routing can take over.
+func (s *Server) appHandler(w http.ResponseWriter, r *http.Request) {
+	if name := r.PathValue("path"); name != "" && s.static.serve(w, r, name) {
+		return
+	}
+	if !s.static.serve(w, r, "index.html") {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "File not found", "")
+	}
+}
@@ -0,0 +1,21 @@
+// Calls the API from the browser so the demo shows a working round trip.
+async function show(id, url) {
+  const out = document.getElementById(id);
+  try {
+    const res = await fetch(url, { headers: { Accept: "application/json" } });
+    const body = await res.json();
+    out.textContent = res.status + " " + res.statusText + "\n" + JSON.stringify(body, null, 2);
+    out.className = res.ok ? "ok" : "error";
+  } catch (err) {
+    out.textContent = String(err);
+    out.className = "error";
+  }
+}
+
+function refresh() {
+  show("health", "/healthz");
+  show("api", "/api/v1");
+}
+
+document.getElementById("refresh").addEventListener("click", refresh);
+refresh();
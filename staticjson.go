@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// staticDocument is a response body that can't change while the process
+// runs, encoded once: plain and indented, each as-is and gzipped, with a
+// strong ETag per variant and Last-Modified at process start. Header values
+// are kept as ready-made slices so a request allocates next to nothing.
+type staticDocument struct {
+	contentType  []string
+	modified     time.Time
+	lastModified []string
+	// variants is indexed by [pretty][gzip].
+	variants [2][2]staticVariant
+}
+
+type staticVariant struct {
+	body   []byte
+	etag   []string
+	length []string
+}
+
+var gzipEncoding = []string{"gzip"}
+
+// newStaticDocument prepares both variants of plain and pretty; documents
+// without an indented form pass the same bytes twice.
+func newStaticDocument(contentType string, modified time.Time, plain, pretty []byte) *staticDocument {
+	modified = modified.UTC().Truncate(time.Second)
+	d := &staticDocument{
+		contentType:  []string{contentType},
+		modified:     modified,
+		lastModified: []string{modified.Format(http.TimeFormat)},
+	}
+	for i, body := range [][]byte{plain, pretty} {
+		// Writes to a bytes.Buffer can't fail.
+		var buf bytes.Buffer
+		gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		gz.Write(body)
+		gz.Close()
+		d.variants[i][0] = newStaticVariant(body)
+		d.variants[i][1] = newStaticVariant(buf.Bytes())
+	}
+	return d
+}
+
+func newStaticVariant(body []byte) staticVariant {
+	sum := sha256.Sum256(body)
+	return staticVariant{
+		body:   body,
+		etag:   []string{`"` + hex.EncodeToString(sum[:8]) + `"`},
+		length: []string{strconv.Itoa(len(body))},
+	}
+}
+
+// serve writes the variant r asks for, or 304 when r's If-None-Match or,
+// lacking one, If-Modified-Since shows the client has it already. The
+// body is gzipped here, so withGzip passes it through untouched. Like
+// writeResponse, routes configured no-store get no validators.
+func (d *staticDocument) serve(w http.ResponseWriter, r *http.Request, pretty bool) {
+	gz := 0
+	if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		gz = 1
+	}
+	p := 0
+	if pretty {
+		p = 1
+	}
+	v := d.variants[p][gz]
+
+	// The keys must be canonical, as Header.Get looks them up: "Etag", not
+	// "ETag".
+	h := w.Header()
+	h["Content-Type"] = d.contentType
+	h.Add("Vary", "Accept")
+	if !strings.Contains(h.Get("Cache-Control"), "no-store") {
+		h["Etag"] = v.etag
+		h["Last-Modified"] = d.lastModified
+		if d.notModified(r, v.etag[0]) {
+			h.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if gz == 1 {
+		h["Content-Encoding"] = gzipEncoding
+	}
+	h["Content-Length"] = v.length
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(v.body)
+	}
+}
+
+// notModified evaluates r's preconditions against the document as RFC 9110
+// orders them: If-Modified-Since only counts without an If-None-Match.
+func (d *staticDocument) notModified(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	return err == nil && !d.modified.After(since)
+}
+
+// staticJSON serves payload, which must not change once the routes are
+// registered, from a staticDocument. It is encoded on first use, so a
+// payload such as the OpenAPI spec can still gain routes until then; a
+// payload that can't be encoded is a bug, and panics naming the handler.
+// XML and YAML are rare enough for these routes to go through
+// writeResponse each time.
+func (s *Server) staticJSON(name string, payload any) http.HandlerFunc {
+	render := sync.OnceValue(func() *staticDocument {
+		plain, err := json.Marshal(payload)
+		if err != nil {
+			panic(fmt.Sprintf("%s: cannot encode static payload: %v", name, err))
+		}
+		var pretty bytes.Buffer
+		json.Indent(&pretty, plain, "", "  ")
+		pretty.WriteByte('\n')
+		return newStaticDocument(contentTypeJSON, s.started, append(plain, '\n'), pretty.Bytes())
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contentType, _ := negotiate(r.Header.Get("Accept")); contentType != contentTypeJSON {
+			writeResponse(w, r, http.StatusOK, payload)
+			return
+		}
+		render().serve(w, r, wantsPretty(r))
+	}
+}
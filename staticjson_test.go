@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticJSON(t *testing.T) {
+	srv, h := newTestServer(t)
+	get := func(target string, header ...string) *http.Response {
+		t.Helper()
+		r := newRequest(t, "GET", target, "")
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		return serve(h, r).Result()
+	}
+	for _, target := range []string{"/openapi.json", "/version"} {
+		t.Run(target, func(t *testing.T) {
+			plain := get(target)
+			body, _ := io.ReadAll(plain.Body)
+			etag := plain.Header.Get("ETag")
+			if plain.StatusCode != http.StatusOK || !json.Valid(body) || plain.Header.Get("Content-Length") == "" {
+				t.Fatalf("GET = %d, %d bytes, Content-Length %q", plain.StatusCode, len(body), plain.Header.Get("Content-Length"))
+			}
+			if !strings.HasPrefix(etag, `"`) {
+				t.Errorf("ETag = %q, want a strong validator", etag)
+			}
+			if lm := plain.Header.Get("Last-Modified"); lm != srv.started.UTC().Format(http.TimeFormat) {
+				t.Errorf("Last-Modified = %q, want the process start", lm)
+			}
+			if again := get(target); again.Header.Get("ETag") != etag {
+				t.Errorf("ETag changed between requests: %q, then %q", etag, again.Header.Get("ETag"))
+			}
+
+			for _, tc := range []struct {
+				name   string
+				header []string
+				want   int
+			}{
+				{"matching If-None-Match", []string{"If-None-Match", etag}, http.StatusNotModified},
+				{"one of several", []string{"If-None-Match", `"other", ` + etag}, http.StatusNotModified},
+				{"stale If-None-Match", []string{"If-None-Match", `"other"`}, http.StatusOK},
+				{"If-Modified-Since now", []string{"If-Modified-Since", time.Now().UTC().Format(http.TimeFormat)}, http.StatusNotModified},
+				{"If-Modified-Since before start", []string{"If-Modified-Since", srv.started.Add(-time.Hour).UTC().Format(http.TimeFormat)}, http.StatusOK},
+				{"If-None-Match wins", []string{"If-None-Match", `"other"`, "If-Modified-Since", time.Now().UTC().Format(http.TimeFormat)}, http.StatusOK},
+			} {
+				resp := get(target, tc.header...)
+				b, _ := io.ReadAll(resp.Body)
+				if resp.StatusCode != tc.want || (tc.want == http.StatusNotModified && len(b) != 0) {
+					t.Errorf("%s: GET = %d with %d bytes, want %d", tc.name, resp.StatusCode, len(b), tc.want)
+				}
+			}
+
+			gz := get(target, "Accept-Encoding", "gzip")
+			if gz.Header.Get("Content-Encoding") != "gzip" || gz.Header.Get("ETag") == etag {
+				t.Fatalf("gzip GET: Content-Encoding %q, ETag %q, want gzip with its own ETag", gz.Header.Get("Content-Encoding"), gz.Header.Get("ETag"))
+			}
+			zr, err := gzip.NewReader(gz.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inflated, _ := io.ReadAll(zr); !bytes.Equal(inflated, body) {
+				t.Error("gzip variant doesn't inflate to the plain body")
+			}
+
+			pretty := get(target+"?pretty=true", "Accept-Encoding", "identity")
+			prettyBody, _ := io.ReadAll(pretty.Body)
+			if !bytes.Contains(prettyBody, []byte("\n  ")) || pretty.Header.Get("ETag") == etag {
+				t.Errorf("?pretty=true body isn't indented, or shares the plain ETag")
+			}
+			if xml := get(target, "Accept", "application/xml"); xml.StatusCode != http.StatusOK || !strings.HasPrefix(xml.Header.Get("Content-Type"), "application/xml") {
+				t.Errorf("Accept: application/xml = %d %s, want XML", xml.StatusCode, xml.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// openAPIPayload decodes the served spec, to stand in for a large document.
+func openAPIPayload(tb testing.TB) (*Server, any) {
+	srv, h := newTestServer(tb)
+	var spec any
+	if err := json.Unmarshal(serve(h, newRequest(tb, "GET", "/openapi.json", "")).Body.Bytes(), &spec); err != nil {
+		tb.Fatal(err)
+	}
+	return srv, spec
+}
+
+func TestStaticJSONAllocations(t *testing.T) {
+	srv, spec := openAPIPayload(t)
+	h := srv.staticJSON("/spec", spec)
+	r := newRequest(t, "GET", "/spec", "")
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := &discardWriter{header: make(http.Header)}
+	h(w, r)
+	allocs := testing.AllocsPerRun(100, func() {
+		clear(w.header)
+		h(w, r)
+	})
+	if allocs > 2 {
+		t.Errorf("serving a pre-rendered document allocates %v times, want next to none", allocs)
+	}
+}
+
+func BenchmarkStaticJSON(b *testing.B) {
+	srv, spec := openAPIPayload(b)
+	for _, bc := range []struct {
+		name string
+		h    http.HandlerFunc
+	}{
+		{"prerendered", srv.staticJSON("/spec", spec)},
+		{"encoded", func(w http.ResponseWriter, r *http.Request) { writeResponse(w, r, http.StatusOK, spec) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			r := newRequest(b, "GET", "/spec", "")
+			w := &discardWriter{header: make(http.Header)}
+			b.ReportAllocs()
+			for range b.N {
+				clear(w.header)
+				bc.h(w, r)
+			}
+		})
+	}
+}
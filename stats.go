@@ -0,0 +1,209 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsSamples is how many recent latencies each route keeps for its
+// quantiles.
+const statsSamples = 1024
+
+// statusClasses label routeCounters.classes.
+var statusClasses = [5]string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// routeCounters accumulates one route and method. Counters are atomics so
+// concurrent requests never wait on each other; only the latency ring has a
+// lock, and it is per route.
+type routeCounters struct {
+	total   atomic.Uint64
+	classes [5]atomic.Uint64
+	bytes   atomic.Uint64
+	nanos   atomic.Int64
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (rc *routeCounters) observe(status, bytes int, d time.Duration) {
+	rc.total.Add(1)
+	if class := status/100 - 1; class >= 0 && class < len(rc.classes) {
+		rc.classes[class].Add(1)
+	}
+	rc.bytes.Add(uint64(bytes))
+	rc.nanos.Add(int64(d))
+
+	rc.mu.Lock()
+	if len(rc.samples) < statsSamples {
+		rc.samples = append(rc.samples, d)
+	} else {
+		rc.samples[rc.next] = d
+		rc.next = (rc.next + 1) % statsSamples
+	}
+	rc.mu.Unlock()
+}
+
+type routeKey struct {
+	path   string
+	method string
+}
+
+// requestStats is the in-process request summary behind /stats and /ui.
+type requestStats struct {
+	routes sync.Map // routeKey -> *routeCounters
+	since  atomic.Pointer[time.Time]
+}
+
+// httpStats is fed by instrument alongside the Prometheus metrics.
+var httpStats = newRequestStats()
+
+func newRequestStats() *requestStats {
+	st := &requestStats{}
+	now := time.Now().UTC()
+	st.since.Store(&now)
+	return st
+}
+
+// Observe records one finished request.
+func (st *requestStats) Observe(path, method string, status, bytes int, d time.Duration) {
+	key := routeKey{path, method}
+	rc, ok := st.routes.Load(key)
+	if !ok {
+		rc, _ = st.routes.LoadOrStore(key, &routeCounters{})
+	}
+	rc.(*routeCounters).observe(status, bytes, d)
+}
+
+// Reset zeroes every counter. Requests finishing during the reset may be
+// counted on either side of it.
+func (st *requestStats) Reset() {
+	st.routes.Clear()
+	now := time.Now().UTC()
+	st.since.Store(&now)
+}
+
+// RouteStats summarizes one route and method for /stats.
+type RouteStats struct {
+	Path   string            `json:"path"`
+	Method string            `json:"method"`
+	Total  uint64            `json:"total"`
+	Status map[string]uint64 `json:"status"`
+	Bytes  uint64            `json:"bytes"`
+	AvgMS  float64           `json:"avg_ms"`
+	P50MS  float64           `json:"p50_ms"`
+	P95MS  float64           `json:"p95_ms"`
+	P99MS  float64           `json:"p99_ms"`
+}
+
+// Errors is the number of 5xx responses.
+func (rs RouteStats) Errors() uint64 {
+	return rs.Status["5xx"]
+}
+
+// Routes summarizes every route seen since the last reset, sorted by path
+// and method. Quantiles cover the most recent statsSamples requests.
+func (st *requestStats) Routes() []RouteStats {
+	var routes []RouteStats
+	st.routes.Range(func(k, v any) bool {
+		key, rc := k.(routeKey), v.(*routeCounters)
+		rs := RouteStats{
+			Path:   key.path,
+			Method: key.method,
+			Total:  rc.total.Load(),
+			Status: make(map[string]uint64),
+			Bytes:  rc.bytes.Load(),
+		}
+		for i, class := range statusClasses {
+			if n := rc.classes[i].Load(); n > 0 {
+				rs.Status[class] = n
+			}
+		}
+		if rs.Total > 0 {
+			rs.AvgMS = durationMS(time.Duration(rc.nanos.Load() / int64(rs.Total)))
+		}
+		rc.mu.Lock()
+		samples := slices.Clone(rc.samples)
+		rc.mu.Unlock()
+		slices.Sort(samples)
+		rs.P50MS = durationMS(quantile(samples, 0.50))
+		rs.P95MS = durationMS(quantile(samples, 0.95))
+		rs.P99MS = durationMS(quantile(samples, 0.99))
+		routes = append(routes, rs)
+		return true
+	})
+	slices.SortFunc(routes, func(a, b RouteStats) int {
+		return cmp.Or(cmp.Compare(a.Path, b.Path), cmp.Compare(a.Method, b.Method))
+	})
+	return routes
+}
+
+// quantile returns the nearest-rank q-quantile of sorted, or 0 if it is
+// empty.
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// StatsResponse is the body of GET /stats.
+type StatsResponse struct {
+	Since         time.Time    `json:"since"`
+	TotalRequests uint64       `json:"total_requests"`
+	BytesWritten  uint64       `json:"bytes_written"`
+	Goroutines    int          `json:"goroutines"`
+	Memory        MemoryInfo   `json:"memory"`
+	Routes        []RouteStats `json:"routes"`
+	// Concurrency lists the enabled concurrency limiters.
+	Concurrency []ConcurrencyStats `json:"concurrency,omitempty"`
+	// Connections has the public listener's connections, then the admin
+	// port's when it has one.
+	Connections []ConnectionStats `json:"connections"`
+	ReadOnly    ReadOnlyState     `json:"read_only"`
+}
+
+// statsHandler serves GET /stats.
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := StatsResponse{
+		Since:      *httpStats.since.Load(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory:     readMemoryInfo(),
+		Routes:     httpStats.Routes(),
+		ReadOnly:   s.readOnly.State(),
+	}
+	for _, rs := range resp.Routes {
+		resp.TotalRequests += rs.Total
+		resp.BytesWritten += rs.Bytes
+	}
+	if resp.Routes == nil {
+		resp.Routes = []RouteStats{}
+	}
+	for _, l := range []*concurrencyLimiter{s.apiLimit, s.delayLimit} {
+		if l.limit.Max > 0 {
+			resp.Concurrency = append(resp.Concurrency, l.Stats())
+		}
+	}
+	resp.Connections = []ConnectionStats{s.conns.Stats()}
+	if s.adminConns != nil {
+		resp.Connections = append(resp.Connections, s.adminConns.Stats())
+	}
+	writeResponse(w, r, http.StatusOK, resp)
+}
+
+// statsResetHandler serves POST /stats/reset.
+func (s *Server) statsResetHandler(w http.ResponseWriter, r *http.Request) {
+	httpStats.Reset()
+	loggerFrom(r.Context()).Info("request stats reset")
+	w.WriteHeader(http.StatusNoContent)
+}
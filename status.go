@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusResponse describes the status returned by /status/{code}.
+type StatusResponse struct {
+	Code        int       `json:"code"`
+	Description string    `json:"description"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// statusHandler serves /status/{code}, replying with any status from 100 to
+// 599. 3xx responses redirect to /, and informational codes are sent as an
+// interim response ahead of a final 200. ?delay= waits first, up to
+// DELAY_MAX, and ?body=false omits the JSON body.
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	code, err := strconv.Atoi(r.PathValue("code"))
+	if err != nil || code < 100 || code > 599 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid status code", fmt.Sprintf("%q must be a number between 100 and 599", r.PathValue("code")))
+		return
+	}
+	if code == http.StatusSwitchingProtocols {
+		// net/http treats 101 as a final response, which leaves the
+		// client waiting for a protocol that never arrives.
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid status code", "101 requires a protocol upgrade; use /ws instead")
+		return
+	}
+
+	query := r.URL.Query()
+	withBody := true
+	if v := query.Get("body"); v != "" {
+		withBody, err = strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid query parameter", fmt.Sprintf("body must be true or false, got %q", v))
+			return
+		}
+	}
+	if v := query.Get("delay"); v != "" {
+		wait, err := parseDelay(v, s.cfg.DelayMax)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Invalid delay", err.Error())
+			return
+		}
+		if !delayResponse(w, r, wait) {
+			return
+		}
+	}
+
+	final := code
+	if code < 200 {
+		w.WriteHeader(code)
+		final = http.StatusOK
+	}
+	if code >= 300 && code < 400 {
+		w.Header().Set("Location", "/")
+	}
+	description := http.StatusText(code)
+	if description == "" {
+		description = "Unassigned"
+	}
+	if !withBody || !bodyAllowed(final) {
+		w.WriteHeader(final)
+		return
+	}
+	writeResponse(w, r, final, StatusResponse{Code: code, Description: description, Timestamp: s.clock.Now()})
+}
+
+// bodyAllowed reports whether a response with status may carry a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
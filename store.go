@@ -0,0 +1,717 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrNotFound is returned by store operations for unknown item IDs.
+var ErrNotFound = errors.New("item not found")
+
+// Store persists items. Implementations must be safe for concurrent use and
+// return ErrNotFound for unknown IDs.
+//
+// Items belong to the tenant of the context they were created with
+// (tenantFrom). Every operation but PurgeDeleted sees only that tenant's
+// items, and treats other tenants' as unknown; PurgeDeleted spans every
+// tenant.
+//
+// Delete is a soft delete: the item keeps its data and gets a DeletedAt,
+// but Get, Update, Patch, Delete, and List without IncludeDeleted treat it
+// as missing until Restore brings it back. Purge and PurgeDeleted remove
+// items for good.
+type Store interface {
+	Create(ctx context.Context, item Item) (Item, error)
+	Get(ctx context.Context, id string) (Item, error)
+	List(ctx context.Context, q itemQuery) ([]Item, int, error)
+	// ListStream yields the page List would return one item at a time, so
+	// large listings can be written out without holding them in memory. An
+	// error ends the sequence.
+	ListStream(ctx context.Context, q itemQuery) iter.Seq2[Item, error]
+	Update(ctx context.Context, id string, item Item) (Item, error)
+	Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error)
+	Delete(ctx context.Context, id string) error
+	// Restore undeletes the item with id. One that isn't deleted is
+	// returned unchanged, with restored false.
+	Restore(ctx context.Context, id string) (item Item, restored bool, err error)
+	// Purge removes the item with id, deleted or not.
+	Purge(ctx context.Context, id string) error
+	// PurgeDeleted removes the items deleted before cutoff and returns how
+	// many there were.
+	PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// purgeDeletedInterval is how often items deleted longer than
+// DELETED_ITEM_RETENTION ago are purged.
+const purgeDeletedInterval = time.Minute
+
+// generationStore is implemented by stores that can cheaply report a token
+// that changes on every mutation of a tenant's items, letting list ETags
+// skip hashing the body.
+type generationStore interface {
+	Generation(tenant string) string
+}
+
+// memoryStore keeps items in a map guarded by a RWMutex so concurrent
+// readers don't block each other. When path is set, every mutation is
+// written through to that JSON file. index follows every change to items,
+// under the same lock. now stamps created and updated times.
+type memoryStore struct {
+	mu    sync.RWMutex
+	items map[string]Item
+	index *searchIndex
+	path  string
+	now   func() time.Time
+
+	// generations counts successful mutations by tenant, so one tenant's
+	// writes leave the others' list ETags valid; epoch distinguishes this
+	// process's counters from a previous run's.
+	generations map[string]uint64
+	epoch       int64
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{items: make(map[string]Item), index: newSearchIndex(), now: time.Now, generations: make(map[string]uint64), epoch: time.Now().UnixNano()}
+}
+
+// Generation identifies the current contents of the tenant's items.
+func (s *memoryStore) Generation(tenant string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return strconv.FormatInt(s.epoch, 36) + "." + strconv.FormatUint(s.generations[tenant], 10)
+}
+
+// Create assigns a new ID and timestamps to item and stores it.
+//
+// Each operation checks ctx once it holds the lock, so a request whose
+// client gave up while it waited behind a writer does no work.
+func (s *memoryStore) Create(ctx context.Context, item Item) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+	return s.createLocked(tenantFrom(ctx), item)
+}
+
+func (s *memoryStore) createLocked(tenant string, item Item) (Item, error) {
+	now := s.now().UTC()
+	item.ID = newUUID()
+	item.Tenant = tenant
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	item.Version = 1
+	if err := s.putLocked(item.ID, item); err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// Get returns the item with the given ID.
+func (s *memoryStore) Get(ctx context.Context, id string) (Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+	return s.getLocked(tenantFrom(ctx), id)
+}
+
+func (s *memoryStore) getLocked(tenant, id string) (Item, error) {
+	item, ok := s.lookupLocked(tenant, id)
+	if !ok || item.DeletedAt != nil {
+		return Item{}, ErrNotFound
+	}
+	return cloneItem(item), nil
+}
+
+// lookupLocked returns the item with id if tenant owns it, deleted or not.
+// The caller must hold s.mu.
+func (s *memoryStore) lookupLocked(tenant, id string) (Item, bool) {
+	item, ok := s.items[id]
+	if !ok || item.Tenant != tenant {
+		return Item{}, false
+	}
+	return item, true
+}
+
+// itemQuery selects, orders, and pages the results of List.
+type itemQuery struct {
+	NamePrefix string
+	SortBy     string // "created_at" or "name"
+	Desc       bool
+	After      string // cursor: return items after this ID in sort order
+	Offset     int
+	Limit      int
+	// IncludeDeleted lists soft-deleted items too.
+	IncludeDeleted bool
+	// Tenant is set by the store from the context.
+	Tenant string
+}
+
+// matches reports whether item is selected by q's filters.
+func (q itemQuery) matches(item Item) bool {
+	return item.Tenant == q.Tenant && strings.HasPrefix(item.Name, q.NamePrefix) && (q.IncludeDeleted || item.DeletedAt == nil)
+}
+
+// List returns the page of items selected by q along with the total number
+// of items matching the filter before paging. An After cursor naming an
+// unknown item returns ErrNotFound.
+func (s *memoryStore) List(ctx context.Context, q itemQuery) ([]Item, int, error) {
+	q.Tenant = tenantFrom(ctx)
+	s.mu.RLock()
+	if err := ctx.Err(); err != nil {
+		s.mu.RUnlock()
+		return nil, 0, err
+	}
+	items := s.matchLocked(q)
+	s.mu.RUnlock()
+	// Sorting a large store is the expensive part; skip it if the client
+	// has gone meanwhile.
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	return pageItems(items, q)
+}
+
+// ListStream copies only the sort keys of matching items up front and
+// clones each item as it is yielded, skipping any deleted meanwhile, so
+// streaming the whole store doesn't double its memory.
+func (s *memoryStore) ListStream(ctx context.Context, q itemQuery) iter.Seq2[Item, error] {
+	q.Tenant = tenantFrom(ctx)
+	return func(yield func(Item, error) bool) {
+		s.mu.RLock()
+		keys := make([]Item, 0, len(s.items))
+		for _, item := range s.items {
+			if q.matches(item) {
+				keys = append(keys, Item{ID: item.ID, Name: item.Name, CreatedAt: item.CreatedAt})
+			}
+		}
+		s.mu.RUnlock()
+		keys, _, err := pageItems(keys, q)
+		if err != nil {
+			yield(Item{}, err)
+			return
+		}
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				yield(Item{}, err)
+				return
+			}
+			s.mu.RLock()
+			item, ok := s.items[key.ID]
+			if ok = ok && q.matches(item); ok {
+				item = cloneItem(item)
+			}
+			s.mu.RUnlock()
+			if ok && !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+// listStream implements ListStream with list, the store's List, for stores
+// that have to hold the page anyway.
+func listStream(ctx context.Context, q itemQuery, list func(context.Context, itemQuery) ([]Item, int, error)) iter.Seq2[Item, error] {
+	return func(yield func(Item, error) bool) {
+		items, _, err := list(ctx, q)
+		if err != nil {
+			yield(Item{}, err)
+			return
+		}
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+// matchLocked copies out the items q's filters select. The caller must hold
+// s.mu.
+func (s *memoryStore) matchLocked(q itemQuery) []Item {
+	items := make([]Item, 0, len(s.items))
+	for _, item := range s.items {
+		if q.matches(item) {
+			items = append(items, cloneItem(item))
+		}
+	}
+	return items
+}
+
+// pageItems sorts items and applies q's cursor, offset, and limit.
+func pageItems(items []Item, q itemQuery) ([]Item, int, error) {
+
+	slices.SortFunc(items, func(a, b Item) int {
+		c := 0
+		if q.SortBy == "name" {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if c == 0 {
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if c == 0 {
+			c = strings.Compare(a.ID, b.ID)
+		}
+		if q.Desc {
+			return -c
+		}
+		return c
+	})
+	total := len(items)
+
+	if q.After != "" {
+		i := slices.IndexFunc(items, func(item Item) bool { return item.ID == q.After })
+		if i < 0 {
+			return nil, 0, ErrNotFound
+		}
+		items = items[i+1:]
+	}
+	if q.Offset >= len(items) {
+		return []Item{}, total, nil
+	}
+	items = items[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(items) {
+		items = items[:q.Limit]
+	}
+	return items, total, nil
+}
+
+// Update replaces the name and data of an existing item, keeping its ID and
+// creation time.
+func (s *memoryStore) Update(ctx context.Context, id string, item Item) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+	return s.updateLocked(tenantFrom(ctx), id, item)
+}
+
+func (s *memoryStore) updateLocked(tenant, id string, item Item) (Item, error) {
+	existing, ok := s.lookupLocked(tenant, id)
+	if !ok || existing.DeletedAt != nil {
+		return Item{}, ErrNotFound
+	}
+	existing.Name = item.Name
+	existing.Data = item.Data
+	existing.UpdatedAt = s.now().UTC()
+	existing.Version++
+	if err := s.putLocked(id, existing); err != nil {
+		return Item{}, err
+	}
+	return existing, nil
+}
+
+// Patch applies fn to the stored item under the write lock so concurrent
+// patches can't interleave. fn receives a copy; returning an error leaves
+// the item unchanged. ID and CreatedAt are preserved, UpdatedAt is
+// refreshed, and Version is bumped.
+func (s *memoryStore) Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+	return s.patchLocked(tenantFrom(ctx), id, fn)
+}
+
+func (s *memoryStore) patchLocked(tenant, id string, fn func(Item) (Item, error)) (Item, error) {
+	existing, ok := s.lookupLocked(tenant, id)
+	if !ok || existing.DeletedAt != nil {
+		return Item{}, ErrNotFound
+	}
+	patched, err := fn(cloneItem(existing))
+	if err != nil {
+		return Item{}, err
+	}
+	patched.ID = existing.ID
+	patched.Tenant = existing.Tenant
+	patched.CreatedAt = existing.CreatedAt
+	patched.UpdatedAt = s.now().UTC()
+	patched.Version = existing.Version + 1
+	if err := s.putLocked(id, patched); err != nil {
+		return Item{}, err
+	}
+	return patched, nil
+}
+
+// Delete marks the item with the given ID deleted, bumping its version.
+func (s *memoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.deleteLocked(tenantFrom(ctx), id)
+}
+
+func (s *memoryStore) deleteLocked(tenant, id string) error {
+	existing, ok := s.lookupLocked(tenant, id)
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	now := s.now().UTC()
+	existing.DeletedAt = &now
+	existing.UpdatedAt = now
+	existing.Version++
+	return s.putLocked(id, existing)
+}
+
+// Restore clears the item's DeletedAt, bumping its version.
+func (s *memoryStore) Restore(ctx context.Context, id string) (Item, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return Item{}, false, err
+	}
+	return s.restoreLocked(tenantFrom(ctx), id)
+}
+
+func (s *memoryStore) restoreLocked(tenant, id string) (Item, bool, error) {
+	existing, ok := s.lookupLocked(tenant, id)
+	if !ok {
+		return Item{}, false, ErrNotFound
+	}
+	if existing.DeletedAt == nil {
+		return cloneItem(existing), false, nil
+	}
+	existing.DeletedAt = nil
+	existing.UpdatedAt = s.now().UTC()
+	existing.Version++
+	if err := s.putLocked(id, existing); err != nil {
+		return Item{}, false, err
+	}
+	return cloneItem(existing), true, nil
+}
+
+// Purge removes the item with the given ID.
+func (s *memoryStore) Purge(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.purgeLocked(tenantFrom(ctx), id)
+}
+
+func (s *memoryStore) purgeLocked(tenant, id string) error {
+	existing, ok := s.lookupLocked(tenant, id)
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.items, id)
+	if err := s.flushLocked(); err != nil {
+		s.items[id] = existing
+		return err
+	}
+	s.index.remove(id)
+	s.generations[tenant]++
+	return nil
+}
+
+// PurgeDeleted removes every item deleted before cutoff in one write.
+func (s *memoryStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.purgeDeletedLocked(cutoff)
+}
+
+func (s *memoryStore) purgeDeletedLocked(cutoff time.Time) (int, error) {
+	purged := make(map[string]Item)
+	for id, item := range s.items {
+		if item.DeletedAt != nil && item.DeletedAt.Before(cutoff) {
+			purged[id] = item
+			delete(s.items, id)
+		}
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+	if err := s.flushLocked(); err != nil {
+		maps.Copy(s.items, purged)
+		return 0, err
+	}
+	for id, item := range purged {
+		s.index.remove(id)
+		s.generations[item.Tenant]++
+	}
+	return len(purged), nil
+}
+
+// atomicStore is implemented by stores that can run a sequence of
+// operations as one unit.
+type atomicStore interface {
+	// Atomically calls fn with a Store whose operations see and make
+	// changes in isolation. If fn returns an error, every change it made is
+	// undone.
+	Atomically(ctx context.Context, fn func(Store) error) error
+}
+
+// Atomically holds the write lock for the whole of fn, so no other request
+// observes or interleaves with its changes, and restores the previous items
+// if fn fails.
+func (s *memoryStore) Atomically(ctx context.Context, fn func(Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Stored items are replaced rather than mutated, so a shallow copy of
+	// the map is a complete snapshot.
+	snapshot := maps.Clone(s.items)
+	generations := maps.Clone(s.generations)
+	restore := func() error {
+		s.items = snapshot
+		s.index.rebuild(snapshot)
+		s.generations = generations
+		return s.flushLocked()
+	}
+	returned := false
+	defer func() {
+		if !returned {
+			// fn panicked.
+			restore()
+		}
+	}()
+	err := fn(memoryTx{s})
+	returned = true
+	if err != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			return errors.Join(err, restoreErr)
+		}
+	}
+	return err
+}
+
+// memoryTx is the Store passed to Atomically's fn; its methods run under
+// the lock Atomically already holds.
+type memoryTx struct {
+	s *memoryStore
+}
+
+func (tx memoryTx) Create(ctx context.Context, item Item) (Item, error) {
+	return tx.s.createLocked(tenantFrom(ctx), item)
+}
+
+func (tx memoryTx) Get(ctx context.Context, id string) (Item, error) {
+	return tx.s.getLocked(tenantFrom(ctx), id)
+}
+
+func (tx memoryTx) List(ctx context.Context, q itemQuery) ([]Item, int, error) {
+	q.Tenant = tenantFrom(ctx)
+	return pageItems(tx.s.matchLocked(q), q)
+}
+
+func (tx memoryTx) ListStream(ctx context.Context, q itemQuery) iter.Seq2[Item, error] {
+	return listStream(ctx, q, tx.List)
+}
+
+func (tx memoryTx) Update(ctx context.Context, id string, item Item) (Item, error) {
+	return tx.s.updateLocked(tenantFrom(ctx), id, item)
+}
+
+func (tx memoryTx) Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	return tx.s.patchLocked(tenantFrom(ctx), id, fn)
+}
+
+func (tx memoryTx) Delete(ctx context.Context, id string) error {
+	return tx.s.deleteLocked(tenantFrom(ctx), id)
+}
+
+func (tx memoryTx) Restore(ctx context.Context, id string) (Item, bool, error) {
+	return tx.s.restoreLocked(tenantFrom(ctx), id)
+}
+
+func (tx memoryTx) Purge(ctx context.Context, id string) error {
+	return tx.s.purgeLocked(tenantFrom(ctx), id)
+}
+
+func (tx memoryTx) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	return tx.s.purgeDeletedLocked(cutoff)
+}
+
+// putLocked stores item under id and writes the change through, restoring
+// the previous state if the write fails. The caller must hold s.mu.
+func (s *memoryStore) putLocked(id string, item Item) error {
+	previous, existed := s.items[id]
+	s.items[id] = cloneItem(item)
+	if err := s.flushLocked(); err != nil {
+		if existed {
+			s.items[id] = previous
+		} else {
+			delete(s.items, id)
+		}
+		return err
+	}
+	s.index.add(item)
+	s.generations[item.Tenant]++
+	return nil
+}
+
+// cloneItem deep-copies the Data map and DeletedAt so callers can't mutate
+// stored state.
+func cloneItem(item Item) Item {
+	if item.Data != nil {
+		item.Data = cloneValue(item.Data).(map[string]any)
+	}
+	if item.DeletedAt != nil {
+		deletedAt := *item.DeletedAt
+		item.DeletedAt = &deletedAt
+	}
+	return item
+}
+
+// cloneValue deep-copies the maps and slices produced by decoding JSON.
+func cloneValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			out[key] = cloneValue(value)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, value := range v {
+			out[i] = cloneValue(value)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// instrumentedStore wraps a Store so every operation gets a child span and a
+// debug log entry through the request-scoped logger. Failures other than
+// ErrNotFound are logged at warn and mark the span as an error.
+type instrumentedStore struct {
+	Store
+}
+
+// startStoreOp begins a span for op; the returned function ends it and logs
+// the outcome.
+func startStoreOp(ctx context.Context, op string) (context.Context, func(id string, err error)) {
+	ctx, span := tracer.Start(ctx, "store."+op)
+	start := time.Now()
+	return ctx, func(id string, err error) {
+		defer span.End()
+		elapsed := time.Since(start)
+		addTiming(ctx, timingStore, elapsed)
+		attrs := []slog.Attr{
+			slog.String("op", op),
+			slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+		}
+		if id != "" {
+			attrs = append(attrs, slog.String("item_id", id))
+			span.SetAttributes(attribute.String("item.id", id))
+		}
+		level := slog.LevelDebug
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				// The request ended first; nothing is wrong with the store.
+				attrs = append(attrs, slog.Bool("cancelled", true))
+			} else if !errors.Is(err, ErrNotFound) {
+				level = slog.LevelWarn
+				recordSpanError(span, err)
+			}
+		}
+		loggerFrom(ctx).LogAttrs(ctx, level, "store operation", attrs...)
+	}
+}
+
+func (s instrumentedStore) Create(ctx context.Context, item Item) (Item, error) {
+	ctx, done := startStoreOp(ctx, "create")
+	created, err := s.Store.Create(ctx, item)
+	done(created.ID, err)
+	return created, err
+}
+
+func (s instrumentedStore) Get(ctx context.Context, id string) (Item, error) {
+	ctx, done := startStoreOp(ctx, "get")
+	item, err := s.Store.Get(ctx, id)
+	done(id, err)
+	return item, err
+}
+
+func (s instrumentedStore) List(ctx context.Context, q itemQuery) ([]Item, int, error) {
+	ctx, done := startStoreOp(ctx, "list")
+	items, total, err := s.Store.List(ctx, q)
+	done("", err)
+	return items, total, err
+}
+
+// ListStream is one "list" operation from the first item to the last.
+func (s instrumentedStore) ListStream(ctx context.Context, q itemQuery) iter.Seq2[Item, error] {
+	return func(yield func(Item, error) bool) {
+		ctx, done := startStoreOp(ctx, "list")
+		var err error
+		defer func() { done("", err) }()
+		for item, itemErr := range s.Store.ListStream(ctx, q) {
+			if err = itemErr; err != nil {
+				yield(Item{}, err)
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (s instrumentedStore) Update(ctx context.Context, id string, item Item) (Item, error) {
+	ctx, done := startStoreOp(ctx, "update")
+	updated, err := s.Store.Update(ctx, id, item)
+	done(id, err)
+	return updated, err
+}
+
+func (s instrumentedStore) Patch(ctx context.Context, id string, fn func(Item) (Item, error)) (Item, error) {
+	ctx, done := startStoreOp(ctx, "patch")
+	patched, err := s.Store.Patch(ctx, id, fn)
+	done(id, err)
+	return patched, err
+}
+
+func (s instrumentedStore) Delete(ctx context.Context, id string) error {
+	ctx, done := startStoreOp(ctx, "delete")
+	err := s.Store.Delete(ctx, id)
+	done(id, err)
+	return err
+}
+
+func (s instrumentedStore) Restore(ctx context.Context, id string) (Item, bool, error) {
+	ctx, done := startStoreOp(ctx, "restore")
+	item, restored, err := s.Store.Restore(ctx, id)
+	done(id, err)
+	return item, restored, err
+}
+
+func (s instrumentedStore) Purge(ctx context.Context, id string) error {
+	ctx, done := startStoreOp(ctx, "purge")
+	err := s.Store.Purge(ctx, id)
+	done(id, err)
+	return err
+}
+
+func (s instrumentedStore) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	ctx, done := startStoreOp(ctx, "purge_deleted")
+	n, err := s.Store.PurgeDeleted(ctx, cutoff)
+	done("", err)
+	return n, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+const tenantHeader = "X-Tenant-ID"
+
+// validTenantName is the syntax of tenant names, in TENANTS and in
+// X-Tenant-ID, so they are safe in logs, keys, and labels.
+var validTenantName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,62}$`)
+
+// otherTenantLabel is the metrics label of tenants admitted only by
+// TENANT_PATTERN, which could otherwise have any number of values.
+const otherTenantLabel = "other"
+
+type tenantKey struct{}
+
+// withTenant scopes ctx, and every store call made with it, to tenant. The
+// default tenant is "", so items stored before tenants existed belong to it.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFrom returns the tenant ctx is scoped to: "" for the default
+// tenant, and for background work outside any request.
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenants decides which X-Tenant-ID values are served: the default tenant,
+// TENANTS, and names matching TENANT_PATTERN.
+type tenants struct {
+	required    bool
+	defaultName string
+	listed      []string
+	pattern     *regexp.Regexp
+}
+
+func newTenants(cfg Config) *tenants {
+	t := &tenants{required: cfg.MultiTenant, defaultName: cfg.DefaultTenant, listed: cfg.Tenants}
+	if cfg.TenantPattern != "" {
+		// Validate has compiled it already.
+		t.pattern = regexp.MustCompile(`^(?:` + cfg.TenantPattern + `)$`)
+	}
+	return t
+}
+
+// allowed reports whether name is a tenant this server serves.
+func (t *tenants) allowed(name string) bool {
+	if !validTenantName.MatchString(name) {
+		return false
+	}
+	return name == t.defaultName || slices.Contains(t.listed, name) || (t.pattern != nil && t.pattern.MatchString(name))
+}
+
+// label is name as a metrics label.
+func (t *tenants) label(name string) string {
+	if name == t.defaultName || slices.Contains(t.listed, name) {
+		return name
+	}
+	return otherTenantLabel
+}
+
+// Middleware resolves the request's tenant from X-Tenant-ID and scopes
+// the request context to it. Without the header the request belongs to
+// the default tenant, unless MULTI_TENANT requires one (400). A tenant
+// that isn't served gets 403. Requests already scoped, such as batch
+// operations, keep their tenant.
+func (t *tenants) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(tenantKey{}).(string); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Responses are the tenant's, so a cache must not serve them to
+		// another.
+		w.Header().Add("Vary", tenantHeader)
+		name := r.Header.Get(tenantHeader)
+		switch {
+		case name == "" && t.required:
+			writeError(w, r, http.StatusBadRequest, codeTenantRequired, "Tenant required", tenantHeader+" is required")
+			return
+		case name == "":
+			name = t.defaultName
+		case !t.allowed(name):
+			writeError(w, r, http.StatusForbidden, codeUnknownTenant, "Unknown tenant", "")
+			return
+		}
+		if info, ok := r.Context().Value(logInfoKey{}).(*requestLogInfo); ok {
+			info.Tenant = name
+		}
+		tenant := name
+		if name == t.defaultName {
+			tenant = ""
+		}
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(withTenant(r.Context(), tenant)))
+		tenantRequestsTotal.WithLabelValues(t.label(name), strconv.Itoa(rec.status)).Inc()
+	})
+}
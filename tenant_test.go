@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// asTenant sends a request to h as tenant, or with no X-Tenant-ID for "".
+func asTenant(t *testing.T, h http.Handler, tenant, method, target, body string) *http.Response {
+	t.Helper()
+	r := newRequest(t, method, target, body)
+	if tenant != "" {
+		r.Header.Set(tenantHeader, tenant)
+	}
+	if method == "PATCH" {
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+	}
+	return serve(h, r).Result()
+}
+
+// tenantItemNames lists the names of the items tenant sees.
+func tenantItemNames(t *testing.T, h http.Handler, tenant string) []string {
+	t.Helper()
+	resp := asTenant(t, h, tenant, "GET", "/api/v1/items", "")
+	var list ItemList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(list.Items))
+	for i, item := range list.Items {
+		names[i] = item.Name
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestTenantIsolation(t *testing.T) {
+	var logs bytes.Buffer
+	_, h := newLoggedTestServer(t, slog.New(slog.NewJSONHandler(&logs, nil)), func(cfg *Config) {
+		cfg.Tenants = []string{"red", "blue"}
+		cfg.TenantPattern = "team-[0-9]+"
+	})
+	resp := asTenant(t, h, "red", "POST", "/api/v1/items", `{"name": "red widget"}`)
+	var red Item
+	if err := json.NewDecoder(resp.Body).Decode(&red); err != nil || red.Tenant != "red" {
+		t.Fatalf("created %+v, %v, want an item owned by red", red, err)
+	}
+
+	// Every item operation by another tenant misses red's item.
+	for _, tenant := range []string{"blue", "", "default", "team-7"} {
+		for _, op := range []struct{ method, target, body string }{
+			{"GET", "/api/v1/items/" + red.ID, ""},
+			{"PUT", "/api/v1/items/" + red.ID, `{"name": "stolen"}`},
+			{"PATCH", "/api/v1/items/" + red.ID, `{"name": "stolen"}`},
+			{"DELETE", "/api/v1/items/" + red.ID, ""},
+			{"DELETE", "/api/v1/items/" + red.ID + "?hard=true", ""},
+		} {
+			if resp := asTenant(t, h, tenant, op.method, op.target, op.body); resp.StatusCode != http.StatusNotFound {
+				t.Errorf("%s %s as %q = %d, want 404", op.method, op.target, tenant, resp.StatusCode)
+			}
+		}
+		if names := tenantItemNames(t, h, tenant); len(names) != 0 {
+			t.Errorf("%q lists %q, want none of red's items", tenant, names)
+		}
+	}
+	if names := tenantItemNames(t, h, "red"); !slices.Equal(names, []string{"red widget"}) {
+		t.Errorf("red lists %q, want its item untouched", names)
+	}
+
+	for _, tenant := range []string{"green", "team-x", "../red", "red blue"} {
+		if resp := asTenant(t, h, tenant, "GET", "/api/v1/items", ""); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET as %q = %d, want 403", tenant, resp.StatusCode)
+		}
+	}
+
+	other := testutil.ToFloat64(tenantRequestsTotal.WithLabelValues(otherTenantLabel, "200"))
+	asTenant(t, h, "team-42", "GET", "/api/v1/items", "")
+	if got := testutil.ToFloat64(tenantRequestsTotal.WithLabelValues(otherTenantLabel, "200")) - other; got != 1 {
+		t.Errorf("tenant_requests_total{tenant=%q} rose by %v, want pattern tenants counted together", otherTenantLabel, got)
+	}
+	if testutil.ToFloat64(tenantRequestsTotal.WithLabelValues("team-42", "200")) != 0 {
+		t.Error("pattern tenant got its own metrics label")
+	}
+	var logged bool
+	for _, line := range logLines(t, &logs, "request") {
+		logged = logged || line["tenant"] == "team-42"
+	}
+	if !logged {
+		t.Error("no access log line names tenant team-42")
+	}
+}
+
+func TestTenantRequired(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) {
+		cfg.MultiTenant = true
+		cfg.Tenants = []string{"red"}
+	})
+	if resp := asTenant(t, h, "", "GET", "/api/v1/items", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET without a tenant = %d, want 400 with MULTI_TENANT", resp.StatusCode)
+	}
+	if resp := asTenant(t, h, "red", "GET", "/api/v1/items", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET as red = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestTenantIsolationPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.json")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	withTenants := defaultConfig()
+	withTenants.Tenants = []string{"red", "blue"}
+
+	_, h := startTestServer(t, withTenants, logger, openFileStore(path, logger))
+	asTenant(t, h, "red", "POST", "/api/v1/items", `{"name": "shared name"}`)
+	asTenant(t, h, "red", "POST", "/api/v1/items", `{"name": "red only"}`)
+	asTenant(t, h, "blue", "POST", "/api/v1/items", `{"name": "shared name"}`)
+
+	// A restarted server reads each item back into its own tenant.
+	_, h = startTestServer(t, withTenants, logger, openFileStore(path, logger))
+	for tenant, want := range map[string][]string{
+		"red":  {"red only", "shared name"},
+		"blue": {"shared name"},
+		"":     {},
+	} {
+		if names := tenantItemNames(t, h, tenant); !slices.Equal(names, want) {
+			t.Errorf("%q lists %q after a reload, want %q", tenant, names, want)
+		}
+	}
+}
+
+func TestItemListETagIsPerTenant(t *testing.T) {
+	_, handler := newTestServer(t, func(cfg *Config) {
+		cfg.Tenants = []string{"red", "blue"}
+	})
+	do := func(method, target, tenant, body, ifNoneMatch string) *http.Response {
+		t.Helper()
+		r := newRequest(t, method, target, body)
+		r.Header.Set(tenantHeader, tenant)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(handler, r).Result()
+	}
+
+	do("POST", "/api/v1/items", "red", `{"name": "red widget"}`, "")
+	red := do("GET", "/api/v1/items", "red", "", "")
+	etag := red.Header.Get("ETag")
+	if red.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("GET /api/v1/items as red = %d with ETag %q, want 200 with an ETag", red.StatusCode, etag)
+	}
+	if vary := red.Header.Values("Vary"); !slices.Contains(vary, tenantHeader) {
+		t.Errorf("Vary = %q, want it to name %s", vary, tenantHeader)
+	}
+
+	// Blue presenting red's ETag must get blue's listing, not a 304.
+	if blue := do("GET", "/api/v1/items", "blue", "", etag); blue.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/v1/items as blue with red's ETag = %d, want 200", blue.StatusCode)
+	}
+	if again := do("GET", "/api/v1/items", "red", "", etag); again.StatusCode != http.StatusNotModified {
+		t.Errorf("GET /api/v1/items as red with its ETag = %d, want 304", again.StatusCode)
+	}
+
+	// A write by blue leaves red's cached listing valid.
+	do("POST", "/api/v1/items", "blue", `{"name": "blue widget"}`, "")
+	if again := do("GET", "/api/v1/items", "red", "", etag); again.StatusCode != http.StatusNotModified {
+		t.Errorf("GET /api/v1/items as red after blue's write = %d, want 304", again.StatusCode)
+	}
+	do("POST", "/api/v1/items", "red", `{"name": "another"}`, "")
+	if again := do("GET", "/api/v1/items", "red", "", etag); again.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/v1/items as red after its own write = %d, want 200", again.StatusCode)
+	}
+}
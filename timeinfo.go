@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// Zone names resolve without /usr/share/zoneinfo, so ?tz= works in
+	// scratch and distroless images.
+	_ "time/tzdata"
+)
+
+// timeFormats are the ?format= names GET /time accepts. Raw layouts aren't
+// taken so clients can't make us print arbitrary strings.
+var timeFormats = map[string]func(time.Time) string{
+	"rfc3339":    func(t time.Time) string { return t.Format(time.RFC3339Nano) },
+	"rfc1123":    func(t time.Time) string { return t.Format(time.RFC1123Z) },
+	"kitchen":    func(t time.Time) string { return t.Format(time.Kitchen) },
+	"datetime":   func(t time.Time) string { return t.Format(time.DateTime) },
+	"unix":       func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) },
+	"unix_milli": func(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) },
+}
+
+// TimeResponse is GET /time: the server's clock several ways, for spotting
+// skew between pods and nodes.
+type TimeResponse struct {
+	RFC3339    string  `json:"rfc3339"`
+	Unix       int64   `json:"unix"`
+	UnixMilli  int64   `json:"unix_milli"`
+	Human      string  `json:"human"`
+	Timezone   string  `json:"timezone"`
+	UTCOffset  string  `json:"utc_offset"`
+	Format     string  `json:"format,omitempty"`
+	Formatted  string  `json:"formatted,omitempty"`
+	Uptime     string  `json:"uptime"`
+	UptimeSecs float64 `json:"uptime_seconds"`
+	Hostname   string  `json:"hostname"`
+	Node       string  `json:"node,omitempty"`
+}
+
+// timeHandler serves GET /time. ?tz= takes an IANA zone name (default UTC)
+// and ?format= one of timeFormats. Uptime is measured on the monotonic
+// clock, so it's unaffected by the wall clock being stepped.
+func (s *Server) timeHandler(w http.ResponseWriter, r *http.Request) {
+	now := s.clock.Now()
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		// LoadLocation refuses paths, so this can't read arbitrary files.
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Unknown time zone",
+				fmt.Sprintf("%q is not an IANA time zone name such as UTC, Europe/Berlin, or America/New_York", tz))
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	formatter, ok := timeFormats[format]
+	if format != "" && !ok {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "Unknown format",
+			"format must be one of "+strings.Join(timeFormatNames(), ", "))
+		return
+	}
+
+	local := now.In(loc)
+	uptime := now.Sub(s.started)
+	response := TimeResponse{
+		RFC3339:    local.Format(time.RFC3339Nano),
+		Unix:       now.Unix(),
+		UnixMilli:  now.UnixMilli(),
+		Human:      local.Format("Monday, 2 January 2006 15:04:05 MST"),
+		Timezone:   loc.String(),
+		UTCOffset:  local.Format("-07:00"),
+		Uptime:     uptime.Round(time.Second).String(),
+		UptimeSecs: uptime.Seconds(),
+		Hostname:   s.instance.Hostname,
+		Node:       s.instance.Node,
+	}
+	if ok {
+		response.Format, response.Formatted = format, formatter(local)
+	}
+	writeResponse(w, r, http.StatusOK, response)
+}
+
+// timeFormatNames returns the keys of timeFormats, sorted.
+func timeFormatNames() []string {
+	names := make([]string, 0, len(timeFormats))
+	for name := range timeFormats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
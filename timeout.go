@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// timeoutWriter lets a handler and an expiring deadline race for the
+// response. Whichever writes first wins: once the handler has started a
+// response the deadline only cancels its context, and once the deadline has
+// answered with a 504 the handler's writes are discarded.
+//
+// The handler gets its own header map, copied to the real one when it
+// commits, so a handler still running after the 504 never touches headers
+// the server owns.
+type timeoutWriter struct {
+	w   http.ResponseWriter
+	h   http.Header
+	ctx context.Context
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(status)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	dst := tw.w.Header()
+	clear(dst)
+	maps.Copy(dst, tw.h)
+	// Informational responses precede the real one and don't commit it.
+	if status >= 200 || status == http.StatusSwitchingProtocols {
+		tw.wroteHeader = true
+	}
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+// FlushError commits the response and flushes it. http.ResponseController
+// prefers it to Unwrap, so flushes stay under the lock.
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return http.NewResponseController(tw.w).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// extend write deadlines.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// answeredTimeout reports whether the client gets the 504 rather than the
+// handler's response, deciding it if the deadline has passed with nothing
+// written. Both the handler, once it returns, and withTimeout may ask first;
+// they get the same answer.
+func (tw *timeoutWriter) answeredTimeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut && !tw.wroteHeader && errors.Is(context.Cause(tw.ctx), context.DeadlineExceeded) {
+		tw.timedOut = true
+	}
+	return tw.timedOut
+}
+
+// withTimeout runs next under a context that expires after timeout and
+// answers 504 if it has not started a response by then. Handlers should pass
+// r.Context() to store and outbound calls so they stop early too. A timeout
+// of zero or less disables the deadline.
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, h: w.Header().Clone(), ctx: ctx}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					// Relay the panic to this goroutine for recoverPanics,
+					// keeping the handler's stack.
+					if p != http.ErrAbortHandler {
+						p = fmt.Sprintf("%v\n\n%s", p, debug.Stack())
+					}
+					panicked <- p
+					return
+				}
+				close(done)
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+		}()
+
+		finished := false
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			finished = true
+		case <-ctx.Done():
+		}
+
+		if !tw.answeredTimeout() {
+			if !finished {
+				// The response is under way, or the client went away; let
+				// the handler finish either way.
+				select {
+				case p := <-panicked:
+					panic(p)
+				case <-done:
+				}
+			}
+			// A handler that returns without writing answers 200, with
+			// whatever headers it set, unless the client has gone and the
+			// request should be logged as abandoned.
+			if r.Context().Err() == nil {
+				tw.WriteHeader(http.StatusOK)
+			}
+			return
+		}
+		loggerFrom(r.Context()).Warn("request timed out", slog.Duration("timeout", timeout))
+		writeError(w, r, http.StatusGatewayTimeout, codeRequestTimeout, "Request timed out",
+			fmt.Sprintf("no response within %s", timeout))
+	})
+}
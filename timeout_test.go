@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// quietRequest is a GET whose request-scoped logger discards the timeout
+// warnings.
+func quietRequest(t *testing.T) *http.Request {
+	t.Helper()
+	r := newRequest(t, "GET", "/", "")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return r.WithContext(contextWithLogger(r.Context(), logger))
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantBody   string
+		wantHeader string
+	}{
+		{
+			name: "fast handler",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Handler", "yes")
+				w.Write([]byte("ok"))
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "ok",
+			wantHeader: "yes",
+		},
+		{
+			name: "headers only",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Handler", "yes")
+			},
+			wantStatus: http.StatusOK,
+			wantHeader: "yes",
+		},
+		{
+			name: "started before the deadline",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				<-r.Context().Done()
+				w.Write([]byte("done"))
+			},
+			wantStatus: http.StatusAccepted,
+			wantBody:   "done",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(withTimeout(tt.handler, 20*time.Millisecond), quietRequest(t))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("X-Handler"); got != tt.wantHeader {
+				t.Errorf("X-Handler = %q, want %q", got, tt.wantHeader)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// A handler still running after the 504 has its writes and headers
+// discarded.
+func TestTimeoutDiscardsLateWrites(t *testing.T) {
+	release := make(chan struct{})
+	late := make(chan error, 1)
+	h := withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("X-Handler", "yes")
+		_, err := w.Write([]byte("late"))
+		late <- err
+	}), 10*time.Millisecond)
+
+	rec := serve(h, quietRequest(t))
+	close(release)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", rec.Code)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != codeRequestTimeout {
+		t.Errorf("body = %s, want a request_timeout error", rec.Body)
+	}
+	if err := <-late; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("write after the 504 = %v, want ErrHandlerTimeout", err)
+	}
+	if rec.Header().Get("X-Handler") != "" {
+		t.Error("header set after the 504 reached the response")
+	}
+}
+
+// Handlers racing the deadline must never produce a second WriteHeader or
+// mix their output into the 504.
+func TestTimeoutRace(t *testing.T) {
+	h := withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}), time.Millisecond)
+	for range 200 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, quietRequest(t))
+		switch rec.Code {
+		case http.StatusOK:
+			if rec.Body.String() != "ok" {
+				t.Fatalf("200 with body %q", rec.Body)
+			}
+		case http.StatusGatewayTimeout:
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("504 with body %q", rec.Body)
+			}
+		default:
+			t.Fatalf("status = %d", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const serverTimingHeader = "Server-Timing"
+
+// timingSegment is a part of the request reported in Server-Timing.
+type timingSegment int
+
+const (
+	timingAuth timingSegment = iota
+	timingStore
+	timingEncode
+	timingSegments
+)
+
+var timingNames = [timingSegments]string{"auth", "store", "encode"}
+
+// serverTiming accumulates the time spent in each segment of one request.
+// Segments are summed atomically since store calls may overlap.
+type serverTiming struct {
+	start    time.Time
+	segments [timingSegments]atomic.Int64
+	// authStart is set by authenticate; checks run one after another.
+	authStart time.Time
+}
+
+type serverTimingKey struct{}
+
+func serverTimingFrom(ctx context.Context) *serverTiming {
+	st, _ := ctx.Value(serverTimingKey{}).(*serverTiming)
+	return st
+}
+
+// addTiming adds d to segment for the request behind ctx. It is a no-op for
+// requests without a collector, such as background jobs.
+func addTiming(ctx context.Context, segment timingSegment, d time.Duration) {
+	if st := serverTimingFrom(ctx); st != nil {
+		st.segments[segment].Add(int64(d))
+	}
+}
+
+// header formats the collected segments, e.g.
+// "total;dur=1.52, store;dur=0.31". Segments nobody touched are left out.
+func (st *serverTiming) header() string {
+	buf := make([]byte, 0, 96)
+	buf = appendTiming(buf, "total", time.Since(st.start))
+	for i := range st.segments {
+		if d := st.segments[i].Load(); d > 0 {
+			buf = append(buf, ", "...)
+			buf = appendTiming(buf, timingNames[i], time.Duration(d))
+		}
+	}
+	return string(buf)
+}
+
+func appendTiming(buf []byte, name string, d time.Duration) []byte {
+	buf = append(buf, name...)
+	buf = append(buf, ";dur="...)
+	return strconv.AppendFloat(buf, float64(d.Microseconds())/1000, 'f', -1, 64)
+}
+
+// timingWriter adds Server-Timing as the response is committed. The
+// collector lives in the writer so each request costs one allocation for
+// both.
+type timingWriter struct {
+	http.ResponseWriter
+	timing      serverTiming
+	wroteHeader bool
+}
+
+func (tw *timingWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.ResponseWriter.Header().Set(serverTimingHeader, tw.timing.header())
+		// Informational responses precede the real one, which gets the
+		// final timings.
+		tw.wroteHeader = status >= 200 || status == http.StatusSwitchingProtocols
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// FlushError commits the header before flushing so streamed responses get
+// it too.
+func (tw *timingWriter) FlushError() error {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// withServerTiming puts a timing collector on the request context and
+// reports it in a Server-Timing header on the response.
+func withServerTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &timingWriter{ResponseWriter: w}
+		tw.timing.start = time.Now()
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), serverTimingKey{}, &tw.timing)))
+	})
+}
+
+// timeAuth wraps the authentication checks in front of h so the time before
+// h runs is reported as the auth segment.
+func timeAuth(checks func(http.HandlerFunc) http.HandlerFunc, h http.HandlerFunc) http.HandlerFunc {
+	checked := checks(func(w http.ResponseWriter, r *http.Request) {
+		if st := serverTimingFrom(r.Context()); st != nil && !st.authStart.IsZero() {
+			st.segments[timingAuth].Add(int64(time.Since(st.authStart)))
+		}
+		h(w, r)
+	})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if st := serverTimingFrom(r.Context()); st != nil {
+			st.authStart = time.Now()
+		}
+		checked(w, r)
+	}
+}
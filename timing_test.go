@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestServerTiming(t *testing.T) {
+	_, h := newTestServer(t)
+	createItem(t, h, `{"name": "timed"}`)
+	rec := serve(h, newRequest(t, "GET", "/api/v1/items", ""))
+	header := rec.Header().Get(serverTimingHeader)
+	segment := regexp.MustCompile(`^[a-z]+;dur=\d+(\.\d+)?$`)
+	names := map[string]bool{}
+	for _, part := range strings.Split(header, ", ") {
+		if !segment.MatchString(part) {
+			t.Errorf("malformed segment %q in %q", part, header)
+		}
+		name, _, _ := strings.Cut(part, ";")
+		names[name] = true
+	}
+	for _, want := range []string{"total", "store", "encode"} {
+		if !names[want] {
+			t.Errorf("Server-Timing = %q, want a %s segment", header, want)
+		}
+	}
+}
+
+// A handler that never touches the collector still gets the total.
+func TestServerTimingUntouched(t *testing.T) {
+	h := withServerTiming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	rec := serve(h, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get(serverTimingHeader); !strings.HasPrefix(got, "total;dur=") || strings.Contains(got, ",") {
+		t.Errorf("Server-Timing = %q, want only total", got)
+	}
+	// Outside a request, adding time is a no-op rather than a panic.
+	addTiming(context.Background(), timingStore, 1)
+}
+
+// BenchmarkServerTiming measures the collector's overhead on a trivial
+// handler that records a store and an encode segment.
+func BenchmarkServerTiming(b *testing.B) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addTiming(r.Context(), timingStore, 1000)
+		addTiming(r.Context(), timingEncode, 1000)
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, bench := range []struct {
+		name string
+		h    http.Handler
+	}{
+		{"without", handler},
+		{"with", withServerTiming(handler)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			r := httptest.NewRequest("GET", "/", nil)
+			w := &discardWriter{header: make(http.Header)}
+			b.ReportAllocs()
+			for range b.N {
+				clear(w.header)
+				bench.h.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// discardWriter is a ResponseWriter that keeps only its header map, so
+// benchmarks measure the middleware rather than a recorder.
+type discardWriter struct{ header http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxTransformOps caps the pipeline length. base64 grows its input by a
+// third, so long pipelines would grow without bound.
+const maxTransformOps = 16
+
+// transformOps are the operations POST /api/v1/transform applies, by name.
+var transformOps = map[string]func(string) string{
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"reverse": reverseString,
+	"sha256": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"rot13":  rot13,
+}
+
+// transformOpNames returns the keys of transformOps, sorted.
+func transformOpNames() []string {
+	names := make([]string, 0, len(transformOps))
+	for name := range transformOps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func reverseString(s string) string {
+	runes := []rune(s)
+	slices.Reverse(runes)
+	return string(runes)
+}
+
+func rot13(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, s)
+}
+
+// TransformRequest is the body of POST /api/v1/transform.
+type TransformRequest struct {
+	Text string   `json:"text"`
+	Ops  []string `json:"ops"`
+	// Repeat runs the pipeline this many times, to generate CPU load. Zero
+	// means once.
+	Repeat int `json:"repeat"`
+}
+
+// TransformStep is the text after one operation.
+type TransformStep struct {
+	Op     string `json:"op"`
+	Result string `json:"result"`
+}
+
+// TransformResponse reports each step of the pipeline and how much work the
+// repeats took, for sizing load tests.
+type TransformResponse struct {
+	Steps          []TransformStep `json:"steps"`
+	Result         string          `json:"result"`
+	Repeat         int             `json:"repeat"`
+	BytesProcessed int64           `json:"bytes_processed"`
+	DurationMS     float64         `json:"duration_ms"`
+}
+
+// runTransform applies ops to text in order, returning each step.
+func runTransform(text string, ops []string) []TransformStep {
+	steps := make([]TransformStep, len(ops))
+	for i, op := range ops {
+		text = transformOps[op](text)
+		steps[i] = TransformStep{Op: op, Result: text}
+	}
+	return steps
+}
+
+// transformWork is how many bytes one run of ops over n bytes reads, from
+// how each operation resizes its input. Case changes can resize non-ASCII
+// text slightly; that is close enough for a budget.
+func transformWork(n int64, ops []string) int64 {
+	var work int64
+	for _, op := range ops {
+		work += n
+		switch op {
+		case "base64":
+			n = int64(base64.StdEncoding.EncodedLen(int(n)))
+		case "sha256":
+			n = 2 * sha256.Size
+		}
+	}
+	return work
+}
+
+// transformHandler serves POST /api/v1/transform. Requests that would
+// process more than TRANSFORM_MAX_BYTES in total are refused before any
+// work, and repeats stop when the request is cancelled or times out.
+func (s *Server) transformHandler(w http.ResponseWriter, r *http.Request) {
+	var req TransformRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	supported := "supported operations are " + strings.Join(transformOpNames(), ", ")
+	switch {
+	case len(req.Ops) == 0:
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "No operations", "ops must list at least one operation; "+supported)
+		return
+	case len(req.Ops) > maxTransformOps:
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Too many operations", fmt.Sprintf("ops may list at most %d operations", maxTransformOps))
+		return
+	case req.Repeat < 0 || req.Repeat > s.cfg.TransformMaxRepeat:
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Invalid repeat", fmt.Sprintf("repeat must be 0 to %d", s.cfg.TransformMaxRepeat))
+		return
+	}
+	var unknown []FieldError
+	for i, op := range req.Ops {
+		if _, ok := transformOps[op]; !ok {
+			unknown = append(unknown, FieldError{Field: fmt.Sprintf("ops[%d]", i), Constraint: "enum", Message: "is not a supported operation", Value: op})
+		}
+	}
+	if len(unknown) > 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Unsupported operation", supported, unknown...)
+		return
+	}
+	repeat := max(req.Repeat, 1)
+	perRun := transformWork(int64(len(req.Text)), req.Ops)
+	if perRun*int64(repeat) > s.cfg.TransformMaxBytes {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Too much work",
+			fmt.Sprintf("%d runs of %d bytes exceed TRANSFORM_MAX_BYTES (%d)", repeat, perRun, s.cfg.TransformMaxBytes))
+		return
+	}
+
+	start := time.Now()
+	var steps []TransformStep
+	for range repeat {
+		// A timed-out request has already been answered.
+		if r.Context().Err() != nil {
+			return
+		}
+		steps = runTransform(req.Text, req.Ops)
+	}
+	writeResponse(w, r, http.StatusOK, TransformResponse{
+		Steps:          steps,
+		Result:         steps[len(steps)-1].Result,
+		Repeat:         repeat,
+		BytesProcessed: perRun * int64(repeat),
+		DurationMS:     float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.TransformMaxBytes = 1 << 10 })
+	rec := serve(h, newRequest(t, "POST", "/api/v1/transform", `{"text": "Hello", "ops": ["upper", "reverse", "rot13", "base64"], "repeat": 3}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/v1/transform = %d: %s", rec.Code, rec.Body)
+	}
+	var resp TransformResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"HELLO", "OLLEH", "BYYRU", "QllZUlU="}
+	if len(resp.Steps) != len(want) {
+		t.Fatalf("steps = %+v, want %d", resp.Steps, len(want))
+	}
+	for i, step := range resp.Steps {
+		if step.Result != want[i] {
+			t.Errorf("step %d (%s) = %q, want %q", i, step.Op, step.Result, want[i])
+		}
+	}
+	if resp.Result != want[len(want)-1] || resp.Repeat != 3 || resp.BytesProcessed != 3*20 {
+		t.Errorf("result = %q, repeat = %d, bytes = %d; want %q, 3, 60", resp.Result, resp.Repeat, resp.BytesProcessed, want[len(want)-1])
+	}
+
+	for _, tc := range []struct{ name, body, want string }{
+		{"unknown op", `{"text": "x", "ops": ["upper", "explode"]}`, "supported operations are base64, lower, reverse, rot13, sha256, upper"},
+		{"no ops", `{"text": "x", "ops": []}`, "ops must list at least one operation"},
+		{"repeat too high", `{"text": "x", "ops": ["upper"], "repeat": 10001}`, "repeat must be 0 to 10000"},
+		{"too much work", fmt.Sprintf(`{"text": %q, "ops": ["upper"], "repeat": 2}`, strings.Repeat("x", 600)), "exceed TRANSFORM_MAX_BYTES"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(h, newRequest(t, "POST", "/api/v1/transform", tc.body))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tc.want) {
+				t.Errorf("POST /api/v1/transform = %d %s, want 400 mentioning %q", rec.Code, rec.Body, tc.want)
+			}
+		})
+	}
+}
+
+// BenchmarkTransform reports what one request costs at a few sizes, for
+// sizing CPU load tests.
+func BenchmarkTransform(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		size   int
+		repeat int
+	}{
+		{"1KiB", 1 << 10, 1},
+		{"1KiBx100", 1 << 10, 100},
+		{"64KiB", 64 << 10, 1},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			_, h := newTestServer(b)
+			body := fmt.Sprintf(`{"text": %q, "ops": ["upper", "reverse", "rot13", "base64", "sha256"], "repeat": %d}`, strings.Repeat("a", bc.size), bc.repeat)
+			b.SetBytes(transformWork(int64(bc.size), []string{"upper", "reverse", "rot13", "base64", "sha256"}) * int64(bc.repeat))
+			b.ResetTimer()
+			for range b.N {
+				rec := serve(h, newRequest(b, "POST", "/api/v1/transform", body))
+				if rec.Code != http.StatusOK {
+					b.Fatalf("POST /api/v1/transform = %d: %s", rec.Code, rec.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// uiRefreshSeconds is how often the /ui page reloads itself.
+const uiRefreshSeconds = 5
+
+// uiPage is the data rendered by templates/ui.html. Everything in it comes
+// from the structures behind the JSON endpoints: the health responses,
+// the fault injector, and the /stats request counters.
+type uiPage struct {
+	Build          BuildInfo
+	Instance       InstanceInfo
+	Liveness       HealthResponse
+	LivenessCode   int
+	Readiness      HealthResponse
+	ReadinessCode  int
+	Faults         []Fault
+	Requests       []RouteStats
+	Now            time.Time
+	RefreshSeconds int
+}
+
+// parseUITemplate parses the embedded dashboard template; NewServer calls it
+// so a broken template stops startup instead of failing requests.
+func parseUITemplate() (*template.Template, error) {
+	return template.ParseFS(templateFS, "templates/ui.html")
+}
+
+// uiHandler renders the status dashboard at /ui.
+func (s *Server) uiHandler(w http.ResponseWriter, r *http.Request) {
+	page := uiPage{
+		Build:          s.build,
+		Instance:       s.instance,
+		Faults:         s.faults.List(),
+		Now:            s.clock.Now(),
+		RefreshSeconds: uiRefreshSeconds,
+	}
+	page.LivenessCode, page.Liveness = s.liveness()
+	page.ReadinessCode, page.Readiness = s.readiness(r.Context())
+	page.Requests = httpStats.Routes()
+
+	// Render fully first so a template error still gets a clean 500.
+	var buf bytes.Buffer
+	if err := s.ui.Execute(&buf, page); err != nil {
+		loggerFrom(r.Context()).Error("rendering /ui failed", slog.Any("error", err))
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "internal server error", "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
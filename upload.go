@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UploadedFile describes one file stored by POST /upload.
+type UploadedFile struct {
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type"`
+	StoredPath  string `json:"stored_path"`
+}
+
+// maxFilenameLength keeps sanitized names within common filesystem limits,
+// leaving room for a collision suffix.
+const maxFilenameLength = 200
+
+// sanitizeFilename reduces a client-supplied name to a plain file name: any
+// directory part is dropped, characters outside [A-Za-z0-9._-] become "_",
+// and leading dots are removed so the result is never "." or ".." or a
+// hidden file. It returns "" when nothing usable is left.
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	cleaned = strings.TrimLeft(cleaned, ".")
+	if len(cleaned) > maxFilenameLength {
+		ext := filepath.Ext(cleaned)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		cleaned = cleaned[:maxFilenameLength-len(ext)] + ext
+	}
+	return cleaned
+}
+
+// uploadAllowed reports whether a file with the given name and content type
+// (sniffed from its first bytes, not taken from the client) matches
+// UPLOAD_ALLOWED_TYPES. Entries starting with "." are
+// extensions; others are MIME types, optionally "type/*". Either kind of
+// match admits the file.
+func uploadAllowed(name, contentType string, allowed []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		switch {
+		case strings.HasPrefix(entry, "."):
+			if ext == entry {
+				return true
+			}
+		case strings.HasSuffix(entry, "/*"):
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(entry, "*")) {
+				return true
+			}
+		case mediaType == entry:
+			return true
+		}
+	}
+	return false
+}
+
+// createUnique opens a new file for name in dir, appending -1, -2, ... before
+// the extension until the name is free. O_EXCL makes the claim atomic, so
+// concurrent uploads of the same name never overwrite each other.
+func createUnique(dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			return f, candidate, nil
+		}
+		if !errors.Is(err, fs.ErrExist) || i > 1000 {
+			return nil, "", err
+		}
+		candidate = base + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+// uploadError is a rejected upload part, reported with its status and code.
+type uploadError struct {
+	status int
+	code   string
+	msg    string
+	detail string
+}
+
+func (e *uploadError) Error() string { return e.msg + ": " + e.detail }
+
+// storeUpload writes one multipart file part under dir, enforcing the
+// per-file limit and the type allowlist.
+func (s *Server) storeUpload(part io.Reader, filename string) (UploadedFile, error) {
+	name := sanitizeFilename(filename)
+	if name == "" {
+		return UploadedFile{}, &uploadError{http.StatusBadRequest, codeInvalidBody, "Invalid filename", fmt.Sprintf("%q has no usable characters", filename)}
+	}
+
+	br := bufio.NewReaderSize(part, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return UploadedFile{}, err
+	}
+	sniffed := http.DetectContentType(head)
+	if !uploadAllowed(name, sniffed, s.cfg.UploadAllowedTypes) {
+		return UploadedFile{}, &uploadError{http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "File type not allowed", fmt.Sprintf("%s (%s) does not match UPLOAD_ALLOWED_TYPES", name, sniffed)}
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = sniffed
+	}
+
+	f, stored, err := createUnique(s.cfg.UploadDir, name)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(br, s.cfg.UploadMaxFileBytes+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > s.cfg.UploadMaxFileBytes {
+		err = &uploadError{http.StatusRequestEntityTooLarge, codeBodyTooLarge, "File too large", fmt.Sprintf("%s exceeds %d bytes", name, s.cfg.UploadMaxFileBytes)}
+	}
+	storedPath := filepath.Join(s.cfg.UploadDir, stored)
+	if err != nil {
+		os.Remove(storedPath)
+		return UploadedFile{}, err
+	}
+	return UploadedFile{
+		Filename:    stored,
+		Size:        n,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		ContentType: contentType,
+		StoredPath:  storedPath,
+	}, nil
+}
+
+// uploadHandler handles POST /upload. Every file part is streamed to disk;
+// if any part is rejected, the files already written for the request are
+// removed so an upload either lands whole or not at all. The total size is
+// capped by limitBody before the handler runs.
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "Invalid multipart body", err.Error())
+		return
+	}
+
+	var files []UploadedFile
+	fail := func(status int, code, msg, detail string) {
+		for _, f := range files {
+			os.Remove(f.StoredPath)
+		}
+		writeError(w, r, status, code, msg, detail)
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				fail(http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Upload too large", fmt.Sprintf("uploads must not exceed %d bytes in total", tooLarge.Limit))
+				return
+			}
+			fail(http.StatusBadRequest, codeInvalidBody, "Invalid multipart body", err.Error())
+			return
+		}
+		if part.FileName() == "" {
+			// Plain form fields carry no file.
+			part.Close()
+			continue
+		}
+		file, err := s.storeUpload(part, part.FileName())
+		part.Close()
+		if err != nil {
+			var rejected *uploadError
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &rejected):
+				fail(rejected.status, rejected.code, rejected.msg, rejected.detail)
+			case errors.As(err, &tooLarge):
+				fail(http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Upload too large", fmt.Sprintf("uploads must not exceed %d bytes in total", tooLarge.Limit))
+			default:
+				loggerFrom(r.Context()).Error("storing upload failed", slog.Any("error", err))
+				fail(http.StatusInternalServerError, codeInternal, "internal server error", "")
+			}
+			return
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody, "No files uploaded", "send at least one multipart part with a filename")
+		return
+	}
+	for _, f := range files {
+		loggerFrom(r.Context()).Info("file uploaded", slog.String("file", f.Filename), slog.Int64("size", f.Size))
+	}
+	writeResponse(w, r, http.StatusCreated, files)
+}
+
+// downloadHandler handles GET /upload/{name}, streaming a stored file with a
+// Content-Type from its extension (or sniffed) and Range support.
+func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if sanitizeFilename(name) != name {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "File not found", "")
+		return
+	}
+	f, err := os.Open(filepath.Join(s.cfg.UploadDir, name))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "File not found", "")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "File not found", "")
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
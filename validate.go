@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Validator is implemented by request bodies that check their own fields.
+// Keeping the rules in Validate lets them be exercised without HTTP.
+type Validator interface {
+	Validate() []FieldError
+}
+
+// decodeValid decodes a JSON object body into dst, a pointer to a struct,
+// and validates it. Unknown fields, fields of the wrong type, and Validate
+// failures are all collected and reported together as a 422 listing each
+// one; malformed JSON is still a 400. It writes the error response itself and
+// returns false on failure.
+func decodeValid(w http.ResponseWriter, r *http.Request, dst Validator) bool {
+	var raw map[string]json.RawMessage
+	if err := decodeJSON(r, &raw); err != nil {
+		var decErr *decodeError
+		if errors.As(err, &decErr) && decErr.Code == codeInvalidBody {
+			// The only shape mismatch possible here is a non-object body.
+			writeError(w, r, decErr.Status, decErr.Code, decErr.Message, "body must be a JSON object")
+			return false
+		}
+		writeDecodeError(w, r, err)
+		return false
+	}
+
+	problems := decodeFields(raw, dst)
+	failed := make(map[string]bool, len(problems))
+	for _, p := range problems {
+		failed[p.Field] = true
+	}
+	// A field that didn't decode would also fail its rules; report it once.
+	for _, p := range dst.Validate() {
+		if !failed[p.Field] {
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) == 0 {
+		return true
+	}
+
+	noun := "fields"
+	if len(problems) == 1 {
+		noun = "field"
+	}
+	writeError(w, r, http.StatusUnprocessableEntity, codeValidationFailed, "Validation failed",
+		fmt.Sprintf("%d invalid %s", len(problems), noun), problems...)
+	return false
+}
+
+// decodeFields unmarshals each member of raw into the struct field of dst
+// with the matching json tag, reporting unknown members and type mismatches
+// in key order.
+func decodeFields(raw map[string]json.RawMessage, dst any) []FieldError {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	fields := make(map[string]int, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+
+	var problems []FieldError
+	for _, key := range slices.Sorted(maps.Keys(raw)) {
+		i, ok := fields[key]
+		if !ok {
+			problems = append(problems, FieldError{
+				Field:      key,
+				Constraint: "unknown_field",
+				Message:    "is not a recognized field",
+				Value:      rawValue(raw[key]),
+			})
+			continue
+		}
+		if err := json.Unmarshal(raw[key], v.Field(i).Addr().Interface()); err != nil {
+			problems = append(problems, FieldError{
+				Field:      key,
+				Constraint: "type",
+				Message:    "must be " + jsonTypeName(t.Field(i).Type),
+				Value:      rawValue(raw[key]),
+			})
+		}
+	}
+	return problems
+}
+
+// rawValue decodes msg generically for echoing back in a FieldError.
+func rawValue(msg json.RawMessage) any {
+	var v any
+	json.Unmarshal(msg, &v)
+	return v
+}
+
+// jsonTypeName describes t in JSON terms for error messages.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(jsonTypeName(t.Elem()), "a "), "an ") + "s"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	default:
+		return "an object"
+	}
+}
+
+// maxValidateTags caps ValidateRequest.Tags.
+const maxValidateTags = 10
+
+// ValidateRequest is the body of POST /api/v1/validate, a demonstration of
+// typed request validation.
+type ValidateRequest struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags"`
+}
+
+// Validate checks that name is set, count is not negative, and there are at
+// most maxValidateTags non-empty tags.
+func (req *ValidateRequest) Validate() []FieldError {
+	var problems []FieldError
+	if req.Name == "" {
+		problems = append(problems, FieldError{Field: "name", Constraint: "required", Message: "is required"})
+	}
+	if req.Count < 0 {
+		problems = append(problems, FieldError{Field: "count", Constraint: "minimum", Message: "must be at least 0", Value: req.Count})
+	}
+	if len(req.Tags) > maxValidateTags {
+		problems = append(problems, FieldError{
+			Field:      "tags",
+			Constraint: "max_items",
+			Message:    fmt.Sprintf("must have at most %d items", maxValidateTags),
+			Value:      req.Tags,
+		})
+	}
+	for i, tag := range req.Tags {
+		if tag == "" {
+			problems = append(problems, FieldError{Field: fmt.Sprintf("tags[%d]", i), Constraint: "required", Message: "must not be empty"})
+		}
+	}
+	return problems
+}
+
+// validateHandler handles POST /api/v1/validate, returning the accepted body
+// or every validation failure at once.
+func (s *Server) validateHandler(w http.ResponseWriter, r *http.Request) {
+	var req ValidateRequest
+	if !decodeValid(w, r, &req) {
+		return
+	}
+	writeResponse(w, r, http.StatusOK, map[string]any{"valid": true, "value": req})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequestRules(t *testing.T) {
+	elevenTags := strings.Split("a,b,c,d,e,f,g,h,i,j,k", ",")
+	tests := []struct {
+		name string
+		req  ValidateRequest
+		want []FieldError
+	}{
+		{"valid", ValidateRequest{Name: "n", Count: 3, Tags: []string{"x"}}, nil},
+		{"zero count and no tags", ValidateRequest{Name: "n"}, nil},
+		{"ten tags", ValidateRequest{Name: "n", Tags: elevenTags[:maxValidateTags]}, nil},
+		{"missing name", ValidateRequest{}, []FieldError{
+			{Field: "name", Constraint: "required", Message: "is required"},
+		}},
+		{"negative count", ValidateRequest{Name: "n", Count: -1}, []FieldError{
+			{Field: "count", Constraint: "minimum", Message: "must be at least 0", Value: -1},
+		}},
+		{"too many tags", ValidateRequest{Name: "n", Tags: elevenTags}, []FieldError{
+			{Field: "tags", Constraint: "max_items", Message: "must have at most 10 items", Value: elevenTags},
+		}},
+		{"empty tags", ValidateRequest{Name: "n", Tags: []string{"x", "", ""}}, []FieldError{
+			{Field: "tags[1]", Constraint: "required", Message: "must not be empty"},
+			{Field: "tags[2]", Constraint: "required", Message: "must not be empty"},
+		}},
+		{"every rule at once", ValidateRequest{Count: -5, Tags: append([]string{""}, elevenTags...)}, []FieldError{
+			{Field: "name", Constraint: "required", Message: "is required"},
+			{Field: "count", Constraint: "minimum", Message: "must be at least 0", Value: -5},
+			{Field: "tags", Constraint: "max_items", Message: "must have at most 10 items", Value: append([]string{""}, elevenTags...)},
+			{Field: "tags[0]", Constraint: "required", Message: "must not be empty"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.Validate(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestItemRequestRules(t *testing.T) {
+	if got := (&itemRequest{Name: "n"}).Validate(); got != nil {
+		t.Errorf("Validate() with a name = %+v, want nil", got)
+	}
+	want := []FieldError{{Field: "name", Constraint: "required", Message: "is required"}}
+	if got := (&itemRequest{Data: map[string]any{"k": 1}}).Validate(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() without a name = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	raw := func(body string) map[string]json.RawMessage {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(body), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	tests := []struct {
+		name string
+		body string
+		want []FieldError
+	}{
+		{"all known", `{"name": "n", "count": 2, "tags": ["a"]}`, nil},
+		{"unknown field", `{"name": "n", "colour": "red"}`, []FieldError{
+			{Field: "colour", Constraint: "unknown_field", Message: "is not a recognized field", Value: "red"},
+		}},
+		{"string for an integer", `{"count": "two"}`, []FieldError{
+			{Field: "count", Constraint: "type", Message: "must be an integer", Value: "two"},
+		}},
+		{"fraction for an integer", `{"count": 1.5}`, []FieldError{
+			{Field: "count", Constraint: "type", Message: "must be an integer", Value: 1.5},
+		}},
+		{"number for a string", `{"name": 7}`, []FieldError{
+			{Field: "name", Constraint: "type", Message: "must be a string", Value: 7.0},
+		}},
+		{"wrong element type", `{"tags": [1]}`, []FieldError{
+			{Field: "tags", Constraint: "type", Message: "must be an array of strings", Value: []any{1.0}},
+		}},
+		{"in key order", `{"tags": "a", "count": true, "b": 1, "a": null}`, []FieldError{
+			{Field: "a", Constraint: "unknown_field", Message: "is not a recognized field"},
+			{Field: "b", Constraint: "unknown_field", Message: "is not a recognized field", Value: 1.0},
+			{Field: "count", Constraint: "type", Message: "must be an integer", Value: true},
+			{Field: "tags", Constraint: "type", Message: "must be an array of strings", Value: "a"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req ValidateRequest
+			if got := decodeFields(raw(tt.body), &req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeFields(%s) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+
+	var req ValidateRequest
+	decodeFields(raw(`{"name": "n", "count": 2, "tags": ["a", "b"]}`), &req)
+	if want := (ValidateRequest{Name: "n", Count: 2, Tags: []string{"a", "b"}}); !reflect.DeepEqual(req, want) {
+		t.Errorf("decoded %+v, want %+v", req, want)
+	}
+}
+
+func TestJSONTypeName(t *testing.T) {
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{"", "a string"},
+		{true, "a boolean"},
+		{0, "an integer"},
+		{uint8(0), "an integer"},
+		{0.5, "a number"},
+		{[]int{}, "an array of integers"},
+		{[]float64{}, "an array of numbers"},
+		{[]map[string]any{}, "an array of objects"},
+		{new(int64), "an integer"},
+		{map[string]any{}, "an object"},
+		{struct{}{}, "an object"},
+	}
+	for _, tt := range tests {
+		if got := jsonTypeName(reflect.TypeOf(tt.v)); got != tt.want {
+			t.Errorf("jsonTypeName(%T) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeValid(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantDetail string
+		wantFields []string
+	}{
+		{"valid", `{"name": "n"}`, 0, "", "", nil},
+		{"one problem", `{}`, http.StatusUnprocessableEntity, codeValidationFailed, "1 invalid field", []string{"name"}},
+		// name fails to decode, so is left empty, but isn't reported again
+		// as required.
+		{"reported once", `{"name": 5, "count": -1, "x": 1}`, http.StatusUnprocessableEntity, codeValidationFailed, "3 invalid fields", []string{"name", "x", "count"}},
+		{"not an object", `[1]`, http.StatusUnprocessableEntity, codeInvalidBody, "body must be a JSON object", nil},
+		{"malformed", `{"name": `, http.StatusBadRequest, codeInvalidJSON, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			var req ValidateRequest
+			ok := decodeValid(rec, httptest.NewRequest("POST", "/api/v1/validate", strings.NewReader(tt.body)), &req)
+			if ok != (tt.wantStatus == 0) {
+				t.Fatalf("decodeValid = %v, response %d %s", ok, rec.Code, rec.Body)
+			}
+			if ok {
+				return
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != tt.wantStatus || body.Code != tt.wantCode {
+				t.Fatalf("response = %d %s, want %d %s", rec.Code, rec.Body, tt.wantStatus, tt.wantCode)
+			}
+			if tt.wantDetail != "" && body.Detail != tt.wantDetail {
+				t.Errorf("detail = %q, want %q", body.Detail, tt.wantDetail)
+			}
+			var fields []string
+			for _, d := range body.Details {
+				fields = append(fields, d.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("details for %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// warmupStep is one thing done at boot so the first real requests don't pay
+// for it.
+type warmupStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// WarmupResult is how one warm-up step went.
+type WarmupResult struct {
+	Name       string  `json:"name"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// StartupStatus is the body of GET /debug/startup.
+type StartupStatus struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	DurationMS float64        `json:"duration_ms,omitempty"`
+	Done       bool           `json:"done"`
+	Steps      []WarmupResult `json:"steps"`
+}
+
+// warmupState records the warm-up run; /readyz reports 503 warming_up until
+// it is done.
+type warmupState struct {
+	mu     sync.Mutex
+	status StartupStatus
+	done   chan struct{}
+}
+
+func newWarmupState() *warmupState {
+	return &warmupState{done: make(chan struct{}), status: StartupStatus{Steps: []WarmupResult{}}}
+}
+
+// Done reports whether warm-up has finished.
+func (w *warmupState) Done() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status returns a copy of the warm-up record.
+func (w *warmupState) Status() StartupStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := w.status
+	status.Steps = append([]WarmupResult{}, w.status.Steps...)
+	return status
+}
+
+// warmupSteps lists what to warm for this configuration.
+func (s *Server) warmupSteps() []warmupStep {
+	steps := []warmupStep{
+		// Dependency checks dial the database and Redis, opening the first
+		// pooled connections.
+		{"dependencies", func(ctx context.Context) error {
+			statuses, healthy := s.checks.Run(ctx, s.cfg.WarmupTimeout)
+			if !healthy {
+				return fmt.Errorf("checks failed: %v", statuses)
+			}
+			return nil
+		}},
+		{"ui_template", func(ctx context.Context) error {
+			return s.ui.Execute(new(bytes.Buffer), uiPage{Build: s.build, Instance: s.instance, Now: s.clock.Now()})
+		}},
+		// A request through the router primes its handlers, the encoders,
+		// and their pools.
+		{"self_request", func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/version", nil)
+			if err != nil {
+				return err
+			}
+			rec := &batchRecorder{header: make(http.Header)}
+			s.api.ServeHTTP(rec, req)
+			if rec.status != http.StatusOK {
+				return fmt.Errorf("GET /version returned %d", rec.status)
+			}
+			return nil
+		}},
+	}
+	if s.verifier != nil && s.verifier.jwks != nil {
+		steps = append(steps, warmupStep{"jwks", s.verifier.jwks.Refresh})
+	}
+	return steps
+}
+
+// warmUp runs every warm-up step concurrently, bounded by WARMUP_TIMEOUT,
+// and logs how each went; a zero timeout skips them. Failures are logged
+// but don't hold readiness back: /readyz still runs the dependency checks
+// on its own.
+func (s *Server) warmUp(ctx context.Context) {
+	w := s.warmup
+	defer close(w.done)
+	start := time.Now()
+	w.mu.Lock()
+	w.status.StartedAt = start.UTC()
+	w.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.WarmupTimeout)
+	defer cancel()
+	var steps []warmupStep
+	if s.cfg.WarmupTimeout > 0 {
+		steps = s.warmupSteps()
+	}
+	results := make([]WarmupResult, len(steps))
+	var wg sync.WaitGroup
+	for i, step := range steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stepStart := time.Now()
+			err := step.run(ctx)
+			if err == nil && ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			results[i] = WarmupResult{Name: step.name, DurationMS: durationMS(time.Since(stepStart))}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	finished := time.Now().UTC()
+	w.mu.Lock()
+	w.status.Steps = results
+	w.status.FinishedAt = &finished
+	w.status.DurationMS = durationMS(elapsed)
+	w.status.Done = true
+	w.mu.Unlock()
+	for _, res := range results {
+		if res.Error != "" {
+			s.logger.Warn("warm-up step failed", slog.String("step", res.Name), slog.Float64("duration_ms", res.DurationMS), slog.String("error", res.Error))
+			continue
+		}
+		s.logger.Debug("warm-up step done", slog.String("step", res.Name), slog.Float64("duration_ms", res.DurationMS))
+	}
+	s.logger.Info("warm-up finished", slog.Float64("duration_ms", durationMS(elapsed)), slog.Int("steps", len(results)))
+}
+
+// startupHandler serves GET /debug/startup.
+func (s *Server) startupHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, s.warmup.Status())
+}
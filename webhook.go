@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Item change event types sent to webhooks.
+const (
+	webhookItemCreated  = "item.created"
+	webhookItemUpdated  = "item.updated"
+	webhookItemDeleted  = "item.deleted"
+	webhookItemRestored = "item.restored"
+)
+
+// Webhook delivery headers. The signature is "sha256=" and the hex
+// HMAC-SHA256 of the body keyed by the webhook's secret.
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const (
+	// webhookWorkers is how many deliveries run at once.
+	webhookWorkers = 4
+	// webhookQueueSize bounds the deliveries waiting for a worker; events
+	// beyond it are dropped and recorded as failed.
+	webhookQueueSize = 1000
+	// webhookHistory is how many delivery attempts are kept per webhook.
+	webhookHistory = 50
+)
+
+// Webhook is a registered callback. The secret is never returned. It only
+// hears of its tenant's items, and only that tenant sees it.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	HasSecret bool      `json:"has_secret"`
+	CreatedAt time.Time `json:"created_at"`
+	Tenant    string    `json:"tenant,omitempty"`
+}
+
+// WebhookRequest is the body of POST /webhooks.
+type WebhookRequest struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// Validate requires an absolute http or https URL.
+func (req *WebhookRequest) Validate() []FieldError {
+	target, err := url.Parse(req.URL)
+	switch {
+	case req.URL == "":
+		return []FieldError{{Field: "url", Constraint: "required", Message: "is required"}}
+	case err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "":
+		return []FieldError{{Field: "url", Constraint: "format", Message: "must be an absolute http or https URL", Value: req.URL}}
+	}
+	return nil
+}
+
+// WebhookEvent is the body POSTed to every webhook when an item changes.
+// Item is the full item, or just its ID for deletions.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Item      any       `json:"item"`
+	Timestamp time.Time `json:"timestamp"`
+	Tenant    string    `json:"tenant,omitempty"`
+}
+
+// WebhookDelivery records one delivery attempt.
+type WebhookDelivery struct {
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Succeeded  bool      `json:"succeeded"`
+	DurationMS float64   `json:"duration_ms"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// webhookSubscription is a webhook with its secret and recent deliveries.
+type webhookSubscription struct {
+	Webhook
+	secret     string
+	deliveries []WebhookDelivery
+}
+
+// webhookJob is one event on its way to one webhook.
+type webhookJob struct {
+	hookID string
+	url    string
+	secret string
+	event  WebhookEvent
+	body   []byte
+}
+
+// webhookDispatcher keeps the registered webhooks and delivers events to
+// them from a pool of background workers. Each attempt is bounded by
+// timeout; failures are retried up to maxAttempts times, waiting backoff,
+// then twice as long, and so on.
+type webhookDispatcher struct {
+	client      *http.Client
+	timeout     time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	now         func() time.Time
+	logger      *slog.Logger
+
+	mu    sync.Mutex
+	hooks map[string]*webhookSubscription
+	// pending counts deliveries queued or in progress.
+	pending int
+	closed  bool
+
+	queue chan webhookJob
+	// ctx is cancelled when a shutdown runs out of time, abandoning
+	// in-flight deliveries and retries.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWebhookDispatcher(hc httpClientConfig, timeout time.Duration, maxAttempts int, backoff time.Duration, now func() time.Time, logger *slog.Logger) *webhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	// Deliveries are POSTs, which the client never retries; failed ones
+	// are retried below, with their own backoff and record of attempts.
+	client := newHTTPClient(hc, 0)
+	// A redirect is reported as the delivery's status rather than followed
+	// to a target nobody registered.
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	d := &webhookDispatcher{
+		client:      client,
+		timeout:     timeout,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		now:         now,
+		logger:      logger,
+		hooks:       make(map[string]*webhookSubscription),
+		queue:       make(chan webhookJob, webhookQueueSize),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	for range webhookWorkers {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for job := range d.queue {
+				d.deliver(job)
+				d.mu.Lock()
+				d.pending--
+				d.mu.Unlock()
+			}
+		}()
+	}
+	return d
+}
+
+// Register adds a webhook.
+func (d *webhookDispatcher) Register(tenant, target, secret string) Webhook {
+	hook := &webhookSubscription{
+		Webhook: Webhook{ID: newUUID(), URL: target, HasSecret: secret != "", CreatedAt: d.now().UTC(), Tenant: tenant},
+		secret:  secret,
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[hook.ID] = hook
+	return hook.Webhook
+}
+
+// List returns tenant's webhooks oldest first.
+func (d *webhookDispatcher) List(tenant string) []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hooks := make([]Webhook, 0, len(d.hooks))
+	for _, hook := range d.hooks {
+		if hook.Tenant == tenant {
+			hooks = append(hooks, hook.Webhook)
+		}
+	}
+	slices.SortFunc(hooks, func(a, b Webhook) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return hooks
+}
+
+// Remove deletes one of tenant's webhooks, reporting whether it existed.
+// Queued deliveries to it are dropped.
+func (d *webhookDispatcher) Remove(tenant, id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hook, ok := d.hooks[id]
+	if !ok || hook.Tenant != tenant {
+		return false
+	}
+	delete(d.hooks, id)
+	return true
+}
+
+// Deliveries returns the recent delivery attempts of one of tenant's
+// webhooks, newest first.
+func (d *webhookDispatcher) Deliveries(tenant, id string) ([]WebhookDelivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hook, ok := d.hooks[id]
+	if !ok || hook.Tenant != tenant {
+		return nil, false
+	}
+	deliveries := slices.Clone(hook.deliveries)
+	slices.Reverse(deliveries)
+	return deliveries, true
+}
+
+// Publish queues event for every webhook of its tenant without waiting for
+// delivery.
+func (d *webhookDispatcher) Publish(event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("cannot encode webhook event", slog.String("event_type", event.Type), slog.Any("error", err))
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	for _, hook := range d.hooks {
+		if hook.Tenant != event.Tenant {
+			continue
+		}
+		job := webhookJob{hookID: hook.ID, url: hook.URL, secret: hook.secret, event: event, body: body}
+		select {
+		case d.queue <- job:
+			d.pending++
+		default:
+			d.logger.Warn("webhook queue full, dropping event", slog.String("webhook_id", hook.ID), slog.String("event_type", event.Type))
+			d.recordLocked(job, WebhookDelivery{Error: "delivery queue full"})
+		}
+	}
+}
+
+// deliver POSTs job until an attempt gets a 2xx, attempts run out, or the
+// dispatcher is cancelled.
+func (d *webhookDispatcher) deliver(job webhookJob) {
+	wait := d.backoff
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if !d.active(job.hookID) {
+			return
+		}
+		delivery := d.attempt(job)
+		delivery.Attempt = attempt
+		d.record(job, delivery)
+		if delivery.Succeeded {
+			return
+		}
+		d.logger.Warn("webhook delivery failed",
+			slog.String("webhook_id", job.hookID),
+			slog.String("event_id", job.event.ID),
+			slog.Int("attempt", attempt),
+			slog.Int("status", delivery.StatusCode),
+			slog.String("error", delivery.Error),
+		)
+		if attempt == d.maxAttempts {
+			return
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// attempt makes one delivery request.
+func (d *webhookDispatcher) attempt(job webhookJob) WebhookDelivery {
+	ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+	defer cancel()
+	start := time.Now()
+	delivery := WebhookDelivery{Timestamp: d.now().UTC()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.url, bytes.NewReader(job.body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	req.Header.Set("User-Agent", "my-go-app (webhook)")
+	req.Header.Set(webhookEventHeader, job.event.Type)
+	req.Header.Set(webhookDeliveryHeader, job.event.ID)
+	if job.secret != "" {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(job.secret, job.body))
+	}
+
+	resp, err := d.client.Do(req)
+	delivery.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		delivery.Error = err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			delivery.Error = fmt.Sprintf("timed out after %s", d.timeout)
+		}
+		return delivery
+	}
+	// Drain a little so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	delivery.Succeeded = resp.StatusCode >= 200 && resp.StatusCode < 300
+	return delivery
+}
+
+// webhookSignature returns the X-Webhook-Signature value for body.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *webhookDispatcher) active(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.hooks[id]
+	return ok
+}
+
+func (d *webhookDispatcher) record(job webhookJob, delivery WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recordLocked(job, delivery)
+}
+
+func (d *webhookDispatcher) recordLocked(job webhookJob, delivery WebhookDelivery) {
+	hook, ok := d.hooks[job.hookID]
+	if !ok {
+		return
+	}
+	delivery.EventID = job.event.ID
+	delivery.EventType = job.event.Type
+	if delivery.Timestamp.IsZero() {
+		delivery.Timestamp = d.now().UTC()
+	}
+	hook.deliveries = append(hook.deliveries, delivery)
+	if over := len(hook.deliveries) - webhookHistory; over > 0 {
+		hook.deliveries = slices.Delete(hook.deliveries, 0, over)
+	}
+}
+
+// Shutdown stops accepting events and waits for queued deliveries,
+// including their retries, to finish. When ctx ends first, outstanding
+// deliveries are cancelled and ctx's error is returned.
+func (d *webhookDispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	idle := d.pending == 0
+	d.mu.Unlock()
+	if idle {
+		d.cancel()
+		d.wg.Wait()
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// itemChanged notifies webhooks and the broker of an item change. Changes
+// made inside an atomic batch are held until the batch commits.
+func (s *Server) itemChanged(r *http.Request, eventType string, item any) {
+	if bc, ok := r.Context().Value(batchKey{}).(batchContext); ok && bc.pending != nil {
+		*bc.pending = append(*bc.pending, WebhookEvent{Type: eventType, Item: item})
+		return
+	}
+	s.publishChange(r.Context(), eventType, item)
+}
+
+// publishChange sends one item change event, under one ID, to the webhooks
+// and the broker.
+func (s *Server) publishChange(ctx context.Context, eventType string, item any) {
+	event := WebhookEvent{ID: newUUID(), Type: eventType, Item: item, Timestamp: s.clock.Now().UTC(), Tenant: tenantFrom(ctx)}
+	s.webhooks.Publish(event)
+	s.broker.Publish(ctx, event)
+	recordAuditChange(ctx, eventType, item)
+}
+
+// deletedItem is the item in an item.deleted event. Purged is set when it
+// was deleted for good rather than soft-deleted.
+type deletedItem struct {
+	ID     string `json:"id"`
+	Purged bool   `json:"purged,omitempty"`
+}
+
+// webhooksHandler handles GET and POST /webhooks.
+func (s *Server) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeResponse(w, r, http.StatusOK, s.webhooks.List(tenantFrom(r.Context())))
+	case http.MethodPost:
+		var req WebhookRequest
+		if !decodeValid(w, r, &req) {
+			return
+		}
+		target, _ := url.Parse(req.URL)
+		if !s.cfg.WebhookAllowedHosts.Allows(target) {
+			writeError(w, r, http.StatusForbidden, codeForbidden, "Target not allowed", target.Host+" is not in WEBHOOK_ALLOWED_HOSTS")
+			return
+		}
+		hook := s.webhooks.Register(tenantFrom(r.Context()), req.URL, req.Secret)
+		w.Header().Set("Location", "/webhooks/"+hook.ID)
+		writeResponse(w, r, http.StatusCreated, hook)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
+
+// deleteWebhookHandler handles DELETE /webhooks/{id}.
+func (s *Server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.webhooks.Remove(tenantFrom(r.Context()), r.PathValue("id")) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Webhook not found", "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookDeliveriesHandler handles GET /webhooks/{id}/deliveries.
+func (s *Server) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	deliveries, ok := s.webhooks.Deliveries(tenantFrom(r.Context()), r.PathValue("id"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Webhook not found", "")
+		return
+	}
+	writeResponse(w, r, http.StatusOK, deliveries)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWebhookRegistrationRespectsAllowlist(t *testing.T) {
+	_, handler := newTestServer(t, func(cfg *Config) {
+		cfg.WebhookAllowedHosts = mustAllowlist(t, "https://hooks.example.com", "*.corp.example")
+	})
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"https://hooks.example.com/items", 201},
+		{"https://hooks.example.com@evil.net/items", 403},
+		{"https://hooks.example.com.evil.net/items", 403},
+		{"https://ci.corp.example/hook", 201},
+		{"https://evilcorp.example/hook", 403},
+	}
+	for _, tt := range tests {
+		rec := serve(handler, newRequest(t, "POST", "/webhooks", `{"url": "`+tt.url+`"}`))
+		if rec.Code != tt.want {
+			t.Errorf("POST /webhooks %s = %d %s, want %d", tt.url, rec.Code, rec.Body, tt.want)
+		}
+	}
+	rec := serve(handler, newRequest(t, "GET", "/webhooks", ""))
+	if body := rec.Body.String(); strings.Contains(body, "evil") {
+		t.Errorf("GET /webhooks lists a refused target: %s", body)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsPongWait is how long a client may stay silent, pongs included,
+	// before its connection is reaped.
+	wsPongWait = 60 * time.Second
+	// wsPingInterval must be shorter than wsPongWait so a live client always
+	// has a ping to answer.
+	wsPingInterval = wsPongWait * 9 / 10
+	// wsWriteWait bounds each frame write, including close frames.
+	wsWriteWait = 10 * time.Second
+)
+
+// wsHub serves the /ws echo endpoint and tracks open connections so they can
+// be closed with a going-away frame on shutdown. Hijacked connections are
+// invisible to http.Server.Shutdown, so this has to be done by hand.
+type wsHub struct {
+	upgrader        websocket.Upgrader
+	maxMessageBytes int64
+	slots           chan struct{}
+
+	mu      sync.Mutex
+	conns   map[*websocket.Conn]struct{}
+	closing bool
+}
+
+// newWSHub limits the hub to maxConns concurrent connections and
+// maxMessageBytes per message. Cross-origin upgrades are accepted only from
+// origins allowed by the CORS policy; same-origin upgrades always are.
+func newWSHub(maxConns int, maxMessageBytes int64, cors *corsPolicy) *wsHub {
+	h := &wsHub{
+		maxMessageBytes: maxMessageBytes,
+		slots:           make(chan struct{}, maxConns),
+		conns:           make(map[*websocket.Conn]struct{}),
+	}
+	h.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if allowed, _ := cors.allows(origin); origin == "" || allowed {
+			return true
+		}
+		return sameOrigin(r)
+	}
+	return h
+}
+
+// sameOrigin reports whether the Origin header's host matches the request
+// Host, the same check the upgrader applies by default.
+func sameOrigin(r *http.Request) bool {
+	u, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// ServeHTTP upgrades the request and echoes every text and binary message
+// back until the client goes away.
+func (h *wsHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	default:
+		writeRetryableError(w, r, http.StatusServiceUnavailable, retryAfterDefault, codeUnavailable, "Too many WebSocket connections", "")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an error status.
+		return
+	}
+	if !h.add(conn) {
+		closeWebSocket(conn, websocket.CloseGoingAway, "server shutting down")
+		conn.Close()
+		return
+	}
+	defer h.remove(conn)
+	wsConnectedClients.Inc()
+	defer wsConnectedClients.Dec()
+
+	conn.SetReadLimit(h.maxMessageBytes)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go pingWebSocket(conn, done)
+
+	for {
+		messageType, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) &&
+				!errors.Is(err, websocket.ErrReadLimit) {
+				loggerFrom(r.Context()).Warn("websocket read ended", slog.String("remote_addr", r.RemoteAddr), slog.Any("error", err))
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteMessage(messageType, msg); err != nil {
+			return
+		}
+	}
+}
+
+// pingWebSocket pings conn every wsPingInterval until done is closed. A
+// client that stops answering hits its read deadline and is dropped.
+func pingWebSocket(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Count reports the open connections.
+func (h *wsHub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+func (h *wsHub) add(conn *websocket.Conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		return false
+	}
+	h.conns[conn] = struct{}{}
+	return true
+}
+
+func (h *wsHub) remove(conn *websocket.Conn) {
+	h.mu.Lock()
+	delete(h.conns, conn)
+	h.mu.Unlock()
+	conn.Close()
+}
+
+// Shutdown sends a going-away close frame to every open connection, stops
+// accepting new ones, and waits for the read loops to finish or ctx to end.
+func (h *wsHub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	for conn := range h.conns {
+		closeWebSocket(conn, websocket.CloseGoingAway, "server shutting down")
+		conn.SetReadDeadline(time.Now().Add(wsWriteWait))
+	}
+	h.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		h.mu.Lock()
+		open := len(h.conns)
+		h.mu.Unlock()
+		if open == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func closeWebSocket(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// dialWS opens a WebSocket to path on ts.
+func dialWS(t *testing.T, ts *httptest.Server, path string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, nil)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv, h := newTestServer(t)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	before := testutil.ToFloat64(wsConnectedClients)
+	conn, _, err := dialWS(t, ts, "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, msg := range []struct {
+		kind int
+		data string
+	}{{websocket.TextMessage, "hello"}, {websocket.BinaryMessage, "\x00\x01\x02"}} {
+		if err := conn.WriteMessage(msg.kind, []byte(msg.data)); err != nil {
+			t.Fatal(err)
+		}
+		kind, data, err := conn.ReadMessage()
+		if err != nil || kind != msg.kind || string(data) != msg.data {
+			t.Errorf("echo = %d %q %v, want %d %q", kind, data, err, msg.kind, msg.data)
+		}
+	}
+	if got := testutil.ToFloat64(wsConnectedClients); got != before+1 || srv.ws.Count() != 1 {
+		t.Errorf("connected clients gauge = %v, hub count %d, want %v and 1", got, srv.ws.Count(), before+1)
+	}
+
+	// Shutdown says goodbye with a going-away close frame.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go srv.ws.Shutdown(ctx)
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("read during shutdown = %v, want a going-away close", err)
+	}
+}
+
+func TestWebSocketLimits(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) {
+		cfg.WSMaxConnections = 1
+		cfg.WSMaxMessageBytes = 16
+	})
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	conn, _, err := dialWS(t, ts, "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, resp, err := dialWS(t, ts, "/ws"); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second connection over WS_MAX_CONNECTIONS = %v, want a 503", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 17)))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("read after an oversized message = %v, want a message-too-big close", err)
+	}
+}